@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"database/sql"
+	"os"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// checkUniversalApk renders 404 for the download of an Android App Bundle whose universal APK is not built,
+// as an aab cannot be installed as it is.
+func (c *AlphaWingController) checkUniversalApk(bundle *models.Bundle) revel.Result {
+	if bundle.InstallFileId() != "" {
+		return nil
+	}
+	if Conf.Bundletool == nil {
+		return c.NotFound("The universal APK is not built, as bundletool.path is not configured.")
+	}
+	return c.NotFound(models.ErrUniversalApkNotBuilt.Error())
+}
+
+// prepareAab records the build of the universal APK of the bundle and builds it in the background, and then runs it
+// on the device farms, which install the apk. UniversalApkBuildJob builds it again if it fails or the server stops.
+func prepareAab(storage models.Storage, app *models.App, bundle *models.Bundle) {
+	if Conf.Bundletool == nil {
+		return
+	}
+	build := &models.UniversalApkBuild{
+		BundleId: bundle.Id,
+		State:    models.UniversalApkBuildStateBuilding,
+	}
+	if err := Transact(build.Save); err != nil {
+		revel.ERROR.Printf("failed to build the universal APK of bundle %d: %s", bundle.Id, err)
+		return
+	}
+	go runUniversalApkBuild(storage, app, bundle, build)
+}
+
+// runUniversalApkBuild builds the universal APK, deleting the build once it is attached, or recording the failure.
+func runUniversalApkBuild(storage models.Storage, app *models.App, bundle *models.Bundle, build *models.UniversalApkBuild) {
+	stop := touchWhileUploading(build.Touch)
+	err := buildUniversalApk(storage, app, bundle)
+	stop()
+	if err != nil {
+		revel.ERROR.Printf("failed to build the universal APK of bundle %d: %s", bundle.Id, err)
+		if err := Transact(func(txn gorp.SqlExecutor) error {
+			return build.Fail(txn, err)
+		}); err != nil {
+			revel.ERROR.Printf("failed to record the build of the universal APK of bundle %d: %s", bundle.Id, err)
+		}
+		return
+	}
+	if err := Transact(build.Delete); err != nil {
+		revel.ERROR.Printf("failed to record the build of the universal APK of bundle %d: %s", bundle.Id, err)
+	}
+	runOnDeviceFarms(storage, app, bundle)
+}
+
+// resumeUniversalApkBuilds builds again the universal APKs left by a server stopped or failed to be retried.
+func resumeUniversalApkBuilds() error {
+	if Conf.Bundletool == nil {
+		return nil
+	}
+	builds, err := models.ClaimStaleUniversalApkBuilds(Dbm, time.Now())
+	if err != nil || len(builds) == 0 {
+		return err
+	}
+	storage, err := backgroundStorage()
+	if err != nil {
+		return err
+	}
+
+	for _, build := range builds {
+		bundle, err := models.GetBundle(Dbm, build.BundleId)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		// the bundle is deleted with its builds, unless it is deleted while this one is read
+		if err == sql.ErrNoRows {
+			continue
+		}
+		// the apk attached by a server stopped before it deleted the build is not built again
+		if bundle.UniversalApkFileId != "" {
+			if err := Transact(build.Delete); err != nil {
+				return err
+			}
+			continue
+		}
+		app, err := models.GetApp(Dbm, bundle.AppId)
+		if err != nil {
+			return err
+		}
+		revel.INFO.Printf("resuming the build of the universal APK of bundle %d", bundle.Id)
+		go runUniversalApkBuild(storage, app, bundle, build)
+	}
+	return nil
+}
+
+func buildUniversalApk(storage models.Storage, app *models.App, bundle *models.Bundle) error {
+	// the uploaded temporary file is removed after the request, so read it back from the storage
+	object, err := storage.Get(bundle.FileId)
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	file, err := Conf.Bundletool.BuildUniversalApk(object.Body)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	return app.AttachUniversalApk(Dbm, storage, bundle, file)
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// renderRequestAccess renders the forbidden page which lets the user request access to the app.
+func (c *AlphaWingController) renderRequestAccess(app *models.App) revel.Result {
+	if app.IsPrivate() {
+		return c.NotFound("App is not found.")
+	}
+
+	accessRequest, err := models.GetPendingAccessRequest(Dbm, app.Id, c.LoginUserId)
+	if err != nil {
+		panic(err)
+	}
+
+	c.InitRenderArgs()
+	c.RenderArgs["app"] = app
+	c.RenderArgs["accessRequest"] = accessRequest
+	c.Response.Status = http.StatusForbidden
+	return c.RenderTemplate("AppController/GetRequestAccess.html")
+}
+
+// notifyAccessRequest mails the members of the app and posts to Slack, so that they can approve or deny the request.
+func (c *AlphaWingController) notifyAccessRequest(app *models.App, accessRequest *models.AccessRequest) {
+	appUrl, err := c.UriFor(fmt.Sprintf("app/%d", app.Id))
+	if err != nil {
+		revel.ERROR.Printf("failed to notify access request %d: %s", accessRequest.Id, err)
+		return
+	}
+
+	go postSlack(fmt.Sprintf("%s が %s へのアクセスを申請しています。\n%s", accessRequest.Email, app.Title, appUrl))
+
+	if Conf.Mailer == nil {
+		return
+	}
+
+	authorities, err := app.Authorities(Dbm)
+	if err != nil {
+		revel.ERROR.Printf("failed to notify access request %d: %s", accessRequest.Id, err)
+		return
+	}
+	var to []string
+	for _, authority := range authorities {
+		to = append(to, authority.Email)
+	}
+
+	subject := fmt.Sprintf("[alphawing] %s がアクセスを申請しています: %s", accessRequest.Email, app.Title)
+	body := fmt.Sprintf("%s が %s へのアクセスを申請しています。\n以下のページから承認または却下してください。\n\n%s\n", accessRequest.Email, app.Title, appUrl)
+	go sendMail(to, subject, body)
+}
+
+// notifyAccessRequestResult mails the requester whether the request is approved.
+func (c *AlphaWingController) notifyAccessRequestResult(app *models.App, accessRequest *models.AccessRequest) {
+	if Conf.Mailer == nil {
+		return
+	}
+
+	appUrl, err := c.UriFor(fmt.Sprintf("app/%d", app.Id))
+	if err != nil {
+		revel.ERROR.Printf("failed to notify access request %d: %s", accessRequest.Id, err)
+		return
+	}
+
+	var subject, body string
+	if accessRequest.State == models.AccessRequestStateApproved {
+		subject = fmt.Sprintf("[alphawing] アクセスが承認されました: %s", app.Title)
+		body = fmt.Sprintf("%s へのアクセスが承認されました。\n\n%s\n", app.Title, appUrl)
+	} else {
+		subject = fmt.Sprintf("[alphawing] アクセスが却下されました: %s", app.Title)
+		body = fmt.Sprintf("%s へのアクセスが却下されました。\n", app.Title)
+	}
+	go sendMail([]string{accessRequest.Email}, subject, body)
+}
+
+func sendMail(to []string, subject, body string) {
+	if err := Conf.Mailer.Send(to, subject, body); err != nil {
+		revel.ERROR.Printf("failed to send mail to %v: %s", to, err)
+	}
+}
+
+// postSlack posts to the Slack channel in the settings, if any.
+func postSlack(text string) {
+	settings, err := currentSettings()
+	if err != nil {
+		revel.ERROR.Printf("failed to post to slack: %s", err)
+		return
+	}
+	webhookUrl := settings[models.SettingSlackWebhookUrl]
+	if webhookUrl == "" {
+		return
+	}
+	if err := deliverWebhook(0, models.WebhookDeliveryKindSlack, models.WebhookEventNotification, webhookUrl, models.SlackMessage(text)); err != nil {
+		revel.ERROR.Printf("failed to post to slack: %s", err)
+	}
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/kayac/alphawing/app/models"
+	"github.com/kayac/alphawing/app/routes"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// AccountController lets the login user export or erase the personal data.
+type AccountController struct {
+	AuthController
+}
+
+func (c AccountController) GetAccount() revel.Result {
+	user, err := models.GetUser(Dbm, c.LoginUserId)
+	if err != nil {
+		panic(err)
+	}
+	languages := Conf.ChangelogLanguages
+	return c.Render(user, languages)
+}
+
+// PostLanguage sets the language of the changelogs shown and mailed to the user, or the one of the browser if empty.
+func (c AccountController) PostLanguage(language string) revel.Result {
+	user, err := models.GetUser(Dbm, c.LoginUserId)
+	if err != nil {
+		panic(err)
+	}
+
+	valid := language == ""
+	for _, l := range Conf.ChangelogLanguages {
+		valid = valid || l == language
+	}
+	c.Validation.Required(valid).Message("Language is invalid.")
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		return c.Redirect(routes.AccountController.GetAccount())
+	}
+
+	user.Language = language
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return user.Update(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Updated!")
+	return c.Redirect(routes.AccountController.GetAccount())
+}
+
+func (c AccountController) GetExportData() revel.Result {
+	user, err := models.GetUser(Dbm, c.LoginUserId)
+	if err != nil {
+		panic(err)
+	}
+
+	export, err := models.ExportUserData(Dbm, user)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Response.Out.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"alphawing_%d.json\"", user.Id))
+	return c.RenderJson(export)
+}
+
+func (c AccountController) PostEraseAccount(email string) revel.Result {
+	user, err := models.GetUser(Dbm, c.LoginUserId)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Validation.Required(email == user.Email).Message("Email does not match.")
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		return c.Redirect(routes.AccountController.GetAccount())
+	}
+
+	var report *models.UserErasureReport
+	var succeeded []*models.App
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		r, err := models.EraseUserData(txn, c.GoogleService, user)
+		if err != nil {
+			return err
+		}
+		report = r
+		succeeded, err = succeedApps(txn, c.GoogleService, report.OwnedApps)
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+	notifySuccession(succeeded)
+
+	c.logout()
+	c.Flash.Success(fmt.Sprintf("Erased! (%d authorities, %d access requests, %d devices removed and %d audits anonymized)", report.Authorities, report.AccessRequests, report.PairedDevices, report.AnonymizedAudits))
+	return c.Redirect(routes.AlphaWingController.Index())
+}
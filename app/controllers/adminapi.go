@@ -0,0 +1,575 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// AdminApiController manages the apps with the admin token, so that a Terraform provider can treat them as resources.
+type AdminApiController struct {
+	ApiController
+}
+
+type JsonResponseAdminApp struct {
+	*JsonResponse
+	Content *AdminAppJsonResponse `json:"content"`
+}
+
+type JsonResponseAdminApps struct {
+	*JsonResponse
+	Content    []*AdminAppJsonResponse `json:"content"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+}
+
+type JsonResponseInstallHistory struct {
+	*JsonResponse
+	Content    []*models.InstallRecordJsonResponse `json:"content"`
+	NextCursor string                              `json:"next_cursor,omitempty"`
+}
+
+type JsonResponseExportUser struct {
+	*JsonResponse
+	Content *models.UserDataExport `json:"content"`
+}
+
+type JsonResponseEraseUser struct {
+	*JsonResponse
+	Content *models.UserErasureReport `json:"content"`
+}
+
+type JsonResponseBandwidth struct {
+	*JsonResponse
+	Content []*models.BandwidthUsageJsonResponse `json:"content"`
+}
+
+type JsonResponseEvents struct {
+	*JsonResponse
+	Content *EventsJsonResponse `json:"content"`
+}
+
+type JsonResponseSettings struct {
+	*JsonResponse
+	Content []*models.SettingJsonResponse `json:"content"`
+}
+
+type EventsJsonResponse struct {
+	Cursor int                         `json:"cursor"`
+	Events []*models.EventJsonResponse `json:"events"`
+}
+
+type AdminAppJsonResponse struct {
+	Id                   int    `json:"id"`
+	Title                string `json:"title"`
+	Description          string `json:"description"`
+	DescriptionTemplate  string `json:"description_template"`
+	Visibility           string `json:"visibility"`
+	Category             string `json:"category"`
+	FirebaseAndroidAppId string `json:"firebase_android_app_id"`
+	FirebaseIosAppId     string `json:"firebase_ios_app_id"`
+	StorageLocation      string `json:"storage_location"`
+	RequireSameSigner    bool   `json:"require_same_signer"`
+	StorageQuotaMb       int    `json:"storage_quota_mb"`
+	ApiToken             string `json:"api_token"`
+	CreatedAt            string `json:"created_at"`
+	UpdatedAt            string `json:"updated_at"`
+}
+
+func (c AdminApiController) NewJsonResponseAdminApp(stat int, mes []string, app *models.App) *JsonResponseAdminApp {
+	var content *AdminAppJsonResponse
+	if app != nil {
+		content = adminAppJsonResponse(app)
+	}
+	return &JsonResponseAdminApp{
+		c.NewJsonResponse(stat, mes),
+		content,
+	}
+}
+
+func adminAppJsonResponse(app *models.App) *AdminAppJsonResponse {
+	return &AdminAppJsonResponse{
+		Id:                   app.Id,
+		Title:                app.Title,
+		Description:          app.Description,
+		DescriptionTemplate:  app.DescriptionTemplate,
+		Visibility:           app.Visibility.String(),
+		Category:             app.Category,
+		FirebaseAndroidAppId: app.FirebaseAndroidAppId,
+		FirebaseIosAppId:     app.FirebaseIosAppId,
+		StorageLocation:      app.StorageLocation,
+		RequireSameSigner:    app.RequireSameSigner,
+		StorageQuotaMb:       app.StorageQuotaMb,
+		ApiToken:             app.ApiToken,
+		CreatedAt:            app.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:            app.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// GetApps lists every app, or a page of them after the cursor with limit or cursor.
+func (c AdminApiController) GetApps(limit int, cursor string) revel.Result {
+	after, err := models.DecodeCursor(cursor)
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	var apps []*models.App
+	var next int
+	if limit == 0 && cursor == "" {
+		apps, err = models.GetAllApps(Dbm)
+	} else {
+		apps, next, err = models.GetAppsWithCursor(Dbm, after, models.CursorLimit(limit, Conf.PagerDefaultLimit))
+	}
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	content := []*AdminAppJsonResponse{}
+	for _, app := range apps {
+		content = append(content, adminAppJsonResponse(app))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseAdminApps{c.NewJsonResponse(c.Response.Status, []string{"App List"}), content, models.EncodeCursor(next)})
+}
+
+func (c AdminApiController) GetApp(appId int) revel.Result {
+	app, result := c.findApp(appId)
+	if result != nil {
+		return result
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(c.NewJsonResponseAdminApp(c.Response.Status, []string{"App"}, app))
+}
+
+func (c AdminApiController) PostCreateApp() revel.Result {
+	if result := c.checkIdempotencyKey("admin"); result != nil {
+		return result
+	}
+	app := &models.App{
+		StorageLocation: c.Params.Get("storage_location"),
+	}
+	folderId, ok := storageFolderId(app.StorageLocation)
+	if !ok {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"storage_location is invalid."}))
+	}
+	if result := c.bindApp(app); result != nil {
+		return result
+	}
+
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		return models.CreateApp(txn, c.GoogleService, app, folderId)
+	})
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponseAdminApp(c.Response.Status, []string{err.Error()}, nil))
+	}
+
+	c.Response.Status = http.StatusCreated
+	return c.RenderJson(c.NewJsonResponseAdminApp(c.Response.Status, []string{"App is created!"}, app))
+}
+
+// PutUpdateApp replaces every setting of the app, so sending the same parameters again changes nothing.
+func (c AdminApiController) PutUpdateApp(appId int) revel.Result {
+	app, result := c.findApp(appId)
+	if result != nil {
+		return result
+	}
+	// moving the files across the locations would break the residency while in progress
+	if storageLocation, ok := c.Params.Values["storage_location"]; ok && storageLocation[0] != app.StorageLocation {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"storage_location cannot be changed."}))
+	}
+	if result := c.bindApp(app); result != nil {
+		return result
+	}
+
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		if err := app.Update(txn); err != nil {
+			return err
+		}
+		return app.UpdateStorageQuota(txn, app.StorageQuotaMb)
+	})
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponseAdminApp(c.Response.Status, []string{err.Error()}, nil))
+	}
+
+	app, result = c.findApp(appId)
+	if result != nil {
+		return result
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(c.NewJsonResponseAdminApp(c.Response.Status, []string{"App is updated!"}, app))
+}
+
+func (c AdminApiController) DeleteApp(appId int) revel.Result {
+	if result := c.checkIdempotencyKey("admin"); result != nil {
+		return result
+	}
+	app, result := c.findApp(appId)
+	if result != nil {
+		return result
+	}
+
+	err := models.TransactDeleting(Dbm, c.Storage, Conf.BundleArchive, func(txn gorp.SqlExecutor, storage models.Storage) error {
+		return app.Delete(txn, c.GoogleService, storage)
+	})
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"App is deleted!"}))
+}
+
+// PostSyncAuthorities replaces the members of the app with the document. It takes the admin token instead of the API token
+// of the app, which the CI uploading the bundles holds, and does not grant the admin role, which the admins grant on the app page.
+func (c AdminApiController) PostSyncAuthorities(appId int, document string, dry_run bool) revel.Result {
+	if result := c.checkIdempotencyKey("admin"); result != nil {
+		return result
+	}
+	app, result := c.findApp(appId)
+	if result != nil {
+		return result
+	}
+
+	c.Validation.Required(document).Message("document is required.")
+	if c.Validation.HasErrors() {
+		var errors []string
+		for _, err := range c.Validation.Errors {
+			errors = append(errors, err.String())
+		}
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseSyncAuthorities(c.Response.Status, errors, nil))
+	}
+
+	doc, err := models.ParseAuthorityDocument([]byte(document))
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseSyncAuthorities(c.Response.Status, []string{err.Error()}, nil))
+	}
+
+	// the document cannot leave the app without an owner, unless it has never had one
+	owners, err := app.ActiveOwners(Dbm)
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponseSyncAuthorities(c.Response.Status, []string{err.Error()}, nil))
+	}
+	if len(owners) > 0 && !doc.HasAdmin() {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseSyncAuthorities(c.Response.Status, []string{"authorities must have an admin."}, nil))
+	}
+
+	authorities, err := app.Authorities(Dbm)
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponseSyncAuthorities(c.Response.Status, []string{err.Error()}, nil))
+	}
+	diff := models.NewAuthorityDiff(authorities, doc)
+	if diff.GrantsAdmin() {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseSyncAuthorities(c.Response.Status, []string{"admin role cannot be granted by the document."}, nil))
+	}
+
+	if dry_run || diff.IsEmpty() {
+		c.Response.Status = http.StatusOK
+		return c.RenderJson(c.NewJsonResponseSyncAuthorities(c.Response.Status, []string{"Authority Diff"}, diff.JsonResponse(false)))
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return app.ApplyAuthorityDiff(txn, c.GoogleService, diff)
+	})
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponseSyncAuthorities(c.Response.Status, []string{err.Error()}, nil))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(c.NewJsonResponseSyncAuthorities(c.Response.Status, []string{"Authorities are synced!"}, diff.JsonResponse(true)))
+}
+
+func (c AdminApiController) GetExportUser(email string) revel.Result {
+	user, result := c.findUser(email)
+	if result != nil {
+		return result
+	}
+
+	export, err := models.ExportUserData(Dbm, user)
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseExportUser{c.NewJsonResponse(c.Response.Status, []string{"User Data"}), export})
+}
+
+// GetInstallHistory lists the downloads of the user given as email, or of the paired device given as device_id, after the cursor.
+func (c AdminApiController) GetInstallHistory(email string, device_id int, limit int, cursor string) revel.Result {
+	before, err := models.DecodeCursor(cursor)
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+	limit = models.CursorLimit(limit, Conf.PagerDefaultLimit)
+
+	var user *models.User
+	if device_id != 0 {
+		device, err := models.GetPairedDevice(Dbm, device_id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.Response.Status = http.StatusNotFound
+				return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Device not found."}))
+			}
+			c.Response.Status = http.StatusInternalServerError
+			return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+		}
+		user, err = models.GetUser(Dbm, device.UserId)
+		if err != nil {
+			c.Response.Status = http.StatusInternalServerError
+			return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+		}
+	} else {
+		var result revel.Result
+		user, result = c.findUser(email)
+		if result != nil {
+			return result
+		}
+	}
+
+	// one more download tells whether the page is the last one
+	records, err := models.GetInstallHistory(Dbm, user.Id, device_id, before, limit+1)
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+	next := 0
+	if len(records) > limit {
+		records = records[:limit]
+		next = records[limit-1].Id
+	}
+
+	content := []*models.InstallRecordJsonResponse{}
+	for _, record := range records {
+		content = append(content, record.JsonResponse())
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseInstallHistory{c.NewJsonResponse(c.Response.Status, []string{"Install History"}), content, models.EncodeCursor(next)})
+}
+
+func (c AdminApiController) PostEraseUser(email string) revel.Result {
+	if result := c.checkIdempotencyKey("admin"); result != nil {
+		return result
+	}
+	user, result := c.findUser(email)
+	if result != nil {
+		return result
+	}
+
+	var report *models.UserErasureReport
+	var succeeded []*models.App
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		r, err := models.EraseUserData(txn, c.GoogleService, user)
+		if err != nil {
+			return err
+		}
+		report = r
+		succeeded, err = succeedApps(txn, c.GoogleService, report.OwnedApps)
+		return err
+	})
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+	report.SucceededApps = appIds(succeeded)
+	notifySuccession(succeeded)
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseEraseUser{c.NewJsonResponse(c.Response.Status, []string{"User is erased!"}), report})
+}
+
+func (c AdminApiController) GetBandwidth(date string) revel.Result {
+	day := time.Now()
+	if date != "" {
+		d, err := time.ParseInLocation(models.AuditRollupDateFormat, date, time.Local)
+		if err != nil {
+			c.Response.Status = http.StatusBadRequest
+			return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"date is invalid."}))
+		}
+		day = d
+	}
+
+	usages, err := models.GetBandwidthUsages(Dbm, day)
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	content := []*models.BandwidthUsageJsonResponse{}
+	for _, usage := range usages {
+		content = append(content, usage.JsonResponse())
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseBandwidth{c.NewJsonResponse(c.Response.Status, []string{"Bandwidth Usage"}), content})
+}
+
+// GetEvents responds the changes of the apps and the bundles after the cursor, and the cursor to follow them with.
+// The cursor stays the same while nothing has changed.
+func (c AdminApiController) GetEvents(since, limit int) revel.Result {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	events, err := models.GetEventsSince(Dbm, since, limit)
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	content := &EventsJsonResponse{
+		Cursor: since,
+		Events: []*models.EventJsonResponse{},
+	}
+	for _, event := range events {
+		content.Events = append(content.Events, event.JsonResponse())
+		content.Cursor = event.Seq
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseEvents{c.NewJsonResponse(c.Response.Status, []string{"Events"}), content})
+}
+
+func (c *AdminApiController) findUser(email string) (*models.User, revel.Result) {
+	user, err := models.GetUserFromEmail(Dbm, email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Response.Status = http.StatusNotFound
+			return nil, c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"User not found."}))
+		}
+		c.Response.Status = http.StatusInternalServerError
+		return nil, c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+	return user, nil
+}
+
+func (c *AdminApiController) findApp(appId int) (*models.App, revel.Result) {
+	app, err := models.GetApp(Dbm, appId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Response.Status = http.StatusNotFound
+			return nil, c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"App not found."}))
+		}
+		c.Response.Status = http.StatusInternalServerError
+		return nil, c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+	return app, nil
+}
+
+// bindApp overwrites the settings of the app with the parameters, leaving the omitted ones empty.
+func (c *AdminApiController) bindApp(app *models.App) revel.Result {
+	title := c.Params.Get("title")
+	visibility, visibilityErr := models.ParseAppVisibility(c.Params.Get("visibility"))
+	storageQuotaMb := 0
+	var storageQuotaErr error
+	if v := c.Params.Get("storage_quota_mb"); v != "" {
+		storageQuotaMb, storageQuotaErr = strconv.Atoi(v)
+	}
+
+	c.Validation.Required(title).Message("title is required.")
+	c.Validation.Required(visibilityErr == nil).Message("visibility is invalid.")
+	c.Validation.Required(storageQuotaErr == nil && storageQuotaMb >= 0).Message("storage_quota_mb is invalid.")
+	c.Validation.MaxSize(c.Params.Get("description_template"), 255).Message("description_template is too long.")
+	if c.Validation.HasErrors() {
+		var errors []string
+		for _, err := range c.Validation.Errors {
+			errors = append(errors, err.String())
+		}
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, errors))
+	}
+
+	app.Title = title
+	app.Description = c.Params.Get("description")
+	app.DescriptionTemplate = c.Params.Get("description_template")
+	app.Visibility = visibility
+	app.Category = c.Params.Get("category")
+	app.FirebaseAndroidAppId = c.Params.Get("firebase_android_app_id")
+	app.FirebaseIosAppId = c.Params.Get("firebase_ios_app_id")
+	app.RequireSameSigner = c.Params.Get("require_same_signer") == "true"
+	app.StorageQuotaMb = storageQuotaMb
+	return nil
+}
+
+func (c *AdminApiController) CheckAdminToken() revel.Result {
+	if Conf.AdminApiToken == "" {
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Admin API is not enabled."}))
+	}
+
+	token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+	if !isAdminApiToken(token) {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Token is invalid."}))
+	}
+	c.checkTokenCountry(models.TokenKindAdmin, 0, "管理APIのトークン")
+	c.useToken(models.TokenKindAdmin, 0, token)
+
+	return nil
+}
+
+func isAdminApiToken(token string) bool {
+	return Conf.AdminApiToken != "" && hmac.Equal([]byte(token), []byte(Conf.AdminApiToken))
+}
+
+func (c AdminApiController) GetSettings() revel.Result {
+	content, err := models.GetSettingsJsonResponse(Dbm, settingDefaults())
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseSettings{c.NewJsonResponse(c.Response.Status, []string{"Settings"}), content})
+}
+
+// PutSettings changes only the given settings, and an empty value restores the default in app.conf.
+func (c AdminApiController) PutSettings() revel.Result {
+	values := models.Settings{}
+	for key, value := range c.Params.Values {
+		values[key] = value[0]
+	}
+
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		return models.SaveSettings(txn, values)
+	})
+	expireSettings()
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	content, err := models.GetSettingsJsonResponse(Dbm, settingDefaults())
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseSettings{c.NewJsonResponse(c.Response.Status, []string{"Settings are updated!"}), content})
+}
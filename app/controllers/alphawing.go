@@ -3,9 +3,11 @@ package controllers
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"code.google.com/p/go-uuid/uuid"
 	"code.google.com/p/goauth2/oauth"
@@ -23,7 +25,9 @@ type AlphaWingController struct {
 	GorpController
 	LoginUserId   int
 	GoogleService *models.GoogleService
+	Storage       models.Storage
 	OAuthConfig   *oauth.Config
+	Span          *models.Span
 }
 
 const LoginSessionKey = "LoginSessionKey"
@@ -34,27 +38,37 @@ func (c AlphaWingController) Index() revel.Result {
 		return c.Render()
 	}
 
-	s, err := c.userGoogleService()
+	fileIds, err := c.accessibleFileIds()
 	if err != nil {
 		panic(err)
 	}
 
-	fileList, err := s.GetSharedFileList(Conf.ServiceAccountClientEmail)
+	apps, err := models.GetApps(Dbm, fileIds)
+	if err != nil {
+		panic(err)
+	}
+	dashboardApps, err := models.NewDashboardApps(Dbm, apps)
 	if err != nil {
 		panic(err)
 	}
 
-	var fileIds []string
-	for _, file := range fileList.Items {
-		fileIds = append(fileIds, file.Id)
+	listedApps, err := models.GetListedApps(Dbm, fileIds)
+	if err != nil {
+		panic(err)
 	}
 
-	apps, err := models.GetApps(Dbm, fileIds)
+	user, err := models.GetUser(Dbm, c.LoginUserId)
+	if err != nil {
+		panic(err)
+	}
+	isAdmin := isAdminEmail(user.Email)
+
+	accessRequests, err := models.GetDashboardAccessRequests(Dbm, user.Email)
 	if err != nil {
 		panic(err)
 	}
 
-	return c.Render(apps)
+	return c.Render(dashboardApps, listedApps, isAdmin, accessRequests)
 }
 
 func (c AlphaWingController) GetLogin() revel.Result {
@@ -87,6 +101,7 @@ func (c AlphaWingController) GetCallback() revel.Result {
 		panic(err)
 	}
 	if sessionKey := state.Get("session_key"); sessionKey != c.Session[OAuthSessionKey] {
+		c.recordLoginFailure()
 		panic("invalid session key")
 	}
 	delete(c.Session, OAuthSessionKey)
@@ -96,6 +111,7 @@ func (c AlphaWingController) GetCallback() revel.Result {
 	t := c.transport()
 	_, err = t.Exchange(code)
 	if err != nil {
+		c.recordLoginFailure()
 		panic(err)
 	}
 	tokeninfo, err := c.tokenInfo()
@@ -106,26 +122,137 @@ func (c AlphaWingController) GetCallback() revel.Result {
 	permitted := c.isPermittedEmail(tokeninfo.Email)
 	c.Validation.Required(permitted).Message("can't login with unauthorized email")
 	if c.Validation.HasErrors() {
+		c.recordLoginFailure()
 		c.Validation.Keep()
 		c.FlashParams()
 		return c.Redirect(routes.AlphaWingController.Index())
 	}
 
+	var user *models.User
 	err = Transact(func(txn gorp.SqlExecutor) error {
-		user, err := models.FindOrCreateUser(txn, tokeninfo.Email)
-		if err != nil {
-			return err
-		}
-		c.login(fmt.Sprint(user.Id))
-		return nil
+		user, err = models.FindOrCreateUser(txn, tokeninfo.Email)
+		return err
 	})
 	if err != nil {
 		panic(err)
 	}
+	if user.Deactivated {
+		c.recordLoginFailure()
+		c.Flash.Error("can't login with deactivated account")
+		return c.Redirect(routes.AlphaWingController.Index())
+	}
+	c.login(fmt.Sprint(user.Id))
 
 	return c.Redirect(next)
 }
 
+// accessibleFileIds returns the IDs of the app folders shared with the login user.
+func (c *AlphaWingController) accessibleFileIds() ([]string, error) {
+	s, err := c.userGoogleService()
+	if err != nil {
+		return nil, err
+	}
+
+	fileList, err := s.GetSharedFileList(Conf.ServiceAccountClientEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIds []string
+	for _, file := range fileList.Items {
+		fileIds = append(fileIds, file.Id)
+	}
+	return fileIds, nil
+}
+
+// storageFolderId returns the folder of the storage location, which is empty for the default location.
+func storageFolderId(storageLocation string) (string, bool) {
+	if storageLocation == "" {
+		return "", true
+	}
+	folderId, ok := Conf.StorageLocations[storageLocation]
+	return folderId, ok
+}
+
+// loginAuthority returns nil if the login user is not a member of the app.
+func (c *AlphaWingController) loginAuthority(app *models.App) (*models.Authority, error) {
+	user, err := models.GetUser(Dbm, c.LoginUserId)
+	if err != nil {
+		return nil, err
+	}
+	return app.AuthorityForEmail(Dbm, user.Email)
+}
+
+// isDeveloper reports whether the login user is a member of the app other than a tester.
+func (c *AlphaWingController) isDeveloper(app *models.App) (bool, error) {
+	authority, err := c.loginAuthority(app)
+	if err != nil {
+		return false, err
+	}
+	return authority != nil && authority.IsDeveloper(), nil
+}
+
+// isAdmin reports whether the login user is an admin of the app, who changes the roles, the credentials and the token.
+// The developers manage the apps without an active admin, e.g. created before the roles, until one is named.
+func (c *AlphaWingController) isAdmin(app *models.App) (bool, error) {
+	authority, err := c.loginAuthority(app)
+	if err != nil || authority == nil || !authority.IsDeveloper() {
+		return false, err
+	}
+	if authority.Role == models.AuthorityRoleAdmin {
+		return true, nil
+	}
+	owners, err := app.ActiveOwners(Dbm)
+	if err != nil {
+		return false, err
+	}
+	return len(owners) == 0, nil
+}
+
+// LimitedTimeUriFor returns the URL signed for the LimitedTimeController.
+func (c *AlphaWingController) LimitedTimeUriFor(path string) (*url.URL, error) {
+	u, err := c.UriFor(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureInfo := models.NewLimitedTimeSignatureInfo(u.Host, u.Path)
+	signatureInfo.RefreshSignature(Conf.Secret)
+
+	u.RawQuery = signatureInfo.UrlValues().Encode()
+	return u, nil
+}
+
+// shareLimitedTimeUriFor returns the URL signed for the LimitedTimeController valid for download.sharelinkhours,
+// to pass the install to the testers without a login until it expires or the links of the bundle are revoked.
+func (c *AlphaWingController) shareLimitedTimeUriFor(path string, bundle *models.Bundle) (*url.URL, error) {
+	u, err := c.UriFor(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureInfo := models.NewShareLimitedTimeSignatureInfo(u.Host, u.Path, time.Duration(Conf.ShareLinkHours)*time.Hour, bundle.ShareGeneration)
+	signatureInfo.RefreshSignature(Conf.Secret)
+
+	u.RawQuery = signatureInfo.UrlValues().Encode()
+	return u, nil
+}
+
+// deviceLimitedTimeUriFor returns the URL signed for the LimitedTimeController with the paired device,
+// so that the downloads through it are recorded for the device.
+func (c *AlphaWingController) deviceLimitedTimeUriFor(path string, deviceId int) (*url.URL, error) {
+	u, err := c.UriFor(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureInfo := models.NewDeviceLimitedTimeSignatureInfo(u.Host, u.Path, deviceId)
+	signatureInfo.RefreshSignature(Conf.Secret)
+
+	u.RawQuery = signatureInfo.UrlValues().Encode()
+	return u, nil
+}
+
 func (c *AlphaWingController) UriFor(path string) (*url.URL, error) {
 	scheme := "http"
 	if c.Request.Header.Get("X-Forwarded-Proto") == "https" {
@@ -253,23 +380,46 @@ func (c *AlphaWingController) InitOAuthConfig() revel.Result {
 	return nil
 }
 
-func (c *AlphaWingController) InitGoogleService() revel.Result {
-	config := &models.ServiceAccountConfig{
-		ClientEmail: Conf.ServiceAccountClientEmail,
-		PrivateKey:  Conf.ServiceAccountPrivateKey,
-		Scope:       []string{drive.DriveScope},
+// StartTrace starts the span of the request, continuing the trace of the caller if the header is given.
+func (c *AlphaWingController) StartTrace() revel.Result {
+	c.Span = Conf.Tracer.StartRemoteSpan(c.Name+"."+c.MethodName, models.SpanKindServer, c.Request.Header.Get(models.TraceParentHeader))
+	c.Span.SetAttribute("http.method", c.Request.Method)
+	c.Span.SetAttribute("http.target", c.Request.URL.Path)
+	return nil
+}
+
+func (c *AlphaWingController) FinishTrace() revel.Result {
+	c.Span.SetAttribute("http.status_code", strconv.Itoa(c.Response.Status))
+	if c.Response.Status >= 500 {
+		c.Span.SetError(http.StatusText(c.Response.Status))
 	}
+	c.Span.Finish()
+	return nil
+}
 
-	token, err := models.GetServiceAccountToken(config)
+// CheckSetup shows the setup until the first user logs in, before the service account is used.
+func (c *AlphaWingController) CheckSetup() revel.Result {
+	if c.MethodName != "Index" || c.isLogin() {
+		return nil
+	}
+	firstRun, err := isFirstRun()
 	if err != nil {
 		panic(err)
 	}
+	if firstRun {
+		return c.Redirect(routes.SetupController.GetSetup())
+	}
+	return nil
+}
 
-	s, err := models.NewGoogleService(token)
+func (c *AlphaWingController) InitGoogleService() revel.Result {
+	s, err := NewServiceAccountGoogleService()
 	if err != nil {
 		panic(err)
 	}
+	s.Trace(c.Span)
 	c.GoogleService = s
+	c.Storage = newStorage(s)
 
 	capacityInfo, err := s.GetCapacityInfo()
 	if err != nil {
@@ -281,7 +431,13 @@ func (c *AlphaWingController) InitGoogleService() revel.Result {
 }
 
 func (c *AlphaWingController) InitRenderArgs() revel.Result {
-	c.RenderArgs["organizationName"] = Conf.OrganizationName
+	alert, err := models.GetLastStorageAlert(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	if alert != nil && alert.Threshold > 0 {
+		c.RenderArgs["storageAlert"] = alert
+	}
 
 	return nil
 }
@@ -296,10 +452,42 @@ func (c *AlphaWingController) userGoogleService() (*models.GoogleService, error)
 	if err != nil {
 		return nil, err
 	}
+	s.InjectFaults(Conf.StorageFaults)
+	s.Trace(c.Span)
+
+	return s, nil
+}
+
+// NewServiceAccountGoogleService returns the GoogleService which owns the app folders and bundle files.
+func NewServiceAccountGoogleService() (*models.GoogleService, error) {
+	s, err := newServiceAccountGoogleService()
+	if err != nil {
+		return nil, err
+	}
+	s.PermanentDelete = Conf.DrivePermanentDelete
+	s.InjectFaults(Conf.StorageFaults)
 
 	return s, nil
 }
 
+func newServiceAccountGoogleService() (*models.GoogleService, error) {
+	if Conf.FakeDrive != nil {
+		return models.NewFakeGoogleService(Conf.FakeDrive)
+	}
+
+	config := &models.ServiceAccountConfig{
+		ClientEmail: Conf.ServiceAccountClientEmail,
+		PrivateKey:  Conf.ServiceAccountPrivateKey,
+		Scope:       []string{drive.DriveScope},
+	}
+
+	token, err := models.GetServiceAccountToken(config)
+	if err != nil {
+		return nil, err
+	}
+	return models.NewGoogleService(token)
+}
+
 func extractPath(next string) string {
 	n, err := url.Parse(next)
 	if err != nil {
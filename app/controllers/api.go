@@ -2,9 +2,10 @@ package controllers
 
 import (
 	"database/sql"
+	"fmt"
 	"net/http"
 	"os"
-	"path/filepath"
+	"time"
 
 	"github.com/coopernurse/gorp"
 	"github.com/kayac/alphawing/app/models"
@@ -20,6 +21,7 @@ type JsonResponse struct {
 type JsonResponseUploadBundle struct {
 	*JsonResponse
 	Content *models.BundleJsonResponse `json:"content"`
+	Error   *models.UploadDiagnosis    `json:"error,omitempty"`
 }
 
 type JsonResponseListBundle struct {
@@ -27,6 +29,26 @@ type JsonResponseListBundle struct {
 	Content *models.BundlesJsonResponse `json:"content"`
 }
 
+type JsonResponseSyncAuthorities struct {
+	*JsonResponse
+	Content *models.AuthorityDiffJsonResponse `json:"content"`
+}
+
+type JsonResponseMetrics struct {
+	*JsonResponse
+	Content *models.AppMetricsJsonResponse `json:"content"`
+}
+
+type JsonResponseStorageUsage struct {
+	*JsonResponse
+	Content *models.AppStorageUsageJsonResponse `json:"content"`
+}
+
+type JsonResponseStats struct {
+	*JsonResponse
+	Content []*models.AppStatJsonResponse `json:"content"`
+}
+
 type ApiController struct {
 	AlphaWingController
 }
@@ -40,8 +62,8 @@ func (c ApiController) NewJsonResponse(stat int, mes []string) *JsonResponse {
 
 func (c ApiController) NewJsonResponseUploadBundle(stat int, mes []string, content *models.BundleJsonResponse) *JsonResponseUploadBundle {
 	return &JsonResponseUploadBundle{
-		c.NewJsonResponse(stat, mes),
-		content,
+		JsonResponse: c.NewJsonResponse(stat, mes),
+		Content:      content,
 	}
 }
 
@@ -56,27 +78,52 @@ func (c ApiController) NewJsonResponseListBundle(stat int, mes []string, content
 	}
 }
 
+func (c ApiController) NewJsonResponseSyncAuthorities(stat int, mes []string, content *models.AuthorityDiffJsonResponse) *JsonResponseSyncAuthorities {
+	return &JsonResponseSyncAuthorities{
+		c.NewJsonResponse(stat, mes),
+		content,
+	}
+}
+
 func (c ApiController) GetDocument() revel.Result {
 	return c.Render()
 }
 
-func (c ApiController) PostUploadBundle(token string, description string, file *os.File) revel.Result {
-	app, err := models.GetAppByApiToken(Dbm, token)
+func (c ApiController) GetSigningKey() revel.Result {
+	if Conf.BundleSigner == nil {
+		return c.NotFound("Signing is not enabled.")
+	}
+
+	publicKey, err := Conf.BundleSigner.PublicKeyPem()
+	if err != nil {
+		panic(err)
+	}
+	return c.RenderText(string(publicKey))
+}
+
+func (c ApiController) PostUploadBundle(token string, description string, known_issues string, channel string, tags string, variant string, branch string, commit string, ci_job_url string, version string, short_version string, identifier string, file *os.File, provenance *os.File, mapping *os.File) revel.Result {
+	app, err := c.appByApiToken(token)
 	if err != nil {
 		c.Response.Status = http.StatusUnauthorized
 		return c.RenderJson(c.NewJsonResponseUploadBundle(c.Response.Status, []string{"Token is invalid."}, nil))
 	}
+	if result := c.checkIdempotencyKey(fmt.Sprintf("app:%d", app.Id)); result != nil {
+		return result
+	}
 
 	var filename string
 	if _, ok := c.Params.Files["file"]; ok {
 		filename = c.Params.Files["file"][0].Filename
 	}
-	extStr := filepath.Ext(filename)
-	ext := models.BundleFileExtension(extStr)
+	ext := models.BundleFileExtensionOf(filename)
 	isValidExt := ext.IsValid()
 
 	c.Validation.Required(file != nil).Message("File is required.")
 	c.Validation.Required(isValidExt).Message("File extension is not valid.")
+	c.Validation.Required(withinUploadLimit(file)).Message("File is too large.")
+	c.Validation.Required(models.ValidCiJobUrl(ci_job_url) == nil).Message("ci_job_url is not valid.")
+	c.Validation.Required(mapping == nil || ext.PlatformType() == models.BundlePlatformTypeAndroid).Message("mapping is only for the apks and the aabs.")
+	c.Validation.Required(withinUploadLimit(mapping)).Message("mapping is too large.")
 	if c.Validation.HasErrors() {
 		var errors []string
 		for _, err := range c.Validation.Errors {
@@ -89,18 +136,80 @@ func (c ApiController) PostUploadBundle(token string, description string, file *
 	bundle := &models.Bundle{
 		PlatformType: ext.PlatformType(),
 		Description:  description,
+		Channel:      channel,
+		Tags:         tags,
+		Variant:      variant,
+		Branch:       branch,
+		Commit:       commit,
+		Translations: c.changelogParams(),
 		File:         file,
+		UploadedBy:   models.ApiUploader(token),
+		CiJobUrl:     ci_job_url,
+
+		BundleVersion:    version,
+		ShortVersion:     short_version,
+		BundleIdentifier: identifier,
 	}
 
-	if err := app.CreateBundle(Dbm, c.GoogleService, bundle); err != nil {
-		if bperr, ok := err.(*models.BundleParseError); ok {
-			c.Response.Status = http.StatusInternalServerError
-			return c.RenderJson(c.NewJsonResponseUploadBundle(c.Response.Status, []string{bperr.Error()}, nil))
-		}
+	noteAppWrite(app.Id)
+	if err := app.CreateBundle(Dbm, c.Storage, bundle); err != nil {
+		return c.renderUploadError(err)
+	}
+	return c.renderUploadedBundle(app, bundle, known_issues, provenance, mapping)
+}
+
+func (c ApiController) renderUploadError(err error) revel.Result {
+	diagnosis := c.diagnoseUpload(err)
+	c.Response.Status = diagnosis.Status
+	response := c.NewJsonResponseUploadBundle(c.Response.Status, []string{diagnosis.String()}, nil)
+	response.Error = diagnosis
+	return c.RenderJson(response)
+}
+
+// renderUploadedBundle adds the known issues, the provenance and the mapping to the bundle created, and notifies it.
+// The bundle is kept when the mapping is not valid, which is told in the message.
+func (c ApiController) renderUploadedBundle(app *models.App, bundle *models.Bundle, knownIssues string, provenance *os.File, mapping *os.File) revel.Result {
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		return bundle.AddKnownIssues(txn, models.ParseKnownIssues(knownIssues))
+	})
+	if err != nil {
 		c.Response.Status = http.StatusInternalServerError
 		return c.RenderJson(c.NewJsonResponseUploadBundle(c.Response.Status, []string{err.Error()}, nil))
 	}
 
+	c.cachePlist(app, bundle)
+	c.forwardBundle(app, bundle)
+	c.analyzeBundle(app, bundle)
+	c.pushBundle(app, bundle)
+	c.notifyBundle(app, bundle)
+
+	messages := []string{"Bundle is created!"}
+	if provenance != nil {
+		p, err := attachProvenance(bundle, provenance)
+		if err != nil {
+			c.Response.Status = http.StatusInternalServerError
+			return c.RenderJson(c.NewJsonResponseUploadBundle(c.Response.Status, []string{err.Error()}, nil))
+		}
+		if !p.Verified {
+			messages = append(messages, p.Message)
+		}
+	}
+	if mapping != nil {
+		_, err := bundle.AddMapping(Dbm, c.Storage, mapping)
+		_, overQuota := err.(*models.AppQuotaError)
+		switch {
+		case err == nil:
+		case err == models.ErrMappingInvalid, err == models.ErrMappingVersionCode, overQuota:
+			messages = append(messages, fmt.Sprintf("The mapping is not stored: %s.", err))
+		default:
+			c.Response.Status = http.StatusInternalServerError
+			return c.RenderJson(c.NewJsonResponseUploadBundle(c.Response.Status, []string{err.Error()}, nil))
+		}
+	}
+	if warnsProfileExpiry(bundle) {
+		messages = append(messages, fmt.Sprintf("The provisioning profile expires at %s.", bundle.ProfileExpiresAt.Format(time.RFC3339)))
+	}
+
 	content, err := bundle.JsonResponse(&c)
 	if err != nil {
 		c.Response.Status = http.StatusInternalServerError
@@ -108,15 +217,18 @@ func (c ApiController) PostUploadBundle(token string, description string, file *
 	}
 
 	c.Response.Status = http.StatusOK
-	return c.RenderJson(c.NewJsonResponseUploadBundle(c.Response.Status, []string{"Bundle is created!"}, content))
+	return c.RenderJson(c.NewJsonResponseUploadBundle(c.Response.Status, messages, content))
 }
 
 func (c ApiController) PostDeleteBundle(token string, file_id string) revel.Result {
-	_, err := models.GetAppByApiToken(Dbm, token)
+	app, err := c.appByApiToken(token)
 	if err != nil {
 		c.Response.Status = http.StatusUnauthorized
 		return c.RenderJson(c.NewJsonResponseDeleteBundle(c.Response.Status, []string{"Token is invalid."}))
 	}
+	if result := c.checkIdempotencyKey(fmt.Sprintf("app:%d", app.Id)); result != nil {
+		return result
+	}
 
 	c.Validation.Required(file_id).Message("file_id is required.")
 	if c.Validation.HasErrors() {
@@ -128,7 +240,7 @@ func (c ApiController) PostDeleteBundle(token string, file_id string) revel.Resu
 		return c.RenderJson(c.NewJsonResponseDeleteBundle(c.Response.Status, errors))
 	}
 
-	bundle, err := models.GetBundleByFileId(Dbm, file_id)
+	bundle, err := app.GetBundleByFileId(Dbm, file_id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.Response.Status = http.StatusNotFound
@@ -138,8 +250,9 @@ func (c ApiController) PostDeleteBundle(token string, file_id string) revel.Resu
 		return c.RenderJson(c.NewJsonResponseDeleteBundle(c.Response.Status, []string{err.Error()}))
 	}
 
-	err = Transact(func(txn gorp.SqlExecutor) error {
-		return bundle.Delete(txn, c.GoogleService)
+	noteAppWrite(bundle.AppId)
+	err = models.TransactDeleting(Dbm, c.Storage, Conf.BundleArchive, func(txn gorp.SqlExecutor, storage models.Storage) error {
+		return bundle.Delete(txn, storage)
 	})
 	if err != nil {
 		c.Response.Status = http.StatusInternalServerError
@@ -150,14 +263,37 @@ func (c ApiController) PostDeleteBundle(token string, file_id string) revel.Resu
 	return c.RenderJson(c.NewJsonResponseDeleteBundle(c.Response.Status, []string{"Bundle is deleted!"}))
 }
 
-func (c ApiController) GetListBundle(token string, page int) revel.Result {
-	app, err := models.GetAppByApiToken(Dbm, token)
+// GetListBundle lists the bundles of the variant, or of every variant if it is empty, by the page or after the cursor.
+// The cursor of the next page is given either way, for CI to follow it while the bundles are uploaded.
+func (c ApiController) GetListBundle(token string, page int, per_page int, cursor string, variant string, uploaded_by string) revel.Result {
+	app, err := c.appByApiToken(token)
 	if err != nil {
 		c.Response.Status = http.StatusUnauthorized
 		return c.RenderJson(c.NewJsonResponseListBundle(c.Response.Status, []string{"Token is invalid."}, nil))
 	}
+	before, err := models.DecodeCursor(cursor)
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseListBundle(c.Response.Status, []string{err.Error()}, nil))
+	}
+	limit := models.CursorLimit(per_page, Conf.PagerDefaultLimit)
 
-	bundles, totalCount, err := app.BundlesWithPager(Dbm, page, Conf.PagerDefaultLimit)
+	var bundles models.Bundles
+	var totalCount, next int
+	if cursor != "" {
+		page = 0
+		if bundles, next, err = app.BundlesWithCursor(readDbm(app.Id), before, limit, variant, uploaded_by); err == nil {
+			totalCount, err = app.BundleCount(readDbm(app.Id), variant, uploaded_by)
+		}
+	} else {
+		if page < 1 {
+			page = 1
+		}
+		bundles, totalCount, err = app.BundlesWithPager(readDbm(app.Id), page, limit, variant, uploaded_by)
+		if len(bundles) > 0 && (page-1)*limit+len(bundles) < totalCount {
+			next = bundles[len(bundles)-1].Id
+		}
+	}
 	if err != nil {
 		c.Response.Status = http.StatusInternalServerError
 		return c.RenderJson(c.NewJsonResponseListBundle(c.Response.Status, []string{err.Error()}, nil))
@@ -170,13 +306,87 @@ func (c ApiController) GetListBundle(token string, page int) revel.Result {
 	}
 
 	content := &models.BundlesJsonResponse{
-		totalCount,
-		page,
-		Conf.PagerDefaultLimit,
-		bundlesJsonResponse,
+		TotalCount: totalCount,
+		Page:       page,
+		Limit:      limit,
+		NextCursor: models.EncodeCursor(next),
+		Bundles:    bundlesJsonResponse,
 	}
 
 	c.Response.Status = http.StatusOK
 
 	return c.RenderJson(c.NewJsonResponseListBundle(c.Response.Status, []string{"Bundle List"}, content))
 }
+
+func (c ApiController) GetStats(token string, period string, limit int) revel.Result {
+	app, err := c.appByApiToken(token)
+	if err != nil {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Token is invalid."}))
+	}
+
+	if period == "" {
+		period = models.AppStatPeriodDaily
+	}
+	if limit <= 0 {
+		limit = Conf.PagerDefaultLimit
+	}
+	c.Validation.Required(models.IsValidAppStatPeriod(period)).Message("period is invalid.")
+	if c.Validation.HasErrors() {
+		var errors []string
+		for _, err := range c.Validation.Errors {
+			errors = append(errors, err.String())
+		}
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, errors))
+	}
+
+	stats, err := app.Stats(readDbm(app.Id), period, limit)
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	content := []*models.AppStatJsonResponse{}
+	for _, stat := range stats {
+		content = append(content, stat.JsonResponse())
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseStats{c.NewJsonResponse(c.Response.Status, []string{"Stats"}), content})
+}
+
+func (c ApiController) GetMetrics(token string) revel.Result {
+	app, err := c.appByApiToken(token)
+	if err != nil {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Token is invalid."}))
+	}
+
+	metrics, err := app.Metrics(readDbm(app.Id), time.Now().AddDate(0, 0, -models.AppMetricsDays))
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseMetrics{c.NewJsonResponse(c.Response.Status, []string{"Metrics"}), metrics.JsonResponse()})
+}
+
+// GetStorageUsage tells the size of the files of the project against its quota, e.g. for CI to delete old bundles before the upload.
+func (c ApiController) GetStorageUsage(token string) revel.Result {
+	app, err := c.appByApiToken(token)
+	if err != nil {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Token is invalid."}))
+	}
+
+	usage, err := app.StorageUsage(readDbm(app.Id))
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseStorageUsage{c.NewJsonResponse(c.Response.Status, []string{"Storage"}), usage.JsonResponse()})
+}
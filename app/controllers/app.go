@@ -2,9 +2,15 @@ package controllers
 
 import (
 	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/kayac/alphawing/app/models"
 	"github.com/kayac/alphawing/app/routes"
@@ -27,11 +33,155 @@ type AppControllerWithValidation struct {
 // AppController
 func (c AppController) GetCreateApp() revel.Result {
 	app := &models.App{}
-	return c.Render(app)
+	storageLocations := Conf.StorageLocations
+	return c.Render(app, storageLocations)
+}
+
+// GetCatalog lists the apps the user can access and the listed ones, whose title contains q unless it is empty.
+func (c AppController) GetCatalog(q string) revel.Result {
+	fileIds, err := c.accessibleFileIds()
+	if err != nil {
+		panic(err)
+	}
+
+	apps, err := models.SearchApps(Dbm, fileIds, q)
+	if err != nil {
+		panic(err)
+	}
+	listedApps, err := models.SearchListedApps(Dbm, fileIds, q)
+	if err != nil {
+		panic(err)
+	}
+
+	// the catalog of every app is read from the replica even after an upload, as it is not the page the uploader is sent to
+	catalog, err := models.NewCatalog(ReadDbm, apps, listedApps)
+	if err != nil {
+		panic(err)
+	}
+
+	return c.Render(catalog, q)
+}
+
+// GetCalendar shows the bundles published and the releases planned in the month, e.g. "2006-01", across the apps.
+func (c AppController) GetCalendar(month string) revel.Result {
+	from, err := time.ParseInLocation("2006-01", month, time.Local)
+	if err != nil {
+		now := time.Now()
+		from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	}
+	to := from.AddDate(0, 1, 0)
+
+	fileIds, err := c.accessibleFileIds()
+	if err != nil {
+		panic(err)
+	}
+	apps, err := models.GetApps(Dbm, fileIds)
+	if err != nil {
+		panic(err)
+	}
+
+	calendar, err := models.GetReleaseCalendar(Dbm, apps, from, to)
+	if err != nil {
+		panic(err)
+	}
+
+	month = from.Format("2006-01")
+	prevMonth := from.AddDate(0, -1, 0).Format("2006-01")
+	nextMonth := to.Format("2006-01")
+	return c.Render(calendar, month, prevMonth, nextMonth)
+}
+
+func (c AppController) GetIcon(appId int) revel.Result {
+	app, err := models.GetApp(Dbm, appId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NotFound("App is not found.")
+		}
+		panic(err)
+	}
+	if app.IconFileId == "" {
+		return c.NotFound("Icon is not found.")
+	}
+
+	// only the icons of the listed apps are shown to the users without access
+	if !app.IsListed() {
+		s, err := c.userGoogleService()
+		if err != nil {
+			panic(err)
+		}
+		if _, err = s.GetFile(app.FileId); err != nil {
+			return c.NotFound("App is not found.")
+		}
+	}
+
+	resp, file, err := c.GoogleService.DownloadFile(app.IconFileId)
+	if err != nil {
+		panic(err)
+	}
+
+	modtime, err := time.Parse(time.RFC3339, file.ModifiedDate)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Response.ContentType = file.MimeType
+	return c.RenderBinary(resp.Body, file.Title, revel.Inline, modtime)
+}
+
+func (c AppController) PostRequestAccess(appId int) revel.Result {
+	app, err := models.GetApp(Dbm, appId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NotFound("App is not found.")
+		}
+		panic(err)
+	}
+	if app.IsPrivate() {
+		return c.NotFound("App is not found.")
+	}
+
+	accessRequest, err := models.GetPendingAccessRequest(Dbm, appId, c.LoginUserId)
+	if err != nil {
+		panic(err)
+	}
+	if accessRequest != nil {
+		c.Flash.Error("Access is already requested.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	tokeninfo, err := c.tokenInfo()
+	if err != nil {
+		panic(err)
+	}
+
+	accessRequest = &models.AccessRequest{
+		AppId:  appId,
+		UserId: c.LoginUserId,
+		Email:  tokeninfo.Email,
+		State:  models.AccessRequestStatePending,
+	}
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return accessRequest.Save(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceAccessRequest, accessRequest.Id, models.ActionCreate); err != nil {
+		panic(err)
+	}
+
+	c.notifyAccessRequest(app, accessRequest)
+
+	c.Flash.Success("Requested!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
 }
 
 func (c AppController) PostCreateApp(app models.App) revel.Result {
 	c.Validation.Required(app.Title).Message("Title is required.")
+	c.Validation.Required(app.Visibility.IsValid()).Message("Visibility is invalid.")
+	folderId, ok := storageFolderId(app.StorageLocation)
+	c.Validation.Required(ok).Message("Storage location is invalid.")
 	if c.Validation.HasErrors() {
 		c.Validation.Keep()
 		c.FlashParams()
@@ -39,7 +189,7 @@ func (c AppController) PostCreateApp(app models.App) revel.Result {
 	}
 
 	err := Transact(func(txn gorp.SqlExecutor) error {
-		if err := models.CreateApp(txn, c.GoogleService, &app); err != nil {
+		if err := models.CreateApp(txn, c.GoogleService, &app, folderId); err != nil {
 			return err
 		}
 
@@ -49,6 +199,7 @@ func (c AppController) PostCreateApp(app models.App) revel.Result {
 		}
 		authority := &models.Authority{
 			Email: tokeninfo.Email,
+			Role:  models.AuthorityRoleAdmin,
 		}
 		return app.CreateAuthority(txn, c.GoogleService, authority)
 	})
@@ -66,203 +217,1233 @@ func (c AppController) PostCreateApp(app models.App) revel.Result {
 
 // ------------------------------------------------------
 // AppControllerWithValidation
+// GetApp lists the bundles of the variant in the query, or of every variant without it.
 func (c AppControllerWithValidation) GetApp(appId int) revel.Result {
 	app := c.App
+	// not an argument, so that the reverse routes to the app stay as they are
+	variant := c.Params.Get("variant")
+
+	// the testers tell the builds by the codenames
+	if codename := strings.TrimSpace(strings.ToLower(c.Params.Get("codename"))); codename != "" {
+		bundle, err := app.GetBundleByCodename(Dbm, codename)
+		if err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+		if err == sql.ErrNoRows {
+			c.Flash.Error("No bundle has the codename.")
+			return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+		}
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundle.Id))
+	}
 
 	authorities, err := app.Authorities(Dbm)
 	if err != nil {
 		panic(err)
 	}
 
-	apkBundles, err := app.BundlesByPlatformType(Dbm, models.BundlePlatformTypeAndroid)
+	variants, err := app.Variants(readDbm(app.Id))
 	if err != nil {
 		panic(err)
 	}
 
-	ipaBundles, err := app.BundlesByPlatformType(Dbm, models.BundlePlatformTypeIOS)
+	apkBundles, err := app.BundlesByPlatformType(readDbm(app.Id), models.BundlePlatformTypeAndroid, variant)
 	if err != nil {
 		panic(err)
 	}
 
-	return c.Render(app, authorities, apkBundles, ipaBundles)
-}
-
-func (c AppControllerWithValidation) GetUpdateApp(appId int) revel.Result {
-	app := c.App
-	return c.Render(app)
-}
-
-func (c AppControllerWithValidation) PostUpdateApp(appId int, app models.App) revel.Result {
-	if appId != app.Id {
-		c.Flash.Error("Parameter is invalid.")
-		c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(app.Id))
+	ipaBundles, err := app.BundlesByPlatformType(readDbm(app.Id), models.BundlePlatformTypeIOS, variant)
+	if err != nil {
+		panic(err)
 	}
 
-	c.Validation.Required(app.Title).Message("Title is required.")
-	if c.Validation.HasErrors() {
-		c.Validation.Keep()
-		c.FlashParams()
-		return c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(app.Id))
+	macBundles, err := app.BundlesByPlatformType(readDbm(app.Id), models.BundlePlatformTypeMacOS, variant)
+	if err != nil {
+		panic(err)
 	}
 
-	err := Transact(func(txn gorp.SqlExecutor) error {
-		return app.Update(txn)
-	})
+	genericBundles, err := app.BundlesByPlatformType(readDbm(app.Id), models.BundlePlatformTypeGeneric, variant)
 	if err != nil {
 		panic(err)
 	}
 
-	if err := c.GoogleService.UpdateFileTitle(c.App.FileId, app.Title); err != nil {
+	deviceGroups, err := app.DeviceGroups(Dbm)
+	if err != nil {
 		panic(err)
 	}
+	mdmEnabled := Conf.MdmProvider != ""
 
-	c.Flash.Success("Updated!")
-	return c.Redirect(routes.AppControllerWithValidation.GetApp(app.Id))
-}
-
-func (c AppControllerWithValidation) PostRefreshToken(appId int, app models.App) revel.Result {
-	if appId != app.Id {
-		c.Flash.Error("Parameter is invalid")
-		c.Redirect(routes.AppControllerWithValidation.GetApp(app.Id))
+	accessRequests, err := app.PendingAccessRequests(Dbm)
+	if err != nil {
+		panic(err)
 	}
 
-	err := Transact(func(txn gorp.SqlExecutor) error {
-		return app.RefreshToken(txn)
-	})
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	isAdmin, err := c.isAdmin(app)
 	if err != nil {
 		panic(err)
 	}
 
-	c.Flash.Success("Refreshed!")
-	return c.Redirect(routes.AppControllerWithValidation.GetApp(app.Id))
-}
+	weeklyStats, err := app.Stats(readDbm(app.Id), models.AppStatPeriodWeekly, 8)
+	if err != nil {
+		panic(err)
+	}
 
-func (c AppControllerWithValidation) PostDeleteApp(appId int) revel.Result {
-	app := c.App
+	notificationRoutes, err := app.NotificationRoutes(Dbm)
+	if err != nil {
+		panic(err)
+	}
 
-	err := Transact(func(txn gorp.SqlExecutor) error {
-		return app.Delete(txn, c.GoogleService)
-	})
+	metrics, err := app.Metrics(readDbm(app.Id), time.Now().AddDate(0, 0, -models.AppMetricsDays))
 	if err != nil {
 		panic(err)
 	}
 
-	if err := c.createAudit(models.ResourceApp, appId, models.ActionDelete); err != nil {
+	now := time.Now()
+	releasePlans, err := app.UpcomingReleasePlans(Dbm, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local))
+	if err != nil {
 		panic(err)
 	}
 
-	c.Flash.Success("Deleted!")
-	return c.Redirect(routes.AlphaWingController.Index())
-}
+	// the usage of the API tokens, for the developers to find the integrations still sending the one refreshed
+	var tokenActivities []*models.TokenActivity
+	if isDeveloper {
+		tokenActivities, err = app.TokenActivities(Dbm)
+		if err != nil {
+			panic(err)
+		}
+	}
 
-func (c AppControllerWithValidation) GetCreateBundle(appId int) revel.Result {
-	app := c.App
-	bundle := &models.Bundle{AppId: appId}
-	return c.Render(app, bundle)
-}
+	// the env files with who downloaded them, for the developers only
+	var envFiles []*models.EnvFile
+	var envFileAccesses []*models.EnvFileAccess
+	if isDeveloper {
+		envFiles, err = app.EnvFiles(Dbm)
+		if err != nil {
+			panic(err)
+		}
+		envFileAccesses, err = app.EnvFileAccesses(Dbm, models.EnvFileAccessesShown)
+		if err != nil {
+			panic(err)
+		}
+	}
 
-func (c AppControllerWithValidation) PostCreateBundle(appId int, bundle models.Bundle, file *os.File) revel.Result {
-	if appId != bundle.AppId {
-		c.Flash.Error("Parameter is invalid.")
-		c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	// the size of the files against the quota set by the admins
+	var storageUsage *models.AppStorageUsage
+	if isDeveloper {
+		storageUsage, err = app.StorageUsage(Dbm)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	var filename string
-	if _, ok := c.Params.Files["file"]; ok {
-		filename = c.Params.Files["file"][0].Filename
+	// the badges to embed in the READMEs, whose token is shown to the developers as the API token is
+	var androidBadgeUrl, iosBadgeUrl string
+	if isDeveloper {
+		if androidBadgeUrl, err = c.badgeUrl(app, "android"); err != nil {
+			panic(err)
+		}
+		if iosBadgeUrl, err = c.badgeUrl(app, "ios"); err != nil {
+			panic(err)
+		}
 	}
-	extStr := filepath.Ext(filename)
-	ext := models.BundleFileExtension(extStr)
-	isValidExt := ext.IsValid()
 
-	c.Validation.Required(file != nil).Message("File is required.")
-	c.Validation.Required(isValidExt).Message("File extension is not valid.")
-	if c.Validation.HasErrors() {
-		c.Validation.Keep()
-		c.FlashParams()
-		return c.Redirect(routes.AppControllerWithValidation.GetCreateBundle(appId))
+	return c.Render(app, authorities, variants, variant, apkBundles, ipaBundles, macBundles, genericBundles, deviceGroups, mdmEnabled, accessRequests, isDeveloper, isAdmin, weeklyStats, notificationRoutes, releasePlans, metrics, tokenActivities, envFiles, envFileAccesses, storageUsage, androidBadgeUrl, iosBadgeUrl)
+}
+
+// GetDoc shows the documentation at the revision, or at the latest one for 0.
+func (c AppControllerWithValidation) GetDoc(appId, revision int) revel.Result {
+	app := c.App
+
+	doc, err := app.DocRevision(Dbm, revision)
+	if err != nil {
+		panic(err)
+	}
+	if doc == nil && revision != 0 {
+		return c.NotFound("Document revision is not found.")
 	}
 
-	bundle.File = file
-	bundle.PlatformType = ext.PlatformType()
-	if err := c.App.CreateBundle(Dbm, c.GoogleService, &bundle); err != nil {
-		if bperr, ok := err.(*models.BundleParseError); ok {
-			c.Flash.Error(bperr.Error())
-			return c.Redirect(routes.AppControllerWithValidation.GetCreateBundle(appId))
-		}
+	docRevisions, err := app.DocRevisions(Dbm)
+	if err != nil {
 		panic(err)
 	}
 
-	if err := c.createAudit(models.ResourceBundle, bundle.Id, models.ActionCreate); err != nil {
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
 		panic(err)
 	}
 
-	c.Flash.Success("Created!")
-	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundle.Id))
+	var docHtml string
+	if doc != nil {
+		docHtml = doc.Html()
+	}
+
+	return c.Render(app, doc, docRevisions, docHtml, isDeveloper)
 }
 
-func (c AppControllerWithValidation) PostCreateAuthority(appId int, email string) revel.Result {
+func (c AppControllerWithValidation) GetUpdateDoc(appId int) revel.Result {
 	app := c.App
 
-	c.Validation.Required(email).Message("Email is required.")
-	c.Validation.Email(email).Message("Email is invalid.")
-	if c.Validation.HasErrors() {
-		c.Validation.Keep()
-		c.FlashParams()
-		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetDoc(appId, 0))
 	}
 
-	found, err := app.HasAuthorityForEmail(Dbm, email)
+	doc, err := app.DocRevision(Dbm, 0)
 	if err != nil {
 		panic(err)
 	}
-	c.Validation.Required(!found).Message(email + " is already registered.")
+	if doc == nil {
+		doc = &models.AppDocRevision{}
+	}
+
+	return c.Render(app, doc)
+}
+
+// PostUpdateDoc adds the edit as a new revision, so that the bundles pinning the old ones keep them.
+func (c AppControllerWithValidation) PostUpdateDoc(appId int, body, summary string) revel.Result {
+	app := c.App
+
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetDoc(appId, 0))
+	}
+
+	c.Validation.MaxSize(body, 65535).Message("Document is too long.")
+	c.Validation.MaxSize(summary, 255).Message("Summary is too long.")
 	if c.Validation.HasErrors() {
 		c.Validation.Keep()
 		c.FlashParams()
-		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
-	}
-
-	authority := &models.Authority{
-		Email: email,
+		return c.Redirect(routes.AppControllerWithValidation.GetUpdateDoc(appId))
 	}
 
+	var doc *models.AppDocRevision
 	err = Transact(func(txn gorp.SqlExecutor) error {
-		return app.CreateAuthority(txn, c.GoogleService, authority)
+		var err error
+		doc, err = app.CreateDocRevision(txn, body, summary, c.LoginUserId)
+		return err
 	})
 	if err != nil {
 		panic(err)
 	}
 
-	if err := c.createAudit(models.ResourceAuthority, authority.Id, models.ActionCreate); err != nil {
+	c.Flash.Success("Updated!")
+	return c.Redirect(routes.AppControllerWithValidation.GetDoc(appId, doc.Revision))
+}
+
+// GetStaleTesters reports the testers who have not installed any bundle in the days, for the QA leads to follow up.
+func (c AppControllerWithValidation) GetStaleTesters(appId, days int) revel.Result {
+	app := c.App
+
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
 		panic(err)
 	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
 
-	c.Flash.Success("Registered!")
-	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	if days <= 0 {
+		days = models.StaleTesterDefaultDays
+	}
+	staleTesters, err := app.StaleTesters(Dbm, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		panic(err)
+	}
+	mailEnabled := Conf.Mailer != nil
+
+	return c.Render(app, days, staleTesters, mailEnabled)
 }
 
-func (c AppControllerWithValidation) PostDeleteAuthority(appId, authorityId int) revel.Result {
+// PostNudgeStaleTesters mails the testers in the report to install the latest bundle.
+func (c AppControllerWithValidation) PostNudgeStaleTesters(appId, days int) revel.Result {
 	app := c.App
 
-	authority, err := models.GetAuthority(Dbm, authorityId)
+	isDeveloper, err := c.isDeveloper(app)
 	if err != nil {
 		panic(err)
 	}
-
-	if appId != authority.AppId {
-		c.Flash.Error("Parameter is invalid.")
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
 		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
 	}
+	if Conf.Mailer == nil {
+		c.Flash.Error("Mail is not configured.")
+		return c.Redirect(routes.AppControllerWithValidation.GetStaleTesters(appId, days))
+	}
 
-	err = Transact(func(txn gorp.SqlExecutor) error {
-		return app.DeleteAuthority(txn, c.GoogleService, authority)
-	})
+	if days <= 0 {
+		days = models.StaleTesterDefaultDays
+	}
+	staleTesters, err := app.StaleTesters(Dbm, time.Now().AddDate(0, 0, -days))
 	if err != nil {
 		panic(err)
 	}
+	if len(staleTesters) == 0 {
+		c.Flash.Success("No tester to nudge.")
+		return c.Redirect(routes.AppControllerWithValidation.GetStaleTesters(appId, days))
+	}
 
-	if err := c.createAudit(models.ResourceAuthority, authority.Id, models.ActionDelete); err != nil {
+	appUrl, err := c.UriFor(fmt.Sprintf("app/%d", app.Id))
+	if err != nil {
+		panic(err)
+	}
+
+	subject := fmt.Sprintf("[alphawing] %s の最新バージョンをインストールしてください", app.Title)
+	body := fmt.Sprintf("%s のテストにご協力ください。最新バージョンは以下からインストールできます。\n\n%s\n", app.Title, appUrl)
+	// each tester is mailed alone, not to let the testers know the others' addresses
+	for _, staleTester := range staleTesters {
+		go sendMail([]string{staleTester.Authority.Email}, subject, body)
+	}
+
+	c.Flash.Success(fmt.Sprintf("Nudged %d testers!", len(staleTesters)))
+	return c.Redirect(routes.AppControllerWithValidation.GetStaleTesters(appId, days))
+}
+
+func (c AppControllerWithValidation) GetUpdateApp(appId int) revel.Result {
+	app := c.App
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+	// the forms of the credentials are shown to the admins only
+	isAdmin, err := c.isAdmin(app)
+	if err != nil {
+		panic(err)
+	}
+
+	firebaseEnabled := Conf.FirebaseProjectNumber != ""
+
+	appStoreConnectKey, err := models.GetAppStoreConnectKey(Dbm, appId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		appStoreConnectKey = &models.AppStoreConnectKey{}
+	}
+
+	playCredential, err := models.GetPlayCredential(Dbm, appId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		playCredential = &models.PlayCredential{}
+	}
+
+	browserStack, err := models.GetDeviceFarm(Dbm, appId, models.DeviceFarmProviderBrowserStack)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		browserStack = &models.DeviceFarm{Provider: models.DeviceFarmProviderBrowserStack}
+	}
+
+	testLab, err := models.GetDeviceFarm(Dbm, appId, models.DeviceFarmProviderTestLab)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		testLab = &models.DeviceFarm{Provider: models.DeviceFarmProviderTestLab}
+	}
+
+	return c.Render(app, firebaseEnabled, appStoreConnectKey, playCredential, browserStack, testLab, isAdmin)
+}
+
+func (c AppControllerWithValidation) PostUpdatePlayCredential(appId int, playCredential models.PlayCredential) revel.Result {
+	isAdmin, err := c.isAdmin(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isAdmin {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	playCredential.Validate(c.Validation)
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(appId))
+	}
+
+	playCredential.AppId = appId
+	if _, err := playCredential.ServiceAccountConfig(); err != nil {
+		c.Flash.Error(err.Error())
+		return c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(appId))
+	}
+	if err := playCredential.Seal(Conf.EnvFileKey); err != nil {
+		panic(err)
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return playCredential.Save(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Updated!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostUpdateAppStoreConnectKey(appId int, appStoreConnectKey models.AppStoreConnectKey) revel.Result {
+	isAdmin, err := c.isAdmin(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isAdmin {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	appStoreConnectKey.Validate(c.Validation)
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(appId))
+	}
+
+	appStoreConnectKey.AppId = appId
+	if _, err := appStoreConnectKey.Token(); err != nil {
+		c.Flash.Error(err.Error())
+		return c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(appId))
+	}
+	if err := appStoreConnectKey.Seal(Conf.EnvFileKey); err != nil {
+		panic(err)
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return appStoreConnectKey.Save(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Updated!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostUpdateApp(appId int, app models.App, icon *os.File) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	if appId != app.Id {
+		c.Flash.Error("Parameter is invalid.")
+		c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(app.Id))
+	}
+
+	var iconFilename string
+	if _, ok := c.Params.Files["icon"]; ok {
+		iconFilename = c.Params.Files["icon"][0].Filename
+	}
+	if icon != nil {
+		ext := strings.ToLower(filepath.Ext(iconFilename))
+		c.Validation.Required(ext == ".png" || ext == ".jpg" || ext == ".jpeg").Message("Icon must be a png or jpeg file.")
+	}
+
+	c.Validation.Required(app.Title).Message("Title is required.")
+	c.Validation.Required(app.Visibility.IsValid()).Message("Visibility is invalid.")
+	c.Validation.MaxSize(app.DescriptionTemplate, 255).Message("Description template is too long.")
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(app.Id))
+	}
+
+	// the Firebase App IDs are not in the form while firebase.projectnumber is unset, and are kept for when it is set again
+	if _, ok := c.Params.Values["app.FirebaseAndroidAppId"]; !ok {
+		app.FirebaseAndroidAppId = c.App.FirebaseAndroidAppId
+	}
+	if _, ok := c.Params.Values["app.FirebaseIosAppId"]; !ok {
+		app.FirebaseIosAppId = c.App.FirebaseIosAppId
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		if err := app.Update(txn); err != nil {
+			return err
+		}
+		if icon == nil {
+			return nil
+		}
+		return c.App.SetIcon(txn, c.GoogleService, icon, "icon"+filepath.Ext(iconFilename))
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.GoogleService.UpdateFileTitle(c.App.FileId, app.Title); err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Updated!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(app.Id))
+}
+
+func (c AppControllerWithValidation) PostRefreshToken(appId int, app models.App) revel.Result {
+	isAdmin, err := c.isAdmin(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isAdmin {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	if appId != app.Id {
+		c.Flash.Error("Parameter is invalid")
+		c.Redirect(routes.AppControllerWithValidation.GetApp(app.Id))
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return app.RefreshToken(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Refreshed!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(app.Id))
+}
+
+func (c AppControllerWithValidation) PostDeleteApp(appId int) revel.Result {
+	app := c.App
+	isAdmin, err := c.isAdmin(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isAdmin {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	err = models.TransactDeleting(Dbm, c.Storage, Conf.BundleArchive, func(txn gorp.SqlExecutor, storage models.Storage) error {
+		return app.Delete(txn, c.GoogleService, storage)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceApp, appId, models.ActionDelete); err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Deleted!")
+	return c.Redirect(routes.AlphaWingController.Index())
+}
+
+func (c AppControllerWithValidation) GetCreateBundle(appId int) revel.Result {
+	app := c.App
+	bundle := &models.Bundle{AppId: appId}
+	defaultLanguage := defaultChangelogLanguage()
+	translationLanguages := translationLanguages()
+	settings, err := currentSettings()
+	if err != nil {
+		panic(err)
+	}
+	// told to the script of the form, to refuse a large file before it is uploaded
+	uploadMaxSize := int64(settings.Int(models.SettingUploadMaxSizeMb)) * 1024 * 1024
+	return c.Render(app, bundle, defaultLanguage, translationLanguages, uploadMaxSize)
+}
+
+// PostCreateUploadSession starts the chunked upload of the form, which shows the progress and the info of the file.
+func (c AppControllerWithValidation) PostCreateUploadSession(appId int, filename string, size int64) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Response.Status = http.StatusForbidden
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{"Permission denied."}, nil))
+	}
+
+	return c.createUploadSession(c.App, filename, size)
+}
+
+func (c AppControllerWithValidation) PutAppendUploadSession(appId int, uploadId int, offset int64) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Response.Status = http.StatusForbidden
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{"Permission denied."}, nil))
+	}
+
+	session, err := models.GetUploadSession(Dbm, c.App, uploadId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{"Upload is not found."}, nil))
+	}
+	return c.appendUploadSession(c.App, session, offset)
+}
+
+// PostCreateBundle creates the bundle of the file of the form, or of the chunked upload of uploadId sent by the script of the form.
+func (c AppControllerWithValidation) PostCreateBundle(appId int, bundle models.Bundle, knownIssues string, file *os.File, provenance *os.File, uploadId int) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	if appId != bundle.AppId {
+		c.Flash.Error("Parameter is invalid.")
+		c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	var filename string
+	if _, ok := c.Params.Files["file"]; ok {
+		filename = c.Params.Files["file"][0].Filename
+	}
+	var session *models.UploadSession
+	if file == nil && uploadId != 0 {
+		var err error
+		session, err = models.GetUploadSession(Dbm, c.App, uploadId)
+		if err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+		if session != nil && session.Completed() {
+			file, err = session.Open(Conf.UploadSessionDir)
+			if err != nil {
+				panic(err)
+			}
+			defer file.Close()
+			filename = session.Filename
+		}
+	}
+	ext := models.BundleFileExtensionOf(filename)
+	isValidExt := ext.IsValid()
+
+	c.Validation.Required(file != nil).Message("File is required.")
+	c.Validation.Required(isValidExt).Message("File extension is not valid.")
+	c.Validation.Required(withinUploadLimit(file)).Message("File is too large.")
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.AppControllerWithValidation.GetCreateBundle(appId))
+	}
+
+	user, err := models.GetUser(Dbm, c.LoginUserId)
+	if err != nil {
+		panic(err)
+	}
+
+	bundle.File = file
+	bundle.PlatformType = ext.PlatformType()
+	bundle.Translations = c.changelogParams()
+	// the uploader is the member, whatever the form sends
+	bundle.UploadedBy = models.UserUploader(user)
+	bundle.CiJobUrl = ""
+	noteAppWrite(appId)
+	if err := c.App.CreateBundle(Dbm, c.Storage, &bundle); err != nil {
+		c.Flash.Error(c.diagnoseUpload(err).String())
+		return c.Redirect(routes.AppControllerWithValidation.GetCreateBundle(appId))
+	}
+	if session != nil {
+		if err := session.Delete(Dbm, Conf.UploadSessionDir); err != nil {
+			revel.ERROR.Printf("failed to delete upload %d: %s", session.Id, err)
+		}
+	}
+
+	if err := c.createAudit(models.ResourceBundle, bundle.Id, models.ActionCreate); err != nil {
+		panic(err)
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return bundle.AddKnownIssues(txn, models.ParseKnownIssues(knownIssues))
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.cachePlist(c.App, &bundle)
+	c.forwardBundle(c.App, &bundle)
+	c.analyzeBundle(c.App, &bundle)
+	c.pushBundle(c.App, &bundle)
+	c.notifyBundle(c.App, &bundle)
+
+	if provenance != nil {
+		p, err := attachProvenance(&bundle, provenance)
+		if err != nil {
+			panic(err)
+		}
+		if !p.Verified {
+			c.Flash.Error("Created, but " + p.Message)
+			return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundle.Id))
+		}
+	}
+
+	c.Flash.Success("Created!")
+	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundle.Id))
+}
+
+func (c AppControllerWithValidation) PostCreateAuthority(appId int, email string, role models.AuthorityRole) revel.Result {
+	app := c.App
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	c.Validation.Required(email).Message("Email is required.")
+	c.Validation.Email(email).Message("Email is invalid.")
+	c.Validation.Required(role.IsValid()).Message("Role is invalid.")
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	found, err := app.HasAuthorityForEmail(Dbm, email)
+	if err != nil {
+		panic(err)
+	}
+	c.Validation.Required(!found).Message(email + " is already registered.")
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	if role == models.AuthorityRoleAdmin {
+		isAdmin, err := c.isAdmin(app)
+		if err != nil {
+			panic(err)
+		}
+		if !isAdmin {
+			c.Flash.Error("Permission denied.")
+			return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+		}
+	}
+
+	authority := &models.Authority{
+		Email: email,
+		Role:  role,
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return app.CreateAuthority(txn, c.GoogleService, authority)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceAuthority, authority.Id, models.ActionCreate); err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Registered!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostUpdateAuthorityRole(appId, authorityId int, role models.AuthorityRole) revel.Result {
+	app := c.App
+
+	isAdmin, err := c.isAdmin(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isAdmin {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	authority, err := models.GetAuthority(Dbm, authorityId)
+	if err != nil {
+		panic(err)
+	}
+
+	if appId != authority.AppId || !role.IsValid() {
+		c.Flash.Error("Parameter is invalid.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	if role != models.AuthorityRoleAdmin {
+		isLastOwner, err := app.IsLastOwner(Dbm, authority)
+		if err != nil {
+			panic(err)
+		}
+		if isLastOwner {
+			c.Flash.Error("The last admin cannot be demoted. Add another admin first.")
+			return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+		}
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		authority.Role = role
+		return authority.Update(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceAuthority, authority.Id, models.ActionUpdate); err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Updated!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostDeleteAuthority(appId, authorityId int) revel.Result {
+	app := c.App
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	authority, err := models.GetAuthority(Dbm, authorityId)
+	if err != nil {
+		panic(err)
+	}
+
+	if appId != authority.AppId {
+		c.Flash.Error("Parameter is invalid.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	if authority.Role == models.AuthorityRoleAdmin {
+		isAdmin, err := c.isAdmin(app)
+		if err != nil {
+			panic(err)
+		}
+		if !isAdmin {
+			c.Flash.Error("Permission denied.")
+			return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+		}
+	}
+
+	isLastOwner, err := app.IsLastOwner(Dbm, authority)
+	if err != nil {
+		panic(err)
+	}
+	if isLastOwner {
+		c.Flash.Error("The last admin cannot be deleted. Add another admin first.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return app.DeleteAuthority(txn, c.GoogleService, authority)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceAuthority, authority.Id, models.ActionDelete); err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Deleted!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostApproveAccessRequest(appId, accessRequestId int) revel.Result {
+	app := c.App
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	accessRequest := c.pendingAccessRequest(accessRequestId)
+	if accessRequest == nil {
+		c.Flash.Error("Parameter is invalid.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	found, err := app.HasAuthorityForEmail(Dbm, accessRequest.Email)
+	if err != nil {
+		panic(err)
+	}
+
+	authority := &models.Authority{
+		Email: accessRequest.Email,
+		Role:  models.AuthorityRoleTester,
+	}
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		if !found {
+			if err := app.CreateAuthority(txn, c.GoogleService, authority); err != nil {
+				return err
+			}
+		}
+		accessRequest.State = models.AccessRequestStateApproved
+		return accessRequest.Update(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if !found {
+		if err := c.createAudit(models.ResourceAuthority, authority.Id, models.ActionCreate); err != nil {
+			panic(err)
+		}
+	}
+	if err := c.createAudit(models.ResourceAccessRequest, accessRequest.Id, models.ActionApprove); err != nil {
+		panic(err)
+	}
+
+	c.notifyAccessRequestResult(app, accessRequest)
+
+	c.Flash.Success("Approved!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostDenyAccessRequest(appId, accessRequestId int) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	app := c.App
+
+	accessRequest := c.pendingAccessRequest(accessRequestId)
+	if accessRequest == nil {
+		c.Flash.Error("Parameter is invalid.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		accessRequest.State = models.AccessRequestStateDenied
+		return accessRequest.Update(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceAccessRequest, accessRequest.Id, models.ActionDeny); err != nil {
+		panic(err)
+	}
+
+	c.notifyAccessRequestResult(app, accessRequest)
+
+	c.Flash.Success("Denied!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+// pendingAccessRequest returns nil unless the request is pending for the app.
+func (c *AppControllerWithValidation) pendingAccessRequest(accessRequestId int) *models.AccessRequest {
+	accessRequest, err := models.GetAccessRequest(Dbm, accessRequestId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		panic(err)
+	}
+	if accessRequest.AppId != c.App.Id || !accessRequest.IsPending() {
+		return nil
+	}
+	return accessRequest
+}
+
+func (c AppControllerWithValidation) PostCreateDeviceGroup(appId int, deviceGroup models.DeviceGroup) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	deviceGroup.Validate(c.Validation)
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	deviceGroup.AppId = appId
+	deviceGroup.MdmAppId = ""
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return deviceGroup.Save(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceDeviceGroup, deviceGroup.Id, models.ActionCreate); err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Registered!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostDeleteDeviceGroup(appId, deviceGroupId int) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	deviceGroup, err := models.GetDeviceGroup(Dbm, deviceGroupId)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+
+	if err == sql.ErrNoRows || appId != deviceGroup.AppId {
+		c.Flash.Error("Parameter is invalid.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return deviceGroup.DeleteFromDB(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceDeviceGroup, deviceGroup.Id, models.ActionDelete); err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Deleted!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostCreateReleasePlan(appId int, title, plannedOn string) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	plan := &models.ReleasePlan{
+		AppId:  appId,
+		Title:  title,
+		UserId: c.LoginUserId,
+	}
+	if t, err := time.ParseInLocation("2006-01-02", plannedOn, time.Local); err == nil {
+		plan.PlannedOn = t
+	}
+	plan.Validate(c.Validation)
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return plan.Save(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Registered!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostDeleteReleasePlan(appId, releasePlanId int) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	plan, err := models.GetReleasePlan(Dbm, releasePlanId)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	if err == sql.ErrNoRows || appId != plan.AppId {
+		c.Flash.Error("Parameter is invalid.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return plan.DeleteFromDB(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Deleted!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostCreateNotificationRoute(appId int, notificationRoute models.NotificationRoute) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	notificationRoute.Validate(c.Validation)
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	notificationRoute.AppId = appId
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return notificationRoute.Save(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Registered!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostDeleteNotificationRoute(appId, notificationRouteId int) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	notificationRoute, err := models.GetNotificationRoute(Dbm, notificationRouteId)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	if err == sql.ErrNoRows || appId != notificationRoute.AppId {
+		c.Flash.Error("Parameter is invalid.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return notificationRoute.DeleteFromDB(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Deleted!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+// PostCreateEnvFile encrypts the uploaded env file for the channel, replacing the one of the same name.
+func (c AppControllerWithValidation) PostCreateEnvFile(appId int, channel string, file *os.File) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	envFile := &models.EnvFile{AppId: appId, Channel: channel, UserId: c.LoginUserId}
+	if file != nil {
+		envFile.Name = filepath.Base(c.Params.Files["file"][0].Filename)
+		// one byte more than the limit tells that the file is too large
+		content, err := ioutil.ReadAll(io.LimitReader(file, models.EnvFileMaxSize+1))
+		if err != nil {
+			panic(err)
+		}
+		if err := envFile.Seal(Conf.EnvFileKey, content); err != nil {
+			panic(err)
+		}
+	}
+
+	envFile.Validate(c.Validation)
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return envFile.Save(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = c.createAudit(models.ResourceEnvFile, envFile.Id, models.ActionCreate)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Registered!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostDeleteEnvFile(appId, envFileId int) revel.Result {
+	isDeveloper, err := c.isDeveloper(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	envFile, err := models.GetEnvFile(Dbm, envFileId)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	if err == sql.ErrNoRows || appId != envFile.AppId {
+		c.Flash.Error("Parameter is invalid.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return envFile.DeleteFromDB(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = c.createAudit(models.ResourceEnvFile, envFile.Id, models.ActionDelete)
+	if err != nil {
 		panic(err)
 	}
 
@@ -311,7 +1492,7 @@ func (c *AppControllerWithValidation) CheckForbidden() revel.Result {
 	}
 
 	if _, err = s.GetFile(app.FileId); err != nil {
-		return c.Forbidden("Can't access the app.")
+		return c.renderRequestAccess(app)
 	}
 
 	return nil
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// checkArchived restores the archived bundle before the download, or renders the page to come back later
+// while the archive is restoring it. The page is not an error, not to be reported as one.
+func (c *AlphaWingController) checkArchived(bundle *models.Bundle) revel.Result {
+	if !bundle.IsArchived() {
+		return nil
+	}
+	if Conf.BundleArchive == nil {
+		panic("the bundle is archived but archive.backend is not configured")
+	}
+
+	blob, err := bundle.Blob(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	if blob != nil {
+		restored, err := models.RestoreBlob(Dbm, c.GoogleService, Conf.BundleArchive, blob)
+		if err != nil {
+			panic(err)
+		}
+		if restored {
+			restoredBundle, err := models.GetBundle(Dbm, bundle.Id)
+			if err != nil {
+				panic(err)
+			}
+			*bundle = *restoredBundle
+			return nil
+		}
+	}
+
+	c.InitRenderArgs()
+	c.RenderArgs["bundle"] = bundle
+	c.Response.Status = http.StatusAccepted
+	c.Response.Out.Header().Set("Retry-After", "3600")
+	return c.RenderTemplate("BundleControllerWithValidation/GetRestoring.html")
+}
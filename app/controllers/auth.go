@@ -3,6 +3,7 @@ package controllers
 import (
 	"net/url"
 
+	"github.com/kayac/alphawing/app/models"
 	"github.com/kayac/alphawing/app/routes"
 	"github.com/revel/revel"
 )
@@ -13,6 +14,13 @@ type AuthController struct {
 
 func (c *AuthController) CheckLogin() revel.Result {
 	if c.isLogin() {
+		// the user deactivated after the login is logged out on the next request
+		user, err := models.GetUser(Dbm, c.LoginUserId)
+		if err == nil && user.Deactivated {
+			c.logout()
+			c.Flash.Error("can't login with deactivated account")
+			return c.Redirect(routes.AlphaWingController.Index())
+		}
 		return nil
 	}
 
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// CheckAuthLockout refuses every request of the address locked out by the failed logins and the invalid tokens,
+// before the token is looked up, so that the tokens cannot be guessed by brute force.
+func (c *AlphaWingController) CheckAuthLockout() revel.Result {
+	lockout, err := models.AuthLockout(Dbm, "addr:"+c.clientAddr(), Conf.AuthFailureLimit, time.Now())
+	if err != nil {
+		panic(err)
+	}
+	if lockout <= 0 {
+		return nil
+	}
+
+	c.Response.Out.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(lockout.Seconds()))))
+	c.Response.Status = http.StatusTooManyRequests
+	return c.RenderText("Too many failed attempts. Please try again later.")
+}
+
+// RecordAuthFailure counts the 401 of an invalid token toward the lockout of the address, and the 403 toward the alert
+// of the user or the address probing the pages of the apps it has no access to.
+func (c *AlphaWingController) RecordAuthFailure() revel.Result {
+	if c.Result == nil {
+		return nil
+	}
+	switch c.Response.Status {
+	case http.StatusUnauthorized:
+		c.recordAuthFailure("addr:"+c.clientAddr(), models.AuthFailureKindToken, Conf.AuthFailureLimit)
+	case http.StatusForbidden:
+		c.recordAuthFailure(c.bandwidthSubject(), models.AuthFailureKindForbidden, Conf.ForbiddenAlertLimit)
+	}
+	return nil
+}
+
+// recordLoginFailure counts the failed login toward the lockout of the address.
+func (c *AlphaWingController) recordLoginFailure() {
+	c.recordAuthFailure("addr:"+c.clientAddr(), models.AuthFailureKindLogin, Conf.AuthFailureLimit)
+}
+
+// recordAuthFailure alerts the admins once, when the failures of the subject reach the limit in the window.
+func (c *AlphaWingController) recordAuthFailure(subject, kind string, limit int) {
+	if limit <= 0 {
+		return
+	}
+
+	var count int
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		var err error
+		count, err = models.RecordAuthFailure(txn, subject, kind)
+		return err
+	})
+	if err != nil {
+		revel.ERROR.Printf("failed to record auth failure of %s: %s", subject, err)
+		return
+	}
+	if count != limit {
+		return
+	}
+
+	var text string
+	switch kind {
+	case models.AuthFailureKindForbidden:
+		text = fmt.Sprintf("alphawing で %s が%d分間に%d回アクセスを拒否されました。(最後: %s)", subject, int(models.AuthFailureWindow.Minutes()), count, c.Request.URL.Path)
+	default:
+		text = fmt.Sprintf("alphawing で %s がログインまたはトークンの認証に%d分間に%d回失敗したため、ロックしました。(最後: %s)", subject, int(models.AuthFailureWindow.Minutes()), count, c.Request.URL.Path)
+	}
+	notifySecurityAlert("[alphawing] 不審なアクセスを検知しました", text)
+}
+
+// checkTokenCountry alerts the admins when the token is used from a country it has never been used from,
+// e.g. a leaked CI token, told by the header of the CDN or the load balancer in security.countryheader.
+func (c *AlphaWingController) checkTokenCountry(tokenKind string, tokenId int, tokenName string) {
+	if Conf.CountryHeader == "" {
+		return
+	}
+	country := c.Request.Header.Get(Conf.CountryHeader)
+	if country == "" {
+		return
+	}
+
+	var isNew bool
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		var err error
+		isNew, err = models.RecordTokenCountry(txn, tokenKind, tokenId, country)
+		return err
+	})
+	if err != nil {
+		revel.ERROR.Printf("failed to record country of %s token %d: %s", tokenKind, tokenId, err)
+		return
+	}
+	if !isNew {
+		return
+	}
+
+	text := fmt.Sprintf("alphawing の%sが初めて %s から使われました。(%s, %s)\n心当たりがなければトークンを再発行してください。", tokenName, country, c.clientAddr(), c.Request.URL.Path)
+	notifySecurityAlert("[alphawing] トークンが新しい国から使われました", text)
+}
+
+// appByApiToken returns the app of the API token, checking the country the token is used from and counting its usage.
+func (c *AlphaWingController) appByApiToken(token string) (*models.App, error) {
+	app, err := models.GetAppByApiToken(Dbm, token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.useRevokedToken(token)
+		}
+		return nil, err
+	}
+	c.checkTokenCountry(models.TokenKindApi, app.Id, fmt.Sprintf("%s のAPIトークン", app.Title))
+	c.useToken(models.TokenKindApi, app.Id, token)
+	return app, nil
+}
+
+// notifySecurityAlert mails the admins and posts to Slack.
+func notifySecurityAlert(subject, text string) {
+	revel.WARN.Printf("security alert: %s", text)
+	go postSlack(text)
+
+	if Conf.Mailer == nil || len(Conf.Admins) == 0 {
+		return
+	}
+	go sendMail(Conf.Admins, subject, text+"\n")
+}
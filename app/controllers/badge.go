@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// the label of the badges is kept short to fit a line of a README
+const badgeMaxLabelLength = 40
+
+// the badges requested by each client address in the current minute
+var badgeRequests = struct {
+	sync.Mutex
+	minute time.Time
+	counts map[string]int
+}{counts: map[string]int{}}
+
+// GetBadge draws the latest bundle of the platform, android by default, as an SVG badge for the READMEs and the wikis,
+// with the badge token of the app in the URL instead of a login or the API token.
+func (c ApiController) GetBadge(appId int, token, platform, variant, label string) revel.Result {
+	if result := c.checkBadgeRate(); result != nil {
+		return result
+	}
+
+	// the app which is not found is not told from the wrong token
+	app, err := models.GetApp(Dbm, appId)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	if app == nil || !models.IsValidBadgeToken(Conf.Secret, app, token) {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderText("Token is invalid.")
+	}
+
+	var platformType models.BundlePlatformType
+	switch platform {
+	case "", "android":
+		platformType = models.BundlePlatformTypeAndroid
+	case "ios":
+		platformType = models.BundlePlatformTypeIOS
+	case "macos":
+		platformType = models.BundlePlatformTypeMacOS
+	case "generic":
+		platformType = models.BundlePlatformTypeGeneric
+	default:
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderText("Platform must be android, ios, macos or generic.")
+	}
+	if label == "" {
+		label = "latest internal build"
+	}
+	if utf8.RuneCountInString(label) > badgeMaxLabelLength {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderText(fmt.Sprintf("Label must be at most %d characters.", badgeMaxLabelLength))
+	}
+
+	svg, err := app.LatestBundleBadge(readDbm(app.Id), platformType, variant, label)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Response.Out.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(models.BadgeCacheDuration.Seconds())))
+	c.Response.ContentType = "image/svg+xml"
+	return c.RenderBinary(bytes.NewReader(svg), fmt.Sprintf("app_%d_badge.svg", app.Id), revel.Inline, time.Now())
+}
+
+// checkBadgeRate refuses the badges over badge.ratelimit a minute of the client address, as they are fetched without a login.
+func (c *AlphaWingController) checkBadgeRate() revel.Result {
+	if Conf.BadgeRateLimit <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	minute := now.Truncate(time.Minute)
+	addr := c.clientAddr()
+	badgeRequests.Lock()
+	if !badgeRequests.minute.Equal(minute) {
+		badgeRequests.minute = minute
+		badgeRequests.counts = map[string]int{}
+	}
+	badgeRequests.counts[addr]++
+	count := badgeRequests.counts[addr]
+	badgeRequests.Unlock()
+	if count <= Conf.BadgeRateLimit {
+		return nil
+	}
+
+	c.Response.Out.Header().Set("Retry-After", fmt.Sprint(int(minute.Add(time.Minute).Sub(now).Seconds())+1))
+	c.Response.Status = http.StatusTooManyRequests
+	return c.RenderText("Too many requests of the badges. Please try again later.")
+}
+
+// badgeUrl returns the URL of the badge of the latest bundle of the platform to embed, with the badge token of the app.
+func (c *AlphaWingController) badgeUrl(app *models.App, platform string) (string, error) {
+	u, err := c.UriFor(fmt.Sprintf("api/badge/%d", app.Id))
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("token", models.BadgeToken(Conf.Secret, app))
+	query.Set("platform", platform)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
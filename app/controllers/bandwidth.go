@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// bandwidthSubject identifies whom the download is accounted to: the login user, or the client address otherwise.
+func (c *AlphaWingController) bandwidthSubject() string {
+	if c.LoginUserId != 0 {
+		return fmt.Sprintf("user:%d", c.LoginUserId)
+	}
+	return "addr:" + c.clientAddr()
+}
+
+// clientAddr returns the address of the client. Behind the proxies of security.trustedproxies, it is the right-most
+// address of X-Forwarded-For which is not of a proxy, as the ones on its left are sent by the client.
+func (c *AlphaWingController) clientAddr() string {
+	addr := c.Request.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if !isTrustedProxy(addr) {
+		return addr
+	}
+	hops := strings.Split(strings.Join(c.Request.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		addr = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return addr
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range Conf.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBandwidth refuses the download before it is fetched from Google Drive, once the subject has used up the daily limit.
+func (c *AlphaWingController) checkBandwidth() revel.Result {
+	if Conf.BandwidthDailyLimit <= 0 {
+		return nil
+	}
+
+	bytes, err := models.GetBandwidthBytes(Dbm, c.bandwidthSubject(), time.Now())
+	if err != nil {
+		panic(err)
+	}
+	if bytes < Conf.BandwidthDailyLimit {
+		return nil
+	}
+
+	c.Response.Status = http.StatusTooManyRequests
+	return c.RenderText("The daily download limit is exceeded. Please try again tomorrow.")
+}
+
+// meterBandwidth throttles the download to the configured rate and records the bytes served.
+func (c *AlphaWingController) meterBandwidth(r io.ReadCloser) io.ReadCloser {
+	subject := c.bandwidthSubject()
+	return &models.MeteredReader{
+		Reader:      r,
+		BytesPerSec: Conf.BandwidthRate,
+		OnClose: func(bytes int64) {
+			err := Transact(func(txn gorp.SqlExecutor) error {
+				return models.AddBandwidthUsage(txn, subject, time.Now(), bytes)
+			})
+			if err != nil {
+				revel.ERROR.Printf("failed to record bandwidth usage of %s: %s", subject, err)
+			}
+		},
+	}
+}
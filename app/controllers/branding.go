@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// BrandingFilter sets the logo, the organization name and the contact of the deployment for every page,
+// including the error pages which are rendered without the interceptors.
+var BrandingFilter = func(c *revel.Controller, fc []revel.Filter) {
+	if !strings.HasPrefix(c.Request.URL.Path, "/static/") {
+		settings, err := currentSettings()
+		if err != nil {
+			// the error pages are still branded by app.conf while the database is down
+			settings = settingDefaults()
+		}
+		c.RenderArgs["organizationName"] = settings[models.SettingOrganizationName]
+		c.RenderArgs["logoUrl"] = settings[models.SettingLogoUrl]
+		c.RenderArgs["contactUrl"] = settings[models.SettingContactUrl]
+	}
+
+	fc[0](c, fc[1:])
+}
+
+// CheckMaintenance shows the maintenance page while the message is set in the settings,
+// except to the admins, to the login and to the admin API with the admin token, so that the admins can end it.
+func (c *AlphaWingController) CheckMaintenance() revel.Result {
+	settings, err := currentSettings()
+	if err != nil {
+		panic(err)
+	}
+	message := settings[models.SettingMaintenanceMessage]
+	if message == "" {
+		return nil
+	}
+	if c.Name == "AlphaWingController" && (c.MethodName == "GetLogin" || c.MethodName == "GetCallback" || c.MethodName == "GetLogout") {
+		return nil
+	}
+	if c.Name == "AdminApiController" && isAdminApiToken(strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")) {
+		return nil
+	}
+	if userId, err := strconv.Atoi(c.Session[LoginSessionKey]); err == nil {
+		user, err := models.GetUser(Dbm, userId)
+		if err == nil && isAdminEmail(user.Email) {
+			return nil
+		}
+	}
+
+	// the maintenance is not an error to report
+	c.Args[errorReportedKey] = true
+	c.Response.Status = http.StatusServiceUnavailable
+	c.Response.Out.Header().Set("Retry-After", "600")
+	if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+		return c.RenderJson(&JsonResponse{
+			Status:  http.StatusServiceUnavailable,
+			Message: []string{message},
+		})
+	}
+	c.RenderArgs["maintenanceMessage"] = message
+	return c.RenderTemplate("errors/503.html")
+}
@@ -1,10 +1,11 @@
 package controllers
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
+	"net/url"
 	"strconv"
-	"time"
 
 	"github.com/kayac/alphawing/app/models"
 	"github.com/kayac/alphawing/app/routes"
@@ -43,18 +44,273 @@ func (c BundleControllerWithValidation) GetBundle(bundleId int) revel.Result {
 		panic(err)
 	}
 
-	return c.Render(bundle, app, installUrl)
+	deviceGroups, err := app.DeviceGroups(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	mdmEnabled := Conf.MdmProvider != ""
+
+	_, err = models.GetAppStoreConnectKey(Dbm, app.Id)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	testFlightEnabled := err == nil
+	testFlightSubmission, err := bundle.LatestTestFlightSubmission(Dbm)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = models.GetPlayCredential(Dbm, app.Id)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	playEnabled := err == nil
+	playSubmission, err := bundle.LatestPlaySubmission(Dbm)
+	if err != nil {
+		panic(err)
+	}
+
+	deviceFarms, err := app.DeviceFarms(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	deviceFarmRuns, err := bundle.DeviceFarmRuns(Dbm)
+	if err != nil {
+		panic(err)
+	}
+
+	provenance, err := bundle.LatestProvenance(Dbm)
+	if err != nil {
+		panic(err)
+	}
+
+	signingEnabled := Conf.BundleSigner != nil
+
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+
+	// the recall notice replaces the install page, with the way to lift it for the developers
+	if bundle.IsRecalled() {
+		c.RenderArgs["app"] = app
+		c.RenderArgs["isDeveloper"] = isDeveloper
+		return c.checkRecalled(bundle)
+	}
+
+	var replacements []*models.Bundle
+	if isDeveloper {
+		bundles, err := app.BundlesByPlatformType(Dbm, bundle.PlatformType, "")
+		if err != nil {
+			panic(err)
+		}
+		for _, b := range bundles {
+			if b.Id != bundle.Id && !b.IsRecalled() {
+				replacements = append(replacements, b)
+			}
+		}
+	}
+
+	knownIssues, err := bundle.KnownIssues(Dbm)
+	if err != nil {
+		panic(err)
+	}
+
+	doc, err := app.DocRevision(Dbm, bundle.DocRevision)
+	if err != nil {
+		panic(err)
+	}
+
+	// the plist URL to paste into the tools which install through itms-services, signed as the download page does
+	var plistUrl *url.URL
+	if bundle.IsIpa() {
+		plistUrl, err = c.LimitedTimeUriFor(fmt.Sprintf("bundle/%d/download_plist", bundle.Id))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// the install link for the testers without a login, signed longer than the downloads
+	var shareUrl string
+	shareLinkHours := Conf.ShareLinkHours
+	if isDeveloper && Conf.ShareLinkHours > 0 && !bundle.IsRecalled() {
+		if bundle.IsIpa() {
+			u, err := c.shareLimitedTimeUriFor(fmt.Sprintf("bundle/%d/download_plist", bundle.Id), bundle)
+			if err != nil {
+				panic(err)
+			}
+			shareUrl = "itms-services://?action=download-manifest&url=" + url.QueryEscape(u.String())
+		} else if bundle.IsApk() {
+			u, err := c.shareLimitedTimeUriFor(fmt.Sprintf("bundle/%d/download_limited_apk", bundle.Id), bundle)
+			if err != nil {
+				panic(err)
+			}
+			shareUrl = u.String()
+		} else if bundle.IsPlainDownload() {
+			u, err := c.shareLimitedTimeUriFor(fmt.Sprintf("bundle/%d/download_limited_file", bundle.Id), bundle)
+			if err != nil {
+				panic(err)
+			}
+			shareUrl = u.String()
+		}
+	}
+
+	// the previous bundle may have been deleted since, with its icon
+	var iconChangedFrom *models.Bundle
+	if bundle.IconChanged() {
+		iconChangedFrom, err = models.GetBundle(Dbm, bundle.IconChangedFrom)
+		if err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+	}
+
+	var signerChangedFrom *models.Bundle
+	if bundle.SignerChanged() {
+		signerChangedFrom, err = models.GetBundle(Dbm, bundle.SignerChangedFrom)
+		if err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+	}
+
+	analysis, err := bundle.Analysis(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	profileExpiring := warnsProfileExpiry(bundle)
+
+	// the changelog in the language of the viewer, with the links to the other languages
+	changelogs, err := bundle.ChangelogTexts(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	description, descriptionLanguage := bundle.LocalizedDescription(changelogs, c.preferredLanguages(), defaultChangelogLanguage())
+	otherLanguages := otherChangelogLanguages(bundle, changelogs, descriptionLanguage)
+
+	envFiles, err := bundle.EnvFiles(Dbm)
+	if err != nil {
+		panic(err)
+	}
+
+	// the download chooses the split of the device by the client hints, which are only sent when asked for
+	splits, err := bundle.Splits(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	if len(splits) > 0 {
+		c.Response.Out.Header().Set("Accept-CH", splitClientHints)
+	}
+
+	var dsyms []*models.BundleDsym
+	var mapping *models.BundleMapping
+	if isDeveloper {
+		dsyms, err = bundle.Dsyms(Dbm)
+		if err != nil {
+			panic(err)
+		}
+		mapping, err = bundle.Mapping(Dbm)
+		if err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+	}
+
+	return c.Render(bundle, app, installUrl, deviceGroups, mdmEnabled, testFlightEnabled, testFlightSubmission, playEnabled, playSubmission, deviceFarms, deviceFarmRuns, provenance, signingEnabled, isDeveloper, knownIssues, doc, replacements, iconChangedFrom, signerChangedFrom, plistUrl, shareUrl, shareLinkHours, analysis, profileExpiring, description, descriptionLanguage, otherLanguages, envFiles, splits, dsyms, mapping)
 }
 
 func (c BundleControllerWithValidation) GetUpdateBundle(bundleId int) revel.Result {
 	bundle := c.Bundle
-	return c.Render(bundle)
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	docRevisions, err := app.DocRevisions(Dbm)
+	if err != nil {
+		panic(err)
+	}
+
+	changelogs, err := bundle.ChangelogTexts(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	defaultLanguage := defaultChangelogLanguage()
+	translationLanguages := translationLanguages()
+
+	return c.Render(bundle, isDeveloper, docRevisions, changelogs, defaultLanguage, translationLanguages)
+}
+
+// GetVerify checks the checksum of the installed build against the bundle, to confirm whether a tester runs the build.
+// The checksum is computed on the device by the page, so the file is not uploaded.
+func (c BundleControllerWithValidation) GetVerify(bundleId int, digest string) revel.Result {
+	bundle := c.Bundle
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+
+	var verification *models.BundleVerification
+	if digest != "" {
+		normalized := models.NormalizeDigest(digest)
+		if normalized == "" {
+			c.Flash.Error("Checksum must be the SHA-256 in hex.")
+			return c.Redirect(routes.BundleControllerWithValidation.GetVerify(bundleId, ""))
+		}
+		verification, err = bundle.Verify(Dbm, normalized)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return c.Render(bundle, app, digest, verification)
 }
 
 func (c BundleControllerWithValidation) PostUpdateBundle(bundleId int, bundle models.Bundle) revel.Result {
 	bundle_for_update := c.Bundle
-	err := Transact(func(txn gorp.SqlExecutor) error {
+
+	app, err := bundle_for_update.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	if bundle.DocRevision != 0 {
+		doc, err := app.DocRevision(Dbm, bundle.DocRevision)
+		if err != nil {
+			panic(err)
+		}
+		if doc == nil {
+			c.Flash.Error("Document revision is not found.")
+			return c.Redirect(routes.BundleControllerWithValidation.GetUpdateBundle(bundleId))
+		}
+	}
+
+	noteAppWrite(bundle_for_update.AppId)
+	err = Transact(func(txn gorp.SqlExecutor) error {
 		bundle_for_update.Description = bundle.Description
+		bundle_for_update.Channel = bundle.Channel
+		bundle_for_update.Tags = bundle.Tags
+		bundle_for_update.Variant = bundle.Variant
+		bundle_for_update.InternalNotes = bundle.InternalNotes
+		bundle_for_update.DocRevision = bundle.DocRevision
+		if err := bundle_for_update.SaveChangelogs(txn, c.changelogParams()); err != nil {
+			return err
+		}
 		return bundle_for_update.Update(txn)
 	})
 	if err != nil {
@@ -65,10 +321,213 @@ func (c BundleControllerWithValidation) PostUpdateBundle(bundleId int, bundle mo
 	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundle_for_update.Id))
 }
 
+func (c BundleControllerWithValidation) PostCreateKnownIssue(bundleId int, title string) revel.Result {
+	bundle := c.Bundle
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	c.Validation.Required(title).Message("Title is required.")
+	c.Validation.MaxSize(title, 255).Message("Title is too long.")
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	issue := &models.KnownIssue{
+		BundleId: bundle.Id,
+		Title:    title,
+	}
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return issue.Save(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.notifyKnownIssue(app, bundle, issue)
+
+	c.Flash.Success("Created!")
+	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+}
+
+func (c BundleControllerWithValidation) PostDeleteKnownIssue(bundleId, knownIssueId int) revel.Result {
+	bundle := c.Bundle
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	issue, err := models.GetKnownIssue(Dbm, knownIssueId)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	if err == sql.ErrNoRows || issue.BundleId != bundle.Id {
+		c.Flash.Error("Parameter is invalid.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return issue.DeleteFromDB(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Deleted!")
+	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+}
+
+// PostRecall disables every download of the bundle at once, and suggests the replacement to install instead.
+func (c BundleControllerWithValidation) PostRecall(bundleId int, reason string, replacementId int) revel.Result {
+	bundle := c.Bundle
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	c.Validation.Required(reason).Message("Reason is required.")
+	c.Validation.MaxSize(reason, 1000).Message("Reason is too long.")
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	noteAppWrite(bundle.AppId)
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return bundle.Recall(txn, reason, replacementId)
+	})
+	if err != nil {
+		if err == models.ErrRecallReplacementInvalid {
+			c.Flash.Error(err.Error())
+			return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+		}
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceBundle, bundleId, models.ActionRecall); err != nil {
+		panic(err)
+	}
+	c.notifyRecall(app, bundle)
+
+	c.Flash.Success("Recalled!")
+	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+}
+
+func (c BundleControllerWithValidation) PostLiftRecall(bundleId int) revel.Result {
+	bundle := c.Bundle
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	noteAppWrite(bundle.AppId)
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return bundle.LiftRecall(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceBundle, bundleId, models.ActionLiftRecall); err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Recall is lifted!")
+	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+}
+
+// PostRevokeShareLinks stops the share links of the bundle passed around, e.g. to the testers who left the project.
+func (c BundleControllerWithValidation) PostRevokeShareLinks(bundleId int) revel.Result {
+	bundle := c.Bundle
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	noteAppWrite(bundle.AppId)
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return bundle.RevokeShareLinks(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceBundle, bundleId, models.ActionUpdate); err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Share links are revoked!")
+	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+}
+
 func (c BundleControllerWithValidation) PostDeleteBundle(bundleId int) revel.Result {
 	bundle := c.Bundle
-	err := Transact(func(txn gorp.SqlExecutor) error {
-		return bundle.Delete(txn, c.GoogleService)
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	noteAppWrite(bundle.AppId)
+	err = models.TransactDeleting(Dbm, c.Storage, Conf.BundleArchive, func(txn gorp.SqlExecutor, storage models.Storage) error {
+		return bundle.Delete(txn, storage)
 	})
 	if err != nil {
 		panic(err)
@@ -83,39 +542,272 @@ func (c BundleControllerWithValidation) PostDeleteBundle(bundleId int) revel.Res
 }
 
 func (c BundleControllerWithValidation) GetDownloadBundle(bundleId int) revel.Result {
+	if result := c.checkRecalled(c.Bundle); result != nil {
+		return result
+	}
+	if result := c.checkArchived(c.Bundle); result != nil {
+		return result
+	}
 	bundle := c.Bundle
 
-	plistUrl, err := c.UriFor(fmt.Sprintf("bundle/%d/download_plist", bundle.Id))
+	plistUrl, err := c.LimitedTimeUriFor(fmt.Sprintf("bundle/%d/download_plist", bundle.Id))
 	if err != nil {
 		panic(err)
 	}
 
-	signatureInfo := models.NewLimitedTimeSignatureInfo(plistUrl.Host, plistUrl.Path)
-	signatureInfo.RefreshSignature(Conf.Secret)
-
-	plistUrl.RawQuery = signatureInfo.UrlValues().Encode()
-
 	return c.Render(plistUrl)
 }
 
 func (c BundleControllerWithValidation) GetDownloadApk(bundleId int) revel.Result {
-	resp, file, err := c.GoogleService.DownloadFile(c.Bundle.FileId)
+	if result := c.checkBandwidth(); result != nil {
+		return result
+	}
+	if result := c.checkRecalled(c.Bundle); result != nil {
+		return result
+	}
+	if result := c.checkArchived(c.Bundle); result != nil {
+		return result
+	}
+	if result := c.checkUniversalApk(c.Bundle); result != nil {
+		return result
+	}
+
+	err := c.createAudit(models.ResourceBundle, bundleId, models.ActionDownload)
+	if err != nil {
+		panic(err)
+	}
+
+	return c.renderApk(c.Bundle)
+}
+
+func (c BundleControllerWithValidation) GetDownloadSignature(bundleId int) revel.Result {
+	if Conf.BundleSigner == nil {
+		return c.NotFound("Signing is not enabled.")
+	}
+	if result := c.checkArchived(c.Bundle); result != nil {
+		return result
+	}
+
+	signature, err := signBundle(c.Storage, c.Bundle)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Response.Out.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=bundle_%d%s.sig", c.Bundle.Id, c.Bundle.Extension()))
+	return c.RenderText(signature)
+}
+
+// GetDownloadEnvFile serves the env file of the channel of the bundle decrypted, logging the download.
+func (c BundleControllerWithValidation) GetDownloadEnvFile(bundleId, envFileId int) revel.Result {
+	envFile, err := models.GetEnvFile(Dbm, envFileId)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	if err == sql.ErrNoRows || !envFile.Matches(c.Bundle) {
+		return c.NotFound("Env file is not found.")
+	}
+
+	content, err := envFile.Open(Conf.EnvFileKey)
+	if err != nil {
+		panic(err)
+	}
+
+	err = c.createAudit(models.ResourceEnvFile, envFile.Id, models.ActionDownload)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Response.Out.Header().Set("Cache-Control", "no-store")
+	c.Response.ContentType = "application/octet-stream"
+	return c.RenderBinary(bytes.NewReader(content), envFile.Name, revel.Attachment, envFile.UpdatedAt)
+}
+
+func (c BundleControllerWithValidation) PostPushInstall(bundleId, deviceGroupId int) revel.Result {
+	bundle := c.Bundle
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	if !bundle.IsIpa() {
+		c.Flash.Error("Only ipa files can be installed through MDM.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+	if result := c.checkRecalled(c.Bundle); result != nil {
+		return result
+	}
+	if result := c.checkArchived(c.Bundle); result != nil {
+		return result
+	}
+
+	provider, err := models.NewMdmProvider(Conf.MdmProvider, Conf.MdmApiKey)
+	if err != nil {
+		c.Flash.Error(err.Error())
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	deviceGroup, err := models.GetDeviceGroup(Dbm, deviceGroupId)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	if err == sql.ErrNoRows || deviceGroup.AppId != bundle.AppId {
+		c.Flash.Error("Parameter is invalid.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	object, err := c.Storage.Get(bundle.FileId)
+	if err != nil {
+		panic(err)
+	}
+	defer object.Body.Close()
+
+	mdmAppId, err := provider.PushInstall(deviceGroup, object.Name, object.Body)
+	if err != nil {
+		if mdmErr, ok := err.(*models.MdmError); ok {
+			c.Flash.Error(mdmErr.Error())
+			return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+		}
+		panic(err)
+	}
+
+	if deviceGroup.MdmAppId != mdmAppId {
+		deviceGroup.MdmAppId = mdmAppId
+		err = Transact(func(txn gorp.SqlExecutor) error {
+			return deviceGroup.Update(txn)
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if err := c.createAudit(models.ResourceBundle, bundleId, models.ActionPushInstall); err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Pushed!")
+	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+}
+
+func (c BundleControllerWithValidation) PostSubmitTestFlight(bundleId int) revel.Result {
+	bundle := c.Bundle
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	if bundle.IsRecalled() {
+		c.Flash.Error("Recalled bundles cannot be submitted to TestFlight.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	if !bundle.IsIpa() {
+		c.Flash.Error("Only ipa files can be submitted to TestFlight.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	key, err := getAppStoreConnectKey(bundle.AppId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Flash.Error("App Store Connect API key is not registered.")
+			return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+		}
+		panic(err)
+	}
+
+	submission := &models.TestFlightSubmission{
+		BundleId: bundle.Id,
+		State:    models.TestFlightStateUploading,
+	}
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return submission.Save(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceBundle, bundleId, models.ActionSubmitTestFlight); err != nil {
+		panic(err)
+	}
+
+	// uploading a large ipa takes a while
+	go submitToTestFlight(c.Storage, key, bundle, submission)
+
+	c.Flash.Success("Submitting!")
+	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+}
+
+func (c BundleControllerWithValidation) PostPublishPlay(bundleId int) revel.Result {
+	bundle := c.Bundle
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
 	if err != nil {
 		panic(err)
 	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	if bundle.IsRecalled() {
+		c.Flash.Error("Recalled bundles cannot be published to Google Play.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
 
-	modtime, err := time.Parse(time.RFC3339, file.ModifiedDate)
+	if !bundle.IsApk() {
+		c.Flash.Error("Only apk files can be published to Google Play.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	credential, err := getPlayCredential(bundle.AppId)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Flash.Error("Google Play service account key is not registered.")
+			return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+		}
 		panic(err)
 	}
 
-	err = c.createAudit(models.ResourceBundle, bundleId, models.ActionDownload)
+	submission := &models.PlaySubmission{
+		BundleId: bundle.Id,
+		State:    models.PlayStateUploading,
+	}
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return submission.Save(txn)
+	})
 	if err != nil {
 		panic(err)
 	}
 
-	c.Response.ContentType = "application/vnd.android.package-archive"
-	return c.RenderBinary(resp.Body, file.OriginalFilename, revel.Attachment, modtime)
+	if err := c.createAudit(models.ResourceBundle, bundleId, models.ActionPublishPlay); err != nil {
+		panic(err)
+	}
+
+	go publishToPlay(c.Storage, credential, bundle, submission)
+
+	c.Flash.Success("Publishing!")
+	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
 }
 
 func (c *BundleControllerWithValidation) CheckNotFound() revel.Result {
@@ -152,13 +844,19 @@ func (c *BundleControllerWithValidation) CheckForbidden() revel.Result {
 		return c.NotFound("Bundle is not found.")
 	}
 
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+
 	s, err := c.userGoogleService()
 	if err != nil {
 		panic(err)
 	}
 
-	if _, err = s.GetFile(bundle.FileId); err != nil {
-		return c.Forbidden("Can't access the bundle.")
+	// bundle files may be shared between apps, so check the app folder
+	if _, err = s.GetFile(app.FileId); err != nil {
+		return c.renderRequestAccess(app)
 	}
 
 	return nil
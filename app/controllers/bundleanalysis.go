@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"os"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// analyzeBundle reads the metadata of the whole bundle file in the background, records it with the analyze event,
+// and posts it to the webhook, so that the policy bots check the analyzed build rather than the upload.
+func (c *AlphaWingController) analyzeBundle(app *models.App, bundle *models.Bundle) {
+	// the URLs of the bundle are of the host of the request
+	bundleJsonResponse, err := bundle.JsonResponse(c)
+	if err != nil {
+		revel.ERROR.Printf("failed to analyze bundle %d: %s", bundle.Id, err)
+		return
+	}
+
+	storage := c.Storage
+	go func() {
+		analysis, err := analyzeBundleFile(storage, bundle)
+		if err != nil {
+			revel.ERROR.Printf("failed to analyze bundle %d: %s", bundle.Id, err)
+			return
+		}
+		if err := postBundleAnalyzed(app, bundle, bundleJsonResponse, analysis); err != nil {
+			revel.ERROR.Printf("failed to post the analysis of bundle %d to the webhook: %s", bundle.Id, err)
+		}
+	}()
+}
+
+// warnsProfileExpiry tells whether the provisioning profile of the ipa expires within profile.warningdays.
+func warnsProfileExpiry(bundle *models.Bundle) bool {
+	return Conf.ProfileWarningDays > 0 && bundle.ProfileExpiresWithin(Conf.ProfileWarningDays)
+}
+
+func analyzeBundleFile(storage models.Storage, bundle *models.Bundle) (*models.BundleAnalysis, error) {
+	// the uploaded temporary file is removed after the request, so read it back from the storage
+	object, err := storage.Get(bundle.FileId)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Body.Close()
+
+	file, err := spoolUpload(object.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	analysis, err := models.AnalyzeBundle(file, bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		if err := analysis.Save(txn); err != nil {
+			return err
+		}
+		return models.RecordEvent(txn, models.EventResourceBundle, bundle.Id, bundle.AppId, models.EventActionAnalyze)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return analysis, nil
+}
+
+func postBundleAnalyzed(app *models.App, bundle *models.Bundle, bundleJsonResponse *models.BundleJsonResponse, analysis *models.BundleAnalysis) error {
+	settings, err := currentSettings()
+	if err != nil {
+		return err
+	}
+	webhookUrl := settings[models.SettingWebhookUrl]
+	if webhookUrl == "" {
+		return nil
+	}
+
+	payload := &models.BundleAnalyzedPayload{
+		Event:       models.WebhookEventBundleAnalyzed,
+		AppId:       app.Id,
+		BundleId:    bundle.Id,
+		Bundle:      bundleJsonResponse,
+		Analysis:    analysis.JsonResponse(),
+		DeliveredAt: time.Now().Format(time.RFC3339),
+	}
+	return deliverWebhook(app.Id, models.WebhookDeliveryKindWebhook, models.WebhookEventBundleAnalyzed, webhookUrl, payload)
+}
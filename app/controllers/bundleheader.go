@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"strconv"
+
+	"github.com/kayac/alphawing/app/models"
+)
+
+const (
+	VersionHeader  = "X-Alphawing-Version"
+	RevisionHeader = "X-Alphawing-Revision"
+	ChecksumHeader = "X-Alphawing-Checksum"
+)
+
+// setBundleHeaders tells which bundle is downloaded, so that the scripts of the device farms and the MDMs
+// verify the file they fetched without another call to the API. They are sent with the redirect to the storage as well.
+func (c *AlphaWingController) setBundleHeaders(bundle *models.Bundle) {
+	header := c.Response.Out.Header()
+	header.Set(VersionHeader, bundle.BundleVersion)
+	header.Set(RevisionHeader, strconv.Itoa(bundle.Revision))
+	// the bundles uploaded before the checksums were recorded have none,
+	// and the universal APK of an aab is not the file of the checksum
+	if bundle.Digest != "" && !bundle.IsAab() {
+		header.Set(ChecksumHeader, bundle.Digest)
+	}
+}
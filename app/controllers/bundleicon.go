@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// GetIcon serves the thumbnail of the launcher icon of the bundle, to compare it with the previous bundle.
+func (c BundleControllerWithValidation) GetIcon(bundleId int) revel.Result {
+	return c.renderIcon(c.Bundle)
+}
+
+// GetIcon serves the icon for the URL signed for the notifications, which are read outside the login.
+func (c *LimitedTimeController) GetIcon(bundleId int) revel.Result {
+	return c.renderIcon(c.Bundle)
+}
+
+func (c *AlphaWingController) renderIcon(bundle *models.Bundle) revel.Result {
+	icon, err := bundle.Icon(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	if icon == nil {
+		return c.NotFound("Icon is not found.")
+	}
+
+	c.Response.ContentType = "image/png"
+	return c.RenderBinary(bytes.NewReader(icon.Png), fmt.Sprintf("bundle_%d_icon.png", bundle.Id), revel.Inline, icon.CreatedAt)
+}
+
+// iconChangeImages returns the icons before and after the change for the notice of the bundle, or nil if the icon is not changed.
+func (c *AlphaWingController) iconChangeImages(bundle *models.Bundle) ([]models.SlackImage, error) {
+	if !bundle.IconChanged() {
+		return nil, nil
+	}
+	before, err := c.LimitedTimeUriFor(fmt.Sprintf("bundle/%d/limited_icon", bundle.IconChangedFrom))
+	if err != nil {
+		return nil, err
+	}
+	after, err := c.LimitedTimeUriFor(fmt.Sprintf("bundle/%d/limited_icon", bundle.Id))
+	if err != nil {
+		return nil, err
+	}
+	return []models.SlackImage{
+		{Url: before.String(), Title: "変更前のアイコン"},
+		{Url: after.String(), Title: "変更後のアイコン"},
+	}, nil
+}
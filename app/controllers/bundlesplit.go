@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// the client hints the browsers send to the install page once it asks for them, to choose the split of the device
+const splitClientHints = "Sec-CH-UA-Arch, Sec-CH-UA-Bitness, Sec-CH-DPR"
+
+type JsonResponseBundleSplit struct {
+	*JsonResponse
+	Content *models.BundleSplitJsonResponse `json:"content"`
+}
+
+func (c ApiController) NewJsonResponseBundleSplit(stat int, mes []string, content *models.BundleSplitJsonResponse) *JsonResponseBundleSplit {
+	return &JsonResponseBundleSplit{
+		JsonResponse: c.NewJsonResponse(stat, mes),
+		Content:      content,
+	}
+}
+
+// PostUploadSplit adds the APK of an ABI or a density to the Android bundle of the file_id, released with it.
+func (c ApiController) PostUploadSplit(token string, file_id string, abi string, density string, file *os.File) revel.Result {
+	app, err := c.appByApiToken(token)
+	if err != nil {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponseBundleSplit(c.Response.Status, []string{"Token is invalid."}, nil))
+	}
+	if result := c.checkIdempotencyKey(fmt.Sprintf("app:%d", app.Id)); result != nil {
+		return result
+	}
+
+	var filename string
+	if _, ok := c.Params.Files["file"]; ok {
+		filename = c.Params.Files["file"][0].Filename
+	}
+	c.Validation.Required(file_id).Message("file_id is required.")
+	c.Validation.Required(file != nil).Message("File is required.")
+	c.Validation.Required(strings.HasSuffix(strings.ToLower(filename), string(models.BundleFileExtensionAndroid))).Message("File extension is not valid.")
+	c.Validation.Required(withinUploadLimit(file)).Message("File is too large.")
+	if c.Validation.HasErrors() {
+		var errors []string
+		for _, err := range c.Validation.Errors {
+			errors = append(errors, err.String())
+		}
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseBundleSplit(c.Response.Status, errors, nil))
+	}
+
+	bundle, err := app.GetBundleByFileId(Dbm, file_id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJson(c.NewJsonResponseBundleSplit(c.Response.Status, []string{"Bundle not found."}, nil))
+	}
+
+	noteAppWrite(app.Id)
+	split, err := bundle.AddSplit(Dbm, c.Storage, file, abi, density)
+	switch err.(type) {
+	case nil:
+	case *models.BundleParseError:
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseBundleSplit(c.Response.Status, []string{err.Error()}, nil))
+	case *models.AppQuotaError:
+		c.Response.Status = http.StatusInsufficientStorage
+		return c.RenderJson(c.NewJsonResponseBundleSplit(c.Response.Status, []string{err.Error()}, nil))
+	default:
+		switch err {
+		case models.ErrSplitNotApk, models.ErrSplitUnknown, models.ErrSplitAbiRequired:
+			c.Response.Status = http.StatusBadRequest
+		case models.ErrSplitDuplicated:
+			c.Response.Status = http.StatusConflict
+		default:
+			panic(err)
+		}
+		return c.RenderJson(c.NewJsonResponseBundleSplit(c.Response.Status, []string{err.Error()}, nil))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(c.NewJsonResponseBundleSplit(c.Response.Status, []string{"Split is uploaded!"}, split.JsonResponse()))
+}
+
+// deviceSplit chooses the split for the device of the request, by the abi and the density parameters
+// or by the client hints, and nil for the bundle itself.
+func (c *AlphaWingController) deviceSplit(bundle *models.Bundle) *models.BundleSplit {
+	if !bundle.IsApk() || bundle.IsAab() {
+		return nil
+	}
+	// the bundle is chosen on the page when the split of the device does not work
+	if c.Params.Get("abi") == "none" {
+		return nil
+	}
+	header := c.Request.Header
+	abis := models.SplitPreference(c.Params.Get("abi"), false)
+	if abis == nil {
+		abis = models.SplitPreference(header.Get("Sec-CH-UA-Arch"), strings.Trim(header.Get("Sec-CH-UA-Bitness"), `"`) == "64")
+	}
+	density := c.Params.Get("density")
+	if density == "" {
+		if dpr, err := strconv.ParseFloat(header.Get("Sec-CH-DPR"), 64); err == nil {
+			density = models.SplitDensityOf(dpr)
+		}
+	}
+
+	split, err := bundle.SplitFor(Dbm, abis, density)
+	if err != nil {
+		panic(err)
+	}
+	return split
+}
+
+// renderApk serves the split of the device, or the APK of the bundle. The checksum of the split replaces the one
+// of the bundle, and the signature of the bundle is not sent for it.
+func (c *AlphaWingController) renderApk(bundle *models.Bundle) revel.Result {
+	fileId := bundle.InstallFileId()
+	c.setBundleHeaders(bundle)
+	if split := c.deviceSplit(bundle); split != nil {
+		fileId = split.FileId
+		c.Response.Out.Header().Set(ChecksumHeader, split.Digest)
+	} else if err := c.setSignatureHeader(bundle); err != nil {
+		panic(err)
+	}
+	if result := c.redirectFileToSignedURL(fileId); result != nil {
+		return result
+	}
+
+	object, err := c.Storage.Get(fileId)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Response.ContentType = "application/vnd.android.package-archive"
+	return c.RenderBinary(c.meterBandwidth(object.Body), object.Name, revel.Attachment, object.ModTime)
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"database/sql"
+	"sort"
+
+	"github.com/kayac/alphawing/app/models"
+)
+
+func defaultChangelogLanguage() string {
+	return Conf.ChangelogLanguages[0]
+}
+
+// translationLanguages returns the languages of changelog.languages other than the one of the descriptions.
+func translationLanguages() []string {
+	return Conf.ChangelogLanguages[1:]
+}
+
+// changelogParams reads the descriptions in the other languages, given as description_<language>, e.g. description_en.
+// The languages not given are left out, not to delete their changelogs.
+func (c *AlphaWingController) changelogParams() map[string]string {
+	texts := map[string]string{}
+	for _, language := range translationLanguages() {
+		if values, ok := c.Params.Values["description_"+language]; ok && len(values) > 0 {
+			texts[language] = values[0]
+		}
+	}
+	return texts
+}
+
+// translations keeps the descriptions in the other languages of changelog.languages, by the primary language.
+func translations(texts map[string]string) map[string]string {
+	translations := map[string]string{}
+	for language, text := range texts {
+		language = models.NormalizeLanguage(language)
+		for _, l := range translationLanguages() {
+			if l == language {
+				translations[language] = text
+			}
+		}
+	}
+	return translations
+}
+
+// preferredLanguages returns the languages to show the changelogs in: the lang parameter, the language of the account
+// and the one of the browser, in the order.
+func (c *AlphaWingController) preferredLanguages() []string {
+	languages := []string{}
+	if lang := c.Params.Get("lang"); lang != "" {
+		languages = append(languages, lang)
+	}
+	if c.LoginUserId != 0 {
+		user, err := models.GetUser(Dbm, c.LoginUserId)
+		if err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+		if err == nil && user.Language != "" {
+			languages = append(languages, user.Language)
+		}
+	}
+	if c.Request.Locale != "" {
+		languages = append(languages, c.Request.Locale)
+	}
+	return languages
+}
+
+// otherChangelogLanguages returns the languages the bundle is described in other than the one shown.
+func otherChangelogLanguages(bundle *models.Bundle, texts map[string]string, shown string) []string {
+	languages := []string{}
+	if bundle.Description != "" && shown != defaultChangelogLanguage() {
+		languages = append(languages, defaultChangelogLanguage())
+	}
+	for language := range texts {
+		if language != shown {
+			languages = append(languages, language)
+		}
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// mailLanguages groups the addresses by the languages of their accounts, the ones without a language
+// in the one of the descriptions.
+func mailLanguages(to []string) (map[string][]string, error) {
+	languages := map[string][]string{}
+	for _, email := range to {
+		language := defaultChangelogLanguage()
+		user, err := models.GetUserFromEmail(Dbm, email)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if err == nil && user.Language != "" {
+			language = user.Language
+		}
+		languages[language] = append(languages[language], email)
+	}
+	return languages, nil
+}
+
+// mailBundle mails the members about the bundle, each in the language of their accounts with the changelog in it.
+func mailBundle(bundle *models.Bundle, to []string, subject string, body func(changelog string) string) error {
+	texts, err := bundle.ChangelogTexts(Dbm)
+	if err != nil {
+		return err
+	}
+	languages, err := mailLanguages(to)
+	if err != nil {
+		return err
+	}
+
+	for language, emails := range languages {
+		changelog, _ := bundle.LocalizedDescription(texts, []string{language}, defaultChangelogLanguage())
+		go sendMail(emails, subject, body(changelog))
+	}
+	return nil
+}
+
+// changelogSection is the changelog to put in the mails, if any.
+func changelogSection(changelog string) string {
+	if changelog == "" {
+		return ""
+	}
+	return "変更点:\n" + changelog + "\n\n"
+}
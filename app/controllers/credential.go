@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// SealCredentials seals the credentials of the external services saved before they were sealed.
+func SealCredentials() {
+	var count int
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		keys, err := models.SealAppStoreConnectKeys(txn, Conf.EnvFileKey)
+		if err != nil {
+			return err
+		}
+		farms, err := models.SealDeviceFarms(txn, Conf.EnvFileKey)
+		if err != nil {
+			return err
+		}
+		plays, err := models.SealPlayCredentials(txn, Conf.EnvFileKey)
+		count = keys + farms + plays
+		return err
+	})
+	if err != nil {
+		revel.ERROR.Printf("failed to seal the credentials: %s", err)
+		return
+	}
+	if count > 0 {
+		revel.INFO.Printf("sealed %d credentials", count)
+	}
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/kayac/alphawing/app/models"
+	"github.com/kayac/alphawing/app/routes"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// DeviceController manages the companion app devices of the login user.
+type DeviceController struct {
+	AuthController
+}
+
+func (c DeviceController) GetDevices() revel.Result {
+	devices, err := models.GetPairedDevicesByUser(Dbm, c.LoginUserId)
+	if err != nil {
+		panic(err)
+	}
+
+	pairing, err := models.GetPendingPairing(Dbm, c.LoginUserId)
+	if err != nil {
+		panic(err)
+	}
+
+	// the companion app reads the endpoint and the code from the QR code
+	var pairingUrl string
+	if pairing != nil {
+		u, err := c.UriFor("api/device/pair")
+		if err != nil {
+			panic(err)
+		}
+		pairingUrl = fmt.Sprintf("%s?pairing_code=%s", u.String(), pairing.PairingCode)
+	}
+
+	return c.Render(devices, pairing, pairingUrl)
+}
+
+func (c DeviceController) PostStartPairing() revel.Result {
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		_, err := models.StartPairing(txn, c.LoginUserId)
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return c.Redirect(routes.DeviceController.GetDevices())
+}
+
+func (c DeviceController) PostDeleteDevice(deviceId int) revel.Result {
+	device, err := models.GetPairedDevice(Dbm, deviceId)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	if err == sql.ErrNoRows || device.UserId != c.LoginUserId {
+		c.Flash.Error("Parameter is invalid.")
+		return c.Redirect(routes.DeviceController.GetDevices())
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return device.DeleteFromDB(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Deleted!")
+	return c.Redirect(routes.DeviceController.GetDevices())
+}
@@ -0,0 +1,468 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// DeviceApiController serves the companion app authenticated with a device token.
+type DeviceApiController struct {
+	ApiController
+	Device *models.PairedDevice
+	User   *models.User
+}
+
+type JsonResponsePairDevice struct {
+	*JsonResponse
+	Content *DevicePairJsonResponse `json:"content"`
+}
+
+type DevicePairJsonResponse struct {
+	DeviceToken string `json:"device_token"`
+	Email       string `json:"email"`
+}
+
+type JsonResponseDeviceCatalog struct {
+	*JsonResponse
+	Content []*DeviceCategoryJsonResponse `json:"content"`
+}
+
+type JsonResponseDeviceApp struct {
+	*JsonResponse
+	Content *DeviceAppJsonResponse `json:"content"`
+}
+
+type DeviceCategoryJsonResponse struct {
+	Name string                   `json:"name"`
+	Apps []*DeviceAppJsonResponse `json:"apps"`
+}
+
+type DeviceAppJsonResponse struct {
+	Id           int                       `json:"id"`
+	Title        string                    `json:"title"`
+	Category     string                    `json:"category"`
+	IconUrl      string                    `json:"icon_url"`
+	HasAuthority bool                      `json:"has_authority"`
+	LatestApk    *DeviceBundleJsonResponse `json:"latest_apk"`
+	LatestIpa    *DeviceBundleJsonResponse `json:"latest_ipa"`
+}
+
+// the install URL opens the bundle without the web login and expires like the ipa install links
+type DeviceBundleJsonResponse struct {
+	*models.BundleJsonResponse
+	DeviceInstallUrl string   `json:"device_install_url"`
+	KnownIssues      []string `json:"known_issues"`
+}
+
+type JsonResponseDeviceVerify struct {
+	*JsonResponse
+	Content *DeviceVerifyJsonResponse `json:"content"`
+}
+
+// latest tells whether the build is the newest of its platform and variant, so the app can ask to update
+type DeviceVerifyJsonResponse struct {
+	Digest  string                       `json:"digest"`
+	Matched bool                         `json:"matched"`
+	Latest  bool                         `json:"latest"`
+	Bundles []*models.BundleJsonResponse `json:"bundles"`
+}
+
+func (c ApiController) PostPairDevice(pairing_code string, name string) revel.Result {
+	c.Validation.Required(pairing_code).Message("pairing_code is required.")
+	c.Validation.Required(name).Message("name is required.")
+	if c.Validation.HasErrors() {
+		var errors []string
+		for _, err := range c.Validation.Errors {
+			errors = append(errors, err.String())
+		}
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(&JsonResponsePairDevice{c.NewJsonResponse(c.Response.Status, errors), nil})
+	}
+
+	subject := "addr:" + c.clientAddr()
+	locked, err := models.IsPairingLocked(Dbm, subject, time.Now())
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(&JsonResponsePairDevice{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+	}
+	if locked {
+		c.Response.Status = http.StatusTooManyRequests
+		return c.RenderJson(&JsonResponsePairDevice{c.NewJsonResponse(c.Response.Status, []string{"Too many invalid pairing codes. Please try again later."}), nil})
+	}
+
+	var device *models.PairedDevice
+	var token string
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		d, t, err := models.CompletePairing(txn, pairing_code, name)
+		if err != nil {
+			return err
+		}
+		device, token = d, t
+		return nil
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			recordPairingFailure(subject)
+			c.Response.Status = http.StatusUnauthorized
+			return c.RenderJson(&JsonResponsePairDevice{c.NewJsonResponse(c.Response.Status, []string{"pairing_code is invalid or expired."}), nil})
+		}
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(&JsonResponsePairDevice{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+	}
+
+	user, err := models.GetUser(Dbm, device.UserId)
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(&JsonResponsePairDevice{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+	}
+
+	c.Response.Status = http.StatusOK
+	content := &DevicePairJsonResponse{
+		DeviceToken: token,
+		Email:       user.Email,
+	}
+	return c.RenderJson(&JsonResponsePairDevice{c.NewJsonResponse(c.Response.Status, []string{"Device is paired!"}), content})
+}
+
+// recordPairingFailure counts the invalid pairing code, alerting the admins when the pending codes are invalidated
+// for the codes guessed from every address.
+func recordPairingFailure(subject string) {
+	var invalidated bool
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		var err error
+		invalidated, err = models.RecordPairingFailure(txn, subject)
+		return err
+	})
+	if err != nil {
+		revel.ERROR.Printf("failed to record pairing failure of %s: %s", subject, err)
+		return
+	}
+	if !invalidated {
+		return
+	}
+
+	text := fmt.Sprintf("alphawing でペアリングコードの認証が%d分間に%d回失敗したため、待機中のペアリングコードを無効にしました。(最後: %s)", int(models.AuthFailureWindow.Minutes()), models.PairingGlobalFailureLimit, subject)
+	notifySecurityAlert("[alphawing] 不審なアクセスを検知しました", text)
+}
+
+// GetCatalog lists the apps, whose title contains q unless it is empty.
+func (c DeviceApiController) GetCatalog(q string) revel.Result {
+	catalog, err := c.catalog(q)
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(&JsonResponseDeviceCatalog{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+	}
+
+	content := []*DeviceCategoryJsonResponse{}
+	for _, category := range catalog {
+		categoryJsonResponse := &DeviceCategoryJsonResponse{Name: category.Name}
+		for _, entry := range category.Entries {
+			appJsonResponse, err := c.appJsonResponse(entry)
+			if err != nil {
+				c.Response.Status = http.StatusInternalServerError
+				return c.RenderJson(&JsonResponseDeviceCatalog{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+			}
+			categoryJsonResponse.Apps = append(categoryJsonResponse.Apps, appJsonResponse)
+		}
+		content = append(content, categoryJsonResponse)
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseDeviceCatalog{c.NewJsonResponse(c.Response.Status, []string{"Catalog"}), content})
+}
+
+// GetLatest returns the latest bundles of the app, of the variant unless it is empty.
+func (c DeviceApiController) GetLatest(appId int, variant string) revel.Result {
+	catalog, err := c.catalog("")
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(&JsonResponseDeviceApp{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+	}
+
+	for _, category := range catalog {
+		for _, entry := range category.Entries {
+			if entry.App.Id != appId {
+				continue
+			}
+			if variant != "" && entry.HasAuthority {
+				if entry.LatestApk, err = entry.App.LatestBundle(Dbm, models.BundlePlatformTypeAndroid, variant); err != nil {
+					c.Response.Status = http.StatusInternalServerError
+					return c.RenderJson(&JsonResponseDeviceApp{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+				}
+				if entry.LatestIpa, err = entry.App.LatestBundle(Dbm, models.BundlePlatformTypeIOS, variant); err != nil {
+					c.Response.Status = http.StatusInternalServerError
+					return c.RenderJson(&JsonResponseDeviceApp{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+				}
+			}
+			content, err := c.appJsonResponse(entry)
+			if err != nil {
+				c.Response.Status = http.StatusInternalServerError
+				return c.RenderJson(&JsonResponseDeviceApp{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+			}
+			c.Response.Status = http.StatusOK
+			return c.RenderJson(&JsonResponseDeviceApp{c.NewJsonResponse(c.Response.Status, []string{"Latest Bundles"}), content})
+		}
+	}
+
+	c.Response.Status = http.StatusNotFound
+	return c.RenderJson(&JsonResponseDeviceApp{c.NewJsonResponse(c.Response.Status, []string{"App is not found."}), nil})
+}
+
+// GetVerify tells the bundles of the app with the build fingerprint the app reports, the SHA-256 of its own package.
+func (c DeviceApiController) GetVerify(appId int, digest string) revel.Result {
+	normalized := models.NormalizeDigest(digest)
+	if normalized == "" {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(&JsonResponseDeviceVerify{c.NewJsonResponse(c.Response.Status, []string{"digest must be the SHA-256 in hex."}), nil})
+	}
+
+	app, err := models.GetApp(Dbm, appId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Response.Status = http.StatusNotFound
+			return c.RenderJson(&JsonResponseDeviceVerify{c.NewJsonResponse(c.Response.Status, []string{"App is not found."}), nil})
+		}
+		panic(err)
+	}
+	found, err := app.HasAuthorityForEmail(Dbm, c.User.Email)
+	if err != nil {
+		panic(err)
+	}
+	if !found {
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJson(&JsonResponseDeviceVerify{c.NewJsonResponse(c.Response.Status, []string{"App is not found."}), nil})
+	}
+
+	bundles, err := app.BundlesByDigest(Dbm, normalized)
+	if err != nil {
+		panic(err)
+	}
+
+	content := &DeviceVerifyJsonResponse{
+		Digest:  normalized,
+		Matched: len(bundles) > 0,
+		Bundles: []*models.BundleJsonResponse{},
+	}
+	for _, bundle := range bundles {
+		latest, err := app.LatestBundle(Dbm, bundle.PlatformType, bundle.Variant)
+		if err != nil {
+			panic(err)
+		}
+		if latest != nil && latest.Digest == normalized {
+			content.Latest = true
+		}
+		bundleJsonResponse, err := bundle.JsonResponse(&c)
+		if err != nil {
+			panic(err)
+		}
+		content.Bundles = append(content.Bundles, bundleJsonResponse)
+	}
+
+	message := "Build matches no bundle."
+	if content.Matched {
+		message = "Build matches the bundles."
+	}
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseDeviceVerify{c.NewJsonResponse(c.Response.Status, []string{message}), content})
+}
+
+// PostPushToken opts the device into the push notifications of the new bundles with the FCM registration token,
+// or out of them with the empty token.
+func (c DeviceApiController) PostPushToken(push_token string) revel.Result {
+	c.Validation.MaxSize(push_token, 512).Message("push_token is too long.")
+	if c.Validation.HasErrors() {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{c.Validation.Errors[0].String()}))
+	}
+
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		return c.Device.SetPushToken(txn, push_token)
+	})
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	message := "Push notifications are enabled!"
+	if push_token == "" {
+		message = "Push notifications are disabled!"
+	}
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{message}))
+}
+
+func (c DeviceApiController) GetIcon(appId int) revel.Result {
+	app, err := models.GetApp(Dbm, appId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NotFound("App is not found.")
+		}
+		panic(err)
+	}
+	if app.IconFileId == "" {
+		return c.NotFound("Icon is not found.")
+	}
+	// only the icons of the listed apps are shown to the users without authority
+	if !app.IsListed() {
+		found, err := app.HasAuthorityForEmail(Dbm, c.User.Email)
+		if err != nil {
+			panic(err)
+		}
+		if !found {
+			return c.NotFound("App is not found.")
+		}
+	}
+
+	resp, file, err := c.GoogleService.DownloadFile(app.IconFileId)
+	if err != nil {
+		panic(err)
+	}
+
+	modtime, err := time.Parse(time.RFC3339, file.ModifiedDate)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Response.ContentType = file.MimeType
+	return c.RenderBinary(resp.Body, file.Title, revel.Inline, modtime)
+}
+
+// catalog returns the apps the user has authority for and the listed ones, whose title contains the query.
+// The Google Drive permissions of the user are not available to the device, so the authorities are used instead.
+func (c *DeviceApiController) catalog(query string) ([]*models.CatalogCategory, error) {
+	apps, err := models.SearchAppsByAuthorityEmail(Dbm, c.User.Email, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIds []string
+	for _, app := range apps {
+		fileIds = append(fileIds, app.FileId)
+	}
+
+	listedApps, err := models.SearchListedApps(Dbm, fileIds, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.NewCatalog(Dbm, apps, listedApps)
+}
+
+func (c *DeviceApiController) appJsonResponse(entry *models.CatalogEntry) (*DeviceAppJsonResponse, error) {
+	app := entry.App
+	appJsonResponse := &DeviceAppJsonResponse{
+		Id:           app.Id,
+		Title:        app.Title,
+		Category:     app.Category,
+		HasAuthority: entry.HasAuthority,
+	}
+
+	if app.IconFileId != "" {
+		iconUrl, err := c.UriFor(fmt.Sprintf("api/device/app/%d/icon", app.Id))
+		if err != nil {
+			return nil, err
+		}
+		appJsonResponse.IconUrl = iconUrl.String()
+	}
+
+	// the bundles are not loaded without authority
+	if !entry.HasAuthority {
+		return appJsonResponse, nil
+	}
+
+	var err error
+	if appJsonResponse.LatestApk, err = c.bundleJsonResponse(entry.LatestApk); err != nil {
+		return nil, err
+	}
+	if appJsonResponse.LatestIpa, err = c.bundleJsonResponse(entry.LatestIpa); err != nil {
+		return nil, err
+	}
+	return appJsonResponse, nil
+}
+
+func (c *DeviceApiController) bundleJsonResponse(bundle *models.Bundle) (*DeviceBundleJsonResponse, error) {
+	if bundle == nil {
+		return nil, nil
+	}
+
+	bundleJsonResponse, err := bundle.JsonResponse(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var installUrl string
+	if bundle.IsIpa() {
+		plistUrl, err := c.deviceLimitedTimeUriFor(fmt.Sprintf("bundle/%d/download_plist", bundle.Id), c.Device.Id)
+		if err != nil {
+			return nil, err
+		}
+		installUrl = "itms-services://?action=download-manifest&url=" + url.QueryEscape(plistUrl.String())
+	} else {
+		apkUrl, err := c.deviceLimitedTimeUriFor(fmt.Sprintf("bundle/%d/download_limited_apk", bundle.Id), c.Device.Id)
+		if err != nil {
+			return nil, err
+		}
+		installUrl = apkUrl.String()
+	}
+
+	issues, err := bundle.KnownIssues(Dbm)
+	if err != nil {
+		return nil, err
+	}
+	knownIssues := []string{}
+	for _, issue := range issues {
+		knownIssues = append(knownIssues, issue.Title)
+	}
+
+	return &DeviceBundleJsonResponse{bundleJsonResponse, installUrl, knownIssues}, nil
+}
+
+func (c *DeviceApiController) CheckDeviceToken() revel.Result {
+	token := c.Params.Get("device_token")
+	if authorization := c.Request.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		token = strings.TrimPrefix(authorization, "Bearer ")
+	}
+
+	device, err := models.GetPairedDeviceByToken(Dbm, token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Response.Status = http.StatusUnauthorized
+			return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Device token is invalid."}))
+		}
+		panic(err)
+	}
+
+	user, err := models.GetUser(Dbm, device.UserId)
+	if err != nil {
+		panic(err)
+	}
+	// the devices of a user deactivated before they were revoked with the deactivation
+	if user.Deactivated {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Device token is invalid."}))
+	}
+	c.checkTokenCountry(models.TokenKindDevice, device.Id, fmt.Sprintf("%s のデバイス %s のトークン", user.Email, device.Name))
+	c.useToken(models.TokenKindDevice, device.Id, token)
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return device.Touch(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Device = device
+	c.User = user
+	c.LoginUserId = user.Id
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+	"github.com/kayac/alphawing/app/routes"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+func (c AppControllerWithValidation) PostUpdateDeviceFarm(appId int, deviceFarm models.DeviceFarm) revel.Result {
+	isAdmin, err := c.isAdmin(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isAdmin {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	deviceFarm.Validate(c.Validation)
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(appId))
+	}
+
+	deviceFarm.AppId = appId
+	if deviceFarm.Provider == models.DeviceFarmProviderTestLab {
+		if _, _, err := deviceFarm.ServiceAccountConfig(); err != nil {
+			c.Flash.Error(err.Error())
+			return c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(appId))
+		}
+	}
+	if err := deviceFarm.Seal(Conf.EnvFileKey); err != nil {
+		panic(err)
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return deviceFarm.Save(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Updated!")
+	return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+}
+
+func (c AppControllerWithValidation) PostDeleteDeviceFarm(appId int, provider string) revel.Result {
+	isAdmin, err := c.isAdmin(c.App)
+	if err != nil {
+		panic(err)
+	}
+	if !isAdmin {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.AppControllerWithValidation.GetApp(appId))
+	}
+
+	deviceFarm, err := models.GetDeviceFarm(Dbm, appId, provider)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Flash.Error("Device farm is not registered.")
+			return c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(appId))
+		}
+		panic(err)
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return deviceFarm.DeleteFromDB(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Deleted!")
+	return c.Redirect(routes.AppControllerWithValidation.GetUpdateApp(appId))
+}
+
+// PostRunDeviceFarm runs the tests of the bundle on the device farm again, e.g. after the test suite is fixed.
+func (c BundleControllerWithValidation) PostRunDeviceFarm(bundleId int, provider string) revel.Result {
+	bundle := c.Bundle
+
+	app, err := bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	if bundle.IsRecalled() {
+		c.Flash.Error("Recalled bundles cannot be tested on device farms.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	deviceFarm, err := models.GetDeviceFarm(Dbm, bundle.AppId, provider)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Flash.Error("Device farm is not registered.")
+			return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+		}
+		panic(err)
+	}
+
+	if _, err := startDeviceFarmRun(c.Storage, deviceFarm, bundle); err != nil {
+		panic(err)
+	}
+
+	if err := c.createAudit(models.ResourceBundle, bundleId, models.ActionRunDeviceFarm); err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Running!")
+	return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+}
+
+// runOnDeviceFarms starts the test runs of the bundle on the device farms registered for the app.
+func runOnDeviceFarms(storage models.Storage, app *models.App, bundle *models.Bundle) {
+	deviceFarms, err := app.DeviceFarms(Dbm)
+	if err != nil {
+		revel.ERROR.Printf("failed to run bundle %d on device farms: %s", bundle.Id, err)
+		return
+	}
+	for _, deviceFarm := range deviceFarms {
+		if _, err := startDeviceFarmRun(storage, deviceFarm, bundle); err != nil {
+			revel.ERROR.Printf("failed to run bundle %d on %s: %s", bundle.Id, deviceFarm.ProviderName(), err)
+		}
+	}
+}
+
+// startDeviceFarmRun records the run and uploads the bundle to the device farm in the background.
+func startDeviceFarmRun(storage models.Storage, deviceFarm *models.DeviceFarm, bundle *models.Bundle) (*models.DeviceFarmRun, error) {
+	run := &models.DeviceFarmRun{
+		BundleId: bundle.Id,
+		Provider: deviceFarm.Provider,
+		State:    models.DeviceFarmStateUploading,
+	}
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		return run.Save(txn)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go runOnDeviceFarm(storage, deviceFarm, bundle, run)
+	return run, nil
+}
+
+// runOnDeviceFarm starts the run and records its id and link, which DeviceFarmRunJob polls until it is finished.
+func runOnDeviceFarm(storage models.Storage, deviceFarm *models.DeviceFarm, bundle *models.Bundle, run *models.DeviceFarmRun) {
+	stop := touchWhileUploading(run.Touch)
+	runId, url, err := uploadToDeviceFarm(storage, deviceFarm, bundle)
+	stop()
+	if err != nil {
+		revel.ERROR.Printf("failed to run bundle %d on %s: %s", bundle.Id, deviceFarm.ProviderName(), err)
+		run.State = models.DeviceFarmStateError
+		run.Message = err.Error()
+	} else {
+		run.State = models.DeviceFarmStateRunning
+		run.RunId = runId
+		run.Url = url
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return run.Update(txn)
+	})
+	if err != nil {
+		revel.ERROR.Printf("failed to update device farm run %d: %s", run.Id, err)
+	}
+}
+
+func uploadToDeviceFarm(storage models.Storage, deviceFarm *models.DeviceFarm, bundle *models.Bundle) (string, string, error) {
+	if bundle.IsRecalled() {
+		return "", "", models.ErrBundleRecalled
+	}
+	if bundle.IsArchived() {
+		return "", "", models.ErrBundleArchived
+	}
+	if bundle.InstallFileId() == "" {
+		return "", "", models.ErrUniversalApkNotBuilt
+	}
+	client, err := deviceFarm.Client(Conf.EnvFileKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	object, err := storage.Get(bundle.InstallFileId())
+	if err != nil {
+		return "", "", err
+	}
+	defer object.Body.Close()
+
+	return client.Start(bundle, object.Name, object.Body)
+}
+
+// resumeDeviceFarmRuns uploads again the bundles of the runs left uploading by a server stopped.
+func resumeDeviceFarmRuns() error {
+	runs, err := models.ClaimStaleDeviceFarmRuns(Dbm, time.Now())
+	if err != nil || len(runs) == 0 {
+		return err
+	}
+	storage, err := backgroundStorage()
+	if err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		bundle, err := models.GetBundle(Dbm, run.BundleId)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		// the bundle is deleted with its runs, unless it is deleted while this one is read
+		if err == sql.ErrNoRows {
+			continue
+		}
+		deviceFarm, err := models.GetDeviceFarm(Dbm, bundle.AppId, run.Provider)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				return err
+			}
+			run.State = models.DeviceFarmStateError
+			run.Message = "Device farm is not registered."
+			if err := Transact(run.Update); err != nil {
+				return err
+			}
+			continue
+		}
+		revel.INFO.Printf("resuming device farm run %d of bundle %d", run.Id, bundle.Id)
+		go runOnDeviceFarm(storage, deviceFarm, bundle, run)
+	}
+	return nil
+}
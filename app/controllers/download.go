@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+func (c BundleControllerWithValidation) GetDownloadFile(bundleId int) revel.Result {
+	if result := c.checkBandwidth(); result != nil {
+		return result
+	}
+	if result := c.checkRecalled(c.Bundle); result != nil {
+		return result
+	}
+	if result := c.checkArchived(c.Bundle); result != nil {
+		return result
+	}
+	if !c.Bundle.IsPlainDownload() {
+		return c.NotFound("Bundle is not a file to download.")
+	}
+
+	err := c.createAudit(models.ResourceBundle, bundleId, models.ActionDownload)
+	if err != nil {
+		panic(err)
+	}
+
+	return c.renderFile(c.Bundle)
+}
+
+func (c *LimitedTimeController) GetDownloadFile(bundleId int) revel.Result {
+	if result := c.checkBandwidth(); result != nil {
+		return result
+	}
+	if result := c.checkRecalled(c.Bundle); result != nil {
+		return result
+	}
+	if result := c.checkArchived(c.Bundle); result != nil {
+		return result
+	}
+	if !c.Bundle.IsPlainDownload() {
+		return c.NotFound("")
+	}
+
+	err := c.createDownloadAudit(bundleId)
+	if err != nil {
+		panic(err)
+	}
+
+	return c.renderFile(c.Bundle)
+}
+
+// renderFile serves the disk image, the package or the generic artifact as it is, which the testers open by themselves.
+func (c *AlphaWingController) renderFile(bundle *models.Bundle) revel.Result {
+	c.setBundleHeaders(bundle)
+	if err := c.setSignatureHeader(bundle); err != nil {
+		panic(err)
+	}
+	if result := c.redirectToSignedURL(bundle); result != nil {
+		return result
+	}
+
+	object, err := c.Storage.Get(bundle.FileId)
+	if err != nil {
+		panic(err)
+	}
+
+	switch bundle.Extension() {
+	case models.BundleFileExtensionMacOSDiskImage:
+		c.Response.ContentType = "application/x-apple-diskimage"
+	case models.BundleFileExtensionGenericZip:
+		c.Response.ContentType = "application/zip"
+	case models.BundleFileExtensionGenericTarball:
+		c.Response.ContentType = "application/gzip"
+	default:
+		c.Response.ContentType = "application/octet-stream"
+	}
+	return c.RenderBinary(c.meterBandwidth(object.Body), object.Name, revel.Attachment, object.ModTime)
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/kayac/alphawing/app/models"
+	"github.com/kayac/alphawing/app/routes"
+
+	"github.com/revel/revel"
+)
+
+type JsonResponseBundleDsym struct {
+	*JsonResponse
+	Content *models.BundleDsymJsonResponse `json:"content"`
+}
+
+func (c ApiController) NewJsonResponseBundleDsym(stat int, mes []string, content *models.BundleDsymJsonResponse) *JsonResponseBundleDsym {
+	return &JsonResponseBundleDsym{
+		JsonResponse: c.NewJsonResponse(stat, mes),
+		Content:      content,
+	}
+}
+
+// PostUploadDsym stores the zip of the dSYMs with the ipa of the file_id, or with the newest ipa whose executable
+// has one of the UUIDs of the dSYMs.
+func (c ApiController) PostUploadDsym(token string, file_id string, file *os.File) revel.Result {
+	app, err := c.appByApiToken(token)
+	if err != nil {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponseBundleDsym(c.Response.Status, []string{"Token is invalid."}, nil))
+	}
+	if result := c.checkIdempotencyKey(fmt.Sprintf("app:%d", app.Id)); result != nil {
+		return result
+	}
+
+	var filename string
+	if _, ok := c.Params.Files["file"]; ok {
+		filename = c.Params.Files["file"][0].Filename
+	}
+	c.Validation.Required(file != nil).Message("File is required.")
+	c.Validation.Required(strings.HasSuffix(strings.ToLower(filename), string(models.BundleFileExtensionGenericZip))).Message("File extension is not valid.")
+	c.Validation.Required(withinUploadLimit(file)).Message("File is too large.")
+	if c.Validation.HasErrors() {
+		var errors []string
+		for _, err := range c.Validation.Errors {
+			errors = append(errors, err.String())
+		}
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseBundleDsym(c.Response.Status, errors, nil))
+	}
+
+	uuids, err := models.DsymUuids(file)
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseBundleDsym(c.Response.Status, []string{err.Error()}, nil))
+	}
+
+	var bundle *models.Bundle
+	if file_id != "" {
+		bundle, err = app.GetBundleByFileId(Dbm, file_id)
+	} else {
+		bundle, err = app.BundleByUuids(Dbm, uuids)
+	}
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJson(c.NewJsonResponseBundleDsym(c.Response.Status, []string{"Bundle not found."}, nil))
+	}
+
+	noteAppWrite(app.Id)
+	dsym, err := bundle.AddDsym(Dbm, c.Storage, file, uuids)
+	if _, ok := err.(*models.AppQuotaError); ok {
+		c.Response.Status = http.StatusInsufficientStorage
+		return c.RenderJson(c.NewJsonResponseBundleDsym(c.Response.Status, []string{err.Error()}, nil))
+	}
+	switch err {
+	case nil:
+	case models.ErrDsymNotIpa, models.ErrDsymNotMatched:
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseBundleDsym(c.Response.Status, []string{err.Error()}, nil))
+	case models.ErrDsymDuplicated:
+		c.Response.Status = http.StatusConflict
+		return c.RenderJson(c.NewJsonResponseBundleDsym(c.Response.Status, []string{err.Error()}, nil))
+	default:
+		panic(err)
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(c.NewJsonResponseBundleDsym(c.Response.Status, []string{"dSYM is uploaded!"}, dsym.JsonResponse()))
+}
+
+// GetDownloadDsym serves the newest dSYMs of the app with the UUID, which the crash reports tell.
+func (c ApiController) GetDownloadDsym(token string, uuid string) revel.Result {
+	app, err := c.appByApiToken(token)
+	if err != nil {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponseBundleDsym(c.Response.Status, []string{"Token is invalid."}, nil))
+	}
+
+	c.Validation.Required(uuid).Message("uuid is required.")
+	if c.Validation.HasErrors() {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseBundleDsym(c.Response.Status, []string{"uuid is required."}, nil))
+	}
+
+	dsym, bundle, err := app.DsymByUuid(Dbm, uuid)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJson(c.NewJsonResponseBundleDsym(c.Response.Status, []string{"dSYM not found."}, nil))
+	}
+	return c.renderDsym(dsym, bundle)
+}
+
+// GetDownloadDsym serves the dSYMs of the bundle to the developers of the app.
+func (c BundleControllerWithValidation) GetDownloadDsym(bundleId, dsymId int) revel.Result {
+	app, err := c.Bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	dsym, err := c.Bundle.GetDsym(Dbm, dsymId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		return c.NotFound("dSYM is not found.")
+	}
+	return c.renderDsym(dsym, c.Bundle)
+}
+
+func (c *AlphaWingController) renderDsym(dsym *models.BundleDsym, bundle *models.Bundle) revel.Result {
+	if result := c.redirectFileToSignedURL(dsym.FileId); result != nil {
+		return result
+	}
+
+	object, err := c.Storage.Get(dsym.FileId)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Response.ContentType = "application/zip"
+	return c.RenderBinary(c.meterBandwidth(object.Body), dsym.FileName(bundle), revel.Attachment, object.ModTime)
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+const errorReportedKey = "errorReported"
+
+// ErrorReportFilter reports the panics and the server errors of the requests to the Sentry DSN in the settings.
+// It runs inside the PanicFilter, which still renders the error page.
+var ErrorReportFilter = func(c *revel.Controller, fc []revel.Filter) {
+	defer func() {
+		if err := recover(); err != nil {
+			// the frames of the panic are still on the stack while it is deferred
+			event := models.NewErrorEvent(fmt.Sprintf("%T", err), fmt.Sprint(err), models.StackFrames(2))
+			reportError(c, event)
+			panic(err)
+		}
+	}()
+
+	fc[0](c, fc[1:])
+
+	if _, reported := c.Args[errorReportedKey]; !reported && c.Response.Status >= http.StatusInternalServerError {
+		event := models.NewErrorEvent("HTTP", fmt.Sprintf("%d %s on %s", c.Response.Status, http.StatusText(c.Response.Status), c.Action), nil)
+		reportError(c, event)
+	}
+}
+
+func reportError(c *revel.Controller, event *models.ErrorEvent) {
+	c.Args[errorReportedKey] = true
+	event.SetRequest(c.Request.Request)
+	event.Tags = map[string]string{"action": c.Action}
+	if userId := c.Session[LoginSessionKey]; userId != "" {
+		event.User = &models.ErrorEventUser{Id: userId}
+	}
+
+	// sent in background not to delay the error page
+	go func() {
+		settings, err := currentSettings()
+		if err != nil {
+			revel.ERROR.Printf("failed to report error %s: %s", event.EventId, err)
+			return
+		}
+		dsn := settings[models.SettingSentryDsn]
+		if dsn == "" {
+			return
+		}
+		reporter, err := models.NewErrorReporter(dsn, revel.RunMode)
+		if err != nil {
+			revel.ERROR.Printf("failed to report error %s: %s", event.EventId, err)
+			return
+		}
+		if err := reporter.Report(event); err != nil {
+			revel.ERROR.Printf("failed to report error %s: %s", event.EventId, err)
+		}
+	}()
+}
+
+// diagnoseUpload logs the error of the upload with the reference ID of the diagnosis,
+// and reports it unless the uploader can fix it.
+func (c *AlphaWingController) diagnoseUpload(err error) *models.UploadDiagnosis {
+	diagnosis := models.DiagnoseUploadError(err)
+	revel.ERROR.Printf("failed to upload bundle [%s]: %s", diagnosis.ReferenceId, err)
+
+	switch diagnosis.Code {
+	case models.UploadErrorBadZip, models.UploadErrorParse, models.UploadErrorBadSignature, models.UploadErrorSignerChanged:
+		// the uploader can fix them
+	default:
+		event := models.NewErrorEvent(fmt.Sprintf("%T", err), err.Error(), models.StackFrames(1))
+		event.EventId = diagnosis.ReferenceId
+		reportError(c.Controller, event)
+	}
+	return diagnosis
+}
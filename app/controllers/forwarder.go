@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// forwardBundle publishes the bundle to the distribution services configured for the app in the background.
+func (c *AlphaWingController) forwardBundle(app *models.App, bundle *models.Bundle) {
+	storage := c.Storage
+
+	if bundle.IsAab() {
+		prepareAab(storage, app, bundle)
+	} else {
+		runOnDeviceFarms(storage, app, bundle)
+	}
+
+	firebaseAppId := app.FirebaseAppId(bundle.PlatformType)
+	if Conf.FirebaseProjectNumber != "" && firebaseAppId != "" {
+		releaseNotes, err := bundle.ReleaseNotes(Dbm)
+		if err != nil {
+			revel.ERROR.Printf("failed to publish bundle %d to Firebase App Distribution: %s", bundle.Id, err)
+			return
+		}
+		go func() {
+			if err := publishToFirebase(storage, firebaseAppId, bundle, releaseNotes); err != nil {
+				revel.ERROR.Printf("failed to publish bundle %d to Firebase App Distribution: %s", bundle.Id, err)
+			}
+		}()
+	}
+}
+
+func publishToFirebase(storage models.Storage, firebaseAppId string, bundle *models.Bundle, releaseNotes string) error {
+	config := &models.ServiceAccountConfig{
+		ClientEmail: Conf.ServiceAccountClientEmail,
+		PrivateKey:  Conf.ServiceAccountPrivateKey,
+		Scope:       []string{models.FirebaseScope},
+	}
+	token, err := models.GetServiceAccountToken(config)
+	if err != nil {
+		return err
+	}
+
+	// the uploaded temporary file is removed after the request, so read it back from the storage
+	object, err := storage.Get(bundle.FileId)
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	f := models.NewFirebaseAppDistribution(token, Conf.FirebaseProjectNumber)
+	return f.UploadRelease(firebaseAppId, object.Name, object.Body, releaseNotes)
+}
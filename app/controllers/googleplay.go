@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// publishToPlay releases the bundle to the internal testing track and records the result to the submission.
+func publishToPlay(storage models.Storage, credential *models.PlayCredential, bundle *models.Bundle, submission *models.PlaySubmission) {
+	stop := touchWhileUploading(submission.Touch)
+	versionCode, err := uploadToPlay(storage, credential, bundle)
+	stop()
+	if err != nil {
+		revel.ERROR.Printf("failed to publish bundle %d to Google Play: %s", bundle.Id, err)
+		submission.State = models.PlayStateFailed
+		submission.Message = err.Error()
+	} else {
+		submission.State = models.PlayStateComplete
+		submission.VersionCode = versionCode
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return submission.Update(txn)
+	})
+	if err != nil {
+		revel.ERROR.Printf("failed to update Google Play submission %d: %s", submission.Id, err)
+	}
+}
+
+func uploadToPlay(storage models.Storage, credential *models.PlayCredential, bundle *models.Bundle) (int64, error) {
+	if bundle.IsRecalled() {
+		return 0, models.ErrBundleRecalled
+	}
+	if bundle.IsArchived() {
+		return 0, models.ErrBundleArchived
+	}
+	config, err := credential.ServiceAccountConfig()
+	if err != nil {
+		return 0, err
+	}
+	token, err := models.GetServiceAccountToken(config)
+	if err != nil {
+		return 0, err
+	}
+
+	object, err := storage.Get(bundle.FileId)
+	if err != nil {
+		return 0, err
+	}
+	defer object.Body.Close()
+
+	p := models.NewGooglePlay(token, credential.PackageName)
+	return p.PublishToInternalTrack(object.Body, bundle.IsAab())
+}
+
+// getPlayCredential returns the credential of the app with its service account key opened.
+func getPlayCredential(appId int) (*models.PlayCredential, error) {
+	credential, err := models.GetPlayCredential(Dbm, appId)
+	if err != nil {
+		return nil, err
+	}
+	if err := credential.Open(Conf.EnvFileKey); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// resumePlaySubmissions uploads again the submissions left uploading by a server stopped.
+func resumePlaySubmissions() error {
+	submissions, err := models.ClaimStalePlaySubmissions(Dbm, time.Now())
+	if err != nil || len(submissions) == 0 {
+		return err
+	}
+	storage, err := backgroundStorage()
+	if err != nil {
+		return err
+	}
+
+	for _, submission := range submissions {
+		bundle, err := models.GetBundle(Dbm, submission.BundleId)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		// the bundle is deleted with its submissions, unless it is deleted while this one is read
+		if err == sql.ErrNoRows {
+			continue
+		}
+		credential, err := getPlayCredential(bundle.AppId)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				return err
+			}
+			submission.State = models.PlayStateFailed
+			submission.Message = "Google Play service account key is not registered."
+			if err := Transact(submission.Update); err != nil {
+				return err
+			}
+			continue
+		}
+		revel.INFO.Printf("resuming Google Play submission %d of bundle %d", submission.Id, bundle.Id)
+		go publishToPlay(storage, credential, bundle, submission)
+	}
+	return nil
+}
@@ -28,18 +28,201 @@ func InitDB() {
 
 	bundleTableMap := Dbm.AddTableWithName(models.Bundle{}, "bundle")
 	bundleTableMap.SetKeys(true, "Id")
+	bundleTableMap.ColMap("InternalNotes").SetMaxSize(4096)
+
+	blobTableMap := Dbm.AddTableWithName(models.Blob{}, "bundle_blob")
+	blobTableMap.SetKeys(true, "Id")
+	blobTableMap.SetUniqueTogether("Digest", "StorageLocation")
+
+	bundleSplitTableMap := Dbm.AddTableWithName(models.BundleSplit{}, "bundle_split")
+	bundleSplitTableMap.SetKeys(true, "Id")
+	bundleSplitTableMap.SetUniqueTogether("BundleId", "Abi", "Density")
+
+	bundleDsymTableMap := Dbm.AddTableWithName(models.BundleDsym{}, "bundle_dsym")
+	bundleDsymTableMap.SetKeys(true, "Id")
+	bundleDsymTableMap.ColMap("Uuids").SetMaxSize(4096)
+
+	bundleMappingTableMap := Dbm.AddTableWithName(models.BundleMapping{}, "bundle_mapping")
+	bundleMappingTableMap.SetKeys(true, "Id")
+	bundleMappingTableMap.ColMap("BundleId").SetUnique(true)
+
+	folderTableMap := Dbm.AddTableWithName(models.Folder{}, "folder")
+	folderTableMap.SetKeys(true, "Id")
+	folderTableMap.SetUniqueTogether("AppId", "BundleVersion")
 
 	authorityTableMap := Dbm.AddTableWithName(models.Authority{}, "authority")
 	authorityTableMap.SetKeys(true, "Id")
 
+	knownIssueTableMap := Dbm.AddTableWithName(models.KnownIssue{}, "known_issue")
+	knownIssueTableMap.SetKeys(true, "Id")
+
+	changelogTableMap := Dbm.AddTableWithName(models.Changelog{}, "changelog")
+	changelogTableMap.SetKeys(true, "Id")
+	changelogTableMap.ColMap("Text").SetMaxSize(4096)
+	changelogTableMap.SetUniqueTogether("BundleId", "Language")
+
+	deviceGroupTableMap := Dbm.AddTableWithName(models.DeviceGroup{}, "device_group")
+	deviceGroupTableMap.SetKeys(true, "Id")
+
+	appStoreConnectKeyTableMap := Dbm.AddTableWithName(models.AppStoreConnectKey{}, "app_store_connect_key")
+	appStoreConnectKeyTableMap.SetKeys(true, "Id")
+	appStoreConnectKeyTableMap.ColMap("AppId").SetUnique(true)
+	appStoreConnectKeyTableMap.ColMap("PrivateKey").SetMaxSize(4096)
+
+	testFlightSubmissionTableMap := Dbm.AddTableWithName(models.TestFlightSubmission{}, "test_flight_submission")
+	testFlightSubmissionTableMap.SetKeys(true, "Id")
+	testFlightSubmissionTableMap.ColMap("Message").SetMaxSize(4096)
+
+	playCredentialTableMap := Dbm.AddTableWithName(models.PlayCredential{}, "play_credential")
+	playCredentialTableMap.SetKeys(true, "Id")
+	playCredentialTableMap.ColMap("AppId").SetUnique(true)
+	playCredentialTableMap.ColMap("ServiceAccountKey").SetMaxSize(8192)
+
+	playSubmissionTableMap := Dbm.AddTableWithName(models.PlaySubmission{}, "play_submission")
+	playSubmissionTableMap.SetKeys(true, "Id")
+	playSubmissionTableMap.ColMap("Message").SetMaxSize(4096)
+
+	deviceFarmTableMap := Dbm.AddTableWithName(models.DeviceFarm{}, "device_farm")
+	deviceFarmTableMap.SetKeys(true, "Id")
+	deviceFarmTableMap.SetUniqueTogether("AppId", "Provider")
+	deviceFarmTableMap.ColMap("ServiceAccountKey").SetMaxSize(8192)
+	deviceFarmTableMap.ColMap("Devices").SetMaxSize(4096)
+
+	deviceFarmRunTableMap := Dbm.AddTableWithName(models.DeviceFarmRun{}, "device_farm_run")
+	deviceFarmRunTableMap.SetKeys(true, "Id")
+	deviceFarmRunTableMap.ColMap("Message").SetMaxSize(4096)
+
+	universalApkBuildTableMap := Dbm.AddTableWithName(models.UniversalApkBuild{}, "universal_apk_build")
+	universalApkBuildTableMap.SetKeys(true, "Id")
+	universalApkBuildTableMap.ColMap("Message").SetMaxSize(4096)
+
+	bundleAnalysisTableMap := Dbm.AddTableWithName(models.BundleAnalysis{}, "bundle_analysis")
+	bundleAnalysisTableMap.SetKeys(true, "Id")
+	bundleAnalysisTableMap.ColMap("Permissions").SetMaxSize(8192)
+
+	provenanceTableMap := Dbm.AddTableWithName(models.Provenance{}, "provenance")
+	provenanceTableMap.SetKeys(true, "Id")
+	provenanceTableMap.ColMap("Message").SetMaxSize(4096)
+
+	bundleIconTableMap := Dbm.AddTableWithName(models.BundleIcon{}, "bundle_icon")
+	bundleIconTableMap.SetKeys(true, "Id")
+	bundleIconTableMap.ColMap("Png").SetMaxSize(65535)
+
+	accessRequestTableMap := Dbm.AddTableWithName(models.AccessRequest{}, "access_request")
+	accessRequestTableMap.SetKeys(true, "Id")
+
+	pairedDeviceTableMap := Dbm.AddTableWithName(models.PairedDevice{}, "paired_device")
+	pairedDeviceTableMap.SetKeys(true, "Id")
+	pairedDeviceTableMap.ColMap("TokenDigest").SetMaxSize(64)
+	pairedDeviceTableMap.ColMap("PushToken").SetMaxSize(512)
+
 	userTableMap := Dbm.AddTableWithName(models.User{}, "user")
 	userTableMap.SetKeys(true, "Id")
 
+	bandwidthUsageTableMap := Dbm.AddTableWithName(models.BandwidthUsage{}, "bandwidth_usage")
+	bandwidthUsageTableMap.SetKeys(true, "Id")
+	bandwidthUsageTableMap.SetUniqueTogether("Subject", "Date")
+
+	appStatTableMap := Dbm.AddTableWithName(models.AppStat{}, "app_stat")
+	appStatTableMap.SetKeys(true, "Id")
+	appStatTableMap.SetUniqueTogether("AppId", "Period", "Date")
+
+	auditRollupTableMap := Dbm.AddTableWithName(models.AuditRollup{}, "audit_rollup")
+	auditRollupTableMap.SetKeys(true, "Id")
+	auditRollupTableMap.SetUniqueTogether("Date", "Resource", "ResourceId", "Action")
+
+	eventTableMap := Dbm.AddTableWithName(models.Event{}, "event")
+	eventTableMap.SetKeys(true, "Id")
+	eventTableMap.ColMap("Resource").SetMaxSize(16)
+	eventTableMap.ColMap("Action").SetMaxSize(16)
+
+	eventSequenceTableMap := Dbm.AddTableWithName(models.EventSequence{}, "event_sequence")
+	eventSequenceTableMap.SetKeys(false, "Id")
+
+	idempotencyKeyTableMap := Dbm.AddTableWithName(models.IdempotencyKey{}, "idempotency_key")
+	idempotencyKeyTableMap.SetKeys(true, "Id")
+	idempotencyKeyTableMap.SetUniqueTogether("Scope", "Key")
+
+	uploadSessionTableMap := Dbm.AddTableWithName(models.UploadSession{}, "upload_session")
+	uploadSessionTableMap.SetKeys(true, "Id")
+
+	storageAlertTableMap := Dbm.AddTableWithName(models.StorageAlert{}, "storage_alert")
+	storageAlertTableMap.SetKeys(true, "Id")
+
+	authFailureTableMap := Dbm.AddTableWithName(models.AuthFailure{}, "auth_failure")
+	authFailureTableMap.SetKeys(true, "Id")
+
+	tokenCountryTableMap := Dbm.AddTableWithName(models.TokenCountry{}, "token_country")
+	tokenCountryTableMap.SetKeys(true, "Id")
+	tokenCountryTableMap.SetUniqueTogether("TokenKind", "TokenId", "Country")
+
+	tokenUsageTableMap := Dbm.AddTableWithName(models.TokenUsage{}, "token_usage")
+	tokenUsageTableMap.SetKeys(true, "Id")
+	tokenUsageTableMap.SetUniqueTogether("TokenKind", "TokenId", "TokenDigest")
+
+	tokenErrorTableMap := Dbm.AddTableWithName(models.TokenError{}, "token_error")
+	tokenErrorTableMap.SetKeys(true, "Id")
+
+	statusCheckTableMap := Dbm.AddTableWithName(models.StatusCheck{}, "status_check")
+	statusCheckTableMap.SetKeys(true, "Id")
+	statusCheckTableMap.ColMap("Message").SetMaxSize(1024)
+
+	statusIncidentTableMap := Dbm.AddTableWithName(models.StatusIncident{}, "status_incident")
+	statusIncidentTableMap.SetKeys(true, "Id")
+	statusIncidentTableMap.ColMap("Body").SetMaxSize(4096)
+
+	releasePlanTableMap := Dbm.AddTableWithName(models.ReleasePlan{}, "release_plan")
+	releasePlanTableMap.SetKeys(true, "Id")
+
+	notificationRouteTableMap := Dbm.AddTableWithName(models.NotificationRoute{}, "notification_route")
+	notificationRouteTableMap.SetKeys(true, "Id")
+	notificationRouteTableMap.ColMap("WebhookUrl").SetMaxSize(1024)
+
+	envFileTableMap := Dbm.AddTableWithName(models.EnvFile{}, "env_file")
+	envFileTableMap.SetKeys(true, "Id")
+	envFileTableMap.SetUniqueTogether("AppId", "Channel", "Name")
+
+	appDocRevisionTableMap := Dbm.AddTableWithName(models.AppDocRevision{}, "app_doc_revision")
+	appDocRevisionTableMap.SetKeys(true, "Id")
+	appDocRevisionTableMap.SetUniqueTogether("AppId", "Revision")
+	appDocRevisionTableMap.ColMap("Summary").SetMaxSize(255)
+
+	settingTableMap := Dbm.AddTableWithName(models.Setting{}, "setting")
+	settingTableMap.SetKeys(false, "Key")
+	settingTableMap.ColMap("Key").SetMaxSize(64)
+	settingTableMap.ColMap("Value").SetMaxSize(1024)
+
 	auditTableMap := Dbm.AddTableWithName(models.Audit{}, "audit")
 	auditTableMap.SetKeys(true, "Id")
 
+	webhookDeliveryTableMap := Dbm.AddTableWithName(models.WebhookDelivery{}, "webhook_delivery")
+	webhookDeliveryTableMap.SetKeys(true, "Id")
+	webhookDeliveryTableMap.ColMap("Url").SetMaxSize(1024)
+	webhookDeliveryTableMap.ColMap("Error").SetMaxSize(1024)
+
+	webhookAttemptTableMap := Dbm.AddTableWithName(models.WebhookAttempt{}, "webhook_attempt")
+	webhookAttemptTableMap.SetKeys(true, "Id")
+	webhookAttemptTableMap.ColMap("Response").SetMaxSize(4096)
+	webhookAttemptTableMap.ColMap("Error").SetMaxSize(1024)
+
+	storageMigrationFileTableMap := Dbm.AddTableWithName(models.StorageMigrationFile{}, "storage_migration_file")
+	storageMigrationFileTableMap.SetKeys(true, "Id")
+	storageMigrationFileTableMap.SetUniqueTogether("Backend", "SourceFileId")
+
+	schemaMigrationTableMap := Dbm.AddTableWithName(models.SchemaMigrationState{}, "schema_migration")
+	schemaMigrationTableMap.SetKeys(false, "Name")
+	schemaMigrationTableMap.ColMap("Name").SetMaxSize(64)
+	schemaMigrationTableMap.ColMap("Error").SetMaxSize(4096)
+
 	Dbm.TraceOn("[gorp]", revel.INFO)
 	Dbm.CreateTablesIfNotExists()
+
+	// the columns mapped above are added to the tables created by the older versions before serving
+	if err := models.ExpandSchema(Dbm, models.SchemaMigrations); err != nil {
+		panic(err)
+	}
+
+	initReplica()
 }
 
 func getDbm() *gorp.DbMap {
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sort"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+const idempotencyKeyArg = "idempotencyKey"
+
+// checkIdempotencyKey replays the recorded response if the request is a retry with the same Idempotency-Key,
+// and otherwise locks the key until the response is recorded by FinishIdempotencyKey.
+// The keys are in the scope of the token, so that the clients cannot see the responses of each other.
+func (c *ApiController) checkIdempotencyKey(scope string) revel.Result {
+	key := c.Request.Header.Get(models.IdempotencyKeyHeader)
+	if key == "" {
+		return nil
+	}
+	if len(key) > 255 {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Idempotency-Key is too long."}))
+	}
+
+	idempotencyKey, err := models.AcquireIdempotencyKey(Dbm, scope, key, c.requestDigest())
+	switch err {
+	case nil:
+	case models.ErrIdempotencyKeyInProgress:
+		c.Response.Status = http.StatusConflict
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	case models.ErrIdempotencyKeyMismatch:
+		c.Response.Status = http.StatusUnprocessableEntity
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	default:
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	if idempotencyKey.Completed() {
+		return &replayedResult{idempotencyKey}
+	}
+	c.Args[idempotencyKeyArg] = idempotencyKey
+	return nil
+}
+
+// requestDigest identifies the parameters of the request, so that a key reused for another request is refused.
+// The files are identified by the name and the digest of the content, so that a key reused for another build of the
+// same size is refused too.
+func (c *ApiController) requestDigest() string {
+	var keys []string
+	for key := range c.Params.Form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", c.Request.Method, c.Request.URL.Path)
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s=%q\n", key, c.Params.Form[key])
+	}
+	// the streamed upload has its parameters in the query
+	var queryKeys []string
+	for key := range c.Params.Query {
+		queryKeys = append(queryKeys, key)
+	}
+	sort.Strings(queryKeys)
+	for _, key := range queryKeys {
+		fmt.Fprintf(h, "?%s=%q\n", key, c.Params.Query[key])
+	}
+	for _, key := range sortedFileKeys(c.Params) {
+		for _, file := range c.Params.Files[key] {
+			fmt.Fprintf(h, "%s=%s:%s\n", key, file.Filename, fileHeaderDigest(file))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fileHeaderDigest reads the file spooled by the multipart parser, which is read again by the action.
+func fileHeaderDigest(fileHeader *multipart.FileHeader) string {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func sortedFileKeys(params *revel.Params) []string {
+	var keys []string
+	for key := range params.Files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FinishIdempotencyKey records the response of the locked key when it is written,
+// or releases the key of a panic so that the retry runs again.
+func (c *ApiController) FinishIdempotencyKey() revel.Result {
+	idempotencyKey, ok := c.Args[idempotencyKeyArg].(*models.IdempotencyKey)
+	if !ok {
+		return nil
+	}
+	if c.Result == nil {
+		if err := idempotencyKey.Release(Dbm); err != nil {
+			revel.ERROR.Printf("failed to release idempotency key %d: %s", idempotencyKey.Id, err)
+		}
+		return nil
+	}
+	c.Result = &recordedResult{Result: c.Result, idempotencyKey: idempotencyKey}
+	return nil
+}
+
+// a recordedResult records the response written by the result.
+type recordedResult struct {
+	revel.Result
+	idempotencyKey *models.IdempotencyKey
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recordedResult) Apply(req *revel.Request, resp *revel.Response) {
+	out := resp.Out
+	recorder := &responseRecorder{ResponseWriter: out}
+	resp.Out = recorder
+	r.Result.Apply(req, resp)
+	resp.Out = out
+
+	// the server errors are not the outcome of the request, which has to be retried
+	var err error
+	if resp.Status >= http.StatusInternalServerError {
+		err = r.idempotencyKey.Release(Dbm)
+	} else {
+		err = r.idempotencyKey.Complete(Dbm, resp.Status, out.Header().Get("Content-Type"), recorder.body.Bytes())
+	}
+	if err != nil {
+		revel.ERROR.Printf("failed to record idempotency key %d: %s", r.idempotencyKey.Id, err)
+	}
+}
+
+// a replayedResult writes the recorded response again.
+type replayedResult struct {
+	idempotencyKey *models.IdempotencyKey
+}
+
+func (r *replayedResult) Apply(req *revel.Request, resp *revel.Response) {
+	resp.Status = r.idempotencyKey.Status
+	resp.ContentType = r.idempotencyKey.ContentType
+	resp.Out.Header().Set("Idempotent-Replayed", "true")
+	resp.WriteHeader(r.idempotencyKey.Status, r.idempotencyKey.ContentType)
+	resp.Out.Write(r.idempotencyKey.Body)
+}
@@ -1,9 +1,15 @@
 package controllers
 
 import (
+	"crypto"
+	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/kayac/alphawing/app/models"
 
@@ -24,15 +30,109 @@ type Config struct {
 	ServiceAccountClientEmail  string
 	ServiceAccountPrivateKey   string
 	PagerDefaultLimit          int
+	DrivePermanentDelete       bool
+	DriveTrashRetentionDays    int
+	DriveTrashPurgeSchedule    string
+	AuditRetentionMonths       int
+	AuditPurgeSchedule         string
+	MdmProvider                string
+	MdmApiKey                  string
+	FirebaseProjectNumber      string
+	FcmProjectId               string
+	ProvenancePublicKeys       []crypto.PublicKey
+	BundleSigner               *models.BundleSigner
+	Mailer                     *models.Mailer
+	AdminApiToken              string
+	StorageLocations           map[string]string
+	BandwidthDailyLimit        int64
+	BandwidthRate              int64
+	ShareLinkHours             int
+	ProfileWarningDays         int
+	BadgeRateLimit             int
+	MirrorToken                string
+	MirrorLatestBundles        int
+	WarehouseDestination       string
+	WarehouseSchedule          string
+	WarehouseBigQueryProjectId string
+	WarehouseBigQueryDatasetId string
+	WarehouseS3Exporter        *models.S3Exporter
+	Tracer                     *models.Tracer
+	StorageFaults              *models.StorageFaults
+	FakeDrive                  *models.FakeDrive
+	Admins                     []string
+	SlackWebhookUrl            string
+	UploadMaxSizeMb            int
+	UploadSessionDir           string
+	SentryDsn                  string
+	StorageQuotaGb             int
+	StorageBackend             string
+	S3Storage                  *models.S3Storage
+	LocalStorage               *models.LocalStorage
+	GcsStorage                 *models.GcsStorage
+	WebDavStorage              *models.WebDavStorage
+	Cdn                        models.Cdn
+	BundleArchive              models.BundleArchive
+	Bundletool                 *models.Bundletool
+	ArchiveAfterDays           int
+	ArchiveSchedule            string
+	LogoUrl                    string
+	ContactUrl                 string
+	OverridesDir               string
+	MaintenanceMessage         string
+	OwnershipFallbackGroup     string
+	WebhookUrl                 string
+	WebhookSecret              string
+	ReplicaSpec                string
+	ReplicaMaxLag              time.Duration
+	SchemaBackfillBatchSize    int64
+	SchemaBackfillPause        time.Duration
+	AuthFailureLimit           int
+	ForbiddenAlertLimit        int
+	CountryHeader              string
+	TrustedProxies             []*net.IPNet
+	ChangelogLanguages         []string
+	EnvFileKey                 []byte
 }
 
 func init() {
 	// config
 	revel.OnAppStart(LoadConfig)
 
+	// the templates of branding.overridesdir
+	revel.OnAppStart(InitOverrides)
+
 	// gorp
 	revel.OnAppStart(InitDB)
 
+	// the credentials saved before they were sealed
+	revel.OnAppStart(SealCredentials)
+
+	// the files migrated to storage.backend before it was switched
+	revel.OnAppStart(ApplyStorageMigration)
+
+	// demo data
+	revel.OnAppStart(SeedOnStart)
+
+	// jobs
+	revel.OnAppStart(InitJobs)
+
+	// tracing
+	revel.InterceptMethod((*AlphaWingController).StartTrace, revel.BEFORE)
+	revel.InterceptMethod((*AlphaWingController).FinishTrace, revel.FINALLY)
+
+	// first run
+	revel.InterceptMethod((*AlphaWingController).CheckSetup, revel.BEFORE)
+
+	// maintenance
+	revel.InterceptMethod((*AlphaWingController).CheckMaintenance, revel.BEFORE)
+
+	// brute force
+	revel.InterceptMethod((*AlphaWingController).CheckAuthLockout, revel.BEFORE)
+	revel.InterceptMethod((*AlphaWingController).RecordAuthFailure, revel.FINALLY)
+
+	// token usage
+	revel.InterceptMethod((*AlphaWingController).RecordTokenUsage, revel.FINALLY)
+
 	// service account
 	revel.InterceptMethod((*AlphaWingController).InitGoogleService, revel.BEFORE)
 
@@ -50,6 +150,17 @@ func init() {
 	revel.InterceptMethod((*BundleControllerWithValidation).CheckForbidden, revel.BEFORE)
 	revel.InterceptMethod((*LimitedTimeController).CheckNotFound, revel.BEFORE)
 
+	// validate device token
+	revel.InterceptMethod((*DeviceApiController).CheckDeviceToken, revel.BEFORE)
+	revel.InterceptMethod((*AdminApiController).CheckAdminToken, revel.BEFORE)
+	revel.InterceptMethod((*MirrorApiController).CheckMirrorToken, revel.BEFORE)
+
+	// idempotency
+	revel.InterceptMethod((*ApiController).FinishIdempotencyKey, revel.FINALLY)
+
+	// validate admin
+	revel.InterceptMethod((*SettingsController).CheckAdmin, revel.BEFORE)
+
 	// validate limited time token
 	revel.InterceptMethod((*LimitedTimeController).CheckValidLimitedTimeToken, revel.BEFORE)
 
@@ -85,22 +196,338 @@ func LoadConfig() {
 		panic("undefined config: google.webapplication.callbackurl")
 	}
 
-	serviceAccountKeyPath, found := revel.Config.String("google.serviceaccount.keypath")
-	if !found {
-		panic("undefined config: google.serviceaccount.keypath")
+	// the fake storage keeps the files in memory without a service account, for the integration tests
+	var fakeDrive *models.FakeDrive
+	var serviceAccountClientEmail, serviceAccountPrivateKey string
+	if revel.Config.BoolDefault("storage.fake", false) {
+		if revel.RunMode == "prod" {
+			panic("storage.fake cannot be configured in prod")
+		}
+		serviceAccountClientEmail = "alphawing@fake.invalid"
+		fakeDrive = models.NewFakeDrive(serviceAccountClientEmail)
+	} else {
+		serviceAccountKeyPath, found := revel.Config.String("google.serviceaccount.keypath")
+		if !found {
+			panic("undefined config: google.serviceaccount.keypath")
+		}
+		keyBytes, err := ioutil.ReadFile(serviceAccountKeyPath)
+		if err != nil {
+			panic(err)
+		}
+		var keyMap map[string]string
+		if err := json.Unmarshal(keyBytes, &keyMap); err != nil {
+			panic(err)
+		}
+		serviceAccountClientEmail = keyMap["client_email"]
+		serviceAccountPrivateKey = keyMap["private_key"]
 	}
-	keyBytes, err := ioutil.ReadFile(serviceAccountKeyPath)
-	if err != nil {
-		panic(err)
+
+	pagerDefaultLimit := revel.Config.IntDefault("app.pager.default.limit", 25)
+
+	drivePermanentDelete := revel.Config.BoolDefault("google.drive.permanentdelete", false)
+	driveTrashRetentionDays := revel.Config.IntDefault("google.drive.trash.retentiondays", 30)
+	driveTrashPurgeSchedule := revel.Config.StringDefault("google.drive.trash.purgeschedule", "@daily")
+
+	mdmProvider, _ := revel.Config.String("mdm.provider")
+	mdmApiKey, _ := revel.Config.String("mdm.apikey")
+
+	firebaseProjectNumber, _ := revel.Config.String("firebase.projectnumber")
+
+	var provenancePublicKeys []crypto.PublicKey
+	if provenanceKeyPath, _ := revel.Config.String("provenance.publickeypath"); provenanceKeyPath != "" {
+		keyBytes, err := ioutil.ReadFile(provenanceKeyPath)
+		if err != nil {
+			panic(err)
+		}
+		provenancePublicKeys, err = models.ParsePublicKeys(keyBytes)
+		if err != nil {
+			panic(err)
+		}
 	}
-	var keyMap map[string]string
-	if err := json.Unmarshal(keyBytes, &keyMap); err != nil {
-		panic(err)
+
+	var bundleSigner *models.BundleSigner
+	if signingKeyPath, _ := revel.Config.String("signing.privatekeypath"); signingKeyPath != "" {
+		keyBytes, err := ioutil.ReadFile(signingKeyPath)
+		if err != nil {
+			panic(err)
+		}
+		bundleSigner, err = models.NewBundleSigner(keyBytes)
+		if err != nil {
+			panic(err)
+		}
 	}
-	serviceAccountClientEmail := keyMap["client_email"]
-	serviceAccountPrivateKey := keyMap["private_key"]
 
-	pagerDefaultLimit := revel.Config.IntDefault("app.pager.default.limit", 25)
+	// the apps pinned to a location keep their files in its folder, e.g. on a shared drive in the region
+	storageLocations := map[string]string{}
+	if names, _ := revel.Config.String("storage.locations"); names != "" {
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			folderId, found := revel.Config.String("storage.location." + name + ".folderid")
+			if !found || folderId == "" {
+				panic("undefined config: storage.location." + name + ".folderid")
+			}
+			storageLocations[name] = folderId
+		}
+	}
+
+	var mailer *models.Mailer
+	if smtpHost, _ := revel.Config.String("mail.smtp.host"); smtpHost != "" {
+		mailer = &models.Mailer{
+			Host:     smtpHost,
+			Port:     revel.Config.IntDefault("mail.smtp.port", 25),
+			Username: revel.Config.StringDefault("mail.smtp.username", ""),
+			Password: revel.Config.StringDefault("mail.smtp.password", ""),
+			From:     revel.Config.StringDefault("mail.from", "alphawing@"+smtpHost),
+		}
+	}
+
+	warehouseDestination, _ := revel.Config.String("warehouse.destination")
+	var warehouseS3Exporter *models.S3Exporter
+	switch warehouseDestination {
+	case "", "bigquery":
+	case "s3":
+		bucket, found := revel.Config.String("warehouse.s3.bucket")
+		if !found {
+			panic("undefined config: warehouse.s3.bucket")
+		}
+		warehouseS3Exporter = models.NewS3Exporter(
+			bucket,
+			revel.Config.StringDefault("warehouse.s3.region", "us-east-1"),
+			revel.Config.StringDefault("warehouse.s3.prefix", ""),
+			revel.Config.StringDefault("warehouse.s3.accesskeyid", ""),
+			revel.Config.StringDefault("warehouse.s3.secretaccesskey", ""),
+		)
+	default:
+		panic("unknown config: warehouse.destination = " + warehouseDestination)
+	}
+
+	var tracer *models.Tracer
+	if endpoint, _ := revel.Config.String("tracing.otlpendpoint"); endpoint != "" {
+		tracer = models.NewTracer(endpoint, revel.Config.StringDefault("tracing.servicename", "alphawing"))
+	}
+
+	// the faults are only for the tests and staging, never to break the production by mistake
+	var storageFaults *models.StorageFaults
+	errorPercent := revel.Config.IntDefault("storage.faults.errorpercent", 0)
+	latency := revel.Config.IntDefault("storage.faults.latencyms", 0)
+	if errorPercent > 0 || latency > 0 {
+		if revel.RunMode == "prod" {
+			panic("storage.faults.* cannot be configured in prod")
+		}
+		storageFaults = &models.StorageFaults{
+			ErrorPercent: errorPercent,
+			Latency:      time.Duration(latency) * time.Millisecond,
+		}
+		if methods, _ := revel.Config.String("storage.faults.methods"); methods != "" {
+			storageFaults.Methods = strings.Split(methods, ",")
+		}
+	}
+
+	// the bucket can be configured with storage.backend = drive, to migrate the files into it before switching the backend
+	var s3Storage *models.S3Storage
+	if bucket := revel.Config.StringDefault("storage.s3.bucket", ""); bucket != "" {
+		accessKeyId, found := revel.Config.String("storage.s3.accesskeyid")
+		if !found || accessKeyId == "" {
+			panic("undefined config: storage.s3.accesskeyid")
+		}
+		s3Storage = models.NewS3Storage(
+			bucket,
+			revel.Config.StringDefault("storage.s3.region", "us-east-1"),
+			revel.Config.StringDefault("storage.s3.prefix", ""),
+			accessKeyId,
+			revel.Config.StringDefault("storage.s3.secretaccesskey", ""),
+		)
+	}
+	// the root can be configured with another backend too, to migrate the files
+	var localStorage *models.LocalStorage
+	if root := revel.Config.StringDefault("storage.local.root", ""); root != "" {
+		localStorage = models.NewLocalStorage(root)
+	}
+	// the bucket is accessed by the service account of storage.gcs.keypath, or by the one of Google Drive
+	var gcsStorage *models.GcsStorage
+	if bucket := revel.Config.StringDefault("storage.gcs.bucket", ""); bucket != "" {
+		clientEmail, privateKey := serviceAccountClientEmail, serviceAccountPrivateKey
+		if keyPath := revel.Config.StringDefault("storage.gcs.keypath", ""); keyPath != "" {
+			keyBytes, err := ioutil.ReadFile(keyPath)
+			if err != nil {
+				panic(err)
+			}
+			var keyMap map[string]string
+			if err := json.Unmarshal(keyBytes, &keyMap); err != nil {
+				panic(err)
+			}
+			clientEmail, privateKey = keyMap["client_email"], keyMap["private_key"]
+		}
+		var err error
+		gcsStorage, err = models.NewGcsStorage(bucket, revel.Config.StringDefault("storage.gcs.prefix", ""), clientEmail, privateKey)
+		if err != nil {
+			panic(err)
+		}
+	}
+	// the internal servers are accessed with the basic authentication
+	var webDavStorage *models.WebDavStorage
+	if webDavUrl := revel.Config.StringDefault("storage.webdav.url", ""); webDavUrl != "" {
+		webDavStorage = models.NewWebDavStorage(
+			webDavUrl,
+			revel.Config.StringDefault("storage.webdav.username", ""),
+			revel.Config.StringDefault("storage.webdav.password", ""),
+		)
+	}
+	storageBackend := revel.Config.StringDefault("storage.backend", "drive")
+	switch storageBackend {
+	case "", "drive":
+		storageBackend = "drive"
+	case "s3":
+		if s3Storage == nil {
+			panic("undefined config: storage.s3.bucket")
+		}
+	case "local":
+		if localStorage == nil {
+			panic("undefined config: storage.local.root")
+		}
+	case "gcs":
+		if gcsStorage == nil {
+			panic("undefined config: storage.gcs.bucket")
+		}
+	case "webdav":
+		if webDavStorage == nil {
+			panic("undefined config: storage.webdav.url")
+		}
+	default:
+		panic("unknown config: storage.backend = " + storageBackend)
+	}
+
+	var cdn models.Cdn
+	switch provider, _ := revel.Config.String("cdn.provider"); provider {
+	case "":
+	case "cloudfront":
+		keyPairId, found := revel.Config.String("cdn.cloudfront.keypairid")
+		if !found || keyPairId == "" {
+			panic("undefined config: cdn.cloudfront.keypairid")
+		}
+		keyPath, found := revel.Config.String("cdn.cloudfront.privatekeypath")
+		if !found || keyPath == "" {
+			panic("undefined config: cdn.cloudfront.privatekeypath")
+		}
+		keyBytes, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			panic(err)
+		}
+		if cdn, err = models.NewCloudFront(cdnBaseUrl(), keyPairId, keyBytes); err != nil {
+			panic(err)
+		}
+	case "fastly":
+		secret, found := revel.Config.String("cdn.fastly.secret")
+		if !found || secret == "" {
+			panic("undefined config: cdn.fastly.secret")
+		}
+		cdn = models.NewFastly(cdnBaseUrl(), secret)
+	default:
+		panic("unknown config: cdn.provider = " + provider)
+	}
+	// the CDN fetches the files from the bucket
+	if cdn != nil && storageBackend != "s3" {
+		panic("cdn.provider cannot be configured without storage.backend = s3")
+	}
+
+	var bundleArchive models.BundleArchive
+	switch backend, _ := revel.Config.String("archive.backend"); backend {
+	case "":
+	case "drive":
+		folderId, found := revel.Config.String("archive.drive.folderid")
+		if !found || folderId == "" {
+			panic("undefined config: archive.drive.folderid")
+		}
+		bundleArchive = &models.DriveColdArchive{FolderId: folderId}
+	case "s3":
+		bucket, found := revel.Config.String("archive.s3.bucket")
+		if !found {
+			panic("undefined config: archive.s3.bucket")
+		}
+		bundleArchive = models.NewGlacierArchive(
+			bucket,
+			revel.Config.StringDefault("archive.s3.region", "us-east-1"),
+			revel.Config.StringDefault("archive.s3.prefix", ""),
+			revel.Config.StringDefault("archive.s3.accesskeyid", ""),
+			revel.Config.StringDefault("archive.s3.secretaccesskey", ""),
+			revel.Config.StringDefault("archive.s3.storageclass", "GLACIER"),
+			revel.Config.IntDefault("archive.s3.restoredays", 7),
+		)
+	default:
+		panic("unknown config: archive.backend = " + backend)
+	}
+	// the archives move the files out of Google Drive, so use the lifecycle rules of the bucket instead
+	if bundleArchive != nil && storageBackend != "drive" {
+		panic("archive.backend cannot be configured with storage.backend = " + storageBackend)
+	}
+
+	var bundletool *models.Bundletool
+	if path, _ := revel.Config.String("bundletool.path"); path != "" {
+		bundletool = &models.Bundletool{
+			Path:         path,
+			KeyStore:     revel.Config.StringDefault("bundletool.keystore", ""),
+			KeyStorePass: revel.Config.StringDefault("bundletool.keystorepass", ""),
+			KeyAlias:     revel.Config.StringDefault("bundletool.keyalias", ""),
+			KeyPass:      revel.Config.StringDefault("bundletool.keypass", ""),
+		}
+		if bundletool.KeyStore != "" && bundletool.KeyAlias == "" {
+			panic("undefined config: bundletool.keyalias")
+		}
+	}
+
+	var admins []string
+	if emails, _ := revel.Config.String("app.admins"); emails != "" {
+		for _, email := range strings.Split(emails, ",") {
+			admins = append(admins, strings.TrimSpace(email))
+		}
+	}
+
+	// the addresses of X-Forwarded-For are only read from the proxies, given as the addresses or the CIDRs
+	var trustedProxies []*net.IPNet
+	if proxies, _ := revel.Config.String("security.trustedproxies"); proxies != "" {
+		for _, proxy := range strings.Split(proxies, ",") {
+			proxy = strings.TrimSpace(proxy)
+			if !strings.Contains(proxy, "/") {
+				if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+					proxy += "/32"
+				} else {
+					proxy += "/128"
+				}
+			}
+			_, network, err := net.ParseCIDR(proxy)
+			if err != nil {
+				panic("invalid config: security.trustedproxies: " + err.Error())
+			}
+			trustedProxies = append(trustedProxies, network)
+		}
+	}
+
+	// the first language is the one of the descriptions
+	var changelogLanguages []string
+	for _, language := range strings.Split(revel.Config.StringDefault("changelog.languages", "ja,en"), ",") {
+		if language = models.NormalizeLanguage(language); language != "" {
+			changelogLanguages = append(changelogLanguages, language)
+		}
+	}
+	if len(changelogLanguages) == 0 {
+		panic("undefined config: changelog.languages")
+	}
+
+	// the env files are encrypted with the key derived from app.secret, unless envfile.key is given to rotate the secret
+	envFileKey := models.EnvFileKey(secret)
+	if encoded := revel.Config.StringDefault("envfile.key", ""); encoded != "" {
+		var err error
+		envFileKey, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(envFileKey) != 32 {
+			panic("envfile.key must be 32 bytes in base64")
+		}
+	}
+
+	// the lag of the replica is read with SHOW SLAVE STATUS
+	replicaSpec := revel.Config.StringDefault("db.replica.spec", "")
+	if replicaSpec != "" && revel.Config.StringDefault("db.driver", "") != "mysql" {
+		panic("db.replica.spec requires db.driver = mysql")
+	}
 
 	Conf = &Config{
 		Secret:                     secret,
@@ -112,7 +539,77 @@ func LoadConfig() {
 		ServiceAccountClientEmail:  serviceAccountClientEmail,
 		ServiceAccountPrivateKey:   serviceAccountPrivateKey,
 		PagerDefaultLimit:          pagerDefaultLimit,
+		DrivePermanentDelete:       drivePermanentDelete,
+		DriveTrashRetentionDays:    driveTrashRetentionDays,
+		DriveTrashPurgeSchedule:    driveTrashPurgeSchedule,
+		AuditRetentionMonths:       revel.Config.IntDefault("audit.retentionmonths", 0),
+		AuditPurgeSchedule:         revel.Config.StringDefault("audit.purgeschedule", "@daily"),
+		MdmProvider:                mdmProvider,
+		MdmApiKey:                  mdmApiKey,
+		FirebaseProjectNumber:      firebaseProjectNumber,
+		FcmProjectId:               revel.Config.StringDefault("fcm.projectid", ""),
+		ProvenancePublicKeys:       provenancePublicKeys,
+		BundleSigner:               bundleSigner,
+		Mailer:                     mailer,
+		AdminApiToken:              revel.Config.StringDefault("api.admintoken", ""),
+		StorageLocations:           storageLocations,
+		BandwidthDailyLimit:        int64(revel.Config.IntDefault("bandwidth.dailylimitmb", 0)) * 1024 * 1024,
+		BandwidthRate:              int64(revel.Config.IntDefault("bandwidth.ratekbps", 0)) * 1024 / 8,
+		ShareLinkHours:             revel.Config.IntDefault("download.sharelinkhours", 24),
+		ProfileWarningDays:         revel.Config.IntDefault("profile.warningdays", 14),
+		BadgeRateLimit:             revel.Config.IntDefault("badge.ratelimit", 60),
+		MirrorToken:                revel.Config.StringDefault("mirror.token", ""),
+		MirrorLatestBundles:        revel.Config.IntDefault("mirror.latestbundles", 3),
+		WarehouseDestination:       warehouseDestination,
+		WarehouseSchedule:          revel.Config.StringDefault("warehouse.schedule", "@daily"),
+		WarehouseBigQueryProjectId: revel.Config.StringDefault("warehouse.bigquery.projectid", ""),
+		WarehouseBigQueryDatasetId: revel.Config.StringDefault("warehouse.bigquery.dataset", "alphawing"),
+		WarehouseS3Exporter:        warehouseS3Exporter,
+		Tracer:                     tracer,
+		StorageFaults:              storageFaults,
+		FakeDrive:                  fakeDrive,
+		Admins:                     admins,
+		SlackWebhookUrl:            revel.Config.StringDefault("notification.slack.webhookurl", ""),
+		UploadMaxSizeMb:            revel.Config.IntDefault("upload.maxsizemb", 0),
+		UploadSessionDir:           revel.Config.StringDefault("upload.sessiondir", filepath.Join(os.TempDir(), "alphawing-upload")),
+		SentryDsn:                  revel.Config.StringDefault("errorreporting.sentrydsn", ""),
+		StorageQuotaGb:             revel.Config.IntDefault("storage.quotagb", 0),
+		StorageBackend:             storageBackend,
+		S3Storage:                  s3Storage,
+		LocalStorage:               localStorage,
+		GcsStorage:                 gcsStorage,
+		WebDavStorage:              webDavStorage,
+		Cdn:                        cdn,
+		Bundletool:                 bundletool,
+		BundleArchive:              bundleArchive,
+		ArchiveAfterDays:           revel.Config.IntDefault("archive.afterdays", 180),
+		ArchiveSchedule:            revel.Config.StringDefault("archive.schedule", "@daily"),
+		LogoUrl:                    revel.Config.StringDefault("branding.logourl", ""),
+		ContactUrl:                 revel.Config.StringDefault("branding.contacturl", ""),
+		OverridesDir:               revel.Config.StringDefault("branding.overridesdir", ""),
+		MaintenanceMessage:         revel.Config.StringDefault("maintenance.message", ""),
+		OwnershipFallbackGroup:     revel.Config.StringDefault("ownership.fallbackgroup", ""),
+		WebhookUrl:                 revel.Config.StringDefault("webhook.url", ""),
+		WebhookSecret:              revel.Config.StringDefault("webhook.secret", ""),
+		ReplicaSpec:                replicaSpec,
+		ReplicaMaxLag:              time.Duration(revel.Config.IntDefault("db.replica.maxlagseconds", 5)) * time.Second,
+		SchemaBackfillBatchSize:    int64(revel.Config.IntDefault("db.backfill.batchsize", 1000)),
+		SchemaBackfillPause:        time.Duration(revel.Config.IntDefault("db.backfill.pausems", 200)) * time.Millisecond,
+		AuthFailureLimit:           revel.Config.IntDefault("security.authfailurelimit", 10),
+		ForbiddenAlertLimit:        revel.Config.IntDefault("security.forbiddenalertlimit", 50),
+		CountryHeader:              revel.Config.StringDefault("security.countryheader", ""),
+		TrustedProxies:             trustedProxies,
+		ChangelogLanguages:         changelogLanguages,
+		EnvFileKey:                 envFileKey,
+	}
+}
+
+func cdnBaseUrl() string {
+	baseUrl, found := revel.Config.String("cdn.baseurl")
+	if !found || baseUrl == "" {
+		panic("undefined config: cdn.baseurl")
 	}
+	return baseUrl
 }
 
 func GenerateApiDocument() {
@@ -0,0 +1,460 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/modules/jobs/app/jobs"
+	"github.com/revel/revel"
+)
+
+func InitJobs() {
+	if !Conf.DrivePermanentDelete {
+		jobs.Schedule(Conf.DriveTrashPurgeSchedule, PurgeTrashJob{})
+	}
+	jobs.Schedule("@every 5m", TestFlightStateJob{})
+	jobs.Now(TestFlightStateJob{})
+	jobs.Schedule("@every 5m", DeviceFarmRunJob{})
+	jobs.Now(DeviceFarmRunJob{})
+	jobs.Schedule("@every 5m", PlaySubmissionJob{})
+	jobs.Now(PlaySubmissionJob{})
+	jobs.Schedule("@every 5m", UniversalApkBuildJob{})
+	jobs.Now(UniversalApkBuildJob{})
+	jobs.Schedule("@hourly", AppStatJob{})
+	jobs.Schedule("@hourly", PurgeIdempotencyKeyJob{})
+	jobs.Schedule("@hourly", PurgeAuthFailureJob{})
+	jobs.Schedule("@hourly", PurgeWebhookDeliveryJob{})
+	jobs.Schedule("@every 1m", WebhookRetryJob{})
+	jobs.Schedule("@hourly", PurgeUploadSessionJob{})
+	jobs.Schedule("@hourly", StorageQuotaJob{})
+	jobs.Schedule("@hourly", SuccessionJob{})
+	jobs.Schedule("@every 5m", StatusCheckJob{})
+	jobs.Schedule("@every 1m", SchemaBackfillJob{})
+	jobs.Schedule(Conf.AuditPurgeSchedule, PurgeAuditJob{})
+	if Conf.WarehouseDestination != "" {
+		jobs.Schedule(Conf.WarehouseSchedule, WarehouseExportJob{})
+	}
+	if Conf.BundleArchive != nil {
+		jobs.Schedule(Conf.ArchiveSchedule, ArchiveJob{})
+		jobs.Schedule("@every 5m", RestoreJob{})
+	}
+	if Conf.ReplicaSpec != "" {
+		jobs.Schedule("@every 30s", ReplicaLagJob{})
+	}
+}
+
+// runPurge logs how many the purge deleted, or only the error, as the count of a failed purge is not what it deleted.
+func runPurge(job, noun string, purge func() (int, error)) {
+	count, err := purge()
+	if err != nil {
+		revel.ERROR.Printf("%s: %s", job, err)
+		return
+	}
+	revel.INFO.Printf("%s: purged %d %s", job, count, noun)
+}
+
+// ----------------------------------------------------------------------
+// PurgeTrashJob
+type PurgeTrashJob struct{}
+
+func (j PurgeTrashJob) Run() {
+	s, err := NewServiceAccountGoogleService()
+	if err != nil {
+		revel.ERROR.Printf("PurgeTrashJob: %s", err)
+		return
+	}
+
+	settings, err := currentSettings()
+	if err != nil {
+		revel.ERROR.Printf("PurgeTrashJob: %s", err)
+		return
+	}
+
+	before := time.Now().AddDate(0, 0, -settings.Int(models.SettingDriveTrashRetentionDays))
+	runPurge("PurgeTrashJob", "files", func() (int, error) {
+		return s.PurgeTrashedFiles(before)
+	})
+}
+
+// ----------------------------------------------------------------------
+// PurgeAuditJob
+type PurgeAuditJob struct{}
+
+// the audits are kept forever while the retention is 0
+func (j PurgeAuditJob) Run() {
+	settings, err := currentSettings()
+	if err != nil {
+		revel.ERROR.Printf("PurgeAuditJob: %s", err)
+		return
+	}
+	months := settings.Int(models.SettingAuditRetentionMonths)
+	if months == 0 {
+		return
+	}
+
+	before := time.Now().AddDate(0, -months, 0)
+	runPurge("PurgeAuditJob", "audits", func() (int, error) {
+		return models.RollupAndPurgeAudits(Dbm, before)
+	})
+}
+
+// ----------------------------------------------------------------------
+// PurgeIdempotencyKeyJob
+type PurgeIdempotencyKeyJob struct{}
+
+// the retries of the clients are expected in a day
+func (j PurgeIdempotencyKeyJob) Run() {
+	before := time.Now().AddDate(0, 0, -1)
+	runPurge("PurgeIdempotencyKeyJob", "keys", func() (int, error) {
+		return models.PurgeIdempotencyKeys(Dbm, before)
+	})
+}
+
+// ----------------------------------------------------------------------
+// PurgeWebhookDeliveryJob
+type PurgeWebhookDeliveryJob struct{}
+
+// the deliveries are kept for a month, to look back on the failures of the integrations
+func (j PurgeWebhookDeliveryJob) Run() {
+	runPurge("PurgeWebhookDeliveryJob", "deliveries", func() (int, error) {
+		return models.PurgeWebhookDeliveries(Dbm, time.Now().AddDate(0, 0, -30))
+	})
+}
+
+// ----------------------------------------------------------------------
+// PurgeAuthFailureJob
+type PurgeAuthFailureJob struct{}
+
+// the failures are counted only in the window
+func (j PurgeAuthFailureJob) Run() {
+	runPurge("PurgeAuthFailureJob", "failures", func() (int, error) {
+		return models.PurgeAuthFailures(Dbm, time.Now().Add(-models.AuthFailureWindow))
+	})
+}
+
+// ----------------------------------------------------------------------
+// PurgeUploadSessionJob
+type PurgeUploadSessionJob struct{}
+
+// the chunks of the uploads abandoned, e.g. by a failed CI job, would fill the disk
+func (j PurgeUploadSessionJob) Run() {
+	runPurge("PurgeUploadSessionJob", "sessions", func() (int, error) {
+		return models.PurgeUploadSessions(Dbm, Conf.UploadSessionDir, time.Now().Add(-models.UploadSessionLifetime))
+	})
+}
+
+// ----------------------------------------------------------------------
+// StorageQuotaJob
+type StorageQuotaJob struct{}
+
+func (j StorageQuotaJob) Run() {
+	s, err := NewServiceAccountGoogleService()
+	if err != nil {
+		revel.ERROR.Printf("StorageQuotaJob: %s", err)
+		return
+	}
+	settings, err := currentSettings()
+	if err != nil {
+		revel.ERROR.Printf("StorageQuotaJob: %s", err)
+		return
+	}
+
+	usage, err := models.GetStorageUsage(s, int64(settings.Int(models.SettingStorageQuotaGb))*1000000000)
+	if err != nil {
+		revel.ERROR.Printf("StorageQuotaJob: %s", err)
+		return
+	}
+	alert, err := models.UpdateStorageAlert(Dbm, usage)
+	if err != nil {
+		revel.ERROR.Printf("StorageQuotaJob: %s", err)
+		return
+	}
+	if alert != nil {
+		notifyStorageAlert(alert)
+	}
+}
+
+// notifyStorageAlert mails the admins and posts to Slack before the uploads start failing.
+func notifyStorageAlert(alert *models.StorageAlert) {
+	text := fmt.Sprintf(
+		"alphawing のストレージ使用量が上限の%d%%を超えました。(%.2fGB / %.2fGB)\n古いバンドルを削除するか、容量を追加してください。",
+		alert.Threshold, float64(alert.UsedBytes)/1000000000, float64(alert.QuotaBytes)/1000000000,
+	)
+	go postSlack(text)
+
+	if Conf.Mailer == nil || len(Conf.Admins) == 0 {
+		return
+	}
+	go sendMail(Conf.Admins, fmt.Sprintf("[alphawing] ストレージ使用量が%d%%を超えました", alert.Threshold), text+"\n")
+}
+
+// ----------------------------------------------------------------------
+// StatusCheckJob
+type StatusCheckJob struct{}
+
+// the service is up while the job runs, so its check only records that it ran
+func (j StatusCheckJob) Run() {
+	checks := map[string]error{
+		models.StatusComponentService:  nil,
+		models.StatusComponentDatabase: Dbm.Db.Ping(),
+		models.StatusComponentStorage:  checkStorageStatus(),
+	}
+	for component, checkErr := range checks {
+		if err := models.RecordStatusCheck(Dbm, component, checkErr); err != nil {
+			revel.ERROR.Printf("StatusCheckJob: %s", err)
+			return
+		}
+	}
+
+	if _, err := models.PurgeStatusChecks(Dbm, time.Now().AddDate(0, 0, -30)); err != nil {
+		revel.ERROR.Printf("StatusCheckJob: %s", err)
+	}
+}
+
+func checkStorageStatus() error {
+	s, err := NewServiceAccountGoogleService()
+	if err != nil {
+		return err
+	}
+	_, err = s.GetAbout()
+	return err
+}
+
+// ----------------------------------------------------------------------
+// ArchiveJob
+type ArchiveJob struct{}
+
+// a failed blob is left in the Google Drive to be archived on the next run
+func (j ArchiveJob) Run() {
+	s, err := NewServiceAccountGoogleService()
+	if err != nil {
+		revel.ERROR.Printf("ArchiveJob: %s", err)
+		return
+	}
+
+	blobs, err := models.GetArchivableBlobs(Dbm, time.Now().AddDate(0, 0, -Conf.ArchiveAfterDays))
+	if err != nil {
+		revel.ERROR.Printf("ArchiveJob: %s", err)
+		return
+	}
+	count := 0
+	for _, blob := range blobs {
+		if err := models.ArchiveBlob(Dbm, s, Conf.BundleArchive, blob); err != nil {
+			revel.ERROR.Printf("ArchiveJob: blob %d: %s", blob.Id, err)
+			continue
+		}
+		count++
+	}
+	revel.INFO.Printf("ArchiveJob: archived %d blobs", count)
+}
+
+// ----------------------------------------------------------------------
+// RestoreJob
+type RestoreJob struct{}
+
+// the restores requested by the downloads are put back once the archive is ready
+func (j RestoreJob) Run() {
+	blobs, err := models.GetRestoringBlobs(Dbm)
+	if err != nil {
+		revel.ERROR.Printf("RestoreJob: %s", err)
+		return
+	}
+	if len(blobs) == 0 {
+		return
+	}
+
+	s, err := NewServiceAccountGoogleService()
+	if err != nil {
+		revel.ERROR.Printf("RestoreJob: %s", err)
+		return
+	}
+	for _, blob := range blobs {
+		restored, err := models.RestoreBlob(Dbm, s, Conf.BundleArchive, blob)
+		if err != nil {
+			revel.ERROR.Printf("RestoreJob: blob %d: %s", blob.Id, err)
+			continue
+		}
+		if restored {
+			revel.INFO.Printf("RestoreJob: restored blob %d", blob.Id)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------
+// AppStatJob
+type AppStatJob struct{}
+
+// the stats of the last week are recomputed since the downloads of the earlier days may still be counted
+func (j AppStatJob) Run() {
+	since := time.Now().AddDate(0, 0, -7)
+	if err := models.RefreshAppStats(Dbm, since); err != nil {
+		revel.ERROR.Printf("AppStatJob: %s", err)
+	}
+}
+
+// ----------------------------------------------------------------------
+// WarehouseExportJob
+type WarehouseExportJob struct{}
+
+// the day before is exported, as the audits of today are still being added
+func (j WarehouseExportJob) Run() {
+	exporter, err := newWarehouseExporter()
+	if err != nil {
+		revel.ERROR.Printf("WarehouseExportJob: %s", err)
+		return
+	}
+
+	date := time.Now().AddDate(0, 0, -1)
+	if err := models.ExportWarehouse(Dbm, exporter, date); err != nil {
+		revel.ERROR.Printf("WarehouseExportJob: %s", err)
+		return
+	}
+	revel.INFO.Printf("WarehouseExportJob: exported %s", date.Format(models.AuditRollupDateFormat))
+}
+
+func newWarehouseExporter() (models.WarehouseExporter, error) {
+	if Conf.WarehouseS3Exporter != nil {
+		return Conf.WarehouseS3Exporter, nil
+	}
+
+	config := &models.ServiceAccountConfig{
+		ClientEmail: Conf.ServiceAccountClientEmail,
+		PrivateKey:  Conf.ServiceAccountPrivateKey,
+		Scope:       []string{models.BigQueryScope},
+	}
+	token, err := models.GetServiceAccountToken(config)
+	if err != nil {
+		return nil, err
+	}
+	return models.NewBigQueryExporter(token, Conf.WarehouseBigQueryProjectId, Conf.WarehouseBigQueryDatasetId), nil
+}
+
+// ----------------------------------------------------------------------
+// TestFlightStateJob
+type TestFlightStateJob struct{}
+
+// the submissions left uploading by a server stopped are uploaded again, from the start of the server on
+func (j TestFlightStateJob) Run() {
+	if err := resumeTestFlightSubmissions(); err != nil {
+		revel.ERROR.Printf("TestFlightStateJob: %s", err)
+	}
+
+	submissions, err := models.GetProcessingTestFlightSubmissions(Dbm)
+	if err != nil {
+		revel.ERROR.Printf("TestFlightStateJob: %s", err)
+		return
+	}
+
+	for _, submission := range submissions {
+		if err := refreshTestFlightState(submission); err != nil {
+			revel.ERROR.Printf("TestFlightStateJob: submission %d: %s", submission.Id, err)
+		}
+	}
+}
+
+func refreshTestFlightState(submission *models.TestFlightSubmission) error {
+	bundle, err := models.GetBundle(Dbm, submission.BundleId)
+	if err != nil {
+		return err
+	}
+	key, err := getAppStoreConnectKey(bundle.AppId)
+	if err != nil {
+		return err
+	}
+
+	state, message, err := models.NewAppStoreConnect(key).BuildUploadState(submission.BuildUploadId)
+	if err != nil {
+		return err
+	}
+	if state != models.TestFlightStateComplete && state != models.TestFlightStateFailed {
+		return nil
+	}
+
+	submission.State = state
+	submission.Message = message
+	return Transact(func(txn gorp.SqlExecutor) error {
+		return submission.Update(txn)
+	})
+}
+
+// ----------------------------------------------------------------------
+// PlaySubmissionJob
+type PlaySubmissionJob struct{}
+
+// the submissions left uploading by a server stopped are uploaded again, from the start of the server on
+func (j PlaySubmissionJob) Run() {
+	if err := resumePlaySubmissions(); err != nil {
+		revel.ERROR.Printf("PlaySubmissionJob: %s", err)
+	}
+}
+
+// ----------------------------------------------------------------------
+// UniversalApkBuildJob
+type UniversalApkBuildJob struct{}
+
+// the builds left by a server stopped, or failed and not retried as many times as they can be, are built again,
+// from the start of the server on
+func (j UniversalApkBuildJob) Run() {
+	if err := resumeUniversalApkBuilds(); err != nil {
+		revel.ERROR.Printf("UniversalApkBuildJob: %s", err)
+	}
+}
+
+// ----------------------------------------------------------------------
+// DeviceFarmRunJob
+type DeviceFarmRunJob struct{}
+
+// the runs left uploading by a server stopped are uploaded again, from the start of the server on
+func (j DeviceFarmRunJob) Run() {
+	if err := resumeDeviceFarmRuns(); err != nil {
+		revel.ERROR.Printf("DeviceFarmRunJob: %s", err)
+	}
+
+	runs, err := models.GetRunningDeviceFarmRuns(Dbm)
+	if err != nil {
+		revel.ERROR.Printf("DeviceFarmRunJob: %s", err)
+		return
+	}
+
+	for _, run := range runs {
+		if err := refreshDeviceFarmRun(run); err != nil {
+			revel.ERROR.Printf("DeviceFarmRunJob: run %d: %s", run.Id, err)
+		}
+	}
+}
+
+// the run is left running when the device farm is deleted from the app, as its state cannot be read any more
+func refreshDeviceFarmRun(run *models.DeviceFarmRun) error {
+	bundle, err := models.GetBundle(Dbm, run.BundleId)
+	if err != nil {
+		return err
+	}
+	deviceFarm, err := models.GetDeviceFarm(Dbm, bundle.AppId, run.Provider)
+	if err != nil {
+		return err
+	}
+	client, err := deviceFarm.Client(Conf.EnvFileKey)
+	if err != nil {
+		return err
+	}
+
+	state, message, url, err := client.State(bundle, run.RunId)
+	if err != nil {
+		return err
+	}
+	if state == run.State && (url == "" || url == run.Url) {
+		return nil
+	}
+
+	run.State = state
+	run.Message = message
+	if url != "" {
+		run.Url = url
+	}
+	return Transact(func(txn gorp.SqlExecutor) error {
+		return run.Update(txn)
+	})
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// notifyKnownIssue mails the members of the app so that the testers do not report the issue again.
+func (c *AlphaWingController) notifyKnownIssue(app *models.App, bundle *models.Bundle, issue *models.KnownIssue) {
+	if Conf.Mailer == nil {
+		return
+	}
+
+	authorities, err := app.Authorities(Dbm)
+	if err != nil {
+		revel.ERROR.Printf("failed to notify known issue %d: %s", issue.Id, err)
+		return
+	}
+	var to []string
+	for _, authority := range authorities {
+		to = append(to, authority.Email)
+	}
+
+	bundleUrl, err := c.UriFor(fmt.Sprintf("bundle/%d", bundle.Id))
+	if err != nil {
+		revel.ERROR.Printf("failed to notify known issue %d: %s", issue.Id, err)
+		return
+	}
+
+	subject := fmt.Sprintf("[alphawing] 既知の不具合が追加されました: %s %s", app.Title, bundle.VersionLabel())
+	err = mailBundle(bundle, to, subject, func(changelog string) string {
+		return fmt.Sprintf("%s %s に既知の不具合が追加されました。\n\n- %s\n\n%s%s\n", app.Title, bundle.VersionLabel(), issue.Title, changelogSection(changelog), bundleUrl)
+	})
+	if err != nil {
+		revel.ERROR.Printf("failed to notify known issue %d: %s", issue.Id, err)
+	}
+}
@@ -2,59 +2,96 @@ package controllers
 
 import (
 	"database/sql"
-	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
 	"github.com/revel/revel"
 )
 
 type LimitedTimeController struct {
 	AlphaWingController
 	Bundle *models.Bundle
+	// the paired device the URL is signed for, if any
+	Device *models.PairedDevice
 }
 
 func (c *LimitedTimeController) GetDownloadPlist(bundleId int) revel.Result {
+	if result := c.checkRecalled(c.Bundle); result != nil {
+		return result
+	}
 	bundle := c.Bundle
 
-	ipaUrl, err := c.UriFor(fmt.Sprintf("bundle/%d/download_ipa", bundle.Id))
+	ipaUrl, err := c.ipaUrl(bundle)
 	if err != nil {
 		panic(err)
 	}
 
-	signatureInfo := models.NewLimitedTimeSignatureInfo(ipaUrl.Host, ipaUrl.Path)
-	signatureInfo.RefreshSignature(Conf.Secret)
-
-	ipaUrl.RawQuery = signatureInfo.UrlValues().Encode()
-
 	r, err := bundle.PlistReader(Dbm, ipaUrl)
 	if err != nil {
 		panic(err)
 	}
 
+	c.setBundleHeaders(bundle)
 	c.Response.ContentType = "application/x-plist"
 	return c.RenderBinary(r, models.PlistFileName, revel.Attachment, time.Now())
 }
 
 func (c *LimitedTimeController) GetDownloadIpa(bundleId int) revel.Result {
-	resp, file, err := c.GoogleService.DownloadFile(c.Bundle.FileId)
+	if result := c.checkBandwidth(); result != nil {
+		return result
+	}
+	if result := c.checkRecalled(c.Bundle); result != nil {
+		return result
+	}
+	if result := c.checkArchived(c.Bundle); result != nil {
+		return result
+	}
+
+	err := c.createDownloadAudit(bundleId)
 	if err != nil {
 		panic(err)
 	}
 
-	modtime, err := time.Parse(time.RFC3339, file.ModifiedDate)
-	if err != nil {
+	c.setBundleHeaders(c.Bundle)
+	if err := c.setSignatureHeader(c.Bundle); err != nil {
 		panic(err)
 	}
+	if result := c.redirectToSignedURL(c.Bundle); result != nil {
+		return result
+	}
 
-	err = c.createAudit(models.ResourceBundle, bundleId, models.ActionDownload)
+	object, err := c.Storage.Get(c.Bundle.FileId)
 	if err != nil {
 		panic(err)
 	}
 
 	c.Response.ContentType = "application/octet-stream"
-	return c.RenderBinary(resp.Body, file.OriginalFilename, revel.Attachment, modtime)
+	return c.RenderBinary(c.meterBandwidth(object.Body), object.Name, revel.Attachment, object.ModTime)
+}
+
+func (c *LimitedTimeController) GetDownloadApk(bundleId int) revel.Result {
+	if result := c.checkBandwidth(); result != nil {
+		return result
+	}
+	if result := c.checkRecalled(c.Bundle); result != nil {
+		return result
+	}
+	if result := c.checkArchived(c.Bundle); result != nil {
+		return result
+	}
+	if result := c.checkUniversalApk(c.Bundle); result != nil {
+		return result
+	}
+
+	err := c.createDownloadAudit(bundleId)
+	if err != nil {
+		panic(err)
+	}
+
+	return c.renderApk(c.Bundle)
 }
 
 func (c *LimitedTimeController) CheckValidLimitedTimeToken() revel.Result {
@@ -98,10 +135,42 @@ func (c *LimitedTimeController) CheckValidLimitedTimeToken() revel.Result {
 		revel.ERROR.Printf("Token is invalid.")
 		return c.NotFound("")
 	}
+	if generation, ok := paramToSign.ShareGeneration(); ok && generation != bundle.ShareGeneration {
+		revel.ERROR.Printf("Share link is revoked.")
+		return c.NotFound("")
+	}
+
+	// the device may have been deleted since the URL was signed
+	if deviceId := paramToSign.DeviceId(); deviceId != 0 {
+		device, err := models.GetPairedDevice(Dbm, deviceId)
+		if err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+		if err == nil {
+			c.Device = device
+		}
+	}
 
 	return nil
 }
 
+// createDownloadAudit records the download for the paired device and its user, if the URL is signed for one.
+func (c *LimitedTimeController) createDownloadAudit(bundleId int) error {
+	audit := &models.Audit{
+		UserId:     c.LoginUserId,
+		Resource:   models.ResourceBundle,
+		ResourceId: bundleId,
+		Action:     models.ActionDownload,
+	}
+	if c.Device != nil {
+		audit.UserId = c.Device.UserId
+		audit.DeviceId = c.Device.Id
+	}
+	return Transact(func(txn gorp.SqlExecutor) error {
+		return audit.Save(txn)
+	})
+}
+
 func (c *LimitedTimeController) CheckNotFound() revel.Result {
 	bundleIdStr := c.Params.Get("bundleId")
 
@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coopernurse/gorp"
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+type JsonResponseUploadManifest struct {
+	*JsonResponse
+	Content []*models.ManifestArtifactJsonResponse `json:"content"`
+	Error   *models.UploadDiagnosis                `json:"error,omitempty"`
+}
+
+// a manifestUpload is an artifact of the manifest with its app and files, resolved before any bundle is stored.
+type manifestUpload struct {
+	artifact   *models.ManifestArtifact
+	app        *models.App
+	file       *os.File
+	provenance *os.File
+	bundle     *models.Bundle
+}
+
+// PostUploadManifest creates the bundles of the artifacts in the manifest, each to the app of its token, from the files uploaded as files.
+// Either all the bundles are created or none, as the ones created are deleted when one of them fails.
+func (c ApiController) PostUploadManifest(manifest string) revel.Result {
+	m, err := models.ParseUploadManifest(manifest)
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(&JsonResponseUploadManifest{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil, nil})
+	}
+
+	uploads := []*manifestUpload{}
+	defer func() {
+		for _, upload := range uploads {
+			removeManifestFile(upload.file)
+			removeManifestFile(upload.provenance)
+		}
+	}()
+
+	var errors []string
+	for _, artifact := range m.Artifacts {
+		app, err := c.appByApiToken(artifact.Token)
+		if err != nil {
+			c.Response.Status = http.StatusUnauthorized
+			return c.RenderJson(&JsonResponseUploadManifest{c.NewJsonResponse(c.Response.Status, []string{fmt.Sprintf("Token of %s is invalid.", artifact.File)}), nil, nil})
+		}
+
+		upload := &manifestUpload{artifact: artifact, app: app}
+		uploads = append(uploads, upload)
+
+		ext := models.BundleFileExtensionOf(artifact.File)
+		if !ext.IsValid() {
+			errors = append(errors, fmt.Sprintf("File extension of %s is not valid.", artifact.File))
+			continue
+		}
+		if upload.file, err = c.manifestFile(artifact.File); err != nil {
+			panic(err)
+		}
+		if upload.file == nil {
+			errors = append(errors, fmt.Sprintf("File %s is not uploaded.", artifact.File))
+			continue
+		}
+		if !withinUploadLimit(upload.file) {
+			errors = append(errors, fmt.Sprintf("File %s is too large.", artifact.File))
+			continue
+		}
+		if artifact.Provenance != "" {
+			if upload.provenance, err = c.manifestFile(artifact.Provenance); err != nil {
+				panic(err)
+			}
+			if upload.provenance == nil {
+				errors = append(errors, fmt.Sprintf("File %s is not uploaded.", artifact.Provenance))
+				continue
+			}
+		}
+		upload.bundle = artifact.Bundle(ext.PlatformType())
+		upload.bundle.UploadedBy = models.ApiUploader(artifact.Token)
+		upload.bundle.Translations = translations(artifact.Descriptions)
+		upload.bundle.File = upload.file
+	}
+	if len(errors) > 0 {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(&JsonResponseUploadManifest{c.NewJsonResponse(c.Response.Status, errors), nil, nil})
+	}
+
+	if result := c.checkIdempotencyKey(manifestIdempotencyScope(uploads)); result != nil {
+		return result
+	}
+
+	messages := []string{"Bundles are created!"}
+	for i, upload := range uploads {
+		noteAppWrite(upload.app.Id)
+		err := upload.app.CreateBundle(Dbm, c.Storage, upload.bundle)
+		if err == nil {
+			err = Transact(func(txn gorp.SqlExecutor) error {
+				return upload.bundle.AddKnownIssues(txn, models.ParseKnownIssues(upload.artifact.KnownIssues))
+			})
+		}
+		// the provenance is attached before the bundles are announced, so that the ones failing it are deleted too
+		if err == nil && upload.provenance != nil {
+			var p *models.Provenance
+			if p, err = attachProvenance(upload.bundle, upload.provenance); err == nil && !p.Verified {
+				messages = append(messages, fmt.Sprintf("%s: %s", upload.artifact.File, p.Message))
+			}
+		}
+		if err != nil {
+			// a failed bundle may have been saved before its file is stored
+			c.deleteManifestBundles(uploads[:i+1])
+			diagnosis := c.diagnoseUpload(err)
+			c.Response.Status = diagnosis.Status
+			message := fmt.Sprintf("%s: %s", upload.artifact.File, diagnosis.String())
+			return c.RenderJson(&JsonResponseUploadManifest{c.NewJsonResponse(c.Response.Status, []string{message, "No bundle is created."}), nil, diagnosis})
+		}
+	}
+
+	content := []*models.ManifestArtifactJsonResponse{}
+	for _, upload := range uploads {
+		c.cachePlist(upload.app, upload.bundle)
+		c.forwardBundle(upload.app, upload.bundle)
+		c.analyzeBundle(upload.app, upload.bundle)
+		c.pushBundle(upload.app, upload.bundle)
+		c.notifyBundle(upload.app, upload.bundle)
+
+		bundleJsonResponse, err := upload.bundle.JsonResponse(&c)
+		if err != nil {
+			c.Response.Status = http.StatusInternalServerError
+			return c.RenderJson(&JsonResponseUploadManifest{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil, nil})
+		}
+		content = append(content, &models.ManifestArtifactJsonResponse{
+			AppId:              upload.app.Id,
+			File:               upload.artifact.File,
+			BundleJsonResponse: bundleJsonResponse,
+		})
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseUploadManifest{c.NewJsonResponse(c.Response.Status, messages), content, nil})
+}
+
+// deleteManifestBundles deletes the bundles created for the manifest in a transaction, so that either all of them are
+// deleted or none, logging them when they are left.
+func (c ApiController) deleteManifestBundles(uploads []*manifestUpload) {
+	var ids []int
+	for _, upload := range uploads {
+		if upload.bundle.Id != 0 {
+			ids = append(ids, upload.bundle.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	err := models.TransactDeleting(Dbm, c.Storage, Conf.BundleArchive, func(txn gorp.SqlExecutor, storage models.Storage) error {
+		for _, id := range ids {
+			// the bundle is read again, as the file may not have been stored
+			bundle, err := models.GetBundle(txn, id)
+			if err != nil {
+				return err
+			}
+			if err := bundle.Delete(txn, storage); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		revel.ERROR.Printf("failed to delete bundles %v of the failed manifest: %s", ids, err)
+	}
+}
+
+// manifestIdempotencyScope scopes the idempotency key to all the apps of the manifest, so that a key is not shared
+// with the manifests of the other apps.
+func manifestIdempotencyScope(uploads []*manifestUpload) string {
+	var appIds []int
+	seen := map[int]bool{}
+	for _, upload := range uploads {
+		if !seen[upload.app.Id] {
+			seen[upload.app.Id] = true
+			appIds = append(appIds, upload.app.Id)
+		}
+	}
+	sort.Ints(appIds)
+
+	ids := make([]string, len(appIds))
+	for i, appId := range appIds {
+		ids[i] = strconv.Itoa(appId)
+	}
+	return "manifest:apps:" + strings.Join(ids, ",")
+}
+
+// manifestFile copies the uploaded file of the name to a temporary file, or returns nil if it is not uploaded,
+// as the files of a manifest are sent in the same field.
+func (c ApiController) manifestFile(name string) (*os.File, error) {
+	var header *multipart.FileHeader
+	for _, h := range c.Params.Files["files"] {
+		if h.Filename == name {
+			header = h
+			break
+		}
+	}
+	if header == nil {
+		return nil, nil
+	}
+
+	src, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	tmp, err := ioutil.TempFile("", "alphawing-manifest")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		removeManifestFile(tmp)
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, os.SEEK_SET); err != nil {
+		removeManifestFile(tmp)
+		return nil, err
+	}
+	return tmp, nil
+}
+
+func removeManifestFile(file *os.File) {
+	if file == nil {
+		return
+	}
+	file.Close()
+	os.Remove(file.Name())
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/kayac/alphawing/app/models"
+	"github.com/kayac/alphawing/app/routes"
+
+	"github.com/revel/revel"
+)
+
+type JsonResponseBundleMapping struct {
+	*JsonResponse
+	Content *models.BundleMappingJsonResponse `json:"content"`
+}
+
+func (c ApiController) NewJsonResponseBundleMapping(stat int, mes []string, content *models.BundleMappingJsonResponse) *JsonResponseBundleMapping {
+	return &JsonResponseBundleMapping{
+		JsonResponse: c.NewJsonResponse(stat, mes),
+		Content:      content,
+	}
+}
+
+// PostUploadMapping stores the mapping.txt of ProGuard or R8 with the Android bundle of the file_id.
+func (c ApiController) PostUploadMapping(token string, file_id string, file *os.File) revel.Result {
+	app, err := c.appByApiToken(token)
+	if err != nil {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponseBundleMapping(c.Response.Status, []string{"Token is invalid."}, nil))
+	}
+	if result := c.checkIdempotencyKey(fmt.Sprintf("app:%d", app.Id)); result != nil {
+		return result
+	}
+
+	var filename string
+	if _, ok := c.Params.Files["file"]; ok {
+		filename = c.Params.Files["file"][0].Filename
+	}
+	c.Validation.Required(file_id).Message("file_id is required.")
+	c.Validation.Required(file != nil).Message("File is required.")
+	c.Validation.Required(strings.HasSuffix(strings.ToLower(filename), ".txt")).Message("File extension is not valid.")
+	c.Validation.Required(withinUploadLimit(file)).Message("File is too large.")
+	if c.Validation.HasErrors() {
+		var errors []string
+		for _, err := range c.Validation.Errors {
+			errors = append(errors, err.String())
+		}
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseBundleMapping(c.Response.Status, errors, nil))
+	}
+
+	bundle, err := app.GetBundleByFileId(Dbm, file_id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJson(c.NewJsonResponseBundleMapping(c.Response.Status, []string{"Bundle not found."}, nil))
+	}
+
+	noteAppWrite(app.Id)
+	mapping, err := bundle.AddMapping(Dbm, c.Storage, file)
+	if err != nil {
+		c.Response.Status = mappingErrorStatus(err)
+		return c.RenderJson(c.NewJsonResponseBundleMapping(c.Response.Status, []string{err.Error()}, nil))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(c.NewJsonResponseBundleMapping(c.Response.Status, []string{"Mapping is uploaded!"}, mapping.JsonResponse(bundle)))
+}
+
+// mappingErrorStatus returns the status of the errors of the mapping uploaded, panicking for the other errors.
+func mappingErrorStatus(err error) int {
+	if _, ok := err.(*models.AppQuotaError); ok {
+		return http.StatusInsufficientStorage
+	}
+	switch err {
+	case models.ErrMappingNotApk, models.ErrMappingInvalid, models.ErrMappingVersionCode:
+		return http.StatusBadRequest
+	case models.ErrMappingDuplicated:
+		return http.StatusConflict
+	}
+	panic(err)
+}
+
+// GetDownloadMapping serves the mapping of the newest bundle of the app with the versionCode.
+func (c ApiController) GetDownloadMapping(token string, version_code string) revel.Result {
+	app, err := c.appByApiToken(token)
+	if err != nil {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponseBundleMapping(c.Response.Status, []string{"Token is invalid."}, nil))
+	}
+
+	c.Validation.Required(version_code).Message("version_code is required.")
+	if c.Validation.HasErrors() {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseBundleMapping(c.Response.Status, []string{"version_code is required."}, nil))
+	}
+
+	mapping, bundle, err := app.MappingByVersionCode(Dbm, version_code)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJson(c.NewJsonResponseBundleMapping(c.Response.Status, []string{"Mapping not found."}, nil))
+	}
+	return c.renderMapping(mapping, bundle)
+}
+
+// GetDownloadMapping serves the mapping of the bundle to the developers of the app.
+func (c BundleControllerWithValidation) GetDownloadMapping(bundleId int) revel.Result {
+	app, err := c.Bundle.App(Dbm)
+	if err != nil {
+		panic(err)
+	}
+	isDeveloper, err := c.isDeveloper(app)
+	if err != nil {
+		panic(err)
+	}
+	if !isDeveloper {
+		c.Flash.Error("Permission denied.")
+		return c.Redirect(routes.BundleControllerWithValidation.GetBundle(bundleId))
+	}
+
+	mapping, err := c.Bundle.Mapping(Dbm)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		return c.NotFound("Mapping is not found.")
+	}
+	return c.renderMapping(mapping, c.Bundle)
+}
+
+func (c *AlphaWingController) renderMapping(mapping *models.BundleMapping, bundle *models.Bundle) revel.Result {
+	if result := c.redirectFileToSignedURL(mapping.FileId); result != nil {
+		return result
+	}
+
+	object, err := c.Storage.Get(mapping.FileId)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Response.ContentType = "text/plain; charset=utf-8"
+	return c.RenderBinary(c.meterBandwidth(object.Body), mapping.FileName(bundle), revel.Attachment, object.ModTime)
+}
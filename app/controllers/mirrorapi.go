@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// MirrorApiController lets the caching nodes in remote offices mirror the latest bundles
+// and report the downloads they served from the LAN.
+type MirrorApiController struct {
+	ApiController
+}
+
+type JsonResponseMirrorFeed struct {
+	*JsonResponse
+	Content *MirrorFeedJsonResponse `json:"content"`
+}
+
+// the cursor changes whenever a bundle is added or removed, so the nodes can skip the sync while it stays the same
+type MirrorFeedJsonResponse struct {
+	Cursor  string                      `json:"cursor"`
+	Changed bool                        `json:"changed"`
+	Bundles []*MirrorBundleJsonResponse `json:"bundles"`
+}
+
+type MirrorBundleJsonResponse struct {
+	BundleId     int    `json:"bundle_id"`
+	AppId        int    `json:"app_id"`
+	AppTitle     string `json:"app_title"`
+	PlatformType string `json:"platform_type"`
+	Version      string `json:"version"`
+	Revision     int    `json:"revision"`
+	Codename     string `json:"codename"`
+	Digest       string `json:"digest"`
+	CreatedAt    string `json:"created_at"`
+	DownloadUrl  string `json:"download_url"`
+}
+
+// the downloads of a bundle a node can report at a time, far more than a LAN serves between the reports
+const MirrorStatMaxDownloads = 100000
+
+type MirrorStat struct {
+	BundleId  int `json:"bundle_id"`
+	Downloads int `json:"downloads"`
+}
+
+// GetFeed returns the latest bundles of every app except the private ones.
+func (c MirrorApiController) GetFeed(cursor string, latest int) revel.Result {
+	if latest <= 0 {
+		latest = Conf.MirrorLatestBundles
+	}
+
+	apps, err := models.GetMirroredApps(Dbm)
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	content := &MirrorFeedJsonResponse{Bundles: []*MirrorBundleJsonResponse{}}
+	var ids []string
+	for _, app := range apps {
+		bundles, err := app.LatestBundles(Dbm, latest)
+		if err != nil {
+			c.Response.Status = http.StatusInternalServerError
+			return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+		}
+
+		for _, bundle := range bundles {
+			// an aab is mirrored once its universal APK is built, which has no digest recorded
+			if bundle.InstallFileId() == "" {
+				continue
+			}
+			bundleDigest := bundle.Digest
+			if bundle.IsAab() {
+				bundleDigest = ""
+			}
+			path := fmt.Sprintf("bundle/%d/download_limited_apk", bundle.Id)
+			if bundle.IsIpa() {
+				path = fmt.Sprintf("bundle/%d/download_ipa", bundle.Id)
+			} else if bundle.IsPlainDownload() {
+				path = fmt.Sprintf("bundle/%d/download_limited_file", bundle.Id)
+			}
+			downloadUrl, err := c.LimitedTimeUriFor(path)
+			if err != nil {
+				c.Response.Status = http.StatusInternalServerError
+				return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+			}
+
+			content.Bundles = append(content.Bundles, &MirrorBundleJsonResponse{
+				BundleId:     bundle.Id,
+				AppId:        app.Id,
+				AppTitle:     app.Title,
+				PlatformType: bundle.PlatformType.String(),
+				Version:      bundle.BundleVersion,
+				Revision:     bundle.Revision,
+				Codename:     bundle.Codename,
+				Digest:       bundleDigest,
+				CreatedAt:    bundle.CreatedAt.Format(time.RFC3339),
+				DownloadUrl:  downloadUrl.String(),
+			})
+			ids = append(ids, fmt.Sprint(bundle.Id))
+		}
+	}
+
+	digest := sha256.Sum256([]byte(strings.Join(ids, ",")))
+	content.Cursor = hex.EncodeToString(digest[:])
+	content.Changed = content.Cursor != cursor
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseMirrorFeed{c.NewJsonResponse(c.Response.Status, []string{"Mirror Feed"}), content})
+}
+
+// PostStats records the downloads served by the node to the daily counts of the bundles, without an audit per download.
+func (c MirrorApiController) PostStats(stats string) revel.Result {
+	if result := c.checkIdempotencyKey("mirror"); result != nil {
+		return result
+	}
+	var entries []*MirrorStat
+	if err := json.Unmarshal([]byte(stats), &entries); err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"stats is invalid."}))
+	}
+	for _, entry := range entries {
+		if entry.Downloads < 0 || entry.Downloads > MirrorStatMaxDownloads {
+			c.Response.Status = http.StatusBadRequest
+			return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{fmt.Sprintf("downloads must be between 0 and %d.", MirrorStatMaxDownloads)}))
+		}
+	}
+
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		for _, entry := range entries {
+			if _, err := models.GetBundle(txn, entry.BundleId); err != nil {
+				if err == sql.ErrNoRows {
+					continue
+				}
+				return err
+			}
+			if entry.Downloads == 0 {
+				continue
+			}
+			if err := models.AddAuditRollup(txn, models.ResourceBundle, entry.BundleId, models.ActionDownload, entry.Downloads, time.Now()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Stats are recorded!"}))
+}
+
+func (c *MirrorApiController) CheckMirrorToken() revel.Result {
+	if Conf.MirrorToken == "" {
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Mirror API is not enabled."}))
+	}
+
+	token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+	if !hmac.Equal([]byte(token), []byte(Conf.MirrorToken)) {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{"Token is invalid."}))
+	}
+	c.checkTokenCountry(models.TokenKindMirror, 0, "ミラーAPIのトークン")
+	c.useToken(models.TokenKindMirror, 0, token)
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// notifyBundle posts the new bundle to the webhooks of the routes of the app matching it, each once.
+func (c *AlphaWingController) notifyBundle(app *models.App, bundle *models.Bundle) {
+	routes, err := app.NotificationRoutes(Dbm)
+	if err != nil {
+		revel.ERROR.Printf("failed to notify bundle %d: %s", bundle.Id, err)
+		return
+	}
+
+	var webhookUrls []string
+	seen := map[string]bool{}
+	for _, route := range routes {
+		// the routes added before the host was checked are skipped
+		if !models.IsSlackWebhookUrl(route.WebhookUrl) {
+			revel.WARN.Printf("skipped notification route %d not to Slack", route.Id)
+			continue
+		}
+		if route.Matches(bundle) && !seen[route.WebhookUrl] {
+			seen[route.WebhookUrl] = true
+			webhookUrls = append(webhookUrls, route.WebhookUrl)
+		}
+	}
+	if len(webhookUrls) == 0 {
+		return
+	}
+
+	bundleUrl, err := c.UriFor(fmt.Sprintf("bundle/%d", bundle.Id))
+	if err != nil {
+		revel.ERROR.Printf("failed to notify bundle %d: %s", bundle.Id, err)
+		return
+	}
+
+	text := fmt.Sprintf("%s %s [%s] が追加されました。", app.Title, bundle.VersionLabel(), bundle.PlatformType)
+	if bundle.Channel != "" {
+		text += fmt.Sprintf("\nチャンネル: %s", bundle.Channel)
+	}
+	if tags := bundle.TagList(); len(tags) > 0 {
+		text += fmt.Sprintf("\nタグ: %s", strings.Join(tags, ", "))
+	}
+	images, err := c.iconChangeImages(bundle)
+	if err != nil {
+		revel.ERROR.Printf("failed to notify bundle %d: %s", bundle.Id, err)
+		return
+	}
+	if len(images) > 0 {
+		text += "\nアプリのアイコンが変更されました。"
+	}
+	if bundle.SignerChanged() {
+		text += "\napkの署名の証明書が以前のapkと異なります。テスターはインストール済みのアプリを更新できません。"
+	}
+	if warnsProfileExpiry(bundle) {
+		text += fmt.Sprintf("\nプロビジョニングプロファイルの有効期限が近づいています (%s まで)。", bundle.ProfileExpiresAt.Format("2006/01/02 15:04"))
+	}
+	text += "\n" + bundleUrl.String()
+
+	payload := models.SlackMessage(text)
+	if len(images) > 0 {
+		payload = models.SlackMessageWithImages(text, images)
+	}
+	go func() {
+		for _, webhookUrl := range webhookUrls {
+			if err := deliverWebhook(app.Id, models.WebhookDeliveryKindSlack, models.WebhookEventBundleCreated, webhookUrl, payload); err != nil {
+				revel.ERROR.Printf("failed to notify bundle %d: %s", bundle.Id, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+type JsonResponseDeactivateUser struct {
+	*JsonResponse
+	Content *models.UserDeactivationReport `json:"content"`
+}
+
+// PostDeactivateUser stops the user who left the organization from logging in,
+// and the apps left without an active owner are succeeded by the fallback group.
+func (c AdminApiController) PostDeactivateUser(email string) revel.Result {
+	if result := c.checkIdempotencyKey("admin"); result != nil {
+		return result
+	}
+	user, result := c.findUser(email)
+	if result != nil {
+		return result
+	}
+
+	report := &models.UserDeactivationReport{Email: user.Email, Deactivated: true, OwnedApps: []int{}}
+	var succeeded []*models.App
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		if err := user.Deactivate(txn); err != nil {
+			return err
+		}
+		revoked, err := user.RevokePairedDevices(txn)
+		if err != nil {
+			return err
+		}
+		report.RevokedDevices = revoked
+		apps, err := user.OwnedApps(txn)
+		if err != nil {
+			return err
+		}
+		for _, app := range apps {
+			report.OwnedApps = append(report.OwnedApps, app.Id)
+		}
+		succeeded, err = succeedApps(txn, c.GoogleService, report.OwnedApps)
+		return err
+	})
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+	report.SucceededApps = appIds(succeeded)
+	notifySuccession(succeeded)
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseDeactivateUser{c.NewJsonResponse(c.Response.Status, []string{"User is deactivated!"}), report})
+}
+
+// PostReactivateUser lets the user log in again, e.g. when deactivated by mistake.
+// The apps succeeded meanwhile keep the fallback group as an owner.
+func (c AdminApiController) PostReactivateUser(email string) revel.Result {
+	user, result := c.findUser(email)
+	if result != nil {
+		return result
+	}
+
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		return user.Reactivate(txn)
+	})
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	report := &models.UserDeactivationReport{Email: user.Email, Deactivated: false, OwnedApps: []int{}, SucceededApps: []int{}}
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseDeactivateUser{c.NewJsonResponse(c.Response.Status, []string{"User is reactivated!"}), report})
+}
+
+// succeedApps makes the fallback group the owner of the apps which have no active owner, and returns the ones succeeded.
+// Nothing is succeeded while ownership.fallbackgroup is empty.
+func succeedApps(txn gorp.SqlExecutor, s *models.GoogleService, appIds []int) ([]*models.App, error) {
+	settings, err := currentSettings()
+	if err != nil {
+		return nil, err
+	}
+	group := strings.TrimSpace(settings[models.SettingOwnershipFallbackGroup])
+	if group == "" {
+		return nil, nil
+	}
+
+	var succeeded []*models.App
+	for _, appId := range appIds {
+		app, err := models.GetApp(txn, appId)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := app.Succeed(txn, s, group)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			succeeded = append(succeeded, app)
+		}
+	}
+	return succeeded, nil
+}
+
+// notifySuccession mails the admins and posts to Slack, as the group may not notice the apps it owns now.
+func notifySuccession(apps []*models.App) {
+	if len(apps) == 0 {
+		return
+	}
+	settings, err := currentSettings()
+	if err != nil {
+		revel.ERROR.Printf("failed to notify the succession: %s", err)
+		return
+	}
+	group := settings[models.SettingOwnershipFallbackGroup]
+
+	titles := []string{}
+	for _, app := range apps {
+		titles = append(titles, app.Title)
+	}
+	text := fmt.Sprintf("管理者が不在になったため、次のプロジェクトの管理者に %s を追加しました。\n%s", group, strings.Join(titles, "\n"))
+	go postSlack(text)
+
+	if Conf.Mailer == nil || len(Conf.Admins) == 0 {
+		return
+	}
+	go sendMail(Conf.Admins, fmt.Sprintf("[alphawing] %d件のプロジェクトを %s に引き継ぎました", len(apps), group), text+"\n")
+}
+
+func appIds(apps []*models.App) []int {
+	ids := []int{}
+	for _, app := range apps {
+		ids = append(ids, app.Id)
+	}
+	return ids
+}
+
+// ----------------------------------------------------------------------
+// SuccessionJob
+type SuccessionJob struct{}
+
+// the apps orphaned while the fallback group is not set are succeeded once it is
+func (j SuccessionJob) Run() {
+	apps, err := models.OrphanedApps(Dbm)
+	if err != nil {
+		revel.ERROR.Printf("SuccessionJob: %s", err)
+		return
+	}
+	if len(apps) == 0 {
+		return
+	}
+
+	s, err := NewServiceAccountGoogleService()
+	if err != nil {
+		revel.ERROR.Printf("SuccessionJob: %s", err)
+		return
+	}
+
+	var succeeded []*models.App
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		succeeded, err = succeedApps(txn, s, appIds(apps))
+		return err
+	})
+	if err != nil {
+		revel.ERROR.Printf("SuccessionJob: %s", err)
+		return
+	}
+	notifySuccession(succeeded)
+	revel.INFO.Printf("SuccessionJob: succeeded %d apps", len(succeeded))
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// the URL of an ipa signed for a plist is reused in the plists for a third of its lifetime, so that the installer
+// still has 10 minutes to start the download from the plist served last
+const signedIpaUrlReuse = models.SignatureExpireDuration / 3
+
+type signedIpaUrl struct {
+	url      *url.URL
+	signedAt time.Time
+}
+
+// the URLs of the ipas signed by the bundle, the host and the paired device
+var signedIpaUrls = struct {
+	sync.Mutex
+	urls    map[string]*signedIpaUrl
+	sweptAt time.Time
+}{urls: map[string]*signedIpaUrl{}}
+
+// cachePlist marshals the plist of the ipa uploaded, before the push of the bundle brings the installs.
+func (c *AlphaWingController) cachePlist(app *models.App, bundle *models.Bundle) {
+	if !bundle.IsIpa() {
+		return
+	}
+	if err := models.CachePlist(app, bundle); err != nil {
+		revel.ERROR.Printf("failed to cache the plist of bundle %d: %s", bundle.Id, err)
+	}
+}
+
+// ipaUrl returns the URL of the ipa for the plist, signed for the paired device of the request if any.
+func (c *LimitedTimeController) ipaUrl(bundle *models.Bundle) (*url.URL, error) {
+	path := fmt.Sprintf("bundle/%d/download_ipa", bundle.Id)
+	base, err := c.UriFor(path)
+	if err != nil {
+		return nil, err
+	}
+	deviceId := 0
+	if c.Device != nil {
+		deviceId = c.Device.Id
+	}
+	key := fmt.Sprintf("%s %d", base, deviceId)
+
+	now := time.Now()
+	signedIpaUrls.Lock()
+	defer signedIpaUrls.Unlock()
+
+	if signed := signedIpaUrls.urls[key]; signed != nil && now.Sub(signed.signedAt) < signedIpaUrlReuse {
+		u := *signed.url
+		return &u, nil
+	}
+
+	var u *url.URL
+	if c.Device != nil {
+		u, err = c.deviceLimitedTimeUriFor(path, c.Device.Id)
+	} else {
+		u, err = c.LimitedTimeUriFor(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if now.Sub(signedIpaUrls.sweptAt) > signedIpaUrlReuse {
+		for k, signed := range signedIpaUrls.urls {
+			if now.Sub(signed.signedAt) >= signedIpaUrlReuse {
+				delete(signedIpaUrls.urls, k)
+			}
+		}
+		signedIpaUrls.sweptAt = now
+	}
+	signedIpaUrls.urls[key] = &signedIpaUrl{url: u, signedAt: now}
+
+	copied := *u
+	return &copied, nil
+}
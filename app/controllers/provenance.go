@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+)
+
+// attachProvenance verifies the uploaded attestation with the configured keys and records it to the bundle.
+func attachProvenance(bundle *models.Bundle, file *os.File) (*models.Provenance, error) {
+	envelope, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var provenance *models.Provenance
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		p, err := bundle.AttachProvenance(txn, envelope, Conf.ProvenancePublicKeys)
+		if err != nil {
+			return err
+		}
+		provenance = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return provenance, nil
+}
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// pushBundle notifies the devices of the members opted into the push notifications of the new bundle in the background.
+// The link opens the bundle page, and the companion apps get a fresh install URL for the bundle from the device API,
+// as the limited time install URLs would expire before the push is opened.
+func (c *AlphaWingController) pushBundle(app *models.App, bundle *models.Bundle) {
+	if Conf.FcmProjectId == "" {
+		return
+	}
+
+	devices, err := models.GetPushDevicesForApp(Dbm, app.Id)
+	if err != nil {
+		revel.ERROR.Printf("failed to push bundle %d: %s", bundle.Id, err)
+		return
+	}
+	if len(devices) == 0 {
+		return
+	}
+
+	bundleUrl, err := c.UriFor(fmt.Sprintf("bundle/%d", bundle.Id))
+	if err != nil {
+		revel.ERROR.Printf("failed to push bundle %d: %s", bundle.Id, err)
+		return
+	}
+
+	message := &models.PushMessage{
+		Title: fmt.Sprintf("%s %s", app.Title, bundle.VersionLabel()),
+		Body:  "新しいバージョンが追加されました。タップしてインストールできます。",
+		Link:  bundleUrl.String(),
+		Data: map[string]string{
+			"app_id":        strconv.Itoa(app.Id),
+			"bundle_id":     strconv.Itoa(bundle.Id),
+			"platform_type": bundle.PlatformType.String(),
+		},
+	}
+	go func() {
+		if err := sendPush(devices, message); err != nil {
+			revel.ERROR.Printf("failed to push bundle %d: %s", bundle.Id, err)
+		}
+	}()
+}
+
+// sendPush sends the message to every device, and opts the devices out whose push tokens are no longer valid.
+func sendPush(devices []*models.PairedDevice, message *models.PushMessage) error {
+	config := &models.ServiceAccountConfig{
+		ClientEmail: Conf.ServiceAccountClientEmail,
+		PrivateKey:  Conf.ServiceAccountPrivateKey,
+		Scope:       []string{models.FirebaseMessagingScope},
+	}
+	token, err := models.GetServiceAccountToken(config)
+	if err != nil {
+		return err
+	}
+
+	f := models.NewFirebaseCloudMessaging(token, Conf.FcmProjectId)
+	for _, device := range devices {
+		err := f.Send(device.PushToken, message)
+		if err == nil {
+			continue
+		}
+		if !models.IsUnregisteredPushToken(err) {
+			revel.ERROR.Printf("failed to push to device %d: %s", device.Id, err)
+			continue
+		}
+		err = Transact(func(txn gorp.SqlExecutor) error {
+			return device.SetPushToken(txn, "")
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
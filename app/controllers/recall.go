@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// checkRecalled renders the recall notice with the replacement instead of the download of the recalled bundle.
+// It is checked before the archive, not to restore the bundle which cannot be installed.
+func (c *AlphaWingController) checkRecalled(bundle *models.Bundle) revel.Result {
+	if !bundle.IsRecalled() {
+		return nil
+	}
+
+	replacement, err := bundle.Replacement(Dbm)
+	if err != nil {
+		panic(err)
+	}
+
+	c.InitRenderArgs()
+	c.RenderArgs["bundle"] = bundle
+	c.RenderArgs["replacement"] = replacement
+	c.Response.Status = http.StatusGone
+	return c.RenderTemplate("BundleControllerWithValidation/GetRecalled.html")
+}
+
+// notifyRecall mails the members of the app, so that the testers who have installed the bundle replace it.
+func (c *AlphaWingController) notifyRecall(app *models.App, bundle *models.Bundle) {
+	if Conf.Mailer == nil {
+		return
+	}
+
+	authorities, err := app.Authorities(Dbm)
+	if err != nil {
+		revel.ERROR.Printf("failed to notify recall of bundle %d: %s", bundle.Id, err)
+		return
+	}
+	var to []string
+	for _, authority := range authorities {
+		to = append(to, authority.Email)
+	}
+
+	bundleUrl, err := c.UriFor(fmt.Sprintf("bundle/%d", bundle.Id))
+	if err != nil {
+		revel.ERROR.Printf("failed to notify recall of bundle %d: %s", bundle.Id, err)
+		return
+	}
+
+	subject := fmt.Sprintf("[alphawing] バージョンが回収されました: %s %s", app.Title, bundle.VersionLabel())
+	err = mailBundle(bundle, to, subject, func(changelog string) string {
+		return fmt.Sprintf("%s %s は回収されました。インストール済みの場合は削除し、代わりのバージョンをインストールしてください。\n\n%s\n\n%s%s\n", app.Title, bundle.VersionLabel(), bundle.RecallReason, changelogSection(changelog), bundleUrl)
+	})
+	if err != nil {
+		revel.ERROR.Printf("failed to notify recall of bundle %d: %s", bundle.Id, err)
+	}
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// ReadDbm serves the listings and the stats from the read replica, or is Dbm while db.replica.spec is not configured.
+// Only the reads which may be a little stale use it, and the writes always go to Dbm.
+var ReadDbm *gorp.DbMap
+
+var errReplicationStopped = errors.New("replication of the replica is stopped")
+
+var replica = struct {
+	sync.Mutex
+	// the time each app is written at, to read the app from the primary until the replica catches up
+	writtenAt map[int]time.Time
+	// false while the replica lags behind more than db.replica.maxlag or its replication is stopped
+	healthy bool
+}{writtenAt: map[int]time.Time{}, healthy: true}
+
+func initReplica() {
+	ReadDbm = Dbm
+	if Conf.ReplicaSpec == "" {
+		return
+	}
+
+	driver, _ := revel.Config.String("db.driver")
+	db, err := sql.Open(driver, Conf.ReplicaSpec)
+	if err != nil {
+		panic(err)
+	}
+	ReadDbm = &gorp.DbMap{Db: db, Dialect: Dbm.Dialect}
+	ReadDbm.TraceOn("[gorp replica]", revel.INFO)
+}
+
+// readDbm returns the replica to read the app from, unless the app is written within the lag the replica is allowed,
+// so that the bundle just uploaded is listed.
+func readDbm(appId int) *gorp.DbMap {
+	if ReadDbm == Dbm {
+		return Dbm
+	}
+
+	replica.Lock()
+	defer replica.Unlock()
+	if !replica.healthy {
+		return Dbm
+	}
+	if writtenAt, ok := replica.writtenAt[appId]; ok {
+		if time.Since(writtenAt) < Conf.ReplicaMaxLag {
+			return Dbm
+		}
+		delete(replica.writtenAt, appId)
+	}
+	return ReadDbm
+}
+
+// noteAppWrite reads the app from the primary for a while, as the replica may not have the write yet.
+// It is kept in the process, so the read after the write on another server may still be stale.
+func noteAppWrite(appId int) {
+	if ReadDbm == Dbm {
+		return
+	}
+
+	replica.Lock()
+	defer replica.Unlock()
+	replica.writtenAt[appId] = time.Now()
+}
+
+// ----------------------------------------------------------------------
+// ReplicaLagJob
+type ReplicaLagJob struct{}
+
+// the reads go to the primary while the replica lags, as the writes of the apps noted may be older than the lag by then
+func (j ReplicaLagJob) Run() {
+	lag, err := replicaLag(ReadDbm.Db)
+	healthy := err == nil && lag <= Conf.ReplicaMaxLag
+	if err != nil {
+		revel.ERROR.Printf("ReplicaLagJob: %s", err)
+	}
+
+	replica.Lock()
+	defer replica.Unlock()
+	if replica.healthy != healthy {
+		revel.WARN.Printf("ReplicaLagJob: replica lags %s, healthy is %t", lag, healthy)
+	}
+	replica.healthy = healthy
+}
+
+// replicaLag returns Seconds_Behind_Master of MySQL, or an error if the replication is stopped.
+func replicaLag(db *sql.DB) (time.Duration, error) {
+	rows, err := db.Query("SHOW SLAVE STATUS")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errReplicationStopped
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Master" {
+			continue
+		}
+		// NULL while the replication is stopped
+		if values[i] == nil {
+			return 0, errReplicationStopped
+		}
+		seconds, err := strconv.Atoi(string(values[i]))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, errReplicationStopped
+}
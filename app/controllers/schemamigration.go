@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+type JsonResponseSchemaMigrations struct {
+	*JsonResponse
+	Content []*models.SchemaMigrationJsonResponse `json:"content"`
+}
+
+// GetSchemaMigrations reports the phases of the migrations of the schema and the progress of their backfills.
+func (c AdminApiController) GetSchemaMigrations() revel.Result {
+	states, err := models.SchemaMigrationStates(Dbm)
+	if err != nil {
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponse(c.Response.Status, []string{err.Error()}))
+	}
+
+	content := []*models.SchemaMigrationJsonResponse{}
+	for _, m := range models.SchemaMigrations {
+		state, ok := states[m.Name]
+		if !ok {
+			// expanded by the next start of a server of this version
+			state = &models.SchemaMigrationState{Name: m.Name}
+		}
+		content = append(content, m.JsonResponse(state))
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseSchemaMigrations{c.NewJsonResponse(c.Response.Status, []string{"Schema Migrations"}), content})
+}
+
+// ----------------------------------------------------------------------
+// SchemaBackfillJob
+type SchemaBackfillJob struct{}
+
+// the job of a server runs a backfill until it is done, while the jobs of the other servers skip it
+func (j SchemaBackfillJob) Run() {
+	storage, err := backgroundStorage()
+	if err != nil {
+		revel.ERROR.Printf("SchemaBackfillJob: %s", err)
+		return
+	}
+	if err := models.BackfillSchema(Dbm, storage, models.SchemaMigrations, Conf.SchemaBackfillBatchSize, Conf.SchemaBackfillPause); err != nil {
+		revel.ERROR.Printf("SchemaBackfillJob: %s", err)
+	}
+}
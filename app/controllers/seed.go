@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// Seed makes the demo data with the users of the first permitted domain unless the emails are given.
+func Seed(developerEmail, testerEmail string) (*models.SeedReport, error) {
+	if developerEmail == "" {
+		developerEmail = "demo-developer@" + Conf.PermittedDomains[0]
+	}
+	if testerEmail == "" {
+		testerEmail = "demo-tester@" + Conf.PermittedDomains[0]
+	}
+
+	s, err := NewServiceAccountGoogleService()
+	if err != nil {
+		return nil, err
+	}
+	return models.Seed(Dbm, s, newStorage(s), developerEmail, testerEmail)
+}
+
+// SeedOnStart makes the demo data when the server starts with the empty database,
+// for the fake storage which loses the files made by the seed command.
+func SeedOnStart() {
+	if !revel.Config.BoolDefault("seed.onstart", false) {
+		return
+	}
+
+	report, err := Seed("", "")
+	if err == models.ErrAlreadySeeded {
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+	revel.INFO.Printf("seeded %d apps and %d bundles", len(report.Apps), len(report.Bundles))
+}
@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+	"github.com/kayac/alphawing/app/routes"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// SettingsController lets the admins in app.admins change the operational settings without a redeploy.
+type SettingsController struct {
+	AuthController
+}
+
+// settingDefaults are the settings in app.conf, which are used until they are changed in the settings.
+func settingDefaults() models.Settings {
+	return models.Settings{
+		models.SettingOrganizationName:        Conf.OrganizationName,
+		models.SettingSlackWebhookUrl:         Conf.SlackWebhookUrl,
+		models.SettingDriveTrashRetentionDays: strconv.Itoa(Conf.DriveTrashRetentionDays),
+		models.SettingAuditRetentionMonths:    strconv.Itoa(Conf.AuditRetentionMonths),
+		models.SettingUploadMaxSizeMb:         strconv.Itoa(Conf.UploadMaxSizeMb),
+		models.SettingSentryDsn:               Conf.SentryDsn,
+		models.SettingStorageQuotaGb:          strconv.Itoa(Conf.StorageQuotaGb),
+		models.SettingLogoUrl:                 Conf.LogoUrl,
+		models.SettingContactUrl:              Conf.ContactUrl,
+		models.SettingMaintenanceMessage:      Conf.MaintenanceMessage,
+		models.SettingOwnershipFallbackGroup:  Conf.OwnershipFallbackGroup,
+		models.SettingWebhookUrl:              Conf.WebhookUrl,
+	}
+}
+
+// the settings are read on every page, so they are cached for a while. A change applies to the server which saved
+// it at once, and to the other servers when their caches expire.
+const settingsCacheDuration = 10 * time.Second
+
+var settingsCache = struct {
+	sync.Mutex
+	settings  models.Settings
+	expiresAt time.Time
+}{}
+
+// currentSettings returns the cached settings, reading them again once the cache has expired.
+func currentSettings() (models.Settings, error) {
+	settingsCache.Lock()
+	defer settingsCache.Unlock()
+
+	now := time.Now()
+	if settingsCache.settings != nil && now.Before(settingsCache.expiresAt) {
+		return settingsCache.settings, nil
+	}
+	settings, err := models.GetSettings(Dbm, settingDefaults())
+	if err != nil {
+		return nil, err
+	}
+	settingsCache.settings = settings
+	settingsCache.expiresAt = now.Add(settingsCacheDuration)
+	return settings, nil
+}
+
+// expireSettings drops the cached settings after they are saved.
+func expireSettings() {
+	settingsCache.Lock()
+	defer settingsCache.Unlock()
+	settingsCache.settings = nil
+}
+
+func isAdminEmail(email string) bool {
+	for _, admin := range Conf.Admins {
+		if admin == email {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *SettingsController) CheckAdmin() revel.Result {
+	user, err := models.GetUser(Dbm, c.LoginUserId)
+	if err != nil {
+		panic(err)
+	}
+	if !isAdminEmail(user.Email) {
+		return c.Forbidden("Only the admins can change the settings.")
+	}
+	return nil
+}
+
+func (c SettingsController) GetSettings() revel.Result {
+	settings, err := models.GetSettingsJsonResponse(Dbm, settingDefaults())
+	if err != nil {
+		panic(err)
+	}
+	incidents, err := models.GetStatusIncidents(Dbm, time.Now().AddDate(0, 0, -7))
+	if err != nil {
+		panic(err)
+	}
+	return c.Render(settings, incidents)
+}
+
+func (c SettingsController) PostSettings() revel.Result {
+	values := models.Settings{}
+	for _, definition := range models.SettingDefinitions {
+		values[definition.Key] = c.Params.Get(definition.Key)
+	}
+
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		return models.SaveSettings(txn, values)
+	})
+	expireSettings()
+	if err != nil {
+		c.Flash.Error(err.Error())
+		return c.Redirect(routes.SettingsController.GetSettings())
+	}
+
+	c.Flash.Success("Settings are updated!")
+	return c.Redirect(routes.SettingsController.GetSettings())
+}
+
+// PostCreateIncident posts the note to the status page.
+func (c SettingsController) PostCreateIncident(title, body string) revel.Result {
+	c.Validation.Required(title).Message("Title is required.")
+	c.Validation.MaxSize(title, 255).Message("Title is too long.")
+	c.Validation.MaxSize(body, 4096).Message("Body is too long.")
+	if c.Validation.HasErrors() {
+		c.Validation.Keep()
+		c.FlashParams()
+		return c.Redirect(routes.SettingsController.GetSettings())
+	}
+
+	incident := &models.StatusIncident{
+		Title: title,
+		Body:  body,
+	}
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		return incident.Save(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Incident is posted!")
+	return c.Redirect(routes.SettingsController.GetSettings())
+}
+
+func (c SettingsController) PostResolveIncident(incidentId int) revel.Result {
+	incident, err := models.GetStatusIncident(Dbm, incidentId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NotFound("Incident is not found.")
+		}
+		panic(err)
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return incident.Resolve(txn)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Flash.Success("Incident is resolved!")
+	return c.Redirect(routes.SettingsController.GetSettings())
+}
+
+// GetInstallHistory shows the downloads of the user or the paired device, for the support to confirm which build a reporter runs.
+func (c SettingsController) GetInstallHistory(email string, deviceId int) revel.Result {
+	var user *models.User
+	var devices []*models.PairedDevice
+	var records []*models.InstallRecord
+	var err error
+
+	if deviceId != 0 {
+		device, err := models.GetPairedDevice(Dbm, deviceId)
+		if err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+		if err == nil {
+			user, err = models.GetUser(Dbm, device.UserId)
+			if err != nil {
+				panic(err)
+			}
+		}
+	} else if email != "" {
+		user, err = models.GetUserFromEmail(Dbm, email)
+		if err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+	}
+
+	if user != nil {
+		devices, err = models.GetPairedDevicesByUser(Dbm, user.Id)
+		if err != nil {
+			panic(err)
+		}
+		records, err = models.GetInstallHistory(Dbm, user.Id, deviceId, 0, Conf.PagerDefaultLimit)
+		if err != nil {
+			panic(err)
+		}
+	} else if email != "" || deviceId != 0 {
+		c.Flash.Error("User is not found.")
+	}
+
+	return c.Render(email, deviceId, user, devices, records)
+}
+
+// GetTokenUsage shows the usage of every token, to find the tokens no longer used to revoke and the integrations
+// sending too many requests.
+func (c SettingsController) GetTokenUsage() revel.Result {
+	activities, err := models.GetTokenActivities(Dbm, Conf.PagerDefaultLimit)
+	if err != nil {
+		panic(err)
+	}
+	activities = tokenActivities(activities)
+
+	return c.Render(activities)
+}
+
+// withinUploadLimit tells whether the uploaded file is not larger than the limit in the settings.
+func withinUploadLimit(file *os.File) bool {
+	if file == nil {
+		return true
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		panic(err)
+	}
+	return withinUploadSize(stat.Size())
+}
+
+// withinUploadSize tells whether the size of the upload is not larger than the limit in the settings.
+func withinUploadSize(size int64) bool {
+	settings, err := currentSettings()
+	if err != nil {
+		panic(err)
+	}
+	limit := int64(settings.Int(models.SettingUploadMaxSizeMb)) * 1024 * 1024
+	if limit == 0 {
+		return true
+	}
+	return size <= limit
+}
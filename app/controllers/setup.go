@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+
+	"code.google.com/p/google-api-go-client/drive/v2"
+
+	"github.com/kayac/alphawing/app/models"
+	"github.com/kayac/alphawing/app/routes"
+
+	"github.com/revel/revel"
+)
+
+// SetupController guides the first run until the first user logs in.
+// It does not embed AlphaWingController, so that it is served even when the service account is not working.
+type SetupController struct {
+	GorpController
+}
+
+// a SetupCheck is the result of a check of the settings, with how to fix it
+type SetupCheck struct {
+	Name    string
+	Ok      bool
+	Message string
+}
+
+func (c SetupController) GetSetup() revel.Result {
+	if result := c.checkFirstRun(); result != nil {
+		return result
+	}
+	return c.renderSetup(c.configChecks(), false)
+}
+
+// PostSetup tests the connections to the database and the storage, in addition to the settings.
+func (c SetupController) PostSetup() revel.Result {
+	if result := c.checkFirstRun(); result != nil {
+		return result
+	}
+	return c.renderSetup(append(c.configChecks(), connectivityChecks()...), true)
+}
+
+func (c SetupController) renderSetup(checks []*SetupCheck, tested bool) revel.Result {
+	c.RenderArgs["checks"] = checks
+	c.RenderArgs["tested"] = tested
+	c.RenderArgs["configSnippet"] = c.configSnippet()
+	c.RenderArgs["organizationName"] = Conf.OrganizationName
+	return c.RenderTemplate("SetupController/GetSetup.html")
+}
+
+// checkFirstRun hides the setup once anyone has logged in, as it tells how the server is configured.
+func (c SetupController) checkFirstRun() revel.Result {
+	firstRun, err := isFirstRun()
+	if err != nil {
+		panic(err)
+	}
+	if !firstRun {
+		return c.NotFound("")
+	}
+	return nil
+}
+
+func isFirstRun() (bool, error) {
+	count, err := Dbm.SelectInt("SELECT COUNT(*) FROM user")
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+func (c SetupController) baseUrl() string {
+	scheme := "http"
+	if c.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+}
+
+func (c SetupController) configChecks() []*SetupCheck {
+	var checks []*SetupCheck
+
+	callbackUrl := c.baseUrl() + routes.AlphaWingController.GetCallback()
+	callbackCheck := &SetupCheck{
+		Name:    "ベースURL",
+		Ok:      Conf.WebApplicationCallbackUrl == callbackUrl,
+		Message: fmt.Sprintf("google.webapplication.callbackurl は %s です。", Conf.WebApplicationCallbackUrl),
+	}
+	if !callbackCheck.Ok {
+		callbackCheck.Message += fmt.Sprintf(" このURLで運用する場合は %s に変更し、Google Developers Console の承認済みのリダイレクトURIにも登録してください。", callbackUrl)
+	}
+	checks = append(checks, callbackCheck)
+
+	checks = append(checks, &SetupCheck{
+		Name:    "ログインできるドメイン",
+		Ok:      len(Conf.PermittedDomains) > 0 && Conf.PermittedDomains[0] != "",
+		Message: fmt.Sprintf("app.permitteddomain は %v です。最初のユーザーはこのドメインのGoogleアカウントでログインしてください。", Conf.PermittedDomains),
+	})
+
+	storageCheck := &SetupCheck{
+		Name:    "ストレージ",
+		Ok:      Conf.ServiceAccountClientEmail != "",
+		Message: fmt.Sprintf("サービスアカウント %s のGoogle Driveにファイルを保存します。", Conf.ServiceAccountClientEmail),
+	}
+	if Conf.FakeDrive != nil {
+		storageCheck.Message = "storage.fake が有効なため、ファイルはメモリに保存され、再起動で失われます。"
+	}
+	checks = append(checks, storageCheck)
+
+	for name, folderId := range Conf.StorageLocations {
+		checks = append(checks, &SetupCheck{
+			Name:    "保存場所 " + name,
+			Ok:      true,
+			Message: fmt.Sprintf("フォルダ %s にサービスアカウントの編集権限が必要です。", folderId),
+		})
+	}
+
+	adminCheck := &SetupCheck{
+		Name:    "管理API",
+		Ok:      true,
+		Message: "api.admintoken が設定されています。",
+	}
+	if Conf.AdminApiToken == "" {
+		adminCheck.Message = "api.admintoken が未設定のため、管理APIは無効です。使う場合は下の設定例のトークンを設定してください。"
+	}
+	checks = append(checks, adminCheck)
+
+	mailCheck := &SetupCheck{
+		Name:    "メール通知",
+		Ok:      true,
+		Message: "mail.smtp.host が設定されています。",
+	}
+	if Conf.Mailer == nil {
+		mailCheck.Message = "mail.smtp.host が未設定のため、アクセス申請などはメールで通知されません。"
+	}
+	checks = append(checks, mailCheck)
+
+	return checks
+}
+
+func connectivityChecks() []*SetupCheck {
+	var checks []*SetupCheck
+
+	dbCheck := &SetupCheck{Name: "データベースへの接続", Ok: true, Message: "接続できました。"}
+	if err := Dbm.Db.Ping(); err != nil {
+		dbCheck.Ok = false
+		dbCheck.Message = "db.spec を確認してください: " + err.Error()
+	}
+	checks = append(checks, dbCheck)
+
+	s, err := NewServiceAccountGoogleService()
+	if err != nil {
+		return append(checks, &SetupCheck{
+			Name:    "サービスアカウントの認証",
+			Message: "google.serviceaccount.keypath の鍵と、Drive APIが有効か確認してください: " + err.Error(),
+		})
+	}
+
+	capacityCheck := &SetupCheck{Name: "Google Driveへの接続", Ok: true}
+	capacityInfo, err := s.GetCapacityInfo()
+	if err != nil {
+		capacityCheck.Ok = false
+		capacityCheck.Message = "Drive APIが有効か確認してください: " + err.Error()
+	} else {
+		capacityCheck.Message = fmt.Sprintf("%sGB / %sGB 使用中です。", capacityInfo.Used, capacityInfo.Total)
+	}
+	checks = append(checks, capacityCheck)
+
+	checks = append(checks, folderCheck(s, "Google Driveへの書き込み", ""))
+	for name, folderId := range Conf.StorageLocations {
+		checks = append(checks, folderCheck(s, "保存場所 "+name+" への書き込み", folderId))
+	}
+
+	return checks
+}
+
+// folderCheck makes and deletes a folder to see the service account can store the files.
+func folderCheck(s *models.GoogleService, name, parentId string) *SetupCheck {
+	check := &SetupCheck{Name: name}
+
+	var parent *drive.ParentReference
+	if parentId != "" {
+		parent = &drive.ParentReference{Id: parentId}
+	}
+	folder, err := s.CreateFolderIn("alphawing-setup-check", parent)
+	if err != nil {
+		check.Message = "フォルダを作成できません: " + err.Error()
+		return check
+	}
+	if err := s.PurgeFile(folder.Id); err != nil {
+		check.Message = "テスト用のフォルダ alphawing-setup-check を削除できません: " + err.Error()
+		return check
+	}
+
+	check.Ok = true
+	check.Message = "フォルダを作成・削除できました。"
+	return check
+}
+
+// configSnippet suggests the settings to add to conf/app.conf for this URL.
+func (c SetupController) configSnippet() string {
+	snippet := fmt.Sprintf("google.webapplication.callbackurl = %s%s\n", c.baseUrl(), routes.AlphaWingController.GetCallback())
+	if Conf.AdminApiToken == "" {
+		snippet += fmt.Sprintf("api.admintoken = %s\n", models.NewToken())
+	}
+	if u, err := url.Parse(c.baseUrl()); err == nil && u.Scheme != "https" {
+		snippet += "# 本番環境ではHTTPSで配信し、プロキシから X-Forwarded-Proto: https を渡してください\n"
+	}
+	return snippet
+}
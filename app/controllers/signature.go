@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"github.com/kayac/alphawing/app/models"
+)
+
+const SignatureHeader = "X-Alphawing-Signature"
+
+// signBundle returns the detached signature of the bundle file, reading the file back from Google Drive
+// only when it cannot be signed from the stored digest.
+func signBundle(storage models.Storage, bundle *models.Bundle) (string, error) {
+	signer := Conf.BundleSigner
+	if bundle.Digest != "" && signer.CanSignDigest() {
+		return signer.SignDigest(bundle.Digest)
+	}
+
+	object, err := storage.Get(bundle.FileId)
+	if err != nil {
+		return "", err
+	}
+	defer object.Body.Close()
+
+	return signer.Sign(object.Body)
+}
+
+// setSignatureHeader sends the signature with the download when it is cheap to make.
+// The universal APK of an aab is not the file signed.
+func (c *AlphaWingController) setSignatureHeader(bundle *models.Bundle) error {
+	if Conf.BundleSigner == nil || bundle.Digest == "" || bundle.IsAab() || !Conf.BundleSigner.CanSignDigest() {
+		return nil
+	}
+	signature, err := Conf.BundleSigner.SignDigest(bundle.Digest)
+	if err != nil {
+		return err
+	}
+	c.Response.Out.Header().Set(SignatureHeader, signature)
+	return nil
+}
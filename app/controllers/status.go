@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// StatusController shows the status of the service without a login, for the testers to see whether it is down.
+// It does not embed AlphaWingController, so that it is served even when the storage is down.
+type StatusController struct {
+	GorpController
+}
+
+func (c StatusController) GetStatus() revel.Result {
+	now := time.Now()
+
+	// the database may be down, which is the status to show
+	statuses, err := models.GetComponentStatuses(Dbm, now)
+	if err != nil {
+		revel.ERROR.Printf("failed to get the status: %s", err)
+		c.RenderArgs["databaseError"] = true
+		return c.RenderTemplate("StatusController/GetStatus.html")
+	}
+	incidents, err := models.GetStatusIncidents(Dbm, now.AddDate(0, 0, -7))
+	if err != nil {
+		panic(err)
+	}
+	c.RenderArgs["statuses"] = statuses
+	c.RenderArgs["incidents"] = incidents
+	return c.RenderTemplate("StatusController/GetStatus.html")
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// the signed URLs are given to the clients only to start the download at once
+const signedURLExpiry = 5 * time.Minute
+
+// newStorage returns the storage of the bundle files, the bucket of storage.backend = s3 or gcs, the local disk of local,
+// the server of webdav or Google Drive.
+func newStorage(s *models.GoogleService) models.Storage {
+	storage, err := storageOfBackend(Conf.StorageBackend, s)
+	if err != nil {
+		panic(err)
+	}
+	return storage
+}
+
+// storageOfBackend returns the storage of the name of storage.backend, or an error if it is not configured.
+func storageOfBackend(backend string, s *models.GoogleService) (models.Storage, error) {
+	switch backend {
+	case "drive":
+		return models.NewDriveStorage(s), nil
+	case "s3":
+		if Conf.S3Storage == nil {
+			return nil, fmt.Errorf("storage.s3.bucket is not configured")
+		}
+		return Conf.S3Storage, nil
+	case "local":
+		if Conf.LocalStorage == nil {
+			return nil, fmt.Errorf("storage.local.root is not configured")
+		}
+		return Conf.LocalStorage, nil
+	case "gcs":
+		if Conf.GcsStorage == nil {
+			return nil, fmt.Errorf("storage.gcs.bucket is not configured")
+		}
+		return Conf.GcsStorage, nil
+	case "webdav":
+		if Conf.WebDavStorage == nil {
+			return nil, fmt.Errorf("storage.webdav.url is not configured")
+		}
+		return Conf.WebDavStorage, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend: %s", backend)
+}
+
+// redirectToSignedURL lets the client download the file to install the bundle from the CDN, or from the storage directly
+// if the storage signs the URLs, unless the server meters the downloads.
+func (c *AlphaWingController) redirectToSignedURL(bundle *models.Bundle) revel.Result {
+	return c.redirectFileToSignedURL(bundle.InstallFileId())
+}
+
+func (c *AlphaWingController) redirectFileToSignedURL(fileId string) revel.Result {
+	if Conf.BandwidthDailyLimit > 0 || Conf.BandwidthRate > 0 {
+		return nil
+	}
+	var signedURL string
+	var err error
+	if Conf.Cdn != nil {
+		signedURL, err = Conf.Cdn.SignedURL(fileId, signedURLExpiry)
+	} else {
+		signedURL, err = c.Storage.SignedURL(fileId, signedURLExpiry)
+	}
+	if err != nil {
+		panic(err)
+	}
+	if signedURL == "" {
+		return nil
+	}
+	return c.Redirect(signedURL)
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// the last migration of the process, to report the progress of
+var storageMigration = struct {
+	sync.Mutex
+	migration *models.StorageMigration
+}{}
+
+type JsonResponseStorageMigration struct {
+	*JsonResponse
+	Content *models.StorageMigrationJsonResponse `json:"content"`
+}
+
+// ApplyStorageMigration points the bundles to the copies in storage.backend, once it is switched to the backend the
+// files are migrated to.
+func ApplyStorageMigration() {
+	applied, err := models.ApplyStorageMigration(Dbm, Conf.StorageBackend)
+	if err != nil {
+		revel.ERROR.Printf("failed to apply the storage migration to %s: %s", Conf.StorageBackend, err)
+		return
+	}
+	if applied > 0 {
+		revel.INFO.Printf("applied %d files migrated to %s", applied, Conf.StorageBackend)
+	}
+}
+
+// PostMigrateStorage starts copying the files of the bundles from the storage backend to another in the background.
+// Only one migration runs at a time.
+func (c AdminApiController) PostMigrateStorage(from, to string) revel.Result {
+	if from == "" || to == "" || from == to {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(&JsonResponseStorageMigration{c.NewJsonResponse(c.Response.Status, []string{"from and to must be different backends."}), nil})
+	}
+
+	// the service account of the request is traced with the request, which ends before the migration
+	s, err := NewServiceAccountGoogleService()
+	if err != nil {
+		panic(err)
+	}
+	source, err := storageOfBackend(from, s)
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(&JsonResponseStorageMigration{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+	}
+	destination, err := storageOfBackend(to, s)
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(&JsonResponseStorageMigration{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+	}
+
+	storageMigration.Lock()
+	defer storageMigration.Unlock()
+	if m := storageMigration.migration; m != nil && m.IsRunning() {
+		c.Response.Status = http.StatusConflict
+		return c.RenderJson(&JsonResponseStorageMigration{c.NewJsonResponse(c.Response.Status, []string{"Migration is already running."}), m.JsonResponse()})
+	}
+
+	m := models.NewStorageMigration(from, to)
+	storageMigration.migration = m
+	go func() {
+		if err := m.Run(Dbm, source, destination); err != nil {
+			revel.ERROR.Printf("failed to migrate storage from %s to %s: %s", from, to, err)
+		}
+		// the files uploaded before the switch are copied after it, and are applied at once
+		if to == Conf.StorageBackend {
+			ApplyStorageMigration()
+		}
+	}()
+
+	c.Response.Status = http.StatusAccepted
+	return c.RenderJson(&JsonResponseStorageMigration{c.NewJsonResponse(c.Response.Status, []string{"Migration is started!"}), m.JsonResponse()})
+}
+
+// GetStorageMigration reports the progress of the running migration, or the result of the last one.
+func (c AdminApiController) GetStorageMigration() revel.Result {
+	storageMigration.Lock()
+	m := storageMigration.migration
+	storageMigration.Unlock()
+
+	if m == nil {
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJson(&JsonResponseStorageMigration{c.NewJsonResponse(c.Response.Status, []string{"No migration has run."}), nil})
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseStorageMigration{c.NewJsonResponse(c.Response.Status, []string{"Storage Migration"}), m.JsonResponse()})
+}
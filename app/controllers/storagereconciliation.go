@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// the last reconciliation of the process, to report the progress of
+var storageReconciliation = struct {
+	sync.Mutex
+	reconciliation *models.StorageReconciliation
+}{}
+
+type JsonResponseStorageReconciliation struct {
+	*JsonResponse
+	Content *models.StorageReconciliationJsonResponse `json:"content"`
+}
+
+// PostReconcileStorage starts cross-checking the bundles against the files of the backend, storage.backend by default,
+// in the background. The orphaned files and the bundles of the missing files are deleted only with clean.
+func (c AdminApiController) PostReconcileStorage(backend string, clean bool) revel.Result {
+	if backend == "" {
+		backend = Conf.StorageBackend
+	}
+
+	// the service account of the request is traced with the request, which ends before the reconciliation
+	s, err := NewServiceAccountGoogleService()
+	if err != nil {
+		panic(err)
+	}
+	storage, err := storageOfBackend(backend, s)
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(&JsonResponseStorageReconciliation{c.NewJsonResponse(c.Response.Status, []string{err.Error()}), nil})
+	}
+
+	storageReconciliation.Lock()
+	defer storageReconciliation.Unlock()
+	if r := storageReconciliation.reconciliation; r != nil && r.IsRunning() {
+		c.Response.Status = http.StatusConflict
+		return c.RenderJson(&JsonResponseStorageReconciliation{c.NewJsonResponse(c.Response.Status, []string{"Reconciliation is already running."}), r.JsonResponse()})
+	}
+
+	r := models.NewStorageReconciliation(backend, clean)
+	storageReconciliation.reconciliation = r
+	go func() {
+		if err := r.Run(Dbm, storage); err != nil {
+			revel.ERROR.Printf("failed to reconcile storage %s: %s", backend, err)
+		}
+	}()
+
+	c.Response.Status = http.StatusAccepted
+	return c.RenderJson(&JsonResponseStorageReconciliation{c.NewJsonResponse(c.Response.Status, []string{"Reconciliation is started!"}), r.JsonResponse()})
+}
+
+// GetStorageReconciliation reports the progress of the running reconciliation, or the result of the last one.
+func (c AdminApiController) GetStorageReconciliation() revel.Result {
+	storageReconciliation.Lock()
+	r := storageReconciliation.reconciliation
+	storageReconciliation.Unlock()
+
+	if r == nil {
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJson(&JsonResponseStorageReconciliation{c.NewJsonResponse(c.Response.Status, []string{"No reconciliation has run."}), nil})
+	}
+
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(&JsonResponseStorageReconciliation{c.NewJsonResponse(c.Response.Status, []string{"Storage Reconciliation"}), r.JsonResponse()})
+}
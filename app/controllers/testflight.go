@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// submitToTestFlight uploads the bundle to App Store Connect and records the result to the submission.
+func submitToTestFlight(storage models.Storage, key *models.AppStoreConnectKey, bundle *models.Bundle, submission *models.TestFlightSubmission) {
+	stop := touchWhileUploading(submission.Touch)
+	buildUploadId, err := uploadToAppStoreConnect(storage, key, bundle)
+	stop()
+	if err != nil {
+		revel.ERROR.Printf("failed to submit bundle %d to TestFlight: %s", bundle.Id, err)
+		submission.State = models.TestFlightStateFailed
+		submission.Message = err.Error()
+	} else {
+		submission.State = models.TestFlightStateProcessing
+		submission.BuildUploadId = buildUploadId
+	}
+
+	err = Transact(func(txn gorp.SqlExecutor) error {
+		return submission.Update(txn)
+	})
+	if err != nil {
+		revel.ERROR.Printf("failed to update TestFlight submission %d: %s", submission.Id, err)
+	}
+}
+
+func uploadToAppStoreConnect(storage models.Storage, key *models.AppStoreConnectKey, bundle *models.Bundle) (string, error) {
+	if bundle.IsRecalled() {
+		return "", models.ErrBundleRecalled
+	}
+	if bundle.IsArchived() {
+		return "", models.ErrBundleArchived
+	}
+	object, err := storage.Get(bundle.FileId)
+	if err != nil {
+		return "", err
+	}
+	defer object.Body.Close()
+
+	// the parts are uploaded by offset, so keep the ipa file on local disk
+	tmpFile, err := ioutil.TempFile("", "alphawing")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	if _, err := io.Copy(tmpFile, object.Body); err != nil {
+		return "", err
+	}
+
+	bundleInfo, err := models.NewBundleInfo(tmpFile, models.BundlePlatformTypeIOS)
+	if err != nil {
+		return "", err
+	}
+
+	asc := models.NewAppStoreConnect(key)
+	return asc.UploadBuild(tmpFile, object.Name, bundleInfo)
+}
+
+// getAppStoreConnectKey returns the key of the app with its private key opened.
+func getAppStoreConnectKey(appId int) (*models.AppStoreConnectKey, error) {
+	key, err := models.GetAppStoreConnectKey(Dbm, appId)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.Open(Conf.EnvFileKey); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// resumeTestFlightSubmissions uploads again the submissions left uploading by a server stopped.
+func resumeTestFlightSubmissions() error {
+	submissions, err := models.ClaimStaleTestFlightSubmissions(Dbm, time.Now())
+	if err != nil || len(submissions) == 0 {
+		return err
+	}
+	storage, err := backgroundStorage()
+	if err != nil {
+		return err
+	}
+
+	for _, submission := range submissions {
+		bundle, err := models.GetBundle(Dbm, submission.BundleId)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		// the bundle is deleted with its submissions, unless it is deleted while this one is read
+		if err == sql.ErrNoRows {
+			continue
+		}
+		key, err := getAppStoreConnectKey(bundle.AppId)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				return err
+			}
+			submission.State = models.TestFlightStateFailed
+			submission.Message = "App Store Connect API key is not registered."
+			if err := Transact(submission.Update); err != nil {
+				return err
+			}
+			continue
+		}
+		revel.INFO.Printf("resuming TestFlight submission %d of bundle %d", submission.Id, bundle.Id)
+		go submitToTestFlight(storage, key, bundle, submission)
+	}
+	return nil
+}
+
+// backgroundStorage returns the storage of the bundle files for the jobs, which have no request.
+func backgroundStorage() (models.Storage, error) {
+	s, err := NewServiceAccountGoogleService()
+	if err != nil {
+		return nil, err
+	}
+	return storageOfBackend(Conf.StorageBackend, s)
+}
+
+// touchWhileUploading touches the row of the upload every minute until it is stopped, so that the other servers do
+// not take it for the one left by a server stopped.
+func touchWhileUploading(touch func(gorp.SqlExecutor) error) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := Transact(touch); err != nil {
+					revel.ERROR.Printf("failed to touch the upload: %s", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/revel/revel"
+)
+
+// ThemeController serves the static files of branding.overridesdir before the built-in ones.
+// It does not embed AlphaWingController, as the login and the error pages use them without a login.
+type ThemeController struct {
+	*revel.Controller
+}
+
+func (c ThemeController) GetStatic(filepath string) revel.Result {
+	dirs := []string{path.Join(revel.BasePath, "static")}
+	if Conf.OverridesDir != "" {
+		dirs = append([]string{path.Join(Conf.OverridesDir, "static")}, dirs...)
+	}
+	for _, dir := range dirs {
+		file := openStaticFile(dir, filepath)
+		if file != nil {
+			return c.RenderFile(file, revel.Inline)
+		}
+	}
+	return c.NotFound("File is not found.")
+}
+
+// openStaticFile opens the file of the path in the directory, or returns nil for a missing file or a directory.
+// The path is cleaned first not to serve the files out of the directory.
+func openStaticFile(dir, name string) *os.File {
+	file, err := os.Open(filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))))
+	if err != nil {
+		return nil
+	}
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		file.Close()
+		return nil
+	}
+	return file
+}
+
+// InitOverrides loads the templates of branding.overridesdir before the built-in ones,
+// as the template loader keeps the first template of each name.
+func InitOverrides() {
+	if Conf.OverridesDir == "" {
+		return
+	}
+	viewsDir := filepath.Join(Conf.OverridesDir, "views")
+	if stat, err := os.Stat(viewsDir); err != nil || !stat.IsDir() {
+		return
+	}
+
+	revel.TemplatePaths = append([]string{viewsDir}, revel.TemplatePaths...)
+	loader := revel.NewTemplateLoader(revel.TemplatePaths)
+	if err := loader.Refresh(); err != nil {
+		panic(err)
+	}
+	revel.MainTemplateLoader = loader
+	revel.INFO.Printf("templates are overridden by %s", viewsDir)
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+const tokenUsesArg = "tokenUses"
+
+// a tokenUse is a token the request is made with, e.g. one for each of the projects of a manifest
+type tokenUse struct {
+	kind    string
+	id      int
+	digest  string
+	revoked bool
+}
+
+// useToken marks the request as made with the token, to be counted after the response by RecordTokenUsage.
+func (c *AlphaWingController) useToken(kind string, id int, token string) {
+	c.addTokenUse(&tokenUse{kind: kind, id: id, digest: models.TokenDigest(token)})
+}
+
+// useRevokedToken marks the request as made with an API token which is not of any project, to be counted
+// for the project it was of if it has been used before the refresh.
+func (c *AlphaWingController) useRevokedToken(token string) {
+	if token == "" {
+		return
+	}
+	c.addTokenUse(&tokenUse{kind: models.TokenKindApi, digest: models.TokenDigest(token), revoked: true})
+}
+
+func (c *AlphaWingController) addTokenUse(use *tokenUse) {
+	uses, _ := c.Args[tokenUsesArg].([]*tokenUse)
+	c.Args[tokenUsesArg] = append(uses, use)
+}
+
+// RecordTokenUsage counts the requests with the tokens, and records the errors answered with their statuses.
+func (c *AlphaWingController) RecordTokenUsage() revel.Result {
+	uses, ok := c.Args[tokenUsesArg].([]*tokenUse)
+	if !ok {
+		return nil
+	}
+
+	status := c.Response.Status
+	if c.Result == nil {
+		status = http.StatusInternalServerError
+	} else if status == 0 {
+		status = http.StatusOK
+	}
+
+	for _, use := range uses {
+		err := Transact(func(txn gorp.SqlExecutor) error {
+			if use.revoked {
+				_, err := models.RecordRevokedTokenUsage(txn, use.digest, c.Request.Method, c.Request.URL.Path, status)
+				return err
+			}
+			return models.RecordTokenUsage(txn, use.kind, use.id, use.digest, c.Request.Method, c.Request.URL.Path, status)
+		})
+		if err != nil {
+			revel.ERROR.Printf("failed to record usage of %s token %d: %s", use.kind, use.id, err)
+		}
+	}
+	return nil
+}
+
+// tokenActivities tells whether the admin and the mirror tokens counted are the ones configured now.
+func tokenActivities(activities []*models.TokenActivity) []*models.TokenActivity {
+	for _, activity := range activities {
+		switch activity.Usage.TokenKind {
+		case models.TokenKindAdmin:
+			activity.Current = Conf.AdminApiToken != "" && activity.Usage.TokenDigest == models.TokenDigest(Conf.AdminApiToken)
+		case models.TokenKindMirror:
+			activity.Current = Conf.MirrorToken != "" && activity.Usage.TokenDigest == models.TokenDigest(Conf.MirrorToken)
+		}
+	}
+	return activities
+}
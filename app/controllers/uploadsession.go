@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+type JsonResponseUploadSession struct {
+	*JsonResponse
+	Content *models.UploadSessionJsonResponse `json:"content"`
+}
+
+func (c *AlphaWingController) NewJsonResponseUploadSession(stat int, mes []string, content *models.UploadSessionJsonResponse) *JsonResponseUploadSession {
+	return &JsonResponseUploadSession{
+		JsonResponse: &JsonResponse{Status: stat, Message: mes},
+		Content:      content,
+	}
+}
+
+// PostCreateUploadSession starts the chunked upload of the file of the size, validated before the chunks are sent.
+func (c ApiController) PostCreateUploadSession(token, filename string, size int64) revel.Result {
+	app, err := c.appByApiToken(token)
+	if err != nil {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{"Token is invalid."}, nil))
+	}
+	return c.createUploadSession(app, filename, size)
+}
+
+// GetUploadSession tells the offset to resume the upload from.
+func (c ApiController) GetUploadSession(token string, uploadId int) revel.Result {
+	_, session, result := c.uploadSession(token, uploadId)
+	if result != nil {
+		return result
+	}
+	return c.renderUploadSession(session)
+}
+
+// PutAppendUploadSession appends the request body at the offset of the upload.
+func (c ApiController) PutAppendUploadSession(token string, uploadId int, offset int64) revel.Result {
+	app, session, result := c.uploadSession(token, uploadId)
+	if result != nil {
+		return result
+	}
+	return c.appendUploadSession(app, session, offset)
+}
+
+// PostCommitUploadSession creates the bundle of the assembled file, with the parameters of Upload Bundle.
+// The session is kept when the bundle is not created, so that the commit can be retried without the chunks.
+func (c ApiController) PostCommitUploadSession(token string, uploadId int, description, known_issues, channel, tags, variant, branch, commit, ci_job_url, version, short_version, identifier string) revel.Result {
+	app, session, result := c.uploadSession(token, uploadId)
+	if result != nil {
+		return result
+	}
+	if result := c.checkIdempotencyKey(fmt.Sprintf("app:%d", app.Id)); result != nil {
+		return result
+	}
+	if !session.Completed() {
+		c.Response.Status = http.StatusConflict
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{models.ErrUploadSessionIncomplete.Error()}, session.JsonResponse()))
+	}
+	if err := models.ValidCiJobUrl(ci_job_url); err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{"ci_job_url is not valid."}, session.JsonResponse()))
+	}
+
+	file, err := session.Open(Conf.UploadSessionDir)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	ext := models.BundleFileExtensionOf(session.Filename)
+	bundle := &models.Bundle{
+		PlatformType: ext.PlatformType(),
+		Description:  description,
+		Channel:      channel,
+		Tags:         tags,
+		Variant:      variant,
+		Branch:       branch,
+		Commit:       commit,
+		Translations: c.changelogParams(),
+		File:         file,
+		UploadedBy:   models.ApiUploader(token),
+		CiJobUrl:     ci_job_url,
+
+		BundleVersion:    version,
+		ShortVersion:     short_version,
+		BundleIdentifier: identifier,
+	}
+
+	noteAppWrite(app.Id)
+	if err := app.CreateBundle(Dbm, c.Storage, bundle); err != nil {
+		return c.renderUploadError(err)
+	}
+	if err := session.Delete(Dbm, Conf.UploadSessionDir); err != nil {
+		revel.ERROR.Printf("failed to delete upload %d: %s", session.Id, err)
+	}
+	return c.renderUploadedBundle(app, bundle, known_issues, nil, nil)
+}
+
+// uploadSession returns the session of the app of the token, or the result of the error.
+func (c ApiController) uploadSession(token string, uploadId int) (*models.App, *models.UploadSession, revel.Result) {
+	app, err := c.appByApiToken(token)
+	if err != nil {
+		c.Response.Status = http.StatusUnauthorized
+		return nil, nil, c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{"Token is invalid."}, nil))
+	}
+	session, err := models.GetUploadSession(Dbm, app, uploadId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+		c.Response.Status = http.StatusNotFound
+		return nil, nil, c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{"Upload is not found."}, nil))
+	}
+	return app, session, nil
+}
+
+// createUploadSession validates the file before the chunks are sent, as the uploads of a file do.
+func (c *AlphaWingController) createUploadSession(app *models.App, filename string, size int64) revel.Result {
+	ext := models.BundleFileExtensionOf(filename)
+	c.Validation.Required(size > 0).Message("Size is required.")
+	c.Validation.Required(ext.IsValid()).Message("File extension is not valid.")
+	c.Validation.Required(withinUploadSize(size)).Message("File is too large.")
+	if c.Validation.HasErrors() {
+		var errors []string
+		for _, err := range c.Validation.Errors {
+			errors = append(errors, err.String())
+		}
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, errors, nil))
+	}
+
+	// the quota is checked again when the bundle is created
+	if err := app.CheckStorageQuota(Dbm, size, ""); err != nil {
+		diagnosis := c.diagnoseUpload(err)
+		c.Response.Status = diagnosis.Status
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{diagnosis.String()}, nil))
+	}
+
+	session := &models.UploadSession{
+		AppId:    app.Id,
+		Filename: filepath.Base(filename),
+		Size:     size,
+	}
+	err := Transact(func(txn gorp.SqlExecutor) error {
+		return txn.Insert(session)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.Response.Status = http.StatusCreated
+	return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{"Upload is started!"}, session.JsonResponse()))
+}
+
+// appendUploadSession writes the request body at the offset. The chunk of a wrong offset is refused with the current one,
+// e.g. when the response of the last chunk is lost. The last chunk is responded with the info of the file.
+func (c *AlphaWingController) appendUploadSession(app *models.App, session *models.UploadSession, offset int64) revel.Result {
+	length := c.Request.ContentLength
+	if length < 0 {
+		c.Response.Status = http.StatusLengthRequired
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{"Content-Length is required."}, session.JsonResponse()))
+	}
+
+	err := session.Append(Dbm, Conf.UploadSessionDir, c.Request.Body, offset, length)
+	switch err {
+	case nil:
+	case models.ErrUploadSessionOffset:
+		// the offset may have been moved by another request
+		if current, err := models.GetUploadSession(Dbm, app, session.Id); err == nil {
+			session = current
+		}
+		c.Response.Status = http.StatusConflict
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{models.ErrUploadSessionOffset.Error()}, session.JsonResponse()))
+	case models.ErrUploadSessionTooLarge:
+		c.Response.Status = http.StatusRequestEntityTooLarge
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{err.Error()}, session.JsonResponse()))
+	default:
+		// the chunk broken off is sent again from the same offset
+		revel.ERROR.Printf("failed to append upload %d: %s", session.Id, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{"Chunk is not written. Send it again."}, session.JsonResponse()))
+	}
+	return c.renderUploadSession(session)
+}
+
+func (c *AlphaWingController) renderUploadSession(session *models.UploadSession) revel.Result {
+	c.Response.Status = http.StatusOK
+	return c.RenderJson(c.NewJsonResponseUploadSession(c.Response.Status, []string{}, session.JsonResponseWithInfo(Conf.UploadSessionDir)))
+}
@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+// PutUploadBundle creates the bundle of the file sent as the request body, streaming it on to the storage
+// instead of writing it to a temporary file first, so that a large bundle is stored as it arrives.
+// The parameters are in the query, as the body is the file itself, or in the fields of the form before the file.
+func (c ApiController) PutUploadBundle(token, filename, description, known_issues, channel, tags, variant, branch, commit, ci_job_url, version, short_version, identifier string) revel.Result {
+	app, err := c.appByApiToken(token)
+	if err != nil {
+		c.Response.Status = http.StatusUnauthorized
+		return c.RenderJson(c.NewJsonResponseUploadBundle(c.Response.Status, []string{"Token is invalid."}, nil))
+	}
+	if result := c.checkIdempotencyKey(fmt.Sprintf("app:%d", app.Id)); result != nil {
+		return result
+	}
+
+	// revel reads the body of a urlencoded form as the params, while a multipart one is streamed by MultipartStreamFilter
+	if c.Request.ContentType == "application/x-www-form-urlencoded" || c.Request.ContentType == "multipart/form-data" {
+		c.Response.Status = http.StatusUnsupportedMediaType
+		return c.RenderJson(c.NewJsonResponseUploadBundle(c.Response.Status, []string{"Send the file as application/octet-stream or in a multipart form."}, nil))
+	}
+	// the storage needs the size before the content, so a chunked body is refused
+	size := c.Request.ContentLength
+	if size < 0 {
+		c.Response.Status = http.StatusLengthRequired
+		return c.RenderJson(c.NewJsonResponseUploadBundle(c.Response.Status, []string{errContentLengthRequired.Error()}, nil))
+	}
+
+	ext := models.BundleFileExtensionOf(filename)
+	c.Validation.Required(size > 0).Message("File is required.")
+	c.Validation.Required(ext.IsValid()).Message("File extension is not valid.")
+	c.Validation.Required(withinUploadSize(size)).Message("File is too large.")
+	c.Validation.Required(models.ValidCiJobUrl(ci_job_url) == nil).Message("ci_job_url is not valid.")
+	if c.Validation.HasErrors() {
+		var errors []string
+		for _, err := range c.Validation.Errors {
+			errors = append(errors, err.String())
+		}
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJson(c.NewJsonResponseUploadBundle(c.Response.Status, errors, nil))
+	}
+
+	bundle := &models.Bundle{
+		PlatformType: ext.PlatformType(),
+		Description:  description,
+		Channel:      channel,
+		Tags:         tags,
+		Variant:      variant,
+		Branch:       branch,
+		Commit:       commit,
+		Translations: c.changelogParams(),
+		UploadedBy:   models.ApiUploader(token),
+		CiJobUrl:     ci_job_url,
+
+		BundleVersion:    version,
+		ShortVersion:     short_version,
+		BundleIdentifier: identifier,
+	}
+	stream := models.NewBundleStream(c.Request.Body, size, bundle.PlatformType)
+
+	noteAppWrite(app.Id)
+	storage, ok := c.Storage.(models.StreamStorage)
+	if ok {
+		if _, err := stream.ReadInfo(); err != nil {
+			revel.INFO.Printf("streaming the upload to app %d falls back to a file: %s", app.Id, err)
+			ok = false
+		}
+	}
+	if !ok {
+		// the upload is stored through a file as the multipart one is, when the version is not in the head of the stream
+		file, err := spoolUpload(stream.Reader())
+		if err != nil {
+			panic(err)
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		bundle.File = file
+		if err := app.CreateBundle(Dbm, c.Storage, bundle); err != nil {
+			return c.renderUploadError(err)
+		}
+		return c.renderUploadedBundle(app, bundle, known_issues, nil, nil)
+	}
+
+	if err := app.CreateStreamedBundle(Dbm, storage, bundle, stream); err != nil {
+		return c.renderUploadError(err)
+	}
+	return c.renderUploadedBundle(app, bundle, known_issues, nil, nil)
+}
+
+// spoolUpload copies the upload to a temporary file, to be removed by the caller.
+func spoolUpload(r io.Reader) (*os.File, error) {
+	tmp, err := ioutil.TempFile("", "alphawing-upload")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, os.SEEK_SET); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// the fields of a streamed form before the file, which are kept in memory
+const multipartStreamMaxFieldsSize = 1024 * 1024
+
+var (
+	errMultipartStream       = errors.New("The form must end with the file.")
+	errContentLengthRequired = errors.New("Content-Length is required.")
+)
+
+// MultipartStreamFilter lets PUT /api/upload_bundle take the file in a form, as `curl -F` sends it, streaming the file
+// instead of the form being written to temporary files by revel. The fields before the file are read as the query,
+// so the file is the last part of the form.
+var MultipartStreamFilter = func(c *revel.Controller, fc []revel.Filter) {
+	if c.Action == "ApiController.PutUploadBundle" && c.Request.ContentType == "multipart/form-data" {
+		if err := streamMultipartFile(c.Request); err != nil {
+			c.Response.Status = http.StatusBadRequest
+			if err == errContentLengthRequired {
+				c.Response.Status = http.StatusLengthRequired
+			}
+			c.Result = c.RenderJson(&JsonResponse{Status: c.Response.Status, Message: []string{err.Error()}})
+			return
+		}
+	}
+
+	fc[0](c, fc[1:])
+}
+
+// streamMultipartFile reads the fields of the form into the query, and replaces the body with the file after them,
+// whose size is told by Content-Length as the file is the last part.
+func streamMultipartFile(req *revel.Request) error {
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return errors.New("The boundary of the form is not found.")
+	}
+	if req.ContentLength < 0 {
+		return errContentLengthRequired
+	}
+
+	form := &multipartStream{r: bufio.NewReader(req.Body), delimiter: "--" + params["boundary"]}
+	query := req.URL.Query()
+	if err := form.skipPreamble(); err != nil {
+		return err
+	}
+	for {
+		header, err := form.readHeader()
+		if err != nil {
+			return err
+		}
+		_, disposition, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+		if err != nil {
+			return errMultipartStream
+		}
+		if disposition["name"] != "file" {
+			value, err := form.readValue()
+			if err != nil {
+				return err
+			}
+			query.Add(disposition["name"], value)
+			continue
+		}
+
+		closing := "\r\n" + form.delimiter + "--\r\n"
+		size := req.ContentLength - form.n - int64(len(closing))
+		if size < 0 {
+			return errMultipartStream
+		}
+		if query.Get("filename") == "" {
+			query.Set("filename", disposition["filename"])
+		}
+		req.URL.RawQuery = query.Encode()
+		req.Body = &multipartFileBody{Reader: io.LimitReader(form.r, size), closing: closing, form: form.r, Closer: req.Body}
+		req.ContentLength = size
+		req.ContentType = "application/octet-stream"
+		return nil
+	}
+}
+
+// a multipartStream reads the parts of a form before the file, counting the bytes read.
+type multipartStream struct {
+	r         *bufio.Reader
+	delimiter string
+	n         int64
+}
+
+func (form *multipartStream) readLine() (string, error) {
+	if form.n > multipartStreamMaxFieldsSize {
+		return "", errors.New("The fields of the form are too large.")
+	}
+	line, err := form.r.ReadString('\n')
+	form.n += int64(len(line))
+	if err != nil {
+		return "", errMultipartStream
+	}
+	return line, nil
+}
+
+func (form *multipartStream) skipPreamble() error {
+	for {
+		line, err := form.readLine()
+		if err != nil {
+			return err
+		}
+		if line == form.delimiter+"\r\n" {
+			return nil
+		}
+	}
+}
+
+func (form *multipartStream) readHeader() (textproto.MIMEHeader, error) {
+	var header bytes.Buffer
+	for {
+		line, err := form.readLine()
+		if err != nil {
+			return nil, err
+		}
+		header.WriteString(line)
+		if line == "\r\n" {
+			return textproto.NewReader(bufio.NewReader(&header)).ReadMIMEHeader()
+		}
+	}
+}
+
+// readValue reads the value of the field until the delimiter of the next part. The form ending before the file is refused.
+func (form *multipartStream) readValue() (string, error) {
+	var value bytes.Buffer
+	for {
+		line, err := form.readLine()
+		if err != nil {
+			return "", err
+		}
+		switch line {
+		case form.delimiter + "\r\n":
+			return strings.TrimSuffix(value.String(), "\r\n"), nil
+		case form.delimiter + "--\r\n", form.delimiter + "--":
+			return "", errMultipartStream
+		}
+		value.WriteString(line)
+	}
+}
+
+// a multipartFileBody reads the file of the form, and fails at its end unless the form is closed right after it,
+// so that a part after the file is not stored in it.
+type multipartFileBody struct {
+	io.Reader
+	io.Closer
+	closing string
+	form    *bufio.Reader
+}
+
+func (body *multipartFileBody) Read(p []byte) (int, error) {
+	n, err := body.Reader.Read(p)
+	if err == io.EOF {
+		closing := make([]byte, len(body.closing))
+		if _, readErr := io.ReadFull(body.form, closing); readErr != nil || string(closing) != body.closing {
+			return n, errMultipartStream
+		}
+	}
+	return n, err
+}
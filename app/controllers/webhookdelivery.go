@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"database/sql"
+
+	"github.com/kayac/alphawing/app/models"
+	"github.com/kayac/alphawing/app/routes"
+
+	"github.com/revel/revel"
+)
+
+// the deliveries retried by a run of the job, the rest being retried by the next run
+const webhookRetryBatchSize = 100
+
+// deliverWebhook records the event posted to the webhook, whose failures are retried by WebhookRetryJob.
+func deliverWebhook(appId int, kind, event, webhookUrl string, payload interface{}) error {
+	delivery, err := models.NewWebhookDelivery(appId, kind, event, webhookUrl, payload)
+	if err != nil {
+		return err
+	}
+	return delivery.Deliver(Dbm, Conf.WebhookSecret)
+}
+
+// a webhookDeliveryItem is a delivery with its attempts and its app, which may have been deleted since.
+type webhookDeliveryItem struct {
+	Delivery *models.WebhookDelivery
+	Attempts []*models.WebhookAttempt
+	App      *models.App
+}
+
+// GetWebhookDeliveries shows the latest deliveries to the webhooks and Slack with their attempts, for the admins
+// to find the failures of the integrations and redeliver them.
+func (c SettingsController) GetWebhookDeliveries(state string) revel.Result {
+	deliveries, err := models.GetWebhookDeliveries(Dbm, state, Conf.PagerDefaultLimit)
+	if err != nil {
+		panic(err)
+	}
+
+	var items []*webhookDeliveryItem
+	for _, delivery := range deliveries {
+		attempts, err := delivery.AttemptList(Dbm)
+		if err != nil {
+			panic(err)
+		}
+		item := &webhookDeliveryItem{Delivery: delivery, Attempts: attempts}
+		if delivery.AppId != 0 {
+			item.App, err = models.GetApp(Dbm, delivery.AppId)
+			if err != nil && err != sql.ErrNoRows {
+				panic(err)
+			}
+		}
+		items = append(items, item)
+	}
+
+	return c.Render(state, items)
+}
+
+// PostRedeliverWebhook posts the delivery again as it was posted, with the signature by the current secret.
+func (c SettingsController) PostRedeliverWebhook(deliveryId int) revel.Result {
+	delivery, err := models.GetWebhookDelivery(Dbm, deliveryId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NotFound("Delivery is not found.")
+		}
+		panic(err)
+	}
+
+	if err := delivery.Redeliver(Dbm, Conf.WebhookSecret); err != nil {
+		c.Flash.Error(err.Error())
+	} else {
+		c.Flash.Success("Delivery is redelivered!")
+	}
+	return c.Redirect(routes.SettingsController.GetWebhookDeliveries(""))
+}
+
+// ----------------------------------------------------------------------
+// WebhookRetryJob
+type WebhookRetryJob struct{}
+
+func (j WebhookRetryJob) Run() {
+	count, err := models.RetryWebhookDeliveries(Dbm, Conf.WebhookSecret, webhookRetryBatchSize)
+	if err != nil {
+		revel.ERROR.Printf("WebhookRetryJob: %s", err)
+	}
+	if count > 0 {
+		revel.INFO.Printf("WebhookRetryJob: delivered %d deliveries", count)
+	}
+}
@@ -1,22 +1,29 @@
 package app
 
-import "github.com/revel/revel"
+import (
+	"github.com/kayac/alphawing/app/controllers"
+
+	"github.com/revel/revel"
+)
 
 func init() {
 	// Filters is the default set of global filters.
 	revel.Filters = []revel.Filter{
-		revel.PanicFilter,             // Recover from panics and display an error page instead.
-		revel.RouterFilter,            // Use the routing table to select the right Action
-		revel.FilterConfiguringFilter, // A hook for adding or removing per-Action filters.
-		revel.ParamsFilter,            // Parse parameters into Controller.Params.
-		revel.SessionFilter,           // Restore and write the session cookie.
-		revel.FlashFilter,             // Restore and write the flash cookie.
-		revel.ValidationFilter,        // Restore kept validation errors and save new ones from cookie.
-		revel.I18nFilter,              // Resolve the requested language
-		HeaderFilter,                  // Add some security based headers
-		revel.InterceptorFilter,       // Run interceptors around the action.
-		revel.CompressFilter,          // Compress the result.
-		revel.ActionInvoker,           // Invoke the action.
+		revel.PanicFilter,                 // Recover from panics and display an error page instead.
+		controllers.ErrorReportFilter,     // Report panics and server errors to Sentry.
+		controllers.BrandingFilter,        // Brand the pages, including the error pages.
+		revel.RouterFilter,                // Use the routing table to select the right Action
+		revel.FilterConfiguringFilter,     // A hook for adding or removing per-Action filters.
+		controllers.MultipartStreamFilter, // Stream the file of the form of the streamed upload.
+		revel.ParamsFilter,                // Parse parameters into Controller.Params.
+		revel.SessionFilter,               // Restore and write the session cookie.
+		revel.FlashFilter,                 // Restore and write the flash cookie.
+		revel.ValidationFilter,            // Restore kept validation errors and save new ones from cookie.
+		revel.I18nFilter,                  // Resolve the requested language
+		HeaderFilter,                      // Add some security based headers
+		revel.InterceptorFilter,           // Run interceptors around the action.
+		revel.CompressFilter,              // Compress the result.
+		revel.ActionInvoker,               // Invoke the action.
 	}
 
 	// register startup functions with OnAppStart
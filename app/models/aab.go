@@ -0,0 +1,351 @@
+package models
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the manifest of the base module of an Android App Bundle, compiled by aapt2 to the protocol buffer of XmlNode
+// instead of the binary XML of an apk.
+// https://android.googlesource.com/platform/frameworks/base/+/master/tools/aapt2/Resources.proto
+const AabManifestName = "base/manifest/AndroidManifest.xml"
+
+const androidNamespace = "http://schemas.android.com/apk/res/android"
+
+var ErrUniversalApkNotBuilt = errors.New("The universal APK of the bundle is not built yet.")
+
+func (bundle *Bundle) IsAab() bool {
+	return bundle.AppBundle
+}
+
+// InstallFileId returns the file to install the bundle from, the universal APK for an Android App Bundle,
+// or "" if it is not built yet.
+func (bundle *Bundle) InstallFileId() string {
+	if bundle.IsAab() {
+		return bundle.UniversalApkFileId
+	}
+	return bundle.FileId
+}
+
+func (bundle *Bundle) UniversalApkFileName() string {
+	return fmt.Sprintf("app_%d_ver_%s_rev_%d_universal%s", bundle.AppId, bundle.BundleVersion, bundle.Revision, BundleFileExtensionAndroid)
+}
+
+func parseAabFile(xmlFile *zip.File) (*BundleInfo, error) {
+	rc, err := xmlFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	buf, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return aabBundleInfo(buf)
+}
+
+func aabBundleInfo(buf []byte) (*BundleInfo, error) {
+	manifest, err := parseAabManifest(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	bundleInfo := androidBundleInfo(manifest)
+	bundleInfo.AppBundle = true
+
+	return bundleInfo, nil
+}
+
+// parseAabManifest reads the manifest element and its children which the analysis needs.
+func parseAabManifest(buf []byte) (*androidManifest, error) {
+	element, err := parseAabNode(buf)
+	if err != nil {
+		return nil, err
+	}
+	if element == nil || element.Name != "manifest" {
+		return nil, errors.New("the manifest element is not found")
+	}
+
+	manifest := &androidManifest{
+		Package:     element.Attrs["package"],
+		VersionName: element.Attrs["android:versionName"],
+		VersionCode: element.Attrs["android:versionCode"],
+	}
+	for _, child := range element.Children {
+		switch child.Name {
+		case "uses-sdk":
+			manifest.UsesSdk.MinSdkVersion = child.Attrs["android:minSdkVersion"]
+			manifest.UsesSdk.TargetSdkVersion = child.Attrs["android:targetSdkVersion"]
+		case "uses-permission":
+			manifest.UsesPermissions = append(manifest.UsesPermissions, androidUsesPermission{Name: child.Attrs["android:name"]})
+		case "application":
+			manifest.Application.Icon = child.Attrs["android:icon"]
+		}
+	}
+	return manifest, nil
+}
+
+// an aabElement is an XmlElement with the attributes of the android namespace prefixed by "android:"
+type aabElement struct {
+	Name     string
+	Attrs    map[string]string
+	Children []*aabElement
+}
+
+// parseAabNode reads the element (1) of the XmlNode, or returns nil for a text node.
+func parseAabNode(buf []byte) (*aabElement, error) {
+	var element *aabElement
+	err := protoFields(buf, func(num int, data []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var err error
+		element, err = parseAabElement(data)
+		return err
+	})
+	return element, err
+}
+
+// parseAabElement reads the name (3), the attributes (4) and the children (5) of the XmlElement.
+func parseAabElement(buf []byte) (*aabElement, error) {
+	element := &aabElement{Attrs: map[string]string{}}
+	err := protoFields(buf, func(num int, data []byte) error {
+		switch num {
+		case 3:
+			element.Name = string(data)
+		case 4:
+			// XmlAttribute: namespace_uri (1), name (2) and value (3)
+			var namespace, name, value string
+			err := protoFields(data, func(num int, data []byte) error {
+				switch num {
+				case 1:
+					namespace = string(data)
+				case 2:
+					name = string(data)
+				case 3:
+					value = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if namespace == androidNamespace {
+				name = "android:" + name
+			}
+			element.Attrs[name] = value
+		case 5:
+			child, err := parseAabNode(data)
+			if err != nil {
+				return err
+			}
+			if child != nil {
+				element.Children = append(element.Children, child)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return element, nil
+}
+
+// protoFields calls f with the number and the bytes of each length-delimited field of the message, skipping the others.
+func protoFields(b []byte, f func(num int, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("invalid protocol buffer")
+		}
+		b = b[n:]
+
+		num, wireType := int(key>>3), key&7
+		switch wireType {
+		case 0: // varint
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return errors.New("invalid protocol buffer")
+			}
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return errors.New("invalid protocol buffer")
+			}
+			b = b[8:]
+		case 2: // length-delimited
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return errors.New("invalid protocol buffer")
+			}
+			data := b[n : n+int(size)]
+			b = b[n+int(size):]
+			if err := f(num, data); err != nil {
+				return err
+			}
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return errors.New("invalid protocol buffer")
+			}
+			b = b[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d of protocol buffer", wireType)
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------
+// Bundletool
+
+// Bundletool builds the universal APK of an Android App Bundle, which has every module and resource in one apk,
+// so that the testers can still install the bundle from the download page.
+// The APK is signed with the debug keystore of the server user unless KeyStore is given.
+// https://developer.android.com/tools/bundletool
+type Bundletool struct {
+	// the bundletool jar, which is run with java, or the command
+	Path         string
+	KeyStore     string
+	KeyStorePass string
+	KeyAlias     string
+	KeyPass      string
+}
+
+type BundletoolError struct {
+	Output string
+	Err    error
+}
+
+func (e *BundletoolError) Error() string {
+	return fmt.Sprintf("bundletool: %s: %s", e.Err, strings.TrimSpace(e.Output))
+}
+
+// BuildUniversalApk builds the universal APK of the aab file into a temporary file. The caller removes it.
+func (b *Bundletool) BuildUniversalApk(aab io.Reader) (*os.File, error) {
+	dir, err := ioutil.TempDir("", "alphawing-bundletool")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	aabPath := filepath.Join(dir, "bundle.aab")
+	aabFile, err := os.Create(aabPath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(aabFile, aab)
+	aabFile.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	apksPath := filepath.Join(dir, "universal.apks")
+	args := []string{"build-apks", "--mode=universal", "--bundle=" + aabPath, "--output=" + apksPath}
+	if b.KeyStore != "" {
+		args = append(args, "--ks="+b.KeyStore, "--ks-pass=pass:"+b.KeyStorePass, "--ks-key-alias="+b.KeyAlias)
+		if b.KeyPass != "" {
+			args = append(args, "--key-pass=pass:"+b.KeyPass)
+		}
+	}
+	if output, err := b.command(args...).CombinedOutput(); err != nil {
+		return nil, &BundletoolError{Output: string(output), Err: err}
+	}
+
+	return extractUniversalApk(apksPath)
+}
+
+func (b *Bundletool) command(args ...string) *exec.Cmd {
+	if strings.HasSuffix(b.Path, ".jar") {
+		return exec.Command("java", append([]string{"-jar", b.Path}, args...)...)
+	}
+	return exec.Command(b.Path, args...)
+}
+
+// extractUniversalApk copies universal.apk out of the APK set built by bundletool.
+func extractUniversalApk(apksPath string) (*os.File, error) {
+	reader, err := zip.OpenReader(apksPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	for _, f := range reader.File {
+		if f.Name != "universal.apk" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		file, err := ioutil.TempFile("", "alphawing-universal")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(file, rc); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return nil, err
+		}
+		if _, err := file.Seek(0, 0); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return nil, err
+		}
+		return file, nil
+	}
+	return nil, errors.New("universal.apk is not found in the APK set")
+}
+
+// AttachUniversalApk stores the universal APK in the version folder of the bundle and records it.
+func (app *App) AttachUniversalApk(dbm *gorp.DbMap, storage Storage, bundle *Bundle, file *os.File) error {
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := app.CheckStorageQuota(dbm, stat.Size(), ""); err != nil {
+		return err
+	}
+	location, err := app.versionLocation(dbm, storage, bundle.BundleVersion)
+	if err != nil {
+		return err
+	}
+	fileId, err := storage.Put(file, bundle.UniversalApkFileName(), location)
+	if err != nil {
+		return err
+	}
+
+	bundle.UniversalApkFileId = fileId
+	bundle.UniversalApkFileSize = stat.Size()
+	return Transact(dbm, func(txn gorp.SqlExecutor) error {
+		// the bundle may have been deleted while the APK was built
+		if _, err := GetBundle(txn, bundle.Id); err != nil {
+			if err == sql.ErrNoRows {
+				return storage.Delete(fileId)
+			}
+			return err
+		}
+		_, err := txn.Exec("UPDATE bundle SET universal_apk_file_id = ?, universal_apk_file_size = ? WHERE id = ?", fileId, bundle.UniversalApkFileSize, bundle.Id)
+		return err
+	})
+}
+
+// DeleteUniversalApk deletes the universal APK, which is not shared with the other bundles as the blobs are.
+func (bundle *Bundle) DeleteUniversalApk(storage Storage) error {
+	if bundle.UniversalApkFileId == "" {
+		return nil
+	}
+	return storage.Delete(bundle.UniversalApkFileId)
+}
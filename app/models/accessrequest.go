@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	AccessRequestStatePending  = "PENDING"
+	AccessRequestStateApproved = "APPROVED"
+	AccessRequestStateDenied   = "DENIED"
+)
+
+// an AccessRequest is a request from a user without authority to join an app
+type AccessRequest struct {
+	Id        int       `db:"id"`
+	AppId     int       `db:"app_id"`
+	UserId    int       `db:"user_id"`
+	Email     string    `db:"email"`
+	State     string    `db:"state"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+func (request *AccessRequest) PreInsert(s gorp.SqlExecutor) error {
+	request.CreatedAt = time.Now()
+	request.UpdatedAt = request.CreatedAt
+	return nil
+}
+
+func (request *AccessRequest) PreUpdate(s gorp.SqlExecutor) error {
+	request.UpdatedAt = time.Now()
+	return nil
+}
+
+func (request *AccessRequest) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(request)
+}
+
+func (request *AccessRequest) Update(txn gorp.SqlExecutor) error {
+	_, err := txn.Update(request)
+	return err
+}
+
+func (request *AccessRequest) IsPending() bool {
+	return request.State == AccessRequestStatePending
+}
+
+func GetAccessRequest(txn gorp.SqlExecutor, id int) (*AccessRequest, error) {
+	var request AccessRequest
+	if err := txn.SelectOne(&request, "SELECT * FROM access_request WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// GetPendingAccessRequest returns nil if the user has no pending request to the app.
+func GetPendingAccessRequest(txn gorp.SqlExecutor, appId, userId int) (*AccessRequest, error) {
+	var requests []*AccessRequest
+	_, err := txn.Select(&requests, "SELECT * FROM access_request WHERE app_id = ? AND user_id = ? AND state = ? LIMIT 1", appId, userId, AccessRequestStatePending)
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	return requests[0], nil
+}
@@ -0,0 +1,950 @@
+package models
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+const (
+	ApkSigningSchemeV1 = "v1"
+	ApkSigningSchemeV2 = "v2"
+	ApkSigningSchemeV3 = "v3"
+
+	apkSigBlockMagic     = "APK Sig Block 42"
+	apkSignatureV2Id     = 0x7109871a
+	apkSignatureV3Id     = 0xf05368c0
+	apkProofOfRotationId = 0x3ba06f8c
+
+	apkChunkSize = 1024 * 1024
+
+	// the signature algorithms of the v2 and v3 schemes
+	apkSigRsaPssSha256      = 0x0101
+	apkSigRsaPssSha512      = 0x0102
+	apkSigRsaPkcs1Sha256    = 0x0103
+	apkSigRsaPkcs1Sha512    = 0x0104
+	apkSigEcdsaSha256       = 0x0201
+	apkSigEcdsaSha512       = 0x0202
+	apkSigVerityRsaPkcs1    = 0x0421
+	apkSigVerityEcdsaSha256 = 0x0423
+)
+
+// ErrApkSignatureUnsupported is the error of the signatures alphawing cannot verify, e.g. DSA, which are left to the devices.
+var ErrApkSignatureUnsupported = errors.New("the signature algorithm is not supported")
+
+// an ApkSignatureError is the error of an apk not signed or with a broken signature, which the devices refuse to install
+type ApkSignatureError struct {
+	Err error
+}
+
+func (e *ApkSignatureError) Error() string {
+	return "invalid apk signature: " + e.Err.Error()
+}
+
+// a SignerChangedError is the error of an apk signed with another key than the previous one, for the app requiring the same signer
+type SignerChangedError struct {
+	Previous *Bundle
+}
+
+func (e *SignerChangedError) Error() string {
+	return fmt.Sprintf("the apk is signed with another certificate than revision %d of %s", e.Previous.Revision, e.Previous.BundleVersion)
+}
+
+// an ApkSignature is the signer of an apk verified by the scheme the devices verify, the newest one it is signed with.
+// PastCertificates are the fingerprints of the certificates the signer is rotated from by the proof of rotation of v3.
+type ApkSignature struct {
+	Scheme           string
+	Certificate      string
+	PastCertificates []string
+}
+
+// CertificateFingerprint returns the SHA-256 of the certificate in the form of keytool and apksigner, e.g. AB:CD:...
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	hexes := make([]string, len(sum))
+	for i, b := range sum {
+		hexes[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hexes, ":")
+}
+
+// RotatedFrom tells whether the signer is rotated from the certificate by the proof of rotation.
+func (signature *ApkSignature) RotatedFrom(certificate string) bool {
+	for _, c := range signature.PastCertificates {
+		if c == certificate {
+			return true
+		}
+	}
+	return false
+}
+
+// SignerChanged tells whether the apk is signed with another certificate than the previous apk of the variant.
+func (bundle *Bundle) SignerChanged() bool {
+	return bundle.SignerChangedFrom != 0
+}
+
+// verifyApkSigner verifies the signature of the apk before it is saved, and records its certificate and the previous apk
+// of the variant if the certificate is changed from its one. The certificates rotated by the proof of rotation of v3
+// are not changes, and the signatures alphawing cannot verify are left to the devices.
+func (app *App) verifyApkSigner(txn gorp.SqlExecutor, bundle *Bundle, file *os.File) error {
+	if bundle.PlatformType != BundlePlatformTypeAndroid || bundle.BundleInfo.AppBundle {
+		return nil
+	}
+	signature, err := VerifyApkSignature(file)
+	if err == ErrApkSignatureUnsupported {
+		revel.WARN.Printf("the signature of %s is not verified: %s", bundle.BundleInfo.Identifier, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	bundle.SigningScheme = signature.Scheme
+	bundle.SigningCertificate = signature.Certificate
+
+	previous, err := app.previousSignedBundle(txn, bundle)
+	if err != nil {
+		return err
+	}
+	if previous == nil || previous.SigningCertificate == bundle.SigningCertificate || signature.RotatedFrom(previous.SigningCertificate) {
+		return nil
+	}
+	if app.RequireSameSigner {
+		return &SignerChangedError{Previous: previous}
+	}
+	bundle.SignerChangedFrom = previous.Id
+	return nil
+}
+
+// previousSignedBundle returns the last apk of the variant with the certificate recorded, other than the bundle.
+// The apks uploaded before the certificates were recorded do not tell the change.
+func (app *App) previousSignedBundle(txn gorp.SqlExecutor, bundle *Bundle) (*Bundle, error) {
+	var bundles []*Bundle
+	_, err := txn.Select(&bundles, "SELECT * FROM bundle WHERE app_id = ? AND platform_type = ? AND variant = ? AND app_bundle = ? AND signing_certificate != '' AND id != ? ORDER BY id DESC LIMIT 1",
+		app.Id, BundlePlatformTypeAndroid, bundle.Variant, false, bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(bundles) == 0 {
+		return nil, nil
+	}
+	return bundles[0], nil
+}
+
+// verifyStoredApkSigner verifies the signature of the apk streamed to the storage, reading it back,
+// as the signatures are at the end of the file.
+func (app *App) verifyStoredApkSigner(txn gorp.SqlExecutor, storage Storage, bundle *Bundle) error {
+	if bundle.PlatformType != BundlePlatformTypeAndroid || bundle.BundleInfo.AppBundle {
+		return nil
+	}
+	object, err := storage.Get(bundle.FileId)
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	tmp, err := ioutil.TempFile("", "alphawing-signature")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, object.Body); err != nil {
+		return err
+	}
+	return app.verifyApkSigner(txn, bundle, tmp)
+}
+
+// VerifyApkSignature verifies the signature of the apk by the newest scheme it is signed with, v3, v2 and then v1,
+// as the devices since Android 9 do. The older schemes are left to the older devices, which verify them on install.
+func VerifyApkSignature(file *os.File) (*ApkSignature, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	apk := &apkFile{r: file, size: stat.Size()}
+
+	if err := apk.findSigningBlock(); err != nil {
+		return nil, err
+	}
+	if block := apk.blocks[apkSignatureV3Id]; block != nil {
+		return apk.verifyV3(block)
+	}
+	if block := apk.blocks[apkSignatureV2Id]; block != nil {
+		return apk.verifyV2(block)
+	}
+	return verifyJarSignature(file, stat.Size())
+}
+
+type apkFile struct {
+	r    io.ReaderAt
+	size int64
+
+	eocdOffset     int64
+	eocd           []byte
+	cdOffset       int64
+	sigBlockOffset int64
+	blocks         map[uint32][]byte
+	contentDigests map[int][]byte
+}
+
+// findSigningBlock reads the end of central directory and the APK Signing Block before the central directory, if any.
+func (apk *apkFile) findSigningBlock() error {
+	// the comment of the end of central directory is up to 65535 bytes
+	tailSize := int64(22 + 65535)
+	if tailSize > apk.size {
+		tailSize = apk.size
+	}
+	tail := make([]byte, tailSize)
+	if _, err := apk.r.ReadAt(tail, apk.size-tailSize); err != nil {
+		return err
+	}
+	eocd := -1
+	for i := len(tail) - 22; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == 0x06054b50 && i+22+int(binary.LittleEndian.Uint16(tail[i+20:])) == len(tail) {
+			eocd = i
+			break
+		}
+	}
+	if eocd < 0 {
+		return &BundleParseError{Err: zip.ErrFormat}
+	}
+	apk.eocdOffset = apk.size - tailSize + int64(eocd)
+	apk.eocd = tail[eocd:]
+	apk.cdOffset = int64(binary.LittleEndian.Uint32(apk.eocd[16:]))
+	apk.sigBlockOffset = apk.cdOffset
+	if apk.cdOffset > apk.eocdOffset {
+		return &BundleParseError{Err: zip.ErrFormat}
+	}
+
+	apk.blocks = map[uint32][]byte{}
+	if apk.cdOffset < 32 {
+		return nil
+	}
+	footer := make([]byte, 24)
+	if _, err := apk.r.ReadAt(footer, apk.cdOffset-24); err != nil {
+		return err
+	}
+	if string(footer[8:]) != apkSigBlockMagic {
+		return nil
+	}
+	blockSize := binary.LittleEndian.Uint64(footer)
+	if blockSize < 24 || blockSize > uint64(apk.cdOffset-8) {
+		return &ApkSignatureError{Err: errors.New("the APK Signing Block is truncated")}
+	}
+	apk.sigBlockOffset = apk.cdOffset - int64(blockSize) - 8
+	block := make([]byte, blockSize+8)
+	if _, err := apk.r.ReadAt(block, apk.sigBlockOffset); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(block) != blockSize {
+		return &ApkSignatureError{Err: errors.New("the sizes of the APK Signing Block differ")}
+	}
+
+	pairs := block[8 : len(block)-24]
+	for len(pairs) > 0 {
+		if len(pairs) < 12 {
+			return &ApkSignatureError{Err: errors.New("the APK Signing Block is truncated")}
+		}
+		size := binary.LittleEndian.Uint64(pairs)
+		if size < 4 || size > uint64(len(pairs)-8) {
+			return &ApkSignatureError{Err: errors.New("the APK Signing Block is truncated")}
+		}
+		id := binary.LittleEndian.Uint32(pairs[8:])
+		apk.blocks[id] = pairs[12 : 8+size]
+		pairs = pairs[8+size:]
+	}
+	return nil
+}
+
+// an apkSigner is a signer of the v2 or the v3 scheme
+type apkSigner struct {
+	signedData   []byte
+	digests      map[uint32][]byte
+	certificates [][]byte
+	attributes   map[uint32][]byte
+	signatures   map[uint32][]byte
+	publicKey    []byte
+	maxSdk       uint32
+}
+
+func (apk *apkFile) verifyV2(block []byte) (*ApkSignature, error) {
+	signers, err := parseApkSigners(block, false)
+	if err != nil {
+		return nil, err
+	}
+	// the devices require all the signers, which are rare anyway
+	for _, signer := range signers {
+		if err := apk.verifySigner(signer); err != nil {
+			return nil, err
+		}
+	}
+	return &ApkSignature{Scheme: ApkSigningSchemeV2, Certificate: CertificateFingerprint(signers[0].certificates[0])}, nil
+}
+
+func (apk *apkFile) verifyV3(block []byte) (*ApkSignature, error) {
+	signers, err := parseApkSigners(block, true)
+	if err != nil {
+		return nil, err
+	}
+	// the signers are for the ranges of the SDK versions, and the one of the newest devices is the current one
+	current := signers[0]
+	for _, signer := range signers {
+		if err := apk.verifySigner(signer); err != nil {
+			return nil, err
+		}
+		if signer.maxSdk > current.maxSdk {
+			current = signer
+		}
+	}
+
+	signature := &ApkSignature{Scheme: ApkSigningSchemeV3, Certificate: CertificateFingerprint(current.certificates[0])}
+	if lineage := current.attributes[apkProofOfRotationId]; lineage != nil {
+		certificates, err := verifySigningLineage(lineage)
+		if err != nil {
+			return nil, &ApkSignatureError{Err: err}
+		}
+		if len(certificates) == 0 || CertificateFingerprint(certificates[len(certificates)-1]) != signature.Certificate {
+			return nil, &ApkSignatureError{Err: errors.New("the proof of rotation does not end with the signer")}
+		}
+		for _, certificate := range certificates[:len(certificates)-1] {
+			signature.PastCertificates = append(signature.PastCertificates, CertificateFingerprint(certificate))
+		}
+	}
+	return signature, nil
+}
+
+func parseApkSigners(block []byte, v3 bool) ([]*apkSigner, error) {
+	seq, err := lengthPrefixed(&block)
+	if err != nil {
+		return nil, &ApkSignatureError{Err: err}
+	}
+	var signers []*apkSigner
+	for len(seq) > 0 {
+		data, err := lengthPrefixed(&seq)
+		if err != nil {
+			return nil, &ApkSignatureError{Err: err}
+		}
+		signer, err := parseApkSigner(data, v3)
+		if err != nil {
+			return nil, &ApkSignatureError{Err: err}
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, &ApkSignatureError{Err: errors.New("no signers are found")}
+	}
+	return signers, nil
+}
+
+func parseApkSigner(data []byte, v3 bool) (*apkSigner, error) {
+	signer := &apkSigner{digests: map[uint32][]byte{}, attributes: map[uint32][]byte{}, signatures: map[uint32][]byte{}}
+	var err error
+	if signer.signedData, err = lengthPrefixed(&data); err != nil {
+		return nil, err
+	}
+	if v3 {
+		// the range of the SDK versions, repeated in the signed data
+		if len(data) < 8 {
+			return nil, errors.New("the signer is truncated")
+		}
+		signer.maxSdk = binary.LittleEndian.Uint32(data[4:])
+		data = data[8:]
+	}
+	signatures, err := lengthPrefixed(&data)
+	if err != nil {
+		return nil, err
+	}
+	if err := idValuePairs(signatures, signer.signatures, true); err != nil {
+		return nil, err
+	}
+	if signer.publicKey, err = lengthPrefixed(&data); err != nil {
+		return nil, err
+	}
+
+	signed := signer.signedData
+	digests, err := lengthPrefixed(&signed)
+	if err != nil {
+		return nil, err
+	}
+	if err := idValuePairs(digests, signer.digests, true); err != nil {
+		return nil, err
+	}
+	certificates, err := lengthPrefixed(&signed)
+	if err != nil {
+		return nil, err
+	}
+	for len(certificates) > 0 {
+		certificate, err := lengthPrefixed(&certificates)
+		if err != nil {
+			return nil, err
+		}
+		signer.certificates = append(signer.certificates, certificate)
+	}
+	if len(signer.certificates) == 0 {
+		return nil, errors.New("the signer has no certificates")
+	}
+	if v3 {
+		if len(signed) < 8 {
+			return nil, errors.New("the signed data is truncated")
+		}
+		if binary.LittleEndian.Uint32(signed[4:]) != signer.maxSdk {
+			return nil, errors.New("the SDK versions of the signer differ from the signed ones")
+		}
+		signed = signed[8:]
+	}
+	attributes, err := lengthPrefixed(&signed)
+	if err != nil {
+		return nil, err
+	}
+	if err := idValuePairs(attributes, signer.attributes, false); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// verifySigner verifies the signed data with the strongest algorithm supported, and the digest of the contents in it.
+func (apk *apkFile) verifySigner(signer *apkSigner) error {
+	certificate, err := x509.ParseCertificate(signer.certificates[0])
+	if err != nil {
+		return &ApkSignatureError{Err: err}
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(signer.publicKey)
+	if err != nil {
+		return &ApkSignatureError{Err: err}
+	}
+	certificateKey, err := x509.MarshalPKIXPublicKey(certificate.PublicKey)
+	if err != nil || !bytes.Equal(certificateKey, signer.publicKey) {
+		return &ApkSignatureError{Err: errors.New("the public key differs from the one of the certificate")}
+	}
+
+	algorithm := uint32(0)
+	for _, id := range []uint32{apkSigRsaPssSha512, apkSigRsaPkcs1Sha512, apkSigEcdsaSha512, apkSigRsaPssSha256, apkSigRsaPkcs1Sha256, apkSigEcdsaSha256} {
+		if signer.signatures[id] != nil {
+			algorithm = id
+			break
+		}
+	}
+	if algorithm == 0 {
+		return ErrApkSignatureUnsupported
+	}
+	if err := verifyApkSignatureAlgorithm(publicKey, algorithm, signer.signedData, signer.signatures[algorithm]); err != nil {
+		return &ApkSignatureError{Err: err}
+	}
+
+	expected := signer.digests[algorithm]
+	if expected == nil {
+		return &ApkSignatureError{Err: errors.New("the digest of the signature algorithm is not signed")}
+	}
+	digest, err := apk.contentDigest(apkSignatureHash(algorithm))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(digest, expected) {
+		return &ApkSignatureError{Err: errors.New("the contents differ from the signed ones")}
+	}
+	return nil
+}
+
+// contentDigest returns the digest of the 1MB chunks of the entries, the central directory and the end of central directory
+// pointing to the APK Signing Block instead, as the signatures of v2 and v3 cover them.
+func (apk *apkFile) contentDigest(h crypto.Hash) ([]byte, error) {
+	if digest := apk.contentDigests[int(h)]; digest != nil {
+		return digest, nil
+	}
+
+	eocd := make([]byte, len(apk.eocd))
+	copy(eocd, apk.eocd)
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(apk.sigBlockOffset))
+	sections := []io.Reader{
+		io.NewSectionReader(apk.r, 0, apk.sigBlockOffset),
+		io.NewSectionReader(apk.r, apk.cdOffset, apk.eocdOffset-apk.cdOffset),
+		bytes.NewReader(eocd),
+	}
+	sizes := []int64{apk.sigBlockOffset, apk.eocdOffset - apk.cdOffset, int64(len(eocd))}
+
+	var chunks int64
+	for _, size := range sizes {
+		chunks += (size + apkChunkSize - 1) / apkChunkSize
+	}
+	top := h.New()
+	top.Write([]byte{0x5a})
+	binary.Write(top, binary.LittleEndian, uint32(chunks))
+
+	buf := make([]byte, apkChunkSize)
+	chunkHash := h.New()
+	for i, section := range sections {
+		for remaining := sizes[i]; remaining > 0; {
+			n := int64(apkChunkSize)
+			if remaining < n {
+				n = remaining
+			}
+			if _, err := io.ReadFull(section, buf[:n]); err != nil {
+				return nil, err
+			}
+			chunkHash.Reset()
+			chunkHash.Write([]byte{0xa5})
+			binary.Write(chunkHash, binary.LittleEndian, uint32(n))
+			chunkHash.Write(buf[:n])
+			top.Write(chunkHash.Sum(nil))
+			remaining -= n
+		}
+	}
+
+	if apk.contentDigests == nil {
+		apk.contentDigests = map[int][]byte{}
+	}
+	apk.contentDigests[int(h)] = top.Sum(nil)
+	return apk.contentDigests[int(h)], nil
+}
+
+func apkSignatureHash(algorithm uint32) crypto.Hash {
+	switch algorithm {
+	case apkSigRsaPssSha512, apkSigRsaPkcs1Sha512, apkSigEcdsaSha512:
+		return crypto.SHA512
+	}
+	return crypto.SHA256
+}
+
+func verifyApkSignatureAlgorithm(publicKey interface{}, algorithm uint32, data, signature []byte) error {
+	h := apkSignatureHash(algorithm)
+	switch algorithm {
+	case apkSigRsaPssSha256, apkSigRsaPssSha512:
+		key, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("the public key is not of RSA")
+		}
+		return rsa.VerifyPSS(key, h, digestOf(h, data), signature, &rsa.PSSOptions{SaltLength: h.Size(), Hash: h})
+	case apkSigRsaPkcs1Sha256, apkSigRsaPkcs1Sha512, apkSigVerityRsaPkcs1, apkSigEcdsaSha256, apkSigEcdsaSha512, apkSigVerityEcdsaSha256:
+		return verifyPkcs1OrEcdsa(publicKey, h, data, signature)
+	}
+	return ErrApkSignatureUnsupported
+}
+
+// verifyPkcs1OrEcdsa verifies the signature of RSA PKCS #1 v1.5 or ECDSA by the key, the ones of the certificates of v1 too.
+// x509 is not used, as it refuses SHA-1 which the old apks are signed with.
+func verifyPkcs1OrEcdsa(publicKey interface{}, h crypto.Hash, data, signature []byte) error {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, h, digestOf(h, data), signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digestOf(h, data), signature) {
+			return errors.New("the ECDSA signature is invalid")
+		}
+		return nil
+	}
+	return ErrApkSignatureUnsupported
+}
+
+func digestOf(h crypto.Hash, data []byte) []byte {
+	hash := h.New()
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+// verifySigningLineage verifies the proof of rotation, where each certificate is signed by the one before,
+// and returns the certificates from the oldest one.
+func verifySigningLineage(lineage []byte) ([][]byte, error) {
+	if len(lineage) < 4 {
+		return nil, errors.New("the proof of rotation is truncated")
+	}
+	lineage = lineage[4:]
+
+	var certificates [][]byte
+	var last *x509.Certificate
+	var lastAlgorithm uint32
+	for len(lineage) > 0 {
+		node, err := lengthPrefixed(&lineage)
+		if err != nil {
+			return nil, err
+		}
+		signedData, err := lengthPrefixed(&node)
+		if err != nil {
+			return nil, err
+		}
+		if len(node) < 8 {
+			return nil, errors.New("the proof of rotation is truncated")
+		}
+		algorithm := binary.LittleEndian.Uint32(node[4:])
+		node = node[8:]
+		signature, err := lengthPrefixed(&node)
+		if err != nil {
+			return nil, err
+		}
+
+		signed := signedData
+		der, err := lengthPrefixed(&signed)
+		if err != nil {
+			return nil, err
+		}
+		if len(signed) < 4 {
+			return nil, errors.New("the proof of rotation is truncated")
+		}
+		if last != nil {
+			if binary.LittleEndian.Uint32(signed) != lastAlgorithm {
+				return nil, errors.New("the signature algorithms of the proof of rotation differ")
+			}
+			if err := verifyApkSignatureAlgorithm(last.PublicKey, lastAlgorithm, signedData, signature); err != nil {
+				return nil, err
+			}
+		}
+		if last, err = x509.ParseCertificate(der); err != nil {
+			return nil, err
+		}
+		lastAlgorithm = algorithm
+		certificates = append(certificates, der)
+	}
+	return certificates, nil
+}
+
+func lengthPrefixed(data *[]byte) ([]byte, error) {
+	if len(*data) < 4 {
+		return nil, errors.New("the length prefixed data is truncated")
+	}
+	size := binary.LittleEndian.Uint32(*data)
+	if uint64(size) > uint64(len(*data)-4) {
+		return nil, errors.New("the length prefixed data is truncated")
+	}
+	value := (*data)[4 : 4+size]
+	*data = (*data)[4+size:]
+	return value, nil
+}
+
+// idValuePairs reads the sequence of the pairs of an ID and a value, which is length prefixed for the digests and the signatures.
+func idValuePairs(seq []byte, pairs map[uint32][]byte, prefixed bool) error {
+	for len(seq) > 0 {
+		pair, err := lengthPrefixed(&seq)
+		if err != nil {
+			return err
+		}
+		if len(pair) < 4 {
+			return errors.New("the pair is truncated")
+		}
+		id := binary.LittleEndian.Uint32(pair)
+		value := pair[4:]
+		if prefixed {
+			if value, err = lengthPrefixed(&value); err != nil {
+				return err
+			}
+		}
+		pairs[id] = value
+	}
+	return nil
+}
+
+var (
+	oidSha1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSha256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSha512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+// the PKCS #7 signed data of the signature block of a JAR, only with what the verification needs
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// verifyJarSignature verifies the JAR signature of v1: the signature block over the signature file,
+// the signature file over the manifest and the manifest over every entry.
+func verifyJarSignature(file *os.File, size int64) (*ApkSignature, error) {
+	reader, err := zip.NewReader(file, size)
+	if err != nil {
+		return nil, &BundleParseError{Err: err}
+	}
+	entries := map[string]*zip.File{}
+	for _, f := range reader.File {
+		entries[f.Name] = f
+	}
+
+	var sfName, blockName string
+	for _, f := range reader.File {
+		dir, name := path.Split(f.Name)
+		if dir != "META-INF/" {
+			continue
+		}
+		ext := path.Ext(name)
+		if ext != ".RSA" && ext != ".EC" && ext != ".DSA" {
+			continue
+		}
+		sf := "META-INF/" + strings.TrimSuffix(name, ext) + ".SF"
+		if entries[sf] != nil {
+			sfName, blockName = sf, f.Name
+			break
+		}
+	}
+	if sfName == "" {
+		return nil, &ApkSignatureError{Err: errors.New("the apk is not signed")}
+	}
+	if path.Ext(blockName) == ".DSA" {
+		return nil, ErrApkSignatureUnsupported
+	}
+
+	sf, err := readZipEntry(entries[sfName])
+	if err != nil {
+		return nil, err
+	}
+	block, err := readZipEntry(entries[blockName])
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := verifyPkcs7(block, sf)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestEntry := entries["META-INF/MANIFEST.MF"]
+	if manifestEntry == nil {
+		return nil, &ApkSignatureError{Err: errors.New("META-INF/MANIFEST.MF is not found")}
+	}
+	manifest, err := readZipEntry(manifestEntry)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyDigestAttribute(parseManifestSections(sf)[0], "-Digest-Manifest", manifest); err != nil {
+		return nil, &ApkSignatureError{Err: fmt.Errorf("META-INF/MANIFEST.MF: %s", err)}
+	}
+
+	signed := map[string]bool{}
+	for _, section := range parseManifestSections(manifest)[1:] {
+		name := section["Name"]
+		entry := entries[name]
+		if entry == nil {
+			return nil, &ApkSignatureError{Err: fmt.Errorf("%s is signed but not found", name)}
+		}
+		content, err := readZipEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyDigestAttribute(section, "-Digest", content); err != nil {
+			return nil, &ApkSignatureError{Err: fmt.Errorf("%s: %s", name, err)}
+		}
+		signed[name] = true
+	}
+	for _, f := range reader.File {
+		if !signed[f.Name] && !strings.HasSuffix(f.Name, "/") && !isJarSignatureFile(f.Name) {
+			return nil, &ApkSignatureError{Err: fmt.Errorf("%s is not signed", f.Name)}
+		}
+	}
+
+	return &ApkSignature{Scheme: ApkSigningSchemeV1, Certificate: CertificateFingerprint(certificate.Raw)}, nil
+}
+
+func isJarSignatureFile(name string) bool {
+	dir, base := path.Split(name)
+	if dir != "META-INF/" {
+		return false
+	}
+	switch path.Ext(base) {
+	case ".MF", ".SF", ".RSA", ".DSA", ".EC":
+		return true
+	}
+	return strings.HasPrefix(base, "SIG-")
+}
+
+// parsePkcs7 reads the PKCS #7 signed data, and returns the certificate of the first signer with its signer info.
+func parsePkcs7(block []byte) (*x509.Certificate, *pkcs7SignerInfo, error) {
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(block, &info); err != nil {
+		return nil, nil, err
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signedData); err != nil {
+		return nil, nil, err
+	}
+	certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(signedData.SignerInfos) == 0 {
+		return nil, nil, errors.New("the signed data has no signers")
+	}
+
+	signer := &signedData.SignerInfos[0]
+	for _, c := range certificates {
+		if c.SerialNumber.Cmp(signer.IssuerAndSerialNumber.SerialNumber) == 0 && bytes.Equal(c.RawIssuer, signer.IssuerAndSerialNumber.Issuer.FullBytes) {
+			return c, signer, nil
+		}
+	}
+	return nil, nil, errors.New("the certificate of the signer is not found")
+}
+
+// verifyPkcs7 verifies the signature block over the signature file, and returns the certificate of the signer.
+func verifyPkcs7(block, content []byte) (*x509.Certificate, error) {
+	certificate, signer, err := parsePkcs7(block)
+	if err != nil {
+		return nil, &ApkSignatureError{Err: err}
+	}
+
+	var h crypto.Hash
+	switch {
+	case signer.DigestAlgorithm.Algorithm.Equal(oidSha1):
+		h = crypto.SHA1
+	case signer.DigestAlgorithm.Algorithm.Equal(oidSha256):
+		h = crypto.SHA256
+	case signer.DigestAlgorithm.Algorithm.Equal(oidSha512):
+		h = crypto.SHA512
+	default:
+		return nil, ErrApkSignatureUnsupported
+	}
+
+	signed := content
+	if len(signer.AuthenticatedAttributes.FullBytes) > 0 {
+		// the attributes are signed with the tag of SET OF instead of the implicit one, and have the digest of the content
+		if err := verifyMessageDigest(signer.AuthenticatedAttributes.Bytes, digestOf(h, content)); err != nil {
+			return nil, err
+		}
+		signed = append([]byte{0x31}, signer.AuthenticatedAttributes.FullBytes[1:]...)
+	}
+	if err := verifyPkcs1OrEcdsa(certificate.PublicKey, h, signed, signer.EncryptedDigest); err != nil {
+		if err == ErrApkSignatureUnsupported {
+			return nil, err
+		}
+		return nil, &ApkSignatureError{Err: err}
+	}
+	return certificate, nil
+}
+
+func verifyMessageDigest(attributes, digest []byte) error {
+	for len(attributes) > 0 {
+		var attribute pkcs7Attribute
+		rest, err := asn1.Unmarshal(attributes, &attribute)
+		if err != nil {
+			return &ApkSignatureError{Err: err}
+		}
+		attributes = rest
+		if !attribute.Type.Equal(oidMessageDigest) {
+			continue
+		}
+		var value []byte
+		if _, err := asn1.Unmarshal(attribute.Values.Bytes, &value); err != nil {
+			return &ApkSignatureError{Err: err}
+		}
+		if !bytes.Equal(value, digest) {
+			return &ApkSignatureError{Err: errors.New("the signature file differs from the signed one")}
+		}
+		return nil
+	}
+	return &ApkSignatureError{Err: errors.New("the digest of the signature file is not signed")}
+}
+
+// verifyDigestAttribute verifies the content by the strongest of the digests of the section, e.g. SHA-256-Digest.
+func verifyDigestAttribute(section map[string]string, suffix string, content []byte) error {
+	for _, digest := range []struct {
+		name string
+		hash func() hash.Hash
+	}{{"SHA-512", sha512.New}, {"SHA-256", sha256.New}, {"SHA1", sha1.New}, {"SHA-1", sha1.New}} {
+		value, ok := section[digest.name+suffix]
+		if !ok {
+			continue
+		}
+		expected, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return err
+		}
+		h := digest.hash()
+		h.Write(content)
+		if !bytes.Equal(h.Sum(nil), expected) {
+			return errors.New("the digest differs from the signed one")
+		}
+		return nil
+	}
+	return fmt.Errorf("no digest%s is found", suffix)
+}
+
+// parseManifestSections parses the sections of a manifest or a signature file, the main section first.
+// The lines are wrapped at 72 bytes with the continuations starting with a space.
+func parseManifestSections(data []byte) []map[string]string {
+	sections := []map[string]string{{}}
+	section := sections[0]
+	var last string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			if len(section) > 0 {
+				section = map[string]string{}
+				sections = append(sections, section)
+			}
+			last = ""
+		case strings.HasPrefix(line, " ") && last != "":
+			section[last] += line[1:]
+		default:
+			if i := strings.Index(line, ": "); i > 0 {
+				last = line[:i]
+				section[last] = line[i+2:]
+			}
+		}
+	}
+	if len(section) == 0 && len(sections) > 1 {
+		sections = sections[:len(sections)-1]
+	}
+	return sections
+}
+
+func readZipEntry(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, &BundleParseError{Err: err}
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, &BundleParseError{Err: err}
+	}
+	return b, nil
+}
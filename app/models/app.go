@@ -3,8 +3,11 @@ package models
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"fmt"
+	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -12,17 +15,85 @@ import (
 	"code.google.com/p/google-api-go-client/drive/v2"
 
 	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
 )
 
+type AppVisibility int
+
+// the zero value keeps the apps created before the visibility was introduced unlisted
+const (
+	AppVisibilityUnlisted AppVisibility = iota
+	AppVisibilityListed
+	AppVisibilityPrivate
+)
+
+func (visibility AppVisibility) IsValid() bool {
+	return AppVisibilityUnlisted <= visibility && visibility <= AppVisibilityPrivate
+}
+
+func (visibility AppVisibility) String() string {
+	var str string
+	if visibility == AppVisibilityUnlisted {
+		str = "unlisted"
+	} else if visibility == AppVisibilityListed {
+		str = "listed"
+	} else if visibility == AppVisibilityPrivate {
+		str = "private"
+	}
+	return str
+}
+
+func ParseAppVisibility(str string) (AppVisibility, error) {
+	for _, visibility := range []AppVisibility{AppVisibilityUnlisted, AppVisibilityListed, AppVisibilityPrivate} {
+		if str == visibility.String() {
+			return visibility, nil
+		}
+	}
+	if str == "" {
+		return AppVisibilityUnlisted, nil
+	}
+	return 0, fmt.Errorf("visibility %q is invalid", str)
+}
+
 // https://github.com/coopernurse/gorp#mapping-structs-to-tables
 type App struct {
-	Id          int       `db:"id"`
-	Title       string    `db:"title"`
-	FileId      string    `db:"file_id"`
-	ApiToken    string    `db:"api_token"`
-	Description string    `db:"description"`
-	CreatedAt   time.Time `db:"created_at"`
-	UpdatedAt   time.Time `db:"updated_at"`
+	Id                   int           `db:"id"`
+	Title                string        `db:"title"`
+	FileId               string        `db:"file_id"`
+	ApiToken             string        `db:"api_token"`
+	Description          string        `db:"description"`
+	DescriptionTemplate  string        `db:"description_template"`
+	FirebaseAndroidAppId string        `db:"firebase_android_app_id"`
+	FirebaseIosAppId     string        `db:"firebase_ios_app_id"`
+	Visibility           AppVisibility `db:"visibility"`
+	Category             string        `db:"category"`
+	IconFileId           string        `db:"icon_file_id"`
+	StorageLocation      string        `db:"storage_location"`
+	RequireSameSigner    bool          `db:"require_same_signer"`
+	StorageQuotaMb       int           `db:"storage_quota_mb"`
+	CreatedAt            time.Time     `db:"created_at"`
+	UpdatedAt            time.Time     `db:"updated_at"`
+}
+
+// FirebaseAppId returns the Firebase App ID to publish bundles of the platform to, if any.
+func (app *App) FirebaseAppId(platformType BundlePlatformType) string {
+	var firebaseAppId string
+	if platformType == BundlePlatformTypeAndroid {
+		firebaseAppId = app.FirebaseAndroidAppId
+	} else if platformType == BundlePlatformTypeIOS {
+		firebaseAppId = app.FirebaseIosAppId
+	}
+	return firebaseAppId
+}
+
+// IsListed reports whether the app appears in the directory for the users without authority.
+func (app *App) IsListed() bool {
+	return app.Visibility == AppVisibilityListed
+}
+
+// IsPrivate reports whether the app is hidden from the users without authority.
+func (app *App) IsPrivate() bool {
+	return app.Visibility == AppVisibilityPrivate
 }
 
 func (app *App) Bundles(txn gorp.SqlExecutor) ([]*Bundle, error) {
@@ -34,38 +105,148 @@ func (app *App) Bundles(txn gorp.SqlExecutor) ([]*Bundle, error) {
 	return bundles, nil
 }
 
-func (app *App) BundlesByPlatformType(txn gorp.SqlExecutor, platformType BundlePlatformType) ([]*Bundle, error) {
+// variantCondition narrows the query to the bundles of the variant, or leaves it for every variant if the variant is empty.
+func variantCondition(variant string, args []interface{}) (string, []interface{}) {
+	if variant == "" {
+		return "", args
+	}
+	return " AND variant = ?", append(args, variant)
+}
+
+// BundlesByPlatformType returns the bundles of the platform, of the variant unless it is empty.
+func (app *App) BundlesByPlatformType(txn gorp.SqlExecutor, platformType BundlePlatformType, variant string) ([]*Bundle, error) {
+	condition, args := variantCondition(variant, []interface{}{app.Id, platformType})
 	var bundles []*Bundle
-	_, err := txn.Select(&bundles, "SELECT * FROM bundle WHERE app_id = ? AND platform_type = ? ORDER BY id DESC", app.Id, platformType)
+	_, err := txn.Select(&bundles, "SELECT * FROM bundle WHERE app_id = ? AND platform_type = ?"+condition+" ORDER BY id DESC", args...)
 	if err != nil {
 		return nil, err
 	}
 	return bundles, nil
 }
 
-func (app *App) BundlesWithPager(txn gorp.SqlExecutor, page, limit int) (Bundles, int, error) {
+// LatestBundle returns nil if no bundle of the platform and the variant is uploaded.
+// The empty variant matches the bundles of every variant. The recalled bundles are skipped.
+func (app *App) LatestBundle(txn gorp.SqlExecutor, platformType BundlePlatformType, variant string) (*Bundle, error) {
+	condition, args := variantCondition(variant, []interface{}{app.Id, platformType})
+	var bundles []*Bundle
+	_, err := txn.Select(&bundles, "SELECT * FROM bundle WHERE app_id = ? AND platform_type = ? AND recalled = 0"+condition+" ORDER BY id DESC LIMIT 1", args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(bundles) == 0 {
+		return nil, nil
+	}
+	return bundles[0], nil
+}
+
+// LatestBundles returns the newest bundles of the app with the file stored and not recalled, newest first.
+func (app *App) LatestBundles(txn gorp.SqlExecutor, limit int) ([]*Bundle, error) {
+	var bundles []*Bundle
+	_, err := txn.Select(&bundles, "SELECT * FROM bundle WHERE app_id = ? AND file_id <> '' AND recalled = 0 ORDER BY id DESC LIMIT ?", app.Id, limit)
+	if err != nil {
+		return nil, err
+	}
+	return bundles, nil
+}
+
+// SetIcon uploads the icon into the app folder and replaces the current one.
+func (app *App) SetIcon(txn gorp.SqlExecutor, s *GoogleService, file *os.File, filename string) error {
+	driveFile, err := s.InsertFile(file, filename, app.ParentReference())
+	if err != nil {
+		return err
+	}
+
+	if app.IconFileId != "" {
+		if err := s.DeleteFile(app.IconFileId); err != nil {
+			code, _, _ := ParseGoogleApiError(err)
+			if code != http.StatusNotFound {
+				return err
+			}
+		}
+	}
+
+	// Update copies the editable fields only
+	app.IconFileId = driveFile.Id
+	if _, err = txn.Exec("UPDATE app SET icon_file_id = ? WHERE id = ?", app.IconFileId, app.Id); err != nil {
+		return err
+	}
+	return RecordEvent(txn, EventResourceApp, app.Id, app.Id, EventActionUpdate)
+}
+
+// Variants returns the variants of the bundles uploaded, e.g. the product flavors or the build configurations.
+func (app *App) Variants(txn gorp.SqlExecutor) ([]string, error) {
+	var variants []string
+	_, err := txn.Select(&variants, "SELECT DISTINCT variant FROM bundle WHERE app_id = ? AND variant <> '' ORDER BY variant", app.Id)
+	if err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+func (app *App) BundlesWithPager(txn gorp.SqlExecutor, page, limit int, variant, uploadedBy string) (Bundles, int, error) {
 	if page < 1 {
 		page = 1
 	}
 
-	count, err := txn.SelectInt("SELECT COUNT(*) FROM bundle WHERE app_id = ?", app.Id)
+	count, err := app.BundleCount(txn, variant, uploadedBy)
 	if err != nil {
 		return nil, 0, err
 	}
+	condition, args := variantCondition(variant, []interface{}{app.Id})
+	condition, args = uploaderCondition(uploadedBy, condition, args)
 
 	offset := (page - 1) * limit
-	if int(count) <= offset {
+	if count <= offset {
 		// 空であることが明らかなのでそのまま返す
-		return Bundles([]*Bundle{}), int(count), nil
+		return Bundles([]*Bundle{}), count, nil
 	}
 
 	var bundles []*Bundle
-	_, err = txn.Select(&bundles, "SELECT * FROM bundle WHERE app_id = ? ORDER BY id DESC LIMIT ? OFFSET ?", app.Id, limit, offset)
+	_, err = txn.Select(&bundles, "SELECT * FROM bundle WHERE app_id = ?"+condition+" ORDER BY id DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	return Bundles(bundles), int(count), nil
+	return Bundles(bundles), count, nil
+}
+
+// BundlesWithCursor returns the bundles below the ID of the cursor, newest first, and the ID of the last one
+// for the cursor of the next page, which is 0 on the last page. The bundles uploaded meanwhile are above it.
+func (app *App) BundlesWithCursor(txn gorp.SqlExecutor, before, limit int, variant, uploadedBy string) (Bundles, int, error) {
+	condition, args := variantCondition(variant, []interface{}{app.Id})
+	condition, args = uploaderCondition(uploadedBy, condition, args)
+	if before > 0 {
+		condition += " AND id < ?"
+		args = append(args, before)
+	}
+
+	// one more bundle tells whether the page is the last one
+	var bundles []*Bundle
+	_, err := txn.Select(&bundles, "SELECT * FROM bundle WHERE app_id = ?"+condition+" ORDER BY id DESC LIMIT ?", append(args, limit+1)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(bundles) <= limit {
+		return Bundles(bundles), 0, nil
+	}
+	bundles = bundles[:limit]
+	return Bundles(bundles), bundles[limit-1].Id, nil
+}
+
+func (app *App) BundleCount(txn gorp.SqlExecutor, variant, uploadedBy string) (int, error) {
+	condition, args := variantCondition(variant, []interface{}{app.Id})
+	condition, args = uploaderCondition(uploadedBy, condition, args)
+	count, err := txn.SelectInt("SELECT COUNT(*) FROM bundle WHERE app_id = ?"+condition, args...)
+	return int(count), err
+}
+
+// bundles with the same content share a file, so the latest one is returned
+func (app *App) GetBundleByFileId(txn gorp.SqlExecutor, fileId string) (*Bundle, error) {
+	var bundle Bundle
+	if err := txn.SelectOne(&bundle, "SELECT * FROM bundle WHERE app_id = ? AND file_id = ? ORDER BY id DESC LIMIT 1", app.Id, fileId); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
 }
 
 func (app *App) Authorities(txn gorp.SqlExecutor) ([]*Authority, error) {
@@ -77,6 +258,24 @@ func (app *App) Authorities(txn gorp.SqlExecutor) ([]*Authority, error) {
 	return authorities, nil
 }
 
+func (app *App) PendingAccessRequests(txn gorp.SqlExecutor) ([]*AccessRequest, error) {
+	var requests []*AccessRequest
+	_, err := txn.Select(&requests, "SELECT * FROM access_request WHERE app_id = ? AND state = ? ORDER BY id ASC", app.Id, AccessRequestStatePending)
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func (app *App) DeviceGroups(txn gorp.SqlExecutor) ([]*DeviceGroup, error) {
+	var groups []*DeviceGroup
+	_, err := txn.Select(&groups, "SELECT * FROM device_group WHERE app_id = ? ORDER BY id ASC", app.Id)
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
 func (app *App) GetMaxRevisionByBundleVersion(txn gorp.SqlExecutor, bundleVersion string) (int, error) {
 	revision, err := txn.SelectInt(
 		"SELECT IFNULL(MAX(revision), 0) FROM bundle WHERE app_id = ? AND bundle_version = ?",
@@ -107,6 +306,17 @@ func (app *App) PreUpdate(s gorp.SqlExecutor) error {
 	return nil
 }
 
+// PostUpdate drops the plists cached for the bundles of the app, which have its title.
+func (app *App) PostUpdate(s gorp.SqlExecutor) error {
+	uncacheAppPlists(app.Id)
+	return nil
+}
+
+func (app *App) PostDelete(s gorp.SqlExecutor) error {
+	uncacheAppPlists(app.Id)
+	return nil
+}
+
 func (app *App) Save(txn gorp.SqlExecutor) error {
 	return txn.Insert(app)
 }
@@ -119,8 +329,10 @@ func (app *App) RefreshToken(txn gorp.SqlExecutor) error {
 
 	current.ApiToken = NewToken()
 
-	_, err = txn.Update(current)
-	return err
+	if _, err = txn.Update(current); err != nil {
+		return err
+	}
+	return RecordEvent(txn, EventResourceApp, app.Id, app.Id, EventActionUpdate)
 }
 
 func (app *App) Update(txn gorp.SqlExecutor) error {
@@ -131,9 +343,17 @@ func (app *App) Update(txn gorp.SqlExecutor) error {
 
 	current.Title = app.Title
 	current.Description = app.Description
-
-	_, err = txn.Update(current)
-	return err
+	current.DescriptionTemplate = app.DescriptionTemplate
+	current.FirebaseAndroidAppId = app.FirebaseAndroidAppId
+	current.FirebaseIosAppId = app.FirebaseIosAppId
+	current.Visibility = app.Visibility
+	current.Category = app.Category
+	current.RequireSameSigner = app.RequireSameSigner
+
+	if _, err = txn.Update(current); err != nil {
+		return err
+	}
+	return RecordEvent(txn, EventResourceApp, app.Id, app.Id, EventActionUpdate)
 }
 
 func (app *App) DeleteFromDB(txn gorp.SqlExecutor) error {
@@ -145,34 +365,130 @@ func (app *App) DeleteFromGoogleDrive(s *GoogleService) error {
 	return s.DeleteFile(app.FileId)
 }
 
-func (app *App) Delete(txn gorp.SqlExecutor, s *GoogleService) error {
-	if err := app.DeleteBundles(txn); err != nil {
+// Delete deletes the bundles from the storage and the app folder from Google Drive.
+func (app *App) Delete(txn gorp.SqlExecutor, s *GoogleService, storage Storage) error {
+	if err := app.DeleteBundles(txn, storage); err != nil {
+		return err
+	}
+	if err := app.DeleteFolders(txn); err != nil {
 		return err
 	}
 	if err := app.DeleteAuthorities(txn); err != nil {
 		return err
 	}
+	if err := app.DeleteDeviceGroups(txn); err != nil {
+		return err
+	}
+	if _, err := txn.Exec("DELETE FROM app_store_connect_key WHERE app_id = ?", app.Id); err != nil {
+		return err
+	}
+	if _, err := txn.Exec("DELETE FROM play_credential WHERE app_id = ?", app.Id); err != nil {
+		return err
+	}
+	if _, err := txn.Exec("DELETE FROM access_request WHERE app_id = ?", app.Id); err != nil {
+		return err
+	}
+	if err := app.DeleteStats(txn); err != nil {
+		return err
+	}
+	if err := app.DeleteDocRevisions(txn); err != nil {
+		return err
+	}
+	if err := app.DeleteNotificationRoutes(txn); err != nil {
+		return err
+	}
+	if err := app.DeleteReleasePlans(txn); err != nil {
+		return err
+	}
+	if err := app.DeleteTokenUsages(txn); err != nil {
+		return err
+	}
+	if err := app.DeleteEnvFiles(txn); err != nil {
+		return err
+	}
+	if err := RecordEvent(txn, EventResourceApp, app.Id, app.Id, EventActionDelete); err != nil {
+		return err
+	}
 	if err := app.DeleteFromDB(txn); err != nil {
 		return err
 	}
-	return app.DeleteFromGoogleDrive(s)
+	return deferDeletion(storage, func() error {
+		return app.DeleteFromGoogleDrive(s)
+	})
 }
 
-func (app *App) DeleteBundles(txn gorp.SqlExecutor) error {
+// files shared with other apps are detached from the app folder before it is deleted
+func (app *App) DeleteBundles(txn gorp.SqlExecutor, storage Storage) error {
 	bundles, err := app.Bundles(txn)
 	if err != nil {
 		return err
 	}
 
-	args := make([]interface{}, len(bundles))
-	for i, bundle := range bundles {
-		args[i] = bundle
+	for _, bundle := range bundles {
+		if err := bundle.Delete(txn, storage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *App) Folders(txn gorp.SqlExecutor) ([]*Folder, error) {
+	var folders []*Folder
+	_, err := txn.Select(&folders, "SELECT * FROM folder WHERE app_id = ? ORDER BY id ASC", app.Id)
+	if err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// version folders on Google Drive are removed along with the app folder
+func (app *App) DeleteFolders(txn gorp.SqlExecutor) error {
+	folders, err := app.Folders(txn)
+	if err != nil {
+		return err
+	}
+
+	args := make([]interface{}, len(folders))
+	for i, folder := range folders {
+		args[i] = folder
 	}
 
 	_, err = txn.Delete(args...)
 	return err
 }
 
+// VersionFolder returns the folder for the bundle version, creating it on Google Drive if needed.
+func (app *App) VersionFolder(dbm *gorp.DbMap, storage FolderStorage, bundleVersion string) (*Folder, error) {
+	folder, err := GetFolder(dbm, app.Id, bundleVersion)
+	if err == nil {
+		return folder, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	folderId, err := storage.CreateFolder(bundleVersion, app.FileId)
+	if err != nil {
+		return nil, err
+	}
+
+	folder = &Folder{
+		AppId:         app.Id,
+		BundleVersion: bundleVersion,
+		FileId:        folderId,
+	}
+	err = Transact(dbm, func(txn gorp.SqlExecutor) error {
+		return folder.Save(txn)
+	})
+	if err != nil {
+		// the folder may have been created concurrently
+		storage.Delete(folderId)
+		return GetFolder(dbm, app.Id, bundleVersion)
+	}
+
+	return folder, nil
+}
+
 func (app *App) DeleteAuthority(txn gorp.SqlExecutor, s *GoogleService, authority *Authority) error {
 	if err := authority.DeleteFromDB(txn); err != nil {
 		return err
@@ -196,6 +512,34 @@ func (app *App) DeleteAuthorities(txn gorp.SqlExecutor) error {
 	return err
 }
 
+func (app *App) DeleteDeviceGroups(txn gorp.SqlExecutor) error {
+	groups, err := app.DeviceGroups(txn)
+	if err != nil {
+		return err
+	}
+
+	args := make([]interface{}, len(groups))
+	for i, group := range groups {
+		args[i] = group
+	}
+
+	_, err = txn.Delete(args...)
+	return err
+}
+
+// AuthorityForEmail returns nil if the email has no authority for the app.
+func (app *App) AuthorityForEmail(txn gorp.SqlExecutor, email string) (*Authority, error) {
+	var authorities []*Authority
+	_, err := txn.Select(&authorities, "SELECT * FROM authority WHERE app_id = ? AND email = ? LIMIT 1", app.Id, email)
+	if err != nil {
+		return nil, err
+	}
+	if len(authorities) == 0 {
+		return nil, nil
+	}
+	return authorities[0], nil
+}
+
 func (app *App) HasAuthorityForEmail(txn gorp.SqlExecutor, email string) (bool, error) {
 	count, err := txn.SelectInt("SELECT COUNT(id) FROM authority WHERE app_id = ? AND email = ?", app.Id, email)
 	if err != nil {
@@ -213,46 +557,197 @@ func (app *App) ParentReference() *drive.ParentReference {
 	}
 }
 
-func (app *App) CreateBundle(dbm *gorp.DbMap, s *GoogleService, bundle *Bundle) error {
+// versionLocation returns the location to put the files of the version in,
+// the version folder for the storage with folders, or the prefix of the keys otherwise.
+func (app *App) versionLocation(dbm *gorp.DbMap, storage Storage, bundleVersion string) (string, error) {
+	folderStorage, ok := storage.(FolderStorage)
+	if !ok {
+		return fmt.Sprintf("app_%d/%s", app.Id, bundleVersion), nil
+	}
+	folder, err := app.VersionFolder(dbm, folderStorage, bundleVersion)
+	if err != nil {
+		return "", err
+	}
+	return folder.FileId, nil
+}
+
+func (app *App) CreateBundle(dbm *gorp.DbMap, storage Storage, bundle *Bundle) error {
 	bundle.AppId = app.Id
 
-	bundleInfo, err := NewBundleInfo(bundle.File, bundle.PlatformType)
+	bundleInfo, err := bundle.readBundleInfo()
 	if err != nil {
-		return err
+		return &BundleParseError{Err: err}
 	}
 	if len(bundleInfo.Version) == 0 {
-		return &BundleParseError{}
+		return &BundleParseError{Err: fmt.Errorf("the version is not found")}
 	}
 	bundle.BundleInfo = bundleInfo
 
+	digest, err := FileDigest(bundle.File)
+	if err != nil {
+		return err
+	}
+	bundle.Digest = digest
+	stat, err := bundle.File.Stat()
+	if err != nil {
+		return err
+	}
+	bundle.FileSize = stat.Size()
+	if err := app.CheckStorageQuota(dbm, bundle.FileSize, bundle.Digest); err != nil {
+		return err
+	}
+	bundle.normalizeLabels()
+	if err := app.verifyApkSigner(dbm, bundle, bundle.File); err != nil {
+		return err
+	}
+	if bundle.IsIpa() {
+		// the signing is only told to the testers, so the bundle is created without it
+		signing, err := readIpaCodeSigning(bundle.File, bundleInfo)
+		if err != nil {
+			revel.WARN.Printf("failed to read the code signing of %s: %s", bundleInfo.Identifier, err)
+		}
+		signing.apply(bundle)
+	}
+
+	// the icon is only for the notice, so the bundle is created without it
+	icon, err := ExtractBundleIcon(bundle.File, bundle.BundleInfo)
+	if err != nil {
+		revel.WARN.Printf("failed to extract the icon of %s: %s", bundle.BundleInfo.Identifier, err)
+	}
+
 	// increment revision number & save application information
 	err = Transact(dbm, func(txn gorp.SqlExecutor) error {
-		maxRevision, err := app.GetMaxRevisionByBundleVersion(txn, bundleInfo.Version)
-		if err != nil {
+		if err := app.saveNewBundle(txn, bundle); err != nil {
 			return err
 		}
-		bundle.Revision = maxRevision + 1
-		bundle.FileName = bundle.BuildFileName()
-		return bundle.Save(txn)
+		return app.saveBundleIcon(txn, bundle, icon)
 	})
 	if err != nil {
 		panic(err)
 	}
 
-	// upload file
-	parent := app.ParentReference()
-	driveFile, err := s.InsertFile(bundle.File, bundle.FileName, parent)
+	// upload file unless the same content is already stored
+	location, err := app.versionLocation(dbm, storage, bundleInfo.Version)
+	if err != nil {
+		return err
+	}
+	blob, err := AcquireBlob(dbm, storage, bundle.File, bundle.FileName, bundle.Digest, app.StorageLocation, location)
 	if err != nil {
 		return err
 	}
 
 	// update FileId
-	bundle.FileId = driveFile.Id
+	bundle.FileId = blob.FileId
 	return Transact(dbm, func(txn gorp.SqlExecutor) error {
-		return bundle.Update(txn)
+		if _, err := txn.Exec("UPDATE bundle SET file_id = ? WHERE id = ?", bundle.FileId, bundle.Id); err != nil {
+			return err
+		}
+		return RecordEvent(txn, EventResourceBundle, bundle.Id, app.Id, EventActionCreate)
 	})
 }
 
+// CreateStreamedBundle creates the bundle of the upload streamed to the storage, after the version is read from its head.
+// The digest is known only after the file is stored, so the file is deleted again if the same content is already stored.
+func (app *App) CreateStreamedBundle(dbm *gorp.DbMap, storage StreamStorage, bundle *Bundle, stream *BundleStream) error {
+	bundle.AppId = app.Id
+
+	bundleInfo, err := stream.ReadInfo()
+	if err != nil {
+		return &BundleParseError{Err: err}
+	}
+	if len(bundleInfo.Version) == 0 {
+		return &BundleParseError{Err: fmt.Errorf("the version is not found")}
+	}
+	bundle.BundleInfo = bundleInfo
+	// the digest is not known yet, so a copy of a bundle of the app is counted too
+	bundle.FileSize = stream.Size
+	if err := app.CheckStorageQuota(dbm, bundle.FileSize, ""); err != nil {
+		return err
+	}
+	bundle.normalizeLabels()
+
+	err = Transact(dbm, func(txn gorp.SqlExecutor) error {
+		return app.saveNewBundle(txn, bundle)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the bundle is saved before the file is stored, as the name of the file has its revision, so it is deleted with
+	// the file when the file fails to be stored
+	if err := app.storeStreamedBundle(dbm, storage, bundle, stream); err != nil {
+		deleteErr := TransactDeleting(dbm, storage, nil, func(txn gorp.SqlExecutor, storage Storage) error {
+			return bundle.Delete(txn, storage)
+		})
+		if deleteErr != nil {
+			revel.ERROR.Printf("failed to delete the bundle %d whose file is not stored: %s", bundle.Id, deleteErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// storeStreamedBundle streams the file of the saved bundle to the storage, and points the bundle to it.
+func (app *App) storeStreamedBundle(dbm *gorp.DbMap, storage StreamStorage, bundle *Bundle, stream *BundleStream) error {
+	location, err := app.versionLocation(dbm, storage, bundle.BundleInfo.Version)
+	if err != nil {
+		return err
+	}
+	key, digest, icon, err := stream.store(storage, bundle.FileName, location)
+	if err != nil {
+		return err
+	}
+	blob, err := AdoptBlob(dbm, storage, key, digest, app.StorageLocation, location)
+	if err != nil {
+		return err
+	}
+
+	bundle.Digest = digest
+	bundle.FileId = blob.FileId
+	stream.codeSigning.apply(bundle)
+	if err := app.verifyStoredApkSigner(dbm, storage, bundle); err != nil {
+		return err
+	}
+	return Transact(dbm, func(txn gorp.SqlExecutor) error {
+		if _, err := txn.Exec("UPDATE bundle SET file_id = ?, digest = ?, signing_certificate = ?, signing_scheme = ?, signer_changed_from = ?, signing_identity = ?, signing_team_id = ?, profile_type = ?, profile_expires_at = ?, executable_uuid = ? WHERE id = ?",
+			bundle.FileId, bundle.Digest, bundle.SigningCertificate, bundle.SigningScheme, bundle.SignerChangedFrom, bundle.SigningIdentity, bundle.SigningTeamId, bundle.ProfileType, bundle.ProfileExpiresAt, bundle.ExecutableUuid, bundle.Id); err != nil {
+			return err
+		}
+		if err := app.saveBundleIcon(txn, bundle, icon); err != nil {
+			return err
+		}
+		return RecordEvent(txn, EventResourceBundle, bundle.Id, app.Id, EventActionCreate)
+	})
+}
+
+// saveNewBundle saves the bundle with the next revision of its version.
+func (app *App) saveNewBundle(txn gorp.SqlExecutor, bundle *Bundle) error {
+	maxRevision, err := app.GetMaxRevisionByBundleVersion(txn, bundle.BundleInfo.Version)
+	if err != nil {
+		return err
+	}
+	bundle.Revision = maxRevision + 1
+	if bundle.Codename, err = app.NewCodename(txn); err != nil {
+		return err
+	}
+	bundle.AppBundle = bundle.BundleInfo.AppBundle
+	bundle.FileExtension = bundle.BundleInfo.Extension
+	// the companion apps of tvOS and watchOS are listed apart in the variant of the platform, unless the upload gives one
+	bundle.PlatformSubtype = bundle.BundleInfo.PlatformSubtype
+	if bundle.Variant == "" {
+		bundle.Variant = string(bundle.PlatformSubtype)
+	}
+	// the description given to the upload is kept as it is
+	if bundle.Description == "" && app.DescriptionTemplate != "" {
+		bundle.Description = app.FillDescriptionTemplate(bundle, time.Now())
+	}
+	bundle.FileName = bundle.BuildFileName()
+	if err := bundle.Save(txn); err != nil {
+		return err
+	}
+	return bundle.SaveChangelogs(txn, bundle.Translations)
+}
+
 func (app *App) CreateAuthority(txn gorp.SqlExecutor, s *GoogleService, authority *Authority) error {
 	authority.AppId = app.Id
 
@@ -266,14 +761,22 @@ func (app *App) CreateAuthority(txn gorp.SqlExecutor, s *GoogleService, authorit
 	return authority.Save(txn)
 }
 
-func CreateApp(txn gorp.SqlExecutor, s *GoogleService, app *App) error {
-	driveFolder, err := s.CreateFolder(app.Title)
+// CreateApp creates the app folder in the folder of the storage location, or in the root if storageFolderId is empty.
+func CreateApp(txn gorp.SqlExecutor, s *GoogleService, app *App, storageFolderId string) error {
+	var parent *drive.ParentReference
+	if storageFolderId != "" {
+		parent = &drive.ParentReference{Id: storageFolderId}
+	}
+	driveFolder, err := s.CreateFolderIn(app.Title, parent)
 	if err != nil {
 		return err
 	}
 	app.FileId = driveFolder.Id
 
-	return app.Save(txn)
+	if err := app.Save(txn); err != nil {
+		return err
+	}
+	return RecordEvent(txn, EventResourceApp, app.Id, app.Id, EventActionCreate)
 }
 
 func GetApp(txn gorp.SqlExecutor, id int) (*App, error) {
@@ -292,23 +795,123 @@ func GetAppByApiToken(txn gorp.SqlExecutor, apiToken string) (*App, error) {
 	return &app, nil
 }
 
+// GetAppsByAuthorityEmail returns the apps the email has authority for.
+func GetAppsByAuthorityEmail(txn gorp.SqlExecutor, email string) ([]*App, error) {
+	return SearchAppsByAuthorityEmail(txn, email, "")
+}
+
+// SearchAppsByAuthorityEmail returns the apps the email has authority for whose title contains the query.
+func SearchAppsByAuthorityEmail(txn gorp.SqlExecutor, email string, query string) ([]*App, error) {
+	condition, args := titleCondition("app.title", query)
+	var apps []*App
+	_, err := txn.Select(&apps, "SELECT app.* FROM app INNER JOIN authority ON authority.app_id = app.id WHERE authority.email = ?"+condition+" ORDER BY app.id DESC", append([]interface{}{email}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// GetListedApps returns the listed apps except the ones with the file IDs.
+func GetListedApps(txn gorp.SqlExecutor, excludedFileIds []string) ([]*App, error) {
+	return SearchListedApps(txn, excludedFileIds, "")
+}
+
+// SearchListedApps returns the listed apps except the ones with the file IDs whose title contains the query.
+// The apps are excluded in SQL, so a private app never leaves the database for the callers without access.
+func SearchListedApps(txn gorp.SqlExecutor, excludedFileIds []string, query string) ([]*App, error) {
+	stmt := "SELECT * FROM app WHERE visibility = ?"
+	args := []interface{}{AppVisibilityListed}
+	if len(excludedFileIds) > 0 {
+		in, inArgs := inCondition(excludedFileIds)
+		stmt += " AND file_id NOT IN (" + in + ")"
+		args = append(args, inArgs...)
+	}
+	condition, titleArgs := titleCondition("title", query)
+	args = append(args, titleArgs...)
+
+	apps := []*App{}
+	if _, err := txn.Select(&apps, stmt+condition+" ORDER BY id DESC", args...); err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// GetAllApps returns every app including the private ones, for the admins.
+func GetAllApps(txn gorp.SqlExecutor) ([]*App, error) {
+	var apps []*App
+	_, err := txn.Select(&apps, "SELECT * FROM app ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// GetAppsWithCursor returns the apps above the ID of the cursor, oldest first as GetAllApps, and the ID of the last one
+// for the cursor of the next page, which is 0 on the last page.
+func GetAppsWithCursor(txn gorp.SqlExecutor, after, limit int) ([]*App, int, error) {
+	var apps []*App
+	_, err := txn.Select(&apps, "SELECT * FROM app WHERE id > ? ORDER BY id ASC LIMIT ?", after, limit+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(apps) <= limit {
+		return apps, 0, nil
+	}
+	apps = apps[:limit]
+	return apps, apps[limit-1].Id, nil
+}
+
+// GetMirroredApps returns the apps except the private ones, which are not mirrored.
+func GetMirroredApps(txn gorp.SqlExecutor) ([]*App, error) {
+	var apps []*App
+	_, err := txn.Select(&apps, "SELECT * FROM app WHERE visibility <> ? ORDER BY id ASC", AppVisibilityPrivate)
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
 func GetApps(txn gorp.SqlExecutor, fileIds []string) ([]*App, error) {
+	return SearchApps(txn, fileIds, "")
+}
+
+// SearchApps returns the apps of the file IDs whose title contains the query, or all of them for the empty query.
+func SearchApps(txn gorp.SqlExecutor, fileIds []string, query string) ([]*App, error) {
 	if len(fileIds) <= 0 {
 		return []*App{}, nil
 	}
 
-	args := make([]interface{}, len(fileIds))
-	quarks := make([]string, len(fileIds))
-	for i, fileId := range fileIds {
-		args[i] = fileId
-		quarks[i] = "?"
-	}
+	in, args := inCondition(fileIds)
+	condition, titleArgs := titleCondition("title", query)
+	args = append(args, titleArgs...)
 
 	var apps []*App
-	_, err := txn.Select(&apps, fmt.Sprintf("SELECT * FROM app WHERE file_id in (%s) ORDER BY id DESC", strings.Join(quarks, ",")), args...)
+	_, err := txn.Select(&apps, fmt.Sprintf("SELECT * FROM app WHERE file_id in (%s)%s ORDER BY id DESC", in, condition), args...)
 	if err != nil {
 		return nil, err
 	}
 
 	return apps, nil
 }
+
+// inCondition returns the placeholders of the values for "IN (...)" and the arguments.
+func inCondition(values []string) (string, []interface{}) {
+	args := make([]interface{}, len(values))
+	quarks := make([]string, len(values))
+	for i, value := range values {
+		args[i] = value
+		quarks[i] = "?"
+	}
+	return strings.Join(quarks, ","), args
+}
+
+// titleCondition returns the condition of the column containing the query, or "" for the empty query.
+func titleCondition(column string, query string) (string, []interface{}) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return "", nil
+	}
+	// SQLite has no escape character unless it is declared, and a backslash is quoted differently by MySQL
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(query)
+	return " AND " + column + " LIKE ? ESCAPE '!'", []interface{}{"%" + escaped + "%"}
+}
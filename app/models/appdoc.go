@@ -0,0 +1,100 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// an AppDocRevision is a revision of the documentation of the app in Markdown, e.g. the setup and the test accounts.
+// Every edit adds a revision, so that a bundle can pin the one matching the build.
+type AppDocRevision struct {
+	Id        int       `db:"id"`
+	AppId     int       `db:"app_id"`
+	Revision  int       `db:"revision"`
+	Body      []byte    `db:"body"`
+	Summary   string    `db:"summary"`
+	UserId    int       `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+
+	Author string `db:"-"`
+}
+
+func (doc *AppDocRevision) PreInsert(s gorp.SqlExecutor) error {
+	doc.CreatedAt = time.Now()
+	return nil
+}
+
+// Markdown returns the body as it is edited.
+func (doc *AppDocRevision) Markdown() string {
+	return string(doc.Body)
+}
+
+// Html renders the body on the server, as the documentation may have the test accounts.
+func (doc *AppDocRevision) Html() string {
+	return RenderMarkdownOnServer(doc.Markdown())
+}
+
+// DocRevisions returns the revisions of the documentation, the latest first, with the emails of the authors.
+func (app *App) DocRevisions(txn gorp.SqlExecutor) ([]*AppDocRevision, error) {
+	var docs []*AppDocRevision
+	_, err := txn.Select(&docs, "SELECT * FROM app_doc_revision WHERE app_id = ? ORDER BY revision DESC", app.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		// the authors erased on request are anonymized to 0
+		if doc.UserId == 0 {
+			continue
+		}
+		user, err := GetUser(txn, doc.UserId)
+		if err != nil {
+			return nil, err
+		}
+		doc.Author = user.Email
+	}
+	return docs, nil
+}
+
+// DocRevision returns the revision of the documentation, or the latest one for 0, or nil if there is none.
+func (app *App) DocRevision(txn gorp.SqlExecutor, revision int) (*AppDocRevision, error) {
+	var doc AppDocRevision
+	var err error
+	if revision == 0 {
+		err = txn.SelectOne(&doc, "SELECT * FROM app_doc_revision WHERE app_id = ? ORDER BY revision DESC LIMIT 1", app.Id)
+	} else {
+		err = txn.SelectOne(&doc, "SELECT * FROM app_doc_revision WHERE app_id = ? AND revision = ?", app.Id, revision)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// CreateDocRevision adds the edit as the next revision.
+func (app *App) CreateDocRevision(txn gorp.SqlExecutor, body, summary string, userId int) (*AppDocRevision, error) {
+	maxRevision, err := txn.SelectInt("SELECT COALESCE(MAX(revision), 0) FROM app_doc_revision WHERE app_id = ?", app.Id)
+	if err != nil {
+		return nil, err
+	}
+	doc := &AppDocRevision{
+		AppId:    app.Id,
+		Revision: int(maxRevision) + 1,
+		Body:     []byte(body),
+		Summary:  summary,
+		UserId:   userId,
+	}
+	if err := txn.Insert(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (app *App) DeleteDocRevisions(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM app_doc_revision WHERE app_id = ?", app.Id)
+	return err
+}
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// AppMetricsDays is how far back the bundles are measured from
+const AppMetricsDays = 90
+
+// AppMetrics tell whether the distribution works, from the audits of the uploads and the downloads.
+// A download stands for an install, as the installs on the devices are not known to the server.
+type AppMetrics struct {
+	// the median time from the upload of a bundle to the first download by someone other than the uploader
+	MedianTimeToFirstInstall time.Duration
+	BundlesMeasured          int
+	BundlesNotInstalled      int
+	Adoptions                []*PlatformAdoption
+}
+
+// a PlatformAdoption is how many of the members have downloaded the latest bundle of the platform
+type PlatformAdoption struct {
+	PlatformType BundlePlatformType
+	Bundle       *Bundle
+	Members      int
+	Installed    int
+}
+
+type AppMetricsJsonResponse struct {
+	MedianSecondsToFirstInstall int64                           `json:"median_seconds_to_first_install"`
+	BundlesMeasured             int                             `json:"bundles_measured"`
+	BundlesNotInstalled         int                             `json:"bundles_not_installed"`
+	Adoptions                   []*PlatformAdoptionJsonResponse `json:"adoptions"`
+}
+
+type PlatformAdoptionJsonResponse struct {
+	PlatformType string  `json:"platform_type"`
+	Version      string  `json:"version"`
+	Revision     int     `json:"revision"`
+	Members      int     `json:"members"`
+	Installed    int     `json:"installed"`
+	Percentage   float64 `json:"percentage"`
+}
+
+func (adoption *PlatformAdoption) Percentage() float64 {
+	if adoption.Members == 0 {
+		return 0
+	}
+	return float64(adoption.Installed) / float64(adoption.Members) * 100
+}
+
+func (metrics *AppMetrics) MedianHoursToFirstInstall() float64 {
+	return metrics.MedianTimeToFirstInstall.Hours()
+}
+
+func (metrics *AppMetrics) JsonResponse() *AppMetricsJsonResponse {
+	adoptions := []*PlatformAdoptionJsonResponse{}
+	for _, adoption := range metrics.Adoptions {
+		adoptions = append(adoptions, &PlatformAdoptionJsonResponse{
+			PlatformType: adoption.PlatformType.String(),
+			Version:      adoption.Bundle.BundleVersion,
+			Revision:     adoption.Bundle.Revision,
+			Members:      adoption.Members,
+			Installed:    adoption.Installed,
+			Percentage:   adoption.Percentage(),
+		})
+	}
+	return &AppMetricsJsonResponse{
+		MedianSecondsToFirstInstall: int64(metrics.MedianTimeToFirstInstall / time.Second),
+		BundlesMeasured:             metrics.BundlesMeasured,
+		BundlesNotInstalled:         metrics.BundlesNotInstalled,
+		Adoptions:                   adoptions,
+	}
+}
+
+// Metrics measures the bundles uploaded since the time, and the latest bundle of each platform.
+func (app *App) Metrics(txn gorp.SqlExecutor, since time.Time) (*AppMetrics, error) {
+	metrics := &AppMetrics{}
+
+	var bundles []*Bundle
+	_, err := txn.Select(&bundles, "SELECT * FROM bundle WHERE app_id = ? AND created_at >= ? ORDER BY id ASC", app.Id, since)
+	if err != nil {
+		return nil, err
+	}
+	firstInstalls, err := app.firstInstalls(txn, since)
+	if err != nil {
+		return nil, err
+	}
+	var durations []time.Duration
+	for _, bundle := range bundles {
+		installedAt, ok := firstInstalls[bundle.Id]
+		if !ok {
+			metrics.BundlesNotInstalled++
+			continue
+		}
+		d := installedAt.Sub(bundle.CreatedAt)
+		if d < 0 {
+			d = 0
+		}
+		durations = append(durations, d)
+	}
+	metrics.BundlesMeasured = len(durations)
+	metrics.MedianTimeToFirstInstall = medianDuration(durations)
+
+	authorities, err := app.Authorities(txn)
+	if err != nil {
+		return nil, err
+	}
+	for _, platformType := range []BundlePlatformType{BundlePlatformTypeAndroid, BundlePlatformTypeIOS} {
+		bundle, err := app.LatestBundle(txn, platformType, "")
+		if err != nil {
+			return nil, err
+		}
+		if bundle == nil {
+			continue
+		}
+		emails, err := bundle.downloaderEmails(txn)
+		if err != nil {
+			return nil, err
+		}
+		adoption := &PlatformAdoption{
+			PlatformType: platformType,
+			Bundle:       bundle,
+			Members:      len(authorities),
+		}
+		for _, authority := range authorities {
+			if emails[authority.Email] {
+				adoption.Installed++
+			}
+		}
+		metrics.Adoptions = append(metrics.Adoptions, adoption)
+	}
+
+	return metrics, nil
+}
+
+type bundleUploader struct {
+	BundleId int `db:"bundle_id"`
+	UserId   int `db:"user_id"`
+}
+
+// firstInstalls returns the time of the first download of each bundle uploaded since the time by someone other than
+// the uploader, reading the audits of all the bundles at once. The downloads through the mirrors, which have no user,
+// count unless the uploader is not known either.
+func (app *App) firstInstalls(txn gorp.SqlExecutor, since time.Time) (map[int]time.Time, error) {
+	var uploaders []*bundleUploader
+	_, err := txn.Select(&uploaders, `SELECT audit.resource_id AS bundle_id, MAX(audit.user_id) AS user_id
+		FROM audit INNER JOIN bundle ON bundle.id = audit.resource_id
+		WHERE audit.resource = ? AND audit.action = ? AND bundle.app_id = ? AND bundle.created_at >= ?
+		GROUP BY audit.resource_id`,
+		ResourceBundle, ActionCreate, app.Id, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	uploaderIds := map[int]int{}
+	for _, uploader := range uploaders {
+		uploaderIds[uploader.BundleId] = uploader.UserId
+	}
+
+	// the first download of each bundle by each user
+	var downloads []*Audit
+	_, err = txn.Select(&downloads, `SELECT * FROM audit WHERE id IN (
+		SELECT MIN(audit.id) FROM audit INNER JOIN bundle ON bundle.id = audit.resource_id
+		WHERE audit.resource = ? AND audit.action = ? AND bundle.app_id = ? AND bundle.created_at >= ?
+		GROUP BY audit.resource_id, audit.user_id
+	)`,
+		ResourceBundle, ActionDownload, app.Id, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	firstInstalls := map[int]time.Time{}
+	for _, download := range downloads {
+		if download.UserId == uploaderIds[download.ResourceId] {
+			continue
+		}
+		if installedAt, ok := firstInstalls[download.ResourceId]; !ok || download.CreatedAt.Before(installedAt) {
+			firstInstalls[download.ResourceId] = download.CreatedAt
+		}
+	}
+	return firstInstalls, nil
+}
+
+func (bundle *Bundle) downloaderEmails(txn gorp.SqlExecutor) (map[string]bool, error) {
+	var users []*User
+	_, err := txn.Select(&users, `SELECT DISTINCT user.* FROM user INNER JOIN audit ON audit.user_id = user.id
+		WHERE audit.resource = ? AND audit.action = ? AND audit.resource_id = ?`,
+		ResourceBundle, ActionDownload, bundle.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	emails := map[string]bool{}
+	for _, user := range users {
+		emails[user.Email] = true
+	}
+	return emails, nil
+}
+
+func medianDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, durations...)
+	sort.Sort(durationSlice(sorted))
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+type durationSlice []time.Duration
+
+func (s durationSlice) Len() int           { return len(s) }
+func (s durationSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s durationSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
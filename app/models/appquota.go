@@ -0,0 +1,109 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/coopernurse/gorp"
+)
+
+// an AppStorageUsage is the size of the files of the app against its quota, the bundle files with their universal APKs,
+// splits, dSYMs and mappings. The bundles sharing a file are counted once, and the archived ones are not counted.
+type AppStorageUsage struct {
+	UsedBytes  int64
+	QuotaBytes int64
+}
+
+type AppStorageUsageJsonResponse struct {
+	UsedBytes  int64   `json:"used_bytes"`
+	QuotaBytes int64   `json:"quota_bytes"`
+	Percentage float64 `json:"percentage"`
+}
+
+// an AppQuotaError is the upload which would exceed the storage quota of the app
+type AppQuotaError struct {
+	Usage *AppStorageUsage
+	Size  int64
+}
+
+func (e *AppQuotaError) Error() string {
+	return fmt.Sprintf("the file of %d bytes exceeds the storage quota of the app, %d of %d bytes used", e.Size, e.Usage.UsedBytes, e.Usage.QuotaBytes)
+}
+
+// Limited tells whether the app has a quota. 0 is unlimited.
+func (usage *AppStorageUsage) Limited() bool {
+	return usage.QuotaBytes > 0
+}
+
+func (usage *AppStorageUsage) Percentage() float64 {
+	if usage.QuotaBytes == 0 {
+		return 0
+	}
+	return float64(usage.UsedBytes) / float64(usage.QuotaBytes) * 100
+}
+
+func (usage *AppStorageUsage) UsedMb() float64 {
+	return float64(usage.UsedBytes) / 1024 / 1024
+}
+
+func (usage *AppStorageUsage) JsonResponse() *AppStorageUsageJsonResponse {
+	return &AppStorageUsageJsonResponse{
+		UsedBytes:  usage.UsedBytes,
+		QuotaBytes: usage.QuotaBytes,
+		Percentage: usage.Percentage(),
+	}
+}
+
+// StorageUsage sums the sizes of the files of the bundles. The bundles uploaded before the sizes were recorded are 0,
+// and the ones before the digests were recorded are counted each, as they do not share the files.
+func (app *App) StorageUsage(txn gorp.SqlExecutor) (*AppStorageUsage, error) {
+	used, err := txn.SelectInt(`SELECT
+		(SELECT COALESCE(SUM(file_size), 0) FROM bundle WHERE app_id = ? AND archive_state = '' AND (digest = '' OR id IN (
+			SELECT MIN(id) FROM bundle WHERE app_id = ? AND archive_state = '' AND digest <> '' GROUP BY digest
+		)))
+		+ (SELECT COALESCE(SUM(universal_apk_file_size), 0) FROM bundle WHERE app_id = ?)
+		+ (SELECT COALESCE(SUM(bundle_split.file_size), 0) FROM bundle_split INNER JOIN bundle ON bundle.id = bundle_split.bundle_id WHERE bundle.app_id = ?)
+		+ (SELECT COALESCE(SUM(bundle_dsym.file_size), 0) FROM bundle_dsym INNER JOIN bundle ON bundle.id = bundle_dsym.bundle_id WHERE bundle.app_id = ?)
+		+ (SELECT COALESCE(SUM(bundle_mapping.file_size), 0) FROM bundle_mapping INNER JOIN bundle ON bundle.id = bundle_mapping.bundle_id WHERE bundle.app_id = ?)`,
+		app.Id, app.Id, app.Id, app.Id, app.Id, app.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &AppStorageUsage{
+		UsedBytes:  used,
+		QuotaBytes: int64(app.StorageQuotaMb) * 1024 * 1024,
+	}, nil
+}
+
+// CheckStorageQuota refuses the file of the size if the usage would exceed the quota.
+// The file with the digest of a bundle of the app is shared with it, so it is not counted again.
+func (app *App) CheckStorageQuota(txn gorp.SqlExecutor, size int64, digest string) error {
+	if app.StorageQuotaMb == 0 {
+		return nil
+	}
+	if digest != "" {
+		count, err := txn.SelectInt("SELECT COUNT(id) FROM bundle WHERE app_id = ? AND digest = ? AND archive_state = ''", app.Id, digest)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+	}
+	usage, err := app.StorageUsage(txn)
+	if err != nil {
+		return err
+	}
+	if usage.UsedBytes+size > usage.QuotaBytes {
+		return &AppQuotaError{Usage: usage, Size: size}
+	}
+	return nil
+}
+
+// UpdateStorageQuota sets the quota in megabytes, which only the admins change.
+func (app *App) UpdateStorageQuota(txn gorp.SqlExecutor, quotaMb int) error {
+	if _, err := txn.Exec("UPDATE app SET storage_quota_mb = ? WHERE id = ?", quotaMb, app.Id); err != nil {
+		return err
+	}
+	app.StorageQuotaMb = quotaMb
+	return nil
+}
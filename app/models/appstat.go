@@ -0,0 +1,178 @@
+package models
+
+import (
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	AppStatPeriodDaily  = "daily"
+	AppStatPeriodWeekly = "weekly"
+)
+
+// an AppStat is the precomputed number of the downloads and the uploads of an app in a day or a week from Monday
+type AppStat struct {
+	Id        int       `db:"id"`
+	AppId     int       `db:"app_id"`
+	Period    string    `db:"period"`
+	Date      string    `db:"date"`
+	Downloads int       `db:"downloads"`
+	Uploads   int       `db:"uploads"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+type AppStatJsonResponse struct {
+	Date      string `json:"date"`
+	Downloads int    `json:"downloads"`
+	Uploads   int    `json:"uploads"`
+}
+
+func (stat *AppStat) PreInsert(s gorp.SqlExecutor) error {
+	stat.CreatedAt = time.Now()
+	stat.UpdatedAt = stat.CreatedAt
+	return nil
+}
+
+func (stat *AppStat) PreUpdate(s gorp.SqlExecutor) error {
+	stat.UpdatedAt = time.Now()
+	return nil
+}
+
+func (stat *AppStat) JsonResponse() *AppStatJsonResponse {
+	return &AppStatJsonResponse{
+		Date:      stat.Date,
+		Downloads: stat.Downloads,
+		Uploads:   stat.Uploads,
+	}
+}
+
+func IsValidAppStatPeriod(period string) bool {
+	return period == AppStatPeriodDaily || period == AppStatPeriodWeekly
+}
+
+type appStatKey struct {
+	AppId  int
+	Period string
+	Date   string
+}
+
+type appStatCount struct {
+	Downloads int
+	Uploads   int
+}
+
+// RefreshAppStats recomputes the stats of the days since the time, and of the weeks including them, from the audits
+// and the rollups of the actions reported as counts. The older stats are kept as they are, since the audits they were
+// computed from may have been purged.
+func RefreshAppStats(dbm *gorp.DbMap, since time.Time) error {
+	since = startOfDay(startOfWeek(since))
+
+	var audits []*Audit
+	_, err := dbm.Select(
+		&audits,
+		"SELECT * FROM audit WHERE resource = ? AND action IN (?, ?) AND created_at >= ?",
+		ResourceBundle, ActionDownload, ActionCreate, since,
+	)
+	if err != nil {
+		return err
+	}
+	// the audits are kept for months, so the rollups of the days are only the counts reported, not the audits purged
+	var rollups []*AuditRollup
+	_, err = dbm.Select(
+		&rollups,
+		"SELECT * FROM audit_rollup WHERE resource = ? AND action IN (?, ?) AND date >= ?",
+		ResourceBundle, ActionDownload, ActionCreate, since.Format(AuditRollupDateFormat),
+	)
+	if err != nil {
+		return err
+	}
+
+	var bundles []*Bundle
+	if _, err := dbm.Select(&bundles, "SELECT id, app_id FROM bundle"); err != nil {
+		return err
+	}
+	appIds := map[int]int{}
+	for _, bundle := range bundles {
+		appIds[bundle.Id] = bundle.AppId
+	}
+
+	counts := map[appStatKey]*appStatCount{}
+	add := func(key appStatKey, action, n int) {
+		count, ok := counts[key]
+		if !ok {
+			count = &appStatCount{}
+			counts[key] = count
+		}
+		if action == ActionDownload {
+			count.Downloads += n
+		} else {
+			count.Uploads += n
+		}
+	}
+	for _, audit := range audits {
+		// the audits of the deleted bundles cannot be told which app they belong to
+		appId, ok := appIds[audit.ResourceId]
+		if !ok {
+			continue
+		}
+		add(appStatKey{appId, AppStatPeriodDaily, audit.CreatedAt.Format(AuditRollupDateFormat)}, audit.Action, 1)
+		add(appStatKey{appId, AppStatPeriodWeekly, startOfWeek(audit.CreatedAt).Format(AuditRollupDateFormat)}, audit.Action, 1)
+	}
+	for _, rollup := range rollups {
+		appId, ok := appIds[rollup.ResourceId]
+		if !ok {
+			continue
+		}
+		date, err := time.ParseInLocation(AuditRollupDateFormat, rollup.Date, time.Local)
+		if err != nil {
+			return err
+		}
+		add(appStatKey{appId, AppStatPeriodDaily, rollup.Date}, rollup.Action, rollup.Count)
+		add(appStatKey{appId, AppStatPeriodWeekly, startOfWeek(date).Format(AuditRollupDateFormat)}, rollup.Action, rollup.Count)
+	}
+
+	return Transact(dbm, func(txn gorp.SqlExecutor) error {
+		if _, err := txn.Exec("DELETE FROM app_stat WHERE date >= ?", since.Format(AuditRollupDateFormat)); err != nil {
+			return err
+		}
+		for key, count := range counts {
+			stat := &AppStat{
+				AppId:     key.AppId,
+				Period:    key.Period,
+				Date:      key.Date,
+				Downloads: count.Downloads,
+				Uploads:   count.Uploads,
+			}
+			if err := txn.Insert(stat); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Stats returns the stats of the period, newest first.
+func (app *App) Stats(txn gorp.SqlExecutor, period string, limit int) ([]*AppStat, error) {
+	var stats []*AppStat
+	_, err := txn.Select(&stats, "SELECT * FROM app_stat WHERE app_id = ? AND period = ? ORDER BY date DESC LIMIT ?", app.Id, period, limit)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (app *App) DeleteStats(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM app_stat WHERE app_id = ?", app.Id)
+	return err
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return startOfDay(t).AddDate(0, 0, -offset)
+}
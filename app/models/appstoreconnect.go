@@ -0,0 +1,398 @@
+package models
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+const (
+	AppStoreConnectBaseUrl       = "https://api.appstoreconnect.apple.com"
+	AppStoreConnectAudience      = "appstoreconnect-v1"
+	AppStoreConnectTokenDuration = 15 * time.Minute
+)
+
+// an AppStoreConnectKey is an App Store Connect API key of an app
+// https://developer.apple.com/documentation/appstoreconnectapi/creating_api_keys_for_app_store_connect_api
+type AppStoreConnectKey struct {
+	Id         int       `db:"id"`
+	AppId      int       `db:"app_id"`
+	IssuerId   string    `db:"issuer_id"`
+	KeyId      string    `db:"key_id"`
+	PrivateKey string    `db:"private_key"`
+	AppleAppId string    `db:"apple_app_id"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+func (key *AppStoreConnectKey) PreInsert(s gorp.SqlExecutor) error {
+	key.CreatedAt = time.Now()
+	key.UpdatedAt = key.CreatedAt
+	return nil
+}
+
+func (key *AppStoreConnectKey) PreUpdate(s gorp.SqlExecutor) error {
+	key.UpdatedAt = time.Now()
+	return nil
+}
+
+func (key *AppStoreConnectKey) Validate(v *revel.Validation) {
+	v.Required(key.IssuerId).Message("Issuer ID is required.")
+	v.Required(key.KeyId).Message("Key ID is required.")
+	v.Required(key.PrivateKey).Message("Private key is required.")
+	v.Required(key.AppleAppId).Message("Apple ID of the app is required.")
+}
+
+// Save inserts the key, or replaces the key of the app if already exists.
+func (key *AppStoreConnectKey) Save(txn gorp.SqlExecutor) error {
+	current, err := GetAppStoreConnectKey(txn, key.AppId)
+	if err == sql.ErrNoRows {
+		return txn.Insert(key)
+	}
+	if err != nil {
+		return err
+	}
+
+	current.IssuerId = key.IssuerId
+	current.KeyId = key.KeyId
+	current.PrivateKey = key.PrivateKey
+	current.AppleAppId = key.AppleAppId
+
+	_, err = txn.Update(current)
+	return err
+}
+
+func (key *AppStoreConnectKey) DeleteFromDB(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(key)
+	return err
+}
+
+// Seal encrypts the private key to save it.
+func (key *AppStoreConnectKey) Seal(secret []byte) error {
+	sealed, err := SealCredential(secret, key.AppId, "app_store_connect_key.private_key", key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	key.PrivateKey = sealed
+	return nil
+}
+
+// Open decrypts the private key saved, to sign the tokens with.
+func (key *AppStoreConnectKey) Open(secret []byte) error {
+	opened, err := OpenCredential(secret, key.AppId, "app_store_connect_key.private_key", key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	key.PrivateKey = opened
+	return nil
+}
+
+// Token returns a JSON Web Token signed with the key.
+// https://developer.apple.com/documentation/appstoreconnectapi/generating_tokens_for_api_requests
+func (key *AppStoreConnectKey) Token() (string, error) {
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return "", errors.New("app store connect: private key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	privateKey, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return "", errors.New("app store connect: private key is not an ECDSA key")
+	}
+
+	header, err := json.Marshal(map[string]string{
+		"alg": "ES256",
+		"kid": key.KeyId,
+		"typ": "JWT",
+	})
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": key.IssuerId,
+		"iat": now.Unix(),
+		"exp": now.Add(AppStoreConnectTokenDuration).Unix(),
+		"aud": AppStoreConnectAudience,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest[:])
+	if err != nil {
+		return "", err
+	}
+	signature := append(paddedBytes(r, 32), paddedBytes(s, 32)...)
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func paddedBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func GetAppStoreConnectKey(txn gorp.SqlExecutor, appId int) (*AppStoreConnectKey, error) {
+	var key AppStoreConnectKey
+	if err := txn.SelectOne(&key, "SELECT * FROM app_store_connect_key WHERE app_id = ?", appId); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// SealAppStoreConnectKeys seals the private keys saved before they were sealed, and returns how many it sealed.
+func SealAppStoreConnectKeys(txn gorp.SqlExecutor, secret []byte) (int, error) {
+	var keys []*AppStoreConnectKey
+	if _, err := txn.Select(&keys, "SELECT * FROM app_store_connect_key WHERE private_key NOT LIKE ?", sealedCredentialPrefix+"%"); err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if err := key.Seal(secret); err != nil {
+			return 0, err
+		}
+		if _, err := txn.Update(key); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// ----------------------------------------------------------------------
+// AppStoreConnect
+// https://developer.apple.com/documentation/appstoreconnectapi
+type AppStoreConnect struct {
+	Key     *AppStoreConnectKey
+	BaseUrl string
+	Client  *http.Client
+}
+
+type AppStoreConnectError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *AppStoreConnectError) Error() string {
+	return fmt.Sprintf("app store connect: got HTTP response code %d: %s", e.StatusCode, e.Body)
+}
+
+type ascUploadOperation struct {
+	Method         string `json:"method"`
+	Url            string `json:"url"`
+	Length         int64  `json:"length"`
+	Offset         int64  `json:"offset"`
+	RequestHeaders []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"requestHeaders"`
+}
+
+type ascResource struct {
+	Data struct {
+		Id         string `json:"id"`
+		Attributes struct {
+			UploadOperations []*ascUploadOperation `json:"uploadOperations"`
+			State            *struct {
+				State  string `json:"state"`
+				Errors []struct {
+					Code        string `json:"code"`
+					Description string `json:"description"`
+				} `json:"errors"`
+			} `json:"state"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+func NewAppStoreConnect(key *AppStoreConnectKey) *AppStoreConnect {
+	return &AppStoreConnect{
+		Key:     key,
+		BaseUrl: AppStoreConnectBaseUrl,
+		Client:  &http.Client{},
+	}
+}
+
+// ascPlatform is the platform of the build upload. The apps of watchOS are uploaded as the ones of iOS.
+func ascPlatform(subtype BundlePlatformSubtype) string {
+	if subtype == BundlePlatformSubtypeTVOS {
+		return "TV_OS"
+	}
+	return "IOS"
+}
+
+// UploadBuild uploads the ipa file as a new build of the app and returns the ID of the build upload.
+func (asc *AppStoreConnect) UploadBuild(file *os.File, filename string, bundleInfo *BundleInfo) (string, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	buildUpload := &ascResource{}
+	err = asc.do("POST", "/v1/buildUploads", map[string]interface{}{
+		"data": map[string]interface{}{
+			"type": "buildUploads",
+			"attributes": map[string]string{
+				"cfBundleShortVersionString": bundleInfo.ShortVersion,
+				"cfBundleVersion":            bundleInfo.Version,
+				"platform":                   ascPlatform(bundleInfo.PlatformSubtype),
+			},
+			"relationships": map[string]interface{}{
+				"app": map[string]interface{}{
+					"data": map[string]string{"type": "apps", "id": asc.Key.AppleAppId},
+				},
+			},
+		},
+	}, buildUpload)
+	if err != nil {
+		return "", err
+	}
+
+	uploadFile := &ascResource{}
+	err = asc.do("POST", "/v1/buildUploadFiles", map[string]interface{}{
+		"data": map[string]interface{}{
+			"type": "buildUploadFiles",
+			"attributes": map[string]interface{}{
+				"assetType": "ASSET",
+				"fileName":  filename,
+				"fileSize":  stat.Size(),
+				"uti":       "com.apple.ipa",
+			},
+			"relationships": map[string]interface{}{
+				"buildUpload": map[string]interface{}{
+					"data": map[string]string{"type": "buildUploads", "id": buildUpload.Data.Id},
+				},
+			},
+		},
+	}, uploadFile)
+	if err != nil {
+		return "", err
+	}
+
+	for _, operation := range uploadFile.Data.Attributes.UploadOperations {
+		if err := asc.uploadPart(file, operation); err != nil {
+			return "", err
+		}
+	}
+
+	err = asc.do("PATCH", "/v1/buildUploadFiles/"+uploadFile.Data.Id, map[string]interface{}{
+		"data": map[string]interface{}{
+			"type":       "buildUploadFiles",
+			"id":         uploadFile.Data.Id,
+			"attributes": map[string]bool{"uploaded": true},
+		},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return buildUpload.Data.Id, nil
+}
+
+// BuildUploadState returns the processing state of the build upload and the error messages if failed.
+func (asc *AppStoreConnect) BuildUploadState(buildUploadId string) (string, string, error) {
+	buildUpload := &ascResource{}
+	if err := asc.do("GET", "/v1/buildUploads/"+buildUploadId, nil, buildUpload); err != nil {
+		return "", "", err
+	}
+
+	state := buildUpload.Data.Attributes.State
+	if state == nil {
+		return "", "", nil
+	}
+	var messages []string
+	for _, e := range state.Errors {
+		messages = append(messages, e.Description)
+	}
+	return state.State, strings.Join(messages, "\n"), nil
+}
+
+func (asc *AppStoreConnect) uploadPart(file *os.File, operation *ascUploadOperation) error {
+	req, err := http.NewRequest(operation.Method, operation.Url, io.NewSectionReader(file, operation.Offset, operation.Length))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = operation.Length
+	for _, header := range operation.RequestHeaders {
+		req.Header.Set(header.Name, header.Value)
+	}
+
+	resp, err := asc.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return &AppStoreConnectError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	return nil
+}
+
+func (asc *AppStoreConnect) do(method, path string, body interface{}, v interface{}) error {
+	token, err := asc.Key.Token()
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, asc.BaseUrl+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := asc.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return &AppStoreConnectError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if v == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,372 @@
+package models
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"code.google.com/p/google-api-go-client/drive/v2"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	ArchiveStateArchived  = "archived"
+	ArchiveStateRestoring = "restoring"
+)
+
+var ErrBundleArchived = errors.New("The bundle is archived. Download it to restore, and retry once it is restored.")
+
+// a BundleArchive keeps the files of the old bundles out of the Google Drive until they are downloaded again.
+type BundleArchive interface {
+	// Archive moves the file of the blob to the archive and returns the key to restore it
+	Archive(s *GoogleService, blob *Blob) (string, error)
+	// RequestRestore starts restoring the file and tells whether it can be restored now
+	RequestRestore(key string) (bool, error)
+	// Restore puts the file back to the parent folder and returns the ID of the file
+	Restore(s *GoogleService, key, filename string, parent *drive.ParentReference) (string, error)
+	// Delete deletes the file of the blob deleted from the archive. A missing file is not an error
+	Delete(key string) error
+}
+
+// DriveColdArchive moves the files to a folder, e.g. on a shared drive with the cheaper storage.
+// The files can be restored at once.
+type DriveColdArchive struct {
+	FolderId string
+}
+
+func (a *DriveColdArchive) Archive(s *GoogleService, blob *Blob) (string, error) {
+	if err := s.MoveFile(blob.FileId, a.FolderId); err != nil {
+		return "", err
+	}
+	return blob.FileId, nil
+}
+
+func (a *DriveColdArchive) RequestRestore(key string) (bool, error) {
+	return true, nil
+}
+
+func (a *DriveColdArchive) Restore(s *GoogleService, key, filename string, parent *drive.ParentReference) (string, error) {
+	if err := s.MoveFile(key, parent.Id); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Delete does nothing, as the key is the ID of the file, which is deleted with the blob.
+func (a *DriveColdArchive) Delete(key string) error {
+	return nil
+}
+
+// GlacierArchive puts the files to S3 in the Glacier storage class and deletes them from the Google Drive.
+// The files take hours to restore, and then stay readable on S3 for RestoreDays.
+type GlacierArchive struct {
+	Bucket          string
+	Region          string
+	Prefix          string
+	AccessKeyId     string
+	SecretAccessKey string
+	StorageClass    string
+	RestoreDays     int
+	Endpoint        string
+	Client          *http.Client
+}
+
+func NewGlacierArchive(bucket, region, prefix, accessKeyId, secretAccessKey, storageClass string, restoreDays int) *GlacierArchive {
+	return &GlacierArchive{
+		Bucket:          bucket,
+		Region:          region,
+		Prefix:          strings.Trim(prefix, "/"),
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secretAccessKey,
+		StorageClass:    storageClass,
+		RestoreDays:     restoreDays,
+		Endpoint:        fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region),
+		Client:          http.DefaultClient,
+	}
+}
+
+func (a *GlacierArchive) Archive(s *GoogleService, blob *Blob) (string, error) {
+	resp, file, err := s.DownloadFile(blob.FileId)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	key := fmt.Sprintf("%s/%s", blob.StorageLocation, blob.Digest)
+	if blob.StorageLocation == "" {
+		key = blob.Digest
+	}
+	if a.Prefix != "" {
+		key = a.Prefix + "/" + key
+	}
+
+	req, err := http.NewRequest("PUT", a.Endpoint+"/"+key, resp.Body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = file.FileSize
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Amz-Storage-Class", a.StorageClass)
+	a.sign(req, "UNSIGNED-PAYLOAD")
+	if _, err := a.do(req); err != nil {
+		return "", err
+	}
+
+	if err := blob.DeleteFromStorage(NewDriveStorage(s)); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// RequestRestore tells from the x-amz-restore header whether the restore is done,
+// and starts it unless it is in progress.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_RestoreObject.html
+func (a *GlacierArchive) RequestRestore(key string) (bool, error) {
+	req, err := http.NewRequest("HEAD", a.Endpoint+"/"+key, nil)
+	if err != nil {
+		return false, err
+	}
+	a.sign(req, sha256Hex(nil))
+	resp, err := a.do(req)
+	if err != nil {
+		return false, err
+	}
+	restore := resp.Header.Get("X-Amz-Restore")
+	if strings.Contains(restore, `ongoing-request="false"`) {
+		return true, nil
+	}
+	if strings.Contains(restore, `ongoing-request="true"`) {
+		return false, nil
+	}
+
+	body := []byte(fmt.Sprintf("<RestoreRequest><Days>%d</Days><GlacierJobParameters><Tier>Standard</Tier></GlacierJobParameters></RestoreRequest>", a.RestoreDays))
+	req, err = http.NewRequest("POST", a.Endpoint+"/"+key+"?restore", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	a.sign(req, sha256Hex(body))
+	if _, err := a.do(req); err != nil {
+		// RestoreAlreadyInProgress
+		if archiveErr, ok := err.(*ArchiveError); ok && archiveErr.StatusCode == http.StatusConflict {
+			return false, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+func (a *GlacierArchive) Restore(s *GoogleService, key, filename string, parent *drive.ParentReference) (string, error) {
+	req, err := http.NewRequest("GET", a.Endpoint+"/"+key, nil)
+	if err != nil {
+		return "", err
+	}
+	a.sign(req, sha256Hex(nil))
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", &ArchiveError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	// the Drive API uploads from a file
+	tmp, err := ioutil.TempFile("", "alphawing-restore")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		return "", err
+	}
+	if _, err := tmp.Seek(0, os.SEEK_SET); err != nil {
+		return "", err
+	}
+
+	driveFile, err := s.InsertFile(tmp, filename, parent)
+	if err != nil {
+		return "", err
+	}
+	return driveFile.Id, nil
+}
+
+// Delete deletes the object, which S3 responds 204 to even when it is missing.
+func (a *GlacierArchive) Delete(key string) error {
+	req, err := http.NewRequest("DELETE", a.Endpoint+"/"+key, nil)
+	if err != nil {
+		return err
+	}
+	a.sign(req, sha256Hex(nil))
+	_, err = a.do(req)
+	return err
+}
+
+func (a *GlacierArchive) sign(req *http.Request, payloadHash string) {
+	signAwsRequest(req, payloadHash, a.Region, a.AccessKeyId, a.SecretAccessKey, time.Now().UTC())
+}
+
+func (a *GlacierArchive) do(req *http.Request) (*http.Response, error) {
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, &ArchiveError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+type ArchiveError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ArchiveError) Error() string {
+	return fmt.Sprintf("archive responded %d: %s", e.StatusCode, e.Body)
+}
+
+func (bundle *Bundle) IsArchived() bool {
+	return bundle.ArchiveState != ""
+}
+
+func (bundle *Bundle) IsRestoring() bool {
+	return bundle.ArchiveState == ArchiveStateRestoring
+}
+
+// GetArchivableBlobs returns the blobs which no bundle has been uploaded with since the time.
+func GetArchivableBlobs(txn gorp.SqlExecutor, before time.Time) ([]*Blob, error) {
+	var blobs []*Blob
+	_, err := txn.Select(&blobs, `SELECT bundle_blob.* FROM bundle_blob
+		WHERE bundle_blob.archive_state = '' AND bundle_blob.created_at < ?
+		AND NOT EXISTS (
+			SELECT bundle.id FROM bundle INNER JOIN app ON app.id = bundle.app_id
+			WHERE bundle.digest = bundle_blob.digest AND app.storage_location = bundle_blob.storage_location AND bundle.created_at >= ?
+		)`, before, before)
+	return blobs, err
+}
+
+func GetRestoringBlobs(txn gorp.SqlExecutor) ([]*Blob, error) {
+	var blobs []*Blob
+	_, err := txn.Select(&blobs, "SELECT * FROM bundle_blob WHERE archive_state = ?", ArchiveStateRestoring)
+	return blobs, err
+}
+
+// Bundles returns the bundles sharing the blob.
+func (blob *Blob) Bundles(txn gorp.SqlExecutor) ([]*Bundle, error) {
+	var bundles []*Bundle
+	_, err := txn.Select(&bundles, `SELECT bundle.* FROM bundle INNER JOIN app ON app.id = bundle.app_id
+		WHERE bundle.digest = ? AND app.storage_location = ? ORDER BY bundle.id`, blob.Digest, blob.StorageLocation)
+	return bundles, err
+}
+
+func (blob *Blob) updateArchiveState(txn gorp.SqlExecutor, state, key, fileId string) error {
+	blob.ArchiveState = state
+	blob.ArchiveKey = key
+	blob.FileId = fileId
+	if _, err := txn.Update(blob); err != nil {
+		return err
+	}
+	_, err := txn.Exec(
+		"UPDATE bundle SET archive_state = ?, file_id = ? WHERE digest = ? AND app_id IN (SELECT id FROM app WHERE storage_location = ?)",
+		state, fileId, blob.Digest, blob.StorageLocation,
+	)
+	return err
+}
+
+// ArchiveBlob moves the file of the blob to the archive, out of the version folders of the bundles.
+func ArchiveBlob(dbm *gorp.DbMap, s *GoogleService, archive BundleArchive, blob *Blob) error {
+	key, err := archive.Archive(s, blob)
+	if err != nil {
+		return err
+	}
+	return Transact(dbm, func(txn gorp.SqlExecutor) error {
+		return blob.updateArchiveState(txn, ArchiveStateArchived, key, blob.FileId)
+	})
+}
+
+// RestoreBlob puts the file back to the version folders of the bundles if the archive is ready, and tells whether it is restored.
+// Otherwise the blob is marked restoring, to be restored by the job once the archive is ready.
+func RestoreBlob(dbm *gorp.DbMap, s *GoogleService, archive BundleArchive, blob *Blob) (bool, error) {
+	ready, err := archive.RequestRestore(blob.ArchiveKey)
+	if err != nil {
+		return false, err
+	}
+	if !ready {
+		if blob.ArchiveState == ArchiveStateRestoring {
+			return false, nil
+		}
+		return false, Transact(dbm, func(txn gorp.SqlExecutor) error {
+			return blob.updateArchiveState(txn, ArchiveStateRestoring, blob.ArchiveKey, blob.FileId)
+		})
+	}
+
+	bundles, err := blob.Bundles(dbm)
+	if err != nil {
+		return false, err
+	}
+	if len(bundles) == 0 {
+		return false, nil
+	}
+
+	var folders []*Folder
+	seen := map[string]bool{}
+	for _, bundle := range bundles {
+		app, err := bundle.App(dbm)
+		if err != nil {
+			return false, err
+		}
+		folder, err := app.VersionFolder(dbm, NewDriveStorage(s), bundle.BundleVersion)
+		if err != nil {
+			return false, err
+		}
+		if !seen[folder.FileId] {
+			seen[folder.FileId] = true
+			folders = append(folders, folder)
+		}
+	}
+
+	fileId, err := archive.Restore(s, blob.ArchiveKey, bundles[0].BuildFileName(), folders[0].ParentReference())
+	if err != nil {
+		return false, err
+	}
+	for _, folder := range folders[1:] {
+		if err := s.AddParent(fileId, folder.FileId); err != nil {
+			return false, err
+		}
+	}
+
+	// the key is kept to delete the copy in the archive with the blob
+	err = Transact(dbm, func(txn gorp.SqlExecutor) error {
+		return blob.updateArchiveState(txn, "", blob.ArchiveKey, fileId)
+	})
+	return err == nil, err
+}
+
+// Blob returns the blob of the bundle, or nil if the bundle owns its file.
+func (bundle *Bundle) Blob(txn gorp.SqlExecutor) (*Blob, error) {
+	if bundle.Digest == "" {
+		return nil, nil
+	}
+	app, err := bundle.App(txn)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := GetBlobByDigest(txn, bundle.Digest, app.StorageLocation)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return blob, err
+}
@@ -13,20 +13,33 @@ type Audit struct {
 	Resource   int       `db:"resource"`
 	ResourceId int       `db:"resource_id"`
 	Action     int       `db:"action"`
+	DeviceId   int       `db:"device_id"`
 	CreatedAt  time.Time `db:"created_at"`
 	UpdatedAt  time.Time `db:"updated_at"`
 }
 
 const (
-	ResourceApp       int = 1
-	ResourceBundle    int = 2
-	ResourceAuthority int = 3
+	ResourceApp           int = 1
+	ResourceBundle        int = 2
+	ResourceAuthority     int = 3
+	ResourceDeviceGroup   int = 4
+	ResourceAccessRequest int = 5
+	ResourceEnvFile       int = 6
 )
 
 const (
-	ActionCreate   int = 1
-	ActionDelete   int = 2
-	ActionDownload int = 3
+	ActionCreate           int = 1
+	ActionDelete           int = 2
+	ActionDownload         int = 3
+	ActionPushInstall      int = 4
+	ActionSubmitTestFlight int = 5
+	ActionPublishPlay      int = 6
+	ActionApprove          int = 7
+	ActionDeny             int = 8
+	ActionUpdate           int = 9
+	ActionRecall           int = 10
+	ActionLiftRecall       int = 11
+	ActionRunDeviceFarm    int = 12
 )
 
 func (audit *Audit) PreInsert(s gorp.SqlExecutor) error {
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	AuditRollupDateFormat = "2006-01-02"
+	auditPurgeBatchSize   = 1000
+)
+
+// an AuditRollup is the daily count of an action on a resource, kept after the audits are purged
+type AuditRollup struct {
+	Id         int       `db:"id"`
+	Date       string    `db:"date"`
+	Resource   int       `db:"resource"`
+	ResourceId int       `db:"resource_id"`
+	Action     int       `db:"action"`
+	Count      int       `db:"count"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+func (rollup *AuditRollup) PreInsert(s gorp.SqlExecutor) error {
+	rollup.CreatedAt = time.Now()
+	rollup.UpdatedAt = rollup.CreatedAt
+	return nil
+}
+
+func (rollup *AuditRollup) PreUpdate(s gorp.SqlExecutor) error {
+	rollup.UpdatedAt = time.Now()
+	return nil
+}
+
+type auditRollupKey struct {
+	Date       string
+	Resource   int
+	ResourceId int
+	Action     int
+}
+
+// RollupAndPurgeAudits adds the audits created before the time to the daily rollups and deletes them,
+// a batch at a time so that each transaction stays small. It returns the number of the purged audits.
+func RollupAndPurgeAudits(dbm *gorp.DbMap, before time.Time) (int, error) {
+	purged := 0
+	for {
+		var count int
+		err := Transact(dbm, func(txn gorp.SqlExecutor) error {
+			c, err := rollupAndPurgeAuditBatch(txn, before)
+			count = c
+			return err
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += count
+		if count < auditPurgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+func rollupAndPurgeAuditBatch(txn gorp.SqlExecutor, before time.Time) (int, error) {
+	var audits []*Audit
+	_, err := txn.Select(&audits, "SELECT * FROM audit WHERE created_at < ? ORDER BY id ASC LIMIT ?", before, auditPurgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(audits) == 0 {
+		return 0, nil
+	}
+
+	counts := map[auditRollupKey]int{}
+	for _, audit := range audits {
+		key := auditRollupKey{audit.CreatedAt.Format(AuditRollupDateFormat), audit.Resource, audit.ResourceId, audit.Action}
+		counts[key]++
+	}
+	for key, count := range counts {
+		if err := addAuditRollup(txn, key, count); err != nil {
+			return 0, err
+		}
+	}
+
+	args := make([]interface{}, len(audits))
+	quarks := make([]string, len(audits))
+	for i, audit := range audits {
+		args[i] = audit.Id
+		quarks[i] = "?"
+	}
+	if _, err := txn.Exec(fmt.Sprintf("DELETE FROM audit WHERE id in (%s)", strings.Join(quarks, ",")), args...); err != nil {
+		return 0, err
+	}
+
+	return len(audits), nil
+}
+
+// AddAuditRollup counts the actions on the resource in the day of the time without an audit each, for the ones
+// reported as a count, e.g. the downloads served by a mirror.
+func AddAuditRollup(txn gorp.SqlExecutor, resource, resourceId, action, count int, at time.Time) error {
+	return addAuditRollup(txn, auditRollupKey{at.Format(AuditRollupDateFormat), resource, resourceId, action}, count)
+}
+
+func addAuditRollup(txn gorp.SqlExecutor, key auditRollupKey, count int) error {
+	result, err := txn.Exec(
+		"UPDATE audit_rollup SET count = count + ?, updated_at = ? WHERE date = ? AND resource = ? AND resource_id = ? AND action = ?",
+		count, time.Now(), key.Date, key.Resource, key.ResourceId, key.Action,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	rollup := &AuditRollup{
+		Date:       key.Date,
+		Resource:   key.Resource,
+		ResourceId: key.ResourceId,
+		Action:     key.Action,
+		Count:      count,
+	}
+	return txn.Insert(rollup)
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	AuthFailureKindLogin     = "login"
+	AuthFailureKindToken     = "token"
+	AuthFailureKindForbidden = "forbidden"
+	AuthFailureKindPairing   = "pairing"
+
+	TokenKindApi    = "api"
+	TokenKindDevice = "device"
+	TokenKindAdmin  = "admin"
+	TokenKindMirror = "mirror"
+
+	// the failures are counted in the window, so the lockout of an address ends once it stops failing for it
+	AuthFailureWindow = 15 * time.Minute
+
+	// the first lockout, doubled for each further failure up to the window
+	authLockoutBase = time.Minute
+)
+
+// an AuthFailure is a failed login, an invalid token or a 403 of the subject, the client address or the login user
+type AuthFailure struct {
+	Id        int       `db:"id"`
+	Subject   string    `db:"subject"`
+	Kind      string    `db:"kind"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// a TokenCountry is a country a token is used from, as told by the header of the CDN or the load balancer
+type TokenCountry struct {
+	Id        int       `db:"id"`
+	TokenKind string    `db:"token_kind"`
+	TokenId   int       `db:"token_id"`
+	Country   string    `db:"country"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (failure *AuthFailure) PreInsert(s gorp.SqlExecutor) error {
+	failure.CreatedAt = time.Now()
+	return nil
+}
+
+func (country *TokenCountry) PreInsert(s gorp.SqlExecutor) error {
+	country.CreatedAt = time.Now()
+	return nil
+}
+
+// RecordAuthFailure records the failure and returns the failures of the kind in the window, including this one.
+func RecordAuthFailure(txn gorp.SqlExecutor, subject, kind string) (int, error) {
+	if err := txn.Insert(&AuthFailure{Subject: subject, Kind: kind}); err != nil {
+		return 0, err
+	}
+	return countAuthFailures(txn, subject, []string{kind}, time.Now())
+}
+
+// AuthLockout returns how long the subject is locked out after failing limit times or more in the window,
+// or 0. The lockout starts at a minute from the last failure and doubles for each further failure.
+func AuthLockout(txn gorp.SqlExecutor, subject string, limit int, now time.Time) (time.Duration, error) {
+	if limit <= 0 {
+		return 0, nil
+	}
+	count, err := countAuthFailures(txn, subject, []string{AuthFailureKindLogin, AuthFailureKindToken}, now)
+	if err != nil {
+		return 0, err
+	}
+	if count < limit {
+		return 0, nil
+	}
+
+	var last AuthFailure
+	err = txn.SelectOne(&last, "SELECT * FROM auth_failure WHERE subject = ? AND kind IN (?, ?) ORDER BY id DESC LIMIT 1", subject, AuthFailureKindLogin, AuthFailureKindToken)
+	if err != nil {
+		return 0, err
+	}
+
+	lockout := authLockoutBase
+	for i := limit; i < count && lockout < AuthFailureWindow; i++ {
+		lockout *= 2
+	}
+	if lockout > AuthFailureWindow {
+		lockout = AuthFailureWindow
+	}
+	if remaining := last.CreatedAt.Add(lockout).Sub(now); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+func countAuthFailures(txn gorp.SqlExecutor, subject string, kinds []string, now time.Time) (int, error) {
+	in, args := inCondition(kinds)
+	args = append([]interface{}{subject, now.Add(-AuthFailureWindow)}, args...)
+	count, err := txn.SelectInt("SELECT COUNT(id) FROM auth_failure WHERE subject = ? AND created_at > ? AND kind IN ("+in+")", args...)
+	return int(count), err
+}
+
+func PurgeAuthFailures(txn gorp.SqlExecutor, before time.Time) (int, error) {
+	result, err := txn.Exec("DELETE FROM auth_failure WHERE created_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// RecordTokenCountry records the country of the token, and reports whether it is new for a token used before.
+// The first country of a token is not new, as every token is used for the first time somewhere.
+func RecordTokenCountry(txn gorp.SqlExecutor, tokenKind string, tokenId int, country string) (bool, error) {
+	var countries []string
+	_, err := txn.Select(&countries, "SELECT country FROM token_country WHERE token_kind = ? AND token_id = ?", tokenKind, tokenId)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range countries {
+		if c == country {
+			return false, nil
+		}
+	}
+
+	if err := txn.Insert(&TokenCountry{TokenKind: tokenKind, TokenId: tokenId, Country: country}); err != nil {
+		return false, err
+	}
+	return len(countries) > 0, nil
+}
@@ -6,13 +6,44 @@ import (
 	"github.com/coopernurse/gorp"
 )
 
+type AuthorityRole int
+
+// the zero value keeps the members added before the roles were introduced developers
+const (
+	AuthorityRoleDeveloper AuthorityRole = iota
+	AuthorityRoleTester
+	AuthorityRoleAdmin
+)
+
+func (role AuthorityRole) IsValid() bool {
+	return AuthorityRoleDeveloper <= role && role <= AuthorityRoleAdmin
+}
+
+func (role AuthorityRole) String() string {
+	var str string
+	if role == AuthorityRoleDeveloper {
+		str = "developer"
+	} else if role == AuthorityRoleTester {
+		str = "tester"
+	} else if role == AuthorityRoleAdmin {
+		str = "admin"
+	}
+	return str
+}
+
 type Authority struct {
-	Id           int       `db:"id"`
-	AppId        int       `db:"app_id"`
-	PermissionId string    `db:"permission_id"`
-	Email        string    `db:"email"`
-	CreatedAt    time.Time `db:"created_at"`
-	UpdatedAt    time.Time `db:"updated_at"`
+	Id           int           `db:"id"`
+	AppId        int           `db:"app_id"`
+	PermissionId string        `db:"permission_id"`
+	Email        string        `db:"email"`
+	Role         AuthorityRole `db:"role"`
+	CreatedAt    time.Time     `db:"created_at"`
+	UpdatedAt    time.Time     `db:"updated_at"`
+}
+
+// IsDeveloper reports whether the member can see the internals of the app, i.e. is not a tester.
+func (authority *Authority) IsDeveloper() bool {
+	return authority.Role != AuthorityRoleTester
 }
 
 func (authority *Authority) PreInsert(s gorp.SqlExecutor) error {
@@ -30,6 +61,11 @@ func (authority *Authority) Save(txn gorp.SqlExecutor) error {
 	return txn.Insert(authority)
 }
 
+func (authority *Authority) Update(txn gorp.SqlExecutor) error {
+	_, err := txn.Update(authority)
+	return err
+}
+
 func (authority *Authority) DeleteFromDB(txn gorp.SqlExecutor) error {
 	_, err := txn.Delete(authority)
 	return err
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/coopernurse/gorp"
+)
+
+// an AuthorityDocument declares every member of an app, so that the access control can be reviewed like code
+type AuthorityDocument struct {
+	Authorities []*AuthorityDocumentEntry `json:"authorities"`
+}
+
+type AuthorityDocumentEntry struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type AuthorityDocumentError struct {
+	Message string
+}
+
+func (e *AuthorityDocumentError) Error() string {
+	return "authority document: " + e.Message
+}
+
+type AuthorityRoleChange struct {
+	Authority    *Authority
+	PreviousRole AuthorityRole
+}
+
+// an AuthorityDiff is the changes to apply to the members of the app to match the document
+type AuthorityDiff struct {
+	Added   []*Authority
+	Removed []*Authority
+	Updated []*AuthorityRoleChange
+}
+
+type AuthorityDiffJsonResponse struct {
+	Added   []*AuthorityChangeJsonResponse `json:"added"`
+	Removed []*AuthorityChangeJsonResponse `json:"removed"`
+	Updated []*AuthorityChangeJsonResponse `json:"updated"`
+	Applied bool                           `json:"applied"`
+}
+
+type AuthorityChangeJsonResponse struct {
+	Email        string `json:"email"`
+	Role         string `json:"role"`
+	PreviousRole string `json:"previous_role,omitempty"`
+}
+
+func ParseAuthorityRole(str string) (AuthorityRole, error) {
+	for _, role := range []AuthorityRole{AuthorityRoleDeveloper, AuthorityRoleTester, AuthorityRoleAdmin} {
+		if str == role.String() {
+			return role, nil
+		}
+	}
+	if str == "" {
+		return AuthorityRoleDeveloper, nil
+	}
+	return 0, fmt.Errorf("role %q is invalid", str)
+}
+
+// ParseAuthorityDocument parses the JSON document and rejects duplicated or empty members.
+func ParseAuthorityDocument(data []byte) (*AuthorityDocument, error) {
+	doc := &AuthorityDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, &AuthorityDocumentError{err.Error()}
+	}
+	if len(doc.Authorities) == 0 {
+		// an empty document would remove every member, which is never intended
+		return nil, &AuthorityDocumentError{"authorities is empty"}
+	}
+
+	emails := map[string]bool{}
+	for _, entry := range doc.Authorities {
+		entry.Email = strings.TrimSpace(entry.Email)
+		if entry.Email == "" {
+			return nil, &AuthorityDocumentError{"email is required"}
+		}
+		if emails[entry.Email] {
+			return nil, &AuthorityDocumentError{entry.Email + " is duplicated"}
+		}
+		emails[entry.Email] = true
+
+		if _, err := ParseAuthorityRole(entry.Role); err != nil {
+			return nil, &AuthorityDocumentError{err.Error()}
+		}
+	}
+	return doc, nil
+}
+
+func NewAuthorityDiff(current []*Authority, doc *AuthorityDocument) *AuthorityDiff {
+	diff := &AuthorityDiff{}
+
+	declared := map[string]AuthorityRole{}
+	for _, entry := range doc.Authorities {
+		role, _ := ParseAuthorityRole(entry.Role)
+		declared[entry.Email] = role
+	}
+
+	existing := map[string]bool{}
+	for _, authority := range current {
+		existing[authority.Email] = true
+
+		role, ok := declared[authority.Email]
+		if !ok {
+			diff.Removed = append(diff.Removed, authority)
+		} else if role != authority.Role {
+			diff.Updated = append(diff.Updated, &AuthorityRoleChange{authority, authority.Role})
+			authority.Role = role
+		}
+	}
+
+	for _, entry := range doc.Authorities {
+		if existing[entry.Email] {
+			continue
+		}
+		diff.Added = append(diff.Added, &Authority{
+			Email: entry.Email,
+			Role:  declared[entry.Email],
+		})
+	}
+
+	return diff
+}
+
+func (diff *AuthorityDiff) IsEmpty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Updated) == 0
+}
+
+// GrantsAdmin reports whether the diff adds an admin or promotes a member to the admin.
+func (diff *AuthorityDiff) GrantsAdmin() bool {
+	for _, authority := range diff.Added {
+		if authority.Role == AuthorityRoleAdmin {
+			return true
+		}
+	}
+	for _, change := range diff.Updated {
+		if change.Authority.Role == AuthorityRoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func (diff *AuthorityDiff) JsonResponse(applied bool) *AuthorityDiffJsonResponse {
+	resp := &AuthorityDiffJsonResponse{
+		Added:   []*AuthorityChangeJsonResponse{},
+		Removed: []*AuthorityChangeJsonResponse{},
+		Updated: []*AuthorityChangeJsonResponse{},
+		Applied: applied,
+	}
+	for _, authority := range diff.Added {
+		resp.Added = append(resp.Added, &AuthorityChangeJsonResponse{Email: authority.Email, Role: authority.Role.String()})
+	}
+	for _, authority := range diff.Removed {
+		resp.Removed = append(resp.Removed, &AuthorityChangeJsonResponse{Email: authority.Email, Role: authority.Role.String()})
+	}
+	for _, change := range diff.Updated {
+		resp.Updated = append(resp.Updated, &AuthorityChangeJsonResponse{
+			Email:        change.Authority.Email,
+			Role:         change.Authority.Role.String(),
+			PreviousRole: change.PreviousRole.String(),
+		})
+	}
+	return resp
+}
+
+// ApplyAuthorityDiff shares and unshares the app folder on Google Drive along with the authorities.
+func (app *App) ApplyAuthorityDiff(txn gorp.SqlExecutor, s *GoogleService, diff *AuthorityDiff) error {
+	for _, authority := range diff.Added {
+		if err := app.CreateAuthority(txn, s, authority); err != nil {
+			return err
+		}
+	}
+	for _, authority := range diff.Removed {
+		if err := app.DeleteAuthority(txn, s, authority); err != nil {
+			return err
+		}
+	}
+	for _, change := range diff.Updated {
+		if err := change.Authority.Update(txn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/coopernurse/gorp"
+)
+
+// BadgeCacheDuration is how long a badge is cached, as every view of the pages it is embedded in fetches it
+const BadgeCacheDuration = 5 * time.Minute
+
+// the badges cached by the app, the platform, the variant and the label
+var badgeCache = struct {
+	sync.Mutex
+	badges map[string]*cachedBadge
+}{badges: map[string]*cachedBadge{}}
+
+type cachedBadge struct {
+	svg      []byte
+	cachedAt time.Time
+}
+
+// BadgeToken derives the token of the badges of the app from its API token, so that the URL embedded in a README
+// only reads the badges, and refreshing the API token revokes it too.
+func BadgeToken(secret string, app *App) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("badge:" + app.ApiToken))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func IsValidBadgeToken(secret string, app *App, token string) bool {
+	return hmac.Equal([]byte(BadgeToken(secret, app)), []byte(token))
+}
+
+// LatestBundleBadge returns the SVG badge of the latest bundle of the platform and the variant, e.g. "2.4.1 (rev 318)".
+func (app *App) LatestBundleBadge(txn gorp.SqlExecutor, platformType BundlePlatformType, variant, label string) ([]byte, error) {
+	key := fmt.Sprintf("%d:%d:%s:%s", app.Id, platformType, variant, label)
+	badgeCache.Lock()
+	cached := badgeCache.badges[key]
+	badgeCache.Unlock()
+	if cached != nil && time.Since(cached.cachedAt) < BadgeCacheDuration {
+		return cached.svg, nil
+	}
+
+	bundle, err := app.LatestBundle(txn, platformType, variant)
+	if err != nil {
+		return nil, err
+	}
+	message, color := "none", "#9f9f9f"
+	if bundle != nil {
+		version := bundle.BundleVersion
+		if bundle.ShortVersion != "" {
+			version = bundle.ShortVersion
+		}
+		message, color = fmt.Sprintf("%s (rev %d)", version, bundle.Revision), "#007ec6"
+	}
+	svg := RenderBadge(label, message, color)
+
+	// the expired ones are dropped, as the variants and the labels are given by the URLs
+	badgeCache.Lock()
+	for cachedKey, cached := range badgeCache.badges {
+		if time.Since(cached.cachedAt) >= BadgeCacheDuration {
+			delete(badgeCache.badges, cachedKey)
+		}
+	}
+	badgeCache.badges[key] = &cachedBadge{svg: svg, cachedAt: time.Now()}
+	badgeCache.Unlock()
+	return svg, nil
+}
+
+// RenderBadge draws the label and the message in the flat style of shields.io. The widths are estimated
+// from the number of the characters, as the fonts of the viewers are not known.
+func RenderBadge(label, message, color string) []byte {
+	labelWidth := badgeTextWidth(label)
+	messageWidth := badgeTextWidth(message)
+	width := labelWidth + messageWidth
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`, width, html.EscapeString(label), html.EscapeString(message))
+	fmt.Fprintf(&buf, `<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(&buf, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`, width)
+	fmt.Fprintf(&buf, `<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`, labelWidth, labelWidth, messageWidth, color, width)
+	fmt.Fprintf(&buf, `<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	fmt.Fprintf(&buf, `<text x="%d" y="14">%s</text><text x="%d" y="14">%s</text></g></svg>`, labelWidth/2, html.EscapeString(label), labelWidth+messageWidth/2, html.EscapeString(message))
+	return buf.Bytes()
+}
+
+func badgeTextWidth(s string) int {
+	return utf8.RuneCountInString(s)*7 + 10
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"io"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// a BandwidthUsage is the bytes of the bundles served to a user, or to an address for the downloads without login, in a day
+type BandwidthUsage struct {
+	Id        int       `db:"id"`
+	Subject   string    `db:"subject"`
+	Date      string    `db:"date"`
+	Bytes     int64     `db:"bytes"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+type BandwidthUsageJsonResponse struct {
+	Subject string `json:"subject"`
+	Date    string `json:"date"`
+	Bytes   int64  `json:"bytes"`
+}
+
+func (usage *BandwidthUsage) PreInsert(s gorp.SqlExecutor) error {
+	usage.CreatedAt = time.Now()
+	usage.UpdatedAt = usage.CreatedAt
+	return nil
+}
+
+func (usage *BandwidthUsage) PreUpdate(s gorp.SqlExecutor) error {
+	usage.UpdatedAt = time.Now()
+	return nil
+}
+
+func (usage *BandwidthUsage) JsonResponse() *BandwidthUsageJsonResponse {
+	return &BandwidthUsageJsonResponse{
+		Subject: usage.Subject,
+		Date:    usage.Date,
+		Bytes:   usage.Bytes,
+	}
+}
+
+// GetBandwidthBytes returns the bytes served to the subject in the day.
+func GetBandwidthBytes(txn gorp.SqlExecutor, subject string, date time.Time) (int64, error) {
+	return txn.SelectInt("SELECT IFNULL(SUM(bytes), 0) FROM bandwidth_usage WHERE subject = ? AND date = ?", subject, date.Format(AuditRollupDateFormat))
+}
+
+// GetBandwidthUsages returns the usages in the day, largest first.
+func GetBandwidthUsages(txn gorp.SqlExecutor, date time.Time) ([]*BandwidthUsage, error) {
+	var usages []*BandwidthUsage
+	_, err := txn.Select(&usages, "SELECT * FROM bandwidth_usage WHERE date = ? ORDER BY bytes DESC", date.Format(AuditRollupDateFormat))
+	if err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
+
+func AddBandwidthUsage(txn gorp.SqlExecutor, subject string, date time.Time, bytes int64) error {
+	day := date.Format(AuditRollupDateFormat)
+	result, err := txn.Exec("UPDATE bandwidth_usage SET bytes = bytes + ?, updated_at = ? WHERE subject = ? AND date = ?", bytes, time.Now(), subject, day)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	usage := &BandwidthUsage{
+		Subject: subject,
+		Date:    day,
+		Bytes:   bytes,
+	}
+	return txn.Insert(usage)
+}
+
+// a MeteredReader counts the bytes read, and sleeps to keep them under the rate if it is positive.
+// OnClose is called with the count when the reader is closed.
+type MeteredReader struct {
+	Reader      io.ReadCloser
+	BytesPerSec int64
+	OnClose     func(bytes int64)
+
+	count   int64
+	started time.Time
+}
+
+func (r *MeteredReader) Read(p []byte) (int, error) {
+	if r.started.IsZero() {
+		r.started = time.Now()
+	}
+	if r.BytesPerSec > 0 && int64(len(p)) > r.BytesPerSec {
+		p = p[:r.BytesPerSec]
+	}
+
+	n, err := r.Reader.Read(p)
+	r.count += int64(n)
+
+	if r.BytesPerSec > 0 {
+		expected := time.Duration(r.count * int64(time.Second) / r.BytesPerSec)
+		if elapsed := time.Since(r.started); elapsed < expected {
+			time.Sleep(expected - elapsed)
+		}
+	}
+	return n, err
+}
+
+func (r *MeteredReader) Close() error {
+	err := r.Reader.Close()
+	if r.OnClose != nil {
+		r.OnClose(r.count)
+	}
+	return err
+}
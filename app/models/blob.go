@@ -0,0 +1,303 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"os"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// a Blob is a content-addressed file in the storage shared by every bundle with the same digest in the storage location
+type Blob struct {
+	Id              int       `db:"id"`
+	Digest          string    `db:"digest"`
+	StorageLocation string    `db:"storage_location"`
+	FileId          string    `db:"file_id"`
+	RefCount        int       `db:"ref_count"`
+	ArchiveState    string    `db:"archive_state"`
+	ArchiveKey      string    `db:"archive_key"`
+	CreatedAt       time.Time `db:"created_at"`
+	UpdatedAt       time.Time `db:"updated_at"`
+}
+
+func (blob *Blob) PreInsert(s gorp.SqlExecutor) error {
+	blob.CreatedAt = time.Now()
+	blob.UpdatedAt = blob.CreatedAt
+	return nil
+}
+
+func (blob *Blob) PreUpdate(s gorp.SqlExecutor) error {
+	blob.UpdatedAt = time.Now()
+	return nil
+}
+
+func (blob *Blob) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(blob)
+}
+
+func (blob *Blob) DeleteFromDB(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(blob)
+	return err
+}
+
+func (blob *Blob) DeleteFromStorage(storage Storage) error {
+	return storage.Delete(blob.FileId)
+}
+
+// FileDigest returns the hex encoded SHA-256 of the file and rewinds it for the upload.
+func FileDigest(file *os.File) (string, error) {
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// storedFileDigest returns the SHA-256 and the size of the file stored under the key, read through.
+func storedFileDigest(storage Storage, key string) (string, int64, error) {
+	object, err := storage.Get(key)
+	if err != nil {
+		return "", 0, err
+	}
+	defer object.Body.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, object.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// AcquireBlob references the stored file with the given digest, uploading the file only
+// when no bundle in the storage location has stored the same content yet. The file is placed in the folder either way if the storage has folders.
+func AcquireBlob(dbm *gorp.DbMap, storage Storage, file *os.File, filename, digest, storageLocation, location string) (*Blob, error) {
+	var blob *Blob
+	err := Transact(dbm, func(txn gorp.SqlExecutor) error {
+		b, err := referenceBlob(txn, digest, storageLocation)
+		if err != nil {
+			return err
+		}
+		blob = b
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if blob != nil && blob.ArchiveState == "" {
+		if err := addToFolder(storage, blob.FileId, location); err != nil {
+			return nil, err
+		}
+		return blob, nil
+	}
+	if blob != nil {
+		return unarchiveBlob(dbm, storage, blob, file, filename, location)
+	}
+
+	key, err := storage.Put(file, filename, location)
+	if err != nil {
+		return nil, err
+	}
+	return saveBlob(dbm, storage, key, digest, storageLocation, location)
+}
+
+// AdoptBlob makes the file already stored under the key the blob of the digest, for the upload streamed to the storage
+// before its digest is known. The file is deleted if the same content is stored, as AcquireBlob would not have stored it.
+func AdoptBlob(dbm *gorp.DbMap, storage Storage, key, digest, storageLocation, location string) (*Blob, error) {
+	var blob *Blob
+	err := Transact(dbm, func(txn gorp.SqlExecutor) error {
+		b, err := referenceBlob(txn, digest, storageLocation)
+		if err != nil {
+			return err
+		}
+		blob = b
+		return nil
+	})
+	if err != nil {
+		storage.Delete(key)
+		return nil, err
+	}
+	if blob != nil && blob.ArchiveState == "" {
+		storage.Delete(key)
+		if err := addToFolder(storage, blob.FileId, location); err != nil {
+			return nil, err
+		}
+		return blob, nil
+	}
+	if blob != nil {
+		return restoreBlob(dbm, storage, blob, key)
+	}
+	return saveBlob(dbm, storage, key, digest, storageLocation, location)
+}
+
+// saveBlob records the file stored under the key as a new blob.
+func saveBlob(dbm *gorp.DbMap, storage Storage, key, digest, storageLocation, location string) (*Blob, error) {
+	blob := &Blob{
+		Digest:          digest,
+		StorageLocation: storageLocation,
+		FileId:          key,
+		RefCount:        1,
+	}
+	err := Transact(dbm, func(txn gorp.SqlExecutor) error {
+		return blob.Save(txn)
+	})
+	if err != nil {
+		// the same content may have been stored concurrently, so reference it instead
+		blob.DeleteFromStorage(storage)
+
+		var existing *Blob
+		txErr := Transact(dbm, func(txn gorp.SqlExecutor) error {
+			b, err := referenceBlob(txn, digest, storageLocation)
+			if err != nil {
+				return err
+			}
+			existing = b
+			return nil
+		})
+		if txErr != nil || existing == nil {
+			return nil, err
+		}
+		if err := addToFolder(storage, existing.FileId, location); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	return blob, nil
+}
+
+// addToFolder adds the shared file to the folder of another version, only for the storage with folders.
+func addToFolder(storage Storage, key, folderId string) error {
+	folderStorage, ok := storage.(FolderStorage)
+	if !ok {
+		return nil
+	}
+	return folderStorage.AddToFolder(key, folderId)
+}
+
+// unarchiveBlob stores the uploaded file again for the archived blob, instead of restoring it from the archive.
+// The bundles sharing the blob are restored with it, but stay only in the folder of the new upload.
+func unarchiveBlob(dbm *gorp.DbMap, storage Storage, blob *Blob, file *os.File, filename, location string) (*Blob, error) {
+	key, err := storage.Put(file, filename, location)
+	if err != nil {
+		return nil, err
+	}
+	return restoreBlob(dbm, storage, blob, key)
+}
+
+// restoreBlob points the archived blob to the file stored again under the key.
+func restoreBlob(dbm *gorp.DbMap, storage Storage, blob *Blob, key string) (*Blob, error) {
+	// the file of the cold folder, which is already deleted for the other archives
+	blob.DeleteFromStorage(storage)
+
+	err := Transact(dbm, func(txn gorp.SqlExecutor) error {
+		return blob.updateArchiveState(txn, "", blob.ArchiveKey, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// ReleaseBlob drops a reference to the blob and deletes the file once nothing refers to it.
+func ReleaseBlob(txn gorp.SqlExecutor, storage Storage, digest, storageLocation string) error {
+	if _, err := txn.Exec("UPDATE bundle_blob SET ref_count = ref_count - 1 WHERE digest = ? AND storage_location = ?", digest, storageLocation); err != nil {
+		return err
+	}
+
+	blob, err := GetBlobByDigest(txn, digest, storageLocation)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if blob.RefCount > 0 {
+		return nil
+	}
+
+	if err := blob.DeleteFromDB(txn); err != nil {
+		return err
+	}
+	deleteArchiveCopy(storage, blob.ArchiveKey)
+	return blob.DeleteFromStorage(storage)
+}
+
+// adoptBundleFile makes the file of a bundle uploaded before the deduplication the blob of its digest, as if it were
+// uploaded now. The file is deleted for the blob of the same content already stored, which is restored with the file
+// if archived, so the storage must defer the deletions until the commit.
+func adoptBundleFile(txn gorp.SqlExecutor, storage Storage, bundle *Bundle, digest, storageLocation string) error {
+	bundle.Digest = digest
+	if _, err := txn.Exec("UPDATE bundle SET digest = ? WHERE id = ?", digest, bundle.Id); err != nil {
+		return err
+	}
+	blob, err := referenceBlob(txn, digest, storageLocation)
+	if err != nil {
+		return err
+	}
+	if blob == nil {
+		blob = &Blob{
+			Digest:          digest,
+			StorageLocation: storageLocation,
+			FileId:          bundle.FileId,
+			RefCount:        1,
+		}
+		return blob.Save(txn)
+	}
+	if blob.ArchiveState != "" {
+		blob.DeleteFromStorage(storage)
+		return blob.updateArchiveState(txn, "", blob.ArchiveKey, bundle.FileId)
+	}
+
+	folder, err := GetFolder(txn, bundle.AppId, bundle.BundleVersion)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if folder != nil {
+		if err := addToFolder(storage, blob.FileId, folder.FileId); err != nil {
+			return err
+		}
+	}
+	if _, err := txn.Exec("UPDATE bundle SET file_id = ? WHERE id = ?", blob.FileId, bundle.Id); err != nil {
+		return err
+	}
+	fileId := bundle.FileId
+	bundle.FileId = blob.FileId
+	return storage.Delete(fileId)
+}
+
+func referenceBlob(txn gorp.SqlExecutor, digest, storageLocation string) (*Blob, error) {
+	result, err := txn.Exec("UPDATE bundle_blob SET ref_count = ref_count + 1 WHERE digest = ? AND storage_location = ?", digest, storageLocation)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, nil
+	}
+	return GetBlobByDigest(txn, digest, storageLocation)
+}
+
+func GetBlobByDigest(txn gorp.SqlExecutor, digest, storageLocation string) (*Blob, error) {
+	var blob Blob
+	if err := txn.SelectOne(&blob, "SELECT * FROM bundle_blob WHERE digest = ? AND storage_location = ?", digest, storageLocation); err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+)
+
+const (
+	BrowserStackBaseUrl      = "https://api-cloud.browserstack.com"
+	BrowserStackDashboardUrl = "https://app-automate.browserstack.com/dashboard/v2/builds"
+)
+
+// BrowserStack runs the test suites on the devices of BrowserStack App Automate,
+// Espresso for the apks and XCUITest for the ipas.
+// https://www.browserstack.com/docs/app-automate/api-reference/introduction
+type BrowserStack struct {
+	Username  string
+	AccessKey string
+	TestSuite string
+	Devices   []string
+	BaseUrl   string
+	Client    *http.Client
+}
+
+type BrowserStackError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *BrowserStackError) Error() string {
+	return fmt.Sprintf("browserstack: got HTTP response code %d: %s", e.StatusCode, e.Body)
+}
+
+func NewBrowserStack(username, accessKey, testSuite string, devices []string) *BrowserStack {
+	return &BrowserStack{
+		Username:  username,
+		AccessKey: accessKey,
+		TestSuite: testSuite,
+		Devices:   devices,
+		BaseUrl:   BrowserStackBaseUrl,
+		Client:    http.DefaultClient,
+	}
+}
+
+// Start uploads the bundle and starts the build of the test suite with it.
+func (b *BrowserStack) Start(bundle *Bundle, filename string, file io.Reader) (string, string, error) {
+	framework := browserStackFramework(bundle)
+
+	appUrl, err := b.uploadApp(framework, filename, file)
+	if err != nil {
+		return "", "", err
+	}
+
+	build := map[string]interface{}{
+		"app":       appUrl,
+		"testSuite": b.TestSuite,
+		"devices":   b.Devices,
+		"buildTag":  fmt.Sprintf("%s (%d)", bundle.BundleVersion, bundle.Revision),
+	}
+	var res struct {
+		BuildId string `json:"build_id"`
+	}
+	if err := b.doJson("POST", fmt.Sprintf("/app-automate/%s/v2/build", framework), build, &res); err != nil {
+		return "", "", err
+	}
+	return res.BuildId, BrowserStackDashboardUrl + "/" + res.BuildId, nil
+}
+
+// State maps the status of the build, which is running while it is queued too.
+func (b *BrowserStack) State(bundle *Bundle, runId string) (string, string, string, error) {
+	var res struct {
+		Status string `json:"status"`
+	}
+	if err := b.doJson("GET", fmt.Sprintf("/app-automate/%s/v2/builds/%s", browserStackFramework(bundle), runId), nil, &res); err != nil {
+		return "", "", "", err
+	}
+
+	switch res.Status {
+	case "passed":
+		return DeviceFarmStatePassed, "", "", nil
+	case "failed":
+		return DeviceFarmStateFailed, "", "", nil
+	case "error", "timedout", "skipped":
+		return DeviceFarmStateError, res.Status, "", nil
+	}
+	return DeviceFarmStateRunning, "", "", nil
+}
+
+// uploadApp returns the bs:// URL of the uploaded app.
+func (b *BrowserStack) uploadApp(framework, filename string, file io.Reader) (string, error) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		part, err := writer.CreateFormFile("file", filename)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(part, file); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(writer.Close())
+	}()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/app-automate/%s/v2/app", b.BaseUrl, framework), pr)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	var res struct {
+		AppUrl string `json:"app_url"`
+	}
+	if err := b.do(req, &res); err != nil {
+		return "", err
+	}
+	return res.AppUrl, nil
+}
+
+func browserStackFramework(bundle *Bundle) string {
+	if bundle.IsIpa() {
+		return "xcuitest"
+	}
+	return "espresso"
+}
+
+func (b *BrowserStack) doJson(method, path string, body interface{}, v interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, b.BaseUrl+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return b.do(req, v)
+}
+
+func (b *BrowserStack) do(req *http.Request, v interface{}) error {
+	req.SetBasicAuth(b.Username, b.AccessKey)
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return &BrowserStackError{StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	if v == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
@@ -1,11 +1,12 @@
 package models
 
 import (
+	"crypto"
+	"database/sql"
 	"fmt"
-	"io"
-	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/coopernurse/gorp"
@@ -16,6 +17,8 @@ type BundlePlatformType int
 const (
 	BundlePlatformTypeAndroid BundlePlatformType = 1 + iota
 	BundlePlatformTypeIOS
+	BundlePlatformTypeMacOS
+	BundlePlatformTypeGeneric
 )
 
 func (platformType BundlePlatformType) Extention() BundleFileExtension {
@@ -24,6 +27,10 @@ func (platformType BundlePlatformType) Extention() BundleFileExtension {
 		ext = BundleFileExtensionAndroid
 	} else if platformType == BundlePlatformTypeIOS {
 		ext = BundleFileExtensionIOS
+	} else if platformType == BundlePlatformTypeMacOS {
+		ext = BundleFileExtensionMacOSDiskImage
+	} else if platformType == BundlePlatformTypeGeneric {
+		ext = BundleFileExtensionGenericZip
 	}
 	return ext
 }
@@ -34,63 +41,176 @@ func (platformType BundlePlatformType) String() string {
 		str = "android"
 	} else if platformType == BundlePlatformTypeIOS {
 		str = "ios"
+	} else if platformType == BundlePlatformTypeMacOS {
+		str = "macos"
+	} else if platformType == BundlePlatformTypeGeneric {
+		str = "generic"
 	}
 	return str
 }
 
+// a BundlePlatformSubtype tells the companion apps of tvOS and watchOS from the ones of iOS, which have the empty one
+type BundlePlatformSubtype string
+
+const (
+	BundlePlatformSubtypeTVOS    BundlePlatformSubtype = "tvos"
+	BundlePlatformSubtypeWatchOS BundlePlatformSubtype = "watchos"
+)
+
+func (subtype BundlePlatformSubtype) String() string {
+	switch subtype {
+	case BundlePlatformSubtypeTVOS:
+		return "tvOS"
+	case BundlePlatformSubtypeWatchOS:
+		return "watchOS"
+	}
+	return ""
+}
+
+// PlatformIdentifier is the platform-identifier of the manifest to install the ipa over the air, empty for iOS.
+func (subtype BundlePlatformSubtype) PlatformIdentifier() string {
+	switch subtype {
+	case BundlePlatformSubtypeTVOS:
+		return "com.apple.platform.appletvos"
+	case BundlePlatformSubtypeWatchOS:
+		return "com.apple.platform.watchos"
+	}
+	return ""
+}
+
 type BundleFileExtension string
 
 const (
-	BundleFileExtensionAndroid BundleFileExtension = ".apk"
-	BundleFileExtensionIOS     BundleFileExtension = ".ipa"
+	BundleFileExtensionAndroid          BundleFileExtension = ".apk"
+	BundleFileExtensionAndroidAppBundle BundleFileExtension = ".aab"
+	BundleFileExtensionIOS              BundleFileExtension = ".ipa"
+	BundleFileExtensionMacOSDiskImage   BundleFileExtension = ".dmg"
+	BundleFileExtensionMacOSPackage     BundleFileExtension = ".pkg"
+	BundleFileExtensionGenericZip       BundleFileExtension = ".zip"
+	BundleFileExtensionGenericTarball   BundleFileExtension = ".tar.gz"
 )
 
 func (ext BundleFileExtension) IsValid() bool {
 	var ok bool
-	if ext == BundleFileExtensionAndroid {
+	if ext == BundleFileExtensionAndroid || ext == BundleFileExtensionAndroidAppBundle {
 		ok = true
 	} else if ext == BundleFileExtensionIOS {
 		ok = true
+	} else if ext == BundleFileExtensionMacOSDiskImage || ext == BundleFileExtensionMacOSPackage {
+		ok = true
+	} else if ext == BundleFileExtensionGenericZip || ext == BundleFileExtensionGenericTarball {
+		ok = true
 	}
 	return ok
 }
 
+// Label is the extension without the dot, e.g. dmg, for the buttons of the downloads.
+func (ext BundleFileExtension) Label() string {
+	return strings.TrimPrefix(string(ext), ".")
+}
+
 func (ext BundleFileExtension) PlatformType() BundlePlatformType {
 	var platformType BundlePlatformType
-	if ext == BundleFileExtensionAndroid {
+	if ext == BundleFileExtensionAndroid || ext == BundleFileExtensionAndroidAppBundle {
 		platformType = BundlePlatformTypeAndroid
 	} else if ext == BundleFileExtensionIOS {
 		platformType = BundlePlatformTypeIOS
+	} else if ext == BundleFileExtensionMacOSDiskImage || ext == BundleFileExtensionMacOSPackage {
+		platformType = BundlePlatformTypeMacOS
+	} else if ext == BundleFileExtensionGenericZip || ext == BundleFileExtensionGenericTarball {
+		platformType = BundlePlatformTypeGeneric
 	}
 	return platformType
 }
 
 type Bundle struct {
-	Id               int                `db:"id"`
-	AppId            int                `db:"app_id"`
-	FileId           string             `db:"file_id"`
-	PlatformType     BundlePlatformType `db:"platform_type"`
-	BundleVersion    string             `db:"bundle_version"`
-	BundleIdentifier string             `db:"bundle_identifier"`
-	Revision         int                `db:"revision"`
-	Description      string             `db:"description"`
-	CreatedAt        time.Time          `db:"created_at"`
-	UpdatedAt        time.Time          `db:"updated_at"`
+	Id                 int                   `db:"id"`
+	AppId              int                   `db:"app_id"`
+	FileId             string                `db:"file_id"`
+	PlatformType       BundlePlatformType    `db:"platform_type"`
+	PlatformSubtype    BundlePlatformSubtype `db:"platform_subtype"`
+	BundleVersion      string                `db:"bundle_version"`
+	BundleIdentifier   string                `db:"bundle_identifier"`
+	ShortVersion       string                `db:"short_version"`
+	VersionCode        string                `db:"version_code"`
+	MinSdkVersion      string                `db:"min_sdk_version"`
+	Revision           int                   `db:"revision"`
+	Codename           string                `db:"codename"`
+	Description        string                `db:"description"`
+	Digest             string                `db:"digest"`
+	FileSize           int64                 `db:"file_size"`
+	ProvenanceVerified bool                  `db:"provenance_verified"`
+	InternalNotes      string                `db:"internal_notes"`
+	ArchiveState       string                `db:"archive_state"`
+	DocRevision        int                   `db:"doc_revision"`
+	Channel            string                `db:"channel"`
+	Tags               string                `db:"tags"`
+	Variant            string                `db:"variant"`
+	Recalled           bool                  `db:"recalled"`
+	RecallReason       string                `db:"recall_reason"`
+	RecallReplacement  int                   `db:"recall_replacement_id"`
+	IconDigest         string                `db:"icon_digest"`
+	IconChangedFrom    int                   `db:"icon_changed_from"`
+	SigningCertificate string                `db:"signing_certificate"`
+	SigningScheme      string                `db:"signing_scheme"`
+	SignerChangedFrom  int                   `db:"signer_changed_from"`
+	SigningIdentity    string                `db:"signing_identity"`
+	SigningTeamId      string                `db:"signing_team_id"`
+	ProfileType        string                `db:"profile_type"`
+	ProfileExpiresAt   time.Time             `db:"profile_expires_at"`
+	ExecutableUuid     string                `db:"executable_uuid"`
+	AppBundle          bool                  `db:"app_bundle"`
+	UniversalApkFileId string                `db:"universal_apk_file_id"`
+	UploadedBy         string                `db:"uploaded_by"`
+	CiJobUrl           string                `db:"ci_job_url"`
+	FileExtension      BundleFileExtension   `db:"file_extension"`
+	ShareGeneration    int                   `db:"share_generation"`
+	CreatedAt          time.Time             `db:"created_at"`
+	UpdatedAt          time.Time             `db:"updated_at"`
+
+	// the size of the universal APK, 0 for the ones built before it was recorded
+	UniversalApkFileSize int64 `db:"universal_apk_file_size"`
 
 	BundleInfo *BundleInfo `db:"-"`
 	File       *os.File    `db:"-"`
 	FileName   string      `db:"-"`
+	// the branch and the commit of the upload, only for the description template
+	Branch string `db:"-"`
+	Commit string `db:"-"`
+	// the descriptions of the upload in the other languages, saved as the changelogs
+	Translations map[string]string `db:"-"`
 }
 
 type BundleJsonResponse struct {
-	FileId       string `json:"file_id"`
-	Version      string `json:"version"`
-	Revision     int    `json:"revision"`
-	InstallUrl   string `json:"install_url"`
-	QrCodeUrl    string `json:"qr_code_url"`
-	PlatformType string `json:"platform_type"`
-	CreatedAt    string `json:"created_at"`
-	UpdatedAt    string `json:"updated_at"`
+	FileId             string   `json:"file_id"`
+	Digest             string   `json:"digest"`
+	Version            string   `json:"version"`
+	VersionCode        string   `json:"version_code"`
+	MinSdkVersion      string   `json:"min_sdk_version"`
+	Revision           int      `json:"revision"`
+	Codename           string   `json:"codename"`
+	InstallUrl         string   `json:"install_url"`
+	QrCodeUrl          string   `json:"qr_code_url"`
+	PlatformType       string   `json:"platform_type"`
+	PlatformSubtype    string   `json:"platform_subtype"`
+	Channel            string   `json:"channel"`
+	Tags               []string `json:"tags"`
+	Variant            string   `json:"variant"`
+	ProvenanceVerified bool     `json:"provenance_verified"`
+	Recalled           bool     `json:"recalled"`
+	ArchiveState       string   `json:"archive_state"`
+	IconChanged        bool     `json:"icon_changed"`
+	SigningCertificate string   `json:"signing_certificate"`
+	SignerChanged      bool     `json:"signer_changed"`
+	SigningIdentity    string   `json:"signing_identity"`
+	TeamId             string   `json:"team_id"`
+	ProfileType        string   `json:"profile_type"`
+	ProfileExpiresAt   string   `json:"profile_expires_at"`
+	ExecutableUuid     string   `json:"executable_uuid"`
+	UploadedBy         string   `json:"uploaded_by"`
+	CiJobUrl           string   `json:"ci_job_url"`
+	CreatedAt          string   `json:"created_at"`
+	UpdatedAt          string   `json:"updated_at"`
 }
 
 type Bundles []*Bundle
@@ -114,6 +234,7 @@ type BundlesJsonResponse struct {
 	TotalCount int                   `json:"total_count"`
 	Page       int                   `json:"page"`
 	Limit      int                   `json:"limit"`
+	NextCursor string                `json:"next_cursor"`
 	Bundles    []*BundleJsonResponse `json:"bundles"`
 }
 
@@ -126,16 +247,41 @@ func (bundle *Bundle) JsonResponse(ub UriBuilder) (*BundleJsonResponse, error) {
 	if err != nil {
 		return nil, err
 	}
+	profileExpiresAt := ""
+	if !bundle.ProfileExpiresAt.IsZero() {
+		profileExpiresAt = bundle.ProfileExpiresAt.Format(time.RFC3339)
+	}
 
 	return &BundleJsonResponse{
-		FileId:       bundle.FileId,
-		Version:      bundle.BundleVersion,
-		Revision:     bundle.Revision,
-		InstallUrl:   installUrl.String(),
-		QrCodeUrl:    qrCodeUrl.String(),
-		PlatformType: bundle.PlatformType.String(),
-		CreatedAt:    bundle.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:    bundle.CreatedAt.Format(time.RFC3339),
+		FileId:             bundle.FileId,
+		Digest:             bundle.Digest,
+		Version:            bundle.BundleVersion,
+		VersionCode:        bundle.VersionCode,
+		MinSdkVersion:      bundle.MinSdkVersion,
+		Revision:           bundle.Revision,
+		Codename:           bundle.Codename,
+		InstallUrl:         installUrl.String(),
+		QrCodeUrl:          qrCodeUrl.String(),
+		PlatformType:       bundle.PlatformType.String(),
+		PlatformSubtype:    string(bundle.PlatformSubtype),
+		Channel:            bundle.Channel,
+		Tags:               append([]string{}, bundle.TagList()...),
+		Variant:            bundle.Variant,
+		ProvenanceVerified: bundle.ProvenanceVerified,
+		Recalled:           bundle.Recalled,
+		ArchiveState:       bundle.ArchiveState,
+		IconChanged:        bundle.IconChanged(),
+		SigningCertificate: bundle.SigningCertificate,
+		SignerChanged:      bundle.SignerChanged(),
+		SigningIdentity:    bundle.SigningIdentity,
+		TeamId:             bundle.SigningTeamId,
+		ProfileType:        bundle.ProfileType,
+		ProfileExpiresAt:   profileExpiresAt,
+		ExecutableUuid:     bundle.ExecutableUuid,
+		UploadedBy:         bundle.UploadedBy,
+		CiJobUrl:           bundle.CiJobUrl,
+		CreatedAt:          bundle.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:          bundle.CreatedAt.Format(time.RFC3339),
 	}, nil
 }
 
@@ -145,16 +291,7 @@ func (bundle *Bundle) Plist(txn gorp.SqlExecutor, ipaUrl *url.URL) (*Plist, erro
 		return nil, err
 	}
 
-	return NewPlist(app.Title, bundle.BundleVersion, bundle.BundleIdentifier, ipaUrl.String()), nil
-}
-
-func (bundle *Bundle) PlistReader(txn gorp.SqlExecutor, ipaUrl *url.URL) (io.Reader, error) {
-	p, err := bundle.Plist(txn, ipaUrl)
-	if err != nil {
-		return nil, err
-	}
-
-	return p.Reader()
+	return NewPlist(app.Title, bundle.BundleVersion, bundle.BundleIdentifier, ipaUrl.String(), bundle.PlatformSubtype), nil
 }
 
 func (bundle *Bundle) BuildFileName() string {
@@ -163,10 +300,22 @@ func (bundle *Bundle) BuildFileName() string {
 		bundle.AppId,
 		bundle.BundleInfo.Version,
 		bundle.Revision,
-		bundle.PlatformType.Extention(),
+		bundle.Extension(),
 	)
 }
 
+// Extension returns the extension of the bundle file, which is .aab for an Android App Bundle
+// and the one of the file for the platforms of several extensions.
+func (bundle *Bundle) Extension() BundleFileExtension {
+	if bundle.AppBundle {
+		return BundleFileExtensionAndroidAppBundle
+	}
+	if bundle.FileExtension != "" {
+		return bundle.FileExtension
+	}
+	return bundle.PlatformType.Extention()
+}
+
 func (bundle *Bundle) IsApk() bool {
 	var ok bool
 	if bundle.PlatformType == BundlePlatformTypeAndroid {
@@ -191,9 +340,137 @@ func (bundle *Bundle) App(txn gorp.SqlExecutor) (*App, error) {
 	return app.(*App), nil
 }
 
+// LatestTestFlightSubmission returns nil if the bundle has never been submitted to TestFlight.
+func (bundle *Bundle) LatestTestFlightSubmission(txn gorp.SqlExecutor) (*TestFlightSubmission, error) {
+	var submissions []*TestFlightSubmission
+	_, err := txn.Select(&submissions, "SELECT * FROM test_flight_submission WHERE bundle_id = ? ORDER BY id DESC LIMIT 1", bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(submissions) == 0 {
+		return nil, nil
+	}
+	return submissions[0], nil
+}
+
+func (bundle *Bundle) DeleteTestFlightSubmissions(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM test_flight_submission WHERE bundle_id = ?", bundle.Id)
+	return err
+}
+
+// LatestPlaySubmission returns nil if the bundle has never been released to Google Play.
+func (bundle *Bundle) LatestPlaySubmission(txn gorp.SqlExecutor) (*PlaySubmission, error) {
+	var submissions []*PlaySubmission
+	_, err := txn.Select(&submissions, "SELECT * FROM play_submission WHERE bundle_id = ? ORDER BY id DESC LIMIT 1", bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(submissions) == 0 {
+		return nil, nil
+	}
+	return submissions[0], nil
+}
+
+func (bundle *Bundle) DeletePlaySubmissions(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM play_submission WHERE bundle_id = ?", bundle.Id)
+	return err
+}
+
+func (bundle *Bundle) KnownIssues(txn gorp.SqlExecutor) ([]*KnownIssue, error) {
+	var issues []*KnownIssue
+	_, err := txn.Select(&issues, "SELECT * FROM known_issue WHERE bundle_id = ? ORDER BY id ASC", bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	return issues, nil
+}
+
+func (bundle *Bundle) AddKnownIssues(txn gorp.SqlExecutor, titles []string) error {
+	for _, title := range titles {
+		issue := &KnownIssue{
+			BundleId: bundle.Id,
+			Title:    title,
+		}
+		if err := issue.Save(txn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bundle *Bundle) DeleteKnownIssues(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM known_issue WHERE bundle_id = ?", bundle.Id)
+	return err
+}
+
+// ReleaseNotes returns the description followed by the known issues, for the notifications to the testers.
+func (bundle *Bundle) ReleaseNotes(txn gorp.SqlExecutor) (string, error) {
+	issues, err := bundle.KnownIssues(txn)
+	if err != nil {
+		return "", err
+	}
+	if len(issues) == 0 {
+		return bundle.Description, nil
+	}
+
+	notes := bundle.Description
+	if notes != "" {
+		notes += "\n\n"
+	}
+	notes += "既知の不具合:"
+	for _, issue := range issues {
+		notes += "\n- " + issue.Title
+	}
+	return notes, nil
+}
+
+// AttachProvenance records the attestation and marks the bundle as verified if it is signed by one of the keys.
+func (bundle *Bundle) AttachProvenance(txn gorp.SqlExecutor, envelope []byte, keys []crypto.PublicKey) (*Provenance, error) {
+	provenance := &Provenance{
+		BundleId: bundle.Id,
+		Envelope: envelope,
+	}
+	if err := VerifyProvenance(envelope, bundle.Digest, keys); err != nil {
+		provenance.Message = err.Error()
+	} else {
+		provenance.Verified = true
+	}
+	if err := provenance.Save(txn); err != nil {
+		return nil, err
+	}
+
+	// Update copies the editable fields only
+	bundle.ProvenanceVerified = provenance.Verified
+	if _, err := txn.Exec("UPDATE bundle SET provenance_verified = ? WHERE id = ?", bundle.ProvenanceVerified, bundle.Id); err != nil {
+		return nil, err
+	}
+	return provenance, nil
+}
+
+// LatestProvenance returns nil if no attestation is uploaded with the bundle.
+func (bundle *Bundle) LatestProvenance(txn gorp.SqlExecutor) (*Provenance, error) {
+	var provenances []*Provenance
+	_, err := txn.Select(&provenances, "SELECT * FROM provenance WHERE bundle_id = ? ORDER BY id DESC LIMIT 1", bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(provenances) == 0 {
+		return nil, nil
+	}
+	return provenances[0], nil
+}
+
+func (bundle *Bundle) DeleteProvenances(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM provenance WHERE bundle_id = ?", bundle.Id)
+	return err
+}
+
 func (bundle *Bundle) PreInsert(s gorp.SqlExecutor) error {
 	bundle.BundleVersion = bundle.BundleInfo.Version
 	bundle.BundleIdentifier = bundle.BundleInfo.Identifier
+	bundle.ShortVersion = bundle.BundleInfo.ShortVersion
+	bundle.VersionCode = bundle.BundleInfo.VersionCode
+	bundle.MinSdkVersion = bundle.BundleInfo.MinSdkVersion
 	bundle.CreatedAt = time.Now()
 	bundle.UpdatedAt = bundle.CreatedAt
 	return nil
@@ -204,10 +481,23 @@ func (bundle *Bundle) PreUpdate(s gorp.SqlExecutor) error {
 	return nil
 }
 
+// PostDelete drops the plist cached for the bundle.
+func (bundle *Bundle) PostDelete(s gorp.SqlExecutor) error {
+	uncacheBundlePlist(bundle.Id)
+	return nil
+}
+
 func (bundle *Bundle) Save(txn gorp.SqlExecutor) error {
 	return txn.Insert(bundle)
 }
 
+// normalizeLabels trims the labels of the upload to match them as they are shown.
+func (bundle *Bundle) normalizeLabels() {
+	bundle.Channel = strings.TrimSpace(bundle.Channel)
+	bundle.Tags = strings.Join(ParseTags(bundle.Tags), ",")
+	bundle.Variant = strings.TrimSpace(bundle.Variant)
+}
+
 func (bundle *Bundle) Update(txn gorp.SqlExecutor) error {
 	current, err := GetBundle(txn, bundle.Id)
 	if err != nil {
@@ -215,12 +505,16 @@ func (bundle *Bundle) Update(txn gorp.SqlExecutor) error {
 	}
 
 	current.Description = bundle.Description
-	if bundle.FileId != "" {
-		current.FileId = bundle.FileId
-	}
+	current.InternalNotes = bundle.InternalNotes
+	current.DocRevision = bundle.DocRevision
+	current.Channel = strings.TrimSpace(bundle.Channel)
+	current.Tags = strings.Join(ParseTags(bundle.Tags), ",")
+	current.Variant = strings.TrimSpace(bundle.Variant)
 
-	_, err = txn.Update(current)
-	return err
+	if _, err = txn.Update(current); err != nil {
+		return err
+	}
+	return RecordEvent(txn, EventResourceBundle, bundle.Id, bundle.AppId, EventActionUpdate)
 }
 
 func (bundle *Bundle) DeleteFromDB(txn gorp.SqlExecutor) error {
@@ -228,19 +522,98 @@ func (bundle *Bundle) DeleteFromDB(txn gorp.SqlExecutor) error {
 	return err
 }
 
-func (bundle *Bundle) DeleteFromGoogleDrive(s *GoogleService) error {
+func (bundle *Bundle) DeleteFromStorage(txn gorp.SqlExecutor, storage Storage) error {
+	if err := bundle.DeleteUniversalApk(storage); err != nil {
+		return err
+	}
+	if err := bundle.DeleteSplits(txn, storage); err != nil {
+		return err
+	}
+	if err := bundle.DeleteDsyms(txn, storage); err != nil {
+		return err
+	}
+	if err := bundle.DeleteMapping(txn, storage); err != nil {
+		return err
+	}
 	if bundle.FileId == "" {
 		return nil
 	}
-	return s.DeleteFile(bundle.FileId)
+	// bundles uploaded before deduplication own their file
+	if bundle.Digest == "" {
+		return storage.Delete(bundle.FileId)
+	}
+	if folderStorage, ok := storage.(FolderStorage); ok {
+		if err := bundle.DetachFromFolder(txn, folderStorage); err != nil {
+			return err
+		}
+	}
+	app, err := bundle.App(txn)
+	if err != nil {
+		return err
+	}
+	return ReleaseBlob(txn, storage, bundle.Digest, app.StorageLocation)
 }
 
-func (bundle *Bundle) Delete(txn gorp.SqlExecutor, s *GoogleService) error {
-	if err := bundle.DeleteFromGoogleDrive(s); err != nil {
-		code, _, _ := ParseGoogleApiError(err)
-		if code != http.StatusNotFound {
-			return err
+// DetachFromFolder removes the file from the version folder unless another bundle of the version still uses it.
+func (bundle *Bundle) DetachFromFolder(txn gorp.SqlExecutor, storage FolderStorage) error {
+	count, err := txn.SelectInt(
+		"SELECT COUNT(id) FROM bundle WHERE app_id = ? AND bundle_version = ? AND digest = ? AND id <> ?",
+		bundle.AppId,
+		bundle.BundleVersion,
+		bundle.Digest,
+		bundle.Id,
+	)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	folder, err := GetFolder(txn, bundle.AppId, bundle.BundleVersion)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
 		}
+		return err
+	}
+
+	return storage.RemoveFromFolder(bundle.FileId, folder.FileId)
+}
+
+func (bundle *Bundle) Delete(txn gorp.SqlExecutor, storage Storage) error {
+	if err := bundle.DeleteFromStorage(txn, storage); err != nil {
+		return err
+	}
+	if err := bundle.DeleteTestFlightSubmissions(txn); err != nil {
+		return err
+	}
+	if err := bundle.DeletePlaySubmissions(txn); err != nil {
+		return err
+	}
+	if err := bundle.DeleteDeviceFarmRuns(txn); err != nil {
+		return err
+	}
+	if err := bundle.DeleteUniversalApkBuilds(txn); err != nil {
+		return err
+	}
+	if err := bundle.DeleteAnalyses(txn); err != nil {
+		return err
+	}
+	if err := bundle.DeleteProvenances(txn); err != nil {
+		return err
+	}
+	if err := bundle.DeleteKnownIssues(txn); err != nil {
+		return err
+	}
+	if err := bundle.DeleteChangelogs(txn); err != nil {
+		return err
+	}
+	if err := bundle.DeleteIcon(txn); err != nil {
+		return err
+	}
+	if err := RecordEvent(txn, EventResourceBundle, bundle.Id, bundle.AppId, EventActionDelete); err != nil {
+		return err
 	}
 	return bundle.DeleteFromDB(txn)
 }
@@ -256,11 +629,3 @@ func GetBundle(txn gorp.SqlExecutor, id int) (*Bundle, error) {
 	}
 	return &bundle, nil
 }
-
-func GetBundleByFileId(txn gorp.SqlExecutor, fileId string) (*Bundle, error) {
-	var bundle Bundle
-	if err := txn.SelectOne(&bundle, "SELECT * FROM bundle WHERE file_id = ?", fileId); err != nil {
-		return nil, err
-	}
-	return &bundle, nil
-}
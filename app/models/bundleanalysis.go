@@ -0,0 +1,298 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DHowett/go-plist"
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	ProfileTypeDevelopment = "development"
+	ProfileTypeAdHoc       = "ad-hoc"
+	ProfileTypeEnterprise  = "enterprise"
+	ProfileTypeAppStore    = "app-store"
+)
+
+// the provisioning profile of the app, not the ones of the extensions in it
+var ipaProfilePattern = regexp.MustCompile(`^Payload/[^/]+\.app/embedded\.mobileprovision$`)
+
+// a BundleAnalysis is the metadata read from the whole bundle file after the upload, for the external policy bots
+// to check the build, e.g. a new permission or the provisioning profile expiring before the release.
+type BundleAnalysis struct {
+	Id       int `db:"id"`
+	BundleId int `db:"bundle_id"`
+	// the package name or the bundle identifier
+	Identifier string `db:"identifier"`
+	// versionCode of an apk, CFBundleVersion of an ipa
+	VersionCode      string `db:"version_code"`
+	MinOsVersion     string `db:"min_os_version"`
+	TargetSdkVersion string `db:"target_sdk_version"`
+	// the permissions an apk uses, one per line
+	Permissions      string    `db:"permissions"`
+	ProfileName      string    `db:"profile_name"`
+	ProfileTeamName  string    `db:"profile_team_name"`
+	ProfileType      string    `db:"profile_type"`
+	ProfileDevices   int       `db:"profile_devices"`
+	ProfileExpiresAt time.Time `db:"profile_expires_at"`
+	// the UDIDs of the devices the profile provisions, one per line
+	ProfileUdids []byte `db:"profile_udids"`
+	// the entitlements of the profile in JSON
+	ProfileEntitlements []byte    `db:"profile_entitlements"`
+	CreatedAt           time.Time `db:"created_at"`
+}
+
+type BundleAnalysisJsonResponse struct {
+	Identifier       string                           `json:"identifier"`
+	VersionCode      string                           `json:"version_code"`
+	MinOsVersion     string                           `json:"min_os_version"`
+	TargetSdkVersion string                           `json:"target_sdk_version"`
+	Permissions      []string                         `json:"permissions"`
+	Profile          *ProvisioningProfileJsonResponse `json:"profile"`
+	AnalyzedAt       string                           `json:"analyzed_at"`
+}
+
+type ProvisioningProfileJsonResponse struct {
+	Name         string                 `json:"name"`
+	TeamName     string                 `json:"team_name"`
+	Type         string                 `json:"type"`
+	Devices      int                    `json:"devices"`
+	Udids        []string               `json:"udids"`
+	Entitlements map[string]interface{} `json:"entitlements"`
+	ExpiresAt    string                 `json:"expires_at"`
+}
+
+type provisioningProfile struct {
+	Name                  string                 `plist:"Name"`
+	TeamName              string                 `plist:"TeamName"`
+	TeamIdentifier        []string               `plist:"TeamIdentifier"`
+	ExpirationDate        time.Time              `plist:"ExpirationDate"`
+	ProvisionedDevices    []string               `plist:"ProvisionedDevices"`
+	ProvisionsAllDevices  bool                   `plist:"ProvisionsAllDevices"`
+	Entitlements          map[string]interface{} `plist:"Entitlements"`
+	DeveloperCertificates [][]byte               `plist:"DeveloperCertificates"`
+}
+
+func (analysis *BundleAnalysis) PreInsert(s gorp.SqlExecutor) error {
+	analysis.CreatedAt = time.Now()
+	return nil
+}
+
+func (analysis *BundleAnalysis) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(analysis)
+}
+
+func (analysis *BundleAnalysis) PermissionList() []string {
+	permissions := []string{}
+	for _, permission := range strings.Split(analysis.Permissions, "\n") {
+		if permission != "" {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+func (analysis *BundleAnalysis) HasProfile() bool {
+	return !analysis.ProfileExpiresAt.IsZero()
+}
+
+func (analysis *BundleAnalysis) UdidList() []string {
+	udids := []string{}
+	for _, udid := range strings.Split(string(analysis.ProfileUdids), "\n") {
+		if udid != "" {
+			udids = append(udids, udid)
+		}
+	}
+	return udids
+}
+
+// Entitlements returns the entitlements of the profile, or an empty map for the analyses before they were recorded.
+func (analysis *BundleAnalysis) Entitlements() map[string]interface{} {
+	entitlements := map[string]interface{}{}
+	if len(analysis.ProfileEntitlements) > 0 {
+		json.Unmarshal(analysis.ProfileEntitlements, &entitlements)
+	}
+	return entitlements
+}
+
+// EntitlementKeys returns the names of the entitlements in order, for the page.
+func (analysis *BundleAnalysis) EntitlementKeys() []string {
+	keys := []string{}
+	for key := range analysis.Entitlements() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (analysis *BundleAnalysis) JsonResponse() *BundleAnalysisJsonResponse {
+	res := &BundleAnalysisJsonResponse{
+		Identifier:       analysis.Identifier,
+		VersionCode:      analysis.VersionCode,
+		MinOsVersion:     analysis.MinOsVersion,
+		TargetSdkVersion: analysis.TargetSdkVersion,
+		Permissions:      analysis.PermissionList(),
+		AnalyzedAt:       analysis.CreatedAt.Format(time.RFC3339),
+	}
+	if analysis.HasProfile() {
+		res.Profile = &ProvisioningProfileJsonResponse{
+			Name:         analysis.ProfileName,
+			TeamName:     analysis.ProfileTeamName,
+			Type:         analysis.ProfileType,
+			Devices:      analysis.ProfileDevices,
+			Udids:        analysis.UdidList(),
+			Entitlements: analysis.Entitlements(),
+			ExpiresAt:    analysis.ProfileExpiresAt.Format(time.RFC3339),
+		}
+	}
+	return res
+}
+
+// Analysis returns nil if the bundle is not analyzed yet.
+func (bundle *Bundle) Analysis(txn gorp.SqlExecutor) (*BundleAnalysis, error) {
+	var analyses []*BundleAnalysis
+	_, err := txn.Select(&analyses, "SELECT * FROM bundle_analysis WHERE bundle_id = ? ORDER BY id DESC LIMIT 1", bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(analyses) == 0 {
+		return nil, nil
+	}
+	return analyses[0], nil
+}
+
+func (bundle *Bundle) DeleteAnalyses(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM bundle_analysis WHERE bundle_id = ?", bundle.Id)
+	return err
+}
+
+// AnalyzeBundle reads the manifest of an apk or an aab, or Info.plist and the provisioning profile of an ipa.
+// Only the identifier and the version of the app of macOS are read, as they are on the upload,
+// and the generic artifacts have the ones given to the upload.
+func AnalyzeBundle(file *os.File, bundle *Bundle) (*BundleAnalysis, error) {
+	if bundle.IsGeneric() {
+		return &BundleAnalysis{BundleId: bundle.Id, Identifier: bundle.BundleIdentifier, VersionCode: bundle.BundleVersion}, nil
+	}
+	if bundle.IsMac() {
+		info, err := NewBundleInfo(file, bundle.PlatformType)
+		if err != nil {
+			return nil, err
+		}
+		return &BundleAnalysis{BundleId: bundle.Id, Identifier: info.Identifier, VersionCode: info.Version}, nil
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := zip.NewReader(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &BundleAnalysis{BundleId: bundle.Id}
+	for _, f := range reader.File {
+		switch {
+		case bundle.IsApk() && (f.Name == "AndroidManifest.xml" || f.Name == AabManifestName):
+			buf, err := readZipFile(f)
+			if err != nil {
+				return nil, err
+			}
+			var manifest *androidManifest
+			if f.Name == AabManifestName {
+				manifest, err = parseAabManifest(buf)
+			} else {
+				manifest, err = parseAndroidManifest(buf)
+			}
+			if err != nil {
+				return nil, err
+			}
+			analysis.Identifier = manifest.Package
+			analysis.VersionCode = manifest.VersionCode
+			analysis.MinOsVersion = manifest.UsesSdk.MinSdkVersion
+			analysis.TargetSdkVersion = manifest.UsesSdk.TargetSdkVersion
+			var permissions []string
+			for _, permission := range manifest.UsesPermissions {
+				permissions = append(permissions, permission.Name)
+			}
+			analysis.Permissions = strings.Join(permissions, "\n")
+		case bundle.IsIpa() && ipaInfoPattern.MatchString(f.Name):
+			buf, err := readZipFile(f)
+			if err != nil {
+				return nil, err
+			}
+			info := &iosInfo{}
+			if _, err := plist.Unmarshal(buf, info); err != nil {
+				return nil, err
+			}
+			analysis.Identifier = info.CFBundleIdentifier
+			analysis.VersionCode = info.CFBundleVersion
+			analysis.MinOsVersion = info.MinimumOSVersion
+		case bundle.IsIpa() && ipaProfilePattern.MatchString(f.Name):
+			buf, err := readZipFile(f)
+			if err != nil {
+				return nil, err
+			}
+			profile, err := parseProvisioningProfile(buf)
+			if err != nil {
+				return nil, err
+			}
+			analysis.ProfileName = profile.Name
+			analysis.ProfileTeamName = profile.TeamName
+			analysis.ProfileType = profile.Type()
+			analysis.ProfileDevices = len(profile.ProvisionedDevices)
+			analysis.ProfileExpiresAt = profile.ExpirationDate
+			analysis.ProfileUdids = []byte(strings.Join(profile.ProvisionedDevices, "\n"))
+			if entitlements, err := json.Marshal(profile.Entitlements); err == nil {
+				analysis.ProfileEntitlements = entitlements
+			}
+		}
+	}
+	return analysis, nil
+}
+
+// parseProvisioningProfile reads the plist signed in the CMS envelope of the profile, without verifying the signature.
+func parseProvisioningProfile(buf []byte) (*provisioningProfile, error) {
+	start := bytes.Index(buf, []byte("<?xml"))
+	end := bytes.LastIndex(buf, []byte("</plist>"))
+	if start < 0 || end < start {
+		return nil, errors.New("the plist of the provisioning profile is not found")
+	}
+
+	profile := &provisioningProfile{}
+	if _, err := plist.Unmarshal(buf[start:end+len("</plist>")], profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// Type tells how the profile distributes the app, as Xcode names the export methods.
+func (profile *provisioningProfile) Type() string {
+	if allow, _ := profile.Entitlements["get-task-allow"].(bool); allow {
+		return ProfileTypeDevelopment
+	}
+	if profile.ProvisionsAllDevices {
+		return ProfileTypeEnterprise
+	}
+	if len(profile.ProvisionedDevices) > 0 {
+		return ProfileTypeAdHoc
+	}
+	return ProfileTypeAppStore
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
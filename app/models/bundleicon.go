@@ -0,0 +1,354 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the launcher icons are kept as the thumbnails of the size, which are compared between the bundles
+const BundleIconSize = 96
+
+// a BundleIcon is the thumbnail of the launcher icon in the bundle file, to tell when a build changes the icon by mistake.
+// The digest is of the pixels of the thumbnail, so that the icon compressed again is not told as changed.
+type BundleIcon struct {
+	Id        int       `db:"id"`
+	BundleId  int       `db:"bundle_id"`
+	Digest    string    `db:"digest"`
+	Png       []byte    `db:"png"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+var (
+	// the launcher icons of the densities, which aapt keeps in the names unless the resources are shortened,
+	// in the base module of an aab
+	apkIconPattern = regexp.MustCompile(`^(?:base/)?res/(?:mipmap|drawable)-(ldpi|mdpi|hdpi|xhdpi|xxhdpi|xxxhdpi)(?:-v\d+)?/([^/]+)\.png$`)
+	apkIconDensity = map[string]int64{"ldpi": 1, "mdpi": 2, "hdpi": 3, "xhdpi": 4, "xxhdpi": 5, "xxxhdpi": 6}
+	// Xcode copies the app icons out of the asset catalog for the older iOS, named after the icon files of Info.plist
+	// with the scale and the device, e.g. AppIcon60x60@2x.png or AppIcon76x76@2x~ipad.png
+	ipaIconPattern = regexp.MustCompile(`^Payload/[^/]+\.app/([^/]+?)(?:@[23]x)?(?:~ipad)?\.png$`)
+	// the icon set of the asset catalog Xcode makes, for an ipa whose Info.plist is not read yet
+	defaultIpaIconNames = []string{"AppIcon"}
+	// the icon Android Studio makes, for an apk whose manifest is not read yet or whose icon is not resolved
+	defaultApkIconNames = []string{"ic_launcher"}
+)
+
+func (icon *BundleIcon) PreInsert(s gorp.SqlExecutor) error {
+	icon.CreatedAt = time.Now()
+	icon.UpdatedAt = icon.CreatedAt
+	return nil
+}
+
+func (icon *BundleIcon) PreUpdate(s gorp.SqlExecutor) error {
+	icon.UpdatedAt = time.Now()
+	return nil
+}
+
+func (icon *BundleIcon) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(icon)
+}
+
+// ExtractBundleIcon returns the thumbnail of the largest launcher icon in the bundle file, or nil if none is found.
+// The icons are the files of the names in the bundle info, or the file of an apk resolved through its resource table.
+func ExtractBundleIcon(file *os.File, bundleInfo *BundleInfo) (*BundleIcon, error) {
+	// the icons of the disk images, the packages and the generic artifacts are not read
+	if bundleInfo.PlatformType == BundlePlatformTypeMacOS || bundleInfo.PlatformType == BundlePlatformTypeGeneric {
+		return nil, nil
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := zip.NewReader(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	var iconFile *zip.File
+	var rank int64
+	for _, f := range reader.File {
+		// the icon resolved through the resource table, which can be shortened by the build
+		if bundleInfo.IconFile != "" && f.Name == bundleInfo.IconFile {
+			iconFile = f
+			break
+		}
+		if r := bundleIconRank(f.Name, bundleInfo.PlatformType, int64(f.UncompressedSize64), bundleInfo.IconNames); r > rank {
+			iconFile, rank = f, r
+		}
+	}
+	if iconFile == nil {
+		return nil, nil
+	}
+
+	rc, err := iconFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return newBundleIcon(b)
+}
+
+// bundleIconRank returns how good the file is as the icon, the higher the larger, or 0 if it is not the launcher icon.
+// The icons are of the icon names, or of the default ones of the platform if none is given.
+func bundleIconRank(name string, platformType BundlePlatformType, size int64, iconNames []string) int64 {
+	switch platformType {
+	case BundlePlatformTypeAndroid:
+		m := apkIconPattern.FindStringSubmatch(name)
+		if m == nil {
+			return 0
+		}
+		if len(iconNames) == 0 {
+			iconNames = defaultApkIconNames
+		}
+		for _, iconName := range iconNames {
+			if m[2] == iconName {
+				return apkIconDensity[m[1]]
+			}
+		}
+	case BundlePlatformTypeIOS:
+		m := ipaIconPattern.FindStringSubmatch(name)
+		if m == nil {
+			return 0
+		}
+		if len(iconNames) == 0 {
+			iconNames = defaultIpaIconNames
+		}
+		for _, iconName := range iconNames {
+			if strings.HasPrefix(m[1], iconName) {
+				return size
+			}
+		}
+	}
+	return 0
+}
+
+func isBundleIcon(name string, platformType BundlePlatformType, iconNames []string) bool {
+	return bundleIconRank(name, platformType, 1, iconNames) > 0
+}
+
+// newBundleIcon makes the thumbnail of the icon file.
+func newBundleIcon(b []byte) (*BundleIcon, error) {
+	img, err := decodeIconPng(b)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail := resizeIcon(img, BundleIconSize)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, thumbnail); err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(thumbnail.Pix)
+	return &BundleIcon{Digest: hex.EncodeToString(digest[:]), Png: buf.Bytes()}, nil
+}
+
+// decodeIconPng decodes the PNG, or the PNG optimized by Xcode which the browsers cannot show.
+func decodeIconPng(b []byte) (image.Image, error) {
+	if len(b) > 16 && string(b[12:16]) == "CgBI" {
+		return decodeCgBI(b)
+	}
+	return png.Decode(bytes.NewReader(b))
+}
+
+// decodeCgBI decodes the PNG of Apple with the CgBI chunk: the data is deflated without the zlib header,
+// and the pixels are premultiplied BGRA. Only the non-interlaced 8-bit RGBA is supported, which Xcode writes.
+func decodeCgBI(b []byte) (image.Image, error) {
+	var width, height int
+	var idat bytes.Buffer
+	for offset := 8; offset+12 <= len(b); {
+		length := int(binary.BigEndian.Uint32(b[offset:]))
+		chunkType := string(b[offset+4 : offset+8])
+		if offset+12+length > len(b) {
+			return nil, errors.New("CgBI chunk is truncated")
+		}
+		data := b[offset+8 : offset+8+length]
+		switch chunkType {
+		case "IHDR":
+			if length < 13 {
+				return nil, errors.New("CgBI IHDR is invalid")
+			}
+			width = int(binary.BigEndian.Uint32(data[0:]))
+			height = int(binary.BigEndian.Uint32(data[4:]))
+			if data[8] != 8 || data[9] != 6 || data[12] != 0 {
+				return nil, errors.New("CgBI other than non-interlaced 8-bit RGBA is not supported")
+			}
+		case "IDAT":
+			idat.Write(data)
+		}
+		offset += 12 + length
+	}
+	if width == 0 || height == 0 {
+		return nil, errors.New("CgBI IHDR is not found")
+	}
+
+	raw, err := ioutil.ReadAll(flate.NewReader(&idat))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	stride := width * 4
+	if len(raw) < height*(stride+1) {
+		return nil, errors.New("CgBI data is truncated")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	prev := make([]byte, stride)
+	for y := 0; y < height; y++ {
+		row := raw[y*(stride+1) : (y+1)*(stride+1)]
+		line := row[1:]
+		unfilterPngRow(row[0], line, prev, 4)
+		for x := 0; x < width; x++ {
+			p := line[x*4 : x*4+4]
+			i := img.PixOffset(x, y)
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = p[2], p[1], p[0], p[3]
+		}
+		prev = line
+	}
+	return img, nil
+}
+
+// unfilterPngRow reverses the filter of the scanline in place with the previous one.
+// https://www.w3.org/TR/PNG/#9Filters
+func unfilterPngRow(filter byte, line, prev []byte, bpp int) {
+	for i := range line {
+		var a, c int
+		if i >= bpp {
+			a = int(line[i-bpp])
+			c = int(prev[i-bpp])
+		}
+		up := int(prev[i])
+		switch filter {
+		case 1:
+			line[i] += byte(a)
+		case 2:
+			line[i] += byte(up)
+		case 3:
+			line[i] += byte((a + up) / 2)
+		case 4:
+			p := a + up - c
+			pa, pb, pc := abs(p-a), abs(p-up), abs(p-c)
+			if pa <= pb && pa <= pc {
+				line[i] += byte(a)
+			} else if pb <= pc {
+				line[i] += byte(up)
+			} else {
+				line[i] += byte(c)
+			}
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// resizeIcon scales the icon to the square by averaging the pixels under each of the thumbnail.
+func resizeIcon(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	thumbnail := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/size
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/size
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < size; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/size
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/size
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+cr, g+cg, b+cb, a+ca, n+1
+				}
+			}
+			thumbnail.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return thumbnail
+}
+
+func (bundle *Bundle) Icon(txn gorp.SqlExecutor) (*BundleIcon, error) {
+	var icon BundleIcon
+	err := txn.SelectOne(&icon, "SELECT * FROM bundle_icon WHERE bundle_id = ?", bundle.Id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &icon, nil
+}
+
+func (bundle *Bundle) DeleteIcon(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM bundle_icon WHERE bundle_id = ?", bundle.Id)
+	return err
+}
+
+// saveBundleIcon records the icon of the bundle saved, and the previous bundle if the icon is changed from its one.
+func (app *App) saveBundleIcon(txn gorp.SqlExecutor, bundle *Bundle, icon *BundleIcon) error {
+	if icon == nil {
+		return nil
+	}
+	bundle.IconDigest = icon.Digest
+
+	previous, err := app.previousBundle(txn, bundle)
+	if err != nil {
+		return err
+	}
+	// the bundles uploaded before the icons were recorded do not tell the change
+	if previous != nil && previous.IconDigest != "" && previous.IconDigest != bundle.IconDigest {
+		bundle.IconChangedFrom = previous.Id
+	}
+	if _, err := txn.Exec("UPDATE bundle SET icon_digest = ?, icon_changed_from = ? WHERE id = ?", bundle.IconDigest, bundle.IconChangedFrom, bundle.Id); err != nil {
+		return err
+	}
+
+	icon.BundleId = bundle.Id
+	return icon.Save(txn)
+}
+
+// previousBundle returns the last bundle of the platform and the variant uploaded before the bundle, recalled or not.
+func (app *App) previousBundle(txn gorp.SqlExecutor, bundle *Bundle) (*Bundle, error) {
+	var bundles []*Bundle
+	_, err := txn.Select(&bundles, "SELECT * FROM bundle WHERE app_id = ? AND platform_type = ? AND variant = ? AND id < ? ORDER BY id DESC LIMIT 1",
+		app.Id, bundle.PlatformType, bundle.Variant, bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(bundles) == 0 {
+		return nil, nil
+	}
+	return bundles[0], nil
+}
+
+// IconChanged tells whether the launcher icon differs from the one of the previous bundle of the platform and the variant.
+func (bundle *Bundle) IconChanged() bool {
+	return bundle.IconChangedFrom != 0
+}
@@ -16,29 +16,148 @@ import (
 // a BundleInfo is information of an application package(apk file, ipa file, etc.)
 type BundleInfo struct {
 	Version      string
+	ShortVersion string
 	Identifier   string
 	PlatformType BundlePlatformType
+	// the file is an Android App Bundle rather than an apk
+	AppBundle bool
+	// the prefixes of the icon files of an ipa told by Info.plist, e.g. "AppIcon60x60" for AppIcon60x60@2x.png,
+	// or the name of the launcher icon of an apk or an aab told by the manifest, e.g. "ic_launcher"
+	IconNames []string
+	// the executable of an ipa told by Info.plist, to read the code signature and the UUID of
+	Executable string
+	// the launcher icon of an apk of the highest density, resolved through resources.arsc
+	IconFile string
+	// the versionCode and the minSdkVersion of the manifest of an apk or an aab
+	VersionCode   string
+	MinSdkVersion string
+	// the companion app of tvOS or watchOS told by Info.plist, empty for iOS
+	PlatformSubtype BundlePlatformSubtype
+	// the extension of the file told by its content, for the platforms of several extensions
+	Extension BundleFileExtension
 }
 
 type androidManifest struct {
-	XMLName     xml.Name `xml:"manifest"`
-	VersionName string   `xml:"http://schemas.android.com/apk/res/android versionName,attr"`
+	XMLName         xml.Name                `xml:"manifest"`
+	Package         string                  `xml:"package,attr"`
+	VersionName     string                  `xml:"http://schemas.android.com/apk/res/android versionName,attr"`
+	VersionCode     string                  `xml:"http://schemas.android.com/apk/res/android versionCode,attr"`
+	UsesSdk         androidUsesSdk          `xml:"uses-sdk"`
+	UsesPermissions []androidUsesPermission `xml:"uses-permission"`
+	Application     androidApplication      `xml:"application"`
+}
+
+type androidApplication struct {
+	// the reference to the launcher icon, e.g. "@0x7F0D0000" in an apk or "@mipmap/ic_launcher" in an aab
+	Icon string `xml:"http://schemas.android.com/apk/res/android icon,attr"`
+}
+
+type androidUsesSdk struct {
+	MinSdkVersion    string `xml:"http://schemas.android.com/apk/res/android minSdkVersion,attr"`
+	TargetSdkVersion string `xml:"http://schemas.android.com/apk/res/android targetSdkVersion,attr"`
+}
+
+type androidUsesPermission struct {
+	Name string `xml:"http://schemas.android.com/apk/res/android name,attr"`
 }
 
 type iosInfo struct {
-	CFBundleVersion    string `plist:"CFBundleVersion"`
-	CFBundleIdentifier string `plist:"CFBundleIdentifier"`
+	CFBundleVersion            string `plist:"CFBundleVersion"`
+	CFBundleShortVersionString string `plist:"CFBundleShortVersionString"`
+	CFBundleIdentifier         string `plist:"CFBundleIdentifier"`
+	MinimumOSVersion           string `plist:"MinimumOSVersion"`
+	CFBundleExecutable         string `plist:"CFBundleExecutable"`
+}
+
+// the icons are read apart from iosInfo, so that an Info.plist with the keys of the unexpected types is still read
+type iosIconInfo struct {
+	CFBundleIcons     iosIcons `plist:"CFBundleIcons"`
+	CFBundleIconsIpad iosIcons `plist:"CFBundleIcons~ipad"`
+	CFBundleIconFiles []string `plist:"CFBundleIconFiles"`
+	CFBundleIconFile  string   `plist:"CFBundleIconFile"`
+}
+
+// the platforms are read apart from iosInfo too, as UIDeviceFamily is an integer in some of the older apps
+type iosPlatformInfo struct {
+	CFBundleSupportedPlatforms []string `plist:"CFBundleSupportedPlatforms"`
+	UIDeviceFamily             []int    `plist:"UIDeviceFamily"`
+}
+
+// PlatformSubtype tells the apps of tvOS and watchOS by the platforms they are built for,
+// or by the device families of the Info.plist without them.
+func (info *iosPlatformInfo) PlatformSubtype() BundlePlatformSubtype {
+	for _, platform := range info.CFBundleSupportedPlatforms {
+		switch platform {
+		case "AppleTVOS", "AppleTVSimulator":
+			return BundlePlatformSubtypeTVOS
+		case "WatchOS", "WatchSimulator":
+			return BundlePlatformSubtypeWatchOS
+		}
+	}
+	if len(info.CFBundleSupportedPlatforms) > 0 {
+		return ""
+	}
+	for _, family := range info.UIDeviceFamily {
+		switch family {
+		case 3:
+			return BundlePlatformSubtypeTVOS
+		case 4:
+			return BundlePlatformSubtypeWatchOS
+		}
+	}
+	return ""
+}
+
+type iosIcons struct {
+	CFBundlePrimaryIcon struct {
+		CFBundleIconFiles []string `plist:"CFBundleIconFiles"`
+		CFBundleIconName  string   `plist:"CFBundleIconName"`
+	} `plist:"CFBundlePrimaryIcon"`
+}
+
+// IconNames returns the names of the icon files, the primary icons of the iPhone and the iPad first
+// and then the ones of the older iOS, without the scales and the extensions.
+func (info *iosIconInfo) IconNames() []string {
+	var names []string
+	for _, icons := range []iosIcons{info.CFBundleIcons, info.CFBundleIconsIpad} {
+		names = append(names, icons.CFBundlePrimaryIcon.CFBundleIconFiles...)
+		if icons.CFBundlePrimaryIcon.CFBundleIconName != "" {
+			names = append(names, icons.CFBundlePrimaryIcon.CFBundleIconName)
+		}
+	}
+	names = append(names, info.CFBundleIconFiles...)
+	if info.CFBundleIconFile != "" {
+		names = append(names, info.CFBundleIconFile)
+	}
+
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".png")
+	}
+	return names
 }
 
 type BundleParseError struct {
 	Offset int64
+	Err    error
 }
 
 func (e *BundleParseError) Error() string {
+	if e.Err != nil {
+		return "cannot parse application package file: " + e.Err.Error()
+	}
 	return "cannot parse application package file"
 }
 
 func NewBundleInfo(file *os.File, platformType BundlePlatformType) (*BundleInfo, error) {
+	// the disk images and the packages are not zip files
+	if platformType == BundlePlatformTypeMacOS {
+		return parseMacFile(file)
+	}
+	// the version of a generic artifact is given to the upload
+	if platformType == BundlePlatformTypeGeneric {
+		return NewGenericBundleInfo(file, "", "", "")
+	}
+
 	stat, err := file.Stat()
 	if err != nil {
 		return nil, err
@@ -50,20 +169,33 @@ func NewBundleInfo(file *os.File, platformType BundlePlatformType) (*BundleInfo,
 	}
 
 	// search system files
-	var xmlFile *zip.File   // apk system file
-	var plistFile *zip.File // ipa system file
+	var xmlFile *zip.File    // apk system file
+	var tableFile *zip.File  // apk resource table
+	var aabXmlFile *zip.File // aab system file
+	var plistFile *zip.File  // ipa system file
 	for _, f := range reader.File {
 		switch {
 		case f.Name == "AndroidManifest.xml":
 			xmlFile = f
-		case strings.HasSuffix(f.Name, "/Info.plist"):
+		case f.Name == "resources.arsc":
+			tableFile = f
+		case f.Name == AabManifestName:
+			aabXmlFile = f
+		case ipaInfoPattern.MatchString(f.Name):
+			// the Info.plist of the app, not the ones of the frameworks and the extensions in it
 			plistFile = f
 		}
 	}
 
+	// parse an aab file, told from an apk by its content
+	if platformType == BundlePlatformTypeAndroid && xmlFile == nil && aabXmlFile != nil {
+		bundleInfo, err := parseAabFile(aabXmlFile)
+		return bundleInfo, err
+	}
+
 	// parse an apk file
 	if platformType == BundlePlatformTypeAndroid {
-		bundleInfo, err := parseApkFile(xmlFile)
+		bundleInfo, err := parseApkFile(xmlFile, tableFile)
 		return bundleInfo, err
 	}
 
@@ -76,35 +208,92 @@ func NewBundleInfo(file *os.File, platformType BundlePlatformType) (*BundleInfo,
 	return nil, errors.New("unknown platform")
 }
 
-func parseApkFile(xmlFile *zip.File) (*BundleInfo, error) {
+// parseApkFile reads the binary XML of the manifest, and the launcher icon from the resource table if there is one.
+func parseApkFile(xmlFile *zip.File, tableFile *zip.File) (*BundleInfo, error) {
 	if xmlFile == nil {
 		return nil, errors.New("AndroidManifest.xml is not found")
 	}
 
-	manifest, err := parseAndroidManifest(xmlFile)
+	buf, err := readZipFile(xmlFile)
 	if err != nil {
 		return nil, err
 	}
+	manifest, err := parseAndroidManifest(buf)
+	if err != nil {
+		return nil, err
+	}
+	bundleInfo := androidBundleInfo(manifest)
 
-	bundleInfo := &BundleInfo{}
-	bundleInfo.Version = manifest.VersionName
-	bundleInfo.PlatformType = BundlePlatformTypeAndroid
-
+	if tableFile != nil {
+		// the icon is only compared between the bundles, so the bundle is read without it
+		if buf, err := readZipFile(tableFile); err == nil {
+			if path := resolveApkIcon(buf, manifest.Application.Icon); path != "" {
+				bundleInfo.IconNames = []string{androidIconName(path)}
+				if strings.HasSuffix(path, ".png") {
+					bundleInfo.IconFile = path
+				}
+			}
+		}
+	}
 	return bundleInfo, nil
 }
 
-func parseAndroidManifest(xmlFile *zip.File) (*androidManifest, error) {
-	rc, err := xmlFile.Open()
+func apkBundleInfo(buf []byte) (*BundleInfo, error) {
+	manifest, err := parseAndroidManifest(buf)
 	if err != nil {
 		return nil, err
 	}
-	defer rc.Close()
+	return androidBundleInfo(manifest), nil
+}
 
-	buf, err := ioutil.ReadAll(rc)
+// androidBundleInfo returns the bundle info of the manifest of an apk or an aab.
+func androidBundleInfo(manifest *androidManifest) *BundleInfo {
+	bundleInfo := &BundleInfo{}
+	bundleInfo.Version = manifest.VersionName
+	bundleInfo.Identifier = manifest.Package
+	bundleInfo.PlatformType = BundlePlatformTypeAndroid
+	bundleInfo.VersionCode = manifest.VersionCode
+	bundleInfo.MinSdkVersion = manifest.UsesSdk.MinSdkVersion
+	// the reference of an aab keeps the name of the icon, which the one of an apk does not
+	if strings.HasPrefix(manifest.Application.Icon, "@") && strings.Contains(manifest.Application.Icon, "/") {
+		bundleInfo.IconNames = []string{androidIconName(manifest.Application.Icon)}
+	}
+	return bundleInfo
+}
+
+// resolveApkIcon returns the file of the icon reference of the highest density in the resource table,
+// e.g. "res/mipmap-xxxhdpi-v4/ic_launcher.png", or "" if it is not resolved.
+func resolveApkIcon(buf []byte, ref string) string {
+	if !androidbinary.IsResID(ref) {
+		return ""
+	}
+	id, err := androidbinary.ParseResID(ref)
 	if err != nil {
-		return nil, err
+		return ""
+	}
+	table, err := androidbinary.NewTableFile(bytes.NewReader(buf))
+	if err != nil {
+		return ""
+	}
+	value, err := table.GetResource(id, &androidbinary.ResTableConfig{Density: 640})
+	if err != nil {
+		return ""
+	}
+	path, _ := value.(string)
+	return path
+}
+
+// androidIconName returns the name of the icon resource of the reference or the file,
+// e.g. "ic_launcher" of "@mipmap/ic_launcher" or "res/mipmap-anydpi-v26/ic_launcher.xml".
+func androidIconName(path string) string {
+	name := path[strings.LastIndex(path, "/")+1:]
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
 	}
+	return name
+}
 
+func parseAndroidManifest(buf []byte) (*androidManifest, error) {
 	xmlContent, err := androidbinary.NewXMLFile(bytes.NewReader(buf))
 	if err != nil {
 		return nil, err
@@ -134,17 +323,30 @@ func parseIpaFile(plistFile *zip.File) (*BundleInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	return ipaBundleInfo(buf)
+}
 
+func ipaBundleInfo(buf []byte) (*BundleInfo, error) {
 	info := &iosInfo{}
-	_, err = plist.Unmarshal(buf, info)
+	_, err := plist.Unmarshal(buf, info)
 	if err != nil {
 		return nil, err
 	}
 
 	bundleInfo := &BundleInfo{}
 	bundleInfo.Version = info.CFBundleVersion
+	bundleInfo.ShortVersion = info.CFBundleShortVersionString
 	bundleInfo.Identifier = info.CFBundleIdentifier
 	bundleInfo.PlatformType = BundlePlatformTypeIOS
+	bundleInfo.Executable = info.CFBundleExecutable
+	iconInfo := &iosIconInfo{}
+	if _, err := plist.Unmarshal(buf, iconInfo); err == nil {
+		bundleInfo.IconNames = iconInfo.IconNames()
+	}
+	platformInfo := &iosPlatformInfo{}
+	if _, err := plist.Unmarshal(buf, platformInfo); err == nil {
+		bundleInfo.PlatformSubtype = platformInfo.PlatformSubtype()
+	}
 
 	return bundleInfo, nil
 }
@@ -0,0 +1,290 @@
+package models
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the ABIs of Android, in the order the devices prefer them
+var SplitAbis = []string{"arm64-v8a", "armeabi-v7a", "armeabi", "x86_64", "x86"}
+
+var SplitDensities = []string{"ldpi", "mdpi", "tvdpi", "hdpi", "xhdpi", "xxhdpi", "xxxhdpi"}
+
+var (
+	ErrSplitNotApk      = errors.New("the split must be an APK of the bundle")
+	ErrSplitUnknown     = errors.New("the ABI or the density of the split is not known")
+	ErrSplitAbiRequired = errors.New("the ABI is not told by the native libraries of the split")
+	ErrSplitDuplicated  = errors.New("the split of the ABI and the density is already uploaded")
+)
+
+// a BundleSplit is another APK of the Android bundle built for an ABI or a density, e.g. the APKs of the Gradle splits,
+// which are released together. The install page serves the one of the device, and the bundle is the one for the others.
+type BundleSplit struct {
+	Id        int       `db:"id"`
+	BundleId  int       `db:"bundle_id"`
+	Abi       string    `db:"abi"`
+	Density   string    `db:"density"`
+	FileId    string    `db:"file_id"`
+	FileSize  int64     `db:"file_size"`
+	Digest    string    `db:"digest"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type BundleSplitJsonResponse struct {
+	Id        int    `json:"id"`
+	Abi       string `json:"abi"`
+	Density   string `json:"density"`
+	FileSize  int64  `json:"file_size"`
+	Digest    string `json:"digest"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (split *BundleSplit) PreInsert(s gorp.SqlExecutor) error {
+	split.CreatedAt = time.Now()
+	return nil
+}
+
+func (split *BundleSplit) JsonResponse() *BundleSplitJsonResponse {
+	return &BundleSplitJsonResponse{
+		Id:        split.Id,
+		Abi:       split.Abi,
+		Density:   split.Density,
+		FileSize:  split.FileSize,
+		Digest:    split.Digest,
+		CreatedAt: split.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func (split *BundleSplit) Label() string {
+	if split.Density == "" {
+		return split.Abi
+	}
+	if split.Abi == "" {
+		return split.Density
+	}
+	return split.Abi + " / " + split.Density
+}
+
+func (split *BundleSplit) FileName(bundle *Bundle) string {
+	suffix := strings.Replace(split.Label(), " / ", "_", 1)
+	return fmt.Sprintf("app_%d_ver_%s_rev_%d_%s%s", bundle.AppId, bundle.BundleVersion, bundle.Revision, suffix, BundleFileExtensionAndroid)
+}
+
+func isSplitAbi(abi string) bool {
+	for _, a := range SplitAbis {
+		if a == abi {
+			return true
+		}
+	}
+	return false
+}
+
+func isSplitDensity(density string) bool {
+	for _, d := range SplitDensities {
+		if d == density {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitAbiOf reads the ABI of the APK from the directories of its native libraries, which is empty for the APK
+// of no native code or of several ABIs.
+func SplitAbiOf(file *os.File) (string, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	reader, err := zip.NewReader(file, stat.Size())
+	if err != nil {
+		return "", err
+	}
+	abis := map[string]bool{}
+	for _, f := range reader.File {
+		segments := strings.Split(f.Name, "/")
+		if len(segments) > 2 && segments[0] == "lib" && segments[1] != "" {
+			abis[segments[1]] = true
+		}
+	}
+	if len(abis) != 1 {
+		return "", nil
+	}
+	for abi := range abis {
+		return abi, nil
+	}
+	return "", nil
+}
+
+// SplitPreference returns the ABIs the device of the architecture runs, best one first. The architecture is
+// the one of the Sec-CH-UA-Arch client hint, "arm" or "x86", or an ABI itself.
+func SplitPreference(arch string, is64bit bool) []string {
+	switch strings.ToLower(strings.Trim(arch, `"`)) {
+	case "arm":
+		if is64bit {
+			return []string{"arm64-v8a", "armeabi-v7a", "armeabi"}
+		}
+		return []string{"armeabi-v7a", "armeabi"}
+	case "x86":
+		if is64bit {
+			return []string{"x86_64", "x86"}
+		}
+		return []string{"x86"}
+	case "arm64-v8a":
+		return []string{"arm64-v8a", "armeabi-v7a", "armeabi"}
+	case "armeabi-v7a":
+		return []string{"armeabi-v7a", "armeabi"}
+	case "armeabi":
+		return []string{"armeabi"}
+	case "x86_64":
+		return []string{"x86_64", "x86"}
+	}
+	return nil
+}
+
+// SplitDensityOf returns the density of the device pixel ratio of the Sec-CH-DPR client hint.
+func SplitDensityOf(dpr float64) string {
+	switch {
+	case dpr <= 0:
+		return ""
+	case dpr <= 0.75:
+		return "ldpi"
+	case dpr <= 1:
+		return "mdpi"
+	case dpr <= 1.33:
+		return "tvdpi"
+	case dpr <= 1.5:
+		return "hdpi"
+	case dpr <= 2:
+		return "xhdpi"
+	case dpr <= 3:
+		return "xxhdpi"
+	}
+	return "xxxhdpi"
+}
+
+func (bundle *Bundle) Splits(txn gorp.SqlExecutor) ([]*BundleSplit, error) {
+	var splits []*BundleSplit
+	_, err := txn.Select(&splits, "SELECT * FROM bundle_split WHERE bundle_id = ? ORDER BY abi, density", bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	return splits, nil
+}
+
+// SplitFor returns the split of the first ABI preferred and of the density, or the one of the ABI for every density.
+// nil is returned when no split fits, for the bundle to be served.
+func (bundle *Bundle) SplitFor(txn gorp.SqlExecutor, abis []string, density string) (*BundleSplit, error) {
+	if len(abis) == 0 && density == "" {
+		return nil, nil
+	}
+	splits, err := bundle.Splits(txn)
+	if err != nil {
+		return nil, err
+	}
+	if len(abis) == 0 {
+		// the splits of the density only
+		abis = []string{""}
+	}
+	for _, abi := range abis {
+		for _, d := range []string{density, ""} {
+			for _, split := range splits {
+				if split.Abi == abi && split.Density == d && (abi != "" || d != "") {
+					return split, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// AddSplit stores the APK as the split of the ABI and the density. The APK must be of the same package and version
+// as the bundle, and the ABI is read from the native libraries when it is not given.
+func (bundle *Bundle) AddSplit(dbm *gorp.DbMap, storage Storage, file *os.File, abi, density string) (*BundleSplit, error) {
+	if bundle.PlatformType != BundlePlatformTypeAndroid || bundle.IsAab() {
+		return nil, ErrSplitNotApk
+	}
+	info, err := NewBundleInfo(file, BundlePlatformTypeAndroid)
+	if err != nil {
+		return nil, &BundleParseError{Err: err}
+	}
+	if info.AppBundle || info.Identifier != bundle.BundleIdentifier || info.Version != bundle.BundleVersion {
+		return nil, ErrSplitNotApk
+	}
+
+	if abi == "" {
+		if abi, err = SplitAbiOf(file); err != nil {
+			return nil, err
+		}
+		if abi == "" && density == "" {
+			return nil, ErrSplitAbiRequired
+		}
+	}
+	if (abi != "" && !isSplitAbi(abi)) || (density != "" && !isSplitDensity(density)) {
+		return nil, ErrSplitUnknown
+	}
+	count, err := dbm.SelectInt("SELECT COUNT(id) FROM bundle_split WHERE bundle_id = ? AND abi = ? AND density = ?", bundle.Id, abi, density)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrSplitDuplicated
+	}
+
+	digest, err := FileDigest(file)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	app, err := bundle.App(dbm)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.CheckStorageQuota(dbm, stat.Size(), ""); err != nil {
+		return nil, err
+	}
+	location, err := app.versionLocation(dbm, storage, bundle.BundleVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	split := &BundleSplit{
+		BundleId: bundle.Id,
+		Abi:      abi,
+		Density:  density,
+		FileSize: stat.Size(),
+		Digest:   digest,
+	}
+	split.FileId, err = storage.Put(file, split.FileName(bundle), location)
+	if err != nil {
+		return nil, err
+	}
+	if err := dbm.Insert(split); err != nil {
+		storage.Delete(split.FileId)
+		return nil, err
+	}
+	return split, nil
+}
+
+// DeleteSplits deletes the files of the splits, which are not shared with the other bundles as the blobs are.
+func (bundle *Bundle) DeleteSplits(txn gorp.SqlExecutor, storage Storage) error {
+	splits, err := bundle.Splits(txn)
+	if err != nil {
+		return err
+	}
+	for _, split := range splits {
+		if err := storage.Delete(split.FileId); err != nil {
+			return err
+		}
+	}
+	_, err = txn.Exec("DELETE FROM bundle_split WHERE bundle_id = ?", bundle.Id)
+	return err
+}
@@ -0,0 +1,330 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+)
+
+// the part of the upload kept in memory to read the version from, before the file is streamed to the storage.
+// AndroidManifest.xml is the first entry of an apk, while the manifest of an aab and Info.plist of an ipa are wherever the archiver puts them.
+const BundleStreamHeadSize = 32 * 1024 * 1024
+
+const (
+	zipLocalHeaderSignature    = 0x04034b50
+	zipDataDescriptorSignature = 0x08074b50
+	zipFlagDataDescriptor      = 0x8
+	zipMethodStore             = 0
+	zipMethodDeflate           = 8
+	zipExtraZip64              = 0x0001
+)
+
+var (
+	errBundleStreamHead = errors.New("the version is not found in the head of the upload")
+	errBundleStreamZip  = errors.New("the bundles of the platform are not streamed")
+	// the Info.plist of the app, not the ones of the frameworks and the extensions in it
+	ipaInfoPattern = regexp.MustCompile(`^Payload/[^/]+\.app/Info\.plist$`)
+)
+
+// a BundleStream reads the bundle file from the upload as it arrives, so that the file is sent on to the storage
+// without a temporary file. The version is read from the head kept in memory first, as it names the file in the storage,
+// and the icon and the digest are taken while the rest is streamed.
+type BundleStream struct {
+	PlatformType BundlePlatformType
+	Size         int64
+
+	body   io.Reader
+	head   *bundleStreamHead
+	source *switchReader
+	zip    *zipStream
+	info   *BundleInfo
+	// the icon file found so far and its rank
+	iconFile []byte
+	iconRank int64
+	// the signing of an ipa read so far
+	codeSigning ipaCodeSigning
+}
+
+func NewBundleStream(body io.Reader, size int64, platformType BundlePlatformType) *BundleStream {
+	stream := &BundleStream{
+		PlatformType: platformType,
+		Size:         size,
+		body:         body,
+		head:         &bundleStreamHead{},
+	}
+	stream.source = &switchReader{io.TeeReader(body, stream.head)}
+	stream.zip = newZipStream(stream.source)
+	return stream
+}
+
+// ReadInfo reads the entries in the head until the manifest of the bundle.
+// When it fails, e.g. the entry is beyond the head or is not streamable, the upload is read again from Reader into a file.
+func (stream *BundleStream) ReadInfo() (*BundleInfo, error) {
+	// the disk images and the packages are not zip files, and the generic artifacts have no manifest
+	if stream.PlatformType == BundlePlatformTypeMacOS || stream.PlatformType == BundlePlatformTypeGeneric {
+		return nil, errBundleStreamZip
+	}
+	for stream.info == nil {
+		entry, err := stream.zip.Next()
+		if err == io.EOF {
+			return nil, errBundleStreamHead
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.readEntry(entry); err != nil {
+			return nil, err
+		}
+	}
+	return stream.info, nil
+}
+
+// Reader returns the whole upload again, i.e. the head read so far followed by the rest, unless it is streamed.
+func (stream *BundleStream) Reader() io.Reader {
+	return io.MultiReader(bytes.NewReader(stream.head.Bytes()), stream.body)
+}
+
+// store streams the upload to the storage, and returns its key, the digest of the whole file and the icon.
+// The entries after the head are read for the icon as the storage reads the upload.
+func (stream *BundleStream) store(storage StreamStorage, name, location string) (string, string, *BundleIcon, error) {
+	pr, pw := io.Pipe()
+	stream.source.r = pr
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			entry, err := stream.zip.Next()
+			if err != nil {
+				break
+			}
+			if err := stream.readEntry(entry); err != nil {
+				break
+			}
+		}
+		// the rest is drained, not to block the upload on the pipe
+		io.Copy(ioutil.Discard, pr)
+	}()
+
+	hash := sha256.New()
+	upload := io.MultiReader(bytes.NewReader(stream.head.Bytes()), io.TeeReader(stream.body, pw))
+	key, err := storage.PutStream(io.TeeReader(upload, hash), stream.Size, name, location)
+	pw.CloseWithError(err)
+	<-done
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	var icon *BundleIcon
+	if stream.iconFile != nil {
+		// the icon is only for the notice, so the bundle is created without it
+		if icon, err = newBundleIcon(stream.iconFile); err != nil {
+			icon = nil
+		}
+	}
+	return key, hex.EncodeToString(hash.Sum(nil)), icon, nil
+}
+
+func (stream *BundleStream) readEntry(entry *zipStreamEntry) error {
+	switch {
+	case stream.info == nil && stream.PlatformType == BundlePlatformTypeAndroid && entry.Name == "AndroidManifest.xml":
+		b, err := ioutil.ReadAll(entry)
+		if err != nil {
+			return err
+		}
+		if stream.info, err = apkBundleInfo(b); err != nil {
+			return err
+		}
+	case stream.info == nil && stream.PlatformType == BundlePlatformTypeAndroid && entry.Name == AabManifestName:
+		b, err := ioutil.ReadAll(entry)
+		if err != nil {
+			return err
+		}
+		if stream.info, err = aabBundleInfo(b); err != nil {
+			return err
+		}
+	case stream.info == nil && stream.PlatformType == BundlePlatformTypeIOS && ipaInfoPattern.MatchString(entry.Name):
+		b, err := ioutil.ReadAll(entry)
+		if err != nil {
+			return err
+		}
+		if stream.info, err = ipaBundleInfo(b); err != nil {
+			return err
+		}
+	case stream.PlatformType == BundlePlatformTypeIOS && stream.codeSigning.wants(entry.Name, stream.info):
+		// the signing is only told to the testers, so the upload goes on without it
+		stream.codeSigning.readEntry(entry.Name, entry, stream.info)
+	case isBundleIcon(entry.Name, stream.PlatformType, stream.iconNames()):
+		b, err := ioutil.ReadAll(entry)
+		if err != nil {
+			return err
+		}
+		if rank := bundleIconRank(entry.Name, stream.PlatformType, int64(len(b)), stream.iconNames()); rank > stream.iconRank {
+			stream.iconFile, stream.iconRank = b, rank
+		}
+	}
+	return nil
+}
+
+// iconNames returns the icon names of the manifest or the Info.plist read so far. The icons before it are told by the default names.
+func (stream *BundleStream) iconNames() []string {
+	if stream.info == nil {
+		return nil
+	}
+	return stream.info.IconNames
+}
+
+// a bundleStreamHead keeps what is read from the upload until it exceeds BundleStreamHeadSize.
+type bundleStreamHead struct {
+	bytes.Buffer
+}
+
+func (head *bundleStreamHead) Write(p []byte) (int, error) {
+	n, _ := head.Buffer.Write(p)
+	if head.Len() > BundleStreamHeadSize {
+		return n, errBundleStreamHead
+	}
+	return n, nil
+}
+
+// a switchReader reads from the head of the upload first, and from the pipe of the upload to the storage after it.
+type switchReader struct {
+	r io.Reader
+}
+
+func (s *switchReader) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+// a zipStream reads the entries of a zip archive from their local headers, without the central directory at the end.
+// https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT
+type zipStream struct {
+	r     *bufio.Reader
+	entry *zipStreamEntry
+}
+
+// a zipStreamEntry reads the content of the entry until the next one is read.
+type zipStreamEntry struct {
+	Name       string
+	body       io.Reader
+	compressed *io.LimitedReader
+	descriptor bool
+	zip64      bool
+}
+
+func (entry *zipStreamEntry) Read(p []byte) (int, error) {
+	return entry.body.Read(p)
+}
+
+func newZipStream(r io.Reader) *zipStream {
+	// flate reads no more than the compressed data from a ByteReader
+	return &zipStream{r: bufio.NewReader(r)}
+}
+
+// Next skips the rest of the current entry and returns the next one, or io.EOF at the central directory,
+// or at the APK signing block before it.
+func (z *zipStream) Next() (*zipStreamEntry, error) {
+	if z.entry != nil {
+		if err := z.finish(z.entry); err != nil {
+			return nil, err
+		}
+		z.entry = nil
+	}
+
+	var header [30]byte
+	if _, err := io.ReadFull(z.r, header[:4]); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(header[:4]) != zipLocalHeaderSignature {
+		return nil, io.EOF
+	}
+	if _, err := io.ReadFull(z.r, header[4:]); err != nil {
+		return nil, err
+	}
+	flags := binary.LittleEndian.Uint16(header[6:])
+	method := binary.LittleEndian.Uint16(header[8:])
+	compressedSize := int64(binary.LittleEndian.Uint32(header[18:]))
+	uncompressedSize := binary.LittleEndian.Uint32(header[22:])
+	name := make([]byte, binary.LittleEndian.Uint16(header[26:]))
+	extra := make([]byte, binary.LittleEndian.Uint16(header[28:]))
+	if _, err := io.ReadFull(z.r, name); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(z.r, extra); err != nil {
+		return nil, err
+	}
+
+	entry := &zipStreamEntry{Name: string(name), descriptor: flags&zipFlagDataDescriptor != 0}
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+size > len(extra) {
+			break
+		}
+		if tag == zipExtraZip64 {
+			entry.zip64 = true
+			field := extra[4 : 4+size]
+			// the sizes are in the field only when they are 0xFFFFFFFF in the header
+			if uncompressedSize == 0xFFFFFFFF && len(field) >= 8 {
+				field = field[8:]
+			}
+			if compressedSize == 0xFFFFFFFF && len(field) >= 8 {
+				compressedSize = int64(binary.LittleEndian.Uint64(field))
+			}
+		}
+		extra = extra[4+size:]
+	}
+
+	switch {
+	case method == zipMethodDeflate && entry.descriptor:
+		entry.body = flate.NewReader(z.r)
+	case method == zipMethodDeflate:
+		entry.compressed = &io.LimitedReader{R: z.r, N: compressedSize}
+		entry.body = flate.NewReader(entry.compressed)
+	case method == zipMethodStore && !entry.descriptor:
+		entry.compressed = &io.LimitedReader{R: z.r, N: compressedSize}
+		entry.body = entry.compressed
+	default:
+		return nil, fmt.Errorf("zip entry %s cannot be streamed", entry.Name)
+	}
+	z.entry = entry
+	return entry, nil
+}
+
+// finish reads the entry to the end, and its data descriptor after it.
+func (z *zipStream) finish(entry *zipStreamEntry) error {
+	if _, err := io.Copy(ioutil.Discard, entry.body); err != nil {
+		return err
+	}
+	if entry.compressed != nil {
+		if _, err := io.Copy(ioutil.Discard, entry.compressed); err != nil {
+			return err
+		}
+	}
+	if !entry.descriptor {
+		return nil
+	}
+
+	// the signature of the data descriptor is optional
+	signature, err := z.r.Peek(4)
+	if err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(signature) == zipDataDescriptorSignature {
+		z.r.Discard(4)
+	}
+	size := 12
+	if entry.zip64 {
+		size = 20
+	}
+	_, err = z.r.Discard(size)
+	return err
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// the principals the bundles are uploaded by, recorded as "<kind>:<name>" in uploaded_by
+const (
+	UploaderKindUser = "user"
+	UploaderKindApi  = "api"
+)
+
+var ErrCiJobUrlInvalid = errors.New("ci_job_url must be an http or https URL")
+
+// UserUploader returns the principal of the member uploading from the page.
+func UserUploader(user *User) string {
+	return UploaderKindUser + ":" + user.Email
+}
+
+// ApiUploader returns the principal of the API token, told by its digest as the token usages are,
+// so that the uploads of a token refreshed are told from the ones of the new token.
+func ApiUploader(token string) string {
+	return UploaderKindApi + ":" + TokenDigest(token)
+}
+
+// ValidCiJobUrl tells the URL of the job of the CI which uploads the bundle, which is linked from the page.
+func ValidCiJobUrl(s string) error {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrCiJobUrlInvalid
+	}
+	return nil
+}
+
+// UploaderKind returns "user" or "api", or "" for the bundles uploaded before the uploaders were recorded.
+func (bundle *Bundle) UploaderKind() string {
+	if i := strings.Index(bundle.UploadedBy, ":"); i >= 0 {
+		return bundle.UploadedBy[:i]
+	}
+	return ""
+}
+
+// UploaderName returns the email of the member or the digest of the API token.
+func (bundle *Bundle) UploaderName() string {
+	if i := strings.Index(bundle.UploadedBy, ":"); i >= 0 {
+		return bundle.UploadedBy[i+1:]
+	}
+	return bundle.UploadedBy
+}
+
+// uploaderCondition adds the condition of the uploader to the one of the variant, unless it is empty.
+func uploaderCondition(uploadedBy string, condition string, args []interface{}) (string, []interface{}) {
+	if uploadedBy == "" {
+		return condition, args
+	}
+	return condition + " AND uploaded_by = ?", append(args, uploadedBy)
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/coopernurse/gorp"
+)
+
+var sha256HexPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// a BundleVerification is the result of checking the checksum of an installed build against a bundle.
+// Bundles are the bundles of the app with the checksum, which tell the build installed when it does not match.
+type BundleVerification struct {
+	Digest  string
+	Matched bool
+	Bundles []*Bundle
+}
+
+// NormalizeDigest returns the SHA-256 in lowercase hex without the "sha256:" prefix and the spaces, or "" if it is not one,
+// as the tools print the checksums in different forms, e.g. sha256sum and shasum.
+func NormalizeDigest(digest string) string {
+	digest = strings.ToLower(strings.TrimSpace(digest))
+	digest = strings.TrimPrefix(digest, "sha256:")
+	// sha256sum prints the file name after the checksum
+	if fields := strings.Fields(digest); len(fields) > 0 {
+		digest = fields[0]
+	}
+	if !sha256HexPattern.MatchString(digest) {
+		return ""
+	}
+	return digest
+}
+
+// BundlesByDigest returns the bundles of the app with the checksum, newest first.
+// The bundles uploaded before the checksums were recorded never match.
+func (app *App) BundlesByDigest(txn gorp.SqlExecutor, digest string) ([]*Bundle, error) {
+	var bundles []*Bundle
+	_, err := txn.Select(&bundles, "SELECT * FROM bundle WHERE app_id = ? AND digest = ? ORDER BY id DESC", app.Id, digest)
+	if err != nil {
+		return nil, err
+	}
+	return bundles, nil
+}
+
+// Verify checks the normalized checksum against the bundle.
+// The revisions sharing the file of the bundle match as well, since they are the same build.
+func (bundle *Bundle) Verify(txn gorp.SqlExecutor, digest string) (*BundleVerification, error) {
+	app, err := bundle.App(txn)
+	if err != nil {
+		return nil, err
+	}
+	bundles, err := app.BundlesByDigest(txn, digest)
+	if err != nil {
+		return nil, err
+	}
+	return &BundleVerification{
+		Digest:  digest,
+		Matched: bundle.Digest != "" && bundle.Digest == digest,
+		Bundles: bundles,
+	}, nil
+}
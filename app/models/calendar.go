@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// a ReleasePlan is a publication planned by the developers, shown on the release calendar with the published bundles.
+type ReleasePlan struct {
+	Id        int       `db:"id"`
+	AppId     int       `db:"app_id"`
+	Title     string    `db:"title"`
+	PlannedOn time.Time `db:"planned_on"`
+	UserId    int       `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (plan *ReleasePlan) PreInsert(s gorp.SqlExecutor) error {
+	plan.CreatedAt = time.Now()
+	return nil
+}
+
+func (plan *ReleasePlan) Validate(v *revel.Validation) {
+	v.Required(plan.Title).Message("Title is required.")
+	v.MaxSize(plan.Title, 255).Message("Title is too long.")
+	v.Required(!plan.PlannedOn.IsZero()).Message("Date is required.")
+}
+
+func (plan *ReleasePlan) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(plan)
+}
+
+func (plan *ReleasePlan) DeleteFromDB(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(plan)
+	return err
+}
+
+func GetReleasePlan(txn gorp.SqlExecutor, id int) (*ReleasePlan, error) {
+	plan, err := txn.Get(ReleasePlan{}, id)
+	if err != nil {
+		return nil, err
+	}
+	if plan == nil {
+		return nil, sql.ErrNoRows
+	}
+	return plan.(*ReleasePlan), nil
+}
+
+// UpcomingReleasePlans returns the plans from the day, the earliest first.
+func (app *App) UpcomingReleasePlans(txn gorp.SqlExecutor, since time.Time) ([]*ReleasePlan, error) {
+	var plans []*ReleasePlan
+	_, err := txn.Select(&plans, "SELECT * FROM release_plan WHERE app_id = ? AND planned_on >= ? ORDER BY planned_on ASC, id ASC", app.Id, since)
+	return plans, err
+}
+
+func (app *App) DeleteReleasePlans(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM release_plan WHERE app_id = ?", app.Id)
+	return err
+}
+
+// a CalendarEntry is either a published bundle or a planned release of an app
+type CalendarEntry struct {
+	App    *App
+	Bundle *Bundle
+	Plan   *ReleasePlan
+}
+
+// a CalendarDay has the entries of the day.
+// It is overlapping when several apps publish on the day, for the release managers to coordinate the betas.
+type CalendarDay struct {
+	Date        time.Time
+	Entries     []*CalendarEntry
+	Overlapping bool
+}
+
+// GetReleaseCalendar returns the days in [from, to) with the bundles published and the releases planned on them.
+func GetReleaseCalendar(txn gorp.SqlExecutor, apps []*App, from, to time.Time) ([]*CalendarDay, error) {
+	if len(apps) == 0 {
+		return []*CalendarDay{}, nil
+	}
+
+	appsById := map[int]*App{}
+	args := make([]interface{}, len(apps))
+	quarks := make([]string, len(apps))
+	for i, app := range apps {
+		appsById[app.Id] = app
+		args[i] = app.Id
+		quarks[i] = "?"
+	}
+	in := strings.Join(quarks, ",")
+	args = append(args, from, to)
+
+	var bundles []*Bundle
+	_, err := txn.Select(&bundles, fmt.Sprintf("SELECT * FROM bundle WHERE app_id IN (%s) AND created_at >= ? AND created_at < ? ORDER BY created_at ASC", in), args...)
+	if err != nil {
+		return nil, err
+	}
+	var plans []*ReleasePlan
+	_, err = txn.Select(&plans, fmt.Sprintf("SELECT * FROM release_plan WHERE app_id IN (%s) AND planned_on >= ? AND planned_on < ? ORDER BY planned_on ASC, id ASC", in), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	days := map[string]*CalendarDay{}
+	dayOf := func(t time.Time) *CalendarDay {
+		t = t.In(from.Location())
+		key := t.Format("2006-01-02")
+		day, ok := days[key]
+		if !ok {
+			day = &CalendarDay{Date: time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())}
+			days[key] = day
+		}
+		return day
+	}
+	for _, bundle := range bundles {
+		day := dayOf(bundle.CreatedAt)
+		day.Entries = append(day.Entries, &CalendarEntry{App: appsById[bundle.AppId], Bundle: bundle})
+	}
+	for _, plan := range plans {
+		day := dayOf(plan.PlannedOn)
+		day.Entries = append(day.Entries, &CalendarEntry{App: appsById[plan.AppId], Plan: plan})
+	}
+
+	calendar := []*CalendarDay{}
+	for _, day := range days {
+		appIds := map[int]bool{}
+		for _, entry := range day.Entries {
+			appIds[entry.App.Id] = true
+		}
+		day.Overlapping = len(appIds) > 1
+		calendar = append(calendar, day)
+	}
+	sort.Sort(calendarDays(calendar))
+	return calendar, nil
+}
+
+type calendarDays []*CalendarDay
+
+func (days calendarDays) Len() int           { return len(days) }
+func (days calendarDays) Less(i, j int) bool { return days[i].Date.Before(days[j].Date) }
+func (days calendarDays) Swap(i, j int)      { days[i], days[j] = days[j], days[i] }
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"sort"
+
+	"github.com/coopernurse/gorp"
+)
+
+// a CatalogEntry is an app shown in the catalog with its latest versions
+type CatalogEntry struct {
+	App          *App
+	LatestApk    *Bundle
+	LatestIpa    *Bundle
+	HasAuthority bool
+}
+
+// a CatalogCategory groups the catalog entries of a category. Name is empty for the uncategorized apps.
+type CatalogCategory struct {
+	Name    string
+	Entries []*CatalogEntry
+}
+
+// NewCatalog groups the accessible apps and the listed ones without access by category, sorted by name with
+// the uncategorized apps last. The latest bundles are read only for the accessible apps, so the bundles of the apps
+// without access are never loaded to be hidden afterwards.
+func NewCatalog(txn gorp.SqlExecutor, accessibleApps []*App, listedApps []*App) ([]*CatalogCategory, error) {
+	categories := map[string]*CatalogCategory{}
+	add := func(entry *CatalogEntry) {
+		category, ok := categories[entry.App.Category]
+		if !ok {
+			category = &CatalogCategory{Name: entry.App.Category}
+			categories[entry.App.Category] = category
+		}
+		category.Entries = append(category.Entries, entry)
+	}
+
+	for _, app := range accessibleApps {
+		latestApk, err := app.LatestBundle(txn, BundlePlatformTypeAndroid, "")
+		if err != nil {
+			return nil, err
+		}
+		latestIpa, err := app.LatestBundle(txn, BundlePlatformTypeIOS, "")
+		if err != nil {
+			return nil, err
+		}
+		add(&CatalogEntry{
+			App:          app,
+			LatestApk:    latestApk,
+			LatestIpa:    latestIpa,
+			HasAuthority: true,
+		})
+	}
+	for _, app := range listedApps {
+		add(&CatalogEntry{App: app})
+	}
+
+	catalog := []*CatalogCategory{}
+	for _, category := range categories {
+		catalog = append(catalog, category)
+	}
+	sort.Sort(catalogCategories(catalog))
+	return catalog, nil
+}
+
+type catalogCategories []*CatalogCategory
+
+func (c catalogCategories) Len() int      { return len(c) }
+func (c catalogCategories) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c catalogCategories) Less(i, j int) bool {
+	if (c[i].Name == "") != (c[j].Name == "") {
+		return c[j].Name == ""
+	}
+	return c[i].Name < c[j].Name
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// a Cdn signs the URLs of the files on the CDN in front of the S3 bucket, so that the downloads do not go through the server.
+// The CDN serves the keys of the bucket as the paths, i.e. its origin is the bucket without an origin path,
+// and the file names at the end of the paths are the ones the files are saved as.
+type Cdn interface {
+	SignedURL(key string, expiry time.Duration) (string, error)
+}
+
+// cdnURL returns the URL of the key on the CDN with the escaped path, which the signature is of.
+func cdnURL(baseUrl, key string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimRight(baseUrl, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u.Path += "/" + key
+	return u, nil
+}
+
+// ----------------------------------------------------------------------
+// CloudFront
+
+// CloudFront signs the URLs with the canned policy of the key pair of the trusted key group.
+// https://docs.aws.amazon.com/AmazonCloudFront/latest/DeveloperGuide/private-content-creating-signed-url-canned-policy.html
+type CloudFront struct {
+	BaseUrl    string
+	KeyPairId  string
+	PrivateKey *rsa.PrivateKey
+}
+
+// NewCloudFront parses the RSA private key of the key pair in PEM, PKCS #1 or PKCS #8.
+func NewCloudFront(baseUrl, keyPairId string, privateKey []byte) (*CloudFront, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errors.New("cloudfront: private key is not PEM encoded")
+	}
+
+	var key *rsa.PrivateKey
+	if block.Type == "RSA PRIVATE KEY" {
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	} else {
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("cloudfront: private key is not RSA")
+		}
+		key = rsaKey
+	}
+	return &CloudFront{BaseUrl: baseUrl, KeyPairId: keyPairId, PrivateKey: key}, nil
+}
+
+func (cf *CloudFront) SignedURL(key string, expiry time.Duration) (string, error) {
+	u, err := cdnURL(cf.BaseUrl, key)
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(expiry).Unix()
+	policy := fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`, u.String(), expires)
+	hash := sha1.Sum([]byte(policy))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, cf.PrivateKey, crypto.SHA1, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("Expires", fmt.Sprint(expires))
+	query.Set("Signature", cloudFrontBase64(signature))
+	query.Set("Key-Pair-Id", cf.KeyPairId)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// cloudFrontBase64 replaces the characters of base64 which are invalid in the query.
+func cloudFrontBase64(b []byte) string {
+	return strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(b))
+}
+
+// ----------------------------------------------------------------------
+// Fastly
+
+// Fastly signs the URLs with the token of the expiry and the HMAC-SHA256 of the path and the expiry, which the service
+// validates in VCL with the shared secret, as in the URL token validation of Fastly.
+// https://docs.fastly.com/en/guides/enabling-url-token-validation
+type Fastly struct {
+	BaseUrl string
+	Secret  string
+}
+
+func NewFastly(baseUrl, secret string) *Fastly {
+	return &Fastly{BaseUrl: baseUrl, Secret: secret}
+}
+
+// SignedURL adds the token as `token=<expiry>_<hex of HMAC-SHA256(secret, path + expiry)>`, the expiry in the Unix time.
+func (f *Fastly) SignedURL(key string, expiry time.Duration) (string, error) {
+	u, err := cdnURL(f.BaseUrl, key)
+	if err != nil {
+		return "", err
+	}
+
+	expires := fmt.Sprint(time.Now().Add(expiry).Unix())
+	mac := hmac.New(sha256.New, []byte(f.Secret))
+	mac.Write([]byte(u.EscapedPath() + expires))
+
+	query := url.Values{}
+	query.Set("token", expires+"_"+hex.EncodeToString(mac.Sum(nil)))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
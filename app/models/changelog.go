@@ -0,0 +1,109 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// a Changelog is the description of a bundle in another language than the one of the description,
+// for the testers who read the other language
+type Changelog struct {
+	Id        int       `db:"id"`
+	BundleId  int       `db:"bundle_id"`
+	Language  string    `db:"language"`
+	Text      string    `db:"text"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+func (changelog *Changelog) PreInsert(s gorp.SqlExecutor) error {
+	changelog.CreatedAt = time.Now()
+	changelog.UpdatedAt = changelog.CreatedAt
+	return nil
+}
+
+func (changelog *Changelog) PreUpdate(s gorp.SqlExecutor) error {
+	changelog.UpdatedAt = time.Now()
+	return nil
+}
+
+func (bundle *Bundle) Changelogs(txn gorp.SqlExecutor) ([]*Changelog, error) {
+	var changelogs []*Changelog
+	_, err := txn.Select(&changelogs, "SELECT * FROM changelog WHERE bundle_id = ? ORDER BY id ASC", bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	return changelogs, nil
+}
+
+// ChangelogTexts returns the texts of the changelogs by the language.
+func (bundle *Bundle) ChangelogTexts(txn gorp.SqlExecutor) (map[string]string, error) {
+	changelogs, err := bundle.Changelogs(txn)
+	if err != nil {
+		return nil, err
+	}
+	texts := map[string]string{}
+	for _, changelog := range changelogs {
+		texts[changelog.Language] = changelog.Text
+	}
+	return texts, nil
+}
+
+// SaveChangelogs sets the changelogs of the bundle in the languages of the texts, deleting the ones of the empty texts.
+// The changelogs in the other languages are kept.
+func (bundle *Bundle) SaveChangelogs(txn gorp.SqlExecutor, texts map[string]string) error {
+	for language, text := range texts {
+		if _, err := txn.Exec("DELETE FROM changelog WHERE bundle_id = ? AND language = ?", bundle.Id, language); err != nil {
+			return err
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if err := txn.Insert(&Changelog{BundleId: bundle.Id, Language: language, Text: text}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bundle *Bundle) DeleteChangelogs(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM changelog WHERE bundle_id = ?", bundle.Id)
+	return err
+}
+
+// LocalizedDescription returns the description of the bundle in the first of the preferred languages it is written in,
+// with its language. It falls back to the description, which is in the default language, and to the first changelog
+// when the description is empty.
+func (bundle *Bundle) LocalizedDescription(texts map[string]string, preferred []string, defaultLanguage string) (string, string) {
+	for _, language := range preferred {
+		language = NormalizeLanguage(language)
+		if language == defaultLanguage && bundle.Description != "" {
+			return bundle.Description, defaultLanguage
+		}
+		if text := texts[language]; text != "" {
+			return text, language
+		}
+	}
+	if bundle.Description != "" || len(texts) == 0 {
+		return bundle.Description, defaultLanguage
+	}
+	// the map is not ordered, so the languages are in the order of the names
+	var first string
+	for language := range texts {
+		if first == "" || language < first {
+			first = language
+		}
+	}
+	return texts[first], first
+}
+
+// NormalizeLanguage returns the primary language of the tag, e.g. en of en-US, as the changelogs are by the language.
+func NormalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	return tag
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the words are short and easy to tell apart when spoken, for the testers to talk about the builds
+var (
+	codenameAdjectives = []string{
+		"amber", "bold", "brave", "bright", "calm", "clever", "cosmic", "crisp", "dapper", "eager",
+		"fancy", "fluffy", "gentle", "giant", "golden", "happy", "jolly", "kind", "lively", "lucky",
+		"mellow", "mighty", "misty", "noble", "plucky", "proud", "quick", "quiet", "rapid", "rusty",
+		"shiny", "silent", "silver", "sleepy", "snowy", "sunny", "swift", "tiny", "witty", "zesty",
+	}
+	codenameAnimals = []string{
+		"badger", "beaver", "bison", "camel", "cobra", "coyote", "crane", "dingo", "dolphin", "eagle",
+		"falcon", "ferret", "gecko", "hippo", "husky", "iguana", "jaguar", "koala", "lemur", "lynx",
+		"marmot", "moose", "narwhal", "ocelot", "otter", "panda", "parrot", "penguin", "puffin", "rabbit",
+		"raccoon", "salmon", "seal", "sloth", "tiger", "toucan", "turtle", "walrus", "wombat", "zebra",
+	}
+)
+
+// the tries to find a codename not used in the app, after which the number has another digit
+const codenameMaxTries = 10
+
+// the source is seeded not to give the same codenames after every restart, and locked as it is not safe for the goroutines
+var codenameRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func randomCodename(max int) string {
+	codenameRand.Lock()
+	defer codenameRand.Unlock()
+	return fmt.Sprintf("%s-%s-%d",
+		codenameAdjectives[codenameRand.Intn(len(codenameAdjectives))],
+		codenameAnimals[codenameRand.Intn(len(codenameAnimals))],
+		codenameRand.Intn(max))
+}
+
+// NewCodename returns the codename such as brave-otter-42 which no other bundle of the app has.
+func (app *App) NewCodename(txn gorp.SqlExecutor) (string, error) {
+	max := 100
+	for i := 0; ; i++ {
+		if i == codenameMaxTries {
+			max *= 10
+			i = 0
+		}
+		codename := randomCodename(max)
+		count, err := txn.SelectInt("SELECT COUNT(*) FROM bundle WHERE app_id = ? AND codename = ?", app.Id, codename)
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return codename, nil
+		}
+	}
+}
+
+// VersionLabel returns the version and the revision with the codename, e.g. 1.0 #3 (brave-otter-42), to show the bundle by.
+// The bundles uploaded before the codenames have none.
+func (bundle *Bundle) VersionLabel() string {
+	label := fmt.Sprintf("%s #%d", bundle.BundleVersion, bundle.Revision)
+	if bundle.Codename != "" {
+		label += fmt.Sprintf(" (%s)", bundle.Codename)
+	}
+	return label
+}
+
+// GetBundleByCodename finds the bundle of the app by the codename a tester tells.
+func (app *App) GetBundleByCodename(txn gorp.SqlExecutor, codename string) (*Bundle, error) {
+	var bundle Bundle
+	if err := txn.SelectOne(&bundle, "SELECT * FROM bundle WHERE app_id = ? AND codename = ?", app.Id, codename); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
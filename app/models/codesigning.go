@@ -0,0 +1,215 @@
+package models
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	machoCodeSignature = 0x1d
+
+	csSuperBlobMagic   = 0xfade0cc0
+	csBlobWrapperMagic = 0xfade0b01
+	csSlotSignature    = 0x10000
+
+	// the code directories have the hashes of the pages, about 1MB for an executable of 100MB
+	maxCodeSignatureSize = 16 * 1024 * 1024
+)
+
+// an ipaCodeSigning collects from the entries of an ipa how the app is signed: the team and the type of the provisioning profile,
+// and the certificate of the signing identity the executable is signed with.
+type ipaCodeSigning struct {
+	profile     *provisioningProfile
+	certificate *x509.Certificate
+	// the UUID of the executable, which the dSYM of the build has
+	uuid string
+}
+
+// CodeSigned tells whether the signing identity of the ipa is known, for the bundles uploaded before it was recorded.
+func (bundle *Bundle) CodeSigned() bool {
+	return bundle.SigningIdentity != "" || bundle.ProfileType != ""
+}
+
+// ProfileExpiresWithin tells whether the provisioning profile of the ipa expires within the days,
+// when the testers can no longer launch the app installed.
+func (bundle *Bundle) ProfileExpiresWithin(days int) bool {
+	if bundle.ProfileExpiresAt.IsZero() {
+		return false
+	}
+	return bundle.ProfileExpiresAt.Before(time.Now().AddDate(0, 0, days))
+}
+
+// readIpaCodeSigning reads the provisioning profile and the code signature of the executable told by Info.plist.
+// It returns what is read with the error of the entry failed, e.g. the profile of the executable signed ad hoc.
+func readIpaCodeSigning(file *os.File, bundleInfo *BundleInfo) (*ipaCodeSigning, error) {
+	signing := &ipaCodeSigning{}
+	stat, err := file.Stat()
+	if err != nil {
+		return signing, err
+	}
+	reader, err := zip.NewReader(file, stat.Size())
+	if err != nil {
+		return signing, err
+	}
+
+	var entryErr error
+	for _, f := range reader.File {
+		if !signing.wants(f.Name, bundleInfo) {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return signing, err
+		}
+		if err := signing.readEntry(f.Name, r, bundleInfo); err != nil {
+			entryErr = err
+		}
+		r.Close()
+	}
+	return signing, entryErr
+}
+
+// wants tells whether the entry is the provisioning profile or the executable of the app.
+func (signing *ipaCodeSigning) wants(name string, bundleInfo *BundleInfo) bool {
+	if ipaProfilePattern.MatchString(name) {
+		return true
+	}
+	return isIpaExecutable(name, bundleInfo)
+}
+
+func (signing *ipaCodeSigning) readEntry(name string, r io.Reader, bundleInfo *BundleInfo) error {
+	if ipaProfilePattern.MatchString(name) {
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		signing.profile, err = parseProvisioningProfile(buf)
+		return err
+	}
+	var err error
+	signing.uuid, signing.certificate, err = machoCodeSigning(r)
+	return err
+}
+
+// apply records the signing of the ipa on the bundle. The identity is told by the profile too when it has only one certificate,
+// e.g. for the executable read before Info.plist in the stream.
+func (signing *ipaCodeSigning) apply(bundle *Bundle) {
+	bundle.ExecutableUuid = signing.uuid
+	certificate := signing.certificate
+	if profile := signing.profile; profile != nil {
+		bundle.ProfileType = profile.Type()
+		bundle.ProfileExpiresAt = profile.ExpirationDate
+		if len(profile.TeamIdentifier) > 0 {
+			bundle.SigningTeamId = profile.TeamIdentifier[0]
+		}
+		if certificate == nil && len(profile.DeveloperCertificates) == 1 {
+			certificate, _ = x509.ParseCertificate(profile.DeveloperCertificates[0])
+		}
+	}
+	if certificate == nil {
+		return
+	}
+	bundle.SigningIdentity = certificate.Subject.CommonName
+	// the certificates of Apple have the team ID in the organizational unit
+	if bundle.SigningTeamId == "" && len(certificate.Subject.OrganizationalUnit) > 0 {
+		bundle.SigningTeamId = certificate.Subject.OrganizationalUnit[0]
+	}
+}
+
+// machoCodeSigning reads the UUID and the certificate of the CMS signature in the code signature of the Mach-O executable,
+// the first architecture of a universal one. The executable is read through once, as it is deflated in the ipa.
+// The UUID is returned with the error of an executable not signed.
+func machoCodeSigning(r io.Reader) (string, *x509.Certificate, error) {
+	macho := &countingReader{r: bufio.NewReader(r)}
+
+	var magic uint32
+	if err := binary.Read(macho, binary.BigEndian, &magic); err != nil {
+		return "", nil, err
+	}
+	if magic == machoFatMagic || magic == machoFatMagic64 {
+		offsets, err := readMachoFat(macho, magic)
+		if err != nil {
+			return "", nil, err
+		}
+		if err := macho.skipTo(int64(offsets[0])); err != nil {
+			return "", nil, err
+		}
+		macho.n = 0
+		if err := binary.Read(macho, binary.BigEndian, &magic); err != nil {
+			return "", nil, err
+		}
+	}
+	commands, err := readMachoCommands(macho, magic)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var uuid string
+	var dataOffset, dataSize uint32
+	err = eachMachoCommand(commands, func(cmd uint32, command []byte) {
+		switch {
+		case cmd == machoUuid && len(command) >= 24:
+			uuid = formatMachoUuid(command[8:24])
+		case cmd == machoCodeSignature && len(command) >= 16:
+			dataOffset = binary.LittleEndian.Uint32(command[8:])
+			dataSize = binary.LittleEndian.Uint32(command[12:])
+		}
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if dataSize == 0 {
+		return uuid, nil, errors.New("the executable is not signed")
+	}
+	if dataSize > maxCodeSignatureSize {
+		return uuid, nil, errors.New("the code signature of the executable is too large")
+	}
+	if err := macho.skipTo(int64(dataOffset)); err != nil {
+		return uuid, nil, err
+	}
+	signature := make([]byte, dataSize)
+	if _, err := io.ReadFull(macho, signature); err != nil {
+		return uuid, nil, err
+	}
+	certificate, err := codeSignatureCertificate(signature)
+	return uuid, certificate, err
+}
+
+// codeSignatureCertificate finds the CMS signature in the super blob of the code signature, which the ad hoc signatures do not have.
+func codeSignatureCertificate(superBlob []byte) (*x509.Certificate, error) {
+	if len(superBlob) < 12 || binary.BigEndian.Uint32(superBlob) != csSuperBlobMagic {
+		return nil, errors.New("the code signature is not a super blob")
+	}
+	count := binary.BigEndian.Uint32(superBlob[8:])
+	if uint64(count)*8 > uint64(len(superBlob)-12) {
+		return nil, errors.New("the code signature is truncated")
+	}
+	for i := uint32(0); i < count; i++ {
+		index := superBlob[12+i*8:]
+		if binary.BigEndian.Uint32(index) != csSlotSignature {
+			continue
+		}
+		offset := binary.BigEndian.Uint32(index[4:])
+		if uint64(offset)+8 > uint64(len(superBlob)) {
+			return nil, errors.New("the code signature is truncated")
+		}
+		blob := superBlob[offset:]
+		length := binary.BigEndian.Uint32(blob[4:])
+		if binary.BigEndian.Uint32(blob) != csBlobWrapperMagic || length < 8 || uint64(length) > uint64(len(blob)) {
+			return nil, errors.New("the CMS signature of the code signature is broken")
+		}
+		if length == 8 {
+			break
+		}
+		certificate, _, err := parsePkcs7(blob[8:length])
+		return certificate, err
+	}
+	return nil, errors.New("the executable is signed ad hoc")
+}
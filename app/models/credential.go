@@ -0,0 +1,66 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// the credentials of the external services are sealed with the key of the env files. The prefix tells them from the
+// ones saved before they were sealed, which are read as they are until they are sealed at the start of the server.
+const sealedCredentialPrefix = "sealed:"
+
+var ErrCredentialBroken = errors.New("the credential cannot be decrypted")
+
+// SealCredential encrypts the credential with AES-GCM as the env files are, bound to the app and the column so that
+// the sealed one cannot be moved to another. The empty one and the one sealed already are returned as they are.
+func SealCredential(key []byte, appId int, column, credential string) (string, error) {
+	if credential == "" || IsSealedCredential(credential) {
+		return credential, nil
+	}
+	aead, err := envFileCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(credential), credentialAdditionalData(appId, column))
+	return sealedCredentialPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenCredential decrypts the credential, or returns the one saved before they were sealed as it is.
+func OpenCredential(key []byte, appId int, column, credential string) (string, error) {
+	if !IsSealedCredential(credential) {
+		return credential, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(credential, sealedCredentialPrefix))
+	if err != nil {
+		return "", ErrCredentialBroken
+	}
+	aead, err := envFileCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrCredentialBroken
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	opened, err := aead.Open(nil, nonce, sealed, credentialAdditionalData(appId, column))
+	if err != nil {
+		return "", ErrCredentialBroken
+	}
+	return string(opened), nil
+}
+
+func IsSealedCredential(credential string) bool {
+	return strings.HasPrefix(credential, sealedCredentialPrefix)
+}
+
+func credentialAdditionalData(appId int, column string) []byte {
+	return []byte("app:" + strconv.Itoa(appId) + ":" + column)
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// the rows of a page are at most the number, however many are asked for, as the events are
+const CursorMaxLimit = 1000
+
+var ErrInvalidCursor = errors.New("Cursor is invalid.")
+
+// EncodeCursor makes the opaque cursor of the next page from the ID of the last row of the page.
+// The next page starts past the ID, so the rows inserted meanwhile do not shift it as they do an offset.
+func EncodeCursor(id int) string {
+	if id == 0 {
+		return ""
+	}
+	return base64.URLEncoding.EncodeToString([]byte("id:" + strconv.Itoa(id)))
+}
+
+// DecodeCursor returns the ID of the cursor, or 0 for the empty cursor of the first page.
+func DecodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	decoded, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(decoded), "id:") {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(string(decoded), "id:"))
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
+
+// CursorLimit returns the number of the rows of a page, the default one for 0.
+func CursorLimit(limit, defaultLimit int) int {
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > CursorMaxLimit {
+		limit = CursorMaxLimit
+	}
+	return limit
+}
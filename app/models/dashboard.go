@@ -0,0 +1,77 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/coopernurse/gorp"
+)
+
+// a DashboardApp is an app of the home with the latest bundle of each platform, to tell what was uploaded without opening it
+type DashboardApp struct {
+	*App
+	LatestBundles []*Bundle
+}
+
+// a DashboardAccessRequest is a pending request to an app the user can approve it of
+type DashboardAccessRequest struct {
+	*AccessRequest
+	AppTitle string
+}
+
+// NewDashboardApps reads the latest bundles of every app in a query, as the home lists all the apps of the user.
+// The recalled bundles are skipped, as the latest bundle of the app page is.
+func NewDashboardApps(txn gorp.SqlExecutor, apps []*App) ([]*DashboardApp, error) {
+	dashboardApps := make([]*DashboardApp, len(apps))
+	if len(apps) == 0 {
+		return dashboardApps, nil
+	}
+
+	quarks := make([]string, len(apps))
+	args := make([]interface{}, len(apps))
+	indexes := map[int]int{}
+	for i, app := range apps {
+		quarks[i] = "?"
+		args[i] = app.Id
+		indexes[app.Id] = i
+		dashboardApps[i] = &DashboardApp{App: app, LatestBundles: []*Bundle{}}
+	}
+
+	var bundles []*Bundle
+	_, err := txn.Select(&bundles, `SELECT * FROM bundle WHERE id IN
+		(SELECT MAX(id) FROM bundle WHERE app_id IN (`+strings.Join(quarks, ",")+`) AND file_id <> '' AND recalled = 0 GROUP BY app_id, platform_type)
+		ORDER BY platform_type`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, bundle := range bundles {
+		dashboardApp := dashboardApps[indexes[bundle.AppId]]
+		dashboardApp.LatestBundles = append(dashboardApp.LatestBundles, bundle)
+	}
+	return dashboardApps, nil
+}
+
+// GetDashboardAccessRequests returns the pending requests to the apps the email is a member of other than a tester, the oldest first.
+func GetDashboardAccessRequests(txn gorp.SqlExecutor, email string) ([]*DashboardAccessRequest, error) {
+	var requests []*AccessRequest
+	_, err := txn.Select(&requests, `SELECT access_request.* FROM access_request
+		INNER JOIN authority ON authority.app_id = access_request.app_id
+		WHERE authority.email = ? AND authority.role <> ? AND access_request.state = ? ORDER BY access_request.id ASC`,
+		email, AuthorityRoleTester, AccessRequestStatePending)
+	if err != nil {
+		return nil, err
+	}
+
+	titles := map[int]string{}
+	dashboardRequests := make([]*DashboardAccessRequest, len(requests))
+	for i, request := range requests {
+		if _, ok := titles[request.AppId]; !ok {
+			app, err := GetApp(txn, request.AppId)
+			if err != nil {
+				return nil, err
+			}
+			titles[request.AppId] = app.Title
+		}
+		dashboardRequests[i] = &DashboardAccessRequest{AccessRequest: request, AppTitle: titles[request.AppId]}
+	}
+	return dashboardRequests, nil
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// the placeholders of the description template of an app, filled at the upload
+const (
+	DescriptionPlaceholderVersion = "{version}"
+	DescriptionPlaceholderBranch  = "{branch}"
+	DescriptionPlaceholderCommit  = "{commit}"
+	DescriptionPlaceholderDate    = "{date}"
+)
+
+// FillDescriptionTemplate returns the description template of the app with the placeholders filled for the bundle:
+// the version of the bundle, the branch and the commit given to the upload, and the date of the upload.
+// The unknown placeholders are left as they are, and the ones without a value are emptied.
+func (app *App) FillDescriptionTemplate(bundle *Bundle, now time.Time) string {
+	return strings.NewReplacer(
+		DescriptionPlaceholderVersion, bundle.BundleInfo.Version,
+		DescriptionPlaceholderBranch, bundle.Branch,
+		DescriptionPlaceholderCommit, bundle.Commit,
+		DescriptionPlaceholderDate, now.Format("2006-01-02"),
+	).Replace(app.DescriptionTemplate)
+}
@@ -0,0 +1,337 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+const (
+	DeviceFarmProviderBrowserStack = "browserstack"
+	DeviceFarmProviderTestLab      = "testlab"
+)
+
+const (
+	DeviceFarmStateUploading = "UPLOADING"
+	DeviceFarmStateRunning   = "RUNNING"
+	DeviceFarmStatePassed    = "PASSED"
+	DeviceFarmStateFailed    = "FAILED"
+	DeviceFarmStateError     = "ERROR"
+)
+
+// a DeviceFarm is the account of a device farm which the bundles of an app are tested on as they are uploaded.
+// BrowserStack App Automate runs the test suite uploaded to it beforehand, and Firebase Test Lab runs the robo test,
+// which needs no test suite.
+type DeviceFarm struct {
+	Id       int    `db:"id"`
+	AppId    int    `db:"app_id"`
+	Provider string `db:"provider"`
+	// the user name and the access key of BrowserStack
+	Username  string `db:"username"`
+	AccessKey string `db:"access_key"`
+	// the bs:// URL of the Espresso or the XCUITest suite on BrowserStack
+	TestSuite string `db:"test_suite"`
+	// the service account key of the Google Cloud project of Test Lab, and the bucket to upload the bundles and the results to
+	ServiceAccountKey string `db:"service_account_key"`
+	Bucket            string `db:"bucket"`
+	// the devices to run the tests on per line, e.g. "Google Pixel 7-13.0" on BrowserStack and "redfin:30" on Test Lab
+	Devices   string    `db:"devices"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// a DeviceFarmClient starts the test run of a bundle on the device farm and reads its state.
+type DeviceFarmClient interface {
+	// Start returns the id of the run and the link to its result.
+	Start(bundle *Bundle, filename string, file io.Reader) (string, string, error)
+	// State returns the state of the run, the message of its result and the link to it if it is known by then.
+	State(bundle *Bundle, runId string) (string, string, string, error)
+}
+
+func (farm *DeviceFarm) PreInsert(s gorp.SqlExecutor) error {
+	farm.CreatedAt = time.Now()
+	farm.UpdatedAt = farm.CreatedAt
+	return nil
+}
+
+func (farm *DeviceFarm) PreUpdate(s gorp.SqlExecutor) error {
+	farm.UpdatedAt = time.Now()
+	return nil
+}
+
+func (farm *DeviceFarm) Validate(v *revel.Validation) {
+	switch farm.Provider {
+	case DeviceFarmProviderBrowserStack:
+		v.Required(farm.Username).Message("BrowserStack username is required.")
+		v.Required(farm.AccessKey).Message("BrowserStack access key is required.")
+		v.Required(farm.TestSuite).Message("BrowserStack test suite is required.")
+	case DeviceFarmProviderTestLab:
+		v.Required(farm.ServiceAccountKey).Message("Service account key is required.")
+		v.Required(farm.Bucket).Message("Bucket is required.")
+	default:
+		v.Error("Device farm provider is not valid.")
+	}
+	v.Required(farm.Devices).Message("Devices are required.")
+}
+
+// Save inserts the device farm, or replaces the one of the provider of the app if already exists.
+func (farm *DeviceFarm) Save(txn gorp.SqlExecutor) error {
+	current, err := GetDeviceFarm(txn, farm.AppId, farm.Provider)
+	if err == sql.ErrNoRows {
+		return txn.Insert(farm)
+	}
+	if err != nil {
+		return err
+	}
+
+	current.Username = farm.Username
+	current.AccessKey = farm.AccessKey
+	current.TestSuite = farm.TestSuite
+	current.ServiceAccountKey = farm.ServiceAccountKey
+	current.Bucket = farm.Bucket
+	current.Devices = farm.Devices
+
+	_, err = txn.Update(current)
+	return err
+}
+
+func (farm *DeviceFarm) DeleteFromDB(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(farm)
+	return err
+}
+
+// Seal encrypts the access key and the service account key to save them.
+func (farm *DeviceFarm) Seal(secret []byte) error {
+	accessKey, err := SealCredential(secret, farm.AppId, "device_farm.access_key", farm.AccessKey)
+	if err != nil {
+		return err
+	}
+	serviceAccountKey, err := SealCredential(secret, farm.AppId, "device_farm.service_account_key", farm.ServiceAccountKey)
+	if err != nil {
+		return err
+	}
+	farm.AccessKey = accessKey
+	farm.ServiceAccountKey = serviceAccountKey
+	return nil
+}
+
+// Open decrypts the access key and the service account key saved, to sign in to the provider with.
+func (farm *DeviceFarm) Open(secret []byte) error {
+	accessKey, err := OpenCredential(secret, farm.AppId, "device_farm.access_key", farm.AccessKey)
+	if err != nil {
+		return err
+	}
+	serviceAccountKey, err := OpenCredential(secret, farm.AppId, "device_farm.service_account_key", farm.ServiceAccountKey)
+	if err != nil {
+		return err
+	}
+	farm.AccessKey = accessKey
+	farm.ServiceAccountKey = serviceAccountKey
+	return nil
+}
+
+// DeviceList returns the devices of the lines, without the blank ones.
+func (farm *DeviceFarm) DeviceList() []string {
+	devices := []string{}
+	for _, line := range strings.Split(farm.Devices, "\n") {
+		if device := strings.TrimSpace(line); device != "" {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// ProviderName returns the name of the provider to show.
+func (farm *DeviceFarm) ProviderName() string {
+	return DeviceFarmProviderName(farm.Provider)
+}
+
+// ServiceAccountConfig parses the JSON key downloaded from the Google Cloud console, with the project of the key.
+func (farm *DeviceFarm) ServiceAccountConfig() (*ServiceAccountConfig, string, error) {
+	var key struct {
+		ProjectId   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal([]byte(farm.ServiceAccountKey), &key); err != nil {
+		return nil, "", err
+	}
+	if key.ProjectId == "" || key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, "", errors.New("test lab: service account key has no project_id, client_email or private_key")
+	}
+
+	return &ServiceAccountConfig{
+		ClientEmail: key.ClientEmail,
+		PrivateKey:  key.PrivateKey,
+		Scope:       []string{TestLabScope},
+	}, key.ProjectId, nil
+}
+
+// Client returns the client of the provider with the keys opened by the secret, which signs in to Google Cloud for Test Lab.
+func (farm *DeviceFarm) Client(secret []byte) (DeviceFarmClient, error) {
+	opened := *farm
+	if err := opened.Open(secret); err != nil {
+		return nil, err
+	}
+	return opened.client()
+}
+
+func (farm *DeviceFarm) client() (DeviceFarmClient, error) {
+	switch farm.Provider {
+	case DeviceFarmProviderBrowserStack:
+		return NewBrowserStack(farm.Username, farm.AccessKey, farm.TestSuite, farm.DeviceList()), nil
+	case DeviceFarmProviderTestLab:
+		config, projectId, err := farm.ServiceAccountConfig()
+		if err != nil {
+			return nil, err
+		}
+		token, err := GetServiceAccountToken(config)
+		if err != nil {
+			return nil, err
+		}
+		return NewTestLab(token, projectId, farm.Bucket, farm.DeviceList()), nil
+	}
+	return nil, errors.New("device farm provider is not valid")
+}
+
+func DeviceFarmProviderName(provider string) string {
+	switch provider {
+	case DeviceFarmProviderBrowserStack:
+		return "BrowserStack"
+	case DeviceFarmProviderTestLab:
+		return "Firebase Test Lab"
+	}
+	return provider
+}
+
+func GetDeviceFarm(txn gorp.SqlExecutor, appId int, provider string) (*DeviceFarm, error) {
+	var farm DeviceFarm
+	if err := txn.SelectOne(&farm, "SELECT * FROM device_farm WHERE app_id = ? AND provider = ?", appId, provider); err != nil {
+		return nil, err
+	}
+	return &farm, nil
+}
+
+// SealDeviceFarms seals the keys of the device farms saved before they were sealed, and returns how many it sealed.
+func SealDeviceFarms(txn gorp.SqlExecutor, secret []byte) (int, error) {
+	var farms []*DeviceFarm
+	_, err := txn.Select(&farms, "SELECT * FROM device_farm WHERE (access_key <> '' AND access_key NOT LIKE ?) OR (service_account_key <> '' AND service_account_key NOT LIKE ?)",
+		sealedCredentialPrefix+"%", sealedCredentialPrefix+"%")
+	if err != nil {
+		return 0, err
+	}
+	for _, farm := range farms {
+		if err := farm.Seal(secret); err != nil {
+			return 0, err
+		}
+		if _, err := txn.Update(farm); err != nil {
+			return 0, err
+		}
+	}
+	return len(farms), nil
+}
+
+func (app *App) DeviceFarms(txn gorp.SqlExecutor) ([]*DeviceFarm, error) {
+	var farms []*DeviceFarm
+	_, err := txn.Select(&farms, "SELECT * FROM device_farm WHERE app_id = ? ORDER BY id ASC", app.Id)
+	if err != nil {
+		return nil, err
+	}
+	return farms, nil
+}
+
+// ----------------------------------------------------------------------
+// DeviceFarmRun
+
+// a DeviceFarmRun records the test run of a bundle on a device farm, with the link to its result.
+type DeviceFarmRun struct {
+	Id        int       `db:"id"`
+	BundleId  int       `db:"bundle_id"`
+	Provider  string    `db:"provider"`
+	RunId     string    `db:"run_id"`
+	Url       string    `db:"url"`
+	State     string    `db:"state"`
+	Message   string    `db:"message"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+func (run *DeviceFarmRun) PreInsert(s gorp.SqlExecutor) error {
+	run.CreatedAt = time.Now()
+	run.UpdatedAt = run.CreatedAt
+	return nil
+}
+
+func (run *DeviceFarmRun) PreUpdate(s gorp.SqlExecutor) error {
+	run.UpdatedAt = time.Now()
+	return nil
+}
+
+func (run *DeviceFarmRun) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(run)
+}
+
+func (run *DeviceFarmRun) Update(txn gorp.SqlExecutor) error {
+	_, err := txn.Update(run)
+	return err
+}
+
+// Touch keeps the run claimed while the bundle is uploaded.
+func (run *DeviceFarmRun) Touch(txn gorp.SqlExecutor) error {
+	return touchUpload(txn, "device_farm_run", run.Id, DeviceFarmStateUploading, time.Now())
+}
+
+func (run *DeviceFarmRun) IsFinished() bool {
+	return run.State == DeviceFarmStatePassed || run.State == DeviceFarmStateFailed || run.State == DeviceFarmStateError
+}
+
+func (run *DeviceFarmRun) ProviderName() string {
+	return DeviceFarmProviderName(run.Provider)
+}
+
+// DeviceFarmRuns returns the runs of the bundle, the latest first.
+func (bundle *Bundle) DeviceFarmRuns(txn gorp.SqlExecutor) ([]*DeviceFarmRun, error) {
+	var runs []*DeviceFarmRun
+	_, err := txn.Select(&runs, "SELECT * FROM device_farm_run WHERE bundle_id = ? ORDER BY id DESC", bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (bundle *Bundle) DeleteDeviceFarmRuns(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM device_farm_run WHERE bundle_id = ?", bundle.Id)
+	return err
+}
+
+func GetRunningDeviceFarmRuns(txn gorp.SqlExecutor) ([]*DeviceFarmRun, error) {
+	var runs []*DeviceFarmRun
+	_, err := txn.Select(&runs, "SELECT * FROM device_farm_run WHERE state = ? ORDER BY id ASC", DeviceFarmStateRunning)
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// ClaimStaleDeviceFarmRuns takes the runs left uploading by a server stopped, to upload them again.
+func ClaimStaleDeviceFarmRuns(txn gorp.SqlExecutor, now time.Time) ([]*DeviceFarmRun, error) {
+	ids, err := claimStaleUploads(txn, "device_farm_run", DeviceFarmStateUploading, now)
+	if err != nil {
+		return nil, err
+	}
+	var runs []*DeviceFarmRun
+	for _, id := range ids {
+		var run DeviceFarmRun
+		if err := txn.SelectOne(&run, "SELECT * FROM device_farm_run WHERE id = ?", id); err != nil {
+			return nil, err
+		}
+		runs = append(runs, &run)
+	}
+	return runs, nil
+}
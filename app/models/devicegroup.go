@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// a DeviceGroup is a saved group of managed test devices on the MDM
+type DeviceGroup struct {
+	Id         int       `db:"id"`
+	AppId      int       `db:"app_id"`
+	Name       string    `db:"name"`
+	MdmGroupId string    `db:"mdm_group_id"`
+	MdmAppId   string    `db:"mdm_app_id"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+func (group *DeviceGroup) PreInsert(s gorp.SqlExecutor) error {
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = group.CreatedAt
+	return nil
+}
+
+func (group *DeviceGroup) PreUpdate(s gorp.SqlExecutor) error {
+	group.UpdatedAt = time.Now()
+	return nil
+}
+
+func (group *DeviceGroup) Validate(v *revel.Validation) {
+	v.Required(group.Name).Message("Name is required.")
+	v.Required(group.MdmGroupId).Message("MDM group ID is required.")
+}
+
+func (group *DeviceGroup) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(group)
+}
+
+func (group *DeviceGroup) Update(txn gorp.SqlExecutor) error {
+	_, err := txn.Update(group)
+	return err
+}
+
+func (group *DeviceGroup) DeleteFromDB(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(group)
+	return err
+}
+
+func GetDeviceGroup(txn gorp.SqlExecutor, id int) (*DeviceGroup, error) {
+	group, err := txn.Get(DeviceGroup{}, id)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, sql.ErrNoRows
+	}
+	return group.(*DeviceGroup), nil
+}
@@ -0,0 +1,279 @@
+package models
+
+import (
+	"archive/zip"
+	"bufio"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the DWARF files of the dSYMs in the zip, of the app and of its extensions and frameworks
+var dsymDwarfPattern = regexp.MustCompile(`(?:^|/)[^/]+\.dSYM/Contents/Resources/DWARF/[^/]+$`)
+
+var (
+	ErrDsymNotIpa     = errors.New("the dSYMs must be of an ipa")
+	ErrDsymNotFound   = errors.New("no dSYM is found in the zip")
+	ErrDsymNotMatched = errors.New("the UUIDs of the dSYMs are not the one of the executable of the bundle")
+	ErrDsymDuplicated = errors.New("the dSYMs are already uploaded")
+)
+
+// a BundleDsym is the zip of the dSYMs of the build of an ipa, kept next to it for the symbolication of the crashes.
+// It is told by the UUIDs of the DWARF files, which the crash reports and the executable of the ipa have.
+type BundleDsym struct {
+	Id       int `db:"id"`
+	BundleId int `db:"bundle_id"`
+	// the UUIDs of the architectures of the DWARF files, one per line
+	Uuids     string    `db:"uuids"`
+	FileId    string    `db:"file_id"`
+	FileSize  int64     `db:"file_size"`
+	Digest    string    `db:"digest"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type BundleDsymJsonResponse struct {
+	Id        int      `json:"id"`
+	BundleId  int      `json:"bundle_id"`
+	Uuids     []string `json:"uuids"`
+	FileSize  int64    `json:"file_size"`
+	Digest    string   `json:"digest"`
+	CreatedAt string   `json:"created_at"`
+}
+
+func (dsym *BundleDsym) PreInsert(s gorp.SqlExecutor) error {
+	dsym.CreatedAt = time.Now()
+	return nil
+}
+
+func (dsym *BundleDsym) JsonResponse() *BundleDsymJsonResponse {
+	return &BundleDsymJsonResponse{
+		Id:        dsym.Id,
+		BundleId:  dsym.BundleId,
+		Uuids:     dsym.UuidList(),
+		FileSize:  dsym.FileSize,
+		Digest:    dsym.Digest,
+		CreatedAt: dsym.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func (dsym *BundleDsym) UuidList() []string {
+	uuids := []string{}
+	for _, uuid := range strings.Split(dsym.Uuids, "\n") {
+		if uuid != "" {
+			uuids = append(uuids, uuid)
+		}
+	}
+	return uuids
+}
+
+func (dsym *BundleDsym) FileName(bundle *Bundle) string {
+	return fmt.Sprintf("app_%d_ver_%s_rev_%d_dsym_%s%s", bundle.AppId, bundle.BundleVersion, bundle.Revision, dsym.Digest[:8], BundleFileExtensionGenericZip)
+}
+
+// DsymUuids reads the UUIDs of every architecture of the DWARF files in the zip of the dSYMs.
+func DsymUuids(file *os.File) ([]string, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := zip.NewReader(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	var uuids []string
+	for _, f := range reader.File {
+		if !dsymDwarfPattern.MatchString(f.Name) {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		found, err := machoUuids(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.Name, err)
+		}
+		uuids = append(uuids, found...)
+	}
+	if len(uuids) == 0 {
+		return nil, ErrDsymNotFound
+	}
+	return uuids, nil
+}
+
+// machoUuids reads the UUIDs of the architectures of the Mach-O file, reading it through once.
+func machoUuids(r io.Reader) ([]string, error) {
+	macho := &countingReader{r: bufio.NewReader(r)}
+
+	var magic uint32
+	if err := binary.Read(macho, binary.BigEndian, &magic); err != nil {
+		return nil, err
+	}
+	offsets := []uint64{0}
+	fat := magic == machoFatMagic || magic == machoFatMagic64
+	if fat {
+		var err error
+		if offsets, err = readMachoFat(macho, magic); err != nil {
+			return nil, err
+		}
+	}
+
+	var uuids []string
+	for _, offset := range offsets {
+		if fat {
+			if err := macho.skipTo(int64(offset)); err != nil {
+				return nil, err
+			}
+			if err := binary.Read(macho, binary.BigEndian, &magic); err != nil {
+				return nil, err
+			}
+		}
+		commands, err := readMachoCommands(macho, magic)
+		if err != nil {
+			return nil, err
+		}
+		err = eachMachoCommand(commands, func(cmd uint32, command []byte) {
+			if cmd == machoUuid && len(command) >= 24 {
+				uuids = append(uuids, formatMachoUuid(command[8:24]))
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return uuids, nil
+}
+
+// BundleByUuids returns the newest ipa whose executable has one of the UUIDs, or sql.ErrNoRows.
+func (app *App) BundleByUuids(txn gorp.SqlExecutor, uuids []string) (*Bundle, error) {
+	if len(uuids) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	args := []interface{}{app.Id, BundlePlatformTypeIOS}
+	for _, uuid := range uuids {
+		args = append(args, uuid)
+	}
+	var bundle Bundle
+	query := "SELECT * FROM bundle WHERE app_id = ? AND platform_type = ? AND executable_uuid IN (?" + strings.Repeat(", ?", len(uuids)-1) + ") ORDER BY id DESC LIMIT 1"
+	if err := txn.SelectOne(&bundle, query, args...); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+// DsymByUuid returns the newest dSYMs of the bundles of the app with the UUID, or sql.ErrNoRows.
+func (app *App) DsymByUuid(txn gorp.SqlExecutor, uuid string) (*BundleDsym, *Bundle, error) {
+	var dsym BundleDsym
+	// the UUIDs are of the fixed format, so a part of the list is one of them
+	err := txn.SelectOne(&dsym, "SELECT bundle_dsym.* FROM bundle_dsym JOIN bundle ON bundle.id = bundle_dsym.bundle_id WHERE bundle.app_id = ? AND bundle_dsym.uuids LIKE ? ORDER BY bundle_dsym.id DESC LIMIT 1", app.Id, "%"+strings.ToUpper(uuid)+"%")
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle, err := GetBundle(txn, dsym.BundleId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &dsym, bundle, nil
+}
+
+func (bundle *Bundle) Dsyms(txn gorp.SqlExecutor) ([]*BundleDsym, error) {
+	var dsyms []*BundleDsym
+	_, err := txn.Select(&dsyms, "SELECT * FROM bundle_dsym WHERE bundle_id = ? ORDER BY id", bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	return dsyms, nil
+}
+
+func (bundle *Bundle) GetDsym(txn gorp.SqlExecutor, id int) (*BundleDsym, error) {
+	var dsym BundleDsym
+	if err := txn.SelectOne(&dsym, "SELECT * FROM bundle_dsym WHERE id = ? AND bundle_id = ?", id, bundle.Id); err != nil {
+		return nil, err
+	}
+	return &dsym, nil
+}
+
+// AddDsym stores the zip of the dSYMs of the UUIDs. One of them must be the UUID of the executable of the ipa,
+// unless the ipa is uploaded before the UUIDs were recorded.
+func (bundle *Bundle) AddDsym(dbm *gorp.DbMap, storage Storage, file *os.File, uuids []string) (*BundleDsym, error) {
+	if !bundle.IsIpa() {
+		return nil, ErrDsymNotIpa
+	}
+	if bundle.ExecutableUuid != "" {
+		matched := false
+		for _, uuid := range uuids {
+			matched = matched || uuid == bundle.ExecutableUuid
+		}
+		if !matched {
+			return nil, ErrDsymNotMatched
+		}
+	}
+
+	digest, err := FileDigest(file)
+	if err != nil {
+		return nil, err
+	}
+	count, err := dbm.SelectInt("SELECT COUNT(id) FROM bundle_dsym WHERE bundle_id = ? AND digest = ?", bundle.Id, digest)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrDsymDuplicated
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	app, err := bundle.App(dbm)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.CheckStorageQuota(dbm, stat.Size(), ""); err != nil {
+		return nil, err
+	}
+	location, err := app.versionLocation(dbm, storage, bundle.BundleVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	dsym := &BundleDsym{
+		BundleId: bundle.Id,
+		Uuids:    strings.Join(uuids, "\n"),
+		FileSize: stat.Size(),
+		Digest:   digest,
+	}
+	dsym.FileId, err = storage.Put(file, dsym.FileName(bundle), location)
+	if err != nil {
+		return nil, err
+	}
+	if err := dbm.Insert(dsym); err != nil {
+		storage.Delete(dsym.FileId)
+		return nil, err
+	}
+	return dsym, nil
+}
+
+// DeleteDsyms deletes the files of the dSYMs, which are of the bundle only.
+func (bundle *Bundle) DeleteDsyms(txn gorp.SqlExecutor, storage Storage) error {
+	dsyms, err := bundle.Dsyms(txn)
+	if err != nil {
+		return err
+	}
+	for _, dsym := range dsyms {
+		if err := storage.Delete(dsym.FileId); err != nil {
+			return err
+		}
+	}
+	_, err = txn.Exec("DELETE FROM bundle_dsym WHERE bundle_id = ?", bundle.Id)
+	return err
+}
@@ -0,0 +1,211 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// the env files are the small configs for the testers, not the bundles
+const EnvFileMaxSize = 64 * 1024
+
+// the recent downloads shown to the developers
+const EnvFileAccessesShown = 20
+
+var ErrEnvFileBroken = errors.New("the env file cannot be decrypted")
+
+// an EnvFile is a small config encrypted in the database, e.g. the credentials of the test accounts and the overrides
+// of the endpoints, for the members to download next to the bundles of the channel instead of passing it in the chat.
+// The empty channel matches any bundle.
+type EnvFile struct {
+	Id        int       `db:"id"`
+	AppId     int       `db:"app_id"`
+	Channel   string    `db:"channel"`
+	Name      string    `db:"name"`
+	Size      int       `db:"size"`
+	Sealed    []byte    `db:"sealed"`
+	UserId    int       `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// an EnvFileAccess is an upload, a download or a deletion of an env file, from the audits
+type EnvFileAccess struct {
+	Action    int       `db:"action"`
+	Name      string    `db:"name"`
+	Channel   string    `db:"channel"`
+	Email     string    `db:"email"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (envFile *EnvFile) PreInsert(s gorp.SqlExecutor) error {
+	envFile.CreatedAt = time.Now()
+	envFile.UpdatedAt = envFile.CreatedAt
+	return nil
+}
+
+func (envFile *EnvFile) PreUpdate(s gorp.SqlExecutor) error {
+	envFile.UpdatedAt = time.Now()
+	return nil
+}
+
+func (envFile *EnvFile) Validate(v *revel.Validation) {
+	v.Required(envFile.Name).Message("File is required.")
+	v.Required(!strings.ContainsAny(envFile.Name, "/\\\"")).Message("File name is invalid.")
+	v.MaxSize(envFile.Name, 255).Message("File name is too long.")
+	v.MaxSize(envFile.Channel, 255).Message("Channel is too long.")
+	v.Required(envFile.Size <= EnvFileMaxSize).Message("File must be smaller than " + strconv.Itoa(EnvFileMaxSize/1024) + "KB.")
+}
+
+// EnvFileKey derives the key of the env files from the secret of the app, unless a key is configured
+// to rotate the secret without them.
+func EnvFileKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("alphawing env file"))
+	return mac.Sum(nil)
+}
+
+// Seal encrypts the content with AES-GCM, bound to the app so that the sealed one cannot be moved to another.
+func (envFile *EnvFile) Seal(key, content []byte) error {
+	aead, err := envFileCipher(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	envFile.Size = len(content)
+	envFile.Sealed = aead.Seal(nonce, nonce, content, envFile.additionalData())
+	return nil
+}
+
+// Open decrypts the content.
+func (envFile *EnvFile) Open(key []byte) ([]byte, error) {
+	aead, err := envFileCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(envFile.Sealed) < aead.NonceSize() {
+		return nil, ErrEnvFileBroken
+	}
+	nonce, sealed := envFile.Sealed[:aead.NonceSize()], envFile.Sealed[aead.NonceSize():]
+	content, err := aead.Open(nil, nonce, sealed, envFile.additionalData())
+	if err != nil {
+		return nil, ErrEnvFileBroken
+	}
+	return content, nil
+}
+
+func (envFile *EnvFile) additionalData() []byte {
+	return []byte("app:" + strconv.Itoa(envFile.AppId))
+}
+
+func envFileCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Matches tells whether the env file is downloaded next to the bundle.
+func (envFile *EnvFile) Matches(bundle *Bundle) bool {
+	return envFile.AppId == bundle.AppId && (envFile.Channel == "" || envFile.Channel == bundle.Channel)
+}
+
+// Save replaces the env file of the same name in the channel, so that the testers always get the latest one.
+func (envFile *EnvFile) Save(txn gorp.SqlExecutor) error {
+	envFile.Channel = strings.TrimSpace(envFile.Channel)
+	envFile.Name = filepath.Base(envFile.Name)
+
+	var current EnvFile
+	err := txn.SelectOne(&current, "SELECT * FROM env_file WHERE app_id = ? AND channel = ? AND name = ?", envFile.AppId, envFile.Channel, envFile.Name)
+	if err == sql.ErrNoRows {
+		return txn.Insert(envFile)
+	}
+	if err != nil {
+		return err
+	}
+	envFile.Id = current.Id
+	envFile.CreatedAt = current.CreatedAt
+	_, err = txn.Update(envFile)
+	return err
+}
+
+func (envFile *EnvFile) DeleteFromDB(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(envFile)
+	return err
+}
+
+func GetEnvFile(txn gorp.SqlExecutor, id int) (*EnvFile, error) {
+	envFile, err := txn.Get(EnvFile{}, id)
+	if err != nil {
+		return nil, err
+	}
+	if envFile == nil {
+		return nil, sql.ErrNoRows
+	}
+	return envFile.(*EnvFile), nil
+}
+
+func (app *App) EnvFiles(txn gorp.SqlExecutor) ([]*EnvFile, error) {
+	var envFiles []*EnvFile
+	_, err := txn.Select(&envFiles, "SELECT * FROM env_file WHERE app_id = ? ORDER BY channel ASC, name ASC", app.Id)
+	if err != nil {
+		return nil, err
+	}
+	return envFiles, nil
+}
+
+// EnvFiles returns the env files of the channel of the bundle and the ones of every channel.
+func (bundle *Bundle) EnvFiles(txn gorp.SqlExecutor) ([]*EnvFile, error) {
+	var envFiles []*EnvFile
+	_, err := txn.Select(&envFiles, "SELECT * FROM env_file WHERE app_id = ? AND (channel = '' OR channel = ?) ORDER BY name ASC", bundle.AppId, bundle.Channel)
+	if err != nil {
+		return nil, err
+	}
+	return envFiles, nil
+}
+
+// EnvFileAccesses returns the recent uploads, downloads and deletions of the env files of the app, the latest first.
+// The deleted ones are left out, as their names are gone with them.
+func (app *App) EnvFileAccesses(txn gorp.SqlExecutor, limit int) ([]*EnvFileAccess, error) {
+	var accesses []*EnvFileAccess
+	_, err := txn.Select(&accesses, `SELECT audit.action, env_file.name, env_file.channel, COALESCE(user.email, '') AS email, audit.created_at
+		FROM audit INNER JOIN env_file ON env_file.id = audit.resource_id
+		LEFT JOIN user ON user.id = audit.user_id
+		WHERE audit.resource = ? AND env_file.app_id = ? ORDER BY audit.id DESC LIMIT ?`,
+		ResourceEnvFile, app.Id, limit)
+	if err != nil {
+		return nil, err
+	}
+	return accesses, nil
+}
+
+func (access *EnvFileAccess) ActionName() string {
+	switch access.Action {
+	case ActionCreate:
+		return "アップロード"
+	case ActionDownload:
+		return "ダウンロード"
+	}
+	return ""
+}
+
+func (app *App) DeleteEnvFiles(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM env_file WHERE app_id = ?", app.Id)
+	return err
+}
@@ -0,0 +1,240 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	errorReportClient     = "alphawing/1.0"
+	errorReportMaxFrames  = 50
+	errorReportAppPackage = "github.com/kayac/alphawing/"
+)
+
+// an ErrorReporter sends the errors to a Sentry compatible endpoint, e.g. Sentry or GlitchTip, with the store API.
+// https://develop.sentry.dev/sdk/store/
+type ErrorReporter struct {
+	StoreUrl    string
+	PublicKey   string
+	SecretKey   string
+	Environment string
+	ServerName  string
+	Client      *http.Client
+}
+
+// an ErrorEvent is the error with the stack trace and the request, in the event payload of Sentry
+type ErrorEvent struct {
+	EventId     string             `json:"event_id"`
+	Timestamp   string             `json:"timestamp"`
+	Level       string             `json:"level"`
+	Platform    string             `json:"platform"`
+	Logger      string             `json:"logger"`
+	ServerName  string             `json:"server_name,omitempty"`
+	Environment string             `json:"environment,omitempty"`
+	Message     string             `json:"message"`
+	Exception   *ErrorEventValues  `json:"exception,omitempty"`
+	Request     *ErrorEventRequest `json:"request,omitempty"`
+	User        *ErrorEventUser    `json:"user,omitempty"`
+	Tags        map[string]string  `json:"tags,omitempty"`
+}
+
+type ErrorEventValues struct {
+	Values []*ErrorEventException `json:"values"`
+}
+
+type ErrorEventException struct {
+	Type       string                `json:"type"`
+	Value      string                `json:"value"`
+	Stacktrace *ErrorEventStacktrace `json:"stacktrace,omitempty"`
+}
+
+type ErrorEventStacktrace struct {
+	Frames []*ErrorEventFrame `json:"frames"`
+}
+
+type ErrorEventFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type ErrorEventRequest struct {
+	Url         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+type ErrorEventUser struct {
+	Id string `json:"id"`
+}
+
+// NewErrorReporter parses the DSN, https://<public key>[:<secret key>]@<host>[/<path>]/<project id>
+func NewErrorReporter(dsn, environment string) (*ErrorReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("error report: the public key is missing in the DSN")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || u.Path[i+1:] == "" {
+		return nil, fmt.Errorf("error report: the project ID is missing in the DSN")
+	}
+	secretKey, _ := u.User.Password()
+	serverName, _ := os.Hostname()
+
+	return &ErrorReporter{
+		StoreUrl:    fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:i], u.Path[i+1:]),
+		PublicKey:   u.User.Username(),
+		SecretKey:   secretKey,
+		Environment: environment,
+		ServerName:  serverName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// NewErrorEvent makes the event of the error, with the stack trace of the caller if the frames are given.
+func NewErrorEvent(errorType, message string, frames []*ErrorEventFrame) *ErrorEvent {
+	exception := &ErrorEventException{
+		Type:  errorType,
+		Value: message,
+	}
+	if len(frames) > 0 {
+		exception.Stacktrace = &ErrorEventStacktrace{Frames: frames}
+	}
+	return &ErrorEvent{
+		EventId:   randomHex(16),
+		Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05"),
+		Level:     "error",
+		Platform:  "go",
+		Logger:    "alphawing",
+		Message:   message,
+		Exception: &ErrorEventValues{Values: []*ErrorEventException{exception}},
+	}
+}
+
+// SetRequest adds the request without the credentials in the headers and the query.
+func (event *ErrorEvent) SetRequest(req *http.Request) {
+	headers := map[string]string{}
+	for key := range req.Header {
+		if isSensitiveName(key) {
+			headers[key] = "[Filtered]"
+			continue
+		}
+		headers[key] = req.Header.Get(key)
+	}
+
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	event.Request = &ErrorEventRequest{
+		Url:         fmt.Sprintf("%s://%s%s", scheme, req.Host, req.URL.Path),
+		Method:      req.Method,
+		QueryString: redactQuery(req.URL.Query()).Encode(),
+		Headers:     headers,
+	}
+}
+
+// the parts of the names of the headers and the parameters with the credentials, e.g. the API tokens in the query of
+// the limited time URLs, the cookies of the sessions and the signatures of the webhooks
+var sensitiveNames = []string{"token", "key", "secret", "password", "passwd", "auth", "cookie", "session", "signature", "credential"}
+
+func isSensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveNames {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactQuery(query url.Values) url.Values {
+	for key := range query {
+		if isSensitiveName(key) {
+			query.Set(key, "[Filtered]")
+		}
+	}
+	return query
+}
+
+// StackFrames returns the stack of the caller, skipping the given number of the frames, oldest first as Sentry expects.
+func StackFrames(skip int) []*ErrorEventFrame {
+	pcs := make([]uintptr, errorReportMaxFrames)
+	n := runtime.Callers(skip+2, pcs)
+
+	frames := []*ErrorEventFrame{}
+	for i := n - 1; i >= 0; i-- {
+		// the return address is after the call
+		fn := runtime.FuncForPC(pcs[i] - 1)
+		if fn == nil {
+			continue
+		}
+		file, line := fn.FileLine(pcs[i] - 1)
+		module, function := splitFunctionName(fn.Name())
+		frames = append(frames, &ErrorEventFrame{
+			Function: function,
+			Module:   module,
+			Filename: file[strings.LastIndex(file, "/")+1:],
+			AbsPath:  file,
+			Lineno:   line,
+			InApp:    strings.HasPrefix(fn.Name(), errorReportAppPackage),
+		})
+	}
+	return frames
+}
+
+// e.g. github.com/kayac/alphawing/app/controllers.ApiController.PostUploadBundle
+func splitFunctionName(name string) (string, string) {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:slash+1+dot], name[slash+1+dot+1:]
+}
+
+func (r *ErrorReporter) Report(event *ErrorEvent) error {
+	event.Environment = r.Environment
+	event.ServerName = r.ServerName
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", r.StoreUrl, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=%s, sentry_timestamp=%d, sentry_key=%s", errorReportClient, time.Now().Unix(), r.PublicKey)
+	if r.SecretKey != "" {
+		auth += ", sentry_secret=" + r.SecretKey
+	}
+	req.Header.Set("X-Sentry-Auth", auth)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return fmt.Errorf("error report: got HTTP response code %d", resp.StatusCode)
+	}
+	return nil
+}
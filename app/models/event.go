@@ -0,0 +1,128 @@
+package models
+
+import (
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	EventResourceApp    = "app"
+	EventResourceBundle = "bundle"
+
+	EventActionCreate = "create"
+	EventActionUpdate = "update"
+	EventActionDelete = "delete"
+	// the bundle file is analyzed after the upload
+	EventActionAnalyze = "analyze"
+)
+
+// the events sequenced by a call, which sequences the rest on the next calls
+const eventSequenceBatchSize = 1000
+
+// an Event is a change of an app or a bundle, for the external systems to follow in the order of the sequence.
+// The IDs are taken at the insert but seen at the commit, so an event of a transaction committed late may have a
+// smaller ID than the ones followed already. The sequence is numbered to the events once committed instead, 0 until then.
+type Event struct {
+	Id         int       `db:"id"`
+	Seq        int       `db:"seq"`
+	Resource   string    `db:"resource"`
+	ResourceId int       `db:"resource_id"`
+	AppId      int       `db:"app_id"`
+	Action     string    `db:"action"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+// an EventSequence is the last sequence numbered, in the only row locked while the events are sequenced
+type EventSequence struct {
+	Id  int `db:"id"`
+	Seq int `db:"seq"`
+}
+
+type EventJsonResponse struct {
+	Cursor     int    `json:"cursor"`
+	Resource   string `json:"resource"`
+	ResourceId int    `json:"resource_id"`
+	AppId      int    `json:"app_id"`
+	Action     string `json:"action"`
+	CreatedAt  string `json:"created_at"`
+}
+
+func (event *Event) PreInsert(s gorp.SqlExecutor) error {
+	event.CreatedAt = time.Now()
+	return nil
+}
+
+func (event *Event) JsonResponse() *EventJsonResponse {
+	return &EventJsonResponse{
+		Cursor:     event.Seq,
+		Resource:   event.Resource,
+		ResourceId: event.ResourceId,
+		AppId:      event.AppId,
+		Action:     event.Action,
+		CreatedAt:  event.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func RecordEvent(txn gorp.SqlExecutor, resource string, resourceId, appId int, action string) error {
+	event := &Event{
+		Resource:   resource,
+		ResourceId: resourceId,
+		AppId:      appId,
+		Action:     action,
+	}
+	return txn.Insert(event)
+}
+
+// GetEventsSince sequences the events committed since the last call and returns the ones after the cursor, oldest first.
+func GetEventsSince(dbm *gorp.DbMap, cursor, limit int) ([]*Event, error) {
+	if err := Transact(dbm, sequenceEvents); err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	_, err := dbm.Select(&events, "SELECT * FROM event WHERE seq > ? ORDER BY seq ASC LIMIT ?", cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// sequenceEvents numbers the events committed and not sequenced yet after the last sequence, so that an event
+// committed late is followed after the ones sequenced before instead of being passed by the cursor. The sequence row
+// is locked first, so the servers sequence one at a time and each sees the events sequenced by the others.
+func sequenceEvents(txn gorp.SqlExecutor) error {
+	if _, err := txn.Exec("UPDATE event_sequence SET seq = seq WHERE id = 1"); err != nil {
+		return err
+	}
+	var sequences []*EventSequence
+	if _, err := txn.Select(&sequences, "SELECT * FROM event_sequence WHERE id = 1"); err != nil {
+		return err
+	}
+	if len(sequences) == 0 {
+		// the events recorded before they were sequenced keep their IDs, which the cursors followed
+		if _, err := txn.Exec("UPDATE event SET seq = id WHERE seq = 0"); err != nil {
+			return err
+		}
+		last, err := txn.SelectInt("SELECT IFNULL(MAX(seq), 0) FROM event")
+		if err != nil {
+			return err
+		}
+		return txn.Insert(&EventSequence{Id: 1, Seq: int(last)})
+	}
+	sequence := sequences[0]
+
+	var events []*Event
+	_, err := txn.Select(&events, "SELECT * FROM event WHERE seq = 0 ORDER BY id ASC LIMIT ?", eventSequenceBatchSize)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	for _, event := range events {
+		sequence.Seq++
+		if _, err := txn.Exec("UPDATE event SET seq = ? WHERE id = ?", sequence.Seq, event.Id); err != nil {
+			return err
+		}
+	}
+	_, err = txn.Update(sequence)
+	return err
+}
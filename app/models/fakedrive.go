@@ -0,0 +1,383 @@
+package models
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+	"code.google.com/p/google-api-go-client/drive/v2"
+	"code.google.com/p/google-api-go-client/oauth2/v2"
+)
+
+const (
+	FakeDriveHost   = "www.googleapis.com"
+	fakeDriveQuota  = 15 * 1000 * 1000 * 1000
+	fakeDriveFolder = "application/vnd.google-apps.folder"
+)
+
+// a FakeDrive keeps the files in memory in place of Google Drive, for the integration tests and the local development.
+// It answers the subset of Drive API v2 and OAuth2 API v2 which GoogleService calls.
+// Every GoogleService made from it acts as the same account, so all the folders count as shared with the login user.
+type FakeDrive struct {
+	Email string
+
+	mu     sync.Mutex
+	files  map[string]*fakeDriveFile
+	nextId int
+}
+
+type fakeDriveFile struct {
+	file        *drive.File
+	content     []byte
+	permissions []*drive.Permission
+}
+
+func NewFakeDrive(email string) *FakeDrive {
+	return &FakeDrive{
+		Email: email,
+		files: map[string]*fakeDriveFile{},
+	}
+}
+
+// NewFakeGoogleService returns the GoogleService storing the files in the fake.
+func NewFakeGoogleService(fake *FakeDrive) (*GoogleService, error) {
+	token := &oauth.Token{AccessToken: "fake"}
+	transport := &oauth.Transport{
+		Token:     token,
+		Transport: fake,
+	}
+	return newGoogleService(token, transport.Client())
+}
+
+// File returns the file and its content, for the tests to see what is stored.
+func (fake *FakeDrive) File(fileId string) (*drive.File, []byte, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	f, ok := fake.files[fileId]
+	if !ok {
+		return nil, nil, false
+	}
+	return f.file, f.content, true
+}
+
+// Reset removes all the files.
+func (fake *FakeDrive) Reset() {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.files = map[string]*fakeDriveFile{}
+}
+
+func (fake *FakeDrive) RoundTrip(req *http.Request) (*http.Response, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+
+	path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(path) >= 3 && path[0] == "oauth2" && path[2] == "userinfo":
+		return fake.respondJson(req, http.StatusOK, &oauth2.Userinfoplus{Email: fake.Email, Id: fake.Email})
+	case len(path) >= 3 && path[0] == "oauth2" && path[2] == "tokeninfo":
+		return fake.respondJson(req, http.StatusOK, &oauth2.Tokeninfo{Email: fake.Email, Verified_email: true})
+	case len(path) == 2 && path[0] == "download":
+		return fake.download(req, path[1])
+	case len(path) >= 3 && path[0] == "drive" && path[2] == "about":
+		return fake.about(req)
+	case len(path) >= 4 && path[0] == "upload" && path[3] == "files":
+		return fake.upload(req)
+	case len(path) >= 3 && path[0] == "drive" && path[2] == "files":
+		return fake.serveFile(req, path[3:])
+	}
+	return fake.respondError(req, http.StatusNotFound, "not found")
+}
+
+func (fake *FakeDrive) serveFile(req *http.Request, path []string) (*http.Response, error) {
+	if len(path) == 0 {
+		switch req.Method {
+		case "GET":
+			return fake.list(req)
+		case "POST":
+			var file drive.File
+			if err := json.NewDecoder(req.Body).Decode(&file); err != nil {
+				return fake.respondError(req, http.StatusBadRequest, err.Error())
+			}
+			return fake.respondJson(req, http.StatusOK, fake.insert(&file, nil))
+		}
+		return fake.respondError(req, http.StatusMethodNotAllowed, "method not allowed")
+	}
+
+	f, ok := fake.files[path[0]]
+	if !ok {
+		return fake.respondError(req, http.StatusNotFound, "File not found: "+path[0])
+	}
+	if len(path) == 1 {
+		switch req.Method {
+		case "GET":
+			return fake.respondJson(req, http.StatusOK, f.file)
+		case "PUT", "PATCH":
+			return fake.patch(req, f)
+		case "DELETE":
+			fake.delete(f.file.Id)
+			return fake.respondEmpty(req)
+		}
+	}
+	if len(path) == 2 && path[1] == "trash" && req.Method == "POST" {
+		fake.trash(f.file.Id, true)
+		return fake.respondJson(req, http.StatusOK, f.file)
+	}
+	if len(path) >= 2 && path[1] == "permissions" {
+		return fake.permission(req, f, path[2:])
+	}
+	return fake.respondError(req, http.StatusNotFound, "not found")
+}
+
+func (fake *FakeDrive) insert(file *drive.File, content []byte) *drive.File {
+	fake.nextId++
+	now := time.Now().Format(time.RFC3339)
+
+	file.Id = fmt.Sprintf("fake%d", fake.nextId)
+	file.CreatedDate = now
+	file.ModifiedDate = now
+	file.Labels = &drive.FileLabels{}
+	if file.MimeType != fakeDriveFolder {
+		digest := md5.Sum(content)
+		file.OriginalFilename = file.Title
+		file.FileSize = int64(len(content))
+		file.Md5Checksum = hex.EncodeToString(digest[:])
+		file.DownloadUrl = fmt.Sprintf("https://%s/download/%s", FakeDriveHost, file.Id)
+	}
+
+	fake.files[file.Id] = &fakeDriveFile{file: file, content: content}
+	return file
+}
+
+func (fake *FakeDrive) upload(req *http.Request) (*http.Response, error) {
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return fake.respondError(req, http.StatusBadRequest, err.Error())
+	}
+	reader := multipart.NewReader(req.Body, params["boundary"])
+
+	var file drive.File
+	part, err := reader.NextPart()
+	if err != nil {
+		return fake.respondError(req, http.StatusBadRequest, err.Error())
+	}
+	if err := json.NewDecoder(part).Decode(&file); err != nil {
+		return fake.respondError(req, http.StatusBadRequest, err.Error())
+	}
+	part, err = reader.NextPart()
+	if err != nil {
+		return fake.respondError(req, http.StatusBadRequest, err.Error())
+	}
+	content, err := ioutil.ReadAll(part)
+	if err != nil {
+		return fake.respondError(req, http.StatusBadRequest, err.Error())
+	}
+
+	return fake.respondJson(req, http.StatusOK, fake.insert(&file, content))
+}
+
+func (fake *FakeDrive) patch(req *http.Request, f *fakeDriveFile) (*http.Response, error) {
+	var file drive.File
+	if err := json.NewDecoder(req.Body).Decode(&file); err != nil && err != io.EOF {
+		return fake.respondError(req, http.StatusBadRequest, err.Error())
+	}
+	if file.Title != "" {
+		f.file.Title = file.Title
+	}
+	for _, property := range file.Properties {
+		f.file.Properties = append(f.file.Properties, property)
+	}
+
+	query := req.URL.Query()
+	if parentId := query.Get("addParents"); parentId != "" {
+		f.file.Parents = append(f.file.Parents, &drive.ParentReference{Id: parentId})
+	}
+	if parentId := query.Get("removeParents"); parentId != "" {
+		var parents []*drive.ParentReference
+		for _, parent := range f.file.Parents {
+			if parent.Id != parentId {
+				parents = append(parents, parent)
+			}
+		}
+		f.file.Parents = parents
+	}
+
+	f.file.ModifiedDate = time.Now().Format(time.RFC3339)
+	return fake.respondJson(req, http.StatusOK, f.file)
+}
+
+// trash trashes the file and the files in it, as Google Drive does with a folder.
+func (fake *FakeDrive) trash(fileId string, explicitly bool) {
+	f := fake.files[fileId]
+	f.file.Labels.Trashed = true
+	f.file.ExplicitlyTrashed = explicitly
+	for _, child := range fake.children(fileId) {
+		fake.trash(child.file.Id, false)
+	}
+}
+
+// delete deletes the file and the files only in it, keeping the ones also in the other folders.
+func (fake *FakeDrive) delete(fileId string) {
+	for _, child := range fake.children(fileId) {
+		var parents []*drive.ParentReference
+		for _, parent := range child.file.Parents {
+			if parent.Id != fileId {
+				parents = append(parents, parent)
+			}
+		}
+		child.file.Parents = parents
+		if len(parents) == 0 {
+			fake.delete(child.file.Id)
+		}
+	}
+	delete(fake.files, fileId)
+}
+
+func (fake *FakeDrive) children(fileId string) []*fakeDriveFile {
+	var children []*fakeDriveFile
+	for _, f := range fake.files {
+		for _, parent := range f.file.Parents {
+			if parent.Id == fileId {
+				children = append(children, f)
+			}
+		}
+	}
+	return children
+}
+
+// list answers "trashed = true" with the trashed files, and the other queries with the files not trashed.
+func (fake *FakeDrive) list(req *http.Request) (*http.Response, error) {
+	trashed := strings.Contains(req.URL.Query().Get("q"), "trashed = true")
+	sharedWithMe := strings.Contains(req.URL.Query().Get("q"), "sharedWithMe = true")
+
+	fileList := &drive.FileList{Items: []*drive.File{}}
+	for _, f := range fake.files {
+		if f.file.Labels.Trashed != trashed {
+			continue
+		}
+		if sharedWithMe && len(f.permissions) == 0 {
+			continue
+		}
+		fileList.Items = append(fileList.Items, f.file)
+	}
+	return fake.respondJson(req, http.StatusOK, fileList)
+}
+
+func (fake *FakeDrive) permission(req *http.Request, f *fakeDriveFile, path []string) (*http.Response, error) {
+	if len(path) == 0 {
+		switch req.Method {
+		case "GET":
+			return fake.respondJson(req, http.StatusOK, &drive.PermissionList{Items: f.permissions})
+		case "POST":
+			var permission drive.Permission
+			if err := json.NewDecoder(req.Body).Decode(&permission); err != nil {
+				return fake.respondError(req, http.StatusBadRequest, err.Error())
+			}
+			fake.nextId++
+			permission.Id = fmt.Sprintf("fakepermission%d", fake.nextId)
+			permission.EmailAddress = permission.Value
+			f.permissions = append(f.permissions, &permission)
+			return fake.respondJson(req, http.StatusOK, &permission)
+		}
+		return fake.respondError(req, http.StatusMethodNotAllowed, "method not allowed")
+	}
+
+	for i, permission := range f.permissions {
+		if permission.Id != path[0] {
+			continue
+		}
+		switch req.Method {
+		case "GET":
+			return fake.respondJson(req, http.StatusOK, permission)
+		case "PUT", "PATCH":
+			var p drive.Permission
+			if err := json.NewDecoder(req.Body).Decode(&p); err != nil {
+				return fake.respondError(req, http.StatusBadRequest, err.Error())
+			}
+			permission.Role = p.Role
+			return fake.respondJson(req, http.StatusOK, permission)
+		case "DELETE":
+			f.permissions = append(f.permissions[:i], f.permissions[i+1:]...)
+			return fake.respondEmpty(req)
+		}
+	}
+	return fake.respondError(req, http.StatusNotFound, "Permission not found: "+path[0])
+}
+
+func (fake *FakeDrive) about(req *http.Request) (*http.Response, error) {
+	var used int64
+	for _, f := range fake.files {
+		used += int64(len(f.content))
+	}
+	return fake.respondJson(req, http.StatusOK, &drive.About{
+		Name:            fake.Email,
+		QuotaBytesTotal: fakeDriveQuota,
+		QuotaBytesUsed:  used,
+	})
+}
+
+func (fake *FakeDrive) download(req *http.Request, fileId string) (*http.Response, error) {
+	f, ok := fake.files[fileId]
+	if !ok {
+		return fake.respondError(req, http.StatusNotFound, "File not found: "+fileId)
+	}
+	resp := fake.respond(req, http.StatusOK, f.content)
+	resp.Header.Set("Content-Type", "application/octet-stream")
+	return resp, nil
+}
+
+func (fake *FakeDrive) respondJson(req *http.Request, status int, v interface{}) (*http.Response, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	resp := fake.respond(req, status, b)
+	resp.Header.Set("Content-Type", "application/json")
+	return resp, nil
+}
+
+func (fake *FakeDrive) respondError(req *http.Request, status int, message string) (*http.Response, error) {
+	body := map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"message": message,
+		},
+	}
+	return fake.respondJson(req, status, body)
+}
+
+func (fake *FakeDrive) respondEmpty(req *http.Request) (*http.Response, error) {
+	return fake.respond(req, http.StatusNoContent, nil), nil
+}
+
+func (fake *FakeDrive) respond(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
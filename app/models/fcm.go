@@ -0,0 +1,96 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+const (
+	FirebaseMessagingScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+	FirebaseCloudMessagingBaseUrl = "https://fcm.googleapis.com"
+)
+
+// FirebaseCloudMessaging sends the push notifications to the companion apps.
+// https://firebase.google.com/docs/reference/fcm/rest/v1/projects.messages/send
+type FirebaseCloudMessaging struct {
+	ProjectId string
+	BaseUrl   string
+	Client    *http.Client
+}
+
+// a PushMessage opens the link when it is tapped, and has the same link in the data for the companion apps.
+type PushMessage struct {
+	Title string
+	Body  string
+	Link  string
+	Data  map[string]string
+}
+
+func NewFirebaseCloudMessaging(token *oauth.Token, projectId string) *FirebaseCloudMessaging {
+	return &FirebaseCloudMessaging{
+		ProjectId: projectId,
+		BaseUrl:   FirebaseCloudMessagingBaseUrl,
+		Client:    createOAuthClient(token),
+	}
+}
+
+func (f *FirebaseCloudMessaging) Send(pushToken string, message *PushMessage) error {
+	data := map[string]string{"link": message.Link}
+	for k, v := range message.Data {
+		data[k] = v
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token": pushToken,
+			"notification": map[string]string{
+				"title": message.Title,
+				"body":  message.Body,
+			},
+			"data": data,
+			"webpush": map[string]interface{}{
+				"fcm_options": map[string]string{"link": message.Link},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/v1/projects/%s/messages:send", f.BaseUrl, f.ProjectId)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return &FirebaseError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	return nil
+}
+
+// IsUnregisteredPushToken tells whether the push token is no longer valid, e.g. the app is uninstalled.
+func IsUnregisteredPushToken(err error) bool {
+	firebaseErr, ok := err.(*FirebaseError)
+	if !ok {
+		return false
+	}
+	return firebaseErr.StatusCode == http.StatusNotFound || strings.Contains(firebaseErr.Body, "UNREGISTERED")
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+const (
+	FirebaseScope = "https://www.googleapis.com/auth/cloud-platform"
+
+	FirebaseAppDistributionBaseUrl = "https://firebaseappdistribution.googleapis.com"
+	firebaseOperationPollInterval  = 5 * time.Second
+	firebaseOperationPollLimit     = 60
+)
+
+// FirebaseAppDistribution publishes bundles to Firebase App Distribution.
+// https://firebase.google.com/docs/reference/app-distribution/rest
+type FirebaseAppDistribution struct {
+	ProjectNumber string
+	BaseUrl       string
+	Client        *http.Client
+}
+
+type FirebaseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *FirebaseError) Error() string {
+	return fmt.Sprintf("firebase: got HTTP response code %d: %s", e.StatusCode, e.Body)
+}
+
+type firebaseOperation struct {
+	Name  string `json:"name"`
+	Done  bool   `json:"done"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Response *struct {
+		Release *struct {
+			Name string `json:"name"`
+		} `json:"release"`
+	} `json:"response"`
+}
+
+func NewFirebaseAppDistribution(token *oauth.Token, projectNumber string) *FirebaseAppDistribution {
+	return &FirebaseAppDistribution{
+		ProjectNumber: projectNumber,
+		BaseUrl:       FirebaseAppDistributionBaseUrl,
+		Client:        createOAuthClient(token),
+	}
+}
+
+// UploadRelease uploads the bundle file as a new release of the Firebase app,
+// and sets the release notes once the release is processed.
+func (f *FirebaseAppDistribution) UploadRelease(firebaseAppId, filename string, file io.Reader, releaseNotes string) error {
+	url := fmt.Sprintf("%s/upload/v1/projects/%s/apps/%s/releases:upload", f.BaseUrl, f.ProjectNumber, firebaseAppId)
+	req, err := http.NewRequest("POST", url, file)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Goog-Upload-File-Name", filename)
+	req.Header.Set("X-Goog-Upload-Protocol", "raw")
+
+	operation := &firebaseOperation{}
+	if err := f.do(req, operation); err != nil {
+		return err
+	}
+
+	for i := 0; !operation.Done && i < firebaseOperationPollLimit; i++ {
+		time.Sleep(firebaseOperationPollInterval)
+
+		req, err := http.NewRequest("GET", f.BaseUrl+"/v1/"+operation.Name, nil)
+		if err != nil {
+			return err
+		}
+		if err := f.do(req, operation); err != nil {
+			return err
+		}
+	}
+	if !operation.Done {
+		return fmt.Errorf("firebase: release upload is not processed: %s", operation.Name)
+	}
+	if operation.Error != nil {
+		return fmt.Errorf("firebase: %s", operation.Error.Message)
+	}
+
+	if len(releaseNotes) == 0 || operation.Response == nil || operation.Response.Release == nil {
+		return nil
+	}
+	return f.updateReleaseNotes(operation.Response.Release.Name, releaseNotes)
+}
+
+func (f *FirebaseAppDistribution) updateReleaseNotes(releaseName, releaseNotes string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"releaseNotes": map[string]string{"text": releaseNotes},
+	})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/v1/%s?updateMask=release_notes.text", f.BaseUrl, releaseName)
+	req, err := http.NewRequest("PATCH", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return f.do(req, nil)
+}
+
+func (f *FirebaseAppDistribution) do(req *http.Request, v interface{}) error {
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return &FirebaseError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
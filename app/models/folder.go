@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"code.google.com/p/google-api-go-client/drive/v2"
+
+	"github.com/coopernurse/gorp"
+)
+
+// a Folder is a folder on Google Drive holding the bundle files of a version of an app
+type Folder struct {
+	Id            int       `db:"id"`
+	AppId         int       `db:"app_id"`
+	BundleVersion string    `db:"bundle_version"`
+	FileId        string    `db:"file_id"`
+	CreatedAt     time.Time `db:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at"`
+}
+
+func (folder *Folder) PreInsert(s gorp.SqlExecutor) error {
+	folder.CreatedAt = time.Now()
+	folder.UpdatedAt = folder.CreatedAt
+	return nil
+}
+
+func (folder *Folder) PreUpdate(s gorp.SqlExecutor) error {
+	folder.UpdatedAt = time.Now()
+	return nil
+}
+
+func (folder *Folder) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(folder)
+}
+
+func (folder *Folder) DeleteFromDB(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(folder)
+	return err
+}
+
+func (folder *Folder) ParentReference() *drive.ParentReference {
+	return &drive.ParentReference{
+		Id: folder.FileId,
+	}
+}
+
+func GetFolder(txn gorp.SqlExecutor, appId int, bundleVersion string) (*Folder, error) {
+	var folder Folder
+	if err := txn.SelectOne(&folder, "SELECT * FROM folder WHERE app_id = ? AND bundle_version = ?", appId, bundleVersion); err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
@@ -0,0 +1,301 @@
+package models
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+const (
+	GcsScope     = "https://www.googleapis.com/auth/devstorage.read_write"
+	GcsBaseUrl   = "https://storage.googleapis.com/storage/v1"
+	GcsUploadUrl = "https://storage.googleapis.com/upload/storage/v1"
+	gcsSignHost  = "storage.googleapis.com"
+)
+
+// GcsStorage keeps the files in a Google Cloud Storage bucket with the service account, as the quota of Google Drive runs out.
+// The keys are the prefix, the location and the file name, as the ones of S3.
+type GcsStorage struct {
+	Bucket    string
+	Prefix    string
+	Account   *ServiceAccountConfig
+	BaseUrl   string
+	UploadUrl string
+	Client    *http.Client
+	// the private key of the service account, to sign the URLs with
+	SigningKey *rsa.PrivateKey
+
+	mu    sync.Mutex
+	token *oauth.Token
+}
+
+func NewGcsStorage(bucket, prefix, clientEmail, privateKey string) (*GcsStorage, error) {
+	signingKey, err := parseGcsPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &GcsStorage{
+		Bucket: bucket,
+		Prefix: strings.Trim(prefix, "/"),
+		Account: &ServiceAccountConfig{
+			ClientEmail: clientEmail,
+			PrivateKey:  privateKey,
+			Scope:       []string{GcsScope},
+		},
+		BaseUrl:    GcsBaseUrl,
+		UploadUrl:  GcsUploadUrl,
+		Client:     http.DefaultClient,
+		SigningKey: signingKey,
+	}, nil
+}
+
+func (st *GcsStorage) Put(file *os.File, name, location string) (string, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		return "", err
+	}
+	return st.PutStream(file, stat.Size(), name, location)
+}
+
+// PutStream uploads the reader in a single request of the media upload.
+// https://cloud.google.com/storage/docs/uploading-objects
+func (st *GcsStorage) PutStream(r io.Reader, size int64, name, location string) (string, error) {
+	key := name
+	if location != "" {
+		key = strings.Trim(location, "/") + "/" + key
+	}
+	if st.Prefix != "" {
+		key = st.Prefix + "/" + key
+	}
+
+	u := fmt.Sprintf("%s/b/%s/o?uploadType=media&name=%s", st.UploadUrl, gcsEscape(st.Bucket), gcsEscape(key))
+	req, err := http.NewRequest("POST", u, ioutil.NopCloser(r))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := st.do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return key, nil
+}
+
+// Get streams the content of the object without keeping it on the local disk.
+func (st *GcsStorage) Get(key string) (*StorageObject, error) {
+	req, err := http.NewRequest("GET", st.objectUrl(key)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := st.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		modTime = time.Now()
+	}
+	return &StorageObject{
+		Body:    resp.Body,
+		Name:    path.Base(key),
+		Size:    resp.ContentLength,
+		ModTime: modTime,
+	}, nil
+}
+
+func (st *GcsStorage) Delete(key string) error {
+	req, err := http.NewRequest("DELETE", st.objectUrl(key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := st.do(req)
+	if err != nil {
+		if storageErr, ok := err.(*StorageError); ok && storageErr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SignedURL signs the GET of the object with the private key of the service account by the V4 signing,
+// with the name of the file to save it as.
+// https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+func (st *GcsStorage) SignedURL(key string, expiry time.Duration) (string, error) {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = gcsEscape(segment)
+	}
+	escapedPath := "/" + gcsEscape(st.Bucket) + "/" + strings.Join(segments, "/")
+
+	now := time.Now().UTC()
+	googDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/auto/storage/goog4_request"
+
+	query := url.Values{}
+	query.Set("X-Goog-Algorithm", "GOOG4-RSA-SHA256")
+	query.Set("X-Goog-Credential", st.Account.ClientEmail+"/"+scope)
+	query.Set("X-Goog-Date", googDate)
+	query.Set("X-Goog-Expires", fmt.Sprintf("%d", int(expiry.Seconds())))
+	query.Set("X-Goog-SignedHeaders", "host")
+	query.Set("response-content-disposition", fmt.Sprintf(`attachment; filename="%s"`, path.Base(key)))
+	canonicalQuery := strings.Replace(query.Encode(), "+", "%20", -1)
+
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		escapedPath,
+		canonicalQuery,
+		"host:" + gcsSignHost + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"GOOG4-RSA-SHA256",
+		googDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, st.SigningKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return "https://" + gcsSignHost + escapedPath + "?" + canonicalQuery + "&X-Goog-Signature=" + hex.EncodeToString(signature), nil
+}
+
+type gcsListResult struct {
+	Items []struct {
+		Name    string    `json:"name"`
+		Size    int64     `json:"size,string"`
+		Updated time.Time `json:"updated"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// List lists the objects under the prefix, a page of a thousand at a time.
+// https://cloud.google.com/storage/docs/json_api/v1/objects/list
+func (st *GcsStorage) List(fn func(entry *StorageEntry) error) error {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("fields", "items(name,size,updated),nextPageToken")
+		if st.Prefix != "" {
+			query.Set("prefix", st.Prefix+"/")
+		}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/b/%s/o?%s", st.BaseUrl, gcsEscape(st.Bucket), query.Encode()), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := st.do(req)
+		if err != nil {
+			return err
+		}
+		var result gcsListResult
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, item := range result.Items {
+			if err := fn(&StorageEntry{Key: item.Name, Size: item.Size, ModTime: item.Updated}); err != nil {
+				return err
+			}
+		}
+		if result.NextPageToken == "" {
+			return nil
+		}
+		token = result.NextPageToken
+	}
+}
+
+func (st *GcsStorage) objectUrl(key string) string {
+	return fmt.Sprintf("%s/b/%s/o/%s", st.BaseUrl, gcsEscape(st.Bucket), gcsEscape(key))
+}
+
+// accessToken returns the token of the service account, asserting a new one when it expires.
+func (st *GcsStorage) accessToken() (string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.token == nil || st.token.Expired() {
+		token, err := GetServiceAccountToken(st.Account)
+		if err != nil {
+			return "", err
+		}
+		st.token = token
+	}
+	return st.token.AccessToken, nil
+}
+
+// do returns the response to be closed by the caller, or the error with the body of the failed request.
+func (st *GcsStorage) do(req *http.Request) (*http.Response, error) {
+	token, err := st.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := st.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, &StorageError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+// gcsEscape escapes the object names in the paths and the queries, where the slashes are escaped too.
+func gcsEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// parseGcsPrivateKey parses the private key of the service account key, which is in PKCS#8.
+func parseGcsPrivateKey(privateKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, errors.New("gcs: private key is not PEM encoded")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("gcs: private key is not RSA")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// the versions given to the generic artifacts name the files and the folders of the storage
+var genericVersionPattern = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._+-]{0,63}$`)
+
+var (
+	ErrGenericVersionRequired = errors.New("the version is required for the generic artifacts")
+	ErrGenericVersionInvalid  = errors.New("the version must be up to 64 letters, digits, dots, hyphens, underscores and pluses")
+	errGenericContent         = errors.New("the file is neither a zip nor a gzipped tarball")
+)
+
+// BundleFileExtensionOf returns the extension of the file name, which is the double one of a gzipped tarball.
+func BundleFileExtensionOf(filename string) BundleFileExtension {
+	if strings.HasSuffix(filename, string(BundleFileExtensionGenericTarball)) {
+		return BundleFileExtensionGenericTarball
+	}
+	return BundleFileExtension(filepath.Ext(filename))
+}
+
+// IsGeneric tells the zips and the tarballs of the other platforms, e.g. the desktop builds of Electron or Unity.
+func (bundle *Bundle) IsGeneric() bool {
+	return bundle.PlatformType == BundlePlatformTypeGeneric
+}
+
+// IsPlainDownload tells the bundles downloaded as they are, which are not installed by the pages.
+func (bundle *Bundle) IsPlainDownload() bool {
+	return bundle.IsMac() || bundle.IsGeneric()
+}
+
+// NewGenericBundleInfo returns the version and the identifier given to the generic artifact, which are not parsed
+// from the file. The file is only told a zip or a tarball by its content.
+func NewGenericBundleInfo(file *os.File, version, shortVersion, identifier string) (*BundleInfo, error) {
+	if version != "" && !genericVersionPattern.MatchString(version) {
+		return nil, ErrGenericVersionInvalid
+	}
+
+	magic := make([]byte, 4)
+	if _, err := file.ReadAt(magic, 0); err != nil {
+		return nil, errGenericContent
+	}
+	var ext BundleFileExtension
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		ext = BundleFileExtensionGenericZip
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		ext = BundleFileExtensionGenericTarball
+	default:
+		return nil, errGenericContent
+	}
+
+	return &BundleInfo{
+		Version:      version,
+		ShortVersion: strings.TrimSpace(shortVersion),
+		Identifier:   strings.TrimSpace(identifier),
+		PlatformType: BundlePlatformTypeGeneric,
+		Extension:    ext,
+	}, nil
+}
+
+// readBundleInfo parses the file of the bundle, or takes the version given to the upload of a generic artifact.
+func (bundle *Bundle) readBundleInfo() (*BundleInfo, error) {
+	if !bundle.IsGeneric() {
+		return NewBundleInfo(bundle.File, bundle.PlatformType)
+	}
+	if bundle.BundleVersion == "" {
+		return nil, ErrGenericVersionRequired
+	}
+	return NewGenericBundleInfo(bundle.File, bundle.BundleVersion, bundle.ShortVersion, bundle.BundleIdentifier)
+}
@@ -0,0 +1,272 @@
+package models
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+const (
+	GooglePlayScope          = "https://www.googleapis.com/auth/androidpublisher"
+	GooglePlayBaseUrl        = "https://androidpublisher.googleapis.com/androidpublisher/v3"
+	GooglePlayUploadUrl      = "https://androidpublisher.googleapis.com/upload/androidpublisher/v3"
+	GooglePlayInternalTrack  = "internal"
+	googlePlayReleaseStatus  = "completed"
+	googlePlayApkContentType = "application/vnd.android.package-archive"
+	googlePlayAabContentType = "application/octet-stream"
+)
+
+// a PlayCredential is a service account key of the Google Play Console developer account of an app
+// https://developers.google.com/android-publisher/getting_started
+type PlayCredential struct {
+	Id                int       `db:"id"`
+	AppId             int       `db:"app_id"`
+	PackageName       string    `db:"package_name"`
+	ServiceAccountKey string    `db:"service_account_key"`
+	CreatedAt         time.Time `db:"created_at"`
+	UpdatedAt         time.Time `db:"updated_at"`
+}
+
+func (credential *PlayCredential) PreInsert(s gorp.SqlExecutor) error {
+	credential.CreatedAt = time.Now()
+	credential.UpdatedAt = credential.CreatedAt
+	return nil
+}
+
+func (credential *PlayCredential) PreUpdate(s gorp.SqlExecutor) error {
+	credential.UpdatedAt = time.Now()
+	return nil
+}
+
+func (credential *PlayCredential) Validate(v *revel.Validation) {
+	v.Required(credential.PackageName).Message("Package name is required.")
+	v.Required(credential.ServiceAccountKey).Message("Service account key is required.")
+}
+
+// Save inserts the credential, or replaces the credential of the app if already exists.
+func (credential *PlayCredential) Save(txn gorp.SqlExecutor) error {
+	current, err := GetPlayCredential(txn, credential.AppId)
+	if err == sql.ErrNoRows {
+		return txn.Insert(credential)
+	}
+	if err != nil {
+		return err
+	}
+
+	current.PackageName = credential.PackageName
+	current.ServiceAccountKey = credential.ServiceAccountKey
+
+	_, err = txn.Update(current)
+	return err
+}
+
+func (credential *PlayCredential) DeleteFromDB(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(credential)
+	return err
+}
+
+// Seal encrypts the service account key to save it.
+func (credential *PlayCredential) Seal(secret []byte) error {
+	sealed, err := SealCredential(secret, credential.AppId, "play_credential.service_account_key", credential.ServiceAccountKey)
+	if err != nil {
+		return err
+	}
+	credential.ServiceAccountKey = sealed
+	return nil
+}
+
+// Open decrypts the service account key saved, to publish the bundles with.
+func (credential *PlayCredential) Open(secret []byte) error {
+	opened, err := OpenCredential(secret, credential.AppId, "play_credential.service_account_key", credential.ServiceAccountKey)
+	if err != nil {
+		return err
+	}
+	credential.ServiceAccountKey = opened
+	return nil
+}
+
+// ServiceAccountConfig parses the JSON key downloaded from the Google Cloud console.
+func (credential *PlayCredential) ServiceAccountConfig() (*ServiceAccountConfig, error) {
+	var key struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal([]byte(credential.ServiceAccountKey), &key); err != nil {
+		return nil, err
+	}
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, errors.New("google play: service account key has no client_email or private_key")
+	}
+
+	return &ServiceAccountConfig{
+		ClientEmail: key.ClientEmail,
+		PrivateKey:  key.PrivateKey,
+		Scope:       []string{GooglePlayScope},
+	}, nil
+}
+
+func GetPlayCredential(txn gorp.SqlExecutor, appId int) (*PlayCredential, error) {
+	var credential PlayCredential
+	if err := txn.SelectOne(&credential, "SELECT * FROM play_credential WHERE app_id = ?", appId); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// SealPlayCredentials seals the service account keys saved before they were sealed, and returns how many it sealed.
+func SealPlayCredentials(txn gorp.SqlExecutor, secret []byte) (int, error) {
+	var credentials []*PlayCredential
+	if _, err := txn.Select(&credentials, "SELECT * FROM play_credential WHERE service_account_key NOT LIKE ?", sealedCredentialPrefix+"%"); err != nil {
+		return 0, err
+	}
+	for _, credential := range credentials {
+		if err := credential.Seal(secret); err != nil {
+			return 0, err
+		}
+		if _, err := txn.Update(credential); err != nil {
+			return 0, err
+		}
+	}
+	return len(credentials), nil
+}
+
+// ----------------------------------------------------------------------
+// GooglePlay
+// https://developers.google.com/android-publisher/edits
+type GooglePlay struct {
+	PackageName string
+	BaseUrl     string
+	UploadUrl   string
+	Client      *http.Client
+}
+
+type GooglePlayError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *GooglePlayError) Error() string {
+	return fmt.Sprintf("google play: got HTTP response code %d: %s", e.StatusCode, e.Body)
+}
+
+func NewGooglePlay(token *oauth.Token, packageName string) *GooglePlay {
+	return &GooglePlay{
+		PackageName: packageName,
+		BaseUrl:     GooglePlayBaseUrl,
+		UploadUrl:   GooglePlayUploadUrl,
+		Client:      createOAuthClient(token),
+	}
+}
+
+// PublishToInternalTrack uploads the apk, or the aab if appBundle, in a new edit, releases it to the internal testing track
+// and commits the edit. It returns the version code of the uploaded file.
+func (p *GooglePlay) PublishToInternalTrack(file io.Reader, appBundle bool) (int64, error) {
+	var edit struct {
+		Id string `json:"id"`
+	}
+	if err := p.doJson("POST", p.editsUrl(""), struct{}{}, &edit); err != nil {
+		return 0, err
+	}
+	// an uncommitted edit would block the next one, so drop it on failure
+	committed := false
+	defer func() {
+		if !committed {
+			p.doJson("DELETE", p.editsUrl(edit.Id), nil, nil)
+		}
+	}()
+
+	kind, contentType := "apks", googlePlayApkContentType
+	if appBundle {
+		kind, contentType = "bundles", googlePlayAabContentType
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/applications/%s/edits/%s/%s?uploadType=media", p.UploadUrl, p.PackageName, edit.Id, kind), file)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	var apk struct {
+		VersionCode int64 `json:"versionCode"`
+	}
+	if err := p.do(req, &apk); err != nil {
+		return 0, err
+	}
+
+	track := map[string]interface{}{
+		"track": GooglePlayInternalTrack,
+		"releases": []map[string]interface{}{
+			{
+				"versionCodes": []string{fmt.Sprint(apk.VersionCode)},
+				"status":       googlePlayReleaseStatus,
+			},
+		},
+	}
+	if err := p.doJson("PUT", p.editsUrl(edit.Id)+"/tracks/"+GooglePlayInternalTrack, track, nil); err != nil {
+		return 0, err
+	}
+
+	if err := p.doJson("POST", p.editsUrl(edit.Id)+":commit", nil, nil); err != nil {
+		return 0, err
+	}
+	committed = true
+
+	return apk.VersionCode, nil
+}
+
+func (p *GooglePlay) editsUrl(editId string) string {
+	u := fmt.Sprintf("%s/applications/%s/edits", p.BaseUrl, p.PackageName)
+	if editId != "" {
+		u += "/" + editId
+	}
+	return u
+}
+
+func (p *GooglePlay) doJson(method, u string, body interface{}, v interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return p.do(req, v)
+}
+
+func (p *GooglePlay) do(req *http.Request, v interface{}) error {
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return &GooglePlayError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if v == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
@@ -2,11 +2,13 @@ package models
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"code.google.com/p/goauth2/oauth"
 	"code.google.com/p/goauth2/oauth/jwt"
@@ -28,7 +30,12 @@ type ServiceAccountConfig struct {
 	Scope       []string
 }
 
+const (
+	TrashedAtPropertyKey = "alphawingTrashedAt"
+)
+
 type GoogleService struct {
+	PermanentDelete    bool
 	AccessToken        string
 	Client             *http.Client
 	OAuth2Service      *oauth2.Service
@@ -75,9 +82,21 @@ func createOAuthClient(token *oauth.Token) *http.Client {
 	return transport.Client()
 }
 
+// Trace records the requests to Google APIs as the children of the span.
+func (s *GoogleService) Trace(span *Span) {
+	if span == nil || s.Client == nil {
+		return
+	}
+	if transport, ok := s.Client.Transport.(*oauth.Transport); ok {
+		transport.Transport = &TracingTransport{Base: transport.Transport, Parent: span}
+	}
+}
+
 func NewGoogleService(token *oauth.Token) (*GoogleService, error) {
-	client := createOAuthClient(token)
+	return newGoogleService(token, createOAuthClient(token))
+}
 
+func newGoogleService(token *oauth.Token, client *http.Client) (*GoogleService, error) {
 	oauth2Service, err := oauth2.New(client)
 	if err != nil {
 		return nil, err
@@ -108,19 +127,33 @@ func (s *GoogleService) GetTokenInfo() (*oauth2.Tokeninfo, error) {
 }
 
 func (s *GoogleService) CreateFolder(folderName string) (*drive.File, error) {
+	return s.CreateFolderIn(folderName, nil)
+}
+
+func (s *GoogleService) CreateFolderIn(folderName string, parent *drive.ParentReference) (*drive.File, error) {
 	driveFolder := &drive.File{
 		Title:    folderName,
 		MimeType: "application/vnd.google-apps.folder",
 	}
+	if parent != nil {
+		driveFolder.Parents = []*drive.ParentReference{parent}
+	}
 	return s.FilesService.Insert(driveFolder).Do()
 }
 
 func (s *GoogleService) InsertFile(file *os.File, filename string, parent *drive.ParentReference) (*drive.File, error) {
+	return s.InsertFileFrom(file, filename, parent)
+}
+
+// InsertFileFrom uploads the file as it is read from the reader.
+func (s *GoogleService) InsertFileFrom(r io.Reader, filename string, parent *drive.ParentReference) (*drive.File, error) {
 	driveFile := &drive.File{
-		Title:   filename,
-		Parents: []*drive.ParentReference{parent},
+		Title: filename,
 	}
-	return s.FilesService.Insert(driveFile).Media(file).Do()
+	if parent != nil {
+		driveFile.Parents = []*drive.ParentReference{parent}
+	}
+	return s.FilesService.Insert(driveFile).Media(r).Do()
 }
 
 func (s *GoogleService) GetFile(fileId string) (*drive.File, error) {
@@ -158,10 +191,130 @@ func (s *GoogleService) UpdateFileTitle(fileId string, title string) error {
 	return err
 }
 
+func (s *GoogleService) AddParent(fileId string, parentId string) error {
+	_, err := s.FilesService.Patch(fileId, &drive.File{}).AddParents(parentId).Do()
+	return err
+}
+
+func (s *GoogleService) RemoveParent(fileId string, parentId string) error {
+	_, err := s.FilesService.Patch(fileId, &drive.File{}).RemoveParents(parentId).Do()
+	return err
+}
+
+// MoveFile makes the folder the only parent of the file.
+func (s *GoogleService) MoveFile(fileId string, parentId string) error {
+	file, err := s.GetFile(fileId)
+	if err != nil {
+		return err
+	}
+	var parentIds []string
+	for _, parent := range file.Parents {
+		if parent.Id != parentId {
+			parentIds = append(parentIds, parent.Id)
+		}
+	}
+	_, err = s.FilesService.Patch(fileId, &drive.File{}).AddParents(parentId).RemoveParents(strings.Join(parentIds, ",")).Do()
+	return err
+}
+
+// DeleteFile moves the file to the trash unless PermanentDelete is set.
 func (s *GoogleService) DeleteFile(fileId string) error {
+	if s.PermanentDelete {
+		return s.PurgeFile(fileId)
+	}
+	return s.TrashFile(fileId)
+}
+
+// TrashFile moves the file to the trash and remembers when, for PurgeTrashedFiles.
+func (s *GoogleService) TrashFile(fileId string) error {
+	file := &drive.File{
+		Properties: []*drive.Property{
+			&drive.Property{
+				Key:        TrashedAtPropertyKey,
+				Value:      strconv.FormatInt(time.Now().Unix(), 10),
+				Visibility: "PRIVATE",
+			},
+		},
+	}
+	if _, err := s.FilesService.Patch(fileId, file).Do(); err != nil {
+		return err
+	}
+
+	_, err := s.FilesService.Trash(fileId).Do()
+	return err
+}
+
+func (s *GoogleService) PurgeFile(fileId string) error {
 	return s.FilesService.Delete(fileId).Do()
 }
 
+func (s *GoogleService) GetTrashedFiles() ([]*drive.File, error) {
+	var files []*drive.File
+	pageToken := ""
+	for {
+		call := s.FilesService.List().Q("trashed = true")
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		fileList, err := call.Do()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, fileList.Items...)
+
+		pageToken = fileList.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+	return files, nil
+}
+
+// PurgeTrashedFiles permanently deletes the files trashed by TrashFile before the given time.
+// Files trashed along with their folder are deleted together with the folder.
+func (s *GoogleService) PurgeTrashedFiles(before time.Time) (int, error) {
+	files, err := s.GetTrashedFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, file := range files {
+		if !file.ExplicitlyTrashed {
+			continue
+		}
+
+		trashedAt, found := trashedAt(file)
+		if !found || !trashedAt.Before(before) {
+			continue
+		}
+
+		if err := s.PurgeFile(file.Id); err != nil {
+			code, _, _ := ParseGoogleApiError(err)
+			if code != http.StatusNotFound {
+				return count, err
+			}
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+func trashedAt(file *drive.File) (time.Time, bool) {
+	for _, property := range file.Properties {
+		if property.Key != TrashedAtPropertyKey {
+			continue
+		}
+		unix, err := strconv.ParseInt(property.Value, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(unix, 0), true
+	}
+	return time.Time{}, false
+}
+
 func (s *GoogleService) DeleteAllFiles() error {
 	fileList, err := s.GetFileList()
 	if err != nil {
@@ -186,6 +339,14 @@ func (s *GoogleService) CreateUserPermission(email string, role string) *drive.P
 	}
 }
 
+func (s *GoogleService) CreateGroupPermission(email string, role string) *drive.Permission {
+	return &drive.Permission{
+		Role:  role,
+		Type:  "group",
+		Value: email,
+	}
+}
+
 func (s *GoogleService) InsertPermission(fileId string, permission *drive.Permission) (*drive.Permission, error) {
 	return s.PermissionsService.Insert(fileId, permission).Do()
 }
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// a request in progress longer than this is taken as abandoned, e.g. by a restart, and can be retried
+	idempotencyKeyLockTimeout = 10 * time.Minute
+)
+
+var (
+	ErrIdempotencyKeyInProgress = errors.New("A request with the same Idempotency-Key is in progress.")
+	ErrIdempotencyKeyMismatch   = errors.New("Idempotency-Key is already used for another request.")
+)
+
+// an IdempotencyKey records the response of the first request with the key, to be replayed for the retries
+type IdempotencyKey struct {
+	Id            int       `db:"id"`
+	Scope         string    `db:"scope"`
+	Key           string    `db:"idempotency_key"`
+	RequestDigest string    `db:"request_digest"`
+	Status        int       `db:"status"`
+	ContentType   string    `db:"content_type"`
+	Body          []byte    `db:"body"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+func (idempotencyKey *IdempotencyKey) PreInsert(s gorp.SqlExecutor) error {
+	idempotencyKey.CreatedAt = time.Now()
+	return nil
+}
+
+// Completed tells whether the response is recorded. The status is 0 while the request is in progress.
+func (idempotencyKey *IdempotencyKey) Completed() bool {
+	return idempotencyKey.Status != 0
+}
+
+func GetIdempotencyKey(txn gorp.SqlExecutor, scope, key string) (*IdempotencyKey, error) {
+	var idempotencyKey IdempotencyKey
+	err := txn.SelectOne(&idempotencyKey, "SELECT * FROM idempotency_key WHERE scope = ? AND idempotency_key = ?", scope, key)
+	if err != nil {
+		return nil, err
+	}
+	return &idempotencyKey, nil
+}
+
+// AcquireIdempotencyKey locks the key for the request, or returns the completed one to be replayed.
+// The unique key of the scope and the key lets only one of the concurrent requests run.
+func AcquireIdempotencyKey(dbm *gorp.DbMap, scope, key, requestDigest string) (*IdempotencyKey, error) {
+	idempotencyKey := &IdempotencyKey{
+		Scope:         scope,
+		Key:           key,
+		RequestDigest: requestDigest,
+	}
+	insertErr := dbm.Insert(idempotencyKey)
+	if insertErr == nil {
+		return idempotencyKey, nil
+	}
+
+	existing, err := GetIdempotencyKey(dbm, scope, key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, insertErr
+		}
+		return nil, err
+	}
+	if existing.RequestDigest != requestDigest {
+		return nil, ErrIdempotencyKeyMismatch
+	}
+	if existing.Completed() {
+		return existing, nil
+	}
+
+	// take over the abandoned one, unless another retry did it first
+	lockedAt := existing.CreatedAt
+	if time.Since(lockedAt) < idempotencyKeyLockTimeout {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	existing.CreatedAt = time.Now()
+	result, err := dbm.Exec("UPDATE idempotency_key SET created_at = ? WHERE id = ? AND status = 0 AND created_at = ?", existing.CreatedAt, existing.Id, lockedAt)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return existing, nil
+}
+
+// Complete records the response, which is replayed for the retries from now on.
+func (idempotencyKey *IdempotencyKey) Complete(txn gorp.SqlExecutor, status int, contentType string, body []byte) error {
+	idempotencyKey.Status = status
+	idempotencyKey.ContentType = contentType
+	idempotencyKey.Body = body
+	_, err := txn.Update(idempotencyKey)
+	return err
+}
+
+// Release removes the key of a failed request, so that a retry runs it again.
+func (idempotencyKey *IdempotencyKey) Release(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(idempotencyKey)
+	return err
+}
+
+func PurgeIdempotencyKeys(txn gorp.SqlExecutor, before time.Time) (int, error) {
+	result, err := txn.Exec("DELETE FROM idempotency_key WHERE created_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// an InstallRecord is a download of a bundle by a user, for the support to confirm which build the user runs.
+// The Bundle and the App are nil if the bundle is deleted, and the Device is nil unless it is downloaded through a paired device.
+type InstallRecord struct {
+	// the ID of the audit of the download, for the cursor of the next page
+	Id          int
+	BundleId    int
+	Bundle      *Bundle
+	App         *App
+	Device      *PairedDevice
+	InstalledAt time.Time
+}
+
+type InstallRecordJsonResponse struct {
+	AppId        int    `json:"app_id"`
+	AppTitle     string `json:"app_title"`
+	BundleId     int    `json:"bundle_id"`
+	PlatformType string `json:"platform_type"`
+	Version      string `json:"version"`
+	Revision     int    `json:"revision"`
+	Codename     string `json:"codename"`
+	DeviceId     int    `json:"device_id"`
+	DeviceName   string `json:"device_name"`
+	InstalledAt  string `json:"installed_at"`
+}
+
+func (record *InstallRecord) JsonResponse() *InstallRecordJsonResponse {
+	response := &InstallRecordJsonResponse{
+		BundleId:    record.BundleId,
+		InstalledAt: record.InstalledAt.Format(time.RFC3339),
+	}
+	if record.Bundle != nil {
+		response.AppId = record.App.Id
+		response.AppTitle = record.App.Title
+		response.PlatformType = record.Bundle.PlatformType.String()
+		response.Version = record.Bundle.BundleVersion
+		response.Revision = record.Bundle.Revision
+		response.Codename = record.Bundle.Codename
+	}
+	if record.Device != nil {
+		response.DeviceId = record.Device.Id
+		response.DeviceName = record.Device.Name
+	}
+	return response
+}
+
+// GetInstallHistory returns the downloads of the user, of the paired device only unless deviceId is 0, newest first.
+// The downloads below the ID of before are returned, or the latest ones for 0.
+func GetInstallHistory(txn gorp.SqlExecutor, userId, deviceId, before, limit int) ([]*InstallRecord, error) {
+	condition := ""
+	args := []interface{}{ResourceBundle, ActionDownload, userId}
+	if deviceId != 0 {
+		condition += " AND device_id = ?"
+		args = append(args, deviceId)
+	}
+	if before > 0 {
+		condition += " AND id < ?"
+		args = append(args, before)
+	}
+	var audits []*Audit
+	_, err := txn.Select(&audits,
+		"SELECT * FROM audit WHERE resource = ? AND action = ? AND user_id = ?"+condition+" ORDER BY id DESC LIMIT ?",
+		append(args, limit)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	bundles := map[int]*Bundle{}
+	apps := map[int]*App{}
+	devices := map[int]*PairedDevice{}
+	records := []*InstallRecord{}
+	for _, audit := range audits {
+		record := &InstallRecord{Id: audit.Id, BundleId: audit.ResourceId, InstalledAt: audit.CreatedAt}
+
+		bundle, ok := bundles[audit.ResourceId]
+		if !ok {
+			bundle, err = GetBundle(txn, audit.ResourceId)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+			bundles[audit.ResourceId] = bundle
+		}
+		if bundle != nil {
+			app, ok := apps[bundle.AppId]
+			if !ok {
+				app, err = GetApp(txn, bundle.AppId)
+				if err != nil {
+					return nil, err
+				}
+				apps[bundle.AppId] = app
+			}
+			record.Bundle = bundle
+			record.App = app
+		}
+
+		if audit.DeviceId != 0 {
+			device, ok := devices[audit.DeviceId]
+			if !ok {
+				device, err = GetPairedDevice(txn, audit.DeviceId)
+				if err != nil && err != sql.ErrNoRows {
+					return nil, err
+				}
+				devices[audit.DeviceId] = device
+			}
+			record.Device = device
+		}
+
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// a KnownIssue is breakage of a bundle already known to the developers, shown to the testers before they install it
+type KnownIssue struct {
+	Id        int       `db:"id"`
+	BundleId  int       `db:"bundle_id"`
+	Title     string    `db:"title"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+func (issue *KnownIssue) PreInsert(s gorp.SqlExecutor) error {
+	issue.CreatedAt = time.Now()
+	issue.UpdatedAt = issue.CreatedAt
+	return nil
+}
+
+func (issue *KnownIssue) PreUpdate(s gorp.SqlExecutor) error {
+	issue.UpdatedAt = time.Now()
+	return nil
+}
+
+func (issue *KnownIssue) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(issue)
+}
+
+func (issue *KnownIssue) DeleteFromDB(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(issue)
+	return err
+}
+
+func GetKnownIssue(txn gorp.SqlExecutor, id int) (*KnownIssue, error) {
+	var issue KnownIssue
+	if err := txn.SelectOne(&issue, "SELECT * FROM known_issue WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return &issue, nil
+}
+
+// ParseKnownIssues splits the text into the titles of the issues, one per line.
+func ParseKnownIssues(text string) []string {
+	var titles []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*"))
+		if line != "" {
+			titles = append(titles, line)
+		}
+	}
+	return titles
+}
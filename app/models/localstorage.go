@@ -0,0 +1,145 @@
+package models
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errLocalStorageKey = errors.New("the key is outside the root of the local storage")
+
+// LocalStorage keeps the files under a directory of the local disk, for the standalone deployments and the integration tests.
+// The keys are the location and the file name relative to the root, e.g. app_1/1.0/app_1_ver_1.0_rev_1.apk, as the ones of S3.
+type LocalStorage struct {
+	Root string
+}
+
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{Root: root}
+}
+
+func (st *LocalStorage) Put(file *os.File, name, location string) (string, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		return "", err
+	}
+	return st.PutStream(file, stat.Size(), name, location)
+}
+
+// PutStream writes the reader to a temporary file in the directory and renames it,
+// so that a failed upload does not leave a partial file at the key.
+func (st *LocalStorage) PutStream(r io.Reader, size int64, name, location string) (string, error) {
+	key := name
+	if location != "" {
+		key = strings.Trim(location, "/") + "/" + key
+	}
+	filePath, err := st.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), ".upload-")
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return key, nil
+}
+
+func (st *LocalStorage) Get(key string) (*StorageObject, error) {
+	filePath, err := st.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &StorageObject{
+		Body:    file,
+		Name:    path.Base(key),
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}, nil
+}
+
+func (st *LocalStorage) Delete(key string) error {
+	filePath, err := st.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SignedURL returns "", as the files on the local disk are served only by the server.
+func (st *LocalStorage) SignedURL(key string, expiry time.Duration) (string, error) {
+	return "", nil
+}
+
+// path returns the file of the key, refusing the keys out of the root, e.g. with "..".
+func (st *LocalStorage) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if key == "" || cleaned == "/" || cleaned != "/"+key {
+		return "", errLocalStorageKey
+	}
+	return filepath.Join(st.Root, filepath.FromSlash(cleaned)), nil
+}
+
+// List walks the files under the root. The temporary files of the uploads are listed too, as one left by a crash is an orphan.
+func (st *LocalStorage) List(fn func(entry *StorageEntry) error) error {
+	err := filepath.Walk(st.Root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(st.Root, filePath)
+		if err != nil {
+			return err
+		}
+		return fn(&StorageEntry{
+			Key:     filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	})
+	// nothing is put yet
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	machoFatMagic   = 0xcafebabe
+	machoFatMagic64 = 0xcafebabf
+	machoMagic      = 0xfeedface
+	machoMagic64    = 0xfeedfacf
+	machoUuid       = 0x1b
+
+	// the load commands are a few KB, so a larger size is of a broken file
+	maxMachoCommandsSize = 16 * 1024 * 1024
+)
+
+// the files directly in the app, not in the frameworks and the extensions
+var ipaAppFilePattern = regexp.MustCompile(`^Payload/[^/]+\.app$`)
+
+// isIpaExecutable tells whether the entry is the executable of the app told by Info.plist.
+func isIpaExecutable(name string, bundleInfo *BundleInfo) bool {
+	return bundleInfo != nil && bundleInfo.Executable != "" && ipaAppFilePattern.MatchString(path.Dir(name)) && path.Base(name) == bundleInfo.Executable
+}
+
+// readMachoFat reads the architectures of the universal executable whose magic is read, and returns their offsets in order.
+func readMachoFat(macho *countingReader, magic uint32) ([]uint64, error) {
+	var archs uint32
+	if err := binary.Read(macho, binary.BigEndian, &archs); err != nil {
+		return nil, err
+	}
+	if archs == 0 || archs > 64 {
+		return nil, errors.New("the architectures of the executable are broken")
+	}
+	offsets := make([]uint64, 0, archs)
+	for i := uint32(0); i < archs; i++ {
+		if magic == machoFatMagic64 {
+			var arch struct {
+				CpuType, CpuSubtype uint32
+				Offset, Size        uint64
+				Align, Reserved     uint32
+			}
+			if err := binary.Read(macho, binary.BigEndian, &arch); err != nil {
+				return nil, err
+			}
+			offsets = append(offsets, arch.Offset)
+		} else {
+			var arch struct{ CpuType, CpuSubtype, Offset, Size, Align uint32 }
+			if err := binary.Read(macho, binary.BigEndian, &arch); err != nil {
+				return nil, err
+			}
+			offsets = append(offsets, uint64(arch.Offset))
+		}
+	}
+	sort.Sort(offsetSlice(offsets))
+	return offsets, nil
+}
+
+type offsetSlice []uint64
+
+func (s offsetSlice) Len() int           { return len(s) }
+func (s offsetSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s offsetSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// readMachoCommands reads the header and the load commands of the thin executable whose magic is read.
+func readMachoCommands(macho *countingReader, magic uint32) ([]byte, error) {
+	// the executables of iOS are little endian
+	var headerSize int64
+	switch swapUint32(magic) {
+	case machoMagic:
+		headerSize = 28
+	case machoMagic64:
+		headerSize = 32
+	default:
+		return nil, errors.New("the executable is not a Mach-O file")
+	}
+	header := make([]byte, headerSize-4)
+	if _, err := io.ReadFull(macho, header); err != nil {
+		return nil, err
+	}
+	commandsSize := binary.LittleEndian.Uint32(header[16:])
+	if commandsSize > maxMachoCommandsSize {
+		return nil, errors.New("the load commands of the executable are too large")
+	}
+	commands := make([]byte, commandsSize)
+	if _, err := io.ReadFull(macho, commands); err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// eachMachoCommand calls f with the type and the bytes of each load command.
+func eachMachoCommand(commands []byte, f func(cmd uint32, command []byte)) error {
+	for len(commands) >= 8 {
+		cmd := binary.LittleEndian.Uint32(commands)
+		size := binary.LittleEndian.Uint32(commands[4:])
+		if size < 8 || int(size) > len(commands) {
+			return errors.New("the load commands of the executable are truncated")
+		}
+		f(cmd, commands[:size])
+		commands = commands[size:]
+	}
+	return nil
+}
+
+// formatMachoUuid formats the UUID of the executable as dwarfdump and the crash reports do.
+func formatMachoUuid(b []byte) string {
+	s := strings.ToUpper(hex.EncodeToString(b))
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+func swapUint32(v uint32) uint32 {
+	return v>>24 | v>>8&0xff00 | v<<8&0xff0000 | v<<24
+}
+
+// a countingReader counts the bytes read, to skip to the offsets in the file it reads through
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) skipTo(offset int64) error {
+	if offset < c.n {
+		return errors.New("the offset is before the part read")
+	}
+	_, err := io.CopyN(ioutil.Discard, c, offset-c.n)
+	return err
+}
@@ -0,0 +1,438 @@
+package models
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/DHowett/go-plist"
+)
+
+const (
+	xarMagic  = "xar!"
+	udifMagic = "koly"
+	// the size of the UDIF trailer at the end of a disk image
+	udifTrailerSize = 512
+	// the Info.plist of an app and the PackageInfo of a package are small, and the larger ones are not read
+	macMetadataMaxSize = 1 << 20
+	// hdiutil splits the blocks into the chunks of 1MB, so a larger one is of a broken image
+	udifChunkMaxSize = 16 * macMetadataMaxSize
+)
+
+// the types of the chunks of the blocks of a disk image
+const (
+	udifChunkZero       = 0x00000000
+	udifChunkRaw        = 0x00000001
+	udifChunkIgnore     = 0x00000002
+	udifChunkZlib       = 0x80000005
+	udifChunkBzip2      = 0x80000006
+	udifChunkComment    = 0x7ffffffe
+	udifChunkTerminator = 0xffffffff
+)
+
+var errDmgCompression = errors.New("the compression of the disk image is not supported, create it in UDZO, UDBZ or UDRO")
+
+type xarHeader struct {
+	Magic                 [4]byte
+	Size                  uint16
+	Version               uint16
+	TocLengthCompressed   uint64
+	TocLengthUncompressed uint64
+	ChecksumAlgorithm     uint32
+}
+
+type xarToc struct {
+	Files []*xarFile `xml:"toc>file"`
+}
+
+type xarFile struct {
+	Name string `xml:"name"`
+	Type string `xml:"type"`
+	Data struct {
+		Offset   int64 `xml:"offset"`
+		Length   int64 `xml:"length"`
+		Encoding struct {
+			Style string `xml:"style,attr"`
+		} `xml:"encoding"`
+	} `xml:"data"`
+	Files []*xarFile `xml:"file"`
+}
+
+// the PackageInfo of a component package, which pkgbuild writes with the keys of the Info.plist of the bundles it installs
+type pkgInfo struct {
+	Identifier string `xml:"identifier,attr"`
+	Version    string `xml:"version,attr"`
+	Bundles    []struct {
+		Path         string `xml:"path,attr"`
+		Id           string `xml:"id,attr"`
+		ShortVersion string `xml:"CFBundleShortVersionString,attr"`
+		Version      string `xml:"CFBundleVersion,attr"`
+	} `xml:"bundle"`
+}
+
+// the Distribution of a product archive, for the packages of no app
+type pkgDistribution struct {
+	Product struct {
+		Id      string `xml:"id,attr"`
+		Version string `xml:"version,attr"`
+	} `xml:"product"`
+	PkgRefs []struct {
+		Id      string `xml:"id,attr"`
+		Version string `xml:"version,attr"`
+	} `xml:"pkg-ref"`
+}
+
+type macInfo struct {
+	CFBundlePackageType        string      `plist:"CFBundlePackageType"`
+	CFBundleIdentifier         string      `plist:"CFBundleIdentifier"`
+	CFBundleVersion            string      `plist:"CFBundleVersion"`
+	CFBundleShortVersionString string      `plist:"CFBundleShortVersionString"`
+	LSUIElement                interface{} `plist:"LSUIElement"`
+	LSBackgroundOnly           interface{} `plist:"LSBackgroundOnly"`
+}
+
+type udifResourceFork struct {
+	ResourceFork struct {
+		Blkx []struct {
+			Name string `plist:"Name"`
+			Data []byte `plist:"Data"`
+		} `plist:"blkx"`
+	} `plist:"resource-fork"`
+}
+
+type udifChunk struct {
+	Type             uint32
+	Comment          uint32
+	SectorNumber     uint64
+	SectorCount      uint64
+	CompressedOffset uint64
+	CompressedLength uint64
+}
+
+// IsMac tells the .dmg and the .pkg of macOS, which are downloaded as they are.
+func (bundle *Bundle) IsMac() bool {
+	return bundle.PlatformType == BundlePlatformTypeMacOS
+}
+
+// parseMacFile tells a disk image from a package by its content, as the aab is told from the apk.
+func parseMacFile(file *os.File) (*BundleInfo, error) {
+	magic := make([]byte, 4)
+	if _, err := file.ReadAt(magic, 0); err != nil {
+		return nil, err
+	}
+	if string(magic) == xarMagic {
+		return parsePkgFile(file)
+	}
+	return parseDmgFile(file)
+}
+
+// parsePkgFile reads the app of the PackageInfo of the package, or of the first component of a product archive.
+// The package of no app is the version of its product, or of its component.
+func parsePkgFile(file *os.File) (*BundleInfo, error) {
+	var header xarHeader
+	if err := binary.Read(io.NewSectionReader(file, 0, 28), binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	tocReader, err := zlib.NewReader(io.NewSectionReader(file, int64(header.Size), int64(header.TocLengthCompressed)))
+	if err != nil {
+		return nil, err
+	}
+	var toc xarToc
+	err = xml.NewDecoder(io.LimitReader(tocReader, int64(header.TocLengthUncompressed))).Decode(&toc)
+	tocReader.Close()
+	if err != nil {
+		return nil, err
+	}
+	heap := int64(header.Size) + int64(header.TocLengthCompressed)
+
+	var packageInfos []*xarFile
+	var distribution *xarFile
+	for _, f := range toc.Files {
+		switch {
+		case f.Name == "PackageInfo":
+			packageInfos = append(packageInfos, f)
+		case f.Name == "Distribution":
+			distribution = f
+		case f.Type == "directory" && path.Ext(f.Name) == ".pkg":
+			for _, child := range f.Files {
+				if child.Name == "PackageInfo" {
+					packageInfos = append(packageInfos, child)
+				}
+			}
+		}
+	}
+
+	bundleInfo := &BundleInfo{
+		PlatformType: BundlePlatformTypeMacOS,
+		Extension:    BundleFileExtensionMacOSPackage,
+	}
+	var infos []*pkgInfo
+	for _, f := range packageInfos {
+		buf, err := readXarFile(file, heap, f)
+		if err != nil {
+			return nil, err
+		}
+		info := &pkgInfo{}
+		if err := xml.Unmarshal(buf, info); err != nil {
+			return nil, err
+		}
+		for _, b := range info.Bundles {
+			if path.Ext(b.Path) == ".app" && b.Id != "" {
+				bundleInfo.Identifier = b.Id
+				bundleInfo.Version = b.Version
+				bundleInfo.ShortVersion = b.ShortVersion
+				return bundleInfo, nil
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	if distribution != nil {
+		buf, err := readXarFile(file, heap, distribution)
+		if err != nil {
+			return nil, err
+		}
+		dist := &pkgDistribution{}
+		if err := xml.Unmarshal(buf, dist); err != nil {
+			return nil, err
+		}
+		if dist.Product.Version != "" {
+			bundleInfo.Identifier = dist.Product.Id
+			bundleInfo.Version = dist.Product.Version
+			return bundleInfo, nil
+		}
+		for _, ref := range dist.PkgRefs {
+			if ref.Version != "" {
+				bundleInfo.Identifier = ref.Id
+				bundleInfo.Version = ref.Version
+				return bundleInfo, nil
+			}
+		}
+	}
+	if len(infos) > 0 {
+		bundleInfo.Identifier = infos[0].Identifier
+		bundleInfo.Version = infos[0].Version
+		return bundleInfo, nil
+	}
+	return nil, errors.New("PackageInfo is not found in the package")
+}
+
+func readXarFile(file *os.File, heap int64, f *xarFile) ([]byte, error) {
+	var r io.Reader = io.NewSectionReader(file, heap+f.Data.Offset, f.Data.Length)
+	switch f.Data.Encoding.Style {
+	case "application/x-gzip":
+		// xar calls its zlib streams gzip
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "application/x-bzip2":
+		r = bzip2.NewReader(r)
+	case "", "application/octet-stream":
+	default:
+		return nil, fmt.Errorf("the encoding of %s is not supported: %s", f.Name, f.Data.Encoding.Style)
+	}
+	return ioutil.ReadAll(io.LimitReader(r, macMetadataMaxSize))
+}
+
+// parseDmgFile reads the Info.plist of the app in the disk image. The file system in it is not read, but the blocks
+// are searched for the XML property lists of the apps, which Xcode writes for macOS. A helper app, e.g. the one of
+// an updater framework, is an agent or runs in the background, so the first app of neither is the one of the image.
+func parseDmgFile(file *os.File) (*BundleInfo, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < udifTrailerSize {
+		return nil, errors.New("the file is neither a disk image nor a package")
+	}
+	trailer := make([]byte, udifTrailerSize)
+	if _, err := file.ReadAt(trailer, stat.Size()-udifTrailerSize); err != nil {
+		return nil, err
+	}
+	if string(trailer[:4]) != udifMagic {
+		return nil, errors.New("the file is neither a disk image nor a package")
+	}
+	dataForkOffset := int64(binary.BigEndian.Uint64(trailer[24:]))
+	xmlOffset := int64(binary.BigEndian.Uint64(trailer[216:]))
+	xmlLength := int64(binary.BigEndian.Uint64(trailer[224:]))
+	// the offsets are read as unsigned, so the broken ones are negative or beyond the file
+	if xmlOffset < 0 || xmlLength <= 0 || xmlLength > macMetadataMaxSize*16 || xmlLength > stat.Size()-xmlOffset {
+		return nil, errors.New("the blocks of the disk image are not found")
+	}
+	buf := make([]byte, xmlLength)
+	if _, err := file.ReadAt(buf, xmlOffset); err != nil {
+		return nil, err
+	}
+	var resource udifResourceFork
+	if _, err := plist.Unmarshal(buf, &resource); err != nil {
+		return nil, err
+	}
+
+	scanner := &macInfoScanner{}
+	for _, blkx := range resource.ResourceFork.Blkx {
+		chunks, err := parseUdifBlocks(blkx.Data)
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range chunks {
+			data, err := readUdifChunk(file, dataForkOffset, chunk)
+			if err != nil {
+				return nil, err
+			}
+			if data == nil {
+				scanner.Reset()
+				continue
+			}
+			if scanner.Scan(data) {
+				return scanner.BundleInfo(), nil
+			}
+		}
+	}
+	if scanner.found != nil {
+		return scanner.BundleInfo(), nil
+	}
+	return nil, errors.New("Info.plist of the app is not found in the disk image")
+}
+
+// parseUdifBlocks reads the chunks of the mish block table of a partition.
+func parseUdifBlocks(data []byte) ([]*udifChunk, error) {
+	if len(data) < 204 || string(data[:4]) != "mish" {
+		return nil, errors.New("the block table of the disk image is broken")
+	}
+	count := int(binary.BigEndian.Uint32(data[200:]))
+	if len(data) < 204+count*40 {
+		return nil, errors.New("the block table of the disk image is broken")
+	}
+	chunks := make([]*udifChunk, 0, count)
+	r := bytes.NewReader(data[204:])
+	for i := 0; i < count; i++ {
+		chunk := &udifChunk{}
+		if err := binary.Read(r, binary.BigEndian, chunk); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// readUdifChunk returns the sectors of the chunk, or nil for the ones of no data.
+func readUdifChunk(file *os.File, dataForkOffset int64, chunk *udifChunk) ([]byte, error) {
+	var r io.Reader = io.NewSectionReader(file, dataForkOffset+int64(chunk.CompressedOffset), int64(chunk.CompressedLength))
+	switch chunk.Type {
+	case udifChunkZero, udifChunkIgnore, udifChunkComment, udifChunkTerminator:
+		return nil, nil
+	case udifChunkRaw:
+	case udifChunkZlib:
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case udifChunkBzip2:
+		r = bzip2.NewReader(r)
+	default:
+		return nil, errDmgCompression
+	}
+	if chunk.SectorCount > udifChunkMaxSize/512 {
+		return nil, errors.New("the chunk of the disk image is too large")
+	}
+	return ioutil.ReadAll(io.LimitReader(r, int64(chunk.SectorCount)*512))
+}
+
+// a macInfoScanner searches the property lists of the apps in the data of the blocks, which may span the chunks.
+type macInfoScanner struct {
+	window []byte
+	found  *macInfo
+	app    *macInfo
+}
+
+func (s *macInfoScanner) Reset() {
+	s.window = nil
+}
+
+// Scan tells whether the app which is neither an agent nor in the background is found.
+func (s *macInfoScanner) Scan(data []byte) bool {
+	s.window = append(s.window, data...)
+	for {
+		start := bytes.Index(s.window, []byte("<?xml"))
+		if start < 0 {
+			// the head of the declaration may be at the end
+			if len(s.window) > 4 {
+				s.window = s.window[len(s.window)-4:]
+			}
+			return false
+		}
+		s.window = s.window[start:]
+		end := bytes.Index(s.window, []byte("</plist>"))
+		if end < 0 {
+			if len(s.window) > macMetadataMaxSize {
+				s.window = s.window[1:]
+				continue
+			}
+			return false
+		}
+		end += len("</plist>")
+		candidate := s.window[:end]
+		s.window = s.window[end:]
+		// the declaration before is of another file, which is not a property list
+		if i := bytes.LastIndex(candidate, []byte("<?xml")); i > 0 {
+			candidate = candidate[i:]
+		}
+		if !bytes.Contains(candidate, []byte("CFBundleIdentifier")) {
+			continue
+		}
+		info := &macInfo{}
+		if _, err := plist.Unmarshal(candidate, info); err != nil || info.CFBundlePackageType != "APPL" || info.CFBundleIdentifier == "" {
+			continue
+		}
+		if s.found == nil {
+			s.found = info
+		}
+		if !plistTrue(info.LSUIElement) && !plistTrue(info.LSBackgroundOnly) {
+			s.app = info
+			return true
+		}
+	}
+}
+
+func (s *macInfoScanner) BundleInfo() *BundleInfo {
+	info := s.app
+	if info == nil {
+		info = s.found
+	}
+	return &BundleInfo{
+		Version:      info.CFBundleVersion,
+		ShortVersion: info.CFBundleShortVersionString,
+		Identifier:   info.CFBundleIdentifier,
+		PlatformType: BundlePlatformTypeMacOS,
+		Extension:    BundleFileExtensionMacOSDiskImage,
+	}
+}
+
+// plistTrue reads the boolean keys, which the older apps have as the strings or the integers.
+func plistTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "1" || strings.EqualFold(v, "YES") || strings.EqualFold(v, "true")
+	case uint64:
+		return v != 0
+	case int64:
+		return v != 0
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+)
+
+// a Mailer sends plain text notifications through an SMTP server
+type Mailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (mailer *Mailer) Send(to []string, subject, body string) error {
+	if len(to) == 0 {
+		return nil
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", mailer.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(body)
+
+	var auth smtp.Auth
+	if mailer.Username != "" {
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", mailer.Host, mailer.Port)
+	return smtp.SendMail(addr, auth, mailer.From, to, msg.Bytes())
+}
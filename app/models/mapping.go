@@ -0,0 +1,174 @@
+package models
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+var (
+	ErrMappingNotApk      = errors.New("the mapping must be of an apk or an aab")
+	ErrMappingInvalid     = errors.New("the file is not a mapping of ProGuard or R8")
+	ErrMappingDuplicated  = errors.New("the mapping is already uploaded")
+	ErrMappingVersionCode = errors.New("the bundle has no versionCode")
+)
+
+// a BundleMapping is the mapping.txt of ProGuard or R8 of the build of an apk or an aab, kept next to it for
+// the deobfuscation of the stack traces of the crashes, which tell the versionCode of the bundle.
+type BundleMapping struct {
+	Id        int       `db:"id"`
+	BundleId  int       `db:"bundle_id"`
+	FileId    string    `db:"file_id"`
+	FileSize  int64     `db:"file_size"`
+	Digest    string    `db:"digest"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type BundleMappingJsonResponse struct {
+	Id          int    `json:"id"`
+	BundleId    int    `json:"bundle_id"`
+	VersionCode string `json:"version_code"`
+	FileSize    int64  `json:"file_size"`
+	Digest      string `json:"digest"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func (mapping *BundleMapping) PreInsert(s gorp.SqlExecutor) error {
+	mapping.CreatedAt = time.Now()
+	return nil
+}
+
+func (mapping *BundleMapping) JsonResponse(bundle *Bundle) *BundleMappingJsonResponse {
+	return &BundleMappingJsonResponse{
+		Id:          mapping.Id,
+		BundleId:    mapping.BundleId,
+		VersionCode: bundle.VersionCode,
+		FileSize:    mapping.FileSize,
+		Digest:      mapping.Digest,
+		CreatedAt:   mapping.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func (mapping *BundleMapping) FileName(bundle *Bundle) string {
+	return fmt.Sprintf("app_%d_ver_%s_rev_%d_mapping.txt", bundle.AppId, bundle.VersionCode, bundle.Revision)
+}
+
+// isMappingFile tells the mapping by its first line other than the comments, which maps a class as "a.B -> c:".
+func isMappingFile(file *os.File) (bool, error) {
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		return false, err
+	}
+	defer file.Seek(0, os.SEEK_SET)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return strings.Contains(line, " -> ") && strings.HasSuffix(line, ":"), nil
+	}
+	return false, scanner.Err()
+}
+
+func (bundle *Bundle) Mapping(txn gorp.SqlExecutor) (*BundleMapping, error) {
+	var mapping BundleMapping
+	if err := txn.SelectOne(&mapping, "SELECT * FROM bundle_mapping WHERE bundle_id = ?", bundle.Id); err != nil {
+		return nil, err
+	}
+	return &mapping, nil
+}
+
+// MappingByVersionCode returns the mapping of the newest bundle of the app with the versionCode, or sql.ErrNoRows.
+func (app *App) MappingByVersionCode(txn gorp.SqlExecutor, versionCode string) (*BundleMapping, *Bundle, error) {
+	var mapping BundleMapping
+	err := txn.SelectOne(&mapping, "SELECT bundle_mapping.* FROM bundle_mapping JOIN bundle ON bundle.id = bundle_mapping.bundle_id WHERE bundle.app_id = ? AND bundle.version_code = ? ORDER BY bundle.id DESC LIMIT 1", app.Id, versionCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle, err := GetBundle(txn, mapping.BundleId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &mapping, bundle, nil
+}
+
+// AddMapping stores the mapping of the apk or the aab, which has one mapping only.
+func (bundle *Bundle) AddMapping(dbm *gorp.DbMap, storage Storage, file *os.File) (*BundleMapping, error) {
+	if !bundle.IsApk() {
+		return nil, ErrMappingNotApk
+	}
+	if bundle.VersionCode == "" {
+		return nil, ErrMappingVersionCode
+	}
+	ok, err := isMappingFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrMappingInvalid
+	}
+
+	count, err := dbm.SelectInt("SELECT COUNT(id) FROM bundle_mapping WHERE bundle_id = ?", bundle.Id)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrMappingDuplicated
+	}
+	digest, err := FileDigest(file)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	app, err := bundle.App(dbm)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.CheckStorageQuota(dbm, stat.Size(), ""); err != nil {
+		return nil, err
+	}
+	location, err := app.versionLocation(dbm, storage, bundle.BundleVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := &BundleMapping{
+		BundleId: bundle.Id,
+		FileSize: stat.Size(),
+		Digest:   digest,
+	}
+	mapping.FileId, err = storage.Put(file, mapping.FileName(bundle), location)
+	if err != nil {
+		return nil, err
+	}
+	if err := dbm.Insert(mapping); err != nil {
+		storage.Delete(mapping.FileId)
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// DeleteMapping deletes the file of the mapping, which is of the bundle only.
+func (bundle *Bundle) DeleteMapping(txn gorp.SqlExecutor, storage Storage) error {
+	var mappings []*BundleMapping
+	if _, err := txn.Select(&mappings, "SELECT * FROM bundle_mapping WHERE bundle_id = ?", bundle.Id); err != nil {
+		return err
+	}
+	for _, mapping := range mappings {
+		if err := storage.Delete(mapping.FileId); err != nil {
+			return err
+		}
+	}
+	_, err := txn.Exec("DELETE FROM bundle_mapping WHERE bundle_id = ?", bundle.Id)
+	return err
+}
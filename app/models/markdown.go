@@ -0,0 +1,139 @@
+package models
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	markdownHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)[\s#]*$`)
+	markdownListItem    = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	markdownOrderedItem = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	markdownQuote       = regexp.MustCompile(`^\s*&gt;\s?(.*)$`)
+	markdownRule        = regexp.MustCompile(`^\s*(-{3,}|\*{3,}|_{3,})\s*$`)
+	markdownCode        = regexp.MustCompile("`([^`]+)`")
+	markdownLink        = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	markdownStrong      = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	markdownEm          = regexp.MustCompile(`\*([^*]+)\*`)
+)
+
+// RenderMarkdownOnServer renders the common subset of Markdown without the GitHub API, for the texts which must not leave the server.
+// The text is escaped first, so that no HTML in it is rendered, and only the http, https and mailto links are made.
+func RenderMarkdownOnServer(md string) string {
+	r := &markdownRenderer{}
+	for _, line := range strings.Split(strings.Replace(md, "\r\n", "\n", -1), "\n") {
+		r.line(line)
+	}
+	r.closeBlock()
+	return r.out.String()
+}
+
+type markdownRenderer struct {
+	out   bytes.Buffer
+	block string
+	lines []string
+}
+
+func (r *markdownRenderer) line(line string) {
+	if r.block == "pre" {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			r.closeBlock()
+		} else {
+			r.lines = append(r.lines, html.EscapeString(line))
+		}
+		return
+	}
+	if strings.HasPrefix(strings.TrimSpace(line), "```") {
+		r.closeBlock()
+		r.block = "pre"
+		return
+	}
+
+	escaped := html.EscapeString(line)
+	switch {
+	case strings.TrimSpace(line) == "":
+		r.closeBlock()
+	case markdownRule.MatchString(line):
+		r.closeBlock()
+		r.out.WriteString("<hr />\n")
+	case markdownHeading.MatchString(escaped):
+		r.closeBlock()
+		m := markdownHeading.FindStringSubmatch(escaped)
+		level := strconv.Itoa(len(m[1]))
+		r.out.WriteString("<h" + level + ">" + renderMarkdownInline(m[2]) + "</h" + level + ">\n")
+	case markdownListItem.MatchString(escaped):
+		r.openBlock("ul")
+		r.lines = append(r.lines, markdownListItem.FindStringSubmatch(escaped)[1])
+	case markdownOrderedItem.MatchString(escaped):
+		r.openBlock("ol")
+		r.lines = append(r.lines, markdownOrderedItem.FindStringSubmatch(escaped)[1])
+	case markdownQuote.MatchString(escaped):
+		r.openBlock("blockquote")
+		r.lines = append(r.lines, markdownQuote.FindStringSubmatch(escaped)[1])
+	default:
+		if r.block == "" {
+			r.block = "p"
+		}
+		// a line after a list item continues it
+		if (r.block == "ul" || r.block == "ol") && len(r.lines) > 0 {
+			r.lines[len(r.lines)-1] += " " + strings.TrimSpace(escaped)
+			return
+		}
+		r.lines = append(r.lines, escaped)
+	}
+}
+
+func (r *markdownRenderer) openBlock(block string) {
+	if r.block != block {
+		r.closeBlock()
+		r.block = block
+	}
+}
+
+func (r *markdownRenderer) closeBlock() {
+	switch r.block {
+	case "pre":
+		r.out.WriteString("<pre><code>" + strings.Join(r.lines, "\n") + "</code></pre>\n")
+	case "ul", "ol":
+		r.out.WriteString("<" + r.block + ">\n")
+		for _, item := range r.lines {
+			r.out.WriteString("<li>" + renderMarkdownInline(item) + "</li>\n")
+		}
+		r.out.WriteString("</" + r.block + ">\n")
+	case "blockquote":
+		r.out.WriteString("<blockquote><p>" + renderMarkdownInline(strings.Join(r.lines, "<br />\n")) + "</p></blockquote>\n")
+	case "p":
+		r.out.WriteString("<p>" + renderMarkdownInline(strings.Join(r.lines, "<br />\n")) + "</p>\n")
+	}
+	r.block = ""
+	r.lines = nil
+}
+
+// renderMarkdownInline formats the escaped text, leaving the code spans as they are.
+func renderMarkdownInline(text string) string {
+	var out bytes.Buffer
+	last := 0
+	for _, loc := range markdownCode.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(renderMarkdownEmphasis(text[last:loc[0]]))
+		out.WriteString("<code>" + text[loc[2]:loc[3]] + "</code>")
+		last = loc[1]
+	}
+	out.WriteString(renderMarkdownEmphasis(text[last:]))
+	return out.String()
+}
+
+func renderMarkdownEmphasis(text string) string {
+	text = markdownLink.ReplaceAllStringFunc(text, func(link string) string {
+		m := markdownLink.FindStringSubmatch(link)
+		lower := strings.ToLower(m[2])
+		if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") && !strings.HasPrefix(lower, "mailto:") {
+			return link
+		}
+		return `<a href="` + m[2] + `">` + m[1] + `</a>`
+	})
+	text = markdownStrong.ReplaceAllString(text, "<strong>$1</strong>")
+	return markdownEm.ReplaceAllString(text, "<em>$1</em>")
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+)
+
+const (
+	MdmProviderSimpleMdm = "simplemdm"
+
+	SimpleMdmBaseUrl = "https://a.simplemdm.com/api/v1"
+)
+
+// a MdmProvider installs bundles on the managed devices through an MDM
+type MdmProvider interface {
+	// PushInstall uploads the bundle file as the app remembered by the group, creating the app
+	// on the MDM if needed, and pushes it to the devices of the group.
+	// It returns the ID of the app on the MDM.
+	PushInstall(group *DeviceGroup, filename string, file io.Reader) (string, error)
+}
+
+type MdmError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *MdmError) Error() string {
+	return fmt.Sprintf("mdm: got HTTP response code %d: %s", e.StatusCode, e.Body)
+}
+
+func NewMdmProvider(provider, apiKey string) (MdmProvider, error) {
+	switch provider {
+	case MdmProviderSimpleMdm:
+		return &SimpleMdm{
+			ApiKey:  apiKey,
+			BaseUrl: SimpleMdmBaseUrl,
+			Client:  &http.Client{},
+		}, nil
+	default:
+		return nil, errors.New("unsupported mdm provider: " + provider)
+	}
+}
+
+// ----------------------------------------------------------------------
+// SimpleMdm
+// https://simplemdm.com/docs/api/
+type SimpleMdm struct {
+	ApiKey  string
+	BaseUrl string
+	Client  *http.Client
+}
+
+type simpleMdmAppResponse struct {
+	Data struct {
+		Id int `json:"id"`
+	} `json:"data"`
+}
+
+func (m *SimpleMdm) PushInstall(group *DeviceGroup, filename string, file io.Reader) (string, error) {
+	appId, err := m.uploadApp(group.MdmAppId, filename, file)
+	if err != nil {
+		return "", err
+	}
+
+	if group.MdmAppId == "" {
+		path := fmt.Sprintf("/assignment_groups/%s/apps/%s", group.MdmGroupId, appId)
+		if _, err := m.do("POST", path, "", nil); err != nil {
+			return "", err
+		}
+	}
+
+	path := fmt.Sprintf("/assignment_groups/%s/push_apps", group.MdmGroupId)
+	if _, err := m.do("POST", path, "", nil); err != nil {
+		return "", err
+	}
+
+	return appId, nil
+}
+
+// uploadApp creates the app, or replaces the binary of the app if appId is given.
+func (m *SimpleMdm) uploadApp(appId string, filename string, file io.Reader) (string, error) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		part, err := writer.CreateFormFile("binary", filename)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(part, file); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(writer.Close())
+	}()
+
+	method, path := "POST", "/apps"
+	if appId != "" {
+		method, path = "PATCH", "/apps/"+appId
+	}
+
+	body, err := m.do(method, path, writer.FormDataContentType(), pr)
+	if err != nil {
+		return "", err
+	}
+
+	res := &simpleMdmAppResponse{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return "", err
+	}
+	return fmt.Sprint(res.Data.Id), nil
+}
+
+func (m *SimpleMdm) do(method, path, contentType string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, m.BaseUrl+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(m.ApiKey, "")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return nil, &MdmError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	return b, nil
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"database/sql"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// a NotificationRoute posts the new bundles matching it to a Slack incoming webhook,
+// e.g. the beta bundles to #qa and the production ones to #release.
+// The empty channel and tag, and the zero platform type, match any bundle.
+type NotificationRoute struct {
+	Id           int                `db:"id"`
+	AppId        int                `db:"app_id"`
+	Channel      string             `db:"channel"`
+	PlatformType BundlePlatformType `db:"platform_type"`
+	Tag          string             `db:"tag"`
+	WebhookUrl   string             `db:"webhook_url"`
+	CreatedAt    time.Time          `db:"created_at"`
+	UpdatedAt    time.Time          `db:"updated_at"`
+}
+
+func (route *NotificationRoute) PreInsert(s gorp.SqlExecutor) error {
+	route.CreatedAt = time.Now()
+	route.UpdatedAt = route.CreatedAt
+	return nil
+}
+
+func (route *NotificationRoute) PreUpdate(s gorp.SqlExecutor) error {
+	route.UpdatedAt = time.Now()
+	return nil
+}
+
+func (route *NotificationRoute) Validate(v *revel.Validation) {
+	v.Required(route.WebhookUrl).Message("Webhook URL is required.")
+	v.Required(IsSlackWebhookUrl(route.WebhookUrl)).Message("Webhook URL must be a Slack incoming webhook URL.")
+	v.Required(route.PlatformType == 0 || route.PlatformType == BundlePlatformTypeAndroid || route.PlatformType == BundlePlatformTypeIOS).Message("Platform is invalid.")
+}
+
+// the routes are added by the developers, so that they post only to Slack and not to the hosts in the private network
+const slackWebhookHost = "hooks.slack.com"
+
+// IsSlackWebhookUrl tells whether the URL is of a Slack incoming webhook.
+func IsSlackWebhookUrl(webhookUrl string) bool {
+	u, err := url.Parse(webhookUrl)
+	return err == nil && u.Scheme == "https" && u.Host == slackWebhookHost && u.User == nil
+}
+
+// Matches tells whether the new bundle is posted to the route.
+func (route *NotificationRoute) Matches(bundle *Bundle) bool {
+	if route.Channel != "" && route.Channel != bundle.Channel {
+		return false
+	}
+	if route.PlatformType != 0 && route.PlatformType != bundle.PlatformType {
+		return false
+	}
+	if route.Tag != "" && !bundle.HasTag(route.Tag) {
+		return false
+	}
+	return true
+}
+
+func (route *NotificationRoute) Save(txn gorp.SqlExecutor) error {
+	route.Channel = strings.TrimSpace(route.Channel)
+	route.Tag = strings.TrimSpace(route.Tag)
+	return txn.Insert(route)
+}
+
+func (route *NotificationRoute) DeleteFromDB(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(route)
+	return err
+}
+
+func GetNotificationRoute(txn gorp.SqlExecutor, id int) (*NotificationRoute, error) {
+	route, err := txn.Get(NotificationRoute{}, id)
+	if err != nil {
+		return nil, err
+	}
+	if route == nil {
+		return nil, sql.ErrNoRows
+	}
+	return route.(*NotificationRoute), nil
+}
+
+func (app *App) NotificationRoutes(txn gorp.SqlExecutor) ([]*NotificationRoute, error) {
+	var routes []*NotificationRoute
+	_, err := txn.Select(&routes, "SELECT * FROM notification_route WHERE app_id = ? ORDER BY id ASC", app.Id)
+	if err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+func (app *App) DeleteNotificationRoutes(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM notification_route WHERE app_id = ?", app.Id)
+	return err
+}
+
+// ParseTags splits the tags by the commas, e.g. "smoke, release-candidate".
+func ParseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func (bundle *Bundle) TagList() []string {
+	return ParseTags(bundle.Tags)
+}
+
+func (bundle *Bundle) HasTag(tag string) bool {
+	for _, t := range bundle.TagList() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
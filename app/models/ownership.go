@@ -0,0 +1,140 @@
+package models
+
+import (
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// The owners of an app are its admins, any of whom manages the app as a co-owner.
+// An app is orphaned when none of its owners is active, i.e. they are deactivated after leaving the organization,
+// and is succeeded by the fallback group of the organization so that someone can still manage it.
+
+// Deactivate stops the user from logging in, keeping the authorities to tell who managed the apps.
+func (user *User) Deactivate(txn gorp.SqlExecutor) error {
+	user.Deactivated = true
+	user.DeactivatedAt = time.Now()
+	return user.Update(txn)
+}
+
+// RevokePairedDevices revokes the devices of the deactivated user, which are paired again after a reactivation.
+func (user *User) RevokePairedDevices(txn gorp.SqlExecutor) (int64, error) {
+	result, err := txn.Exec("DELETE FROM paired_device WHERE user_id = ?", user.Id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (user *User) Reactivate(txn gorp.SqlExecutor) error {
+	user.Deactivated = false
+	user.DeactivatedAt = time.Time{}
+	return user.Update(txn)
+}
+
+// OwnedApps returns the apps which the user is an owner of.
+func (user *User) OwnedApps(txn gorp.SqlExecutor) ([]*App, error) {
+	var apps []*App
+	_, err := txn.Select(&apps, "SELECT app.* FROM app JOIN authority ON authority.app_id = app.id WHERE authority.email = ? AND authority.role = ? ORDER BY app.id ASC", user.Email, AuthorityRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// ActiveOwners returns the owners of the app who are not deactivated, including the ones who have never logged in, e.g. the groups.
+func (app *App) ActiveOwners(txn gorp.SqlExecutor) ([]*Authority, error) {
+	var authorities []*Authority
+	_, err := txn.Select(&authorities, "SELECT authority.* FROM authority LEFT JOIN user ON user.email = authority.email WHERE authority.app_id = ? AND authority.role = ? AND (user.id IS NULL OR user.deactivated = ?) ORDER BY authority.id ASC", app.Id, AuthorityRoleAdmin, false)
+	if err != nil {
+		return nil, err
+	}
+	return authorities, nil
+}
+
+// IsLastOwner tells whether the authority is the only active owner, which cannot be removed or demoted
+// not to leave the app without an owner.
+func (app *App) IsLastOwner(txn gorp.SqlExecutor, authority *Authority) (bool, error) {
+	if authority.Role != AuthorityRoleAdmin {
+		return false, nil
+	}
+	owners, err := app.ActiveOwners(txn)
+	if err != nil {
+		return false, err
+	}
+	return len(owners) == 1 && owners[0].Id == authority.Id, nil
+}
+
+// OrphanedApps returns the apps whose owners are all deactivated. The apps without any owner, e.g. created before the roles,
+// are not orphaned, as no one has left them.
+func OrphanedApps(txn gorp.SqlExecutor) ([]*App, error) {
+	var apps []*App
+	_, err := txn.Select(&apps, `SELECT DISTINCT app.* FROM app
+JOIN authority ON authority.app_id = app.id AND authority.role = ?
+JOIN user ON user.email = authority.email AND user.deactivated = ?
+WHERE NOT EXISTS (
+  SELECT 1 FROM authority AS owner LEFT JOIN user AS owner_user ON owner_user.email = owner.email
+  WHERE owner.app_id = app.id AND owner.role = ? AND (owner_user.id IS NULL OR owner_user.deactivated = ?)
+) ORDER BY app.id ASC`, AuthorityRoleAdmin, true, AuthorityRoleAdmin, false)
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// Succeed makes the group the owner of the app unless the app has an active owner, and tells whether it did.
+// The group is promoted if it is already a member.
+func (app *App) Succeed(txn gorp.SqlExecutor, s *GoogleService, group string) (bool, error) {
+	owners, err := app.ActiveOwners(txn)
+	if err != nil {
+		return false, err
+	}
+	if len(owners) > 0 {
+		return false, nil
+	}
+
+	authority, err := app.AuthorityForEmail(txn, group)
+	if err != nil {
+		return false, err
+	}
+	if authority != nil {
+		authority.Role = AuthorityRoleAdmin
+		return true, authority.Update(txn)
+	}
+	return true, app.CreateGroupAuthority(txn, s, &Authority{Email: group, Role: AuthorityRoleAdmin})
+}
+
+// CreateGroupAuthority shares the app folder with the Google Group, so that every member of the group can open the app
+// and add the new owners.
+func (app *App) CreateGroupAuthority(txn gorp.SqlExecutor, s *GoogleService, authority *Authority) error {
+	authority.AppId = app.Id
+
+	permission := s.CreateGroupPermission(authority.Email, "reader")
+	permissionInserted, err := s.InsertPermission(app.FileId, permission)
+	if err != nil {
+		return err
+	}
+	authority.PermissionId = permissionInserted.Id
+
+	return authority.Save(txn)
+}
+
+// HasAdmin tells whether the document declares an owner.
+func (doc *AuthorityDocument) HasAdmin() bool {
+	for _, entry := range doc.Authorities {
+		if role, _ := ParseAuthorityRole(entry.Role); role == AuthorityRoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type UserDeactivationReport struct {
+	Email       string `json:"email"`
+	Deactivated bool   `json:"deactivated"`
+	// the apps the user is an owner of, which are succeeded if no other owner is active
+	OwnedApps     []int `json:"owned_apps"`
+	SucceededApps []int `json:"succeeded_apps"`
+	// the paired devices revoked with the deactivation
+	RevokedDevices int64 `json:"revoked_devices"`
+}
@@ -0,0 +1,222 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	PairingCodeDuration = 10 * time.Minute
+
+	// the invalid pairing codes tried in AuthFailureWindow by an address and by all the addresses, to refuse the
+	// pairings at. The pending codes are invalidated at the latter, so that a code is guessed one in a million at most.
+	PairingFailureLimit       = 5
+	PairingGlobalFailureLimit = 100
+
+	pairingGlobalSubject = "pairing"
+)
+
+// a PairedDevice is a companion app authorized to act for a user with a device token.
+// Only the digest of the token is stored, the token itself is shown to the device once.
+// The PushToken is the FCM registration token of a device which opted into the push notifications.
+type PairedDevice struct {
+	Id               int       `db:"id"`
+	UserId           int       `db:"user_id"`
+	Name             string    `db:"name"`
+	TokenDigest      string    `db:"token_digest"`
+	PairingCode      string    `db:"pairing_code"`
+	PairingExpiresAt time.Time `db:"pairing_expires_at"`
+	PushToken        string    `db:"push_token"`
+	LastUsedAt       time.Time `db:"last_used_at"`
+	CreatedAt        time.Time `db:"created_at"`
+	UpdatedAt        time.Time `db:"updated_at"`
+}
+
+func (device *PairedDevice) PreInsert(s gorp.SqlExecutor) error {
+	device.CreatedAt = time.Now()
+	device.UpdatedAt = device.CreatedAt
+	return nil
+}
+
+func (device *PairedDevice) PreUpdate(s gorp.SqlExecutor) error {
+	device.UpdatedAt = time.Now()
+	return nil
+}
+
+func (device *PairedDevice) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(device)
+}
+
+func (device *PairedDevice) Update(txn gorp.SqlExecutor) error {
+	_, err := txn.Update(device)
+	return err
+}
+
+func (device *PairedDevice) DeleteFromDB(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(device)
+	return err
+}
+
+func (device *PairedDevice) IsPaired() bool {
+	return device.TokenDigest != ""
+}
+
+// Touch records the use of the device token.
+func (device *PairedDevice) Touch(txn gorp.SqlExecutor) error {
+	device.LastUsedAt = time.Now()
+	_, err := txn.Exec("UPDATE paired_device SET last_used_at = ? WHERE id = ?", device.LastUsedAt, device.Id)
+	return err
+}
+
+// StartPairing issues a pairing code for the user to enter on the companion app.
+func StartPairing(txn gorp.SqlExecutor, userId int) (*PairedDevice, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(100000000))
+	if err != nil {
+		return nil, err
+	}
+
+	device := &PairedDevice{
+		UserId:           userId,
+		PairingCode:      fmt.Sprintf("%08d", n.Int64()),
+		PairingExpiresAt: time.Now().Add(PairingCodeDuration),
+	}
+	if err := device.Save(txn); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// CompletePairing exchanges a valid pairing code for a new device token.
+// It returns sql.ErrNoRows if the code is unknown or expired.
+func CompletePairing(txn gorp.SqlExecutor, pairingCode, name string) (*PairedDevice, string, error) {
+	var device PairedDevice
+	err := txn.SelectOne(
+		&device,
+		"SELECT * FROM paired_device WHERE pairing_code = ? AND token_digest = '' AND pairing_expires_at > ?",
+		pairingCode,
+		time.Now(),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(b)
+
+	device.Name = name
+	device.TokenDigest = deviceTokenDigest(token)
+	device.PairingCode = ""
+	device.LastUsedAt = time.Now()
+	if err := device.Update(txn); err != nil {
+		return nil, "", err
+	}
+	return &device, token, nil
+}
+
+// IsPairingLocked tells whether the pairings of the subject, or of every address, are refused for the invalid codes
+// tried in the window.
+func IsPairingLocked(txn gorp.SqlExecutor, subject string, now time.Time) (bool, error) {
+	count, err := countAuthFailures(txn, subject, []string{AuthFailureKindPairing}, now)
+	if err != nil || count >= PairingFailureLimit {
+		return count >= PairingFailureLimit, err
+	}
+	count, err = countAuthFailures(txn, pairingGlobalSubject, []string{AuthFailureKindPairing}, now)
+	return count >= PairingGlobalFailureLimit, err
+}
+
+// RecordPairingFailure counts the invalid code of the subject toward the limits, and invalidates the pending codes
+// when the invalid codes of every address reach the limit. It reports whether they were invalidated.
+func RecordPairingFailure(txn gorp.SqlExecutor, subject string) (bool, error) {
+	if _, err := RecordAuthFailure(txn, subject, AuthFailureKindPairing); err != nil {
+		return false, err
+	}
+	count, err := RecordAuthFailure(txn, pairingGlobalSubject, AuthFailureKindPairing)
+	if err != nil {
+		return false, err
+	}
+	if count < PairingGlobalFailureLimit {
+		return false, nil
+	}
+	now := time.Now()
+	_, err = txn.Exec("UPDATE paired_device SET pairing_expires_at = ? WHERE token_digest = '' AND pairing_expires_at > ?", now, now)
+	return err == nil, err
+}
+
+func deviceTokenDigest(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+func GetPairedDevice(txn gorp.SqlExecutor, id int) (*PairedDevice, error) {
+	var device PairedDevice
+	if err := txn.SelectOne(&device, "SELECT * FROM paired_device WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+func GetPairedDeviceByToken(txn gorp.SqlExecutor, token string) (*PairedDevice, error) {
+	if token == "" {
+		return nil, sql.ErrNoRows
+	}
+	var device PairedDevice
+	if err := txn.SelectOne(&device, "SELECT * FROM paired_device WHERE token_digest = ?", deviceTokenDigest(token)); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// GetPendingPairing returns nil if the user has no pairing code waiting for a device.
+func GetPendingPairing(txn gorp.SqlExecutor, userId int) (*PairedDevice, error) {
+	var devices []*PairedDevice
+	_, err := txn.Select(
+		&devices,
+		"SELECT * FROM paired_device WHERE user_id = ? AND token_digest = '' AND pairing_expires_at > ? ORDER BY id DESC LIMIT 1",
+		userId,
+		time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, nil
+	}
+	return devices[0], nil
+}
+
+func GetPairedDevicesByUser(txn gorp.SqlExecutor, userId int) ([]*PairedDevice, error) {
+	var devices []*PairedDevice
+	_, err := txn.Select(&devices, "SELECT * FROM paired_device WHERE user_id = ? AND token_digest <> '' ORDER BY id ASC", userId)
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// SetPushToken opts the device into the push notifications, or out of them with the empty token.
+func (device *PairedDevice) SetPushToken(txn gorp.SqlExecutor, pushToken string) error {
+	device.PushToken = pushToken
+	_, err := txn.Exec("UPDATE paired_device SET push_token = ? WHERE id = ?", device.PushToken, device.Id)
+	return err
+}
+
+// GetPushDevicesForApp returns the devices opted into the push notifications of the members of the app.
+func GetPushDevicesForApp(txn gorp.SqlExecutor, appId int) ([]*PairedDevice, error) {
+	var devices []*PairedDevice
+	_, err := txn.Select(&devices, `SELECT paired_device.* FROM paired_device
+		INNER JOIN user ON user.id = paired_device.user_id
+		INNER JOIN authority ON authority.email = user.email
+		WHERE authority.app_id = ? AND paired_device.token_digest <> '' AND paired_device.push_token <> ''
+		ORDER BY paired_device.id`, appId)
+	return devices, err
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// PlaceholderIpa returns an ipa file with the Info.plist only, which is enough to be parsed as a bundle
+// for the demo data and the tests. It cannot be installed.
+func PlaceholderIpa(identifier, version string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.Create("Payload/AlphaWing.app/Info.plist")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(f, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleIdentifier</key>
+	<string>%s</string>
+	<key>CFBundleVersion</key>
+	<string>%s</string>
+	<key>CFBundleShortVersionString</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, identifier, version, version)
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// CreatePlaceholderIpaFile writes a placeholder ipa to a temporary file, which the caller removes.
+func CreatePlaceholderIpaFile(identifier, version string) (*os.File, error) {
+	file, err := ioutil.TempFile("", "alphawing-placeholder")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(PlaceholderIpa(identifier, version)); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	PlayStateUploading = "UPLOADING"
+	PlayStateComplete  = "COMPLETE"
+	PlayStateFailed    = "FAILED"
+)
+
+// a PlaySubmission records the release of a bundle to the internal testing track of Google Play
+type PlaySubmission struct {
+	Id          int       `db:"id"`
+	BundleId    int       `db:"bundle_id"`
+	VersionCode int64     `db:"version_code"`
+	State       string    `db:"state"`
+	Message     string    `db:"message"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
+
+func (submission *PlaySubmission) PreInsert(s gorp.SqlExecutor) error {
+	submission.CreatedAt = time.Now()
+	submission.UpdatedAt = submission.CreatedAt
+	return nil
+}
+
+func (submission *PlaySubmission) PreUpdate(s gorp.SqlExecutor) error {
+	submission.UpdatedAt = time.Now()
+	return nil
+}
+
+func (submission *PlaySubmission) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(submission)
+}
+
+func (submission *PlaySubmission) Update(txn gorp.SqlExecutor) error {
+	_, err := txn.Update(submission)
+	return err
+}
+
+// Touch keeps the submission claimed while it is uploaded.
+func (submission *PlaySubmission) Touch(txn gorp.SqlExecutor) error {
+	return touchUpload(txn, "play_submission", submission.Id, PlayStateUploading, time.Now())
+}
+
+func (submission *PlaySubmission) IsFinished() bool {
+	return submission.State == PlayStateComplete || submission.State == PlayStateFailed
+}
+
+// ClaimStalePlaySubmissions takes the submissions left uploading by a server stopped, to upload them again.
+func ClaimStalePlaySubmissions(txn gorp.SqlExecutor, now time.Time) ([]*PlaySubmission, error) {
+	ids, err := claimStaleUploads(txn, "play_submission", PlayStateUploading, now)
+	if err != nil {
+		return nil, err
+	}
+	var submissions []*PlaySubmission
+	for _, id := range ids {
+		var submission PlaySubmission
+		if err := txn.SelectOne(&submission, "SELECT * FROM play_submission WHERE id = ?", id); err != nil {
+			return nil, err
+		}
+		submissions = append(submissions, &submission)
+	}
+	return submissions, nil
+}
@@ -29,13 +29,15 @@ type Asset struct {
 }
 
 type Metadata struct {
-	BundleIdentifier string `plist:"bundle-identifier"`
-	BundleVersion    string `plist:"bundle-version"`
-	Kind             string `plist:"kind"`
-	Title            string `plist:"title"`
+	BundleIdentifier   string `plist:"bundle-identifier"`
+	BundleVersion      string `plist:"bundle-version"`
+	Kind               string `plist:"kind"`
+	PlatformIdentifier string `plist:"platform-identifier,omitempty"`
+	Title              string `plist:"title"`
 }
 
-func NewPlist(title, version, identifier, ipaUrl string) *Plist {
+// NewPlist returns the manifest of the ipa, which tells the platform of the companion apps of tvOS and watchOS.
+func NewPlist(title, version, identifier, ipaUrl string, platformSubtype BundlePlatformSubtype) *Plist {
 	if len(identifier) == 0 {
 		identifier = DefaultMetadataBundleIdentifier
 	}
@@ -50,10 +52,11 @@ func NewPlist(title, version, identifier, ipaUrl string) *Plist {
 					},
 				},
 				Metadata: &Metadata{
-					BundleIdentifier: identifier,
-					BundleVersion:    version,
-					Kind:             MetadataKind,
-					Title:            title,
+					BundleIdentifier:   identifier,
+					BundleVersion:      version,
+					Kind:               MetadataKind,
+					PlatformIdentifier: platformSubtype.PlatformIdentifier(),
+					Title:              title,
 				},
 			},
 		},
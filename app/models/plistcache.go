@@ -0,0 +1,91 @@
+package models
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// PlistCacheDuration is how long a plist is cached, so that the title of the app changed through another server
+// shows in the plists of this one after a while.
+const PlistCacheDuration = 10 * time.Minute
+
+// the URL of the ipa in the plist cached, replaced with the URL signed for each request
+const plistIpaUrlPlaceholder = "alphawing-ipa-url"
+
+type cachedPlist struct {
+	appId    int
+	data     []byte
+	cachedAt time.Time
+}
+
+// the plists marshalled by the bundle, so that the installs of a bundle pushed to the whole company
+// do not look up the app and marshal the plist for each hit
+var plistCache = struct {
+	sync.RWMutex
+	plists map[int]*cachedPlist
+}{plists: map[int]*cachedPlist{}}
+
+// CachePlist marshals the plist of the bundle in advance, as it is uploaded.
+func CachePlist(app *App, bundle *Bundle) error {
+	_, err := cachePlist(app, bundle)
+	return err
+}
+
+func cachePlist(app *App, bundle *Bundle) (*cachedPlist, error) {
+	data, err := NewPlist(app.Title, bundle.BundleVersion, bundle.BundleIdentifier, plistIpaUrlPlaceholder, bundle.PlatformSubtype).Marshall()
+	if err != nil {
+		return nil, err
+	}
+
+	cached := &cachedPlist{appId: app.Id, data: data, cachedAt: time.Now()}
+	plistCache.Lock()
+	plistCache.plists[bundle.Id] = cached
+	plistCache.Unlock()
+	return cached, nil
+}
+
+func uncacheBundlePlist(bundleId int) {
+	plistCache.Lock()
+	delete(plistCache.plists, bundleId)
+	plistCache.Unlock()
+}
+
+func uncacheAppPlists(appId int) {
+	plistCache.Lock()
+	defer plistCache.Unlock()
+	for bundleId, cached := range plistCache.plists {
+		if cached.appId == appId {
+			delete(plistCache.plists, bundleId)
+		}
+	}
+}
+
+// PlistReader returns the plist of the bundle cached with the URL of the ipa, caching it if it is not yet.
+func (bundle *Bundle) PlistReader(txn gorp.SqlExecutor, ipaUrl *url.URL) (io.Reader, error) {
+	plistCache.RLock()
+	cached := plistCache.plists[bundle.Id]
+	plistCache.RUnlock()
+
+	if cached == nil || time.Since(cached.cachedAt) > PlistCacheDuration {
+		app, err := bundle.App(txn)
+		if err != nil {
+			return nil, err
+		}
+		if cached, err = cachePlist(app, bundle); err != nil {
+			return nil, err
+		}
+	}
+
+	// the query of the signed URL has the ampersands to be escaped as the marshaller does
+	var escaped bytes.Buffer
+	if err := xml.EscapeText(&escaped, []byte(ipaUrl.String())); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(bytes.Replace(cached.data, []byte(plistIpaUrlPlaceholder), escaped.Bytes(), 1)), nil
+}
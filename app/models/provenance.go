@@ -0,0 +1,157 @@
+package models
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const InTotoPayloadType = "application/vnd.in-toto+json"
+
+// a Provenance is a build provenance attestation (an in-toto statement in a DSSE envelope) uploaded with a bundle
+// https://slsa.dev/provenance
+type Provenance struct {
+	Id        int       `db:"id"`
+	BundleId  int       `db:"bundle_id"`
+	Envelope  []byte    `db:"envelope"`
+	Verified  bool      `db:"verified"`
+	Message   string    `db:"message"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+func (provenance *Provenance) PreInsert(s gorp.SqlExecutor) error {
+	provenance.CreatedAt = time.Now()
+	provenance.UpdatedAt = provenance.CreatedAt
+	return nil
+}
+
+func (provenance *Provenance) PreUpdate(s gorp.SqlExecutor) error {
+	provenance.UpdatedAt = time.Now()
+	return nil
+}
+
+func (provenance *Provenance) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(provenance)
+}
+
+type ProvenanceVerifyError struct {
+	Reason string
+}
+
+func (e *ProvenanceVerifyError) Error() string {
+	return "provenance: " + e.Reason
+}
+
+// https://github.com/secure-systems-lab/dsse/blob/master/envelope.md
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		KeyId string `json:"keyid"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+type inTotoStatement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string `json:"predicateType"`
+}
+
+// VerifyProvenance checks that the envelope is signed by one of the keys and that its subject is the file with the digest.
+func VerifyProvenance(envelope []byte, digest string, keys []crypto.PublicKey) error {
+	var env dsseEnvelope
+	if err := json.Unmarshal(envelope, &env); err != nil {
+		return &ProvenanceVerifyError{Reason: "envelope is not valid JSON"}
+	}
+	if env.PayloadType != InTotoPayloadType {
+		return &ProvenanceVerifyError{Reason: "unsupported payload type " + env.PayloadType}
+	}
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return &ProvenanceVerifyError{Reason: "payload is not base64 encoded"}
+	}
+
+	pae := []byte(fmt.Sprintf("DSSEv1 %d %s %d ", len(env.PayloadType), env.PayloadType, len(payload)))
+	pae = append(pae, payload...)
+
+	signed := false
+	for _, signature := range env.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			continue
+		}
+		for _, key := range keys {
+			if verifySignature(key, pae, sig) {
+				signed = true
+				break
+			}
+		}
+		if signed {
+			break
+		}
+	}
+	if !signed {
+		return &ProvenanceVerifyError{Reason: "no signature matches the configured keys"}
+	}
+
+	var statement inTotoStatement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return &ProvenanceVerifyError{Reason: "payload is not an in-toto statement"}
+	}
+	for _, subject := range statement.Subject {
+		if subject.Digest["sha256"] == digest {
+			return nil
+		}
+	}
+	return &ProvenanceVerifyError{Reason: "no subject matches the sha256 of the bundle"}
+}
+
+func verifySignature(key crypto.PublicKey, message, sig []byte) bool {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, message, sig)
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(k, hash[:], sig)
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(message)
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig) == nil {
+			return true
+		}
+		return rsa.VerifyPSS(k, crypto.SHA256, hash[:], sig, nil) == nil
+	}
+	return false
+}
+
+// ParsePublicKeys parses every PEM encoded public key in the data.
+func ParsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
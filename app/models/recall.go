@@ -0,0 +1,85 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/coopernurse/gorp"
+)
+
+var (
+	ErrBundleRecalled           = errors.New("The bundle is recalled and cannot be installed.")
+	ErrRecallReplacementInvalid = errors.New("The replacement must be another bundle of the app which is not recalled.")
+)
+
+func (bundle *Bundle) IsRecalled() bool {
+	return bundle.Recalled
+}
+
+// Recall disables the downloads of the bundle from every page and link at once, for the build which must not be installed any more.
+// The replacement is the bundle suggested instead, or 0 to suggest the latest one of the platform and the variant.
+func (bundle *Bundle) Recall(txn gorp.SqlExecutor, reason string, replacementId int) error {
+	if replacementId != 0 {
+		replacement, err := GetBundle(txn, replacementId)
+		if err == sql.ErrNoRows {
+			return ErrRecallReplacementInvalid
+		}
+		if err != nil {
+			return err
+		}
+		if replacement.AppId != bundle.AppId || replacement.Id == bundle.Id || replacement.Recalled {
+			return ErrRecallReplacementInvalid
+		}
+	}
+
+	bundle.Recalled = true
+	bundle.RecallReason = strings.TrimSpace(reason)
+	bundle.RecallReplacement = replacementId
+	// the links shared before do not work again when the recall is lifted
+	bundle.ShareGeneration++
+	if _, err := txn.Update(bundle); err != nil {
+		return err
+	}
+	return RecordEvent(txn, EventResourceBundle, bundle.Id, bundle.AppId, EventActionUpdate)
+}
+
+// LiftRecall makes the recalled bundle installable again, e.g. when it is recalled by mistake.
+func (bundle *Bundle) LiftRecall(txn gorp.SqlExecutor) error {
+	bundle.Recalled = false
+	bundle.RecallReason = ""
+	bundle.RecallReplacement = 0
+	if _, err := txn.Update(bundle); err != nil {
+		return err
+	}
+	return RecordEvent(txn, EventResourceBundle, bundle.Id, bundle.AppId, EventActionUpdate)
+}
+
+// RevokeShareLinks rotates the share generation of the bundle, so that every share link of it stops working.
+func (bundle *Bundle) RevokeShareLinks(txn gorp.SqlExecutor) error {
+	if _, err := txn.Exec("UPDATE bundle SET share_generation = share_generation + 1 WHERE id = ?", bundle.Id); err != nil {
+		return err
+	}
+	bundle.ShareGeneration++
+	return nil
+}
+
+// Replacement returns the bundle to install instead of the recalled one, or nil if there is none.
+// It is the one chosen on the recall, or else the latest bundle of the platform and the variant.
+func (bundle *Bundle) Replacement(txn gorp.SqlExecutor) (*Bundle, error) {
+	if bundle.RecallReplacement != 0 {
+		replacement, err := GetBundle(txn, bundle.RecallReplacement)
+		if err == nil && !replacement.Recalled {
+			return replacement, nil
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	app, err := bundle.App(txn)
+	if err != nil {
+		return nil, err
+	}
+	return app.LatestBundle(txn, bundle.PlatformType, bundle.Variant)
+}
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps the files in an Amazon S3 bucket, for the deployments which cannot store the bundles on Google Drive.
+// The keys are the prefix, the location and the file name, e.g. alphawing/app_1/1.0/app_1_ver_1.0_rev_1.apk.
+type S3Storage struct {
+	Bucket          string
+	Region          string
+	Prefix          string
+	AccessKeyId     string
+	SecretAccessKey string
+	Endpoint        string
+	Client          *http.Client
+}
+
+func NewS3Storage(bucket, region, prefix, accessKeyId, secretAccessKey string) *S3Storage {
+	return &S3Storage{
+		Bucket:          bucket,
+		Region:          region,
+		Prefix:          strings.Trim(prefix, "/"),
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secretAccessKey,
+		Endpoint:        fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region),
+		Client:          http.DefaultClient,
+	}
+}
+
+func (st *S3Storage) Put(file *os.File, name, location string) (string, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		return "", err
+	}
+	return st.PutStream(file, stat.Size(), name, location)
+}
+
+// PutStream puts the reader in a request, as S3 needs the size of the object before its content.
+func (st *S3Storage) PutStream(r io.Reader, size int64, name, location string) (string, error) {
+	key := name
+	if location != "" {
+		key = strings.Trim(location, "/") + "/" + key
+	}
+	if st.Prefix != "" {
+		key = st.Prefix + "/" + key
+	}
+
+	req, err := http.NewRequest("PUT", st.Endpoint+"/"+key, ioutil.NopCloser(r))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	st.sign(req, "UNSIGNED-PAYLOAD")
+	resp, err := st.do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return key, nil
+}
+
+// Get streams the file from S3 without keeping it on the local disk.
+func (st *S3Storage) Get(key string) (*StorageObject, error) {
+	req, err := http.NewRequest("GET", st.Endpoint+"/"+key, nil)
+	if err != nil {
+		return nil, err
+	}
+	st.sign(req, sha256Hex(nil))
+	resp, err := st.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		modTime = time.Now()
+	}
+	return &StorageObject{
+		Body:    resp.Body,
+		Name:    path.Base(key),
+		Size:    resp.ContentLength,
+		ModTime: modTime,
+	}, nil
+}
+
+func (st *S3Storage) Delete(key string) error {
+	req, err := http.NewRequest("DELETE", st.Endpoint+"/"+key, nil)
+	if err != nil {
+		return err
+	}
+	st.sign(req, sha256Hex(nil))
+	resp, err := st.do(req)
+	if err != nil {
+		if storageErr, ok := err.(*StorageError); ok && storageErr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SignedURL presigns the GET of the file with the query parameters of Signature Version 4,
+// with the name of the file to save it as.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func (st *S3Storage) SignedURL(key string, expiry time.Duration) (string, error) {
+	u, err := url.Parse(st.Endpoint + "/" + key)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", day, st.Region)
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", st.AccessKeyId+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	query.Set("response-content-disposition", fmt.Sprintf(`attachment; filename="%s"`, path.Base(key)))
+	// SigV4 encodes the spaces as %20
+	canonicalQuery := strings.Replace(query.Encode(), "+", "%20", -1)
+
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		u.EscapedPath(),
+		canonicalQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSha256([]byte("AWS4"+st.SecretAccessKey), day)
+	signingKey = hmacSha256(signingKey, st.Region)
+	signingKey = hmacSha256(signingKey, "s3")
+	signingKey = hmacSha256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List lists the objects under the prefix by ListObjectsV2, which needs s3:ListBucket.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html
+func (st *S3Storage) List(fn func(entry *StorageEntry) error) error {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if st.Prefix != "" {
+			query.Set("prefix", st.Prefix+"/")
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := http.NewRequest("GET", st.Endpoint+"/?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		st.sign(req, sha256Hex(nil))
+		resp, err := st.do(req)
+		if err != nil {
+			return err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, object := range result.Contents {
+			if err := fn(&StorageEntry{Key: object.Key, Size: object.Size, ModTime: object.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (st *S3Storage) sign(req *http.Request, payloadHash string) {
+	signAwsRequest(req, payloadHash, st.Region, st.AccessKeyId, st.SecretAccessKey, time.Now().UTC())
+}
+
+// do returns the response to be closed by the caller, or the error with the body of the failed request.
+func (st *S3Storage) do(req *http.Request) (*http.Response, error) {
+	resp, err := st.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, &StorageError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+type StorageError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("storage responded %d: %s", e.StatusCode, e.Body)
+}
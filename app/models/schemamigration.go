@@ -0,0 +1,545 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the phases of a schema migration. The servers write the new columns from the expand on, while the backfill fills
+// them for the existing rows, and only read them once it is done.
+const (
+	SchemaPhaseExpanded    = "expanded"
+	SchemaPhaseBackfilling = "backfilling"
+	SchemaPhaseDone        = "done"
+	SchemaPhaseFailed      = "failed"
+)
+
+// a server backfilling stops updating the state while it is down, after which another server takes over the backfill
+const schemaBackfillStale = 5 * time.Minute
+
+// the seconds the DDL of MySQL waits for the metadata lock, instead of queueing the queries of the table behind it
+// while a long transaction holds the lock
+const schemaLockWaitSeconds = 5
+
+var ErrSchemaUnsupportedDialect = errors.New("the schema migrations support MySQL and SQLite only")
+
+// a SchemaColumn is a column added to a table, with its definition of each database.
+// The definitions have defaults, so that the rows are not rewritten and the servers not writing the column yet work.
+type SchemaColumn struct {
+	Name   string
+	MySQL  string
+	Sqlite string
+}
+
+// a SchemaIndex is an index added to a table, built without blocking the writes on MySQL.
+type SchemaIndex struct {
+	Name    string
+	Columns []string
+}
+
+// a SchemaMigration changes the schema of a table while the servers keep serving. The expand adds the columns and
+// the indexes without locking the table, at the start of the server. The backfill then fills the new columns of
+// the rows existing before, in batches of the ids in a transaction each, keeping the progress to resume.
+type SchemaMigration struct {
+	Name    string
+	Table   string
+	Columns []SchemaColumn
+	Indexes []SchemaIndex
+	// Backfill fills the rows of the ids in [from, to], or nil when the columns are not backfilled. The storage defers
+	// the deletions until the batch has committed
+	Backfill func(txn gorp.SqlExecutor, storage Storage, from, to int64) error
+	// BatchSize is the rows of a batch instead of the size configured, for the backfills reading the files
+	BatchSize int64
+}
+
+// a SchemaMigrationState is the progress of a migration, shared by the servers.
+type SchemaMigrationState struct {
+	Name         string    `db:"name"`
+	Phase        string    `db:"phase"`
+	BackfilledId int64     `db:"backfilled_id"`
+	MaxId        int64     `db:"max_id"`
+	Error        string    `db:"error"`
+	StartedAt    time.Time `db:"started_at"`
+	UpdatedAt    time.Time `db:"updated_at"`
+	FinishedAt   time.Time `db:"finished_at"`
+}
+
+type SchemaMigrationJsonResponse struct {
+	Name         string `json:"name"`
+	Table        string `json:"table"`
+	Phase        string `json:"phase"`
+	BackfilledId int64  `json:"backfilled_id"`
+	MaxId        int64  `json:"max_id"`
+	Error        string `json:"error"`
+	StartedAt    string `json:"started_at"`
+	UpdatedAt    string `json:"updated_at"`
+	FinishedAt   string `json:"finished_at"`
+}
+
+// SchemaMigrations are the migrations of the schema in the order they are run. A migration is not edited once
+// released, as the servers upgraded have run it: a new one is appended.
+var SchemaMigrations = []*SchemaMigration{
+	{
+		Name:  "bundle_android_manifest",
+		Table: "bundle",
+		Columns: []SchemaColumn{
+			{"version_code", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"min_sdk_version", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+		},
+		Indexes: []SchemaIndex{{"idx_bundle_app_version_code", []string{"app_id", "version_code"}}},
+		// the analyses have read them from the manifests since before
+		Backfill: func(txn gorp.SqlExecutor, storage Storage, from, to int64) error {
+			_, err := txn.Exec(`UPDATE bundle SET
+				version_code = COALESCE((SELECT version_code FROM bundle_analysis WHERE bundle_analysis.bundle_id = bundle.id LIMIT 1), ''),
+				min_sdk_version = COALESCE((SELECT min_os_version FROM bundle_analysis WHERE bundle_analysis.bundle_id = bundle.id LIMIT 1), '')
+				WHERE id BETWEEN ? AND ? AND platform_type = ? AND version_code = ''`, from, to, BundlePlatformTypeAndroid)
+			return err
+		},
+	},
+	{
+		Name:  "bundle_uploader",
+		Table: "bundle",
+		Columns: []SchemaColumn{
+			{"uploaded_by", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"ci_job_url", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+		},
+		Indexes: []SchemaIndex{{"idx_bundle_app_uploaded_by", []string{"app_id", "uploaded_by"}}},
+	},
+	{
+		Name:  "bundle_profile_expiry",
+		Table: "bundle",
+		Columns: []SchemaColumn{
+			{"profile_expires_at", "DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00'", "DATETIME NOT NULL DEFAULT '0001-01-01 00:00:00+00:00'"},
+		},
+		Backfill: func(txn gorp.SqlExecutor, storage Storage, from, to int64) error {
+			_, err := txn.Exec(`UPDATE bundle SET
+				profile_expires_at = (SELECT profile_expires_at FROM bundle_analysis WHERE bundle_analysis.bundle_id = bundle.id LIMIT 1)
+				WHERE id BETWEEN ? AND ? AND platform_type = ?
+				AND EXISTS (SELECT 1 FROM bundle_analysis WHERE bundle_analysis.bundle_id = bundle.id)`, from, to, BundlePlatformTypeIOS)
+			return err
+		},
+	},
+	{
+		Name:  "bundle_analysis_profile",
+		Table: "bundle_analysis",
+		// the blobs of MySQL have no default but NULL, as the columns created by gorp
+		Columns: []SchemaColumn{
+			{"profile_udids", "MEDIUMBLOB", "BLOB"},
+			{"profile_entitlements", "MEDIUMBLOB", "BLOB"},
+		},
+	},
+	{
+		Name:  "bundle_executable_uuid",
+		Table: "bundle",
+		Columns: []SchemaColumn{
+			{"executable_uuid", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+		},
+		Indexes: []SchemaIndex{{"idx_bundle_app_executable_uuid", []string{"app_id", "executable_uuid"}}},
+	},
+	{
+		Name:    "audit_resource",
+		Table:   "audit",
+		Indexes: []SchemaIndex{{"idx_audit_resource_action", []string{"resource", "action", "resource_id"}}},
+	},
+	{
+		Name:  "status_check_period",
+		Table: "status_check",
+		Columns: []SchemaColumn{
+			{"period", "BIGINT NOT NULL DEFAULT 0", "BIGINT NOT NULL DEFAULT 0"},
+		},
+		Indexes: []SchemaIndex{{"idx_status_check_period", []string{"period"}}},
+		// the periods are counted here, as the epochs of the times are taken differently in MySQL and SQLite
+		Backfill: func(txn gorp.SqlExecutor, storage Storage, from, to int64) error {
+			var checks []*StatusCheck
+			if _, err := txn.Select(&checks, "SELECT * FROM status_check WHERE id BETWEEN ? AND ? AND period = 0", from, to); err != nil {
+				return err
+			}
+			for _, check := range checks {
+				if _, err := txn.Exec("UPDATE status_check SET period = ? WHERE id = ?", statusCheckPeriod(check.CheckedAt), check.Id); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Name:    "audit_resource_user",
+		Table:   "audit",
+		Indexes: []SchemaIndex{{"idx_audit_resource_user", []string{"resource", "action", "resource_id", "user_id"}}},
+	},
+	{
+		Name:  "bundle_universal_apk_file_size",
+		Table: "bundle",
+		Columns: []SchemaColumn{
+			{"universal_apk_file_size", "BIGINT NOT NULL DEFAULT 0", "BIGINT NOT NULL DEFAULT 0"},
+		},
+	},
+	{
+		Name:  "app_settings",
+		Table: "app",
+		Columns: []SchemaColumn{
+			{"description_template", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"firebase_android_app_id", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"firebase_ios_app_id", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			// the apps before the visibility stay unlisted, as is the zero value
+			{"visibility", "INT NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+			{"category", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"icon_file_id", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"storage_location", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"require_same_signer", "BOOLEAN NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+			{"storage_quota_mb", "INT NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+		},
+	},
+	{
+		Name:  "authority_role",
+		Table: "authority",
+		// the members before the roles stay developers, as is the zero value
+		Columns: []SchemaColumn{
+			{"role", "INT NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+		},
+	},
+	{
+		Name:  "user_account",
+		Table: "user",
+		Columns: []SchemaColumn{
+			{"deactivated", "BOOLEAN NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+			{"language", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"deactivated_at", "DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00'", "DATETIME NOT NULL DEFAULT '0001-01-01 00:00:00+00:00'"},
+		},
+	},
+	{
+		Name:  "audit_device",
+		Table: "audit",
+		Columns: []SchemaColumn{
+			{"device_id", "INT NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+		},
+	},
+	{
+		Name:  "bundle_metadata",
+		Table: "bundle",
+		Columns: []SchemaColumn{
+			// the bundles of iOS have the empty subtype, and the empty extension is the one of the platform
+			{"platform_subtype", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"file_extension", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"app_bundle", "BOOLEAN NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+			{"short_version", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"codename", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"provenance_verified", "BOOLEAN NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+			// a VARCHAR instead of the TEXT of gorp, which has no default on MySQL
+			{"internal_notes", "VARCHAR(4096) NOT NULL DEFAULT ''", "VARCHAR(4096) NOT NULL DEFAULT ''"},
+			{"archive_state", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"doc_revision", "INT NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+			{"channel", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"tags", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"variant", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"recalled", "BOOLEAN NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+			{"recall_reason", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"recall_replacement_id", "INT NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+			{"icon_digest", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"icon_changed_from", "INT NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+			{"signing_certificate", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"signing_scheme", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"signer_changed_from", "INT NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+			{"signing_identity", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"signing_team_id", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"profile_type", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"universal_apk_file_id", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+		},
+	},
+	{
+		Name:  "bundle_file_digest",
+		Table: "bundle",
+		Columns: []SchemaColumn{
+			{"digest", "VARCHAR(255) NOT NULL DEFAULT ''", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			{"file_size", "BIGINT NOT NULL DEFAULT 0", "BIGINT NOT NULL DEFAULT 0"},
+		},
+		// a file is read from the storage in a transaction, so that the state is updated well before it is stale
+		BatchSize: 1,
+		// the bundles before the deduplication share the blobs as uploaded now, so that the quotas count their sizes
+		// and the files are signed and verified. The files missing from the storage stay owned by their bundles.
+		Backfill: func(txn gorp.SqlExecutor, storage Storage, from, to int64) error {
+			var bundles []*Bundle
+			if _, err := txn.Select(&bundles, "SELECT * FROM bundle WHERE id BETWEEN ? AND ? AND digest = '' AND file_id <> '' AND archive_state = ''", from, to); err != nil {
+				return err
+			}
+			for _, bundle := range bundles {
+				digest, size, err := storedFileDigest(storage, bundle.FileId)
+				if err != nil {
+					if IsStorageNotFound(err) {
+						continue
+					}
+					return err
+				}
+				bundle.FileSize = size
+				if _, err := txn.Exec("UPDATE bundle SET file_size = ? WHERE id = ?", size, bundle.Id); err != nil {
+					return err
+				}
+				app, err := bundle.App(txn)
+				if err != nil {
+					return err
+				}
+				if err := adoptBundleFile(txn, storage, bundle, digest, app.StorageLocation); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Name:  "event_seq",
+		Table: "event",
+		Columns: []SchemaColumn{
+			{"seq", "INT NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+		},
+		Indexes: []SchemaIndex{{"idx_event_seq", []string{"seq"}}},
+	},
+	{
+		Name:  "bundle_share_generation",
+		Table: "bundle",
+		Columns: []SchemaColumn{
+			{"share_generation", "INT NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+		},
+	},
+}
+
+func (state *SchemaMigrationState) PreInsert(s gorp.SqlExecutor) error {
+	state.StartedAt = time.Now()
+	state.UpdatedAt = state.StartedAt
+	return nil
+}
+
+func (state *SchemaMigrationState) PreUpdate(s gorp.SqlExecutor) error {
+	state.UpdatedAt = time.Now()
+	return nil
+}
+
+func (state *SchemaMigrationState) IsDone() bool {
+	return state.Phase == SchemaPhaseDone
+}
+
+func (m *SchemaMigration) JsonResponse(state *SchemaMigrationState) *SchemaMigrationJsonResponse {
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	return &SchemaMigrationJsonResponse{
+		Name:         m.Name,
+		Table:        m.Table,
+		Phase:        state.Phase,
+		BackfilledId: state.BackfilledId,
+		MaxId:        state.MaxId,
+		Error:        state.Error,
+		StartedAt:    format(state.StartedAt),
+		UpdatedAt:    format(state.UpdatedAt),
+		FinishedAt:   format(state.FinishedAt),
+	}
+}
+
+// SchemaMigrationStates returns the states of the migrations by their names, without the ones not expanded yet.
+// The reads of the columns backfilled wait for the phase to be done.
+func SchemaMigrationStates(txn gorp.SqlExecutor) (map[string]*SchemaMigrationState, error) {
+	var states []*SchemaMigrationState
+	if _, err := txn.Select(&states, "SELECT * FROM schema_migration"); err != nil {
+		return nil, err
+	}
+	byName := map[string]*SchemaMigrationState{}
+	for _, state := range states {
+		byName[state.Name] = state
+	}
+	return byName, nil
+}
+
+// ExpandSchema adds the columns and the indexes of the migrations missing from the tables. A DDL MySQL cannot run
+// without locking the table fails instead, as does one waiting for the metadata lock longer than a few seconds,
+// so that the server is restarted at a quiet time rather than blocking the uploads.
+func ExpandSchema(dbm *gorp.DbMap, migrations []*SchemaMigration) error {
+	states, err := SchemaMigrationStates(dbm)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, ok := states[m.Name]; ok {
+			continue
+		}
+		if err := m.expand(dbm); err != nil {
+			return fmt.Errorf("%s: %s", m.Name, err)
+		}
+		state := &SchemaMigrationState{Name: m.Name, Phase: SchemaPhaseExpanded}
+		if m.Backfill == nil {
+			state.Phase = SchemaPhaseDone
+			state.FinishedAt = time.Now()
+		}
+		if err := dbm.Insert(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *SchemaMigration) expand(dbm *gorp.DbMap) error {
+	_, mysql := dbm.Dialect.(gorp.MySQLDialect)
+	if _, sqlite := dbm.Dialect.(gorp.SqliteDialect); !mysql && !sqlite {
+		return ErrSchemaUnsupportedDialect
+	}
+
+	// the tables created by the server of this version have the columns already
+	for _, column := range m.Columns {
+		if _, err := dbm.Exec(fmt.Sprintf("SELECT %s FROM %s LIMIT 0", column.Name, m.Table)); err == nil {
+			continue
+		}
+		definition := column.Sqlite
+		suffix := ""
+		if mysql {
+			definition = column.MySQL
+			suffix = ", ALGORITHM=INPLACE, LOCK=NONE"
+		}
+		if err := execDDL(dbm, mysql, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s%s", m.Table, column.Name, definition, suffix)); err != nil {
+			return err
+		}
+	}
+
+	for _, index := range m.Indexes {
+		query := "SELECT COUNT(name) FROM sqlite_master WHERE type = 'index' AND name = ?"
+		if mysql {
+			query = "SELECT COUNT(index_name) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?"
+		}
+		args := []interface{}{index.Name}
+		if mysql {
+			args = []interface{}{m.Table, index.Name}
+		}
+		count, err := dbm.SelectInt(query, args...)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		suffix := ""
+		if mysql {
+			suffix = " ALGORITHM=INPLACE LOCK=NONE"
+		}
+		if err := execDDL(dbm, mysql, fmt.Sprintf("CREATE INDEX %s ON %s (%s)%s", index.Name, m.Table, strings.Join(index.Columns, ", "), suffix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execDDL runs the DDL on a connection waiting for the metadata lock for a few seconds only, on MySQL.
+// The DDL commits the transaction, which is only to keep the connection.
+func execDDL(dbm *gorp.DbMap, mysql bool, ddl string) error {
+	if !mysql {
+		_, err := dbm.Exec(ddl)
+		return err
+	}
+	txn, err := dbm.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	if _, err := txn.Exec(fmt.Sprintf("SET SESSION lock_wait_timeout = %d", schemaLockWaitSeconds)); err != nil {
+		return err
+	}
+	_, err = txn.Exec(ddl)
+	if _, resetErr := txn.Exec("SET SESSION lock_wait_timeout = DEFAULT"); err == nil {
+		err = resetErr
+	}
+	return err
+}
+
+// BackfillSchema runs the backfill of the first migration that is not done, unless another server runs it.
+// The rows inserted after the backfill started are written with the new columns, so the backfill ends at the last
+// id of the table then. The batches are paused between, to leave the database to the requests.
+func BackfillSchema(dbm *gorp.DbMap, storage Storage, migrations []*SchemaMigration, batchSize int64, pause time.Duration) error {
+	for _, m := range migrations {
+		if m.Backfill == nil {
+			continue
+		}
+		state := &SchemaMigrationState{}
+		if err := dbm.SelectOne(state, "SELECT * FROM schema_migration WHERE name = ?", m.Name); err != nil {
+			return err
+		}
+		if state.IsDone() {
+			continue
+		}
+		claimed, err := m.claim(dbm, state)
+		if err != nil || !claimed {
+			return err
+		}
+		size := batchSize
+		if m.BatchSize > 0 {
+			size = m.BatchSize
+		}
+		if err := m.backfill(dbm, storage, state, size, pause); err != nil {
+			state.Phase = SchemaPhaseFailed
+			state.Error = err.Error()
+			if _, updateErr := dbm.Update(state); updateErr != nil {
+				return updateErr
+			}
+			return fmt.Errorf("%s: %s", m.Name, err)
+		}
+		// the migrations are backfilled in order, as a later one may read the columns of an earlier one
+	}
+	return nil
+}
+
+// claim takes the backfill of the migration, which is not taken by another server or is left by a server stopped.
+// The failed backfills are retried from the batch failed.
+func (m *SchemaMigration) claim(dbm *gorp.DbMap, state *SchemaMigrationState) (bool, error) {
+	maxId := state.MaxId
+	if state.Phase == SchemaPhaseExpanded {
+		id, err := dbm.SelectInt(fmt.Sprintf("SELECT IFNULL(MAX(id), 0) FROM %s", m.Table))
+		if err != nil {
+			return false, err
+		}
+		maxId = id
+	}
+	now := time.Now()
+	result, err := dbm.Exec(`UPDATE schema_migration SET phase = ?, max_id = ?, error = '', updated_at = ?
+		WHERE name = ? AND (phase IN (?, ?) OR (phase = ? AND updated_at < ?))`,
+		SchemaPhaseBackfilling, maxId, now, m.Name, SchemaPhaseExpanded, SchemaPhaseFailed, SchemaPhaseBackfilling, now.Add(-schemaBackfillStale))
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+	state.Phase = SchemaPhaseBackfilling
+	state.MaxId = maxId
+	state.Error = ""
+	return true, nil
+}
+
+func (m *SchemaMigration) backfill(dbm *gorp.DbMap, storage Storage, state *SchemaMigrationState, batchSize int64, pause time.Duration) error {
+	for state.BackfilledId < state.MaxId {
+		from := state.BackfilledId + 1
+		to := from + batchSize - 1
+		if to > state.MaxId {
+			to = state.MaxId
+		}
+		err := TransactDeleting(dbm, storage, nil, func(txn gorp.SqlExecutor, storage Storage) error {
+			if err := m.Backfill(txn, storage, from, to); err != nil {
+				return err
+			}
+			state.BackfilledId = to
+			_, err := txn.Update(state)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		time.Sleep(pause)
+	}
+
+	state.Phase = SchemaPhaseDone
+	state.FinishedAt = time.Now()
+	_, err := dbm.Update(state)
+	return err
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"errors"
+	"os"
+
+	"github.com/coopernurse/gorp"
+)
+
+var ErrAlreadySeeded = errors.New("seed: apps already exist")
+
+type seedApp struct {
+	Title       string
+	Description string
+	Category    string
+	Visibility  AppVisibility
+	Identifier  string
+	Versions    []string
+	KnownIssues []string
+}
+
+var seedApps = []*seedApp{
+	&seedApp{
+		Title:       "Demo Shooting",
+		Description: "デモ用のシューティングゲームです。",
+		Category:    "Game",
+		Visibility:  AppVisibilityListed,
+		Identifier:  "com.example.demo.shooting",
+		Versions:    []string{"1.0.0", "1.1.0", "1.2.0"},
+		KnownIssues: []string{"ランキング画面でスコアが更新されない"},
+	},
+	&seedApp{
+		Title:       "Demo Camera",
+		Description: "デモ用のカメラアプリです。",
+		Category:    "Tool",
+		Visibility:  AppVisibilityUnlisted,
+		Identifier:  "com.example.demo.camera",
+		Versions:    []string{"0.9.0", "1.0.0"},
+	},
+	&seedApp{
+		Title:       "Demo Internal",
+		Description: "限られたメンバーだけが見られるデモです。",
+		Visibility:  AppVisibilityPrivate,
+		Identifier:  "com.example.demo.internal",
+		Versions:    []string{"0.1.0"},
+	},
+}
+
+// a SeedReport tells the records made by Seed, with the tokens to try the APIs with
+type SeedReport struct {
+	Users       []*User
+	Apps        []*App
+	Bundles     []*Bundle
+	DeviceName  string
+	DeviceToken string
+}
+
+// Seed makes the demo users, apps and bundles with placeholder ipa files, so that a new deployment
+// or the UI development starts from a realistic state. The developer is the admin of every app,
+// and the tester is a tester of the listed ones. It refuses to run once any app exists.
+func Seed(dbm *gorp.DbMap, s *GoogleService, storage Storage, developerEmail, testerEmail string) (*SeedReport, error) {
+	count, err := dbm.SelectInt("SELECT COUNT(*) FROM app")
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrAlreadySeeded
+	}
+
+	report := &SeedReport{}
+	var developer, tester *User
+	err = Transact(dbm, func(txn gorp.SqlExecutor) error {
+		var err error
+		if developer, err = FindOrCreateUser(txn, developerEmail); err != nil {
+			return err
+		}
+		if tester, err = FindOrCreateUser(txn, testerEmail); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Users = []*User{developer, tester}
+
+	for _, seed := range seedApps {
+		app, bundles, err := seedAppWithBundles(dbm, s, storage, seed, developer, tester)
+		if err != nil {
+			return nil, err
+		}
+		report.Apps = append(report.Apps, app)
+		report.Bundles = append(report.Bundles, bundles...)
+	}
+
+	report.DeviceName = "Demo iPhone"
+	err = Transact(dbm, func(txn gorp.SqlExecutor) error {
+		device, err := StartPairing(txn, developer.Id)
+		if err != nil {
+			return err
+		}
+		_, token, err := CompletePairing(txn, device.PairingCode, report.DeviceName)
+		report.DeviceToken = token
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func seedAppWithBundles(dbm *gorp.DbMap, s *GoogleService, storage Storage, seed *seedApp, developer, tester *User) (*App, []*Bundle, error) {
+	app := &App{
+		Title:       seed.Title,
+		Description: seed.Description,
+		Category:    seed.Category,
+		Visibility:  seed.Visibility,
+	}
+	err := Transact(dbm, func(txn gorp.SqlExecutor) error {
+		if err := CreateApp(txn, s, app, ""); err != nil {
+			return err
+		}
+		authority := &Authority{
+			Email: developer.Email,
+			Role:  AuthorityRoleAdmin,
+		}
+		if err := app.CreateAuthority(txn, s, authority); err != nil {
+			return err
+		}
+		if seed.Visibility != AppVisibilityListed {
+			return nil
+		}
+		authority = &Authority{
+			Email: tester.Email,
+			Role:  AuthorityRoleTester,
+		}
+		return app.CreateAuthority(txn, s, authority)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var bundles []*Bundle
+	for _, version := range seed.Versions {
+		bundle, err := seedBundle(dbm, storage, app, seed.Identifier, version)
+		if err != nil {
+			return nil, nil, err
+		}
+		bundles = append(bundles, bundle)
+	}
+
+	// the known issues are of the latest version
+	if len(bundles) > 0 && len(seed.KnownIssues) > 0 {
+		err := Transact(dbm, func(txn gorp.SqlExecutor) error {
+			return bundles[len(bundles)-1].AddKnownIssues(txn, seed.KnownIssues)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return app, bundles, nil
+}
+
+func seedBundle(dbm *gorp.DbMap, storage Storage, app *App, identifier, version string) (*Bundle, error) {
+	file, err := CreatePlaceholderIpaFile(identifier, version)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	bundle := &Bundle{
+		PlatformType: BundlePlatformTypeIOS,
+		Description:  "バージョン " + version + " のデモです。",
+		File:         file,
+	}
+	if err := app.CreateBundle(dbm, storage, bundle); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
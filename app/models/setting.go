@@ -0,0 +1,206 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	SettingOrganizationName        = "branding.organizationname"
+	SettingSlackWebhookUrl         = "notification.slack.webhookurl"
+	SettingDriveTrashRetentionDays = "retention.drivetrashdays"
+	SettingAuditRetentionMonths    = "retention.auditmonths"
+	SettingUploadMaxSizeMb         = "upload.maxsizemb"
+	SettingSentryDsn               = "errorreporting.sentrydsn"
+	SettingStorageQuotaGb          = "storage.quotagb"
+	SettingLogoUrl                 = "branding.logourl"
+	SettingContactUrl              = "branding.contacturl"
+	SettingMaintenanceMessage      = "maintenance.message"
+	SettingOwnershipFallbackGroup  = "ownership.fallbackgroup"
+	SettingWebhookUrl              = "webhook.url"
+
+	SettingKindString = "string"
+	SettingKindInt    = "int"
+	SettingKindUrl    = "url"
+)
+
+// a Setting overrides the default of an operational setting in app.conf, so that it can be changed without a redeploy
+type Setting struct {
+	Key       string    `db:"setting_key"`
+	Value     string    `db:"value"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// a SettingDefinition is a setting which can be changed at runtime
+type SettingDefinition struct {
+	Key   string
+	Label string
+	Kind  string
+}
+
+var SettingDefinitions = []*SettingDefinition{
+	{SettingOrganizationName, "組織名", SettingKindString},
+	{SettingSlackWebhookUrl, "SlackのIncoming Webhook URL", SettingKindUrl},
+	{SettingDriveTrashRetentionDays, "ゴミ箱の保存日数", SettingKindInt},
+	{SettingAuditRetentionMonths, "監査ログの保存月数 (0は無期限)", SettingKindInt},
+	{SettingUploadMaxSizeMb, "アップロードの上限 MB (0は無制限)", SettingKindInt},
+	{SettingSentryDsn, "エラー通知先のSentry DSN", SettingKindUrl},
+	{SettingStorageQuotaGb, "ストレージの上限 GB (0はGoogle Driveの容量)", SettingKindInt},
+	{SettingLogoUrl, "ロゴ画像のURL", SettingKindUrl},
+	{SettingContactUrl, "問い合わせ先のURL (mailto:も可)", SettingKindString},
+	{SettingMaintenanceMessage, "メンテナンス中のメッセージ (空欄でメンテナンスを終了)", SettingKindString},
+	{SettingOwnershipFallbackGroup, "オーナー不在のプロジェクトを引き継ぐグループのメールアドレス (空欄で引き継がない)", SettingKindString},
+	{SettingWebhookUrl, "ビルドの解析結果を送るWebhook URL", SettingKindUrl},
+}
+
+// Settings is the values of the settings by the key
+type Settings map[string]string
+
+type SettingJsonResponse struct {
+	Key       string `json:"key"`
+	Label     string `json:"label"`
+	Value     string `json:"value"`
+	Default   string `json:"default"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+func (setting *Setting) PreInsert(s gorp.SqlExecutor) error {
+	setting.UpdatedAt = time.Now()
+	return nil
+}
+
+func (setting *Setting) PreUpdate(s gorp.SqlExecutor) error {
+	setting.UpdatedAt = time.Now()
+	return nil
+}
+
+func GetSettingDefinition(key string) *SettingDefinition {
+	for _, definition := range SettingDefinitions {
+		if definition.Key == key {
+			return definition
+		}
+	}
+	return nil
+}
+
+func (definition *SettingDefinition) Validate(value string) error {
+	if value == "" {
+		return nil
+	}
+	switch definition.Kind {
+	case SettingKindInt:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%s must be a number.", definition.Key)
+		}
+	case SettingKindUrl:
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be a URL.", definition.Key)
+		}
+	}
+	return nil
+}
+
+func GetSettingRows(txn gorp.SqlExecutor) ([]*Setting, error) {
+	var settings []*Setting
+	_, err := txn.Select(&settings, "SELECT * FROM setting ORDER BY setting_key ASC")
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// GetSettings returns the defaults overridden by the stored settings.
+func GetSettings(txn gorp.SqlExecutor, defaults Settings) (Settings, error) {
+	rows, err := GetSettingRows(txn)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := Settings{}
+	for key, value := range defaults {
+		settings[key] = value
+	}
+	for _, row := range rows {
+		if GetSettingDefinition(row.Key) != nil {
+			settings[row.Key] = row.Value
+		}
+	}
+	return settings, nil
+}
+
+// SaveSettings stores the given settings, and removes the empty ones to restore the defaults.
+func SaveSettings(txn gorp.SqlExecutor, values Settings) error {
+	for key, value := range values {
+		definition := GetSettingDefinition(key)
+		if definition == nil {
+			return fmt.Errorf("%s is not a setting.", key)
+		}
+		if err := definition.Validate(value); err != nil {
+			return err
+		}
+	}
+
+	for key, value := range values {
+		if value == "" {
+			if _, err := txn.Exec("DELETE FROM setting WHERE setting_key = ?", key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// the affected rows of an update do not tell whether the setting exists, as it is 0 for the same value in MySQL
+		count, err := txn.SelectInt("SELECT COUNT(*) FROM setting WHERE setting_key = ?", key)
+		if err != nil {
+			return err
+		}
+		setting := &Setting{Key: key, Value: value}
+		if count > 0 {
+			_, err = txn.Update(setting)
+		} else {
+			err = txn.Insert(setting)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (settings Settings) Int(key string) int {
+	n, _ := strconv.Atoi(settings[key])
+	return n
+}
+
+// GetSettingsJsonResponse returns every setting with the current value and the default.
+func GetSettingsJsonResponse(txn gorp.SqlExecutor, defaults Settings) ([]*SettingJsonResponse, error) {
+	settings, err := GetSettings(txn, defaults)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := GetSettingRows(txn)
+	if err != nil {
+		return nil, err
+	}
+	updatedAt := map[string]string{}
+	for _, row := range rows {
+		updatedAt[row.Key] = row.UpdatedAt.Format(time.RFC3339)
+	}
+
+	content := []*SettingJsonResponse{}
+	for _, definition := range SettingDefinitions {
+		content = append(content, &SettingJsonResponse{
+			Key:       definition.Key,
+			Label:     definition.Label,
+			Value:     settings[definition.Key],
+			Default:   defaults[definition.Key],
+			UpdatedAt: updatedAt[definition.Key],
+		})
+	}
+	return content, nil
+}
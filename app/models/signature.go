@@ -4,8 +4,10 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"code.google.com/p/go-uuid/uuid"
@@ -14,6 +16,9 @@ import (
 const (
 	SignatureExpireDuration      = 15 * time.Minute
 	SignaturePermittedHttpMethod = "GET"
+
+	signatureDeviceTokenPrefix = "device-"
+	signatureShareTokenPrefix  = "share-"
 )
 
 type ParamToSign struct {
@@ -94,13 +99,60 @@ func (signatureInfo *LimitedTimeSignatureInfo) IsValid(key string) (bool, error)
 }
 
 func NewLimitedTimeSignatureInfo(host, path string) *LimitedTimeSignatureInfo {
+	return NewLimitedTimeSignatureInfoFor(host, path, SignatureExpireDuration)
+}
+
+// NewLimitedTimeSignatureInfoFor signs the path valid for the duration, e.g. for the share links valid longer than the downloads.
+func NewLimitedTimeSignatureInfoFor(host, path string, expire time.Duration) *LimitedTimeSignatureInfo {
 	return &LimitedTimeSignatureInfo{
 		ParamToSign: &ParamToSign{
 			Method: SignaturePermittedHttpMethod,
 			Host:   host,
 			Path:   path,
 			Token:  uuid.NewRandom().String(),
-			Limit:  strconv.FormatInt(time.Now().Add(SignatureExpireDuration).Unix(), 10),
+			Limit:  strconv.FormatInt(time.Now().Add(expire).Unix(), 10),
 		},
 	}
 }
+
+// NewDeviceLimitedTimeSignatureInfo signs the ID of the paired device in the token,
+// so that the downloads through the URL are recorded for the device.
+func NewDeviceLimitedTimeSignatureInfo(host, path string, deviceId int) *LimitedTimeSignatureInfo {
+	signatureInfo := NewLimitedTimeSignatureInfo(host, path)
+	signatureInfo.ParamToSign.Token = fmt.Sprintf("%s%d-%s", signatureDeviceTokenPrefix, deviceId, signatureInfo.ParamToSign.Token)
+	return signatureInfo
+}
+
+// DeviceId returns the ID of the paired device signed in the token, or 0.
+func (param *ParamToSign) DeviceId() int {
+	if !strings.HasPrefix(param.Token, signatureDeviceTokenPrefix) {
+		return 0
+	}
+	s := strings.SplitN(strings.TrimPrefix(param.Token, signatureDeviceTokenPrefix), "-", 2)[0]
+	deviceId, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return deviceId
+}
+
+// NewShareLimitedTimeSignatureInfo signs the share generation of the bundle in the token of the share link valid for the
+// duration, so that the links shared are revoked by rotating the generation.
+func NewShareLimitedTimeSignatureInfo(host, path string, expire time.Duration, generation int) *LimitedTimeSignatureInfo {
+	signatureInfo := NewLimitedTimeSignatureInfoFor(host, path, expire)
+	signatureInfo.ParamToSign.Token = fmt.Sprintf("%s%d-%s", signatureShareTokenPrefix, generation, signatureInfo.ParamToSign.Token)
+	return signatureInfo
+}
+
+// ShareGeneration returns the share generation of the bundle signed in the token, and false if it is not of a share link.
+func (param *ParamToSign) ShareGeneration() (int, bool) {
+	if !strings.HasPrefix(param.Token, signatureShareTokenPrefix) {
+		return 0, false
+	}
+	s := strings.SplitN(strings.TrimPrefix(param.Token, signatureShareTokenPrefix), "-", 2)[0]
+	generation, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+	return generation, true
+}
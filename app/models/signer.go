@@ -0,0 +1,96 @@
+package models
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// a BundleSigner makes detached signatures of bundle files compatible with `cosign verify-blob --key`
+type BundleSigner struct {
+	key crypto.Signer
+}
+
+// NewBundleSigner parses a PEM encoded ECDSA or Ed25519 private key.
+func NewBundleSigner(data []byte) (*BundleSigner, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signer: private key is not PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return &BundleSigner{key: k}, nil
+	case ed25519.PrivateKey:
+		return &BundleSigner{key: k}, nil
+	}
+	return nil, errors.New("signer: private key is not an ECDSA or Ed25519 key")
+}
+
+// SignDigest returns the base64 encoded signature of the file with the hex encoded SHA-256 digest.
+// Ed25519 signs the whole message, so it cannot sign from the digest.
+func (signer *BundleSigner) SignDigest(digest string) (string, error) {
+	if _, ok := signer.key.(ed25519.PrivateKey); ok {
+		return "", errors.New("signer: Ed25519 key requires the file")
+	}
+	hash, err := hex.DecodeString(digest)
+	if err != nil {
+		return "", err
+	}
+	sig, err := signer.key.Sign(rand.Reader, hash, crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// Sign returns the base64 encoded signature of the file read from r.
+func (signer *BundleSigner) Sign(r io.Reader) (string, error) {
+	if key, ok := signer.key.(ed25519.PrivateKey); ok {
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(key, buf)), nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return signer.SignDigest(hex.EncodeToString(hash.Sum(nil)))
+}
+
+func (signer *BundleSigner) CanSignDigest() bool {
+	_, ok := signer.key.(ed25519.PrivateKey)
+	return !ok
+}
+
+// PublicKeyPem returns the public key to verify the signatures with.
+func (signer *BundleSigner) PublicKeyPem() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(signer.key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
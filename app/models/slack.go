@@ -0,0 +1,47 @@
+package models
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+)
+
+var slackClient = &http.Client{Timeout: 10 * time.Second}
+
+// SlackMessage returns the payload of the text to post to the channel of the Slack incoming webhook.
+// https://api.slack.com/messaging/webhooks
+func SlackMessage(text string) map[string]interface{} {
+	return map[string]interface{}{"text": text}
+}
+
+// a SlackImage is an image shown under the text of the message, which Slack fetches from the URL.
+type SlackImage struct {
+	Url   string
+	Title string
+}
+
+// SlackMessageWithImages returns the payload of the text followed by the images as the blocks, with the text as the fallback of the notifications.
+// https://api.slack.com/reference/block-kit/blocks#image
+func SlackMessageWithImages(text string, images []SlackImage) map[string]interface{} {
+	blocks := []map[string]interface{}{
+		{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": text}},
+	}
+	for _, image := range images {
+		blocks = append(blocks, map[string]interface{}{
+			"type":      "image",
+			"image_url": image.Url,
+			"alt_text":  image.Title,
+			"title":     map[string]string{"type": "plain_text", "text": image.Title},
+		})
+	}
+	return map[string]interface{}{"text": text, "blocks": blocks}
+}
+
+func newSlackRequest(webhookUrl string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest("POST", webhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
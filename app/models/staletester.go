@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// StaleTesterDefaultDays is the default days without an install to report a tester
+const StaleTesterDefaultDays = 14
+
+// a StaleTester is a tester who has not installed any bundle of the app since the time of the report.
+// LastInstalledAt is zero if the tester has never installed one.
+type StaleTester struct {
+	Authority       *Authority
+	LastInstalledAt time.Time
+}
+
+// StaleTesters returns the members with the tester role who have not downloaded any bundle of the app since the time,
+// the ones who have never downloaded one first.
+func (app *App) StaleTesters(txn gorp.SqlExecutor, since time.Time) ([]*StaleTester, error) {
+	authorities, err := app.Authorities(txn)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last download of each user
+	var audits []*Audit
+	_, err = txn.Select(&audits, `SELECT * FROM audit WHERE id IN (
+			SELECT MAX(audit.id) FROM audit INNER JOIN bundle ON bundle.id = audit.resource_id
+			WHERE audit.resource = ? AND audit.action = ? AND bundle.app_id = ? AND audit.user_id <> 0
+			GROUP BY audit.user_id
+		)`, ResourceBundle, ActionDownload, app.Id)
+	if err != nil {
+		return nil, err
+	}
+	lastInstalledAt := map[string]time.Time{}
+	for _, audit := range audits {
+		user, err := GetUser(txn, audit.UserId)
+		if err != nil {
+			return nil, err
+		}
+		lastInstalledAt[user.Email] = audit.CreatedAt
+	}
+
+	var never, stale []*StaleTester
+	for _, authority := range authorities {
+		if authority.Role != AuthorityRoleTester {
+			continue
+		}
+		t, ok := lastInstalledAt[authority.Email]
+		if !ok {
+			never = append(never, &StaleTester{Authority: authority})
+		} else if t.Before(since) {
+			stale = append(stale, &StaleTester{Authority: authority, LastInstalledAt: t})
+		}
+	}
+	return append(never, stale...), nil
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	StatusComponentService  = "service"
+	StatusComponentDatabase = "database"
+	StatusComponentStorage  = "storage"
+
+	// the checks are recorded every interval, so that the missing intervals count as the downtime of the service
+	StatusCheckInterval = 5 * time.Minute
+)
+
+var StatusComponents = []string{StatusComponentService, StatusComponentDatabase, StatusComponentStorage}
+
+// a StatusCheck is the result of a check of a component by the job
+type StatusCheck struct {
+	Id        int       `db:"id"`
+	Component string    `db:"component"`
+	Ok        bool      `db:"ok"`
+	Message   string    `db:"message"`
+	CheckedAt time.Time `db:"checked_at"`
+	// the interval of the check, counted from the epoch, to count the intervals up in the query
+	Period int64 `db:"period"`
+}
+
+func (check *StatusCheck) PreInsert(s gorp.SqlExecutor) error {
+	check.CheckedAt = time.Now()
+	check.Period = statusCheckPeriod(check.CheckedAt)
+	return nil
+}
+
+func statusCheckPeriod(t time.Time) int64 {
+	return t.Unix() / int64(StatusCheckInterval/time.Second)
+}
+
+// a componentUptime is the count of the intervals a component was up in, since each time
+type componentUptime struct {
+	Component   string `db:"component"`
+	FirstPeriod int64  `db:"first_period"`
+	UpDay       int    `db:"up_day"`
+	UpWeek      int    `db:"up_week"`
+	UpMonth     int    `db:"up_month"`
+}
+
+// a StatusIncident is a note of the admins on the status page, e.g. an outage or a maintenance
+type StatusIncident struct {
+	Id         int       `db:"id"`
+	Title      string    `db:"title"`
+	Body       string    `db:"body"`
+	Resolved   bool      `db:"resolved"`
+	CreatedAt  time.Time `db:"created_at"`
+	ResolvedAt time.Time `db:"resolved_at"`
+}
+
+func (incident *StatusIncident) PreInsert(s gorp.SqlExecutor) error {
+	incident.CreatedAt = time.Now()
+	incident.ResolvedAt = incident.CreatedAt
+	return nil
+}
+
+// a ComponentStatus is the summary of the checks of a component on the status page
+type ComponentStatus struct {
+	Component   string
+	Ok          bool
+	Message     string
+	CheckedAt   time.Time
+	UptimeDay   float64
+	UptimeWeek  float64
+	UptimeMonth float64
+}
+
+func RecordStatusCheck(txn gorp.SqlExecutor, component string, err error) error {
+	check := &StatusCheck{
+		Component: component,
+		Ok:        err == nil,
+	}
+	if err != nil {
+		check.Message = err.Error()
+	}
+	return txn.Insert(check)
+}
+
+// GetComponentStatuses summarizes the checks of the last 30 days, counting the intervals up in the query.
+// An interval is up when any server recorded the component ok in it.
+// The status of a component without a check is not Ok, with the zero CheckedAt.
+func GetComponentStatuses(txn gorp.SqlExecutor, now time.Time) ([]*ComponentStatus, error) {
+	since := now.AddDate(0, 0, -30)
+	day, week := now.AddDate(0, 0, -1), now.AddDate(0, 0, -7)
+	// the current interval is not over, so it is not counted
+	current := statusCheckPeriod(now)
+
+	var uptimes []*componentUptime
+	_, err := txn.Select(&uptimes, `SELECT component, MIN(period) AS first_period,
+		COUNT(DISTINCT CASE WHEN ok = ? AND period >= ? AND period < ? THEN period END) AS up_day,
+		COUNT(DISTINCT CASE WHEN ok = ? AND period >= ? AND period < ? THEN period END) AS up_week,
+		COUNT(DISTINCT CASE WHEN ok = ? AND period < ? THEN period END) AS up_month
+		FROM status_check WHERE period >= ? GROUP BY component`,
+		true, statusCheckPeriod(day), current,
+		true, statusCheckPeriod(week), current,
+		true, current,
+		statusCheckPeriod(since))
+	if err != nil {
+		return nil, err
+	}
+
+	var latest []*StatusCheck
+	_, err = txn.Select(&latest, "SELECT * FROM status_check WHERE id IN (SELECT MAX(id) FROM status_check WHERE period >= ? GROUP BY component)", statusCheckPeriod(since))
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*ComponentStatus
+	for _, component := range StatusComponents {
+		status := &ComponentStatus{Component: component, UptimeDay: 100, UptimeWeek: 100, UptimeMonth: 100}
+		for _, check := range latest {
+			if check.Component == component {
+				status.Ok = check.Ok
+				status.Message = check.Message
+				status.CheckedAt = check.CheckedAt
+			}
+		}
+		for _, u := range uptimes {
+			if u.Component != component {
+				continue
+			}
+			status.UptimeDay = uptime(u.UpDay, u.FirstPeriod, current, statusCheckPeriod(day))
+			status.UptimeWeek = uptime(u.UpWeek, u.FirstPeriod, current, statusCheckPeriod(week))
+			status.UptimeMonth = uptime(u.UpMonth, u.FirstPeriod, current, statusCheckPeriod(since))
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// uptime is the percentage of the intervals up since the one, or since the first check not to count the time before it.
+func uptime(up int, first, current, since int64) float64 {
+	if first > since {
+		since = first
+	}
+	total := current - since
+	if total <= 0 {
+		return 100
+	}
+	return float64(up) / float64(total) * 100
+}
+
+func PurgeStatusChecks(txn gorp.SqlExecutor, before time.Time) (int, error) {
+	result, err := txn.Exec("DELETE FROM status_check WHERE checked_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// GetStatusIncidents returns the open incidents and the ones resolved since the time, the latest first.
+func GetStatusIncidents(txn gorp.SqlExecutor, resolvedSince time.Time) ([]*StatusIncident, error) {
+	var incidents []*StatusIncident
+	_, err := txn.Select(&incidents, "SELECT * FROM status_incident WHERE resolved = ? OR resolved_at >= ? ORDER BY id DESC", false, resolvedSince)
+	return incidents, err
+}
+
+func GetStatusIncident(txn gorp.SqlExecutor, id int) (*StatusIncident, error) {
+	var incident StatusIncident
+	if err := txn.SelectOne(&incident, "SELECT * FROM status_incident WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return &incident, nil
+}
+
+func (incident *StatusIncident) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(incident)
+}
+
+func (incident *StatusIncident) Resolve(txn gorp.SqlExecutor) error {
+	incident.Resolved = true
+	incident.ResolvedAt = time.Now()
+	_, err := txn.Update(incident)
+	return err
+}
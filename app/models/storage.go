@@ -0,0 +1,154 @@
+package models
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"code.google.com/p/google-api-go-client/drive/v2"
+)
+
+// a Storage keeps the files of the bundles, so that another backend than Google Drive can be added without changing the bundles.
+// The location is where the file is put, e.g. the ID of the version folder on Google Drive or the prefix of the keys.
+type Storage interface {
+	// Put stores the file with the name in the location and returns the key to get it by
+	Put(file *os.File, name, location string) (string, error)
+	// Get opens the file of the key. The caller closes the body
+	Get(key string) (*StorageObject, error)
+	// Delete deletes the file of the key. A missing file is not an error
+	Delete(key string) error
+	// SignedURL returns the URL to download the file without the credentials until it expires, or "" if the backend cannot sign one
+	SignedURL(key string, expiry time.Duration) (string, error)
+}
+
+// a StreamStorage stores the file as it is read, for the uploads streamed without a temporary file.
+type StreamStorage interface {
+	Storage
+	// PutStream stores the size bytes of the reader with the name in the location and returns the key to get it by
+	PutStream(r io.Reader, size int64, name, location string) (string, error)
+}
+
+// a FolderStorage lists a file in the folders, as a file on Google Drive is in every parent folder.
+// The bundles sharing a blob are added to the folders of their versions, while a Storage without folders keeps the blob once.
+type FolderStorage interface {
+	Storage
+	// CreateFolder creates the folder in the parent folder and returns its ID, which is the location to put the files in
+	CreateFolder(name, parentId string) (string, error)
+	AddToFolder(key, folderId string) error
+	// RemoveFromFolder removes the file from the folder, but keeps it in the others
+	RemoveFromFolder(key, folderId string) error
+}
+
+// a ListStorage lists every file it keeps, to find the files no bundle refers to, e.g. left by a failed delete.
+// Google Drive is not listed, as the folders and the archives of the projects are in it too.
+type ListStorage interface {
+	Storage
+	// List calls the function with each file, in pages, as a bucket may have many
+	List(fn func(entry *StorageEntry) error) error
+}
+
+type StorageEntry struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+type StorageObject struct {
+	Body    io.ReadCloser
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// DriveStorage keeps the files in the folders of the apps on Google Drive.
+type DriveStorage struct {
+	Service *GoogleService
+}
+
+func NewDriveStorage(s *GoogleService) *DriveStorage {
+	return &DriveStorage{Service: s}
+}
+
+func (d *DriveStorage) Put(file *os.File, name, location string) (string, error) {
+	var parent *drive.ParentReference
+	if location != "" {
+		parent = &drive.ParentReference{Id: location}
+	}
+	driveFile, err := d.Service.InsertFile(file, name, parent)
+	if err != nil {
+		return "", err
+	}
+	return driveFile.Id, nil
+}
+
+// PutStream uploads the reader to Google Drive, which does not need the size in advance.
+func (d *DriveStorage) PutStream(r io.Reader, size int64, name, location string) (string, error) {
+	var parent *drive.ParentReference
+	if location != "" {
+		parent = &drive.ParentReference{Id: location}
+	}
+	driveFile, err := d.Service.InsertFileFrom(r, name, parent)
+	if err != nil {
+		return "", err
+	}
+	return driveFile.Id, nil
+}
+
+func (d *DriveStorage) Get(key string) (*StorageObject, error) {
+	resp, file, err := d.Service.DownloadFile(key)
+	if err != nil {
+		return nil, err
+	}
+	modTime, err := time.Parse(time.RFC3339, file.ModifiedDate)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return &StorageObject{
+		Body:    resp.Body,
+		Name:    file.OriginalFilename,
+		Size:    file.FileSize,
+		ModTime: modTime,
+	}, nil
+}
+
+func (d *DriveStorage) Delete(key string) error {
+	return ignoreDriveNotFound(d.Service.DeleteFile(key))
+}
+
+// SignedURL returns "", as the files on Google Drive are downloaded only with the access token.
+func (d *DriveStorage) SignedURL(key string, expiry time.Duration) (string, error) {
+	return "", nil
+}
+
+func (d *DriveStorage) CreateFolder(name, parentId string) (string, error) {
+	var parent *drive.ParentReference
+	if parentId != "" {
+		parent = &drive.ParentReference{Id: parentId}
+	}
+	driveFolder, err := d.Service.CreateFolderIn(name, parent)
+	if err != nil {
+		return "", err
+	}
+	return driveFolder.Id, nil
+}
+
+func (d *DriveStorage) AddToFolder(key, folderId string) error {
+	return d.Service.AddParent(key, folderId)
+}
+
+func (d *DriveStorage) RemoveFromFolder(key, folderId string) error {
+	return ignoreDriveNotFound(d.Service.RemoveParent(key, folderId))
+}
+
+func ignoreDriveNotFound(err error) error {
+	if err == nil {
+		return nil
+	}
+	code, _, _ := ParseGoogleApiError(err)
+	if code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel"
+)
+
+// a StorageDeletions defers the deletions of the files in a transaction until it has committed, so that the rows of
+// a deletion rolled back do not point to the files deleted. The copies of the blobs in the archive are deleted too.
+type StorageDeletions struct {
+	storage   Storage
+	archive   BundleArchive
+	deletions []func() error
+}
+
+func NewStorageDeletions(storage Storage, archive BundleArchive) *StorageDeletions {
+	return &StorageDeletions{storage: storage, archive: archive}
+}
+
+// TransactDeleting runs the function in a transaction with the storage deferring the deletions, and deletes the
+// files once it has committed.
+func TransactDeleting(dbm *gorp.DbMap, storage Storage, archive BundleArchive, f func(txn gorp.SqlExecutor, storage Storage) error) error {
+	deletions := NewStorageDeletions(storage, archive)
+	err := Transact(dbm, func(txn gorp.SqlExecutor) error {
+		return f(txn, deletions.Storage())
+	})
+	if err != nil {
+		return err
+	}
+	deletions.Run()
+	return nil
+}
+
+// Storage returns the storage deferring the deletions, with the folders if the storage has them.
+func (d *StorageDeletions) Storage() Storage {
+	deferred := &deferredStorage{Storage: d.storage, deletions: d}
+	if folderStorage, ok := d.storage.(FolderStorage); ok {
+		return &deferredFolderStorage{deferredStorage: deferred, folderStorage: folderStorage}
+	}
+	return deferred
+}
+
+// Run deletes the files after the commit. The files failing to be deleted are left to the reconciliation of the
+// storage, as nothing refers to them any more.
+func (d *StorageDeletions) Run() {
+	for _, deletion := range d.deletions {
+		if err := deletion(); err != nil {
+			revel.ERROR.Printf("failed to delete the file of the deleted rows: %s", err)
+		}
+	}
+	d.deletions = nil
+}
+
+func (d *StorageDeletions) add(deletion func() error) {
+	d.deletions = append(d.deletions, deletion)
+}
+
+type deferredStorage struct {
+	Storage
+	deletions *StorageDeletions
+}
+
+func (s *deferredStorage) Delete(key string) error {
+	s.deletions.add(func() error {
+		return s.Storage.Delete(key)
+	})
+	return nil
+}
+
+func (s *deferredStorage) storageDeletions() *StorageDeletions {
+	return s.deletions
+}
+
+type deferredFolderStorage struct {
+	*deferredStorage
+	folderStorage FolderStorage
+}
+
+func (s *deferredFolderStorage) CreateFolder(name, parentId string) (string, error) {
+	return s.folderStorage.CreateFolder(name, parentId)
+}
+
+func (s *deferredFolderStorage) AddToFolder(key, folderId string) error {
+	return s.folderStorage.AddToFolder(key, folderId)
+}
+
+func (s *deferredFolderStorage) RemoveFromFolder(key, folderId string) error {
+	s.deletions.add(func() error {
+		return s.folderStorage.RemoveFromFolder(key, folderId)
+	})
+	return nil
+}
+
+type deletionDeferrer interface {
+	storageDeletions() *StorageDeletions
+}
+
+// deferDeletion runs the deletion after the commit for the storage deferring the deletions, or at once otherwise.
+func deferDeletion(storage Storage, deletion func() error) error {
+	if deferrer, ok := storage.(deletionDeferrer); ok {
+		deferrer.storageDeletions().add(deletion)
+		return nil
+	}
+	return deletion()
+}
+
+// deleteArchiveCopy deletes the copy of the blob in the archive after the commit. The storages deleting at once do not
+// know the archive, and leave the copy.
+func deleteArchiveCopy(storage Storage, key string) {
+	deferrer, ok := storage.(deletionDeferrer)
+	if !ok || key == "" {
+		return
+	}
+	deletions := deferrer.storageDeletions()
+	if deletions.archive == nil {
+		return
+	}
+	deletions.add(func() error {
+		return deletions.archive.Delete(key)
+	})
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+// StorageFaults makes the requests to Google Drive fail or slow down, to see in tests and staging
+// that the uploads are retried, the partial failures are cleaned up and the deletes are reconciled.
+type StorageFaults struct {
+	ErrorPercent int
+	Latency      time.Duration
+	// the HTTP methods to inject the faults into, e.g. POST and PUT for the uploads. empty for all
+	Methods []string
+}
+
+// a FaultInjectingTransport answers a part of the requests with 503 as a Google API would when overloaded.
+type FaultInjectingTransport struct {
+	Base   http.RoundTripper
+	Faults *StorageFaults
+}
+
+func (t *FaultInjectingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !t.Faults.targets(req.Method) {
+		return base.RoundTrip(req)
+	}
+
+	if t.Faults.Latency > 0 {
+		time.Sleep(t.Faults.Latency)
+	}
+	if rand.Intn(100) >= t.Faults.ErrorPercent {
+		return base.RoundTrip(req)
+	}
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	body := `{"error":{"code":503,"message":"injected storage fault"}}`
+	return &http.Response{
+		Status:        "503 Service Unavailable",
+		StatusCode:    http.StatusServiceUnavailable,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewBufferString(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (faults *StorageFaults) targets(method string) bool {
+	if len(faults.Methods) == 0 {
+		return true
+	}
+	for _, m := range faults.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// InjectFaults makes the requests to Google APIs fail as the faults say.
+func (s *GoogleService) InjectFaults(faults *StorageFaults) {
+	if faults == nil || s.Client == nil {
+		return
+	}
+	if transport, ok := s.Client.Transport.(*oauth.Transport); ok {
+		transport.Transport = &FaultInjectingTransport{Base: transport.Transport, Faults: faults}
+	}
+}
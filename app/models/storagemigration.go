@@ -0,0 +1,245 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the failures are reported up to the number, as the rest are retried by running the migration again
+const StorageMigrationMaxFailures = 100
+
+// a StorageMigration copies the files of the bundles from a storage backend to another, e.g. to move from Google Drive to S3
+// before switching storage.backend. The files are kept in the source, to go back until the backend is switched.
+type StorageMigration struct {
+	From       string
+	To         string
+	Total      int
+	Copied     int
+	Skipped    int
+	Failed     int
+	Failures   []string
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	mutex sync.Mutex
+}
+
+type StorageMigrationJsonResponse struct {
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Running    bool     `json:"running"`
+	Total      int      `json:"total"`
+	Copied     int      `json:"copied"`
+	Skipped    int      `json:"skipped"`
+	Failed     int      `json:"failed"`
+	Failures   []string `json:"failures"`
+	StartedAt  string   `json:"started_at"`
+	FinishedAt string   `json:"finished_at"`
+}
+
+// a migrationFile is a file in the source and a bundle with it, to put the copy in the folder of the version
+type migrationFile struct {
+	FileId        string `db:"file_id"`
+	AppId         int    `db:"app_id"`
+	BundleVersion string `db:"bundle_version"`
+}
+
+// a StorageMigrationFile is the copy of a file in the backend it is migrated to. The bundles keep pointing to the source
+// until the backend is switched, when the copies of the backend are applied to them at once.
+type StorageMigrationFile struct {
+	Id           int       `db:"id"`
+	Backend      string    `db:"backend"`
+	SourceFileId string    `db:"source_file_id"`
+	FileId       string    `db:"file_id"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+// the columns of the files in the storage, all of which are migrated
+var storageFileColumns = []struct{ table, column string }{
+	{"bundle", "file_id"},
+	{"bundle", "universal_apk_file_id"},
+	{"bundle_blob", "file_id"},
+	{"bundle_split", "file_id"},
+	{"bundle_dsym", "file_id"},
+	{"bundle_mapping", "file_id"},
+}
+
+// the files of the bundles which are not archived, and the splits, the dSYMs and the mappings of every bundle
+const migrationFilesQuery = `SELECT file_id, MIN(app_id) AS app_id, MIN(bundle_version) AS bundle_version FROM (
+	SELECT file_id, app_id, bundle_version FROM bundle WHERE file_id <> '' AND archive_state = ''
+	UNION ALL SELECT universal_apk_file_id, app_id, bundle_version FROM bundle WHERE universal_apk_file_id <> ''
+	UNION ALL SELECT bundle_split.file_id, bundle.app_id, bundle.bundle_version FROM bundle_split INNER JOIN bundle ON bundle.id = bundle_split.bundle_id WHERE bundle_split.file_id <> ''
+	UNION ALL SELECT bundle_dsym.file_id, bundle.app_id, bundle.bundle_version FROM bundle_dsym INNER JOIN bundle ON bundle.id = bundle_dsym.bundle_id WHERE bundle_dsym.file_id <> ''
+	UNION ALL SELECT bundle_mapping.file_id, bundle.app_id, bundle.bundle_version FROM bundle_mapping INNER JOIN bundle ON bundle.id = bundle_mapping.bundle_id WHERE bundle_mapping.file_id <> ''
+) files GROUP BY file_id ORDER BY file_id`
+
+func (file *StorageMigrationFile) PreInsert(s gorp.SqlExecutor) error {
+	file.CreatedAt = time.Now()
+	return nil
+}
+
+func NewStorageMigration(from, to string) *StorageMigration {
+	return &StorageMigration{From: from, To: to, Failures: []string{}, StartedAt: time.Now()}
+}
+
+func (m *StorageMigration) IsRunning() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.FinishedAt.IsZero()
+}
+
+func (m *StorageMigration) JsonResponse() *StorageMigrationJsonResponse {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	finishedAt := ""
+	if !m.FinishedAt.IsZero() {
+		finishedAt = m.FinishedAt.Format(time.RFC3339)
+	}
+	return &StorageMigrationJsonResponse{
+		From:       m.From,
+		To:         m.To,
+		Running:    m.FinishedAt.IsZero(),
+		Total:      m.Total,
+		Copied:     m.Copied,
+		Skipped:    m.Skipped,
+		Failed:     m.Failed,
+		Failures:   append([]string{}, m.Failures...),
+		StartedAt:  m.StartedAt.Format(time.RFC3339),
+		FinishedAt: finishedAt,
+	}
+}
+
+// Run copies every file of the bundles which are not archived, with their universal apks, splits, dSYMs and mappings,
+// and records the copies to apply at the switch of the backend by ApplyStorageMigration. A file already copied by the
+// last run is skipped, so the migration can run again for the failed ones and the ones uploaded meanwhile.
+func (m *StorageMigration) Run(dbm *gorp.DbMap, from, to Storage) error {
+	defer func() {
+		m.mutex.Lock()
+		m.FinishedAt = time.Now()
+		m.mutex.Unlock()
+	}()
+
+	var files []*migrationFile
+	_, err := dbm.Select(&files, migrationFilesQuery)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.Total = len(files)
+	m.mutex.Unlock()
+
+	for _, file := range files {
+		skipped, err := migrateFile(dbm, from, to, m.To, file)
+		m.mutex.Lock()
+		switch {
+		case err != nil:
+			m.Failed++
+			if len(m.Failures) < StorageMigrationMaxFailures {
+				m.Failures = append(m.Failures, fmt.Sprintf("%s: %s", file.FileId, err))
+			}
+		case skipped:
+			m.Skipped++
+		default:
+			m.Copied++
+		}
+		m.mutex.Unlock()
+	}
+	return nil
+}
+
+// migrateFile copies the file through a temporary file, as the storages put the local files, and records the copy.
+func migrateFile(dbm *gorp.DbMap, from, to Storage, backend string, file *migrationFile) (bool, error) {
+	count, err := dbm.SelectInt("SELECT COUNT(id) FROM storage_migration_file WHERE backend = ? AND source_file_id = ?", backend, file.FileId)
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	object, err := from.Get(file.FileId)
+	if err != nil {
+		return false, err
+	}
+	defer object.Body.Close()
+
+	tmp, err := ioutil.TempFile("", "alphawing-migration")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, object.Body); err != nil {
+		return false, err
+	}
+	if _, err := tmp.Seek(0, os.SEEK_SET); err != nil {
+		return false, err
+	}
+
+	app, err := GetApp(dbm, file.AppId)
+	if err != nil {
+		return false, err
+	}
+	location, err := app.versionLocation(dbm, to, file.BundleVersion)
+	if err != nil {
+		return false, err
+	}
+	key, err := to.Put(tmp, object.Name, location)
+	if err != nil {
+		return false, err
+	}
+
+	err = dbm.Insert(&StorageMigrationFile{Backend: backend, SourceFileId: file.FileId, FileId: key})
+	if err != nil {
+		to.Delete(key)
+		return false, err
+	}
+	return false, nil
+}
+
+// ApplyStorageMigration points the bundles, the blobs, the splits, the dSYMs and the mappings to the copies in the
+// backend in a transaction, once it is switched to, and returns the number of the files applied. The copies of the
+// files deleted meanwhile are left to the reconciliation of the storage.
+func ApplyStorageMigration(dbm *gorp.DbMap, backend string) (int64, error) {
+	var applied int64
+	err := Transact(dbm, func(txn gorp.SqlExecutor) error {
+		var err error
+		if applied, err = txn.SelectInt("SELECT COUNT(id) FROM storage_migration_file WHERE backend = ?", backend); err != nil || applied == 0 {
+			return err
+		}
+		for _, c := range storageFileColumns {
+			query := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = (
+				SELECT storage_migration_file.file_id FROM storage_migration_file WHERE storage_migration_file.backend = ? AND storage_migration_file.source_file_id = %[1]s.%[2]s
+			) WHERE %[2]s IN (SELECT source_file_id FROM storage_migration_file WHERE backend = ?)`, c.table, c.column)
+			if _, err := txn.Exec(query, backend, backend); err != nil {
+				return err
+			}
+		}
+		_, err = txn.Exec("DELETE FROM storage_migration_file WHERE backend = ?", backend)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return applied, nil
+}
+
+// IsStorageNotFound tells whether the error of a storage is for the missing file.
+func IsStorageNotFound(err error) bool {
+	if storageErr, ok := err.(*StorageError); ok {
+		return storageErr.StatusCode == http.StatusNotFound
+	}
+	if os.IsNotExist(err) {
+		return true
+	}
+	code, _, _ := ParseGoogleApiError(err)
+	return code == http.StatusNotFound
+}
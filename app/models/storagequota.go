@@ -0,0 +1,107 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the admins are alerted once per threshold as the usage grows, in percent of the quota
+var StorageAlertThresholds = []int{80, 90, 95}
+
+// a StorageUsage is the usage of the Google Drive of the service account against the quota
+type StorageUsage struct {
+	UsedBytes  int64
+	QuotaBytes int64
+}
+
+// a StorageAlert records the threshold the usage crossed, so that each threshold is alerted only once
+type StorageAlert struct {
+	Id         int       `db:"id"`
+	Threshold  int       `db:"threshold"`
+	UsedBytes  int64     `db:"used_bytes"`
+	QuotaBytes int64     `db:"quota_bytes"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+func (alert *StorageAlert) PreInsert(s gorp.SqlExecutor) error {
+	alert.CreatedAt = time.Now()
+	return nil
+}
+
+// GetStorageUsage returns the usage against the quota, or against the capacity of the Drive if the quota is 0.
+func GetStorageUsage(s *GoogleService, quotaBytes int64) (*StorageUsage, error) {
+	about, err := s.GetAbout()
+	if err != nil {
+		return nil, err
+	}
+	if quotaBytes == 0 {
+		quotaBytes = about.QuotaBytesTotal
+	}
+	return &StorageUsage{
+		UsedBytes:  about.QuotaBytesUsed,
+		QuotaBytes: quotaBytes,
+	}, nil
+}
+
+func (usage *StorageUsage) Percent() float64 {
+	if usage.QuotaBytes == 0 {
+		return 0
+	}
+	return float64(usage.UsedBytes) / float64(usage.QuotaBytes) * 100
+}
+
+// Threshold returns the highest threshold the usage crossed, or 0.
+func (usage *StorageUsage) Threshold() int {
+	threshold := 0
+	for _, t := range StorageAlertThresholds {
+		if usage.Percent() >= float64(t) {
+			threshold = t
+		}
+	}
+	return threshold
+}
+
+// GetLastStorageAlert returns the last alert, or nil if the usage has never crossed a threshold.
+func GetLastStorageAlert(txn gorp.SqlExecutor) (*StorageAlert, error) {
+	var alert StorageAlert
+	err := txn.SelectOne(&alert, "SELECT * FROM storage_alert ORDER BY id DESC LIMIT 1")
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// UpdateStorageAlert records the threshold of the usage if it changed, and returns the alert if it went up.
+// When the usage goes down, the lower threshold is recorded without an alert, so that crossing it again is alerted.
+func UpdateStorageAlert(txn gorp.SqlExecutor, usage *StorageUsage) (*StorageAlert, error) {
+	last, err := GetLastStorageAlert(txn)
+	if err != nil {
+		return nil, err
+	}
+	lastThreshold := 0
+	if last != nil {
+		lastThreshold = last.Threshold
+	}
+
+	threshold := usage.Threshold()
+	if threshold == lastThreshold {
+		return nil, nil
+	}
+	alert := &StorageAlert{
+		Threshold:  threshold,
+		UsedBytes:  usage.UsedBytes,
+		QuotaBytes: usage.QuotaBytes,
+	}
+	if err := txn.Insert(alert); err != nil {
+		return nil, err
+	}
+	if threshold < lastThreshold {
+		return nil, nil
+	}
+	return alert, nil
+}
@@ -0,0 +1,234 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	// the files and the bundles are reported up to the number, and all of them are counted
+	StorageReconciliationMaxReports = 100
+	// the files put and the bundles created in the period are not checked, as an upload puts the file before it creates the bundle
+	StorageReconciliationGracePeriod = time.Hour
+)
+
+// a StorageReconciliation cross-checks the bundles against the files of a storage backend, as a failed delete leaves
+// the file of a deleted bundle or the bundle of a deleted file. It only reports them, unless Clean is set to delete
+// the orphaned files and the bundles of the missing files.
+type StorageReconciliation struct {
+	Backend string
+	Clean   bool
+	// the storage is listed for the orphaned files, which Google Drive is not
+	Listed        bool
+	Files         int
+	Orphaned      int
+	OrphanedFiles []string
+	Bundles       int
+	Missing       int
+	MissingFiles  []string
+	Deleted       int
+	Failed        int
+	Failures      []string
+	StartedAt     time.Time
+	FinishedAt    time.Time
+
+	mutex sync.Mutex
+}
+
+type StorageReconciliationJsonResponse struct {
+	Backend       string   `json:"backend"`
+	Clean         bool     `json:"clean"`
+	Running       bool     `json:"running"`
+	Listed        bool     `json:"listed"`
+	Files         int      `json:"files"`
+	Orphaned      int      `json:"orphaned"`
+	OrphanedFiles []string `json:"orphaned_files"`
+	Bundles       int      `json:"bundles"`
+	Missing       int      `json:"missing"`
+	MissingFiles  []string `json:"missing_files"`
+	Deleted       int      `json:"deleted"`
+	Failed        int      `json:"failed"`
+	Failures      []string `json:"failures"`
+	StartedAt     string   `json:"started_at"`
+	FinishedAt    string   `json:"finished_at"`
+}
+
+type reconciliationBundle struct {
+	Id     int    `db:"id"`
+	FileId string `db:"file_id"`
+}
+
+func NewStorageReconciliation(backend string, clean bool) *StorageReconciliation {
+	return &StorageReconciliation{
+		Backend:       backend,
+		Clean:         clean,
+		OrphanedFiles: []string{},
+		MissingFiles:  []string{},
+		Failures:      []string{},
+		StartedAt:     time.Now(),
+	}
+}
+
+func (r *StorageReconciliation) IsRunning() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.FinishedAt.IsZero()
+}
+
+func (r *StorageReconciliation) JsonResponse() *StorageReconciliationJsonResponse {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	finishedAt := ""
+	if !r.FinishedAt.IsZero() {
+		finishedAt = r.FinishedAt.Format(time.RFC3339)
+	}
+	return &StorageReconciliationJsonResponse{
+		Backend:       r.Backend,
+		Clean:         r.Clean,
+		Running:       r.FinishedAt.IsZero(),
+		Listed:        r.Listed,
+		Files:         r.Files,
+		Orphaned:      r.Orphaned,
+		OrphanedFiles: append([]string{}, r.OrphanedFiles...),
+		Bundles:       r.Bundles,
+		Missing:       r.Missing,
+		MissingFiles:  append([]string{}, r.MissingFiles...),
+		Deleted:       r.Deleted,
+		Failed:        r.Failed,
+		Failures:      append([]string{}, r.Failures...),
+		StartedAt:     r.StartedAt.Format(time.RFC3339),
+		FinishedAt:    finishedAt,
+	}
+}
+
+// Run lists the storage before it reads the bundles, so that a file of a bundle created meanwhile is not taken for an orphan.
+// The files of the archived bundles are not checked, as they are moved to the archive, but they are not orphans either.
+func (r *StorageReconciliation) Run(dbm *gorp.DbMap, storage Storage) error {
+	defer func() {
+		r.mutex.Lock()
+		r.FinishedAt = time.Now()
+		r.mutex.Unlock()
+	}()
+	checkedBefore := r.StartedAt.Add(-StorageReconciliationGracePeriod)
+
+	var entries []*StorageEntry
+	listStorage, listed := storage.(ListStorage)
+	if listed {
+		err := listStorage.List(func(entry *StorageEntry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var keys []string
+	_, err := dbm.Select(&keys, `SELECT file_id FROM bundle WHERE file_id <> ''
+		UNION SELECT universal_apk_file_id FROM bundle WHERE universal_apk_file_id <> ''
+		UNION SELECT file_id FROM bundle_blob WHERE file_id <> ''
+		UNION SELECT file_id FROM bundle_split WHERE file_id <> ''
+		UNION SELECT file_id FROM bundle_dsym WHERE file_id <> ''
+		UNION SELECT file_id FROM bundle_mapping WHERE file_id <> ''`)
+	if err != nil {
+		return err
+	}
+	referred := map[string]bool{}
+	for _, key := range keys {
+		referred[key] = true
+	}
+
+	var bundles []*reconciliationBundle
+	_, err = dbm.Select(&bundles, "SELECT id, file_id FROM bundle WHERE file_id <> '' AND archive_state = '' AND created_at < ? ORDER BY id", checkedBefore)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.Listed = listed
+	r.Files = len(entries)
+	r.Bundles = len(bundles)
+	r.mutex.Unlock()
+
+	stored := map[string]bool{}
+	for _, entry := range entries {
+		stored[entry.Key] = true
+		if referred[entry.Key] || !entry.ModTime.Before(checkedBefore) {
+			continue
+		}
+		r.report(&r.Orphaned, &r.OrphanedFiles, entry.Key)
+		if r.Clean {
+			r.result(entry.Key, storage.Delete(entry.Key))
+		}
+	}
+
+	for _, bundle := range bundles {
+		exists, err := r.exists(storage, stored, bundle.FileId)
+		if err != nil {
+			r.result(bundle.FileId, err)
+			continue
+		}
+		if exists {
+			continue
+		}
+		r.report(&r.Missing, &r.MissingFiles, fmt.Sprintf("bundle %d: %s", bundle.Id, bundle.FileId))
+		if r.Clean {
+			r.result(bundle.FileId, deleteReconciledBundle(dbm, storage, bundle.Id))
+		}
+	}
+	return nil
+}
+
+// exists looks the file up in the files listed, or gets the file of a storage which is not listed.
+func (r *StorageReconciliation) exists(storage Storage, stored map[string]bool, key string) (bool, error) {
+	if r.Listed {
+		return stored[key], nil
+	}
+	object, err := storage.Get(key)
+	if err != nil {
+		if IsStorageNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	object.Body.Close()
+	return true, nil
+}
+
+func (r *StorageReconciliation) report(count *int, reports *[]string, report string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	*count++
+	if len(*reports) < StorageReconciliationMaxReports {
+		*reports = append(*reports, report)
+	}
+}
+
+// result counts the deletion of the file or the bundle.
+func (r *StorageReconciliation) result(key string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil {
+		r.Failed++
+		if len(r.Failures) < StorageReconciliationMaxReports {
+			r.Failures = append(r.Failures, fmt.Sprintf("%s: %s", key, err))
+		}
+		return
+	}
+	r.Deleted++
+}
+
+// deleteReconciledBundle deletes the bundle as the users do, which releases the blob of the missing file.
+func deleteReconciledBundle(dbm *gorp.DbMap, storage Storage, id int) error {
+	return TransactDeleting(dbm, storage, nil, func(txn gorp.SqlExecutor, storage Storage) error {
+		bundle, err := GetBundle(txn, id)
+		if err != nil {
+			return err
+		}
+		return bundle.Delete(txn, storage)
+	})
+}
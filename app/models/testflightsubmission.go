@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	TestFlightStateUploading  = "UPLOADING"
+	TestFlightStateProcessing = "PROCESSING"
+	TestFlightStateComplete   = "COMPLETE"
+	TestFlightStateFailed     = "FAILED"
+)
+
+// a TestFlightSubmission records the hand-off of a bundle to TestFlight
+type TestFlightSubmission struct {
+	Id            int       `db:"id"`
+	BundleId      int       `db:"bundle_id"`
+	BuildUploadId string    `db:"build_upload_id"`
+	State         string    `db:"state"`
+	Message       string    `db:"message"`
+	CreatedAt     time.Time `db:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at"`
+}
+
+func (submission *TestFlightSubmission) PreInsert(s gorp.SqlExecutor) error {
+	submission.CreatedAt = time.Now()
+	submission.UpdatedAt = submission.CreatedAt
+	return nil
+}
+
+func (submission *TestFlightSubmission) PreUpdate(s gorp.SqlExecutor) error {
+	submission.UpdatedAt = time.Now()
+	return nil
+}
+
+func (submission *TestFlightSubmission) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(submission)
+}
+
+func (submission *TestFlightSubmission) Update(txn gorp.SqlExecutor) error {
+	_, err := txn.Update(submission)
+	return err
+}
+
+// Touch keeps the submission claimed while it is uploaded.
+func (submission *TestFlightSubmission) Touch(txn gorp.SqlExecutor) error {
+	return touchUpload(txn, "test_flight_submission", submission.Id, TestFlightStateUploading, time.Now())
+}
+
+func (submission *TestFlightSubmission) IsFinished() bool {
+	return submission.State == TestFlightStateComplete || submission.State == TestFlightStateFailed
+}
+
+func GetProcessingTestFlightSubmissions(txn gorp.SqlExecutor) ([]*TestFlightSubmission, error) {
+	var submissions []*TestFlightSubmission
+	_, err := txn.Select(&submissions, "SELECT * FROM test_flight_submission WHERE state = ? ORDER BY id ASC", TestFlightStateProcessing)
+	if err != nil {
+		return nil, err
+	}
+	return submissions, nil
+}
+
+// ClaimStaleTestFlightSubmissions takes the submissions left uploading by a server stopped, to upload them again.
+func ClaimStaleTestFlightSubmissions(txn gorp.SqlExecutor, now time.Time) ([]*TestFlightSubmission, error) {
+	ids, err := claimStaleUploads(txn, "test_flight_submission", TestFlightStateUploading, now)
+	if err != nil {
+		return nil, err
+	}
+	var submissions []*TestFlightSubmission
+	for _, id := range ids {
+		var submission TestFlightSubmission
+		if err := txn.SelectOne(&submission, "SELECT * FROM test_flight_submission WHERE id = ?", id); err != nil {
+			return nil, err
+		}
+		submissions = append(submissions, &submission)
+	}
+	return submissions, nil
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+const (
+	TestLabScope         = "https://www.googleapis.com/auth/cloud-platform"
+	TestLabBaseUrl       = "https://testing.googleapis.com/v1"
+	TestLabUploadUrl     = "https://storage.googleapis.com/upload/storage/v1"
+	TestLabConsoleUrl    = "https://console.firebase.google.com/project/%s/testlab/histories/"
+	testLabLocale        = "ja_JP"
+	testLabOrientation   = "portrait"
+	testLabObjectPrefix  = "alphawing"
+	testLabDeviceDivider = ":"
+)
+
+// TestLab runs the robo test of the bundles on the devices of Firebase Test Lab,
+// uploading the bundles to the Cloud Storage bucket which the results are written to.
+// https://firebase.google.com/docs/test-lab/reference/testing/rest
+type TestLab struct {
+	ProjectId string
+	Bucket    string
+	Devices   []string
+	BaseUrl   string
+	UploadUrl string
+	Client    *http.Client
+}
+
+type TestLabError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *TestLabError) Error() string {
+	return fmt.Sprintf("test lab: got HTTP response code %d: %s", e.StatusCode, e.Body)
+}
+
+type testLabMatrix struct {
+	TestMatrixId   string `json:"testMatrixId"`
+	State          string `json:"state"`
+	OutcomeSummary string `json:"outcomeSummary"`
+	// the reason of the INVALID state
+	InvalidMatrixDetails string `json:"invalidMatrixDetails"`
+	ResultStorage        struct {
+		ResultsUrl string `json:"resultsUrl"`
+	} `json:"resultStorage"`
+}
+
+func NewTestLab(token *oauth.Token, projectId, bucket string, devices []string) *TestLab {
+	return &TestLab{
+		ProjectId: projectId,
+		Bucket:    bucket,
+		Devices:   devices,
+		BaseUrl:   TestLabBaseUrl,
+		UploadUrl: TestLabUploadUrl,
+		Client:    createOAuthClient(token),
+	}
+}
+
+// Start uploads the bundle to the bucket and creates the test matrix of the robo test on the devices.
+// The link is to the histories of the project, until the results are written.
+func (t *TestLab) Start(bundle *Bundle, filename string, file io.Reader) (string, string, error) {
+	// the runs of a bundle are kept apart, as it may be run again
+	dir := fmt.Sprintf("%s/bundle-%d/%d", testLabObjectPrefix, bundle.Id, time.Now().Unix())
+	if err := t.upload(dir+"/"+filename, file); err != nil {
+		return "", "", err
+	}
+	appPath := map[string]string{"gcsPath": fmt.Sprintf("gs://%s/%s/%s", t.Bucket, dir, filename)}
+
+	matrix := map[string]interface{}{
+		"resultStorage": map[string]interface{}{
+			"googleCloudStorage": map[string]string{"gcsPath": fmt.Sprintf("gs://%s/%s/results", t.Bucket, dir)},
+		},
+	}
+	if bundle.IsIpa() {
+		matrix["testSpecification"] = map[string]interface{}{"iosRoboTest": map[string]interface{}{"appIpa": appPath}}
+		matrix["environmentMatrix"] = map[string]interface{}{"iosDeviceList": map[string]interface{}{"iosDevices": t.devices("iosModelId", "iosVersionId")}}
+	} else {
+		matrix["testSpecification"] = map[string]interface{}{"androidRoboTest": map[string]interface{}{"appApk": appPath}}
+		matrix["environmentMatrix"] = map[string]interface{}{"androidDeviceList": map[string]interface{}{"androidDevices": t.devices("androidModelId", "androidVersionId")}}
+	}
+
+	var res testLabMatrix
+	if err := t.doJson("POST", fmt.Sprintf("%s/projects/%s/testMatrices", t.BaseUrl, t.ProjectId), matrix, &res); err != nil {
+		return "", "", err
+	}
+	link := res.ResultStorage.ResultsUrl
+	if link == "" {
+		link = fmt.Sprintf(TestLabConsoleUrl, t.ProjectId)
+	}
+	return res.TestMatrixId, link, nil
+}
+
+// State maps the state and the outcome of the test matrix, with the link to its results once they are written.
+func (t *TestLab) State(bundle *Bundle, runId string) (string, string, string, error) {
+	var res testLabMatrix
+	if err := t.doJson("GET", fmt.Sprintf("%s/projects/%s/testMatrices/%s", t.BaseUrl, t.ProjectId, runId), nil, &res); err != nil {
+		return "", "", "", err
+	}
+
+	switch res.State {
+	case "FINISHED":
+		if res.OutcomeSummary == "SUCCESS" {
+			return DeviceFarmStatePassed, "", res.ResultStorage.ResultsUrl, nil
+		}
+		return DeviceFarmStateFailed, res.OutcomeSummary, res.ResultStorage.ResultsUrl, nil
+	case "INVALID":
+		return DeviceFarmStateError, res.InvalidMatrixDetails, res.ResultStorage.ResultsUrl, nil
+	case "ERROR", "CANCELLED":
+		return DeviceFarmStateError, res.State, res.ResultStorage.ResultsUrl, nil
+	}
+	return DeviceFarmStateRunning, "", res.ResultStorage.ResultsUrl, nil
+}
+
+// devices returns the devices of the lines of "model:version" with the keys of the platform.
+func (t *TestLab) devices(modelKey, versionKey string) []map[string]string {
+	devices := []map[string]string{}
+	for _, device := range t.Devices {
+		model, version := device, ""
+		if i := strings.Index(device, testLabDeviceDivider); i >= 0 {
+			model, version = device[:i], device[i+1:]
+		}
+		devices = append(devices, map[string]string{
+			modelKey:      model,
+			versionKey:    version,
+			"locale":      testLabLocale,
+			"orientation": testLabOrientation,
+		})
+	}
+	return devices
+}
+
+func (t *TestLab) upload(name string, file io.Reader) error {
+	u := fmt.Sprintf("%s/b/%s/o?uploadType=media&name=%s", t.UploadUrl, url.QueryEscape(t.Bucket), url.QueryEscape(name))
+	req, err := http.NewRequest("POST", u, file)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return t.do(req, nil)
+}
+
+func (t *TestLab) doJson(method, u string, body interface{}, v interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return t.do(req, v)
+}
+
+func (t *TestLab) do(req *http.Request, v interface{}) error {
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return &TestLabError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if v == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
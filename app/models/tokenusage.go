@@ -0,0 +1,198 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the errors kept for each token, the older ones are deleted
+const TokenErrorsKept = 20
+
+// a TokenUsage counts the requests with a token, for the admins to find the tokens no longer used to revoke
+// and the integrations sending too many requests. The tokens are told apart by the digest, so the API token
+// replaced by the refresh keeps its own counts, with the errors of the integrations still sending it.
+type TokenUsage struct {
+	Id          int       `db:"id"`
+	TokenKind   string    `db:"token_kind"`
+	TokenId     int       `db:"token_id"`
+	TokenDigest string    `db:"token_digest"`
+	Requests    int       `db:"requests"`
+	Errors      int       `db:"errors"`
+	LastUsedAt  time.Time `db:"last_used_at"`
+	LastErrorAt time.Time `db:"last_error_at"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// a TokenError is a request with a token answered with an error
+type TokenError struct {
+	Id        int       `db:"id"`
+	UsageId   int       `db:"usage_id"`
+	Method    string    `db:"method"`
+	Path      string    `db:"path"`
+	Status    int       `db:"status"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// a TokenActivity is the usage of a token with what the token is of, and its recent errors
+type TokenActivity struct {
+	Usage        *TokenUsage
+	App          *App
+	Device       *PairedDevice
+	Current      bool
+	RecentErrors []*TokenError
+}
+
+func (usage *TokenUsage) PreInsert(s gorp.SqlExecutor) error {
+	usage.CreatedAt = time.Now()
+	return nil
+}
+
+func (tokenError *TokenError) PreInsert(s gorp.SqlExecutor) error {
+	tokenError.CreatedAt = time.Now()
+	return nil
+}
+
+// TokenDigest identifies the token without storing it.
+func TokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// RecordTokenUsage counts the request with the token, and records it when it is answered with an error.
+func RecordTokenUsage(txn gorp.SqlExecutor, tokenKind string, tokenId int, digest string, method, path string, status int) error {
+	var usage TokenUsage
+	err := txn.SelectOne(&usage, "SELECT * FROM token_usage WHERE token_kind = ? AND token_id = ? AND token_digest = ?", tokenKind, tokenId, digest)
+	if err == sql.ErrNoRows {
+		usage = TokenUsage{TokenKind: tokenKind, TokenId: tokenId, TokenDigest: digest}
+		err = txn.Insert(&usage)
+	}
+	if err != nil {
+		return err
+	}
+	return usage.record(txn, method, path, status)
+}
+
+// RecordRevokedTokenUsage counts the request with the API token replaced by the refresh, as an error.
+// It returns false if the token has never been used.
+func RecordRevokedTokenUsage(txn gorp.SqlExecutor, digest string, method, path string, status int) (bool, error) {
+	var usages []*TokenUsage
+	_, err := txn.Select(&usages, "SELECT * FROM token_usage WHERE token_kind = ? AND token_digest = ? LIMIT 1", TokenKindApi, digest)
+	if err != nil {
+		return false, err
+	}
+	if len(usages) == 0 {
+		return false, nil
+	}
+	return true, usages[0].record(txn, method, path, status)
+}
+
+func (usage *TokenUsage) record(txn gorp.SqlExecutor, method, path string, status int) error {
+	now := time.Now()
+	// the counts are added in SQL not to lose the concurrent requests
+	if status < 400 {
+		_, err := txn.Exec("UPDATE token_usage SET requests = requests + 1, last_used_at = ? WHERE id = ?", now, usage.Id)
+		return err
+	}
+	_, err := txn.Exec("UPDATE token_usage SET requests = requests + 1, errors = errors + 1, last_used_at = ?, last_error_at = ? WHERE id = ?", now, now, usage.Id)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.Insert(&TokenError{UsageId: usage.Id, Method: method, Path: path, Status: status}); err != nil {
+		return err
+	}
+	_, err = txn.Exec(`DELETE FROM token_error WHERE usage_id = ? AND id NOT IN (
+			SELECT id FROM (SELECT id FROM token_error WHERE usage_id = ? ORDER BY id DESC LIMIT ?) AS kept
+		)`, usage.Id, usage.Id, TokenErrorsKept)
+	return err
+}
+
+func (usage *TokenUsage) RecentErrors(txn gorp.SqlExecutor) ([]*TokenError, error) {
+	var tokenErrors []*TokenError
+	_, err := txn.Select(&tokenErrors, "SELECT * FROM token_error WHERE usage_id = ? ORDER BY id DESC", usage.Id)
+	if err != nil {
+		return nil, err
+	}
+	return tokenErrors, nil
+}
+
+// TokenActivities returns the usages of the API tokens of the app, the current one first.
+func (app *App) TokenActivities(txn gorp.SqlExecutor) ([]*TokenActivity, error) {
+	var usages []*TokenUsage
+	_, err := txn.Select(&usages, "SELECT * FROM token_usage WHERE token_kind = ? AND token_id = ? ORDER BY last_used_at DESC", TokenKindApi, app.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var current, revoked []*TokenActivity
+	for _, usage := range usages {
+		activity, err := usage.activity(txn)
+		if err != nil {
+			return nil, err
+		}
+		if activity.Current {
+			current = append(current, activity)
+		} else {
+			revoked = append(revoked, activity)
+		}
+	}
+	return append(current, revoked...), nil
+}
+
+// GetTokenActivities returns the usages of all the tokens, the ones with the most requests first.
+func GetTokenActivities(txn gorp.SqlExecutor, limit int) ([]*TokenActivity, error) {
+	var usages []*TokenUsage
+	_, err := txn.Select(&usages, "SELECT * FROM token_usage ORDER BY requests DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	activities := []*TokenActivity{}
+	for _, usage := range usages {
+		activity, err := usage.activity(txn)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, activity)
+	}
+	return activities, nil
+}
+
+// activity tells what the token is of, and whether it is still the current one of the app or the device.
+// The ones of the admin and the mirror tokens are told by the caller, which knows the tokens.
+func (usage *TokenUsage) activity(txn gorp.SqlExecutor) (*TokenActivity, error) {
+	activity := &TokenActivity{Usage: usage}
+	var err error
+	switch usage.TokenKind {
+	case TokenKindApi:
+		activity.App, err = GetApp(txn, usage.TokenId)
+		if err == nil {
+			activity.Current = TokenDigest(activity.App.ApiToken) == usage.TokenDigest
+		}
+	case TokenKindDevice:
+		activity.Device, err = GetPairedDevice(txn, usage.TokenId)
+		activity.Current = err == nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	activity.RecentErrors, err = usage.RecentErrors(txn)
+	if err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+func (app *App) DeleteTokenUsages(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM token_error WHERE usage_id IN (SELECT id FROM token_usage WHERE token_kind = ? AND token_id = ?)", TokenKindApi, app.Id)
+	if err != nil {
+		return err
+	}
+	_, err = txn.Exec("DELETE FROM token_usage WHERE token_kind = ? AND token_id = ?", TokenKindApi, app.Id)
+	return err
+}
@@ -0,0 +1,288 @@
+package models
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+const (
+	SpanKindServer = 2
+	SpanKindClient = 3
+
+	TraceParentHeader = "traceparent"
+
+	spanStatusError     = 2
+	tracerBatchSize     = 100
+	tracerFlushInterval = 5 * time.Second
+	tracerQueueSize     = 1000
+)
+
+// a Tracer exports the spans to an OpenTelemetry collector with OTLP over HTTP in JSON.
+// https://opentelemetry.io/docs/specs/otlp/
+// The spans are queued and posted in batches, and dropped when the queue is full,
+// so that a slow collector does not slow the requests down.
+type Tracer struct {
+	Endpoint    string
+	ServiceName string
+	Client      *http.Client
+
+	queue chan *Span
+}
+
+// a Span is a timed operation of a trace. The methods of a nil Span do nothing,
+// so the callers need not to tell whether the tracing is enabled.
+type Span struct {
+	TraceId      string
+	SpanId       string
+	ParentSpanId string
+	Name         string
+	Kind         int
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Error        string
+
+	tracer *Tracer
+	mu     sync.Mutex
+}
+
+func NewTracer(endpoint, serviceName string) *Tracer {
+	tracer := &Tracer{
+		Endpoint:    strings.TrimSuffix(endpoint, "/"),
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *Span, tracerQueueSize),
+	}
+	go tracer.run()
+	return tracer
+}
+
+// StartSpan starts a span, as a child of the parent if any.
+func (tracer *Tracer) StartSpan(name string, kind int, parent *Span) *Span {
+	if tracer == nil {
+		return nil
+	}
+	span := &Span{
+		SpanId:     randomHex(8),
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: map[string]string{},
+		tracer:     tracer,
+	}
+	if parent != nil {
+		span.TraceId = parent.TraceId
+		span.ParentSpanId = parent.SpanId
+	} else {
+		span.TraceId = randomHex(16)
+	}
+	return span
+}
+
+// StartRemoteSpan starts a span continuing the trace of the W3C traceparent header, e.g. from a CI job.
+func (tracer *Tracer) StartRemoteSpan(name string, kind int, traceParent string) *Span {
+	span := tracer.StartSpan(name, kind, nil)
+	if span == nil {
+		return nil
+	}
+	// version-traceid-parentid-flags
+	parts := strings.Split(traceParent, "-")
+	if len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		span.TraceId = parts[1]
+		span.ParentSpanId = parts[2]
+	}
+	return span
+}
+
+func (span *Span) SetAttribute(key, value string) {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.Attributes[key] = value
+}
+
+func (span *Span) SetError(message string) {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.Error = message
+}
+
+func (span *Span) Finish() {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	span.End = time.Now()
+	span.mu.Unlock()
+
+	select {
+	case span.tracer.queue <- span:
+	default:
+	}
+}
+
+func (tracer *Tracer) run() {
+	ticker := time.NewTicker(tracerFlushInterval)
+	defer ticker.Stop()
+
+	var spans []*Span
+	for {
+		select {
+		case span := <-tracer.queue:
+			spans = append(spans, span)
+			if len(spans) < tracerBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(spans) == 0 {
+				continue
+			}
+		}
+		if err := tracer.export(spans); err != nil {
+			revel.ERROR.Printf("failed to export %d spans: %s", len(spans), err)
+		}
+		spans = nil
+	}
+}
+
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []*otlpKeyValue `json:"attributes"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func newOtlpKeyValue(key, value string) *otlpKeyValue {
+	kv := &otlpKeyValue{Key: key}
+	kv.Value.StringValue = value
+	return kv
+}
+
+func (tracer *Tracer) export(spans []*Span) error {
+	otlpSpans := []*otlpSpan{}
+	for _, span := range spans {
+		s := &otlpSpan{
+			TraceId:           span.TraceId,
+			SpanId:            span.SpanId,
+			ParentSpanId:      span.ParentSpanId,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        []*otlpKeyValue{},
+		}
+		for key, value := range span.Attributes {
+			s.Attributes = append(s.Attributes, newOtlpKeyValue(key, value))
+		}
+		if span.Error != "" {
+			s.Status = &otlpStatus{Code: spanStatusError, Message: span.Error}
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []*otlpKeyValue{newOtlpKeyValue("service.name", tracer.ServiceName)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "alphawing"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", tracer.Endpoint+"/v1/traces", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := tracer.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("got HTTP response code %d: %s", resp.StatusCode, string(b))
+	}
+	return nil
+}
+
+// a TracingTransport records a client span of each request, such as the Google Drive calls storing the bundles.
+type TracingTransport struct {
+	Base   http.RoundTripper
+	Parent *Span
+}
+
+func (t *TracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	span := t.Parent.tracer.StartSpan(fmt.Sprintf("%s %s", req.Method, req.URL.Host), SpanKindClient, t.Parent)
+	defer span.Finish()
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err.Error())
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetError(resp.Status)
+	}
+	return resp, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	UniversalApkBuildStateBuilding = "building"
+	UniversalApkBuildStateFailed   = "failed"
+)
+
+// a build failing, e.g. by the storage unavailable for a while, is retried as the one left by a server stopped,
+// until it has failed this many times
+const MaxUniversalApkBuildAttempts = 3
+
+// a UniversalApkBuild records the build of the universal APK of an Android App Bundle, which runs in the background
+// of the server the aab is uploaded to. The row is deleted once the apk is attached to the bundle.
+type UniversalApkBuild struct {
+	Id        int       `db:"id"`
+	BundleId  int       `db:"bundle_id"`
+	State     string    `db:"state"`
+	Attempts  int       `db:"attempts"`
+	Message   string    `db:"message"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+func (build *UniversalApkBuild) PreInsert(s gorp.SqlExecutor) error {
+	build.CreatedAt = time.Now()
+	build.UpdatedAt = build.CreatedAt
+	return nil
+}
+
+func (build *UniversalApkBuild) PreUpdate(s gorp.SqlExecutor) error {
+	build.UpdatedAt = time.Now()
+	return nil
+}
+
+func (build *UniversalApkBuild) Save(txn gorp.SqlExecutor) error {
+	return txn.Insert(build)
+}
+
+func (build *UniversalApkBuild) Update(txn gorp.SqlExecutor) error {
+	_, err := txn.Update(build)
+	return err
+}
+
+func (build *UniversalApkBuild) Delete(txn gorp.SqlExecutor) error {
+	_, err := txn.Delete(build)
+	return err
+}
+
+// Touch keeps the build claimed while the apk is built.
+func (build *UniversalApkBuild) Touch(txn gorp.SqlExecutor) error {
+	return touchUpload(txn, "universal_apk_build", build.Id, UniversalApkBuildStateBuilding, time.Now())
+}
+
+// Fail records the failure of an attempt. The build is left building to be retried once it is stale, or failed
+// after the last attempt.
+func (build *UniversalApkBuild) Fail(txn gorp.SqlExecutor, err error) error {
+	build.Attempts++
+	build.Message = err.Error()
+	if build.Attempts >= MaxUniversalApkBuildAttempts {
+		build.State = UniversalApkBuildStateFailed
+	}
+	return build.Update(txn)
+}
+
+func (bundle *Bundle) DeleteUniversalApkBuilds(txn gorp.SqlExecutor) error {
+	_, err := txn.Exec("DELETE FROM universal_apk_build WHERE bundle_id = ?", bundle.Id)
+	return err
+}
+
+// ClaimStaleUniversalApkBuilds takes the builds left by a server stopped or failed to be retried, to build them again.
+func ClaimStaleUniversalApkBuilds(txn gorp.SqlExecutor, now time.Time) ([]*UniversalApkBuild, error) {
+	ids, err := claimStaleUploads(txn, "universal_apk_build", UniversalApkBuildStateBuilding, now)
+	if err != nil {
+		return nil, err
+	}
+	var builds []*UniversalApkBuild
+	for _, id := range ids {
+		var build UniversalApkBuild
+		if err := txn.SelectOne(&build, "SELECT * FROM universal_apk_build WHERE id = ?", id); err != nil {
+			return nil, err
+		}
+		builds = append(builds, &build)
+	}
+	return builds, nil
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the uploads to the external services run in the background of the server which started them, touching their rows
+// as they go, so that the ones left by a server stopped, e.g. by a deploy, are told by their age and uploaded again
+const UploadClaimStale = 5 * time.Minute
+
+type staleUpload struct {
+	Id        int       `db:"id"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// claimStaleUploads takes the rows of the table left in the state by a server stopped, touching them so that the
+// other servers do not take them too, and returns their ids.
+func claimStaleUploads(txn gorp.SqlExecutor, table, state string, now time.Time) ([]int, error) {
+	var uploads []*staleUpload
+	query := fmt.Sprintf("SELECT id, updated_at FROM %s WHERE state = ? AND updated_at < ? ORDER BY id ASC", table)
+	if _, err := txn.Select(&uploads, query, state, now.Add(-UploadClaimStale)); err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, upload := range uploads {
+		result, err := txn.Exec(fmt.Sprintf("UPDATE %s SET updated_at = ? WHERE id = ? AND state = ? AND updated_at = ?", table), now, upload.Id, state, upload.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if affected == 1 {
+			ids = append(ids, upload.Id)
+		}
+	}
+	return ids, nil
+}
+
+// touchUpload keeps the row claimed while it is uploaded.
+func touchUpload(txn gorp.SqlExecutor, table string, id int, state string, now time.Time) error {
+	_, err := txn.Exec(fmt.Sprintf("UPDATE %s SET updated_at = ? WHERE id = ? AND state = ?", table), now, id, state)
+	return err
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.google.com/p/google-api-go-client/googleapi"
+)
+
+const (
+	UploadErrorBadZip             = "bad_zip"
+	UploadErrorParse              = "parse_error"
+	UploadErrorBadSignature       = "bad_signature"
+	UploadErrorSignerChanged      = "signer_changed"
+	UploadErrorQuotaExceeded      = "quota_exceeded"
+	UploadErrorAppQuotaExceeded   = "app_quota_exceeded"
+	UploadErrorStorageForbidden   = "storage_forbidden"
+	UploadErrorStorageNotFound    = "storage_not_found"
+	UploadErrorStorageUnavailable = "storage_unavailable"
+	UploadErrorInternal           = "internal_error"
+)
+
+// an UploadDiagnosis tells the uploader why the upload failed and what to do,
+// with the reference ID to find the error in the logs and the error reports.
+type UploadDiagnosis struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	Hint        string `json:"hint"`
+	ReferenceId string `json:"reference_id"`
+	Status      int    `json:"-"`
+}
+
+// DiagnoseUploadError makes the diagnosis of the error of CreateBundle.
+func DiagnoseUploadError(err error) *UploadDiagnosis {
+	diagnosis := diagnoseUploadError(err)
+	// the same length as the event ID of the error reports, so that the ID can be searched there
+	diagnosis.ReferenceId = randomHex(16)
+	return diagnosis
+}
+
+func diagnoseUploadError(err error) *UploadDiagnosis {
+	if bperr, ok := err.(*BundleParseError); ok {
+		if bperr.Err == zip.ErrFormat || bperr.Err == zip.ErrChecksum {
+			return &UploadDiagnosis{
+				Code:    UploadErrorBadZip,
+				Message: "The file is not a valid zip archive.",
+				Hint:    "The file may be truncated or corrupted. Build it again, or check that the whole file is uploaded.",
+				Status:  http.StatusBadRequest,
+			}
+		}
+		return &UploadDiagnosis{
+			Code:    UploadErrorParse,
+			Message: fmt.Sprintf("The application package cannot be parsed: %s", bperr.Err),
+			Hint:    "Check that the file is a signed apk, aab or ipa with AndroidManifest.xml or Info.plist which has the version.",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	if sigErr, ok := err.(*ApkSignatureError); ok {
+		return &UploadDiagnosis{
+			Code:    UploadErrorBadSignature,
+			Message: fmt.Sprintf("The apk is not signed properly: %s", sigErr.Err),
+			Hint:    "The devices refuse to install it. Sign it again with apksigner, after any change to the file, e.g. by zipalign.",
+			Status:  http.StatusUnprocessableEntity,
+		}
+	}
+	if changedErr, ok := err.(*SignerChangedError); ok {
+		return &UploadDiagnosis{
+			Code:    UploadErrorSignerChanged,
+			Message: fmt.Sprintf("The apk is signed with another certificate than revision %d of %s.", changedErr.Previous.Revision, changedErr.Previous.BundleVersion),
+			Hint:    "The testers cannot update the installed app with it. Sign it with the key of the previous apks, or ask the developers to allow the change in the settings of the project.",
+			Status:  http.StatusConflict,
+		}
+	}
+
+	if quotaErr, ok := err.(*AppQuotaError); ok {
+		return &UploadDiagnosis{
+			Code:    UploadErrorAppQuotaExceeded,
+			Message: fmt.Sprintf("The project uses %.1f MB of its storage quota of %d MB, and the file of %.1f MB exceeds it.", quotaErr.Usage.UsedMb(), quotaErr.Usage.QuotaBytes/1024/1024, float64(quotaErr.Size)/1024/1024),
+			Hint:    "Delete old bundles of the project, or ask the admins to raise the quota.",
+			Status:  http.StatusInsufficientStorage,
+		}
+	}
+
+	code, message, parseErr := ParseGoogleApiError(err)
+	if parseErr == nil && code != 0 {
+		body := message
+		if googleErr, ok := err.(*googleapi.Error); ok {
+			body += googleErr.Body
+		}
+		switch {
+		case strings.Contains(body, "QuotaExceeded") || strings.Contains(body, "quotaExceeded"):
+			return &UploadDiagnosis{
+				Code:    UploadErrorQuotaExceeded,
+				Message: "The storage of alphawing is full.",
+				Hint:    "Delete old bundles of the project, or ask the admins to free up the Google Drive of the service account.",
+				Status:  http.StatusInsufficientStorage,
+			}
+		case code == http.StatusForbidden || code == http.StatusUnauthorized:
+			return &UploadDiagnosis{
+				Code:    UploadErrorStorageForbidden,
+				Message: fmt.Sprintf("Google Drive refused to store the file: %s", message),
+				Hint:    "The service account may have lost the access to the folder of the project. Ask the admins with the reference ID.",
+				Status:  http.StatusBadGateway,
+			}
+		case code == http.StatusNotFound:
+			return &UploadDiagnosis{
+				Code:    UploadErrorStorageNotFound,
+				Message: "The folder of the project is not found on Google Drive.",
+				Hint:    "The folder may have been deleted on Google Drive. Ask the admins with the reference ID.",
+				Status:  http.StatusBadGateway,
+			}
+		case code >= 500 || code == http.StatusTooManyRequests:
+			return &UploadDiagnosis{
+				Code:    UploadErrorStorageUnavailable,
+				Message: fmt.Sprintf("Google Drive is not available now: %s", message),
+				Hint:    "Retry the upload in a few minutes.",
+				Status:  http.StatusServiceUnavailable,
+			}
+		}
+	}
+
+	return &UploadDiagnosis{
+		Code:    UploadErrorInternal,
+		Message: "The upload failed unexpectedly.",
+		Hint:    "Ask the admins with the reference ID.",
+		Status:  http.StatusInternalServerError,
+	}
+}
+
+func (diagnosis *UploadDiagnosis) String() string {
+	return fmt.Sprintf("%s %s (reference ID: %s)", diagnosis.Message, diagnosis.Hint, diagnosis.ReferenceId)
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// the bundles of a manifest are stored one by one, so the number is limited to keep the request in time
+const UploadManifestMaxArtifacts = 20
+
+// an UploadManifest describes the bundles built together, e.g. by the pipeline of a monorepo, to upload them to their apps at once.
+type UploadManifest struct {
+	Artifacts []*ManifestArtifact `json:"artifacts"`
+}
+
+// a ManifestArtifact is one of the files uploaded with the manifest, with the API token of its app.
+type ManifestArtifact struct {
+	Token       string   `json:"token"`
+	File        string   `json:"file"`
+	Provenance  string   `json:"provenance"`
+	Description string   `json:"description"`
+	KnownIssues string   `json:"known_issues"`
+	Channel     string   `json:"channel"`
+	Tags        []string `json:"tags"`
+	Variant     string   `json:"variant"`
+	Branch      string   `json:"branch"`
+	Commit      string   `json:"commit"`
+	CiJobUrl    string   `json:"ci_job_url"`
+	// the version of a generic artifact, which is not read from its file
+	Version      string `json:"version"`
+	ShortVersion string `json:"short_version"`
+	Identifier   string `json:"identifier"`
+	// the descriptions in the other languages, e.g. {"en": "..."}
+	Descriptions map[string]string `json:"descriptions"`
+}
+
+type ManifestArtifactJsonResponse struct {
+	AppId int    `json:"app_id"`
+	File  string `json:"file"`
+	*BundleJsonResponse
+}
+
+// ParseUploadManifest reads the manifest in JSON, and tells the first artifact without the token or the file.
+func ParseUploadManifest(manifest string) (*UploadManifest, error) {
+	var m UploadManifest
+	if err := json.Unmarshal([]byte(manifest), &m); err != nil {
+		return nil, fmt.Errorf("manifest is invalid: %s", err)
+	}
+	if len(m.Artifacts) == 0 {
+		return nil, fmt.Errorf("manifest has no artifacts")
+	}
+	if len(m.Artifacts) > UploadManifestMaxArtifacts {
+		return nil, fmt.Errorf("manifest has more than %d artifacts", UploadManifestMaxArtifacts)
+	}
+
+	files := map[string]bool{}
+	for i, artifact := range m.Artifacts {
+		if artifact.Token == "" {
+			return nil, fmt.Errorf("artifacts[%d].token is required", i)
+		}
+		if artifact.File == "" {
+			return nil, fmt.Errorf("artifacts[%d].file is required", i)
+		}
+		if err := ValidCiJobUrl(artifact.CiJobUrl); err != nil {
+			return nil, fmt.Errorf("artifacts[%d].%s", i, err)
+		}
+		if files[artifact.File] {
+			return nil, fmt.Errorf("artifacts[%d].file %s is listed twice", i, artifact.File)
+		}
+		files[artifact.File] = true
+	}
+	return &m, nil
+}
+
+// Bundle returns the bundle to create for the artifact.
+func (artifact *ManifestArtifact) Bundle(platformType BundlePlatformType) *Bundle {
+	return &Bundle{
+		PlatformType: platformType,
+		Description:  artifact.Description,
+		Channel:      artifact.Channel,
+		Tags:         strings.Join(artifact.Tags, ","),
+		Variant:      artifact.Variant,
+		Branch:       artifact.Branch,
+		Commit:       artifact.Commit,
+		CiJobUrl:     artifact.CiJobUrl,
+
+		BundleVersion:    artifact.Version,
+		ShortVersion:     artifact.ShortVersion,
+		BundleIdentifier: artifact.Identifier,
+	}
+}
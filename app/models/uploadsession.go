@@ -0,0 +1,204 @@
+package models
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the chunks of an upload are expected to be sent in a day, retries included
+const UploadSessionLifetime = 24 * time.Hour
+
+var (
+	ErrUploadSessionOffset     = errors.New("Offset does not match the size uploaded.")
+	ErrUploadSessionTooLarge   = errors.New("Chunk exceeds the size of the upload.")
+	ErrUploadSessionIncomplete = errors.New("Upload is incomplete.")
+)
+
+// an UploadSession is a bundle file uploaded in chunks, e.g. over the flaky network of CI, which is assembled
+// in a file of the server and only then created as a bundle. The client resumes it from the offset after a failure.
+type UploadSession struct {
+	Id        int       `db:"id"`
+	AppId     int       `db:"app_id"`
+	Filename  string    `db:"filename"`
+	Size      int64     `db:"size"`
+	Offset    int64     `db:"uploaded_size"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+type UploadSessionJsonResponse struct {
+	UploadId  int       `json:"upload_id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// read from the assembled file, to be confirmed before the commit
+	Info      *UploadSessionInfoJsonResponse `json:"info,omitempty"`
+	InfoError string                         `json:"info_error,omitempty"`
+}
+
+type UploadSessionInfoJsonResponse struct {
+	PlatformType    string `json:"platform_type"`
+	PlatformSubtype string `json:"platform_subtype,omitempty"`
+	Identifier      string `json:"identifier"`
+	Version         string `json:"version"`
+	ShortVersion    string `json:"short_version,omitempty"`
+	AppBundle       bool   `json:"app_bundle,omitempty"`
+}
+
+func (session *UploadSession) PreInsert(s gorp.SqlExecutor) error {
+	session.CreatedAt = time.Now()
+	session.UpdatedAt = session.CreatedAt
+	return nil
+}
+
+func (session *UploadSession) PreUpdate(s gorp.SqlExecutor) error {
+	session.UpdatedAt = time.Now()
+	return nil
+}
+
+func (session *UploadSession) JsonResponse() *UploadSessionJsonResponse {
+	return &UploadSessionJsonResponse{
+		UploadId:  session.Id,
+		Filename:  session.Filename,
+		Size:      session.Size,
+		Offset:    session.Offset,
+		ExpiresAt: session.CreatedAt.Add(UploadSessionLifetime),
+	}
+}
+
+// JsonResponseWithInfo adds the info of the assembled file, once the upload is completed.
+func (session *UploadSession) JsonResponseWithInfo(dir string) *UploadSessionJsonResponse {
+	response := session.JsonResponse()
+	if !session.Completed() {
+		return response
+	}
+	info, err := session.ReadInfo(dir)
+	if err != nil {
+		response.InfoError = err.Error()
+		return response
+	}
+	response.Info = &UploadSessionInfoJsonResponse{
+		PlatformType:    info.PlatformType.String(),
+		PlatformSubtype: info.PlatformSubtype.String(),
+		Identifier:      info.Identifier,
+		Version:         info.Version,
+		ShortVersion:    info.ShortVersion,
+		AppBundle:       info.AppBundle,
+	}
+	return response
+}
+
+func (session *UploadSession) Completed() bool {
+	return session.Offset == session.Size
+}
+
+// Path returns the file of the chunks in the directory.
+func (session *UploadSession) Path(dir string) string {
+	return filepath.Join(dir, "upload_"+strconv.Itoa(session.Id))
+}
+
+// Append writes the chunk at the offset, which must be the size uploaded so far, so that a chunk sent again
+// after a lost response is refused instead of written twice. A chunk broken off is truncated by the next one.
+func (session *UploadSession) Append(txn gorp.SqlExecutor, dir string, r io.Reader, offset, length int64) error {
+	if offset != session.Offset {
+		return ErrUploadSessionOffset
+	}
+	if offset+length > session.Size {
+		return ErrUploadSessionTooLarge
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(session.Path(dir), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := file.Seek(offset, os.SEEK_SET); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(file, r, length); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	// another request may have appended the same offset meanwhile
+	result, err := txn.Exec("UPDATE upload_session SET uploaded_size = ?, updated_at = ? WHERE id = ? AND uploaded_size = ?", offset+length, time.Now(), session.Id, offset)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUploadSessionOffset
+	}
+	session.Offset = offset + length
+	return nil
+}
+
+// ReadInfo parses the assembled file as the creation of the bundle does.
+func (session *UploadSession) ReadInfo(dir string) (*BundleInfo, error) {
+	file, err := session.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return NewBundleInfo(file, BundleFileExtensionOf(session.Filename).PlatformType())
+}
+
+// Open opens the assembled file to create the bundle of, to be closed by the caller.
+func (session *UploadSession) Open(dir string) (*os.File, error) {
+	if !session.Completed() {
+		return nil, ErrUploadSessionIncomplete
+	}
+	return os.Open(session.Path(dir))
+}
+
+// Delete removes the chunks and the session.
+func (session *UploadSession) Delete(txn gorp.SqlExecutor, dir string) error {
+	if err := os.Remove(session.Path(dir)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err := txn.Delete(session)
+	return err
+}
+
+// GetUploadSession returns the session of the app, which is not found for another app.
+func GetUploadSession(txn gorp.SqlExecutor, app *App, id int) (*UploadSession, error) {
+	var session UploadSession
+	err := txn.SelectOne(&session, "SELECT * FROM upload_session WHERE id = ? AND app_id = ? AND created_at >= ?", id, app.Id, time.Now().Add(-UploadSessionLifetime))
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// PurgeUploadSessions removes the sessions abandoned before the time with their chunks.
+func PurgeUploadSessions(txn gorp.SqlExecutor, dir string, before time.Time) (int, error) {
+	var sessions []*UploadSession
+	_, err := txn.Select(&sessions, "SELECT * FROM upload_session WHERE created_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	for i, session := range sessions {
+		if err := session.Delete(txn, dir); err != nil {
+			return i, err
+		}
+	}
+	return len(sessions), nil
+}
@@ -9,10 +9,13 @@ import (
 )
 
 type User struct {
-	Id        int       `db:"id"`
-	Email     string    `db:"email"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	Id            int       `db:"id"`
+	Email         string    `db:"email"`
+	Deactivated   bool      `db:"deactivated"`
+	Language      string    `db:"language"`
+	DeactivatedAt time.Time `db:"deactivated_at"`
+	CreatedAt     time.Time `db:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at"`
 }
 
 func (user *User) PreInsert(s gorp.SqlExecutor) error {
@@ -0,0 +1,240 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// a UserDataExport is every personal data of a user stored in alphawing
+type UserDataExport struct {
+	Email          string                     `json:"email"`
+	CreatedAt      string                     `json:"created_at"`
+	Language       string                     `json:"language"`
+	Authorities    []*UserAuthorityExport     `json:"authorities"`
+	AccessRequests []*UserAccessRequestExport `json:"access_requests"`
+	PairedDevices  []*UserPairedDeviceExport  `json:"paired_devices"`
+	Audits         []*UserAuditExport         `json:"audits"`
+}
+
+type UserAuthorityExport struct {
+	AppId     int    `json:"app_id"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
+
+type UserAccessRequestExport struct {
+	AppId     int    `json:"app_id"`
+	State     string `json:"state"`
+	CreatedAt string `json:"created_at"`
+}
+
+type UserPairedDeviceExport struct {
+	Name       string `json:"name"`
+	LastUsedAt string `json:"last_used_at"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type UserAuditExport struct {
+	Resource   int    `json:"resource"`
+	ResourceId int    `json:"resource_id"`
+	Action     int    `json:"action"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// a UserErasureReport tells what was removed by EraseUserData
+type UserErasureReport struct {
+	Email             string `json:"email"`
+	Authorities       int    `json:"authorities"`
+	AccessRequests    int    `json:"access_requests"`
+	PairedDevices     int    `json:"paired_devices"`
+	AnonymizedAudits  int    `json:"anonymized_audits"`
+	AnonymizedBundles int    `json:"anonymized_bundles"`
+	UserAccountErased bool   `json:"user_account_erased"`
+	// the apps the user was an owner of, which are succeeded if no owner is left
+	OwnedApps     []int `json:"owned_apps"`
+	SucceededApps []int `json:"succeeded_apps"`
+}
+
+func (user *User) Audits(txn gorp.SqlExecutor) ([]*Audit, error) {
+	var audits []*Audit
+	_, err := txn.Select(&audits, "SELECT * FROM audit WHERE user_id = ? ORDER BY id ASC", user.Id)
+	if err != nil {
+		return nil, err
+	}
+	return audits, nil
+}
+
+func (user *User) Authorities(txn gorp.SqlExecutor) ([]*Authority, error) {
+	var authorities []*Authority
+	_, err := txn.Select(&authorities, "SELECT * FROM authority WHERE email = ? ORDER BY id ASC", user.Email)
+	if err != nil {
+		return nil, err
+	}
+	return authorities, nil
+}
+
+func (user *User) AccessRequests(txn gorp.SqlExecutor) ([]*AccessRequest, error) {
+	var requests []*AccessRequest
+	_, err := txn.Select(&requests, "SELECT * FROM access_request WHERE user_id = ? OR email = ? ORDER BY id ASC", user.Id, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func ExportUserData(txn gorp.SqlExecutor, user *User) (*UserDataExport, error) {
+	export := &UserDataExport{
+		Email:          user.Email,
+		CreatedAt:      user.CreatedAt.Format(time.RFC3339),
+		Language:       user.Language,
+		Authorities:    []*UserAuthorityExport{},
+		AccessRequests: []*UserAccessRequestExport{},
+		PairedDevices:  []*UserPairedDeviceExport{},
+		Audits:         []*UserAuditExport{},
+	}
+
+	authorities, err := user.Authorities(txn)
+	if err != nil {
+		return nil, err
+	}
+	for _, authority := range authorities {
+		export.Authorities = append(export.Authorities, &UserAuthorityExport{
+			AppId:     authority.AppId,
+			Role:      authority.Role.String(),
+			CreatedAt: authority.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	requests, err := user.AccessRequests(txn)
+	if err != nil {
+		return nil, err
+	}
+	for _, request := range requests {
+		export.AccessRequests = append(export.AccessRequests, &UserAccessRequestExport{
+			AppId:     request.AppId,
+			State:     request.State,
+			CreatedAt: request.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	devices, err := GetPairedDevicesByUser(txn, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range devices {
+		export.PairedDevices = append(export.PairedDevices, &UserPairedDeviceExport{
+			Name:       device.Name,
+			LastUsedAt: device.LastUsedAt.Format(time.RFC3339),
+			CreatedAt:  device.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	audits, err := user.Audits(txn)
+	if err != nil {
+		return nil, err
+	}
+	for _, audit := range audits {
+		export.Audits = append(export.Audits, &UserAuditExport{
+			Resource:   audit.Resource,
+			ResourceId: audit.ResourceId,
+			Action:     audit.Action,
+			CreatedAt:  audit.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return export, nil
+}
+
+// EraseUserData removes the user along with the authorities, the requests and the devices,
+// and keeps the audits and the bundles without the user so that the history of the apps stays consistent.
+func EraseUserData(txn gorp.SqlExecutor, s *GoogleService, user *User) (*UserErasureReport, error) {
+	report := &UserErasureReport{Email: user.Email, OwnedApps: []int{}, SucceededApps: []int{}}
+
+	authorities, err := user.Authorities(txn)
+	if err != nil {
+		return nil, err
+	}
+	for _, authority := range authorities {
+		app, err := GetApp(txn, authority.AppId)
+		if err != nil {
+			return nil, err
+		}
+		if authority.Role == AuthorityRoleAdmin {
+			report.OwnedApps = append(report.OwnedApps, app.Id)
+		}
+		if err := app.DeleteAuthority(txn, s, authority); err != nil {
+			code, _, _ := ParseGoogleApiError(err)
+			if code != http.StatusNotFound {
+				return nil, err
+			}
+		}
+	}
+	report.Authorities = len(authorities)
+
+	result, err := txn.Exec("DELETE FROM access_request WHERE user_id = ? OR email = ?", user.Id, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	report.AccessRequests = int(affected)
+
+	result, err = txn.Exec("DELETE FROM paired_device WHERE user_id = ?", user.Id)
+	if err != nil {
+		return nil, err
+	}
+	affected, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	report.PairedDevices = int(affected)
+
+	result, err = txn.Exec("UPDATE audit SET user_id = 0, device_id = 0 WHERE user_id = ?", user.Id)
+	if err != nil {
+		return nil, err
+	}
+	affected, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	report.AnonymizedAudits = int(affected)
+
+	// the bundles are kept as uploaded by a member without the email
+	result, err = txn.Exec("UPDATE bundle SET uploaded_by = ? WHERE uploaded_by = ?", UserUploader(&User{}), UserUploader(user))
+	if err != nil {
+		return nil, err
+	}
+	affected, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	report.AnonymizedBundles = int(affected)
+
+	if _, err := txn.Exec("UPDATE app_doc_revision SET user_id = 0 WHERE user_id = ?", user.Id); err != nil {
+		return nil, err
+	}
+
+	if _, err := txn.Exec("UPDATE release_plan SET user_id = 0 WHERE user_id = ?", user.Id); err != nil {
+		return nil, err
+	}
+
+	if _, err := txn.Exec("UPDATE env_file SET user_id = 0 WHERE user_id = ?", user.Id); err != nil {
+		return nil, err
+	}
+
+	if _, err := txn.Exec("DELETE FROM bandwidth_usage WHERE subject = ?", fmt.Sprintf("user:%d", user.Id)); err != nil {
+		return nil, err
+	}
+
+	if err := user.Delete(txn); err != nil {
+		return nil, err
+	}
+	report.UserAccountErased = true
+
+	return report, nil
+}
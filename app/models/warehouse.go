@@ -0,0 +1,366 @@
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+	"github.com/coopernurse/gorp"
+)
+
+const (
+	BigQueryScope   = "https://www.googleapis.com/auth/bigquery.insertdata"
+	BigQueryBaseUrl = "https://bigquery.googleapis.com/bigquery/v2"
+
+	WarehouseTableInstalls = "installs"
+	WarehouseTableUploads  = "uploads"
+	WarehouseTableAudits   = "audits"
+
+	warehouseInsertBatchSize = 500
+)
+
+// a WarehouseExporter ships the rows of a table in a day to a data warehouse, partitioned by the day.
+// Exporting the same day again must not duplicate the rows.
+type WarehouseExporter interface {
+	Export(table string, date time.Time, rows []interface{}) error
+}
+
+type WarehouseInstallRow struct {
+	AuditId   int    `json:"audit_id"`
+	AppId     int    `json:"app_id"`
+	BundleId  int    `json:"bundle_id"`
+	UserId    int    `json:"user_id"`
+	Method    string `json:"method"`
+	CreatedAt string `json:"created_at"`
+}
+
+type WarehouseUploadRow struct {
+	AuditId   int    `json:"audit_id"`
+	AppId     int    `json:"app_id"`
+	BundleId  int    `json:"bundle_id"`
+	UserId    int    `json:"user_id"`
+	CreatedAt string `json:"created_at"`
+}
+
+type WarehouseAuditRow struct {
+	Id         int    `json:"id"`
+	UserId     int    `json:"user_id"`
+	Resource   int    `json:"resource"`
+	ResourceId int    `json:"resource_id"`
+	Action     int    `json:"action"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// ExportWarehouse exports the installs, the uploads and the audits of the day.
+// It has to run before the audits of the day are purged.
+func ExportWarehouse(dbm *gorp.DbMap, exporter WarehouseExporter, date time.Time) error {
+	from := startOfDay(date)
+	to := from.AddDate(0, 0, 1)
+
+	var audits []*Audit
+	_, err := dbm.Select(&audits, "SELECT * FROM audit WHERE created_at >= ? AND created_at < ? ORDER BY id ASC", from, to)
+	if err != nil {
+		return err
+	}
+
+	var bundles []*Bundle
+	if _, err := dbm.Select(&bundles, "SELECT id, app_id FROM bundle"); err != nil {
+		return err
+	}
+	appIds := map[int]int{}
+	for _, bundle := range bundles {
+		appIds[bundle.Id] = bundle.AppId
+	}
+
+	installs := []interface{}{}
+	uploads := []interface{}{}
+	rows := []interface{}{}
+	for _, audit := range audits {
+		createdAt := audit.CreatedAt.Format(time.RFC3339)
+		rows = append(rows, &WarehouseAuditRow{
+			Id:         audit.Id,
+			UserId:     audit.UserId,
+			Resource:   audit.Resource,
+			ResourceId: audit.ResourceId,
+			Action:     audit.Action,
+			CreatedAt:  createdAt,
+		})
+
+		if audit.Resource != ResourceBundle {
+			continue
+		}
+		// the app of a deleted bundle is left 0
+		appId := appIds[audit.ResourceId]
+		switch audit.Action {
+		case ActionDownload, ActionPushInstall:
+			method := "download"
+			if audit.Action == ActionPushInstall {
+				method = "push_install"
+			}
+			installs = append(installs, &WarehouseInstallRow{
+				AuditId:   audit.Id,
+				AppId:     appId,
+				BundleId:  audit.ResourceId,
+				UserId:    audit.UserId,
+				Method:    method,
+				CreatedAt: createdAt,
+			})
+		case ActionCreate:
+			uploads = append(uploads, &WarehouseUploadRow{
+				AuditId:   audit.Id,
+				AppId:     appId,
+				BundleId:  audit.ResourceId,
+				UserId:    audit.UserId,
+				CreatedAt: createdAt,
+			})
+		}
+	}
+
+	if err := exporter.Export(WarehouseTableInstalls, from, installs); err != nil {
+		return err
+	}
+	if err := exporter.Export(WarehouseTableUploads, from, uploads); err != nil {
+		return err
+	}
+	return exporter.Export(WarehouseTableAudits, from, rows)
+}
+
+type WarehouseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *WarehouseError) Error() string {
+	return fmt.Sprintf("warehouse: got HTTP response code %d: %s", e.StatusCode, e.Body)
+}
+
+// BigQueryExporter streams the rows into the day partitions of the tables in the dataset.
+// The tables have to be created in advance, partitioned by the ingestion time.
+// https://cloud.google.com/bigquery/docs/reference/rest/v2/tabledata/insertAll
+type BigQueryExporter struct {
+	ProjectId string
+	DatasetId string
+	BaseUrl   string
+	Client    *http.Client
+}
+
+func NewBigQueryExporter(token *oauth.Token, projectId, datasetId string) *BigQueryExporter {
+	return &BigQueryExporter{
+		ProjectId: projectId,
+		DatasetId: datasetId,
+		BaseUrl:   BigQueryBaseUrl,
+		Client:    createOAuthClient(token),
+	}
+}
+
+func (e *BigQueryExporter) Export(table string, date time.Time, rows []interface{}) error {
+	url := fmt.Sprintf(
+		"%s/projects/%s/datasets/%s/tables/%s$%s/insertAll",
+		e.BaseUrl, e.ProjectId, e.DatasetId, table, date.Format("20060102"),
+	)
+
+	for start := 0; start < len(rows); start += warehouseInsertBatchSize {
+		end := start + warehouseInsertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		type insertRow struct {
+			InsertId string      `json:"insertId"`
+			Json     interface{} `json:"json"`
+		}
+		body := struct {
+			Rows []*insertRow `json:"rows"`
+		}{}
+		for i, row := range rows[start:end] {
+			// BigQuery drops the rows with the same insertId, so that a retry does not duplicate them
+			body.Rows = append(body.Rows, &insertRow{
+				InsertId: fmt.Sprintf("%s-%s-%d", table, date.Format("20060102"), start+i),
+				Json:     row,
+			})
+		}
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		var result struct {
+			InsertErrors []struct {
+				Index  int `json:"index"`
+				Errors []struct {
+					Message string `json:"message"`
+				} `json:"errors"`
+			} `json:"insertErrors"`
+		}
+		if err := doWarehouseRequest(e.Client, req, &result); err != nil {
+			return err
+		}
+		if len(result.InsertErrors) > 0 {
+			insertError := result.InsertErrors[0]
+			message := ""
+			if len(insertError.Errors) > 0 {
+				message = insertError.Errors[0].Message
+			}
+			return fmt.Errorf("bigquery: failed to insert %d rows into %s: %s", len(result.InsertErrors), table, message)
+		}
+	}
+	return nil
+}
+
+// S3Exporter puts the rows as a newline delimited JSON file per table and day,
+// at <prefix>/<table>/dt=<date>/part-0.json, to be read as a Hive partitioned data lake.
+// Exporting the day again replaces the file.
+type S3Exporter struct {
+	Bucket          string
+	Region          string
+	Prefix          string
+	AccessKeyId     string
+	SecretAccessKey string
+	Endpoint        string
+	Client          *http.Client
+}
+
+func NewS3Exporter(bucket, region, prefix, accessKeyId, secretAccessKey string) *S3Exporter {
+	return &S3Exporter{
+		Bucket:          bucket,
+		Region:          region,
+		Prefix:          strings.Trim(prefix, "/"),
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secretAccessKey,
+		Endpoint:        fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region),
+		Client:          http.DefaultClient,
+	}
+}
+
+func (e *S3Exporter) Export(table string, date time.Time, rows []interface{}) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, row := range rows {
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	key := fmt.Sprintf("%s/dt=%s/part-0.json", table, date.Format(AuditRollupDateFormat))
+	if e.Prefix != "" {
+		key = e.Prefix + "/" + key
+	}
+
+	req, err := http.NewRequest("PUT", e.Endpoint+"/"+key, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	e.sign(req, buf.Bytes(), time.Now().UTC())
+
+	return doWarehouseRequest(e.Client, req, nil)
+}
+
+// sign adds the AWS Signature Version 4 of the request.
+func (e *S3Exporter) sign(req *http.Request, body []byte, now time.Time) {
+	signAwsRequest(req, sha256Hex(body), e.Region, e.AccessKeyId, e.SecretAccessKey, now)
+}
+
+// signAwsRequest adds the AWS Signature Version 4 of the S3 request, signing the host, the content type and the x-amz-* headers.
+// The payload hash is UNSIGNED-PAYLOAD for a body which is streamed without reading it in advance.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signAwsRequest(req *http.Request, payloadHash, region, accessKeyId, secretAccessKey string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", day, region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+secretAccessKey), day)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyId, scope, signedHeaders, signature,
+	))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func doWarehouseRequest(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return &WarehouseError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
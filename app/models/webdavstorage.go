@@ -0,0 +1,247 @@
+package models
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// the properties of the files to list, as a PROPFIND without a body returns all of them
+const webDavPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/></prop></propfind>`
+
+// WebDavStorage keeps the files on a WebDAV server, for the enterprises which only allow the artifacts on their internal servers.
+// The keys are the location and the file name relative to the base URL, e.g. app_1/1.0/app_1_ver_1.0_rev_1.apk, as the ones of S3.
+type WebDavStorage struct {
+	BaseUrl  string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+type webDavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength int64  `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+func NewWebDavStorage(baseUrl, username, password string) *WebDavStorage {
+	return &WebDavStorage{
+		BaseUrl:  strings.TrimRight(baseUrl, "/"),
+		Username: username,
+		Password: password,
+		Client:   http.DefaultClient,
+	}
+}
+
+func (st *WebDavStorage) Put(file *os.File, name, location string) (string, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		return "", err
+	}
+	return st.PutStream(file, stat.Size(), name, location)
+}
+
+// PutStream creates the collections of the location before it puts the reader, as WebDAV does not create the parents.
+func (st *WebDavStorage) PutStream(r io.Reader, size int64, name, location string) (string, error) {
+	key := name
+	if location != "" {
+		key = strings.Trim(location, "/") + "/" + key
+		if err := st.mkcol(strings.Trim(location, "/")); err != nil {
+			return "", err
+		}
+	}
+
+	req, err := http.NewRequest("PUT", st.url(key), ioutil.NopCloser(r))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := st.do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return key, nil
+}
+
+func (st *WebDavStorage) Get(key string) (*StorageObject, error) {
+	req, err := http.NewRequest("GET", st.url(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := st.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		modTime = time.Now()
+	}
+	return &StorageObject{
+		Body:    resp.Body,
+		Name:    path.Base(key),
+		Size:    resp.ContentLength,
+		ModTime: modTime,
+	}, nil
+}
+
+func (st *WebDavStorage) Delete(key string) error {
+	req, err := http.NewRequest("DELETE", st.url(key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := st.do(req)
+	if err != nil {
+		if storageErr, ok := err.(*StorageError); ok && storageErr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SignedURL returns "", as the files on the internal server are served by the server with the credentials.
+func (st *WebDavStorage) SignedURL(key string, expiry time.Duration) (string, error) {
+	return "", nil
+}
+
+// List walks the collections under the base URL one level at a time, as many servers refuse the PROPFIND of Depth: infinity.
+func (st *WebDavStorage) List(fn func(entry *StorageEntry) error) error {
+	err := st.list("", fn)
+	// nothing is put yet
+	if storageErr, ok := err.(*StorageError); ok && storageErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+func (st *WebDavStorage) list(dir string, fn func(entry *StorageEntry) error) error {
+	req, err := http.NewRequest("PROPFIND", st.url(dir)+"/", strings.NewReader(webDavPropfind))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	resp, err := st.do(req)
+	if err != nil {
+		return err
+	}
+	var result webDavMultistatus
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, response := range result.Responses {
+		key, err := st.keyOfHref(response.Href)
+		if err != nil {
+			return err
+		}
+		// the collection itself is listed first
+		if key == dir {
+			continue
+		}
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+			if prop.ResourceType.Collection != nil {
+				if err := st.list(key, fn); err != nil {
+					return err
+				}
+				continue
+			}
+			modTime, err := http.ParseTime(prop.LastModified)
+			if err != nil {
+				modTime = time.Now()
+			}
+			if err := fn(&StorageEntry{Key: key, Size: prop.ContentLength, ModTime: modTime}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mkcol creates the collection and its parents. The ones already created are answered with 405.
+func (st *WebDavStorage) mkcol(dir string) error {
+	segments := strings.Split(dir, "/")
+	for i := range segments {
+		req, err := http.NewRequest("MKCOL", st.url(strings.Join(segments[:i+1], "/"))+"/", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := st.do(req)
+		if err != nil {
+			if storageErr, ok := err.(*StorageError); ok && storageErr.StatusCode == http.StatusMethodNotAllowed {
+				continue
+			}
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// url returns the URL of the key, escaping each segment of it.
+func (st *WebDavStorage) url(key string) string {
+	if key == "" {
+		return st.BaseUrl
+	}
+	return st.BaseUrl + (&url.URL{Path: "/" + key}).EscapedPath()
+}
+
+// keyOfHref returns the key of the href of the PROPFIND, which is a path or a URL of the server.
+func (st *WebDavStorage) keyOfHref(href string) (string, error) {
+	hrefUrl, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	baseUrl, err := url.Parse(st.BaseUrl)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(strings.TrimPrefix(hrefUrl.Path, baseUrl.Path), "/"), nil
+}
+
+// do returns the response to be closed by the caller, or the error with the body of the failed request.
+func (st *WebDavStorage) do(req *http.Request) (*http.Response, error) {
+	if st.Username != "" {
+		req.SetBasicAuth(st.Username, st.Password)
+	}
+	resp, err := st.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, &StorageError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
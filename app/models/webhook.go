@@ -0,0 +1,62 @@
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+const (
+	WebhookEventHeader     = "X-Alphawing-Event"
+	WebhookSignatureHeader = "X-Alphawing-Webhook-Signature"
+
+	WebhookEventBundleAnalyzed = "bundle.analyzed"
+)
+
+// a Webhook posts the events as JSON to the URL of the external systems, e.g. the policy bots which check the builds.
+// The body is signed with the HMAC-SHA256 by the secret as `sha256=<hex>`, as GitHub signs its webhooks.
+type Webhook struct {
+	Url    string
+	Secret string
+	Client *http.Client
+}
+
+type BundleAnalyzedPayload struct {
+	Event       string                      `json:"event"`
+	AppId       int                         `json:"app_id"`
+	BundleId    int                         `json:"bundle_id"`
+	Bundle      *BundleJsonResponse         `json:"bundle"`
+	Analysis    *BundleAnalysisJsonResponse `json:"analysis"`
+	DeliveredAt string                      `json:"delivered_at"`
+}
+
+func NewWebhook(url, secret string) *Webhook {
+	return &Webhook{
+		Url:    url,
+		Secret: secret,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// NewRequest returns the post of the body of the event, signed when the secret is set.
+func (w *Webhook) NewRequest(event string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest("POST", w.Url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event)
+	if w.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+w.Sign(body))
+	}
+	return req, nil
+}
+
+func (w *Webhook) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,297 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the deliveries are of the webhook of the settings, or of the Slack incoming webhooks of the settings and the routes
+const (
+	WebhookDeliveryKindWebhook = "webhook"
+	WebhookDeliveryKindSlack   = "slack"
+
+	// the events of the Slack messages, as the webhook tells its events by WebhookEventHeader
+	WebhookEventBundleCreated = "bundle.created"
+	WebhookEventNotification  = "notification"
+)
+
+const (
+	WebhookDeliveryStateRetrying  = "retrying"
+	WebhookDeliveryStateDelivered = "delivered"
+	WebhookDeliveryStateFailed    = "failed"
+)
+
+const (
+	// the attempts are retried after 1, 2, 4, ... 64 minutes, about 2 hours in all
+	WebhookDeliveryMaxAttempts = 8
+	webhookRetryInterval       = time.Minute
+	// a server retrying the delivery holds it for the while, so that the other servers do not post it too
+	webhookRetryClaim = 5 * time.Minute
+	// the bytes of the responses kept, as the error pages can be large
+	webhookResponseMaxSize = 4096
+)
+
+// a WebhookDelivery is an event posted to a webhook, with the body as it is posted, so that the failed ones are
+// retried and redelivered as they were. The attempts to post it are kept as WebhookAttempts.
+type WebhookDelivery struct {
+	Id            int       `db:"id"`
+	AppId         int       `db:"app_id"`
+	Kind          string    `db:"kind"`
+	Event         string    `db:"event"`
+	Url           string    `db:"url"`
+	Body          []byte    `db:"body"`
+	State         string    `db:"state"`
+	Attempts      int       `db:"attempts"`
+	NextAttemptAt time.Time `db:"next_attempt_at"`
+	// of the last attempt
+	StatusCode  int       `db:"status_code"`
+	LatencyMs   int64     `db:"latency_ms"`
+	Error       string    `db:"error"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+	DeliveredAt time.Time `db:"delivered_at"`
+}
+
+// a WebhookAttempt is a post of a delivery, automatic or by an admin.
+type WebhookAttempt struct {
+	Id         int       `db:"id"`
+	DeliveryId int       `db:"delivery_id"`
+	StatusCode int       `db:"status_code"`
+	LatencyMs  int64     `db:"latency_ms"`
+	Response   string    `db:"response"`
+	Error      string    `db:"error"`
+	Manual     bool      `db:"manual"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+func NewWebhookDelivery(appId int, kind, event, webhookUrl string, payload interface{}) (*WebhookDelivery, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookDelivery{
+		AppId: appId,
+		Kind:  kind,
+		Event: event,
+		Url:   webhookUrl,
+		Body:  body,
+	}, nil
+}
+
+func (delivery *WebhookDelivery) PreInsert(s gorp.SqlExecutor) error {
+	delivery.CreatedAt = time.Now()
+	delivery.UpdatedAt = delivery.CreatedAt
+	return nil
+}
+
+func (delivery *WebhookDelivery) PreUpdate(s gorp.SqlExecutor) error {
+	delivery.UpdatedAt = time.Now()
+	return nil
+}
+
+func (attempt *WebhookAttempt) PreInsert(s gorp.SqlExecutor) error {
+	attempt.CreatedAt = time.Now()
+	return nil
+}
+
+// MaskedUrl shows the host of the URL only, as the paths of the Slack incoming webhooks are their secrets.
+func (delivery *WebhookDelivery) MaskedUrl() string {
+	u, err := url.Parse(delivery.Url)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + "/..."
+}
+
+// Deliver records the delivery and posts it. A failed delivery is retried with the backoff by RetryWebhookDeliveries,
+// and its error is returned to be logged. The delivery is held for the first attempt as for a retry, so that it is
+// retried when the server stops before it is posted.
+func (delivery *WebhookDelivery) Deliver(dbm *gorp.DbMap, secret string) error {
+	delivery.State = WebhookDeliveryStateRetrying
+	delivery.NextAttemptAt = time.Now().Add(webhookRetryClaim)
+	if err := dbm.Insert(delivery); err != nil {
+		return err
+	}
+	return delivery.post(dbm, secret, false)
+}
+
+// Redeliver posts the delivery again on behalf of an admin. A failed redelivery is not retried, unless the delivery
+// is retried already.
+func (delivery *WebhookDelivery) Redeliver(dbm *gorp.DbMap, secret string) error {
+	return delivery.post(dbm, secret, true)
+}
+
+func (delivery *WebhookDelivery) post(dbm *gorp.DbMap, secret string, manual bool) error {
+	attempt := delivery.attempt(secret)
+	attempt.Manual = manual
+
+	delivery.Attempts++
+	delivery.StatusCode = attempt.StatusCode
+	delivery.LatencyMs = attempt.LatencyMs
+	delivery.Error = attempt.Error
+	switch {
+	case attempt.Error == "":
+		delivery.State = WebhookDeliveryStateDelivered
+		delivery.DeliveredAt = time.Now()
+	case manual && delivery.State == WebhookDeliveryStateRetrying && delivery.Attempts < WebhookDeliveryMaxAttempts:
+		// the retries go on as scheduled
+	case manual || delivery.Attempts >= WebhookDeliveryMaxAttempts:
+		delivery.State = WebhookDeliveryStateFailed
+	default:
+		delivery.State = WebhookDeliveryStateRetrying
+		delivery.NextAttemptAt = time.Now().Add(webhookRetryInterval << uint(delivery.Attempts-1))
+	}
+
+	err := Transact(dbm, func(txn gorp.SqlExecutor) error {
+		attempt.DeliveryId = delivery.Id
+		if err := txn.Insert(attempt); err != nil {
+			return err
+		}
+		_, err := txn.Update(delivery)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if attempt.Error != "" {
+		return fmt.Errorf("%s delivery %d: %s", delivery.Kind, delivery.Id, attempt.Error)
+	}
+	return nil
+}
+
+// attempt posts the body, signed by the secret for the webhook, and tells how it went.
+func (delivery *WebhookDelivery) attempt(secret string) *WebhookAttempt {
+	attempt := &WebhookAttempt{}
+	body := delivery.Body
+
+	var req *http.Request
+	var client *http.Client
+	var err error
+	if delivery.Kind == WebhookDeliveryKindSlack {
+		req, err = newSlackRequest(delivery.Url, body)
+		client = slackClient
+	} else {
+		webhook := NewWebhook(delivery.Url, secret)
+		req, err = webhook.NewRequest(delivery.Event, body)
+		client = webhook.Client
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	attempt.LatencyMs = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, webhookResponseMaxSize))
+	attempt.StatusCode = resp.StatusCode
+	attempt.Response = string(data)
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		attempt.Error = fmt.Sprintf("got HTTP response code %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+// claim holds the delivery due for the retry, unless another server holds it.
+func (delivery *WebhookDelivery) claim(dbm *gorp.DbMap, now time.Time) (bool, error) {
+	result, err := dbm.Exec("UPDATE webhook_delivery SET next_attempt_at = ? WHERE id = ? AND state = ? AND next_attempt_at = ?",
+		now.Add(webhookRetryClaim), delivery.Id, WebhookDeliveryStateRetrying, delivery.NextAttemptAt)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// RetryWebhookDeliveries posts the failed deliveries due for the retry, and returns how many of them are delivered.
+func RetryWebhookDeliveries(dbm *gorp.DbMap, secret string, limit int) (int, error) {
+	now := time.Now()
+	var deliveries []*WebhookDelivery
+	_, err := dbm.Select(&deliveries, "SELECT * FROM webhook_delivery WHERE state = ? AND next_attempt_at <= ? ORDER BY next_attempt_at ASC LIMIT ?",
+		WebhookDeliveryStateRetrying, now, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, delivery := range deliveries {
+		claimed, err := delivery.claim(dbm, now)
+		if err != nil {
+			return delivered, err
+		}
+		if !claimed {
+			continue
+		}
+		if err := delivery.post(dbm, secret, false); err == nil {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// GetWebhookDeliveries returns the latest deliveries, of the state unless it is empty.
+func GetWebhookDeliveries(txn gorp.SqlExecutor, state string, limit int) ([]*WebhookDelivery, error) {
+	condition := ""
+	args := []interface{}{}
+	if state != "" {
+		condition = " WHERE state = ?"
+		args = append(args, state)
+	}
+	var deliveries []*WebhookDelivery
+	_, err := txn.Select(&deliveries, "SELECT * FROM webhook_delivery"+condition+" ORDER BY id DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func GetWebhookDelivery(txn gorp.SqlExecutor, id int) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	if err := txn.SelectOne(&delivery, "SELECT * FROM webhook_delivery WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (delivery *WebhookDelivery) AttemptList(txn gorp.SqlExecutor) ([]*WebhookAttempt, error) {
+	var attempts []*WebhookAttempt
+	_, err := txn.Select(&attempts, "SELECT * FROM webhook_attempt WHERE delivery_id = ? ORDER BY id DESC", delivery.Id)
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// PurgeWebhookDeliveries deletes the deliveries created before, with their attempts, except the ones being retried.
+func PurgeWebhookDeliveries(txn gorp.SqlExecutor, before time.Time) (int, error) {
+	_, err := txn.Exec("DELETE FROM webhook_attempt WHERE delivery_id IN (SELECT id FROM webhook_delivery WHERE created_at < ? AND state <> ?)",
+		before, WebhookDeliveryStateRetrying)
+	if err != nil {
+		return 0, err
+	}
+	result, err := txn.Exec("DELETE FROM webhook_delivery WHERE created_at < ? AND state <> ?", before, WebhookDeliveryStateRetrying)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
@@ -0,0 +1,72 @@
+// Command alphawing runs the tasks of alphawing outside the server, with the settings of conf/app.conf.
+//
+//	$ alphawing [-mode dev] seed [-developer email] [-tester email]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kayac/alphawing/app/controllers"
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel"
+)
+
+const importPath = "github.com/kayac/alphawing"
+
+func main() {
+	mode := flag.String("mode", "dev", "the run mode of conf/app.conf")
+	flag.Usage = usage
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "seed":
+		seed(*mode, flag.Args()[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: alphawing [-mode dev] seed [-developer email] [-tester email]\n")
+	flag.PrintDefaults()
+}
+
+func initApp(mode string) {
+	revel.Init(mode, importPath, "")
+	controllers.LoadConfig()
+	controllers.InitDB()
+}
+
+func seed(mode string, args []string) {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	developer := flags.String("developer", "", "the email of the demo developer (default: demo-developer@ the first permitted domain)")
+	tester := flags.String("tester", "", "the email of the demo tester (default: demo-tester@ the first permitted domain)")
+	flags.Parse(args)
+
+	initApp(mode)
+
+	report, err := controllers.Seed(*developer, *tester)
+	if err == models.ErrAlreadySeeded {
+		fmt.Fprintln(os.Stderr, "the database already has apps, so nothing is seeded.")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, user := range report.Users {
+		fmt.Printf("user\t%s\n", user.Email)
+	}
+	for _, app := range report.Apps {
+		fmt.Printf("app\t%d\t%s\t%s\tapi_token=%s\n", app.Id, app.Title, app.Visibility, app.ApiToken)
+	}
+	for _, bundle := range report.Bundles {
+		fmt.Printf("bundle\t%d\tapp=%d\t%s (%d)\n", bundle.Id, bundle.AppId, bundle.BundleVersion, bundle.Revision)
+	}
+	fmt.Printf("device\t%s\tdevice_token=%s\n", report.DeviceName, report.DeviceToken)
+}
@@ -16,7 +16,15 @@ $(function () {
             '削除するとこのプロジェクトにアクセスできなくなります。よろしいですか?'
         ].join('\n'),
         ERROR_APP_ID: 'error:\n不正なapp idです。',
-        ERROR_AUTHORITY_ID: 'error:\n不正なauthority idです。'
+        ERROR_AUTHORITY_ID: 'error:\n不正なauthority idです。',
+        ERROR_VERIFY_FILE: 'error:\nこのブラウザではチェックサムを計算できません。',
+        COPIED: 'コピーしました',
+        ERROR_COPY: 'error:\nコピーできませんでした。',
+        UPLOADING: 'アップロード中...',
+        UPLOADED: 'アップロードしました。内容を確認して追加してください。',
+        ERROR_UPLOAD_EXTENSION: 'ファイルの拡張子が不正です。',
+        ERROR_UPLOAD_SIZE: 'ファイルが大きすぎます。',
+        ERROR_UPLOAD: 'アップロードできませんでした。'
     };
 
 
@@ -100,6 +108,241 @@ $(function () {
         submitPost(href);
     });
 
+    // copy to clipboard
+    // the textarea is for the browsers without the Clipboard API, e.g. on http
+    function copyText (text, done, fail) {
+        if (navigator.clipboard && window.isSecureContext) {
+            navigator.clipboard.writeText(text).then(done, fail);
+            return;
+        }
+        var $textarea = $('<textarea />').val(text).css({position: 'fixed', top: 0, opacity: 0}).appendTo('body');
+        $textarea[0].select();
+        var copied = document.execCommand('copy');
+        $textarea.remove();
+        (copied ? done : fail)();
+    }
+
+    $('.js-copy').on('click', function () {
+        var $button = $(this);
+        // the label is kept while the button tells it is copied
+        var label = $button.data('label') || $button.text();
+        $button.data('label', label);
+        copyText($button.attr('data-copy'), function () {
+            $button.text(MSG.COPIED);
+            setTimeout(function () {
+                $button.text(label);
+            }, 2000);
+        }, function () {
+            alert(MSG.ERROR_COPY);
+        });
+    });
+
+    // share through the share sheet of the device
+    if (navigator.share) {
+        $('.js-share').prop('hidden', false).on('click', function () {
+            var $button = $(this);
+            navigator.share({
+                title: $button.attr('data-share-title'),
+                url: $button.attr('data-share-url')
+            })['catch'](function () {
+                // cancelled
+            });
+        });
+    }
+
+    // verify checksum
+    // the file is hashed in the browser, so the build is not uploaded
+    $('#verify-file').on('change', function () {
+        var file = this.files && this.files[0];
+        if (!file) {
+            return;
+        }
+        if (!window.crypto || !window.crypto.subtle) {
+            alert(MSG.ERROR_VERIFY_FILE);
+            return;
+        }
+
+        var reader = new FileReader();
+        reader.onload = function () {
+            window.crypto.subtle.digest('SHA-256', reader.result).then(function (hash) {
+                var hex = Array.prototype.map.call(new Uint8Array(hash), function (b) {
+                    return ('0' + b.toString(16)).slice(-2);
+                }).join('');
+                $('#verify-digest').val(hex);
+                $('#verify-form').submit();
+            });
+        };
+        reader.readAsArrayBuffer(file);
+    });
+
+    // chunked upload
+    // the file is sent in chunks through the upload session, so that a large one shows the progress
+    // and a failed chunk is sent again instead of the whole file
+    (function () {
+        var CHUNK_SIZE = 8 * 1024 * 1024;
+        var RETRIES = 3;
+
+        var $form = $('.js-upload-form');
+        if (!$form.length || !window.XMLHttpRequest || !window.Blob || !Blob.prototype.slice) {
+            return;
+        }
+        var $drop = $form.find('.js-upload-drop');
+        var $file = $form.find('.js-upload-file');
+        var $progress = $form.find('.js-upload-progress');
+        var $info = $form.find('.js-upload-info');
+        var $uploadId = $form.find('.js-upload-id');
+        var $submit = $form.find('[type="submit"]');
+        var sessionUrl = $form.attr('data-upload-session-url');
+        var extensions = $drop.attr('data-extensions').split(',');
+        var maxSize = parseInt($drop.attr('data-max-size'), 10) || 0;
+
+        function showInfo (text, isError) {
+            $info.text(text).toggleClass('form-section__info--error', !!isError).prop('hidden', false);
+        }
+
+        function parseJson (text) {
+            try {
+                return JSON.parse(text);
+            } catch (e) {
+                return {};
+            }
+        }
+
+        function errorMessage (res) {
+            return (res && res.message && res.message.length) ? res.message.join('\n') : MSG.ERROR_UPLOAD;
+        }
+
+        function validate (file) {
+            var name = file.name.toLowerCase();
+            var ext = /\.tar\.gz$/.test(name) ? '.tar.gz' : (name.match(/\.[^.]+$/) || [''])[0];
+            if ($.inArray(ext, extensions) < 0) {
+                return MSG.ERROR_UPLOAD_EXTENSION;
+            }
+            if (maxSize && file.size > maxSize) {
+                return MSG.ERROR_UPLOAD_SIZE;
+            }
+            return null;
+        }
+
+        function describe (content) {
+            if (content.info_error) {
+                return content.info_error;
+            }
+            var info = content.info;
+            if (!info) {
+                return MSG.UPLOADED;
+            }
+            var platform = info.platform_type + (info.platform_subtype ? ' (' + info.platform_subtype + ')' : '');
+            var version = info.short_version ? info.short_version + ' (ビルド ' + info.version + ')' : info.version;
+            return MSG.UPLOADED + '\n' + [
+                'プラットフォーム: ' + platform,
+                '識別子: ' + info.identifier,
+                'バージョン: ' + version
+            ].join(' ');
+        }
+
+        // the 409 of a chunk tells the offset received, e.g. when the response of the last chunk was lost
+        function sendChunk (url, file, offset, retries, done, fail) {
+            var xhr = new XMLHttpRequest();
+            var retry = function () {
+                if (retries <= 0) {
+                    fail(errorMessage(parseJson(xhr.responseText)));
+                    return;
+                }
+                setTimeout(function () {
+                    sendChunk(url, file, offset, retries - 1, done, fail);
+                }, 1000);
+            };
+
+            xhr.open('PUT', url + '?offset=' + offset);
+            xhr.setRequestHeader('Content-Type', 'application/octet-stream');
+            xhr.upload.onprogress = function (e) {
+                $progress.val(Math.floor((offset + e.loaded) * 100 / file.size));
+            };
+            xhr.onload = function () {
+                var res = parseJson(xhr.responseText);
+                if ((xhr.status === 200 || xhr.status === 409) && res.content) {
+                    if (res.content.offset === file.size) {
+                        done(res.content);
+                        return;
+                    }
+                    sendChunk(url, file, res.content.offset, RETRIES, done, fail);
+                    return;
+                }
+                if (xhr.status >= 500) {
+                    retry();
+                    return;
+                }
+                fail(errorMessage(res));
+            };
+            xhr.onerror = retry;
+            xhr.send(file.slice(offset, Math.min(offset + CHUNK_SIZE, file.size)));
+        }
+
+        function upload (file) {
+            var error = validate(file);
+            $uploadId.val('');
+            if (error) {
+                $file.val('');
+                showInfo(error, true);
+                return;
+            }
+
+            $submit.prop('disabled', true);
+            $progress.val(0).prop('hidden', false);
+            showInfo(MSG.UPLOADING);
+
+            $.ajax({
+                url: sessionUrl,
+                type: 'POST',
+                data: {filename: file.name, size: file.size},
+                dataType: 'json'
+            }).done(function (res) {
+                sendChunk(sessionUrl + '/' + res.content.upload_id, file, 0, RETRIES, function (content) {
+                    $progress.val(100);
+                    $uploadId.val(content.upload_id);
+                    showInfo(describe(content), !!content.info_error);
+                    $submit.prop('disabled', !!content.info_error);
+                }, function (message) {
+                    showInfo(message, true);
+                    $submit.prop('disabled', false);
+                });
+            }).fail(function (xhr) {
+                showInfo(errorMessage(parseJson(xhr.responseText)), true);
+                $submit.prop('disabled', false);
+            });
+        }
+
+        $file.on('change', function () {
+            if (this.files && this.files[0]) {
+                upload(this.files[0]);
+            }
+        });
+
+        $drop.on('dragover', function (e) {
+            e.preventDefault();
+            $drop.addClass('form-section__drop--over');
+        });
+        $drop.on('dragleave drop', function () {
+            $drop.removeClass('form-section__drop--over');
+        });
+        $drop.on('drop', function (e) {
+            e.preventDefault();
+            var files = e.originalEvent.dataTransfer && e.originalEvent.dataTransfer.files;
+            if (files && files.length) {
+                $file.val('');
+                upload(files[0]);
+            }
+        });
+
+        // the file uploaded in chunks is not sent again with the form
+        $form.on('submit', function () {
+            if ($uploadId.val()) {
+                $file.prop('disabled', true);
+            }
+        });
+    })();
+
     // authority form
     (function () {
         var $memberList = $('#member-list');
@@ -223,7 +466,12 @@ $(function () {
             top: '0px'
         });
 
-        $.each(LABELS, function (index, label) {
+        // the tabs of the other platforms are labeled by themselves
+        var labels = $appBundle.children().map(function (index) {
+            return $(this).data('label') || LABELS[index];
+        }).get();
+
+        $.each(labels, function (index, label) {
             var $btn = $('<a href="#" />');
             $btn.text(label);
             $btn.on('click', function (e) {
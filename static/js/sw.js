@@ -0,0 +1,148 @@
+// service worker to show the install pages pinned in a test lab when the network is flaky.
+// it is served at /sw.js to control the whole site.
+(function () {
+
+    var VERSION = 'v1';
+    var PAGE_CACHE = 'alphawing-pages-' + VERSION;
+    var ASSET_CACHE = 'alphawing-assets-' + VERSION;
+
+    // the app pages and the bundle pages with the QR code and the instructions
+    var PAGE_PATTERN = /^\/(app|bundle)\/\d+$/;
+    var ASSET_HOSTS = ['chart.googleapis.com', 'ajax.googleapis.com'];
+    var NETWORK_TIMEOUT = 3000;
+
+    var PRECACHE = [
+        '/static/css/alphawing.css',
+        '/static/js/alphawing.js',
+        '/static/js/lib/html5shiv.js',
+        '/static/img/logo_alphawing.png',
+        '/static/img/favicon.ico'
+    ];
+
+    self.addEventListener('install', function (e) {
+        e.waitUntil(caches.open(ASSET_CACHE).then(function (cache) {
+            return cache.addAll(PRECACHE);
+        }).then(function () {
+            return self.skipWaiting();
+        }));
+    });
+
+    self.addEventListener('activate', function (e) {
+        e.waitUntil(caches.keys().then(function (keys) {
+            return Promise.all(keys.filter(function (key) {
+                return key !== PAGE_CACHE && key !== ASSET_CACHE;
+            }).map(function (key) {
+                return caches.delete(key);
+            }));
+        }).then(function () {
+            return self.clients.claim();
+        }));
+    });
+
+    // the network first, so that the latest page is shown, and the cached page when it does not respond in time
+    var networkFirst = function (request) {
+        return new Promise(function (resolve, reject) {
+            var done = false;
+            var fallback = function () {
+                caches.match(request).then(function (cached) {
+                    if (done) {
+                        return;
+                    }
+                    if (cached) {
+                        done = true;
+                        resolve(cached);
+                    }
+                });
+            };
+            var timer = setTimeout(fallback, NETWORK_TIMEOUT);
+
+            fetch(request).then(function (response) {
+                clearTimeout(timer);
+                // not to cache the login page the page is redirected to
+                if (response.ok && !response.redirected) {
+                    var copy = response.clone();
+                    caches.open(PAGE_CACHE).then(function (cache) {
+                        cache.put(request, copy);
+                    });
+                }
+                if (!done) {
+                    done = true;
+                    resolve(response);
+                }
+            }).catch(function () {
+                clearTimeout(timer);
+                caches.match(request).then(function (cached) {
+                    if (done) {
+                        return;
+                    }
+                    done = true;
+                    cached ? resolve(cached) : reject(new Error('offline'));
+                });
+            });
+        });
+    };
+
+    // the QR codes do not change for the URL
+    var cacheFirst = function (request) {
+        return caches.match(request).then(function (cached) {
+            if (cached) {
+                return cached;
+            }
+            return fetch(request).then(function (response) {
+                // the QR codes and jQuery are opaque cross-origin responses
+                if (response.ok || response.type === 'opaque') {
+                    var copy = response.clone();
+                    caches.open(ASSET_CACHE).then(function (cache) {
+                        cache.put(request, copy);
+                    });
+                }
+                return response;
+            });
+        });
+    };
+
+    // the cached assets at once, updated for the next time not to keep the old ones after a deploy
+    var staleWhileRevalidate = function (request) {
+        return caches.match(request).then(function (cached) {
+            var fetched = fetch(request).then(function (response) {
+                if (response.ok) {
+                    var copy = response.clone();
+                    caches.open(ASSET_CACHE).then(function (cache) {
+                        cache.put(request, copy);
+                    });
+                }
+                return response;
+            });
+            return cached || fetched;
+        });
+    };
+
+    self.addEventListener('fetch', function (e) {
+        var request = e.request;
+        if (request.method !== 'GET') {
+            return;
+        }
+        var url = new URL(request.url);
+
+        if (url.origin === self.location.origin) {
+            // the pages of the user are not left on a shared device after the logout
+            if (url.pathname === '/logout') {
+                e.waitUntil(caches.delete(PAGE_CACHE));
+                return;
+            }
+            if (request.mode === 'navigate' && PAGE_PATTERN.test(url.pathname)) {
+                e.respondWith(networkFirst(request));
+                return;
+            }
+            if (url.pathname.indexOf('/static/') === 0) {
+                e.respondWith(staleWhileRevalidate(request));
+            }
+            return;
+        }
+
+        if (ASSET_HOSTS.indexOf(url.hostname) !== -1) {
+            e.respondWith(cacheFirst(request));
+        }
+    });
+
+})();
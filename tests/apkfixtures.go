@@ -0,0 +1,402 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+)
+
+// the apks are signed as apksigner signs them, by the specification of the schemes:
+// https://source.android.com/docs/security/features/apksigning
+
+const (
+	apkSigRsaPkcs1Sha256 = 0x0103
+	apkSigEcdsaSha256    = 0x0201
+
+	apkSignatureV2Id     = 0x7109871a
+	apkSignatureV3Id     = 0xf05368c0
+	apkProofOfRotationId = 0x3ba06f8c
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSha256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRsaEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+// an apkKey is a key with the self-signed certificate the apks are signed with
+type apkKey struct {
+	Signer      crypto.Signer
+	Certificate *x509.Certificate
+	Algorithm   uint32
+}
+
+func newRsaApkKey(name string) *apkKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return newApkKey(name, key, apkSigRsaPkcs1Sha256)
+}
+
+func newEcdsaApkKey(name string) *apkKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return newApkKey(name, key, apkSigEcdsaSha256)
+}
+
+func newApkKey(name string, key crypto.Signer, algorithm uint32) *apkKey {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return &apkKey{Signer: key, Certificate: certificate, Algorithm: algorithm}
+}
+
+// sign signs the SHA-256 of the data, by PKCS #1 v1.5 for RSA and in ASN.1 for ECDSA.
+func (key *apkKey) sign(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	signature, err := key.Signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	if err != nil {
+		panic(err)
+	}
+	return signature
+}
+
+// publicKey returns the key of the certificate, which is not of the signer for the forged signatures.
+func (key *apkKey) publicKey() []byte {
+	der, err := x509.MarshalPKIXPublicKey(key.Certificate.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+	return der
+}
+
+// an apkEntry is a file of the apk, in the order of the zip
+type apkEntry struct {
+	Name    string
+	Content []byte
+}
+
+func placeholderApkEntries() []apkEntry {
+	return []apkEntry{
+		{"AndroidManifest.xml", []byte("manifest of com.example.alphawing")},
+		{"classes.dex", bytes.Repeat([]byte("dex\n"), 1024)},
+		{"res/layout/main.xml", []byte("<LinearLayout/>")},
+	}
+}
+
+// apkZip writes the entries stored without compression, so that the offsets of the contents are predictable.
+func apkZip(entries []apkEntry) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: entry.Name, Method: zip.Store})
+		if err != nil {
+			panic(err)
+		}
+		if _, err := f.Write(entry.Content); err != nil {
+			panic(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// signApkV1 returns the entries with the JAR signature of v1, META-INF/MANIFEST.MF, CERT.SF and CERT.RSA.
+func signApkV1(entries []apkEntry, key *apkKey) []apkEntry {
+	manifest := []byte("Manifest-Version: 1.0\r\nCreated-By: alphawing tests\r\n\r\n")
+	sf := []byte("Signature-Version: 1.0\r\nCreated-By: alphawing tests\r\nSHA-256-Digest-Manifest: ")
+	var sections []byte
+	for _, entry := range entries {
+		section := []byte("Name: " + entry.Name + "\r\nSHA-256-Digest: " + sha256Base64(entry.Content) + "\r\n\r\n")
+		manifest = append(manifest, section...)
+		sections = append(sections, []byte("Name: "+entry.Name+"\r\nSHA-256-Digest: "+sha256Base64(section)+"\r\n\r\n")...)
+	}
+	sf = append(sf, []byte(sha256Base64(manifest)+"\r\n\r\n")...)
+	sf = append(sf, sections...)
+
+	signed := append([]apkEntry{{"META-INF/MANIFEST.MF", manifest}}, entries...)
+	return append(signed, apkEntry{"META-INF/CERT.SF", sf}, apkEntry{"META-INF/CERT.RSA", signPkcs7(sf, key)})
+}
+
+func sha256Base64(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+	Certificates     asn1.RawValue
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+// signPkcs7 returns the signature block of the signature file detached, with the authenticated attributes
+// as jarsigner signs it.
+func signPkcs7(content []byte, key *apkKey) []byte {
+	digest := sha256.Sum256(content)
+	var attributes []byte
+	for _, attribute := range []struct {
+		Type  asn1.ObjectIdentifier
+		Value interface{}
+	}{{oidContentType, oidData}, {oidMessageDigest, digest[:]}} {
+		value := mustMarshal(attribute.Value)
+		attributes = append(attributes, mustMarshal(pkcs7Attribute{
+			Type:   attribute.Type,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})...)
+	}
+	// the attributes are signed with the tag of SET OF, and embedded with the implicit one
+	signed := mustMarshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attributes})
+
+	sha256Algorithm := pkix.AlgorithmIdentifier{Algorithm: oidSha256, Parameters: asn1.NullRawValue}
+	signedData := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: key.Certificate.Raw},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: pkcs7IssuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: key.Certificate.RawIssuer},
+				SerialNumber: key.Certificate.SerialNumber,
+			},
+			DigestAlgorithm:           sha256Algorithm,
+			AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attributes},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRsaEncryption, Parameters: asn1.NullRawValue},
+			EncryptedDigest:           key.sign(signed),
+		}},
+	}
+	signedData.ContentInfo.ContentType = oidData
+	return mustMarshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: mustMarshal(signedData)},
+	})
+}
+
+func mustMarshal(v interface{}) []byte {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// an apkRotation is a key of the proof of rotation of v3, the oldest first
+type apkRotation struct {
+	Key *apkKey
+	// the key signing the node instead of the one before, to break the proof
+	SignedBy *apkKey
+}
+
+// signApkV2 inserts the APK Signing Block of v2 signed by the keys before the central directory of the zip.
+func signApkV2(apk []byte, keys ...*apkKey) []byte {
+	return insertSigningBlock(apk, func(digest []byte) map[uint32][]byte {
+		var signers [][]byte
+		for _, key := range keys {
+			signedData := concat(
+				lengthPrefixed(lengthPrefixed(concat(uint32Bytes(key.Algorithm), lengthPrefixed(digest)))),
+				lengthPrefixed(lengthPrefixed(key.Certificate.Raw)),
+				lengthPrefixed(nil),
+			)
+			signers = append(signers, lengthPrefixed(concat(
+				lengthPrefixed(signedData),
+				lengthPrefixed(lengthPrefixed(concat(uint32Bytes(key.Algorithm), lengthPrefixed(key.sign(signedData))))),
+				lengthPrefixed(key.publicKey()),
+			)))
+		}
+		return map[uint32][]byte{apkSignatureV2Id: lengthPrefixed(concat(signers...))}
+	})
+}
+
+// signApkV3 inserts the APK Signing Block of v3 signed by the key, with the proof of rotation from the keys rotated from.
+func signApkV3(apk []byte, key *apkKey, rotations ...apkRotation) []byte {
+	return insertSigningBlock(apk, func(digest []byte) map[uint32][]byte {
+		var attributes []byte
+		if len(rotations) > 0 {
+			attributes = lengthPrefixed(concat(uint32Bytes(apkProofOfRotationId), signingLineage(rotations)))
+		}
+		sdks := concat(uint32Bytes(28), uint32Bytes(0x7fffffff))
+		signedData := concat(
+			lengthPrefixed(lengthPrefixed(concat(uint32Bytes(key.Algorithm), lengthPrefixed(digest)))),
+			lengthPrefixed(lengthPrefixed(key.Certificate.Raw)),
+			sdks,
+			lengthPrefixed(attributes),
+		)
+		signer := concat(
+			lengthPrefixed(signedData),
+			sdks,
+			lengthPrefixed(lengthPrefixed(concat(uint32Bytes(key.Algorithm), lengthPrefixed(key.sign(signedData))))),
+			lengthPrefixed(key.publicKey()),
+		)
+		return map[uint32][]byte{apkSignatureV3Id: lengthPrefixed(lengthPrefixed(signer))}
+	})
+}
+
+// signingLineage returns the proof of rotation, where each certificate is signed by the key before it.
+func signingLineage(rotations []apkRotation) []byte {
+	lineage := uint32Bytes(1)
+	var previous *apkKey
+	for _, rotation := range rotations {
+		var parentAlgorithm uint32
+		var signature []byte
+		signedData := lengthPrefixed(rotation.Key.Certificate.Raw)
+		if previous != nil {
+			parentAlgorithm = previous.Algorithm
+			signedData = concat(signedData, uint32Bytes(parentAlgorithm))
+			signedBy := previous
+			if rotation.SignedBy != nil {
+				signedBy = rotation.SignedBy
+			}
+			signature = signedBy.sign(signedData)
+		} else {
+			signedData = concat(signedData, uint32Bytes(0))
+		}
+		lineage = concat(lineage, lengthPrefixed(concat(
+			lengthPrefixed(signedData),
+			uint32Bytes(0), // the flags
+			uint32Bytes(rotation.Key.Algorithm),
+			lengthPrefixed(signature),
+		)))
+		previous = rotation.Key
+	}
+	return lineage
+}
+
+// insertSigningBlock inserts the APK Signing Block with the values made from the digest of the contents, and
+// points the end of central directory to the central directory after it.
+func insertSigningBlock(apk []byte, values func(digest []byte) map[uint32][]byte) []byte {
+	// the zip is written without the comment, so the end of central directory is the last 22 bytes
+	eocdOffset := len(apk) - 22
+	eocd := apk[eocdOffset:]
+	cdOffset := int(binary.LittleEndian.Uint32(eocd[16:]))
+
+	var pairs []byte
+	for id, value := range values(apkContentDigest(apk[:cdOffset], apk[cdOffset:eocdOffset], eocd)) {
+		pairs = concat(pairs, uint64Bytes(uint64(4+len(value))), uint32Bytes(id), value)
+	}
+	size := uint64(len(pairs) + 8 + 16)
+	block := concat(uint64Bytes(size), pairs, uint64Bytes(size), []byte("APK Sig Block 42"))
+
+	signed := concat(apk[:cdOffset], block, apk[cdOffset:eocdOffset], eocd)
+	binary.LittleEndian.PutUint32(signed[len(signed)-22+16:], uint32(cdOffset+len(block)))
+	return signed
+}
+
+// apkContentDigest returns the SHA-256 of the digests of the 1MB chunks of the entries, the central directory and
+// the end of central directory, which points to the signing block at the offset of the central directory.
+func apkContentDigest(entries, cd, eocd []byte) []byte {
+	var chunks [][]byte
+	for _, section := range [][]byte{entries, cd, eocd} {
+		for len(section) > 0 {
+			n := 1024 * 1024
+			if len(section) < n {
+				n = len(section)
+			}
+			chunks = append(chunks, section[:n])
+			section = section[n:]
+		}
+	}
+	top := sha256.New()
+	top.Write(concat([]byte{0x5a}, uint32Bytes(uint32(len(chunks)))))
+	for _, chunk := range chunks {
+		sum := sha256.Sum256(concat([]byte{0xa5}, uint32Bytes(uint32(len(chunk))), chunk))
+		top.Write(sum[:])
+	}
+	return top.Sum(nil)
+}
+
+func lengthPrefixed(data []byte) []byte {
+	return concat(uint32Bytes(uint32(len(data))), data)
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func uint64Bytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+// createApkFile writes the apk to a temporary file, which the caller removes.
+func createApkFile(apk []byte) *os.File {
+	file, err := ioutil.TempFile("", "alphawing-test")
+	if err != nil {
+		panic(err)
+	}
+	if _, err := file.Write(apk); err != nil {
+		panic(err)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		panic(err)
+	}
+	return file
+}
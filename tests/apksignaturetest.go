@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel/testing"
+)
+
+// ApkSignatureTest verifies the apks signed by the v1, v2 and v3 schemes, and refuses the tampered and truncated ones.
+type ApkSignatureTest struct {
+	testing.TestSuite
+	Rsa, Ecdsa, Old, Stranger *apkKey
+}
+
+func (t *ApkSignatureTest) Before() {
+	t.Rsa = newRsaApkKey("Alpha Wing Test")
+	t.Ecdsa = newEcdsaApkKey("Alpha Wing Test EC")
+	t.Old = newRsaApkKey("Alpha Wing Test Old")
+	t.Stranger = newRsaApkKey("Stranger")
+}
+
+func (t *ApkSignatureTest) verify(apk []byte) (*models.ApkSignature, error) {
+	file := createApkFile(apk)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	return models.VerifyApkSignature(file)
+}
+
+func (t *ApkSignatureTest) TestVerifyApkSignature() {
+	v1 := apkZip(signApkV1(placeholderApkEntries(), t.Rsa))
+	for _, c := range []struct {
+		name        string
+		apk         []byte
+		scheme      string
+		certificate *apkKey
+		past        []*apkKey
+	}{
+		{"v1", v1, models.ApkSigningSchemeV1, t.Rsa, nil},
+		{"v2 RSA", signApkV2(apkZip(placeholderApkEntries()), t.Rsa), models.ApkSigningSchemeV2, t.Rsa, nil},
+		{"v2 ECDSA", signApkV2(apkZip(placeholderApkEntries()), t.Ecdsa), models.ApkSigningSchemeV2, t.Ecdsa, nil},
+		{"v2 over v1", signApkV2(v1, t.Rsa), models.ApkSigningSchemeV2, t.Rsa, nil},
+		{"v2 of two signers", signApkV2(apkZip(placeholderApkEntries()), t.Rsa, t.Ecdsa), models.ApkSigningSchemeV2, t.Rsa, nil},
+		{"v3", signApkV3(v1, t.Rsa), models.ApkSigningSchemeV3, t.Rsa, nil},
+		{"v3 rotated", signApkV3(v1, t.Rsa, apkRotation{Key: t.Old}, apkRotation{Key: t.Rsa}), models.ApkSigningSchemeV3, t.Rsa, []*apkKey{t.Old}},
+		{"v3 rotated twice", signApkV3(v1, t.Ecdsa, apkRotation{Key: t.Old}, apkRotation{Key: t.Rsa}, apkRotation{Key: t.Ecdsa}), models.ApkSigningSchemeV3, t.Ecdsa, []*apkKey{t.Old, t.Rsa}},
+	} {
+		signature, err := t.verify(c.apk)
+		t.Assertf(err == nil, "%s: %v", c.name, err)
+		if err != nil {
+			continue
+		}
+		t.Assertf(signature.Scheme == c.scheme, "%s: the scheme is %s", c.name, signature.Scheme)
+		t.Assertf(signature.Certificate == models.CertificateFingerprint(c.certificate.Certificate.Raw), "%s: the certificate differs", c.name)
+		t.Assertf(len(signature.PastCertificates) == len(c.past), "%s: %d past certificates", c.name, len(signature.PastCertificates))
+		for _, past := range c.past {
+			t.Assertf(signature.RotatedFrom(models.CertificateFingerprint(past.Certificate.Raw)), "%s: not rotated from %s", c.name, past.Certificate.Subject.CommonName)
+		}
+	}
+}
+
+func (t *ApkSignatureTest) TestRefuseBrokenApkSignature() {
+	entries := placeholderApkEntries()
+	signed := signApkV1(entries, t.Rsa)
+	v2 := signApkV2(apkZip(entries), t.Rsa)
+	forged := &apkKey{Signer: t.Stranger.Signer, Certificate: t.Rsa.Certificate, Algorithm: t.Rsa.Algorithm}
+
+	for _, c := range []struct {
+		name string
+		apk  []byte
+		// the error refused as a broken signature, or else as a broken zip
+		signatureError bool
+	}{
+		{"not signed", apkZip(entries), true},
+		{"v1 entry changed", apkZip(replaceApkEntry(signed, "classes.dex", []byte("changed"))), true},
+		{"v1 entry added", apkZip(append(signed, apkEntry{"lib/added.so", []byte("added")})), true},
+		{"v1 entry removed", apkZip(removeApkEntry(signed, "res/layout/main.xml")), true},
+		{"v1 manifest changed", apkZip(replaceApkEntry(signed, "META-INF/MANIFEST.MF", append(signed[0].Content, "X-Changed: true\r\n\r\n"...))), true},
+		{"v1 signature file changed", apkZip(replaceApkEntry(signed, "META-INF/CERT.SF", []byte("Signature-Version: 1.0\r\n\r\n"))), true},
+		{"v1 signed by another key", apkZip(replaceApkEntry(signed, "META-INF/CERT.RSA", signPkcs7(signed[len(signed)-2].Content, forged))), true},
+		{"v1 signature block broken", apkZip(replaceApkEntry(signed, "META-INF/CERT.RSA", []byte("broken"))), true},
+		{"v2 entry changed", replaceBytes(v2, []byte("dex\n"), []byte("DEX\n")), true},
+		{"v2 signed by another key", signApkV2(apkZip(entries), forged), true},
+		{"v2 of a signer failing", signApkV2(apkZip(entries), t.Rsa, forged), true},
+		{"v2 block before the start", setSigningBlockSize(v2, true, 1<<40), true},
+		{"v2 block sizes differ", setSigningBlockSize(v2, false, 1), true},
+		{"v2 pair truncated", setSigningBlockUint(v2, 8, 1<<20), true},
+		{"v2 signers truncated", setSigningBlockUint(v2, 20, 1<<20), true},
+		{"v2 signer truncated", setSigningBlockUint(v2, 24, 1<<20), true},
+		{"v3 entry changed", replaceBytes(signApkV3(apkZip(entries), t.Rsa), []byte("dex\n"), []byte("DEX\n")), true},
+		{"v3 signed by another key", signApkV3(apkZip(entries), forged), true},
+		{"v3 rotated by another key", signApkV3(apkZip(entries), t.Rsa, apkRotation{Key: t.Old}, apkRotation{Key: t.Rsa, SignedBy: t.Stranger}), true},
+		{"v3 rotated to another key", signApkV3(apkZip(entries), t.Rsa, apkRotation{Key: t.Old}, apkRotation{Key: t.Stranger}), true},
+		{"v3 signers truncated", setSigningBlockUint(signApkV3(apkZip(entries), t.Rsa), 24, 1<<20), true},
+		{"end of central directory truncated", v2[:len(v2)-10], false},
+	} {
+		_, err := t.verify(c.apk)
+		t.Assertf(err != nil, "%s: verified", c.name)
+		if err == nil {
+			continue
+		}
+		_, isSignatureError := err.(*models.ApkSignatureError)
+		_, isParseError := err.(*models.BundleParseError)
+		t.Assertf(isSignatureError == c.signatureError && (isSignatureError || isParseError), "%s: %T %v", c.name, err, err)
+	}
+}
+
+func (t *ApkSignatureTest) TestLeaveUnsupportedApkSignature() {
+	signed := signApkV1(placeholderApkEntries(), t.Rsa)
+	// DSA is left to the devices
+	for i, entry := range signed {
+		if entry.Name == "META-INF/CERT.RSA" {
+			signed[i].Name = "META-INF/CERT.DSA"
+		}
+	}
+	_, err := t.verify(apkZip(signed))
+	t.Assert(err == models.ErrApkSignatureUnsupported)
+}
+
+func replaceApkEntry(entries []apkEntry, name string, content []byte) []apkEntry {
+	replaced := make([]apkEntry, len(entries))
+	copy(replaced, entries)
+	for i, entry := range replaced {
+		if entry.Name == name {
+			replaced[i].Content = content
+		}
+	}
+	return replaced
+}
+
+func removeApkEntry(entries []apkEntry, name string) []apkEntry {
+	var removed []apkEntry
+	for _, entry := range entries {
+		if entry.Name != name {
+			removed = append(removed, entry)
+		}
+	}
+	return removed
+}
+
+func replaceBytes(b, old, new []byte) []byte {
+	return bytes.Replace(b, old, new, 1)
+}
+
+// signingBlockOffset returns the offset of the APK Signing Block, which ends at the central directory.
+func signingBlockOffset(apk []byte) int {
+	cdOffset := int(binary.LittleEndian.Uint32(apk[len(apk)-22+16:]))
+	return cdOffset - int(binary.LittleEndian.Uint64(apk[cdOffset-24:])) - 8
+}
+
+// setSigningBlockSize sets the size of the APK Signing Block in the footer, or in the header.
+func setSigningBlockSize(apk []byte, footer bool, size uint64) []byte {
+	broken := append([]byte{}, apk...)
+	offset := signingBlockOffset(apk)
+	if footer {
+		offset = int(binary.LittleEndian.Uint32(apk[len(apk)-22+16:])) - 24
+	}
+	binary.LittleEndian.PutUint64(broken[offset:], size)
+	return broken
+}
+
+// setSigningBlockUint sets the 32 bits at the offset in the APK Signing Block, e.g. 8 for the size of the first pair,
+// 20 for the length of the signers in its value and 24 for the length of the first signer.
+func setSigningBlockUint(apk []byte, offset int, v uint32) []byte {
+	broken := append([]byte{}, apk...)
+	binary.LittleEndian.PutUint32(broken[signingBlockOffset(apk)+offset:], v)
+	return broken
+}
@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/kayac/alphawing/app/controllers"
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel/testing"
+)
+
+// AuthLockoutTest checks the lockout of the addresses failing to authenticate, the address told behind the trusted
+// proxies and the lock of the pairings. The failures are purged around each test, as every request of the tests
+// comes from the same address.
+type AuthLockoutTest struct {
+	testing.TestSuite
+	Suffix           string
+	TrustedProxies   []*net.IPNet
+	AuthFailureLimit int
+}
+
+func (t *AuthLockoutTest) Before() {
+	t.Suffix = fmt.Sprintf("%d", time.Now().UnixNano())
+	t.TrustedProxies = controllers.Conf.TrustedProxies
+	t.AuthFailureLimit = controllers.Conf.AuthFailureLimit
+	// the invalid tokens of the other tests are counted too
+	purgeAuthFailures()
+}
+
+func (t *AuthLockoutTest) After() {
+	controllers.Conf.TrustedProxies = t.TrustedProxies
+	controllers.Conf.AuthFailureLimit = t.AuthFailureLimit
+	purgeAuthFailures()
+}
+
+func purgeAuthFailures() {
+	err := controllers.Transact(func(txn gorp.SqlExecutor) error {
+		_, err := models.PurgeAuthFailures(txn, time.Now().Add(time.Hour))
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// failAt records the failures of the subject made at the time.
+func failAt(subject, kind string, count int, at time.Time) {
+	err := controllers.Transact(func(txn gorp.SqlExecutor) error {
+		for i := 0; i < count; i++ {
+			failure := &models.AuthFailure{Subject: subject, Kind: kind}
+			if err := txn.Insert(failure); err != nil {
+				return err
+			}
+			if _, err := txn.Exec("UPDATE auth_failure SET created_at = ? WHERE id = ?", at, failure.Id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (t *AuthLockoutTest) TestAuthLockout() {
+	now := time.Now()
+	for i, c := range []struct {
+		name     string
+		kind     string
+		failures int
+		ago      time.Duration
+		limit    int
+		// the remaining lockout
+		lockout time.Duration
+	}{
+		{"under the limit", models.AuthFailureKindToken, 2, 0, 3, 0},
+		{"at the limit", models.AuthFailureKindToken, 3, 0, 3, time.Minute},
+		{"failed logins", models.AuthFailureKindLogin, 3, 0, 3, time.Minute},
+		{"doubled", models.AuthFailureKindToken, 4, 0, 3, 2 * time.Minute},
+		{"doubled twice", models.AuthFailureKindToken, 5, 0, 3, 4 * time.Minute},
+		{"up to the window", models.AuthFailureKindToken, 10, 0, 3, models.AuthFailureWindow},
+		{"ended", models.AuthFailureKindToken, 3, 2 * time.Minute, 3, 0},
+		{"partly ended", models.AuthFailureKindToken, 5, 3 * time.Minute, 3, time.Minute},
+		{"out of the window", models.AuthFailureKindToken, 10, models.AuthFailureWindow + time.Minute, 3, 0},
+		{"forbidden not counted", models.AuthFailureKindForbidden, 10, 0, 3, 0},
+		{"pairings not counted", models.AuthFailureKindPairing, 10, 0, 3, 0},
+		{"disabled", models.AuthFailureKindToken, 10, 0, 0, 0},
+	} {
+		subject := fmt.Sprintf("addr:lockout-%s-%d", t.Suffix, i)
+		failAt(subject, c.kind, c.failures, now.Add(-c.ago))
+		// the failures of the other addresses are not counted
+		failAt(subject+"-other", models.AuthFailureKindToken, 10, now)
+
+		lockout, err := models.AuthLockout(controllers.Dbm, subject, c.limit, now)
+		t.Assertf(err == nil, "%s: %v", c.name, err)
+		// the times are rounded to seconds by MySQL
+		t.Assertf(lockout > c.lockout-2*time.Second && lockout < c.lockout+2*time.Second, "%s: locked out for %s", c.name, lockout)
+	}
+}
+
+func (t *AuthLockoutTest) TestLockoutAfterInvalidTokens() {
+	controllers.Conf.AuthFailureLimit = 3
+	app := createApp("Auth Lockout Test", models.AppVisibilityUnlisted)
+
+	for i := 0; i < 3; i++ {
+		t.Get("/api/list_bundle?token=invalid")
+		t.AssertStatus(http.StatusUnauthorized)
+	}
+	// the address is locked out even with a valid token, so that the tokens cannot be guessed
+	t.Get("/api/list_bundle?token=" + url.QueryEscape(app.ApiToken))
+	t.AssertStatus(http.StatusTooManyRequests)
+	t.Assert(t.Response.Header.Get("Retry-After") != "")
+}
+
+func (t *AuthLockoutTest) TestClientAddr() {
+	// the other addresses fail only once each
+	controllers.Conf.AuthFailureLimit = 100
+	base, err := url.Parse(t.BaseUrl())
+	if err != nil {
+		panic(err)
+	}
+	local, _, err := net.SplitHostPort(base.Host)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, c := range []struct {
+		name    string
+		proxies []string
+		header  []string
+		addr    string
+	}{
+		{"without proxies", nil, nil, local},
+		{"forwarded by an untrusted client", nil, []string{"203.0.113.5"}, local},
+		{"forwarded by the proxy", []string{local}, []string{"203.0.113.5"}, "203.0.113.5"},
+		{"spoofed on the left", []string{local}, []string{"198.51.100.1, 203.0.113.5"}, "203.0.113.5"},
+		{"through the proxies", []string{local, "10.0.0.0/8"}, []string{"198.51.100.1, 203.0.113.5, 10.0.0.2"}, "203.0.113.5"},
+		{"in the headers", []string{local, "10.0.0.0/8"}, []string{"198.51.100.1", "203.0.113.5, 10.0.0.2"}, "203.0.113.5"},
+		{"empty hops", []string{local}, []string{"203.0.113.5, , "}, "203.0.113.5"},
+		{"only proxies", []string{local, "10.0.0.0/8"}, []string{"10.0.0.1, 10.0.0.2"}, "10.0.0.1"},
+		{"without the header", []string{local}, nil, local},
+	} {
+		controllers.Conf.TrustedProxies = nil
+		for _, proxy := range c.proxies {
+			controllers.Conf.TrustedProxies = append(controllers.Conf.TrustedProxies, proxyNetwork(proxy))
+		}
+
+		req, err := http.NewRequest("GET", t.BaseUrl()+"/api/list_bundle?token=invalid", nil)
+		if err != nil {
+			panic(err)
+		}
+		for _, header := range c.header {
+			req.Header.Add("X-Forwarded-For", header)
+		}
+		t.MakeRequest(req)
+		t.AssertStatus(http.StatusUnauthorized)
+
+		var failure models.AuthFailure
+		err = controllers.Dbm.SelectOne(&failure, "SELECT * FROM auth_failure ORDER BY id DESC LIMIT 1")
+		t.Assertf(err == nil && failure.Subject == "addr:"+c.addr, "%s: %s", c.name, failure.Subject)
+	}
+}
+
+func (t *AuthLockoutTest) TestPairingLock() {
+	subject := "addr:pairing-" + t.Suffix
+	for i := 0; i < models.PairingFailureLimit; i++ {
+		locked, err := models.IsPairingLocked(controllers.Dbm, subject, time.Now())
+		t.Assert(err == nil && !locked)
+		err = controllers.Transact(func(txn gorp.SqlExecutor) error {
+			_, err := models.RecordPairingFailure(txn, subject)
+			return err
+		})
+		t.Assert(err == nil)
+	}
+	locked, err := models.IsPairingLocked(controllers.Dbm, subject, time.Now())
+	t.Assert(err == nil && locked)
+
+	// the lock ends with the window
+	locked, err = models.IsPairingLocked(controllers.Dbm, subject, time.Now().Add(models.AuthFailureWindow+time.Second))
+	t.Assert(err == nil && !locked)
+
+	// the other addresses can pair until the codes tried by every address reach the limit
+	other := subject + "-other"
+	locked, err = models.IsPairingLocked(controllers.Dbm, other, time.Now())
+	t.Assert(err == nil && !locked)
+	failAt("pairing", models.AuthFailureKindPairing, models.PairingGlobalFailureLimit, time.Now())
+	locked, err = models.IsPairingLocked(controllers.Dbm, other, time.Now())
+	t.Assert(err == nil && locked)
+}
+
+// proxyNetwork returns the network of the CIDR, or of the address alone.
+func proxyNetwork(proxy string) *net.IPNet {
+	if _, network, err := net.ParseCIDR(proxy); err == nil {
+		return network
+	}
+	ip := net.ParseIP(proxy)
+	if ip == nil {
+		panic("invalid proxy: " + proxy)
+	}
+	bits := 8 * len(ip.To16())
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
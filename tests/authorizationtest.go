@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kayac/alphawing/app/controllers"
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel/testing"
+)
+
+// AuthorizationTest checks that the listings of the device API and the queries behind them return only the apps
+// and the bundles the caller is authorized for.
+type AuthorizationTest struct {
+	testing.TestSuite
+	// the apps of each test have the suffix in the title, as the apps of the other tests remain in the database
+	Suffix   string
+	Token    string
+	Member   *models.App
+	Listed   *models.App
+	Unlisted *models.App
+	Private  *models.App
+}
+
+func (t *AuthorizationTest) Before() {
+	t.Suffix = fmt.Sprintf("%d", time.Now().UnixNano())
+	user := createUser(fmt.Sprintf("authorization-%s@example.com", t.Suffix))
+	t.Token = pairDevice(user)
+
+	t.Member = createApp("Authorization Member "+t.Suffix, models.AppVisibilityUnlisted)
+	t.Listed = createApp("Authorization Listed "+t.Suffix, models.AppVisibilityListed)
+	t.Unlisted = createApp("Authorization Unlisted "+t.Suffix, models.AppVisibilityUnlisted)
+	t.Private = createApp("Authorization Private "+t.Suffix, models.AppVisibilityPrivate)
+	for _, app := range []*models.App{t.Member, t.Listed, t.Unlisted, t.Private} {
+		createBundle(app, "1.0.0")
+	}
+	createAuthority(t.Member, user.Email, models.AuthorityRoleTester)
+}
+
+func (t *AuthorizationTest) catalog(query string) map[int]*controllers.DeviceAppJsonResponse {
+	t.Get("/api/device/catalog?" + url.Values{"device_token": {t.Token}, "q": {query}}.Encode())
+	t.AssertOk()
+
+	var res controllers.JsonResponseDeviceCatalog
+	t.Assert(json.Unmarshal(t.ResponseBody, &res) == nil)
+	apps := map[int]*controllers.DeviceAppJsonResponse{}
+	for _, category := range res.Content {
+		for _, app := range category.Apps {
+			apps[app.Id] = app
+		}
+	}
+	return apps
+}
+
+func (t *AuthorizationTest) TestCatalogListsOnlyAuthorizedBundles() {
+	apps := t.catalog("")
+
+	member, ok := apps[t.Member.Id]
+	t.Assert(ok)
+	t.Assert(member.HasAuthority)
+	t.Assert(member.LatestIpa != nil)
+
+	listed, ok := apps[t.Listed.Id]
+	t.Assert(ok)
+	t.Assert(!listed.HasAuthority)
+	t.Assert(listed.LatestIpa == nil)
+
+	_, ok = apps[t.Unlisted.Id]
+	t.Assert(!ok)
+	_, ok = apps[t.Private.Id]
+	t.Assert(!ok)
+}
+
+func (t *AuthorizationTest) TestCatalogSearch() {
+	apps := t.catalog("Listed " + t.Suffix)
+	_, ok := apps[t.Listed.Id]
+	t.Assert(ok)
+	_, ok = apps[t.Member.Id]
+	t.Assert(!ok)
+
+	// the search is scoped to the apps the user can see
+	apps = t.catalog("Private " + t.Suffix)
+	t.AssertEqual(0, len(apps))
+
+	// the wildcards of LIKE are searched for as they are
+	for _, query := range []string{"%", "_", "!%", `\%`} {
+		apps = t.catalog(query)
+		_, ok = apps[t.Member.Id]
+		t.Assert(!ok)
+	}
+}
+
+func (t *AuthorizationTest) TestLatestWithoutAuthority() {
+	t.Get(fmt.Sprintf("/api/device/app/%d/latest?", t.Listed.Id) + url.Values{"device_token": {t.Token}}.Encode())
+	t.AssertOk()
+	var res controllers.JsonResponseDeviceApp
+	t.Assert(json.Unmarshal(t.ResponseBody, &res) == nil)
+	t.Assert(res.Content.LatestIpa == nil)
+
+	// the bundles of a variant are not loaded without authority either
+	t.Get(fmt.Sprintf("/api/device/app/%d/latest?", t.Listed.Id) + url.Values{"device_token": {t.Token}, "variant": {"free"}}.Encode())
+	t.AssertOk()
+	res = controllers.JsonResponseDeviceApp{}
+	t.Assert(json.Unmarshal(t.ResponseBody, &res) == nil)
+	t.Assert(res.Content.LatestIpa == nil)
+
+	for _, app := range []*models.App{t.Unlisted, t.Private} {
+		t.Get(fmt.Sprintf("/api/device/app/%d/latest?", app.Id) + url.Values{"device_token": {t.Token}}.Encode())
+		t.AssertNotFound()
+		t.Get(fmt.Sprintf("/api/device/app/%d/verify?", app.Id) + url.Values{"device_token": {t.Token}, "digest": {strings.Repeat("0", 64)}}.Encode())
+		t.AssertNotFound()
+	}
+}
+
+func (t *AuthorizationTest) TestListedAppsExcludeAccessibleOnes() {
+	apps, err := models.SearchListedApps(controllers.Dbm, []string{t.Listed.FileId}, t.Suffix)
+	t.Assert(err == nil)
+	t.AssertEqual(0, len(apps))
+
+	apps, err = models.SearchListedApps(controllers.Dbm, nil, t.Suffix)
+	t.Assert(err == nil)
+	t.AssertEqual(1, len(apps))
+	t.AssertEqual(t.Listed.Id, apps[0].Id)
+}
+
+func (t *AuthorizationTest) TestMirroredAppsExcludePrivateOnes() {
+	apps, err := models.GetMirroredApps(controllers.Dbm)
+	t.Assert(err == nil)
+	for _, app := range apps {
+		t.Assert(!app.IsPrivate())
+	}
+}
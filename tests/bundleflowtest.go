@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/kayac/alphawing/app/controllers"
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel/testing"
+)
+
+// BundleFlowTest follows a bundle from the upload through the download to the deletion over HTTP.
+type BundleFlowTest struct {
+	testing.TestSuite
+	App *models.App
+}
+
+func (t *BundleFlowTest) Before() {
+	t.App = createApp("Bundle Flow Test", models.AppVisibilityUnlisted)
+}
+
+func (t *BundleFlowTest) TestUploadDownloadDelete() {
+	content := models.PlaceholderIpa("com.example.alphawing", "1.0.0")
+
+	// upload
+	body, contentType := multipartBody(map[string]string{"token": t.App.ApiToken, "description": "flow"}, "file", "test.ipa", content)
+	t.Post("/api/upload_bundle", contentType, body)
+	t.AssertOk()
+
+	var uploaded controllers.JsonResponseUploadBundle
+	t.Assert(json.Unmarshal(t.ResponseBody, &uploaded) == nil)
+	fileId := uploaded.Content.FileId
+	t.AssertEqual("1.0.0", uploaded.Content.Version)
+	t.AssertEqual(1, uploaded.Content.Revision)
+
+	_, stored, found := controllers.Conf.FakeDrive.File(fileId)
+	t.Assert(found)
+	t.AssertEqual(string(content), string(stored))
+
+	bundle, err := t.App.GetBundleByFileId(controllers.Dbm, fileId)
+	t.Assert(err == nil)
+
+	// download with the URL signed as the install page does
+	t.Get(t.signedPath(fmt.Sprintf("/bundle/%d/download_ipa", bundle.Id)))
+	t.AssertOk()
+	t.AssertEqual(string(content), string(t.ResponseBody))
+	t.AssertHeader(controllers.VersionHeader, "1.0.0")
+	t.AssertHeader(controllers.RevisionHeader, "1")
+	t.AssertHeader(controllers.ChecksumHeader, bundle.Digest)
+
+	// delete
+	t.PostForm("/api/delete_bundle", url.Values{"token": {t.App.ApiToken}, "file_id": {fileId}})
+	t.AssertOk()
+
+	_, err = t.App.GetBundleByFileId(controllers.Dbm, fileId)
+	t.Assert(err != nil)
+
+	file, _, found := controllers.Conf.FakeDrive.File(fileId)
+	t.Assert(!found || file.Labels.Trashed)
+}
+
+func (t *BundleFlowTest) TestUploadSameContentTwice() {
+	content := models.PlaceholderIpa("com.example.alphawing", "1.0.1")
+
+	var fileIds []string
+	for i := 0; i < 2; i++ {
+		body, contentType := multipartBody(map[string]string{"token": t.App.ApiToken}, "file", "test.ipa", content)
+		t.Post("/api/upload_bundle", contentType, body)
+		t.AssertOk()
+
+		var uploaded controllers.JsonResponseUploadBundle
+		t.Assert(json.Unmarshal(t.ResponseBody, &uploaded) == nil)
+		t.AssertEqual(i+1, uploaded.Content.Revision)
+		fileIds = append(fileIds, uploaded.Content.FileId)
+	}
+
+	// the content is stored once and shared by the revisions
+	t.AssertEqual(fileIds[0], fileIds[1])
+}
+
+func (t *BundleFlowTest) TestStreamUpload() {
+	content := models.PlaceholderIpa("com.example.alphawing", "1.0.5")
+
+	var fileIds []string
+	for i := 0; i < 2; i++ {
+		query := url.Values{"token": {t.App.ApiToken}, "filename": {"test.ipa"}}
+		req, err := http.NewRequest("PUT", t.BaseUrl()+"/api/upload_bundle?"+query.Encode(), bytes.NewReader(content))
+		t.Assert(err == nil)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		t.MakeRequest(req)
+		t.AssertOk()
+
+		var uploaded controllers.JsonResponseUploadBundle
+		t.Assert(json.Unmarshal(t.ResponseBody, &uploaded) == nil)
+		t.AssertEqual("1.0.5", uploaded.Content.Version)
+		t.AssertEqual(i+1, uploaded.Content.Revision)
+		fileIds = append(fileIds, uploaded.Content.FileId)
+	}
+
+	_, stored, found := controllers.Conf.FakeDrive.File(fileIds[0])
+	t.Assert(found)
+	t.AssertEqual(string(content), string(stored))
+	// the second upload is stored, then deleted for the first one with the same digest
+	t.AssertEqual(fileIds[0], fileIds[1])
+}
+
+func (t *BundleFlowTest) TestUploadWithInvalidToken() {
+	body, contentType := multipartBody(map[string]string{"token": "invalid"}, "file", "test.ipa", models.PlaceholderIpa("com.example.alphawing", "1.0.0"))
+	t.Post("/api/upload_bundle", contentType, body)
+	t.AssertStatus(401)
+}
+
+func (t *BundleFlowTest) TestDownloadWithoutSignature() {
+	bundle := createBundle(t.App, "1.0.2")
+
+	t.Get(fmt.Sprintf("/bundle/%d/download_ipa", bundle.Id))
+	t.AssertNotFound()
+}
+
+func (t *BundleFlowTest) TestListBundle() {
+	createBundle(t.App, "1.0.3")
+	createBundle(t.App, "1.0.4")
+
+	t.Get("/api/list_bundle?token=" + url.QueryEscape(t.App.ApiToken))
+	t.AssertOk()
+
+	var listed controllers.JsonResponseListBundle
+	t.Assert(json.Unmarshal(t.ResponseBody, &listed) == nil)
+	t.AssertEqual(2, len(listed.Content.Bundles))
+}
+
+func (t *BundleFlowTest) signedPath(path string) string {
+	base, err := url.Parse(t.BaseUrl())
+	if err != nil {
+		panic(err)
+	}
+
+	signatureInfo := models.NewLimitedTimeSignatureInfo(base.Host, path)
+	signatureInfo.RefreshSignature(controllers.Conf.Secret)
+	return path + "?" + signatureInfo.UrlValues().Encode()
+}
@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"github.com/kayac/alphawing/app/controllers"
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+	"github.com/revel/revel/testing"
+)
+
+// EventTest checks that the cursor of the events does not pass the events of the transactions committed late.
+type EventTest struct {
+	testing.TestSuite
+}
+
+// lastEventCursor sequences the events recorded by the other tests and returns the cursor after them.
+func lastEventCursor() int {
+	if _, err := models.GetEventsSince(controllers.Dbm, 0, 1); err != nil {
+		panic(err)
+	}
+	cursor, err := controllers.Dbm.SelectInt("SELECT IFNULL(MAX(seq), 0) FROM event")
+	if err != nil {
+		panic(err)
+	}
+	return int(cursor)
+}
+
+func (t *EventTest) TestCommittedLate() {
+	cursor := lastEventCursor()
+
+	late, err := controllers.Dbm.Begin()
+	if err != nil {
+		panic(err)
+	}
+	if err := models.RecordEvent(late, models.EventResourceApp, 1, 1, models.EventActionUpdate); err != nil {
+		late.Rollback()
+		panic(err)
+	}
+	// recorded after the late one, but committed first
+	err = controllers.Transact(func(txn gorp.SqlExecutor) error {
+		return models.RecordEvent(txn, models.EventResourceApp, 2, 2, models.EventActionUpdate)
+	})
+	if err != nil {
+		late.Rollback()
+		panic(err)
+	}
+
+	events, err := models.GetEventsSince(controllers.Dbm, cursor, 100)
+	t.Assert(err == nil)
+	t.AssertEqual(1, len(events))
+	t.AssertEqual(2, events[0].ResourceId)
+
+	t.Assert(late.Commit() == nil)
+	later, err := models.GetEventsSince(controllers.Dbm, events[0].Seq, 100)
+	t.Assert(err == nil)
+	t.AssertEqual(1, len(later))
+	t.AssertEqual(1, later[0].ResourceId)
+	t.Assert(later[0].Id < events[0].Id)
+
+	// the cursor stays after the events followed
+	none, err := models.GetEventsSince(controllers.Dbm, later[0].Seq, 100)
+	t.Assert(err == nil)
+	t.AssertEqual(0, len(none))
+}
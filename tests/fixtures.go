@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"bytes"
+	"mime/multipart"
+	"os"
+
+	"github.com/kayac/alphawing/app/controllers"
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/coopernurse/gorp"
+)
+
+// the fixtures are made through the models against the fake storage, so run the tests with storage.fake = true
+
+func createUser(email string) *models.User {
+	var user *models.User
+	err := controllers.Transact(func(txn gorp.SqlExecutor) error {
+		u, err := models.FindOrCreateUser(txn, email)
+		user = u
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+	return user
+}
+
+func createApp(title string, visibility models.AppVisibility) *models.App {
+	s, err := controllers.NewServiceAccountGoogleService()
+	if err != nil {
+		panic(err)
+	}
+
+	app := &models.App{
+		Title:      title,
+		Visibility: visibility,
+	}
+	err = controllers.Transact(func(txn gorp.SqlExecutor) error {
+		return models.CreateApp(txn, s, app, "")
+	})
+	if err != nil {
+		panic(err)
+	}
+	return app
+}
+
+func createBundle(app *models.App, version string) *models.Bundle {
+	s, err := controllers.NewServiceAccountGoogleService()
+	if err != nil {
+		panic(err)
+	}
+
+	file, err := models.CreatePlaceholderIpaFile("com.example.alphawing", version)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	bundle := &models.Bundle{
+		PlatformType: models.BundlePlatformTypeIOS,
+		File:         file,
+	}
+	if err := app.CreateBundle(controllers.Dbm, models.NewDriveStorage(s), bundle); err != nil {
+		panic(err)
+	}
+	return bundle
+}
+
+// multipartBody returns the body of a form with the file, and its content type.
+func multipartBody(params map[string]string, fieldName, filename string, content []byte) (*bytes.Buffer, string) {
+	return multipartFilesBody(params, fieldName, map[string][]byte{filename: content})
+}
+
+// multipartFilesBody returns the body of a form with the files in the same field, and its content type.
+func multipartFilesBody(params map[string]string, fieldName string, files map[string][]byte) (*bytes.Buffer, string) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for key, value := range params {
+		if err := w.WriteField(key, value); err != nil {
+			panic(err)
+		}
+	}
+	for filename, content := range files {
+		part, err := w.CreateFormFile(fieldName, filename)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := part.Write(content); err != nil {
+			panic(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return &buf, w.FormDataContentType()
+}
+
+func createAuthority(app *models.App, email string, role models.AuthorityRole) *models.Authority {
+	s, err := controllers.NewServiceAccountGoogleService()
+	if err != nil {
+		panic(err)
+	}
+
+	authority := &models.Authority{
+		Email: email,
+		Role:  role,
+	}
+	err = controllers.Transact(func(txn gorp.SqlExecutor) error {
+		return app.CreateAuthority(txn, s, authority)
+	})
+	if err != nil {
+		panic(err)
+	}
+	return authority
+}
+
+// pairDevice pairs a device of the user and returns its device token.
+func pairDevice(user *models.User) string {
+	var token string
+	err := controllers.Transact(func(txn gorp.SqlExecutor) error {
+		device, err := models.StartPairing(txn, user.Id)
+		if err != nil {
+			return err
+		}
+		_, token, err = models.CompletePairing(txn, device.PairingCode, "Test Device")
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kayac/alphawing/app/controllers"
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel/testing"
+)
+
+// ManifestTest uploads the bundles of several apps with a manifest.
+type ManifestTest struct {
+	testing.TestSuite
+	Apps []*models.App
+}
+
+func (t *ManifestTest) Before() {
+	t.Apps = []*models.App{
+		createApp("Manifest Test A", models.AppVisibilityUnlisted),
+		createApp("Manifest Test B", models.AppVisibilityUnlisted),
+	}
+}
+
+func (t *ManifestTest) manifest(files ...string) string {
+	m := &models.UploadManifest{}
+	for i, file := range files {
+		m.Artifacts = append(m.Artifacts, &models.ManifestArtifact{Token: t.Apps[i].ApiToken, File: file, Variant: fmt.Sprintf("v%d", i)})
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+func (t *ManifestTest) TestUploadManifest() {
+	files := map[string][]byte{
+		"a.ipa": models.PlaceholderIpa("com.example.alphawing.a", "2.0.0"),
+		"b.ipa": models.PlaceholderIpa("com.example.alphawing.b", "3.0.0"),
+	}
+	body, contentType := multipartFilesBody(map[string]string{"manifest": t.manifest("a.ipa", "b.ipa")}, "files", files)
+	t.Post("/api/upload_manifest", contentType, body)
+	t.AssertOk()
+
+	var uploaded controllers.JsonResponseUploadManifest
+	t.Assert(json.Unmarshal(t.ResponseBody, &uploaded) == nil)
+	t.AssertEqual(2, len(uploaded.Content))
+	for i, content := range uploaded.Content {
+		t.AssertEqual(t.Apps[i].Id, content.AppId)
+		t.AssertEqual(fmt.Sprintf("v%d", i), content.Variant)
+	}
+	t.AssertEqual("2.0.0", uploaded.Content[0].Version)
+	t.AssertEqual("3.0.0", uploaded.Content[1].Version)
+}
+
+func (t *ManifestTest) TestUploadManifestCreatesNoneOnFailure() {
+	files := map[string][]byte{
+		"a.ipa": models.PlaceholderIpa("com.example.alphawing.a", "2.0.1"),
+		"b.ipa": []byte("not a zip"),
+	}
+	body, contentType := multipartFilesBody(map[string]string{"manifest": t.manifest("a.ipa", "b.ipa")}, "files", files)
+	t.Post("/api/upload_manifest", contentType, body)
+	t.AssertStatus(400)
+
+	for _, app := range t.Apps {
+		bundles, err := app.Bundles(controllers.Dbm)
+		t.Assert(err == nil)
+		t.AssertEqual(0, len(bundles))
+	}
+}
+
+func (t *ManifestTest) TestUploadManifestWithoutFile() {
+	body, contentType := multipartFilesBody(map[string]string{"manifest": t.manifest("a.ipa")}, "files", nil)
+	t.Post("/api/upload_manifest", contentType, body)
+	t.AssertStatus(400)
+}
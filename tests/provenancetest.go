@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel/testing"
+)
+
+// ProvenanceTest verifies the DSSE envelopes of the in-toto statements signed as the SLSA builders sign them.
+type ProvenanceTest struct {
+	testing.TestSuite
+	Ed25519, Ecdsa, Rsa, Stranger crypto.Signer
+	Digest                        string
+}
+
+func (t *ProvenanceTest) Before() {
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	stranger, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	t.Ed25519, t.Ecdsa, t.Rsa, t.Stranger = ed25519Key, ecdsaKey, rsaKey, stranger
+
+	sum := sha256.Sum256(models.PlaceholderIpa("com.example.alphawing", "1.0.0"))
+	t.Digest = hex.EncodeToString(sum[:])
+}
+
+// statement returns the SLSA provenance of the files with the digests.
+func (t *ProvenanceTest) statement(digests ...string) []byte {
+	var subjects []map[string]interface{}
+	for i, digest := range digests {
+		subjects = append(subjects, map[string]interface{}{
+			"name":   fmt.Sprintf("app-%d.ipa", i),
+			"digest": map[string]string{"sha256": digest},
+		})
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"_type":         "https://in-toto.io/Statement/v1",
+		"subject":       subjects,
+		"predicateType": "https://slsa.dev/provenance/v1",
+		"predicate": map[string]interface{}{
+			"buildDefinition": map[string]interface{}{"buildType": "https://example.com/build/v1"},
+			"runDetails":      map[string]interface{}{"builder": map[string]string{"id": "https://example.com/builder"}},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// dsseSignature signs the pre-authentication encoding of the payload, with PSS instead of PKCS #1 v1.5 for RSA if pss.
+func dsseSignature(key crypto.Signer, payloadType string, payload []byte, pss bool) string {
+	pae := append([]byte(fmt.Sprintf("DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))), payload...)
+	var opts crypto.SignerOpts = crypto.Hash(0)
+	message := pae
+	if _, ok := key.(ed25519.PrivateKey); !ok {
+		sum := sha256.Sum256(pae)
+		message = sum[:]
+		opts = crypto.SHA256
+		if pss {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		}
+	}
+	sig, err := key.Sign(rand.Reader, message, opts)
+	if err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func dsseEnvelope(payloadType, payload string, sigs ...string) []byte {
+	var signatures []map[string]string
+	for i, sig := range sigs {
+		signatures = append(signatures, map[string]string{"keyid": fmt.Sprintf("key-%d", i), "sig": sig})
+	}
+	b, err := json.Marshal(map[string]interface{}{"payloadType": payloadType, "payload": payload, "signatures": signatures})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// signedEnvelope returns the envelope of the payload signed by the keys.
+func signedEnvelope(payload []byte, keys ...crypto.Signer) []byte {
+	var sigs []string
+	for _, key := range keys {
+		sigs = append(sigs, dsseSignature(key, models.InTotoPayloadType, payload, false))
+	}
+	return dsseEnvelope(models.InTotoPayloadType, base64.StdEncoding.EncodeToString(payload), sigs...)
+}
+
+func publicKeysPem(keys ...crypto.Signer) []byte {
+	var data []byte
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			panic(err)
+		}
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	return data
+}
+
+func (t *ProvenanceTest) TestParsePublicKeys() {
+	keys, err := models.ParsePublicKeys(publicKeysPem(t.Ed25519, t.Ecdsa, t.Rsa))
+	t.Assert(err == nil)
+	t.AssertEqual(3, len(keys))
+
+	// the text around the blocks is skipped as openssl leaves it
+	keys, err = models.ParsePublicKeys(append([]byte("the key of the builder\n"), publicKeysPem(t.Ecdsa)...))
+	t.Assert(err == nil)
+	t.AssertEqual(1, len(keys))
+
+	_, err = models.ParsePublicKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("broken")}))
+	t.Assert(err != nil)
+}
+
+func (t *ProvenanceTest) TestVerifyProvenance() {
+	keys, err := models.ParsePublicKeys(publicKeysPem(t.Ed25519, t.Ecdsa, t.Rsa))
+	if err != nil {
+		panic(err)
+	}
+	statement := t.statement(t.Digest)
+	payload := base64.StdEncoding.EncodeToString(statement)
+	other := hex.EncodeToString(make([]byte, sha256.Size))
+
+	for _, c := range []struct {
+		name     string
+		envelope []byte
+		verified bool
+	}{
+		{"Ed25519", signedEnvelope(statement, t.Ed25519), true},
+		{"ECDSA", signedEnvelope(statement, t.Ecdsa), true},
+		{"RSA PKCS #1 v1.5", signedEnvelope(statement, t.Rsa), true},
+		{"RSA PSS", dsseEnvelope(models.InTotoPayloadType, payload, dsseSignature(t.Rsa, models.InTotoPayloadType, statement, true)), true},
+		{"signed by a configured key after another", signedEnvelope(statement, t.Stranger, t.Ecdsa), true},
+		{"one of the subjects", signedEnvelope(t.statement(other, t.Digest), t.Ed25519), true},
+		{"not JSON", []byte("not json"), false},
+		{"not in-toto", dsseEnvelope("application/json", payload, dsseSignature(t.Ecdsa, "application/json", statement, false)), false},
+		{"payload not base64", dsseEnvelope(models.InTotoPayloadType, "not base64!", dsseSignature(t.Ecdsa, models.InTotoPayloadType, statement, false)), false},
+		{"signature not base64", dsseEnvelope(models.InTotoPayloadType, payload, "not base64!"), false},
+		{"not signed", dsseEnvelope(models.InTotoPayloadType, payload), false},
+		{"signed by another key", signedEnvelope(statement, t.Stranger), false},
+		// the payload type is signed too, so the signature of another type does not verify the statement
+		{"signed as another type", dsseEnvelope(models.InTotoPayloadType, payload, dsseSignature(t.Ecdsa, "application/json", statement, false)), false},
+		{"payload changed", dsseEnvelope(models.InTotoPayloadType, base64.StdEncoding.EncodeToString(t.statement(other)), dsseSignature(t.Ecdsa, models.InTotoPayloadType, statement, false)), false},
+		{"subject of another file", signedEnvelope(t.statement(other), t.Ed25519), false},
+		{"payload not a statement", signedEnvelope([]byte("not a statement"), t.Ed25519), false},
+	} {
+		err := models.VerifyProvenance(c.envelope, t.Digest, keys)
+		if c.verified {
+			t.Assertf(err == nil, "%s: %v", c.name, err)
+			continue
+		}
+		_, ok := err.(*models.ProvenanceVerifyError)
+		t.Assertf(ok, "%s: %v", c.name, err)
+	}
+}
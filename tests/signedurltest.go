@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kayac/alphawing/app/controllers"
+	"github.com/kayac/alphawing/app/models"
+
+	"github.com/revel/revel/testing"
+)
+
+// SignedUrlTest checks the URLs signed for the downloads and the share links, which are the only access to a bundle
+// without a login.
+type SignedUrlTest struct {
+	testing.TestSuite
+	App    *models.App
+	Bundle *models.Bundle
+}
+
+func (t *SignedUrlTest) Before() {
+	t.App = createApp("Signed URL Test", models.AppVisibilityUnlisted)
+	t.Bundle = createBundle(t.App, "1.0.0")
+}
+
+// signed returns the signature of the path, changed by the function after it is signed.
+func signed(host, path string, expire time.Duration, change func(*models.LimitedTimeSignatureInfo)) *models.LimitedTimeSignatureInfo {
+	signatureInfo := models.NewLimitedTimeSignatureInfoFor(host, path, expire)
+	signatureInfo.RefreshSignature(controllers.Conf.Secret)
+	if change != nil {
+		change(signatureInfo)
+	}
+	return signatureInfo
+}
+
+func (t *SignedUrlTest) TestSignature() {
+	const host, path = "alphawing.example.com", "/bundle/1/download_ipa"
+	for _, c := range []struct {
+		name   string
+		info   *models.LimitedTimeSignatureInfo
+		key    string
+		valid  bool
+		broken bool
+	}{
+		{"signed", signed(host, path, models.SignatureExpireDuration, nil), controllers.Conf.Secret, true, false},
+		{"share link", signed(host, path, 72*time.Hour, nil), controllers.Conf.Secret, true, false},
+		{"another key", signed(host, path, models.SignatureExpireDuration, nil), controllers.Conf.Secret + "x", false, false},
+		{"expired", signed(host, path, -time.Second, nil), controllers.Conf.Secret, false, false},
+		{"another host", signed(host, path, models.SignatureExpireDuration, func(s *models.LimitedTimeSignatureInfo) {
+			s.ParamToSign.Host = "evil.example.com"
+		}), controllers.Conf.Secret, false, false},
+		{"another path", signed(host, path, models.SignatureExpireDuration, func(s *models.LimitedTimeSignatureInfo) {
+			s.ParamToSign.Path = "/bundle/2/download_ipa"
+		}), controllers.Conf.Secret, false, false},
+		{"another method", signed(host, path, models.SignatureExpireDuration, func(s *models.LimitedTimeSignatureInfo) {
+			s.ParamToSign.Method = "POST"
+		}), controllers.Conf.Secret, false, false},
+		{"another token", signed(host, path, models.SignatureExpireDuration, func(s *models.LimitedTimeSignatureInfo) {
+			s.ParamToSign.Token += "x"
+		}), controllers.Conf.Secret, false, false},
+		{"limit extended", signed(host, path, models.SignatureExpireDuration, func(s *models.LimitedTimeSignatureInfo) {
+			s.ParamToSign.Limit = strconv.FormatInt(time.Now().Add(365*24*time.Hour).Unix(), 10)
+		}), controllers.Conf.Secret, false, false},
+		{"device signed in", signed(host, path, models.SignatureExpireDuration, func(s *models.LimitedTimeSignatureInfo) {
+			s.ParamToSign.Token = "device-1-" + s.ParamToSign.Token
+		}), controllers.Conf.Secret, false, false},
+		{"signature truncated", signed(host, path, models.SignatureExpireDuration, func(s *models.LimitedTimeSignatureInfo) {
+			s.Signature = s.Signature[:len(s.Signature)-2]
+		}), controllers.Conf.Secret, false, false},
+		{"signature not hex", signed(host, path, models.SignatureExpireDuration, func(s *models.LimitedTimeSignatureInfo) {
+			s.Signature = "not hex"
+		}), controllers.Conf.Secret, false, true},
+		{"limit not a number", signed(host, path, models.SignatureExpireDuration, func(s *models.LimitedTimeSignatureInfo) {
+			s.ParamToSign.Limit = "never"
+		}), controllers.Conf.Secret, false, true},
+	} {
+		valid, err := c.info.IsValid(c.key)
+		t.Assertf(valid == c.valid, "%s: valid is %v", c.name, valid)
+		t.Assertf((err != nil) == c.broken, "%s: %v", c.name, err)
+	}
+}
+
+func (t *SignedUrlTest) TestShareLinkLimit() {
+	signatureInfo := models.NewLimitedTimeSignatureInfoFor("alphawing.example.com", "/bundle/1/download_ipa", 72*time.Hour)
+	limit, err := strconv.ParseInt(signatureInfo.ParamToSign.Limit, 10, 64)
+	t.Assert(err == nil)
+	t.Assert(limit > time.Now().Add(71*time.Hour).Unix() && limit <= time.Now().Add(72*time.Hour).Unix())
+}
+
+func (t *SignedUrlTest) TestDeviceId() {
+	signatureInfo := models.NewDeviceLimitedTimeSignatureInfo("alphawing.example.com", "/bundle/1/download_ipa", 42)
+	t.AssertEqual(42, signatureInfo.ParamToSign.DeviceId())
+	for _, token := range []string{"", "b6a1d2e0-0000-4000-8000-000000000000", "device-", "device-x-b6a1", "evil-device-1-b6a1"} {
+		t.AssertEqual(0, (&models.ParamToSign{Token: token}).DeviceId())
+	}
+}
+
+func (t *SignedUrlTest) TestDownloadWithSignature() {
+	base, err := url.Parse(t.BaseUrl())
+	if err != nil {
+		panic(err)
+	}
+	path := fmt.Sprintf("/bundle/%d/download_ipa", t.Bundle.Id)
+	other := fmt.Sprintf("/bundle/%d/download_ipa", createBundle(t.App, "1.0.1").Id)
+	for _, c := range []struct {
+		name string
+		path string
+		info *models.LimitedTimeSignatureInfo
+		ok   bool
+	}{
+		{"signed", path, signed(base.Host, path, models.SignatureExpireDuration, nil), true},
+		{"share link", path, signed(base.Host, path, 72*time.Hour, nil), true},
+		{"expired", path, signed(base.Host, path, -time.Second, nil), false},
+		{"signed for another bundle", other, signed(base.Host, path, models.SignatureExpireDuration, nil), false},
+		{"signed for another host", path, signed("evil.example.com", path, models.SignatureExpireDuration, nil), false},
+		{"limit extended", path, signed(base.Host, path, models.SignatureExpireDuration, func(s *models.LimitedTimeSignatureInfo) {
+			s.ParamToSign.Limit = strconv.FormatInt(time.Now().Add(365*24*time.Hour).Unix(), 10)
+		}), false},
+	} {
+		t.Get(c.path + "?" + c.info.UrlValues().Encode())
+		t.Assertf((t.Response.StatusCode == 200) == c.ok, "%s: %d", c.name, t.Response.StatusCode)
+	}
+}
+
+func (t *SignedUrlTest) TestShareGeneration() {
+	signatureInfo := models.NewShareLimitedTimeSignatureInfo("alphawing.example.com", "/bundle/1/download_ipa", 72*time.Hour, 3)
+	generation, ok := signatureInfo.ParamToSign.ShareGeneration()
+	t.Assert(ok)
+	t.AssertEqual(3, generation)
+	for _, token := range []string{"", "b6a1d2e0-0000-4000-8000-000000000000", "share-", "share-x-b6a1", "device-1-b6a1"} {
+		_, ok := (&models.ParamToSign{Token: token}).ShareGeneration()
+		t.Assertf(!ok, "%s", token)
+	}
+}
+
+func (t *SignedUrlTest) TestRevokeShareLinks() {
+	base, err := url.Parse(t.BaseUrl())
+	if err != nil {
+		panic(err)
+	}
+	path := fmt.Sprintf("/bundle/%d/download_ipa", t.Bundle.Id)
+	shared := models.NewShareLimitedTimeSignatureInfo(base.Host, path, 72*time.Hour, t.Bundle.ShareGeneration)
+	shared.RefreshSignature(controllers.Conf.Secret)
+
+	t.Get(path + "?" + shared.UrlValues().Encode())
+	t.AssertOk()
+
+	err = controllers.Transact(t.Bundle.RevokeShareLinks)
+	t.Assert(err == nil)
+	t.Get(path + "?" + shared.UrlValues().Encode())
+	t.AssertNotFound()
+
+	// the downloads signed for the login users are not share links
+	t.Get(path + "?" + signed(base.Host, path, models.SignatureExpireDuration, nil).UrlValues().Encode())
+	t.AssertOk()
+
+	// nor can the generation be changed after the link is signed
+	forged := models.NewShareLimitedTimeSignatureInfo(base.Host, path, 72*time.Hour, t.Bundle.ShareGeneration-1)
+	forged.RefreshSignature(controllers.Conf.Secret)
+	forged.ParamToSign.Token = strings.Replace(forged.ParamToSign.Token, fmt.Sprintf("share-%d-", t.Bundle.ShareGeneration-1), fmt.Sprintf("share-%d-", t.Bundle.ShareGeneration), 1)
+	t.Get(path + "?" + forged.UrlValues().Encode())
+	t.AssertNotFound()
+}